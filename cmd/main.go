@@ -1,9 +1,14 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
 	"os"
+	"time"
 
-	waf "github.com/SomebodyForSomeone/WAF-lya/internal/WAF"
+	waf "github.com/SomebodyForSomeone/WAF-lya/pkg/waf"
 )
 
 const defaultWAFPort string = ":8000"
@@ -11,6 +16,70 @@ const defaultTargetAddress string = "http://localhost:8081"
 const defaultConfigPath string = "waf_config.json"
 
 func main() {
+	// Офлайн-обучение модели аномалий: train <record.jsonl> <model.json> [zscore|isolation_forest]
+	if len(os.Args) > 1 && os.Args[1] == "train" {
+		if len(os.Args) < 4 {
+			log.Fatalln("Использование: train <record.jsonl> <model.json> [zscore|isolation_forest]")
+		}
+		modelType := "isolation_forest"
+		if len(os.Args) > 4 {
+			modelType = os.Args[4]
+		}
+		if err := waf.TrainAnomalyModel(os.Args[2], modelType, os.Args[3]); err != nil {
+			log.Fatalln("Ошибка обучения модели:", err)
+		}
+		log.Printf("Модель %s сохранена в %s", modelType, os.Args[3])
+		return
+	}
+
+	// Офлайн-прогон записанного трафика: replay [-config файл] [-host имя] [-only-blocked] [-baseline прошлый.jsonl] <access.log|file.har>...
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		replay(os.Args[2:])
+		return
+	}
+
+	// Пакетный анализ захватов: analyze [-config файл] [-host имя] [-json] <file.pcap|file.pcapng|file.har|access.log>...
+	if len(os.Args) > 1 && os.Args[1] == "analyze" {
+		analyze(os.Args[2:])
+		return
+	}
+
+	// Тесты правил: test-rules [-config файл] <tests.json>...
+	if len(os.Args) > 1 && os.Args[1] == "test-rules" {
+		testRules(os.Args[2:])
+		return
+	}
+
+	// Проверка развернутого WAF атаками-зондами: selftest [-admin адрес -api-key ключ] [-enum-path путь] <адрес WAF>
+	if len(os.Args) > 1 && os.Args[1] == "selftest" {
+		selftest(os.Args[2:])
+		return
+	}
+
+	// Нагрузочный прогон цепи с вкладом модулей: bench [-config файл] [-n запросов] [-attack доля] [-json]
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		bench(os.Args[2:])
+		return
+	}
+
+	// Проверка конфигурации и файлов правил: validate [-json] [файл конфигурации]
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		validate(os.Args[2:])
+		return
+	}
+
+	// Подпись наборов правил для rule_updates: sign-bundle -key ключ <bundle.json> | sign-bundle -genkey -key ключ
+	if len(os.Args) > 1 && os.Args[1] == "sign-bundle" {
+		signBundle(os.Args[2:])
+		return
+	}
+
+	// Баны для сетевого фильтра: export-bans -admin адрес [-api-key ключ] [-format nftables|ipset] [-set имя] [-table "inet waf"] [-tenant имя]
+	if len(os.Args) > 1 && os.Args[1] == "export-bans" {
+		exportBans(os.Args[2:])
+		return
+	}
+
 	// Путь к конфигу из аргумента, переменной окружения или по умолчанию
 	configPath := defaultConfigPath
 	if len(os.Args) > 1 {
@@ -36,5 +105,209 @@ func main() {
 		}
 	}
 
-	waf.RunWithConfig(wafPort, targetAddress, configPath)
+	if err := waf.RunWithConfig(wafPort, targetAddress, configPath); err != nil {
+		log.Fatalln("Ошибка запуска WAF:", err)
+	}
+}
+
+func validate(args []string) {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	jsonOut := fs.Bool("json", false, "вывести отчет в JSON")
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		log.Fatalln("Использование: validate [-json] [файл конфигурации]")
+	}
+	configPath := defaultConfigPath
+	if fs.NArg() == 1 {
+		configPath = fs.Arg(0)
+	} else if envPath := os.Getenv("WAF_CONFIG"); envPath != "" {
+		configPath = envPath
+	}
+
+	report := waf.ValidateConfig(configPath)
+	if *jsonOut {
+		json.NewEncoder(os.Stdout).Encode(report)
+	} else {
+		report.WriteText(os.Stdout)
+	}
+	if report.Errors() > 0 {
+		os.Exit(1)
+	}
+}
+
+func signBundle(args []string) {
+	fs := flag.NewFlagSet("sign-bundle", flag.ExitOnError)
+	keyPath := fs.String("key", "", "файл закрытого ключа Ed25519")
+	genKey := fs.Bool("genkey", false, "создать новый ключ в -key и вывести открытый ключ")
+	fs.Parse(args)
+	if *keyPath == "" || *genKey != (fs.NArg() == 0) {
+		log.Fatalln("Использование: sign-bundle -key <ключ> <bundle.json> | sign-bundle -genkey -key <ключ>")
+	}
+	if *genKey {
+		pub, err := waf.GenerateBundleKey(*keyPath)
+		if err != nil {
+			log.Fatalln("Ошибка создания ключа:", err)
+		}
+		log.Printf("Закрытый ключ сохранен в %s, открытый ключ для rule_updates.public_keys: %s", *keyPath, pub)
+		return
+	}
+	sigPath, err := waf.SignRuleBundle(*keyPath, fs.Arg(0))
+	if err != nil {
+		log.Fatalln("Ошибка подписи:", err)
+	}
+	log.Printf("Подпись сохранена в %s", sigPath)
+}
+
+func exportBans(args []string) {
+	fs := flag.NewFlagSet("export-bans", flag.ExitOnError)
+	var opts waf.BanExportOptions
+	adminURL := fs.String("admin", "", "адрес панели администратора")
+	apiKey := fs.String("api-key", "", "API-ключ панели (роль viewer)")
+	fs.StringVar(&opts.Format, "format", waf.BanExportNftables, "nftables или ipset")
+	fs.StringVar(&opts.Set, "set", "", "имя набора адресов (по умолчанию waf_banned, IPv6 — waf_banned6)")
+	fs.StringVar(&opts.Table, "table", "", "семейство и таблица nftables (по умолчанию \"inet waf\")")
+	fs.StringVar(&opts.Tenant, "tenant", "", "арендатор")
+	fs.Parse(args)
+	if *adminURL == "" || fs.NArg() != 0 {
+		log.Fatalln("Использование: export-bans -admin <адрес> [-api-key ключ] [-format nftables|ipset] [-set имя] [-table \"inet waf\"] [-tenant имя]")
+	}
+	if err := waf.FetchBans(*adminURL, *apiKey, opts, os.Stdout); err != nil {
+		log.Fatalln("Ошибка экспорта банов:", err)
+	}
+}
+
+func replay(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "файл конфигурации")
+	var opts waf.ReplayOptions
+	fs.StringVar(&opts.Host, "host", "", "Host для строк журнала доступа")
+	fs.BoolVar(&opts.OnlyBlocked, "only-blocked", false, "выводить только заблокированные запросы")
+	fs.StringVar(&opts.Baseline, "baseline", "", "вывод прошлого прогона для сравнения решений")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		log.Fatalln("Использование: replay [-config файл] [-host имя] [-only-blocked] [-baseline прошлый.jsonl] <access.log|file.har>...")
+	}
+
+	cfg, err := waf.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatalln("Ошибка загрузки конфигурации:", err)
+	}
+	report, err := waf.Replay(cfg, fs.Args(), opts, os.Stdout)
+	if err != nil {
+		log.Fatalln("Ошибка воспроизведения:", err)
+	}
+	summary, _ := json.Marshal(report)
+	log.Printf("Итоги: %s", summary)
+	if report.Changed > 0 {
+		os.Exit(1)
+	}
+}
+
+func analyze(args []string) {
+	fs := flag.NewFlagSet("analyze", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "файл конфигурации")
+	jsonOut := fs.Bool("json", false, "выводить срабатывания по запросам в JSON Lines")
+	opts := waf.ReplayOptions{Detected: true}
+	fs.StringVar(&opts.Host, "host", "", "Host для строк журнала доступа")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		log.Fatalln("Использование: analyze [-config файл] [-host имя] [-json] <file.pcap|file.pcapng|file.har|access.log>...")
+	}
+
+	cfg, err := waf.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatalln("Ошибка загрузки конфигурации:", err)
+	}
+	out := io.Discard
+	if *jsonOut {
+		out = os.Stdout
+	}
+	report, err := waf.Replay(cfg, fs.Args(), opts, out)
+	if err != nil {
+		log.Fatalln("Ошибка анализа:", err)
+	}
+	if *jsonOut {
+		summary, _ := json.Marshal(report)
+		log.Printf("Итоги: %s", summary)
+		return
+	}
+	report.WriteText(os.Stdout)
+}
+
+func testRules(args []string) {
+	fs := flag.NewFlagSet("test-rules", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "файл конфигурации")
+	fs.Parse(args)
+
+	cfg, err := waf.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatalln("Ошибка загрузки конфигурации:", err)
+	}
+	files := fs.Args()
+	if len(files) == 0 && cfg != nil {
+		files = cfg.RuleTests.Files
+	}
+	if len(files) == 0 {
+		log.Fatalln("Использование: test-rules [-config файл] <tests.json>...")
+	}
+	report, err := waf.RunRuleTests(cfg, files)
+	if err != nil {
+		log.Fatalln("Ошибка тестов правил:", err)
+	}
+	report.WriteText(os.Stdout)
+	if len(report.Failures) > 0 {
+		os.Exit(1)
+	}
+}
+
+func selftest(args []string) {
+	fs := flag.NewFlagSet("selftest", flag.ExitOnError)
+	var opts waf.SelfTestOptions
+	fs.StringVar(&opts.Host, "host", "", "заголовок Host зондов")
+	fs.DurationVar(&opts.Timeout, "timeout", 5*time.Second, "таймаут одного запроса")
+	fs.BoolVar(&opts.Insecure, "insecure", false, "не проверять сертификат HTTPS")
+	fs.StringVar(&opts.AdminURL, "admin", "", "адрес панели администратора для снятия бана после зонда")
+	fs.StringVar(&opts.APIKey, "api-key", "", "API-ключ панели (роль operator)")
+	fs.StringVar(&opts.ClientIP, "client-ip", "", "адрес проверяющего, как его видит WAF")
+	fs.StringVar(&opts.EnumerationPath, "enum-path", "", "эндпоинт регистрации для зонда перебора аккаунтов")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		log.Fatalln("Использование: selftest [-host имя] [-admin адрес -api-key ключ] [-enum-path путь] <адрес WAF>")
+	}
+	opts.Target = fs.Arg(0)
+
+	report, err := waf.SelfTest(opts, os.Stdout)
+	if err != nil {
+		log.Fatalln("Ошибка проверки:", err)
+	}
+	report.WriteText(os.Stdout)
+	if report.Missed > 0 || report.Skipped > 0 {
+		os.Exit(1)
+	}
+}
+
+func bench(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "файл конфигурации")
+	jsonOut := fs.Bool("json", false, "вывести итоги в JSON")
+	var opts waf.BenchOptions
+	fs.IntVar(&opts.Requests, "n", 20000, "запросов на каждый этап")
+	fs.Float64Var(&opts.AttackPercent, "attack", 5, "доля запросов с атаками, 0–100")
+	fs.IntVar(&opts.Concurrency, "c", 0, "горутин при замере пропускной способности; 0 — GOMAXPROCS")
+	fs.Int64Var(&opts.Seed, "seed", 1, "seed генератора трафика")
+	fs.Parse(args)
+
+	cfg, err := waf.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatalln("Ошибка загрузки конфигурации:", err)
+	}
+	report, err := waf.Bench(cfg, opts)
+	if err != nil {
+		log.Fatalln("Ошибка нагрузочного прогона:", err)
+	}
+	if *jsonOut {
+		json.NewEncoder(os.Stdout).Encode(report)
+		return
+	}
+	report.WriteText(os.Stdout)
 }
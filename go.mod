@@ -4,4 +4,28 @@ go 1.25.5
 
 require golang.org/x/time v0.14.0
 
-require github.com/corazawaf/libinjection-go v0.3.2 
+require (
+	github.com/andybalholm/brotli v1.2.5
+	github.com/corazawaf/libinjection-go v0.3.2
+	github.com/envoyproxy/go-control-plane/envoy v1.39.0
+	github.com/flier/gohs v1.2.1
+	github.com/quic-go/quic-go v0.61.0
+	github.com/segmentio/kafka-go v0.4.51
+	github.com/tetratelabs/wazero v1.12.0
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20260706201446-f0a921348800
+	google.golang.org/grpc v1.84.0
+)
+
+require (
+	github.com/cncf/xds/go v0.0.0-20260202195803-dba9d589def2 // indirect
+	github.com/envoyproxy/protoc-gen-validate v1.3.3 // indirect
+	github.com/klauspost/compress v1.15.9 // indirect
+	github.com/pierrec/lz4/v4 v4.1.15 // indirect
+	github.com/planetscale/vtprotobuf v0.6.1-0.20240319094008-0393e58bdf10 // indirect
+	github.com/quic-go/qpack v0.6.0 // indirect
+	golang.org/x/crypto v0.54.0 // indirect
+	golang.org/x/net v0.57.0 // indirect
+	golang.org/x/sys v0.47.0 // indirect
+	golang.org/x/text v0.40.0 // indirect
+	google.golang.org/protobuf v1.36.11 // indirect
+)
@@ -0,0 +1,154 @@
+package waf
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// AccountLockoutEvent событие блокировки аккаунта, передаваемое хукам уведомлений
+type AccountLockoutEvent struct {
+	Event       string    `json:"event"` // account_locked
+	Username    string    `json:"username"`
+	IP          string    `json:"ip"` // IP последней неудачной попытки
+	Failures    int       `json:"failures"`
+	LockedUntil time.Time `json:"locked_until"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// accountLockout временно блокирует вход в аккаунт после серии неудачных попыток
+// независимо от IP, с которых они приходят, и уведомляет приложение о блокировке
+type accountLockout struct {
+	waf           *WAF
+	maxFailures   int
+	window        time.Duration
+	duration      time.Duration
+	maxDuration   time.Duration
+	webhookURL    string
+	webhookSecret string
+	hooks         []func(AccountLockoutEvent)
+	client        *http.Client
+}
+
+// newAccountLockout создает блокировку аккаунтов из конфига
+func newAccountLockout(w *WAF, cfg AccountLockoutConfig) *accountLockout {
+	l := &accountLockout{
+		waf:           w,
+		maxFailures:   cfg.MaxFailures,
+		window:        15 * time.Minute,
+		duration:      15 * time.Minute,
+		maxDuration:   24 * time.Hour,
+		webhookURL:    cfg.WebhookURL,
+		webhookSecret: cfg.WebhookSecret,
+		client:        &http.Client{Timeout: 5 * time.Second},
+	}
+	if cfg.WindowSeconds > 0 {
+		l.window = time.Duration(cfg.WindowSeconds) * time.Second
+	}
+	if cfg.LockoutSeconds > 0 {
+		l.duration = time.Duration(cfg.LockoutSeconds) * time.Second
+	}
+	if cfg.MaxLockoutSeconds > 0 {
+		l.maxDuration = time.Duration(cfg.MaxLockoutSeconds) * time.Second
+	}
+	return l
+}
+
+// lockedUntil возвращает время окончания блокировки аккаунта (нулевое, если не заблокирован)
+func (l *accountLockout) lockedUntil(user string) time.Time {
+	st := l.waf.states.Get("login_user:" + user)
+	if st == nil {
+		return time.Time{}
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	until, _ := st.Meta["lockout_until"].(time.Time)
+	if time.Now().After(until) {
+		return time.Time{}
+	}
+	return until
+}
+
+// recordFailure учитывает неудачный вход и блокирует аккаунт при превышении порога.
+// Каждая следующая блокировка вдвое длиннее предыдущей, но не длиннее maxDuration.
+func (l *accountLockout) recordFailure(ip, user, requestID string) {
+	st := l.waf.states.Get("login_user:" + user)
+	if st == nil {
+		return
+	}
+	failures := slidingCount(st, "lockout_failures", l.window, true)
+	if failures < l.maxFailures {
+		return
+	}
+
+	st.mu.Lock()
+	count, _ := st.Meta["lockout_count"].(int)
+	duration := l.duration
+	for i := 0; i < count && duration < l.maxDuration; i++ {
+		duration *= 2
+	}
+	if duration > l.maxDuration {
+		duration = l.maxDuration
+	}
+	until := time.Now().Add(duration)
+	st.Meta["lockout_until"] = until
+	st.Meta["lockout_count"] = count + 1
+	delete(st.Meta, "lockout_failures")
+	st.mu.Unlock()
+
+	l.waf.emit(SecurityEvent{
+		Type:      EventAccountLock,
+		Module:    "login_protection",
+		Severity:  SeverityCritical,
+		IP:        ip,
+		RequestID: requestID,
+		Action:    "lock",
+		Message:   fmt.Sprintf("Аккаунт %q заблокирован на %v после %d неудачных входов (последний с %s)", user, duration, failures, ip),
+		Fields:    map[string]interface{}{"username": user, "failures": failures, "locked_until": until},
+	})
+	l.notify(AccountLockoutEvent{
+		Event:       "account_locked",
+		Username:    user,
+		IP:          ip,
+		Failures:    failures,
+		LockedUntil: until,
+		Timestamp:   time.Now(),
+	})
+}
+
+// notify вызывает зарегистрированные хуки и webhook асинхронно
+func (l *accountLockout) notify(ev AccountLockoutEvent) {
+	for _, hook := range l.hooks {
+		go hook(ev)
+	}
+	if l.webhookURL == "" {
+		return
+	}
+	go func() {
+		body, err := json.Marshal(ev)
+		if err != nil {
+			return
+		}
+		req, err := http.NewRequest(http.MethodPost, l.webhookURL, bytes.NewReader(body))
+		if err != nil {
+			log.Printf("[WAF] Ошибка webhook блокировки аккаунта: %v", err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if l.webhookSecret != "" {
+			req.Header.Set("Authorization", "Bearer "+l.webhookSecret)
+		}
+		resp, err := l.client.Do(req)
+		if err != nil {
+			log.Printf("[WAF] Ошибка webhook блокировки аккаунта: %v", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Printf("[WAF] Webhook блокировки аккаунта вернул %s", resp.Status)
+		}
+	}()
+}
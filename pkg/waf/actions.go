@@ -0,0 +1,32 @@
+package waf
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// enforceAction применяет действие к клиенту: ban, throttle, delay или challenge.
+// Возвращает true, если ответ уже записан и запрос дальше не передается.
+func enforceAction(w http.ResponseWriter, r *http.Request, waf *WAF, ip, action string, banDuration, delay time.Duration) bool {
+	switch action {
+	case "delay":
+		time.Sleep(delay)
+		return false
+	case "throttle":
+		w.Header().Set("Retry-After", strconv.FormatInt(int64(delay.Seconds())+1, 10))
+		http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+		return true
+	case "challenge":
+		if waf.challenges.Passed(r, ip) {
+			return false
+		}
+		waf.challenges.Issue(w, ip)
+		return true
+	default:
+		waf.bans.Ban(ip, banDuration)
+		w.Header().Set("Retry-After", strconv.FormatInt(int64(banDuration.Seconds()), 10))
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return true
+	}
+}
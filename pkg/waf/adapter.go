@@ -0,0 +1,33 @@
+package waf
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Adapt возвращает модуль как стандартный net/http middleware
+func Adapt(m Middleware) func(http.Handler) http.Handler {
+	return m.push
+}
+
+// Protection создает модуль по имени из middleware_chain (signature, rate_limit, context, ...)
+// с настройками конфигурации New и возвращает его как стандартный net/http middleware.
+// Модуль не входит в цепь Handler, но использует общие с ней состояния клиентов и блокировки,
+// поэтому отдельные защиты можно подключать к своим маршрутам без обратного прокси.
+func (w *WAF) Protection(name string) (func(http.Handler) http.Handler, error) {
+	w.mu.RLock()
+	cfg := w.cfg
+	w.mu.RUnlock()
+	m, err := newChainMiddleware(w, name, cfg)
+	if err != nil {
+		if errors.Is(err, errUnknownMiddleware) {
+			return nil, fmt.Errorf("%w: %s", err, name)
+		}
+		return nil, err
+	}
+	if m == nil {
+		return nil, fmt.Errorf("%s: not configured", name)
+	}
+	return m.push, nil
+}
@@ -0,0 +1,335 @@
+package waf
+
+import (
+	_ "embed"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+//go:embed admin_dashboard.html
+var adminDashboardHTML []byte
+
+const (
+	adminRecentEvents = 100   // последние события на панели
+	adminMaxOffenders = 10000 // предел учета клиентов, после него редкие записи вытесняются
+	adminRateWindow   = 60    // секунд истории частоты запросов
+)
+
+// adminStats собирает данные панели из шины событий и счетчика запросов
+type adminStats struct {
+	mu        sync.Mutex
+	recent    []SecurityEvent // кольцевой буфер
+	next      int
+	offenders map[string]int
+	hits      map[string]int
+	rate      []float64 // запросов в секунду, от старых к новым
+
+	byRequest      map[string][]SecurityEvent // срабатывания по идентификатору запроса
+	requestOrder   []string                   // порядок вытеснения byRequest
+	falseHits      map[string]int             // ложные срабатывания по правилам
+	falsePositives []FalsePositive
+}
+
+func newAdminStats(w *WAF) *adminStats {
+	s := &adminStats{
+		recent:    make([]SecurityEvent, 0, adminRecentEvents),
+		offenders: make(map[string]int),
+		hits:      make(map[string]int),
+		byRequest: make(map[string][]SecurityEvent),
+		falseHits: make(map[string]int),
+	}
+	w.events.Subscribe("admin", EventSinkFunc(s.add))
+	go s.sampleRate(w)
+	return s
+}
+
+func (s *adminStats) add(ev SecurityEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ev.Type != EventDetection && ev.Type != EventBan {
+		return
+	}
+	if len(s.recent) < adminRecentEvents {
+		s.recent = append(s.recent, ev)
+	} else {
+		s.recent[s.next] = ev
+	}
+	s.next = (s.next + 1) % adminRecentEvents
+
+	if ev.Type != EventDetection {
+		return
+	}
+	s.rememberDetection(ev)
+	name := ev.Module
+	if rule, ok := ev.Fields["rule"].(string); ok {
+		name += ":" + rule
+	}
+	s.hits[name]++
+	if ev.IP != "" {
+		if _, ok := s.offenders[ev.IP]; !ok && len(s.offenders) >= adminMaxOffenders {
+			for ip, n := range s.offenders {
+				if n <= 1 {
+					delete(s.offenders, ip)
+				} else {
+					s.offenders[ip] = n / 2
+				}
+			}
+		}
+		s.offenders[ev.IP]++
+	}
+}
+
+// sampleRate раз в секунду записывает число запросов за прошедшую секунду
+func (s *adminStats) sampleRate(w *WAF) {
+	last := w.requests.Load()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		cur := w.requests.Load()
+		s.mu.Lock()
+		s.rate = append(s.rate, float64(cur-last))
+		if len(s.rate) > adminRateWindow {
+			s.rate = s.rate[len(s.rate)-adminRateWindow:]
+		}
+		s.mu.Unlock()
+		last = cur
+	}
+}
+
+type adminCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type adminBan struct {
+	ID     string    `json:"id"`
+	Until  time.Time `json:"until"`
+	Tenant string    `json:"tenant,omitempty"`
+}
+
+// adminSummary данные панели
+type adminSummary struct {
+	RequestsTotal      uint64              `json:"requests_total"`
+	Rate               []float64           `json:"rate"`
+	Recent             []SecurityEvent     `json:"recent"`
+	TopOffenders       []adminCount        `json:"top_offenders"`
+	Hits               []adminCount        `json:"hits"`
+	FalsePositiveRates []falsePositiveRate `json:"false_positive_rates"`
+	Bans               []adminBan          `json:"bans"`
+}
+
+func (s *adminStats) summary(w *WAF) adminSummary {
+	s.mu.Lock()
+	sum := adminSummary{
+		RequestsTotal:      w.requests.Load(),
+		Rate:               append([]float64(nil), s.rate...),
+		TopOffenders:       topCounts(s.offenders, 20),
+		Hits:               topCounts(s.hits, 0),
+		FalsePositiveRates: s.falsePositiveRates(),
+	}
+	// Новые события первыми
+	for i := 0; i < len(s.recent); i++ {
+		idx := (s.next - 1 - i + 2*adminRecentEvents) % adminRecentEvents
+		if idx < len(s.recent) {
+			sum.Recent = append(sum.Recent, s.recent[idx])
+		}
+	}
+	s.mu.Unlock()
+
+	for id, until := range w.bans.Active() {
+		sum.Bans = append(sum.Bans, adminBan{ID: id, Until: until})
+	}
+	w.mu.RLock()
+	tenants := w.tenants
+	w.mu.RUnlock()
+	for _, t := range tenants {
+		for id, until := range t.waf.bans.Active() {
+			sum.Bans = append(sum.Bans, adminBan{ID: id, Until: until, Tenant: t.name})
+		}
+	}
+	sort.Slice(sum.Bans, func(i, j int) bool { return sum.Bans[i].Until.After(sum.Bans[j].Until) })
+	return sum
+}
+
+// topCounts сортирует счетчики по убыванию; limit=0 — все
+func topCounts(counts map[string]int, limit int) []adminCount {
+	out := make([]adminCount, 0, len(counts))
+	for name, n := range counts {
+		out = append(out, adminCount{Name: name, Count: n})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Name < out[j].Name
+	})
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+// AdminHandler возвращает панель администратора и API управления банами под /admin/.
+// Обработчик нужно публиковать только на адресе, недоступном клиентам; доступ к API
+// ограничивается ролями, если настроена аутентификация (SetAdminAuth).
+func (w *WAF) AdminHandler() http.Handler {
+	w.mu.Lock()
+	if w.stats == nil {
+		w.stats = newAdminStats(w)
+	}
+	w.mu.Unlock()
+
+	mux := http.NewServeMux()
+	// Страница панели не содержит данных и доступна без входа: ключ запрашивается скриптом
+	mux.HandleFunc("GET /admin/{$}", func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		rw.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+		rw.Write(adminDashboardHTML)
+	})
+	mux.HandleFunc("GET /admin/api/me", w.adminAuthorize(AdminRoleViewer, func(rw http.ResponseWriter, r *http.Request) {
+		writeJSON(rw, http.StatusOK, map[string]string{"actor": adminActor(r), "role": adminRole(r)})
+	}))
+	mux.HandleFunc("GET /admin/api/summary", w.adminAuthorize(AdminRoleViewer, func(rw http.ResponseWriter, r *http.Request) {
+		writeJSON(rw, http.StatusOK, w.stats.summary(w))
+	}))
+	mux.HandleFunc("GET /admin/events/stream", w.adminAuthorize(AdminRoleViewer, w.eventStream))
+	mux.HandleFunc("POST /admin/api/bans", w.adminAuthorize(AdminRoleOperator, w.adminBan))
+	mux.HandleFunc("DELETE /admin/api/bans/{id}", w.adminAuthorize(AdminRoleOperator, w.adminUnban))
+	mux.HandleFunc("GET /admin/api/bans/export", w.adminAuthorize(AdminRoleViewer, w.adminBanExport))
+	mux.HandleFunc("GET /admin/api/cluster", w.adminAuthorize(AdminRoleViewer, w.adminCluster))
+	mux.HandleFunc("GET /admin/api/fleet", w.adminAuthorize(AdminRoleViewer, w.adminFleet))
+	mux.HandleFunc("GET /admin/api/fleet/config", w.adminAuthorize(AdminRoleAdmin, w.adminFleetConfig))
+	mux.HandleFunc("POST /admin/api/fleet/report", w.adminAuthorize(AdminRoleOperator, w.adminFleetReport))
+	mux.HandleFunc("GET /admin/api/rules/canary", w.adminAuthorize(AdminRoleViewer, w.adminRulesCanary))
+	mux.HandleFunc("GET /admin/api/replication/state", w.adminAuthorize(AdminRoleViewer, w.adminReplicationState))
+	mux.HandleFunc("GET /admin/api/standby", w.adminAuthorize(AdminRoleViewer, w.adminStandby))
+	mux.HandleFunc("POST /admin/api/standby/promote", w.adminAuthorize(AdminRoleOperator, w.adminStandbyPromote))
+	mux.HandleFunc("GET /admin/api/config", w.adminAuthorize(AdminRoleViewer, func(rw http.ResponseWriter, r *http.Request) {
+		cfg := w.config()
+		rw.Header().Set("ETag", configVersion(cfg))
+		writeJSON(rw, http.StatusOK, redactConfig(cfg))
+	}))
+	mux.HandleFunc("GET /admin/api/shadow", w.adminAuthorize(AdminRoleViewer, func(rw http.ResponseWriter, r *http.Request) {
+		s := w.shadow.Load()
+		if s == nil {
+			writeJSON(rw, http.StatusNotFound, map[string]string{"error": "shadow mode is off"})
+			return
+		}
+		writeJSON(rw, http.StatusOK, s.report())
+	}))
+	mux.HandleFunc("PATCH /admin/api/config", w.adminAuthorize(AdminRoleAdmin, w.adminPatchConfig))
+	mux.HandleFunc("POST /admin/api/config/dry-run", w.adminAuthorize(AdminRoleAdmin, w.adminConfigDryRun))
+	mux.HandleFunc("PUT /admin/api/config", w.adminAuthorize(AdminRoleAdmin, w.adminConfigReplace))
+	mux.HandleFunc("GET /admin/api/config/versions", w.adminAuthorize(AdminRoleViewer, w.adminConfigVersions))
+	mux.HandleFunc("GET /admin/api/config/versions/{version}", w.adminAuthorize(AdminRoleViewer, w.adminConfigVersion))
+	mux.HandleFunc("POST /admin/api/config/rollback", w.adminAuthorize(AdminRoleAdmin, w.adminConfigRollback))
+	mux.HandleFunc("GET /admin/api/maintenance", w.adminAuthorize(AdminRoleViewer, func(rw http.ResponseWriter, r *http.Request) {
+		writeJSON(rw, http.StatusOK, map[string]bool{"enabled": w.Maintenance()})
+	}))
+	mux.HandleFunc("PUT /admin/api/maintenance", w.adminAuthorize(AdminRoleOperator, w.adminMaintenance))
+	mux.HandleFunc("GET /admin/api/false-positives", w.adminAuthorize(AdminRoleViewer, w.adminFalsePositives))
+	mux.HandleFunc("POST /admin/api/false-positives", w.adminAuthorize(AdminRoleOperator, w.adminFalsePositive))
+	w.adminDebugRoutes(mux)
+	mux.Handle("GET /admin", http.RedirectHandler("/admin/", http.StatusMovedPermanently))
+	return mux
+}
+
+// adminBan ручной бан: {"id": "203.0.113.7", "seconds": 3600, "tenant": "shop"}
+func (w *WAF) adminBan(rw http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID      string `json:"id"`
+		Seconds int    `json:"seconds"`
+		Tenant  string `json:"tenant"` // пусто — основной WAF
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(rw, r.Body, 4096)).Decode(&req); err != nil || req.ID == "" || req.Seconds <= 0 {
+		writeJSON(rw, http.StatusBadRequest, map[string]string{"error": "id and positive seconds are required"})
+		return
+	}
+	bans := w.tenantBans(req.Tenant)
+	if bans == nil {
+		writeJSON(rw, http.StatusNotFound, map[string]string{"error": "unknown tenant"})
+		return
+	}
+	var before interface{}
+	if until, ok := bans.Active()[req.ID]; ok {
+		before = until
+	}
+	ban := adminBan{ID: req.ID, Until: time.Now().Add(time.Duration(req.Seconds) * time.Second), Tenant: req.Tenant}
+	bans.Ban(req.ID, time.Duration(req.Seconds)*time.Second)
+	w.auditRequest(r, "ban", auditTarget(req.Tenant, req.ID), before, ban.Until)
+	writeJSON(rw, http.StatusCreated, ban)
+}
+
+// adminUnban снимает бан; арендатор задается параметром tenant
+func (w *WAF) adminUnban(rw http.ResponseWriter, r *http.Request) {
+	id, tenant := r.PathValue("id"), r.URL.Query().Get("tenant")
+	bans := w.tenantBans(tenant)
+	if bans == nil {
+		writeJSON(rw, http.StatusNotFound, map[string]string{"error": "unknown tenant"})
+		return
+	}
+	until, ok := bans.Active()[id]
+	if !ok || !bans.Unban(id) {
+		writeJSON(rw, http.StatusNotFound, map[string]string{"error": "not banned"})
+		return
+	}
+	w.cluster.announceUnban(tenant, id)
+	w.auditRequest(r, "unban", auditTarget(tenant, id), until, nil)
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// tenantBans баны арендатора; "" — основной WAF, nil — арендатора нет
+func (w *WAF) tenantBans(tenant string) *BanList {
+	if tenant == "" {
+		return w.bans
+	}
+	if t := w.Tenant(tenant); t != nil {
+		return t.bans
+	}
+	return nil
+}
+
+func auditTarget(tenant, id string) string {
+	if tenant == "" {
+		return id
+	}
+	return tenant + "/" + id
+}
+
+// adminPatchConfig накладывает JSON на текущую конфигурацию и перезагружает цепь модулей.
+// Listener, таймауты и бэкенды при этом не меняются (см. Reload).
+func (w *WAF) adminPatchConfig(rw http.ResponseWriter, r *http.Request) {
+	patch, err := io.ReadAll(http.MaxBytesReader(rw, r.Body, 1<<20))
+	if err != nil {
+		writeJSON(rw, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	before := w.config()
+	cfg, err := mergeConfig(before, patch)
+	if err == nil {
+		err = w.reload(cfg, "admin")
+	}
+	if err != nil {
+		writeJSON(rw, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+		return
+	}
+	w.auditRequest(r, "config_change", "", redactConfig(before), redactConfig(cfg))
+	writeJSON(rw, http.StatusOK, redactConfig(cfg))
+}
+
+// config текущая конфигурация (nil — настройки по умолчанию)
+func (w *WAF) config() *Config {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.cfg
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,231 @@
+package waf
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Роли администраторов по возрастанию прав
+const (
+	AdminRoleViewer   = "viewer"   // просмотр панели, событий и конфигурации
+	AdminRoleOperator = "operator" // + управление банами
+	AdminRoleAdmin    = "admin"    // + изменение конфигурации
+)
+
+var adminRoleRank = map[string]int{
+	AdminRoleViewer:   1,
+	AdminRoleOperator: 2,
+	AdminRoleAdmin:    3,
+}
+
+// adminTokenCookie cookie, в которой панель хранит ключ или токен (EventSource не передает заголовки)
+const adminTokenCookie = "waf_admin_token"
+
+// adminAPIKey ключ API; хранится только SHA-256 ключа
+type adminAPIKey struct {
+	name string
+	sum  [sha256.Size]byte
+	role string
+}
+
+// adminAuth аутентификация API администратора по ключам и токенам OIDC
+type adminAuth struct {
+	keys        []adminAPIKey
+	oidc        *JWTMiddleware // проверка подписи и claims токена
+	userClaim   string
+	roleClaim   string
+	roles       map[string]string // значение claim -> роль
+	defaultRole string
+}
+
+// SetAdminAuth включает аутентификацию панели администратора. Без ключей и OIDC
+// панель доступна без проверки с правами operator: изменение конфигурации и диагностика
+// процесса (роль admin) без аутентификации запрещены.
+func (w *WAF) SetAdminAuth(cfg AdminAuthConfig) error {
+	if len(cfg.APIKeys) == 0 && cfg.OIDC == nil {
+		w.mu.Lock()
+		w.adminAuth = nil
+		w.mu.Unlock()
+		return nil
+	}
+	a := &adminAuth{}
+	for _, k := range cfg.APIKeys {
+		if k.Name == "" || k.Key == "" {
+			return errors.New("api key name and key are required")
+		}
+		if adminRoleRank[k.Role] == 0 {
+			return fmt.Errorf("api key %s: unknown role %q", k.Name, k.Role)
+		}
+		a.keys = append(a.keys, adminAPIKey{name: k.Name, sum: sha256.Sum256([]byte(k.Key)), role: k.Role})
+	}
+	if o := cfg.OIDC; o != nil {
+		if o.Issuer == "" || o.Audience == "" {
+			return errors.New("oidc: issuer and audience are required")
+		}
+		jwksURL := o.JWKSURL
+		if jwksURL == "" {
+			var err error
+			if jwksURL, err = discoverJWKS(o.Issuer); err != nil {
+				return fmt.Errorf("oidc discovery: %w", err)
+			}
+		}
+		v, err := NewJWTMiddlewareWithConfig(w, JWTConfig{
+			Algorithms: o.Algorithms,
+			JWKSURL:    jwksURL,
+			Audience:   o.Audience,
+			Issuer:     o.Issuer,
+		})
+		if err != nil {
+			return err
+		}
+		a.oidc = v
+		a.userClaim, a.roleClaim = o.UserClaim, o.RoleClaim
+		if a.userClaim == "" {
+			a.userClaim = "email"
+		}
+		if a.roleClaim == "" {
+			a.roleClaim = "roles"
+		}
+		for value, role := range o.Roles {
+			if adminRoleRank[role] == 0 {
+				return fmt.Errorf("oidc: unknown role %q for %q", role, value)
+			}
+		}
+		if o.DefaultRole != "" && adminRoleRank[o.DefaultRole] == 0 {
+			return fmt.Errorf("oidc: unknown default_role %q", o.DefaultRole)
+		}
+		a.roles, a.defaultRole = o.Roles, o.DefaultRole
+	}
+	w.mu.Lock()
+	w.adminAuth = a
+	w.mu.Unlock()
+	return nil
+}
+
+// discoverJWKS получает jwks_uri из OpenID Connect discovery издателя
+func discoverJWKS(issuer string) (string, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New("bad response: " + resp.Status)
+	}
+	var doc struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return "", err
+	}
+	if doc.JWKSURI == "" {
+		return "", errors.New("jwks_uri is missing")
+	}
+	return doc.JWKSURI, nil
+}
+
+// authenticate возвращает исполнителя и его роль. Пустой actor — учетные данные не приняты,
+// пустая роль — токен OIDC верен, но роль ему не назначена
+func (a *adminAuth) authenticate(r *http.Request) (actor, role string) {
+	token := r.Header.Get("X-API-Key")
+	if token == "" {
+		token = bearerToken(r, adminTokenCookie)
+	}
+	if token == "" {
+		return "", ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	for _, k := range a.keys {
+		if subtle.ConstantTimeCompare(sum[:], k.sum[:]) == 1 {
+			return "apikey:" + k.name, k.role
+		}
+	}
+	if a.oidc == nil || strings.Count(token, ".") != 2 {
+		return "", ""
+	}
+	claims, err := a.oidc.verify(token)
+	if err != nil {
+		return "", ""
+	}
+	user, _ := claims[a.userClaim].(string)
+	if user == "" {
+		user, _ = claims["sub"].(string)
+	}
+	return "oidc:" + user, a.role(claims[a.roleClaim])
+}
+
+// role выбирает самую сильную роль из значений claim (строка или массив строк)
+func (a *adminAuth) role(claim interface{}) string {
+	var values []string
+	switch v := claim.(type) {
+	case string:
+		values = strings.Fields(v)
+	case []interface{}:
+		for _, x := range v {
+			if s, ok := x.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+	role := a.defaultRole
+	for _, v := range values {
+		if r := a.roles[v]; adminRoleRank[r] > adminRoleRank[role] {
+			role = r
+		}
+	}
+	return role
+}
+
+// adminAuthorize пропускает запрос, если у исполнителя есть роль не ниже need,
+// и сохраняет исполнителя в контексте для журнала действий
+func (w *WAF) adminAuthorize(need string, h http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		w.mu.RLock()
+		a := w.adminAuth
+		w.mu.RUnlock()
+		if a == nil {
+			if need == AdminRoleAdmin {
+				log.Printf("[WAF] admin: отказано в доступе к %s %s: не настроена аутентификация (admin.auth)", r.Method, r.URL.Path)
+				writeJSON(rw, http.StatusForbidden, map[string]string{"error": "admin.auth must be configured for role admin"})
+				return
+			}
+			h(rw, r)
+			return
+		}
+		actor, role := a.authenticate(r)
+		if actor == "" {
+			rw.Header().Set("WWW-Authenticate", `Bearer realm="waf-admin"`)
+			writeJSON(rw, http.StatusUnauthorized, map[string]string{"error": "authentication required"})
+			return
+		}
+		if adminRoleRank[role] < adminRoleRank[need] {
+			log.Printf("[WAF] admin: %s (%q) отказано в доступе к %s %s", actor, role, r.Method, r.URL.Path)
+			writeJSON(rw, http.StatusForbidden, map[string]string{"error": "role " + need + " required"})
+			return
+		}
+		h(rw, r.WithContext(withAdminActor(withAdminRole(r.Context(), role), actor)))
+	}
+}
+
+type adminRoleKey struct{}
+
+func withAdminRole(ctx context.Context, role string) context.Context {
+	return context.WithValue(ctx, adminRoleKey{}, role)
+}
+
+// adminRole роль исполнителя запроса; без аутентификации — operator
+func adminRole(r *http.Request) string {
+	if role, ok := r.Context().Value(adminRoleKey{}).(string); ok {
+		return role
+	}
+	return AdminRoleOperator
+}
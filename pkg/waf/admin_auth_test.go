@@ -0,0 +1,116 @@
+package waf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdminAuthorizeRoles(t *testing.T) {
+	w, err := NewWAF("http://127.0.0.1:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetAdminAuth(AdminAuthConfig{APIKeys: []AdminAPIKeyConfig{
+		{Name: "view", Key: "viewer-key", Role: AdminRoleViewer},
+		{Name: "ops", Key: "operator-key", Role: AdminRoleOperator},
+		{Name: "root", Key: "admin-key", Role: AdminRoleAdmin},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	// Токены OIDC проверяются тем же JWT модулем; подпись HS256 упрощает тест
+	const secret = "oidc-secret"
+	oidc, err := NewJWTMiddlewareWithConfig(nil, JWTConfig{Algorithms: []string{"HS256"}, HMACSecret: secret, Audience: "panel", Issuer: "idp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.adminAuth.oidc, w.adminAuth.userClaim, w.adminAuth.roleClaim = oidc, "email", "roles"
+	w.adminAuth.roles = map[string]string{"waf-ops": AdminRoleOperator}
+	oidcToken := func(roles interface{}) string {
+		claims := map[string]interface{}{"exp": time.Now().Add(time.Minute).Unix(), "aud": "panel", "iss": "idp", "email": "a@example.com"}
+		if roles != nil {
+			claims["roles"] = roles
+		}
+		return hs256Token(secret, map[string]interface{}{"alg": "HS256"}, claims)
+	}
+	h := w.AdminHandler()
+
+	for _, tc := range []struct {
+		name         string
+		method, path string
+		key, bearer  string
+		want         int // 0 — доступ разрешен
+	}{
+		{"no credentials", "GET", "/admin/api/summary", "", "", http.StatusUnauthorized},
+		{"unknown key", "GET", "/admin/api/summary", "nope", "", http.StatusUnauthorized},
+		{"forged oidc token", "GET", "/admin/api/summary", "", hs256Token("other", map[string]interface{}{"alg": "HS256"}, map[string]interface{}{"aud": "panel", "iss": "idp"}), http.StatusUnauthorized},
+		{"viewer reads", "GET", "/admin/api/summary", "viewer-key", "", 0},
+		{"viewer bans", "POST", "/admin/api/bans", "viewer-key", "", http.StatusForbidden},
+		{"viewer reads fleet config", "GET", "/admin/api/fleet/config", "viewer-key", "", http.StatusForbidden},
+		{"operator bans", "POST", "/admin/api/bans", "operator-key", "", 0},
+		{"operator patches config", "PATCH", "/admin/api/config", "operator-key", "", http.StatusForbidden},
+		{"operator replaces config", "PUT", "/admin/api/config", "operator-key", "", http.StatusForbidden},
+		{"operator rolls back", "POST", "/admin/api/config/rollback", "operator-key", "", http.StatusForbidden},
+		{"admin rolls back", "POST", "/admin/api/config/rollback", "admin-key", "", 0},
+		{"operator reads pprof", "GET", "/admin/debug/pprof/", "operator-key", "", http.StatusForbidden},
+		{"admin reads pprof", "GET", "/admin/debug/pprof/", "admin-key", "", 0},
+		{"admin reads expvar", "GET", "/admin/debug/vars", "admin-key", "", 0},
+		{"oidc without role", "GET", "/admin/api/summary", "", oidcToken(nil), http.StatusForbidden},
+		{"oidc unmapped role", "GET", "/admin/api/summary", "", oidcToken([]interface{}{"admins"}), http.StatusForbidden},
+		{"oidc operator bans", "POST", "/admin/api/bans", "", oidcToken([]interface{}{"waf-ops"}), 0},
+		{"oidc operator patches config", "PATCH", "/admin/api/config", "", oidcToken("waf-ops"), http.StatusForbidden},
+	} {
+		r := httptest.NewRequest(tc.method, tc.path, strings.NewReader("{}"))
+		if tc.key != "" {
+			r.Header.Set("X-API-Key", tc.key)
+		}
+		if tc.bearer != "" {
+			r.Header.Set("Authorization", "Bearer "+tc.bearer)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		denied := rec.Code == http.StatusUnauthorized || rec.Code == http.StatusForbidden
+		if tc.want == 0 && denied || tc.want != 0 && rec.Code != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, rec.Code, tc.want)
+		}
+	}
+}
+
+// Без admin.auth панель открыта с правами operator, действия роли admin запрещены
+func TestAdminWithoutAuth(t *testing.T) {
+	w, err := NewWAF("http://127.0.0.1:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := w.AdminHandler()
+
+	for _, tc := range []struct {
+		method, path string
+		denied       bool
+	}{
+		{"GET", "/admin/api/summary", false},
+		{"GET", "/admin/api/runtime", false},
+		{"POST", "/admin/api/bans", false},
+		{"GET", "/admin/debug/pprof/", true},
+		{"GET", "/admin/debug/pprof/cmdline", true},
+		{"GET", "/admin/debug/vars", true},
+		{"PATCH", "/admin/api/config", true},
+		{"PUT", "/admin/api/config", true},
+		{"POST", "/admin/api/config/rollback", true},
+		{"GET", "/admin/api/fleet/config", true},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.path, strings.NewReader("{}")))
+		if denied := rec.Code == http.StatusForbidden; denied != tc.denied {
+			t.Errorf("%s %s: status %d, denied %v, want %v", tc.method, tc.path, rec.Code, denied, tc.denied)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/admin/api/me", nil))
+	if !strings.Contains(rec.Body.String(), `"role":"operator"`) {
+		t.Errorf("me = %s, want role operator", rec.Body.String())
+	}
+}
@@ -0,0 +1,222 @@
+package waf
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// configDiff структурированная разница между текущей конфигурацией и кандидатом
+type configDiff struct {
+	Chain   listDiff       `json:"chain"` // модули middleware_chain
+	Rules   ruleDiff       `json:"rules"` // правила rules.rules по имени
+	Changes []configChange `json:"changes"`
+}
+
+type listDiff struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+type ruleDiff struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Changed []string `json:"changed"`
+}
+
+// configChange изменение одного значения; секреты показываются как "***"
+type configChange struct {
+	Path   string      `json:"path"` // например rate_limit.limit
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// configDryRun ответ на пробное применение конфигурации
+type configDryRun struct {
+	Valid  bool              `json:"valid"`
+	Base   string            `json:"base"` // версия текущей конфигурации; передается в If-Match при применении
+	Issues []ValidationIssue `json:"issues"`
+	Diff   *configDiff       `json:"diff,omitempty"`
+}
+
+// configVersion версия конфигурации для If-Match/ETag
+func configVersion(cfg *Config) string {
+	data, _ := json.Marshal(cfg)
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// adminConfigDryRun проверяет конфигурацию-кандидата (полный файл конфигурации в теле)
+// и возвращает ошибки и разницу с текущей конфигурацией, ничего не применяя
+func (w *WAF) adminConfigDryRun(rw http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(http.MaxBytesReader(rw, r.Body, 1<<20))
+	if err != nil {
+		writeJSON(rw, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	current := w.config()
+	cfg, rep := validateConfigData("candidate", data)
+	res := configDryRun{Valid: rep.Errors() == 0, Base: configVersion(current), Issues: rep.Issues}
+	if cfg != nil {
+		res.Diff = diffConfigs(current, cfg)
+	}
+	writeJSON(rw, http.StatusOK, res)
+}
+
+// adminConfigReplace применяет конфигурацию-кандидата целиком после той же проверки,
+// что и dry-run. С If-Match конфигурация применяется, только если текущая не менялась
+// с момента пробного применения
+func (w *WAF) adminConfigReplace(rw http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(http.MaxBytesReader(rw, r.Body, 1<<20))
+	if err != nil {
+		writeJSON(rw, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	before := w.config()
+	if match := r.Header.Get("If-Match"); match != "" && match != configVersion(before) {
+		writeJSON(rw, http.StatusPreconditionFailed, map[string]string{"error": "configuration changed since dry-run"})
+		return
+	}
+	cfg, rep := validateConfigData("candidate", data)
+	if rep.Errors() > 0 {
+		writeJSON(rw, http.StatusUnprocessableEntity, map[string]interface{}{"error": "candidate configuration is invalid", "issues": rep.Issues})
+		return
+	}
+	if err := w.reload(cfg, "admin"); err != nil {
+		writeJSON(rw, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+		return
+	}
+	w.auditRequest(r, "config_replace", "", redactConfig(before), redactConfig(cfg))
+	rw.Header().Set("ETag", configVersion(cfg))
+	writeJSON(rw, http.StatusOK, redactConfig(cfg))
+}
+
+// diffConfigs сравнивает конфигурации; nil — настройки по умолчанию
+func diffConfigs(before, after *Config) *configDiff {
+	if before == nil {
+		before = &Config{}
+	}
+	d := &configDiff{
+		Chain:   diffLists(effectiveChain(before), effectiveChain(after)),
+		Rules:   diffRules(before.Rules.Rules, after.Rules.Rules),
+		Changes: []configChange{},
+	}
+	b, a := flattenConfig(before), flattenConfig(after)
+	paths := make([]string, 0, len(a))
+	for p := range a {
+		paths = append(paths, p)
+	}
+	for p := range b {
+		if _, ok := a[p]; !ok {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		// Цепь и правила описаны выше по элементам
+		if p == "middleware_chain" || p == "rules.rules" || reflect.DeepEqual(b[p], a[p]) {
+			continue
+		}
+		d.Changes = append(d.Changes, configChange{Path: p, Before: redactLeaf(p, b[p]), After: redactLeaf(p, a[p])})
+	}
+	return d
+}
+
+func effectiveChain(cfg *Config) []string {
+	if len(cfg.MiddlewareChain) > 0 {
+		return cfg.MiddlewareChain
+	}
+	return []string{"context", "rate_limit", "signature"}
+}
+
+func diffLists(before, after []string) listDiff {
+	d := listDiff{Added: []string{}, Removed: []string{}}
+	was, now := toSet(before), toSet(after)
+	for _, s := range after {
+		if !was[s] {
+			d.Added = append(d.Added, s)
+		}
+	}
+	for _, s := range before {
+		if !now[s] {
+			d.Removed = append(d.Removed, s)
+		}
+	}
+	return d
+}
+
+func diffRules(before, after []RuleConfig) ruleDiff {
+	d := ruleDiff{Added: []string{}, Removed: []string{}, Changed: []string{}}
+	was := make(map[string]RuleConfig, len(before))
+	for _, rc := range before {
+		was[rc.Name] = rc
+	}
+	now := make(map[string]bool, len(after))
+	for _, rc := range after {
+		now[rc.Name] = true
+		old, ok := was[rc.Name]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, rc.Name)
+		case old != rc:
+			d.Changed = append(d.Changed, rc.Name)
+		}
+	}
+	for _, rc := range before {
+		if !now[rc.Name] {
+			d.Removed = append(d.Removed, rc.Name)
+		}
+	}
+	return d
+}
+
+// flattenConfig раскладывает конфигурацию в значения по путям ключей; объекты
+// раскрываются, массивы сравниваются целиком
+func flattenConfig(cfg *Config) map[string]interface{} {
+	data, _ := json.Marshal(cfg)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	dec.Decode(&v)
+	out := make(map[string]interface{})
+	var walk func(prefix string, v interface{})
+	walk = func(prefix string, v interface{}) {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			out[prefix] = v
+			return
+		}
+		for k, val := range obj {
+			p := k
+			if prefix != "" {
+				p = prefix + "." + k
+			}
+			walk(p, val)
+		}
+	}
+	walk("", v)
+	return out
+}
+
+// redactLeaf скрывает секреты в значении по пути path
+func redactLeaf(path string, v interface{}) interface{} {
+	parent, key := "", path
+	if i := strings.LastIndex(path, "."); i >= 0 {
+		parent, key = path[:i], path[i+1:]
+	}
+	parent = parent[strings.LastIndex(parent, ".")+1:]
+	if s, ok := v.(string); ok && s != "" && (isSecretKey(key) || isHeadersKey(parent)) {
+		return "***"
+	}
+	if isHeadersKey(key) {
+		v = map[string]interface{}{key: v}
+		return redactValue(v).(map[string]interface{})[key]
+	}
+	return redactValue(v)
+}
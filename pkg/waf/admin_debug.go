@@ -0,0 +1,94 @@
+package waf
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+)
+
+// processStart время запуска процесса для снимка среды выполнения
+var processStart = time.Now()
+
+// runtimeSnapshot состояние процесса и хранилищ WAF
+type runtimeSnapshot struct {
+	Uptime     string `json:"uptime"`
+	GoVersion  string `json:"go_version"`
+	CPUs       int    `json:"cpus"`
+	GOMAXPROCS int    `json:"gomaxprocs"`
+	Goroutines int    `json:"goroutines"`
+
+	HeapAlloc    uint64  `json:"heap_alloc"`
+	HeapInuse    uint64  `json:"heap_inuse"`
+	HeapObjects  uint64  `json:"heap_objects"`
+	HeapSys      uint64  `json:"heap_sys"`
+	TotalAlloc   uint64  `json:"total_alloc"`
+	NumGC        uint32  `json:"num_gc"`
+	LastGCPause  string  `json:"last_gc_pause"`
+	GCCPUPercent float64 `json:"gc_cpu_percent"`
+
+	States  int            `json:"states"` // отслеживаемые клиенты основного WAF
+	Bans    int            `json:"bans"`
+	Tenants map[string]int `json:"tenant_states,omitempty"`
+}
+
+func (w *WAF) runtimeSnapshot() runtimeSnapshot {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	snap := runtimeSnapshot{
+		Uptime:       time.Since(processStart).Round(time.Second).String(),
+		GoVersion:    runtime.Version(),
+		CPUs:         runtime.NumCPU(),
+		GOMAXPROCS:   runtime.GOMAXPROCS(0),
+		Goroutines:   runtime.NumGoroutine(),
+		HeapAlloc:    ms.HeapAlloc,
+		HeapInuse:    ms.HeapInuse,
+		HeapObjects:  ms.HeapObjects,
+		HeapSys:      ms.HeapSys,
+		TotalAlloc:   ms.TotalAlloc,
+		NumGC:        ms.NumGC,
+		LastGCPause:  time.Duration(ms.PauseNs[(ms.NumGC+255)%256]).String(),
+		GCCPUPercent: ms.GCCPUFraction * 100,
+		States:       w.states.Len(),
+		Bans:         len(w.bans.Active()),
+	}
+	w.mu.RLock()
+	tenants := w.tenants
+	w.mu.RUnlock()
+	for _, t := range tenants {
+		if snap.Tenants == nil {
+			snap.Tenants = make(map[string]int)
+		}
+		snap.Tenants[t.name] = t.waf.states.Len()
+	}
+	return snap
+}
+
+// adminDebugRoutes диагностика работающего процесса: профили pprof, expvar и снимок
+// среды выполнения. Профили раскрывают внутреннее устройство процесса и доступны
+// только роли admin, то есть только при настроенной аутентификации.
+func (w *WAF) adminDebugRoutes(mux *http.ServeMux) {
+	// Обработчики pprof ожидают путь /debug/pprof/...
+	profiles := http.StripPrefix("/admin", http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		// Профиль CPU и trace пишутся дольше таймаута записи панели
+		http.NewResponseController(rw).SetWriteDeadline(time.Time{})
+		switch r.URL.Path {
+		case "/debug/pprof/cmdline":
+			pprof.Cmdline(rw, r)
+		case "/debug/pprof/profile":
+			pprof.Profile(rw, r)
+		case "/debug/pprof/symbol":
+			pprof.Symbol(rw, r)
+		case "/debug/pprof/trace":
+			pprof.Trace(rw, r)
+		default:
+			pprof.Index(rw, r)
+		}
+	}))
+	mux.HandleFunc("/admin/debug/pprof/", w.adminAuthorize(AdminRoleAdmin, profiles.ServeHTTP))
+	mux.HandleFunc("GET /admin/debug/vars", w.adminAuthorize(AdminRoleAdmin, expvar.Handler().ServeHTTP))
+	mux.HandleFunc("GET /admin/api/runtime", w.adminAuthorize(AdminRoleViewer, func(rw http.ResponseWriter, r *http.Request) {
+		writeJSON(rw, http.StatusOK, w.runtimeSnapshot())
+	}))
+}
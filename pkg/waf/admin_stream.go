@@ -0,0 +1,74 @@
+package waf
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// eventStream отдает события шины в формате Server-Sent Events.
+// Параметры запроса: types (по умолчанию detection,ban), min_severity, modules.
+// Медленный клиент теряет события, а не задерживает шину.
+func (w *WAF) eventStream(rw http.ResponseWriter, r *http.Request) {
+	filter := eventFilter{types: map[string]bool{EventDetection: true, EventBan: true}}
+	q := r.URL.Query()
+	if v := q.Get("types"); v != "" {
+		filter.types = toSet(strings.Split(v, ","))
+	}
+	if v := q.Get("modules"); v != "" {
+		filter.modules = toSet(strings.Split(v, ","))
+	}
+	if v := q.Get("min_severity"); v != "" {
+		rank, ok := severityRank[v]
+		if !ok {
+			writeJSON(rw, http.StatusBadRequest, map[string]string{"error": "unknown min_severity"})
+			return
+		}
+		filter.minSeverity = rank
+	}
+
+	// Поток бессрочный: таймаут записи сервера к нему не применяется
+	rc := http.NewResponseController(rw)
+	rc.SetWriteDeadline(time.Time{})
+
+	ch := make(chan SecurityEvent, 64)
+	unsubscribe := w.events.subscribe("stream "+r.RemoteAddr, EventSinkFunc(func(ev SecurityEvent) {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}), filter)
+	defer unsubscribe()
+
+	h := rw.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no")
+	rw.WriteHeader(http.StatusOK)
+	fmt.Fprint(rw, "retry: 3000\n\n")
+	rc.Flush()
+
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+	var id uint64
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(rw, ": ping\n\n")
+		case ev := <-ch:
+			data, err := json.Marshal(ev)
+			if err != nil {
+				continue
+			}
+			id++
+			fmt.Fprintf(rw, "id: %d\nevent: %s\ndata: %s\n\n", id, ev.Type, data)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
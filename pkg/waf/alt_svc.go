@@ -0,0 +1,24 @@
+package waf
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// withAltSvc сообщает клиентам TCP listener о доступности HTTP/3 (заголовок Alt-Svc)
+func withAltSvc(next http.Handler, cfg HTTP3Config) http.Handler {
+	port := strconv.Itoa(cfg.AdvertisePort)
+	if cfg.AdvertisePort == 0 {
+		_, p, err := net.SplitHostPort(cfg.Addr)
+		if err != nil {
+			return next
+		}
+		port = p
+	}
+	value := `h3=":` + port + `"; ma=86400`
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Alt-Svc", value)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,92 @@
+package waf
+
+import (
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// anomalyFeatureNames порядок признаков вектора запроса. Изменение порядка
+// делает несовместимыми ранее обученные модели.
+var anomalyFeatureNames = []string{
+	"method",
+	"path_length",
+	"path_depth",
+	"path_id_segments",
+	"query_params",
+	"query_length",
+	"max_param_length",
+	"max_param_entropy",
+	"non_alnum_ratio",
+	"header_count",
+	"content_length",
+	"log_interval",
+}
+
+// extractAnomalyFeatures строит вектор признаков запроса.
+// st — состояние клиента для расчета интервала между запросами (может быть nil).
+func extractAnomalyFeatures(r *http.Request, st *State) []float64 {
+	f := make([]float64, len(anomalyFeatureNames))
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		f[0] = 0
+	case http.MethodPost:
+		f[0] = 1
+	case http.MethodPut, http.MethodPatch:
+		f[0] = 2
+	case http.MethodDelete:
+		f[0] = 3
+	default:
+		f[0] = 4
+	}
+
+	segments := splitPathSegments(r.URL.Path)
+	f[1] = float64(len(r.URL.Path))
+	f[2] = float64(len(segments))
+	for _, s := range segments {
+		if isIdentifierSegment(s) {
+			f[3]++
+		}
+	}
+
+	query := r.URL.Query()
+	f[4] = float64(len(query))
+	f[5] = float64(len(r.URL.RawQuery))
+	for _, values := range query {
+		for _, v := range values {
+			f[6] = math.Max(f[6], float64(len(v)))
+			f[7] = math.Max(f[7], shannonEntropy(v))
+		}
+	}
+
+	raw := r.URL.Path + r.URL.RawQuery
+	if len(raw) > 0 {
+		var other int
+		for _, c := range raw {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("/-_.", c)) {
+				other++
+			}
+		}
+		f[8] = float64(other) / float64(len(raw))
+	}
+
+	f[9] = float64(len(r.Header))
+	if r.ContentLength > 0 {
+		f[10] = float64(r.ContentLength)
+	}
+
+	if st != nil {
+		now := time.Now()
+		st.mu.Lock()
+		if last, ok := st.Meta["anomaly_last_seen"].(time.Time); ok {
+			f[11] = math.Log1p(now.Sub(last).Seconds())
+		} else {
+			f[11] = math.Log1p(3600)
+		}
+		st.Meta["anomaly_last_seen"] = now
+		st.mu.Unlock()
+	}
+	return f
+}
@@ -0,0 +1,208 @@
+package waf
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// AnomalyModel модель оценки аномальности вектора признаков запроса.
+// Реализации должны сериализоваться в JSON (экспортируемые поля), чтобы
+// модель, обученная офлайн, загружалась при старте WAF.
+type AnomalyModel interface {
+	// Train обучает модель на векторах признаков чистого трафика
+	Train(samples [][]float64) error
+	// Score возвращает оценку аномальности: чем больше, тем подозрительнее
+	Score(features []float64) float64
+	// DefaultThreshold порог оценки, выше которого запрос считается аномальным
+	DefaultThreshold() float64
+}
+
+var (
+	anomalyModelsMu sync.RWMutex
+	anomalyModels   = map[string]func() AnomalyModel{
+		"zscore":           func() AnomalyModel { return &ZScoreModel{} },
+		"isolation_forest": func() AnomalyModel { return &IsolationForestModel{} },
+	}
+)
+
+// RegisterAnomalyModel регистрирует фабрику модели под именем для использования в конфиге
+func RegisterAnomalyModel(name string, factory func() AnomalyModel) {
+	anomalyModelsMu.Lock()
+	defer anomalyModelsMu.Unlock()
+	anomalyModels[name] = factory
+}
+
+// newAnomalyModel создает пустую модель по имени
+func newAnomalyModel(name string) (AnomalyModel, error) {
+	anomalyModelsMu.RLock()
+	defer anomalyModelsMu.RUnlock()
+	factory, ok := anomalyModels[name]
+	if !ok {
+		return nil, errors.New("unknown anomaly model: " + name)
+	}
+	return factory(), nil
+}
+
+// ZScoreModel простая статистическая модель: максимум |z| по признакам
+type ZScoreModel struct {
+	Mean []float64 `json:"mean"`
+	Std  []float64 `json:"std"`
+}
+
+func (m *ZScoreModel) Train(samples [][]float64) error {
+	if len(samples) == 0 {
+		return errors.New("no training samples")
+	}
+	dim := len(samples[0])
+	m.Mean = make([]float64, dim)
+	m.Std = make([]float64, dim)
+	for _, s := range samples {
+		for i := 0; i < dim && i < len(s); i++ {
+			m.Mean[i] += s[i]
+		}
+	}
+	for i := range m.Mean {
+		m.Mean[i] /= float64(len(samples))
+	}
+	for _, s := range samples {
+		for i := 0; i < dim && i < len(s); i++ {
+			d := s[i] - m.Mean[i]
+			m.Std[i] += d * d
+		}
+	}
+	for i := range m.Std {
+		m.Std[i] = math.Sqrt(m.Std[i] / float64(len(samples)))
+	}
+	return nil
+}
+
+func (m *ZScoreModel) Score(features []float64) float64 {
+	var max float64
+	for i := 0; i < len(features) && i < len(m.Mean); i++ {
+		std := m.Std[i]
+		if std < 1e-6 {
+			std = 1e-6 + math.Abs(m.Mean[i])*0.1
+		}
+		max = math.Max(max, math.Abs(features[i]-m.Mean[i])/std)
+	}
+	return max
+}
+
+func (m *ZScoreModel) DefaultThreshold() float64 { return 6 }
+
+// isolationNode узел дерева изоляции
+type isolationNode struct {
+	Feature int            `json:"f"`
+	Split   float64        `json:"s"`
+	Size    int            `json:"n,omitempty"`
+	Left    *isolationNode `json:"l,omitempty"`
+	Right   *isolationNode `json:"r,omitempty"`
+}
+
+// IsolationForestModel лес изоляции: аномалии изолируются за меньшее число разбиений.
+// Оценка в диапазоне (0, 1), значения около 0.5 — норма.
+type IsolationForestModel struct {
+	Trees      []*isolationNode `json:"trees"`
+	SampleSize int              `json:"sample_size"`
+	NumTrees   int              `json:"num_trees"`
+}
+
+func (m *IsolationForestModel) Train(samples [][]float64) error {
+	if len(samples) == 0 {
+		return errors.New("no training samples")
+	}
+	if m.NumTrees <= 0 {
+		m.NumTrees = 100
+	}
+	if m.SampleSize <= 0 {
+		m.SampleSize = 256
+	}
+	if m.SampleSize > len(samples) {
+		m.SampleSize = len(samples)
+	}
+	maxDepth := int(math.Ceil(math.Log2(float64(m.SampleSize))))
+	rnd := rand.New(rand.NewSource(1))
+	m.Trees = make([]*isolationNode, 0, m.NumTrees)
+	for t := 0; t < m.NumTrees; t++ {
+		sub := make([][]float64, m.SampleSize)
+		for i, idx := range rnd.Perm(len(samples))[:m.SampleSize] {
+			sub[i] = samples[idx]
+		}
+		m.Trees = append(m.Trees, buildIsolationTree(sub, 0, maxDepth, rnd))
+	}
+	return nil
+}
+
+// buildIsolationTree строит дерево случайными разбиениями до изоляции точек
+func buildIsolationTree(samples [][]float64, depth, maxDepth int, rnd *rand.Rand) *isolationNode {
+	if depth >= maxDepth || len(samples) <= 1 {
+		return &isolationNode{Size: len(samples)}
+	}
+	dim := len(samples[0])
+	// Выбрать признак с ненулевым разбросом
+	for _, f := range rnd.Perm(dim) {
+		vals := make([]float64, len(samples))
+		for i, s := range samples {
+			vals[i] = s[f]
+		}
+		sort.Float64s(vals)
+		lo, hi := vals[0], vals[len(vals)-1]
+		if hi <= lo {
+			continue
+		}
+		split := lo + rnd.Float64()*(hi-lo)
+		var left, right [][]float64
+		for _, s := range samples {
+			if s[f] < split {
+				left = append(left, s)
+			} else {
+				right = append(right, s)
+			}
+		}
+		return &isolationNode{
+			Feature: f,
+			Split:   split,
+			Left:    buildIsolationTree(left, depth+1, maxDepth, rnd),
+			Right:   buildIsolationTree(right, depth+1, maxDepth, rnd),
+		}
+	}
+	return &isolationNode{Size: len(samples)}
+}
+
+// averagePathLength c(n) — средняя длина пути неуспешного поиска в BST
+func averagePathLength(n int) float64 {
+	if n <= 1 {
+		return 0
+	}
+	if n == 2 {
+		return 1
+	}
+	h := math.Log(float64(n-1)) + 0.5772156649
+	return 2*h - 2*float64(n-1)/float64(n)
+}
+
+func (m *IsolationForestModel) Score(features []float64) float64 {
+	if len(m.Trees) == 0 {
+		return 0
+	}
+	var total float64
+	for _, tree := range m.Trees {
+		node, depth := tree, 0.0
+		for node.Left != nil && node.Right != nil {
+			if node.Feature < len(features) && features[node.Feature] < node.Split {
+				node = node.Left
+			} else {
+				node = node.Right
+			}
+			depth++
+		}
+		total += depth + averagePathLength(node.Size)
+	}
+	mean := total / float64(len(m.Trees))
+	return math.Pow(2, -mean/averagePathLength(m.SampleSize))
+}
+
+func (m *IsolationForestModel) DefaultThreshold() float64 { return 0.65 }
@@ -0,0 +1,215 @@
+package waf
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// anomalyModelFile формат файла обученной модели
+type anomalyModelFile struct {
+	Type     string          `json:"type"`
+	Features []string        `json:"features"`
+	Samples  int             `json:"samples"`
+	Trained  time.Time       `json:"trained"`
+	Model    json.RawMessage `json:"model"`
+}
+
+// anomalySample строка файла записи чистого трафика
+type anomalySample struct {
+	Features []float64 `json:"features"`
+}
+
+// LoadAnomalyModel загружает обученную модель из файла
+func LoadAnomalyModel(path string) (AnomalyModel, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f anomalyModelFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+	if len(f.Features) != len(anomalyFeatureNames) {
+		return nil, fmt.Errorf("model features mismatch: got %d, want %d", len(f.Features), len(anomalyFeatureNames))
+	}
+	model, err := newAnomalyModel(f.Type)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(f.Model, model); err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+// TrainAnomalyModel обучает модель modelType на записанном трафике и сохраняет ее в outPath
+func TrainAnomalyModel(recordPath, modelType, outPath string) error {
+	in, err := os.Open(recordPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	var samples [][]float64
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		var s anomalySample
+		if err := json.Unmarshal(scanner.Bytes(), &s); err != nil || len(s.Features) != len(anomalyFeatureNames) {
+			continue
+		}
+		samples = append(samples, s.Features)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(samples) == 0 {
+		return errors.New("no valid samples in " + recordPath)
+	}
+
+	model, err := newAnomalyModel(modelType)
+	if err != nil {
+		return err
+	}
+	if err := model.Train(samples); err != nil {
+		return err
+	}
+	raw, err := json.Marshal(model)
+	if err != nil {
+		return err
+	}
+	out, err := json.Marshal(anomalyModelFile{
+		Type:     modelType,
+		Features: anomalyFeatureNames,
+		Samples:  len(samples),
+		Trained:  time.Now(),
+		Model:    raw,
+	})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(outPath, out, 0o644)
+}
+
+// AnomalyScoringMiddleware извлекает признаки запроса и либо записывает их
+// для офлайн-обучения (mode=record), либо оценивает подключаемой моделью (mode=score).
+type AnomalyScoringMiddleware struct {
+	waf       *WAF
+	mode      string // record, score
+	model     AnomalyModel
+	threshold float64
+	riskScore float64
+	action    string // log, block
+	*anomalyRecorder
+	logDetections bool
+}
+
+// anomalyRecorder файл записи признаков; открывается один раз и остается открытым
+// при перезагрузке конфигурации с тем же record_path
+type anomalyRecorder struct {
+	recordMu sync.Mutex
+	record   *os.File
+}
+
+// NewAnomalyScoringMiddlewareWithConfig создает модуль оценки аномалий из конфига
+func NewAnomalyScoringMiddlewareWithConfig(w *WAF, cfg AnomalyModelConfig) (*AnomalyScoringMiddleware, error) {
+	m := &AnomalyScoringMiddleware{
+		waf:           w,
+		mode:          cfg.Mode,
+		riskScore:     5,
+		action:        "log",
+		logDetections: true,
+	}
+	if cfg.RiskScore > 0 {
+		m.riskScore = cfg.RiskScore
+	}
+	if cfg.Action != "" {
+		m.action = cfg.Action
+	}
+
+	switch m.mode {
+	case "record":
+		key := "anomaly_model.record|" + cfg.RecordPath
+		v := w.keep(key, func() interface{} {
+			f, err := os.OpenFile(cfg.RecordPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+			if err != nil {
+				return err
+			}
+			return &anomalyRecorder{record: f}
+		})
+		if err, ok := v.(error); ok {
+			w.forget(key)
+			return nil, err
+		}
+		m.anomalyRecorder = v.(*anomalyRecorder)
+	case "score":
+		model, err := LoadAnomalyModel(cfg.ModelPath)
+		if err != nil {
+			return nil, err
+		}
+		m.model = model
+		m.threshold = model.DefaultThreshold()
+		if cfg.Threshold > 0 {
+			m.threshold = cfg.Threshold
+		}
+	default:
+		return nil, errors.New("unknown anomaly mode: " + cfg.Mode)
+	}
+	return m, nil
+}
+
+func (m *AnomalyScoringMiddleware) push(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.waf == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := ClientIP(r)
+		if m.waf.banned(r, ip) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		st := m.waf.states.Get(ip)
+		features := extractAnomalyFeatures(r, st)
+
+		if m.mode == "record" {
+			m.writeSample(features)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		score := m.model.Score(features)
+		if score > m.threshold {
+			risk := addRiskScore(st, m.riskScore)
+			ev := requestEvent(r, ip, "anomaly_model", SeverityWarning, m.action, fmt.Sprintf("Аномальный запрос от %s %s %s: оценка модели %.3f (порог %.3f), риск %.1f", ip, r.Method, r.URL.Path, score, m.threshold, risk))
+			if m.waf.decide(r, ev, m.logDetections, func() bool {
+				return m.action == "block" && forbid(w)()
+			}) {
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeSample дописывает вектор признаков в файл записи
+func (m *AnomalyScoringMiddleware) writeSample(features []float64) {
+	line, err := json.Marshal(anomalySample{Features: features})
+	if err != nil {
+		return
+	}
+	m.recordMu.Lock()
+	defer m.recordMu.Unlock()
+	if _, err := m.record.Write(append(line, '\n')); err != nil {
+		log.Printf("[WAF] Ошибка записи признаков запроса: %v", err)
+	}
+}
@@ -0,0 +1,228 @@
+package waf
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// virusScanner проверяет содержимое файла антивирусом; virus — имя найденной угрозы
+type virusScanner interface {
+	scan(ctx context.Context, content io.Reader) (virus string, err error)
+}
+
+const (
+	defaultAntivirusTimeout = 10 * time.Second
+	antivirusChunkSize      = 64 << 10
+)
+
+// newVirusScanner создает клиента антивируса из конфига; nil — антивирус не настроен
+func newVirusScanner(cfg AntivirusConfig) (virusScanner, error) {
+	timeout := defaultAntivirusTimeout
+	if cfg.TimeoutMs > 0 {
+		timeout = time.Duration(cfg.TimeoutMs) * time.Millisecond
+	}
+	switch cfg.Type {
+	case "":
+		return nil, nil
+	case "clamd":
+		network, addr := "tcp", cfg.Address
+		if p, ok := strings.CutPrefix(addr, "unix:"); ok {
+			network, addr = "unix", p
+		} else {
+			addr = strings.TrimPrefix(addr, "tcp:")
+		}
+		if addr == "" {
+			return nil, errors.New("antivirus: address is required")
+		}
+		return &clamdScanner{network: network, addr: addr, timeout: timeout}, nil
+	case "icap":
+		u, err := url.Parse(cfg.Address)
+		if err != nil || u.Scheme != "icap" || u.Host == "" {
+			return nil, fmt.Errorf("antivirus: invalid ICAP address %q", cfg.Address)
+		}
+		if u.Port() == "" {
+			u.Host = net.JoinHostPort(u.Hostname(), "1344")
+		}
+		return &icapScanner{url: u, timeout: timeout}, nil
+	default:
+		return nil, fmt.Errorf("antivirus: unknown type %q", cfg.Type)
+	}
+}
+
+// deadlineConn продлевает срок операции перед каждой записью и чтением: timeout ограничивает
+// простой антивируса, а не время передачи большого файла
+type deadlineConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c deadlineConn) Write(p []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Write(p)
+}
+
+func (c deadlineConn) Read(p []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Read(p)
+}
+
+func dialScanner(ctx context.Context, network, addr string, timeout time.Duration) (deadlineConn, error) {
+	d := net.Dialer{Timeout: timeout}
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return deadlineConn{}, err
+	}
+	return deadlineConn{Conn: conn, timeout: timeout}, nil
+}
+
+// clamdScanner передает файл clamd командой INSTREAM
+type clamdScanner struct {
+	network, addr string
+	timeout       time.Duration
+}
+
+func (s *clamdScanner) scan(ctx context.Context, content io.Reader) (string, error) {
+	conn, err := dialScanner(ctx, s.network, s.addr, s.timeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	// Отмена запроса клиентом прерывает проверку
+	defer context.AfterFunc(ctx, func() { conn.Close() })()
+
+	if _, err := io.WriteString(conn, "zINSTREAM\x00"); err != nil {
+		return "", err
+	}
+	buf := make([]byte, 4+antivirusChunkSize)
+	for {
+		n, rerr := content.Read(buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf, uint32(n))
+			if _, err := conn.Write(buf[:4+n]); err != nil {
+				// clamd закрывает соединение при превышении StreamMaxLength; ответ объясняет причину
+				if reply, rerr := readClamdReply(conn); rerr == nil {
+					return "", fmt.Errorf("clamd: %s", reply)
+				}
+				return "", err
+			}
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return "", rerr
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return "", err
+	}
+	reply, err := readClamdReply(conn)
+	if err != nil {
+		return "", err
+	}
+	// stream: OK | stream: Eicar-Signature FOUND | ... ERROR
+	reply = strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case reply == "OK":
+		return "", nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return strings.TrimSuffix(reply, " FOUND"), nil
+	}
+	return "", fmt.Errorf("clamd: %s", reply)
+}
+
+func readClamdReply(conn io.Reader) (string, error) {
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		return "", err
+	}
+	return strings.TrimSpace(strings.TrimRight(reply, "\x00")), nil
+}
+
+// icapScanner передает файл ICAP-серверу (RFC 3507) как тело ответа RESPMOD
+type icapScanner struct {
+	url     *url.URL
+	timeout time.Duration
+}
+
+func (s *icapScanner) scan(ctx context.Context, content io.Reader) (string, error) {
+	conn, err := dialScanner(ctx, "tcp", s.url.Host, s.timeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	// Отмена запроса клиентом прерывает проверку
+	defer context.AfterFunc(ctx, func() { conn.Close() })()
+
+	resHdr := "HTTP/1.1 200 OK\r\nContent-Type: application/octet-stream\r\n\r\n"
+	w := bufio.NewWriterSize(conn, antivirusChunkSize+16)
+	fmt.Fprintf(w, "RESPMOD %s ICAP/1.0\r\nHost: %s\r\nAllow: 204\r\nEncapsulated: res-hdr=0, res-body=%d\r\n\r\n%s", s.url, s.url.Host, len(resHdr), resHdr)
+	buf := make([]byte, antivirusChunkSize)
+	for {
+		n, rerr := content.Read(buf)
+		if n > 0 {
+			fmt.Fprintf(w, "%x\r\n", n)
+			w.Write(buf[:n])
+			w.WriteString("\r\n")
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return "", rerr
+		}
+	}
+	w.WriteString("0\r\n\r\n")
+	if err := w.Flush(); err != nil {
+		return "", err
+	}
+
+	tp := textproto.NewReader(bufio.NewReader(conn))
+	status, err := tp.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	proto, rest, _ := strings.Cut(status, " ")
+	code, _, _ := strings.Cut(rest, " ")
+	if !strings.HasPrefix(proto, "ICAP/") {
+		return "", fmt.Errorf("icap: malformed status line %q", status)
+	}
+	h, err := tp.ReadMIMEHeader()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	switch n, _ := strconv.Atoi(code); n {
+	case 204:
+		return "", nil
+	case 200:
+		// Сервер заменил ответ (страница блокировки): файл заражен или запрещен
+		for _, k := range []string{"X-Virus-Id", "X-Infection-Found", "X-Violations-Found"} {
+			if v := h.Get(k); v != "" {
+				return icapThreat(v), nil
+			}
+		}
+		return "blocked by ICAP server", nil
+	default:
+		return "", fmt.Errorf("icap: %s", rest)
+	}
+}
+
+// icapThreat имя угрозы из X-Infection-Found ("Type=0; Resolution=2; Threat=Eicar;")
+func icapThreat(v string) string {
+	for _, f := range strings.Split(v, ";") {
+		if t, ok := strings.CutPrefix(strings.TrimSpace(f), "Threat="); ok {
+			return t
+		}
+	}
+	return strings.TrimSpace(v)
+}
@@ -0,0 +1,198 @@
+package waf
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditRecord запись журнала административных действий
+type AuditRecord struct {
+	Time   time.Time   `json:"time"`
+	Actor  string      `json:"actor"`  // кто выполнил действие
+	Action string      `json:"action"` // ban, unban, config_change, ...
+	Target string      `json:"target,omitempty"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+	Remote string      `json:"remote,omitempty"` // адрес, с которого пришел запрос
+	Prev   string      `json:"prev"`             // SHA-256 предыдущей строки журнала
+}
+
+// auditLog журнал только для дозаписи в формате JSON Lines. Каждая запись содержит хэш
+// предыдущей строки, поэтому удаление или правка записей обнаруживается проверкой цепочки.
+type auditLog struct {
+	mu   sync.Mutex
+	f    *os.File // nil — записи пишутся в журнал WAF
+	prev string
+}
+
+// openAuditLog открывает файл на дозапись и восстанавливает хэш последней записи
+func openAuditLog(path string) (*auditLog, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	a := &auditLog{f: f}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64<<10), 16<<20)
+	for sc.Scan() {
+		if line := sc.Bytes(); len(line) > 0 {
+			a.prev = lineHash(line)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return a, nil
+}
+
+func lineHash(line []byte) string {
+	sum := sha256.Sum256(line)
+	return hex.EncodeToString(sum[:])
+}
+
+// record дописывает запись; ошибка записи не отменяет действие, но попадает в журнал WAF
+func (a *auditLog) record(rec AuditRecord) {
+	if rec.Time.IsZero() {
+		rec.Time = time.Now()
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	rec.Prev = a.prev
+	line, err := json.Marshal(rec)
+	if err != nil {
+		log.Printf("[WAF] audit: %v", err)
+		return
+	}
+	if a.f == nil {
+		log.Printf("[AUDIT] %s", line)
+		a.prev = lineHash(line)
+		return
+	}
+	if _, err := a.f.Write(append(line, '\n')); err != nil {
+		log.Printf("[WAF] audit: ошибка записи: %v", err)
+		return
+	}
+	if err := a.f.Sync(); err != nil {
+		log.Printf("[WAF] audit: %v", err)
+	}
+	a.prev = lineHash(line)
+}
+
+// SetAudit направляет журнал административных действий в файл
+func (w *WAF) SetAudit(cfg AuditConfig) error {
+	if cfg.Path == "" {
+		return nil
+	}
+	a, err := openAuditLog(cfg.Path)
+	if err != nil {
+		return err
+	}
+	w.audit = a
+	return nil
+}
+
+type adminActorKey struct{}
+
+// withAdminActor сохраняет в контексте идентификатор администратора
+func withAdminActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, adminActorKey{}, actor)
+}
+
+// adminActor идентификатор выполнившего запрос администратора
+func adminActor(r *http.Request) string {
+	if actor, ok := r.Context().Value(adminActorKey{}).(string); ok {
+		return actor
+	}
+	return "anonymous"
+}
+
+// auditRequest записывает действие, выполненное через API администратора
+func (w *WAF) auditRequest(r *http.Request, action, target string, before, after interface{}) {
+	w.audit.record(AuditRecord{
+		Actor:  adminActor(r),
+		Action: action,
+		Target: target,
+		Before: before,
+		After:  after,
+		Remote: r.RemoteAddr,
+	})
+}
+
+// secretKeys части имен полей конфигурации, значения которых скрываются в журнале и API
+var secretKeys = []string{"secret", "password", "token", "key", "pass"}
+
+// redactConfig возвращает конфигурацию в виде JSON-объекта со скрытыми секретами
+func redactConfig(cfg *Config) interface{} {
+	if cfg == nil {
+		return nil
+	}
+	raw, err := json.Marshal(cfg)
+	if err != nil {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil
+	}
+	return redactValue(v)
+}
+
+func redactValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for k, val := range x {
+			if s, ok := val.(string); ok && s != "" && isSecretKey(k) {
+				x[k] = "***"
+				continue
+			}
+			if h, ok := val.(map[string]interface{}); ok && isHeadersKey(k) {
+				// Значения заголовков (Authorization, ключи API бэкенда) скрываются целиком, как в secrets.go
+				for name, hv := range h {
+					if s, ok := hv.(string); ok && s != "" {
+						h[name] = "***"
+					}
+				}
+				continue
+			}
+			x[k] = redactValue(val)
+		}
+	case []interface{}:
+		for i := range x {
+			x[i] = redactValue(x[i])
+		}
+	}
+	return v
+}
+
+// isHeadersKey поле с объектом заголовков: все его значения считаются секретами
+func isHeadersKey(k string) bool {
+	return strings.HasSuffix(strings.ToLower(k), "headers")
+}
+
+func isSecretKey(k string) bool {
+	k = strings.ToLower(k)
+	for _, s := range secretKeys {
+		if strings.Contains(k, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package waf
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRedactConfigHeaders(t *testing.T) {
+	cfg := &Config{}
+	cfg.Verdict.Headers = map[string]string{"Authorization": "Bearer verdict-token"}
+	cfg.Rewrite.Request.SetHeaders = map[string]string{"X-Backend-Auth": "rewrite-secret"}
+	cfg.Rewrite.Request.RemoveHeaders = []string{"X-Debug"}
+	cfg.Signature.LogMatches = true
+
+	data, err := json.Marshal(redactConfig(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+	for _, leak := range []string{"verdict-token", "rewrite-secret"} {
+		if strings.Contains(out, leak) {
+			t.Errorf("redacted config contains %q: %s", leak, out)
+		}
+	}
+	// Имена заголовков и списки без значений не скрываются
+	for _, keep := range []string{`"Authorization":"***"`, `"X-Debug"`} {
+		if !strings.Contains(out, keep) {
+			t.Errorf("redacted config lacks %s: %s", keep, out)
+		}
+	}
+}
+
+func TestDiffConfigsRedactsHeaders(t *testing.T) {
+	before, after := &Config{}, &Config{}
+	after.Verdict.Headers = map[string]string{"Authorization": "Bearer new-token"}
+	data, err := json.Marshal(diffConfigs(before, after))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "new-token") {
+		t.Errorf("diff contains header value: %s", data)
+	}
+}
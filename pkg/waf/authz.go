@@ -0,0 +1,109 @@
+package waf
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// maxAuthzDenyBody ограничение тела ответа отказа, передаваемого внешнему прокси
+const maxAuthzDenyBody = 64 << 10
+
+// authzVerdict решение по запросу, проверенному цепью модулей без передачи бэкенду
+type authzVerdict struct {
+	allowed bool
+
+	// Разрешенный запрос: заголовки, которые модули добавили или изменили (например, claims JWT),
+	// и удаленные ими заголовки — внешний прокси применяет их к запросу к бэкенду
+	setHeaders    http.Header
+	removeHeaders []string
+
+	// Отклоненный запрос: ответ, который внешний прокси отдает клиенту
+	status int
+	header http.Header
+	body   []byte
+}
+
+// authzPassKey ключ контекста, через который конец цепи сообщает, что запрос пропущен
+type authzPassKey struct{}
+
+// authzChain цепь модулей для внешней авторизации (ext_authz, forward-auth)
+type authzChain struct {
+	once    sync.Once
+	handler http.Handler
+}
+
+// authorize прогоняет запрос через цепь модулей. Запрос разрешен, если дошел до конца цепи;
+// иначе ответ модуля (403, 429, редирект на challenge, ответ на preflight) становится ответом клиенту.
+func (w *WAF) authorize(r *http.Request) authzVerdict {
+	w.authz.once.Do(func() {
+		w.authz.handler = w.Handler(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+			if passed, ok := req.Context().Value(authzPassKey{}).(**http.Request); ok {
+				*passed = req
+			}
+		}))
+	})
+
+	before := r.Header.Clone()
+	var passed *http.Request
+	rec := &verdictRecorder{header: make(http.Header), status: http.StatusOK}
+	w.authz.handler.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), authzPassKey{}, &passed)))
+
+	if passed == nil {
+		return authzVerdict{status: rec.status, header: rec.header, body: rec.body}
+	}
+	v := authzVerdict{allowed: true, setHeaders: make(http.Header)}
+	for k, vals := range passed.Header {
+		if !equalValues(before[k], vals) {
+			v.setHeaders[k] = vals
+		}
+	}
+	for k := range before {
+		if _, ok := passed.Header[k]; !ok {
+			v.removeHeaders = append(v.removeHeaders, k)
+		}
+	}
+	return v
+}
+
+func equalValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// verdictRecorder сохраняет ответ модуля, отклонившего запрос
+type verdictRecorder struct {
+	header      http.Header
+	status      int
+	body        []byte
+	wroteHeader bool
+}
+
+func (rec *verdictRecorder) Header() http.Header { return rec.header }
+
+func (rec *verdictRecorder) WriteHeader(code int) {
+	if rec.wroteHeader {
+		return
+	}
+	rec.wroteHeader = true
+	rec.status = code
+}
+
+func (rec *verdictRecorder) Write(b []byte) (int, error) {
+	rec.WriteHeader(http.StatusOK)
+	if room := maxAuthzDenyBody - len(rec.body); room > 0 {
+		if len(b) > room {
+			rec.body = append(rec.body, b[:room]...)
+		} else {
+			rec.body = append(rec.body, b...)
+		}
+	}
+	return len(b), nil
+}
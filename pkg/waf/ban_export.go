@@ -0,0 +1,169 @@
+package waf
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Форматы экспорта банов
+const (
+	BanExportNftables = "nftables" // скрипт для nft -f
+	BanExportIPSet    = "ipset"    // файл для ipset restore
+)
+
+// BanExportOptions настройки экспорта банов в правила сетевого фильтра
+type BanExportOptions struct {
+	Format string // nftables (по умолчанию) или ipset
+	Set    string // имя набора адресов IPv4; набор IPv6 — с суффиксом 6. По умолчанию waf_banned
+	Table  string // семейство и таблица nftables, по умолчанию "inet waf"
+	Tenant string // арендатор; пусто — основной WAF
+}
+
+var (
+	banExportName  = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,31}$`)
+	banExportTable = regexp.MustCompile(`^(ip|ip6|inet|arp|bridge|netdev) [A-Za-z0-9_.-]{1,31}$`)
+)
+
+func (o *BanExportOptions) normalize() error {
+	if o.Format == "" {
+		o.Format = BanExportNftables
+	}
+	if o.Set == "" {
+		o.Set = "waf_banned"
+	}
+	if o.Table == "" {
+		o.Table = "inet waf"
+	}
+	switch {
+	case o.Format != BanExportNftables && o.Format != BanExportIPSet:
+		return fmt.Errorf("unknown format %q", o.Format)
+	case !banExportName.MatchString(o.Set):
+		return fmt.Errorf("invalid set name %q", o.Set)
+	case !banExportTable.MatchString(o.Table):
+		return fmt.Errorf("invalid nftables table %q, expected \"<family> <name>\"", o.Table)
+	}
+	return nil
+}
+
+// RenderBans выводит баны в формате сетевого фильтра: набор содержит все забаненные адреса
+// с оставшимся временем бана, так что сетевая блокировка снимается одновременно с баном WAF.
+// Идентификаторы, не являющиеся IP (сессии, аккаунты, составные ключи), пропускаются.
+// Вывод заменяет содержимое наборов целиком и применяется повторно без ошибок
+func RenderBans(bans map[string]time.Time, opts BanExportOptions, out io.Writer) error {
+	if err := opts.normalize(); err != nil {
+		return err
+	}
+	now := time.Now()
+	type element struct {
+		addr    netip.Addr
+		timeout int64 // секунды до окончания бана
+	}
+	var v4, v6 []element
+	for id, until := range bans {
+		addr, err := netip.ParseAddr(id)
+		if err != nil || !until.After(now) {
+			continue
+		}
+		e := element{addr.Unmap(), int64(math.Ceil(until.Sub(now).Seconds()))}
+		if e.addr.Is4() {
+			v4 = append(v4, e)
+		} else {
+			v6 = append(v6, e)
+		}
+	}
+	for _, list := range [][]element{v4, v6} {
+		sort.Slice(list, func(i, j int) bool { return list[i].addr.Less(list[j].addr) })
+	}
+
+	set6 := opts.Set + "6"
+	fmt.Fprintf(out, "# WAF bans %s: IPv4 %d, IPv6 %d\n", now.UTC().Format(time.RFC3339), len(v4), len(v6))
+	if opts.Format == BanExportIPSet {
+		fmt.Fprintf(out, "create %s hash:ip family inet timeout 0\n", opts.Set)
+		fmt.Fprintf(out, "create %s hash:ip family inet6 timeout 0\n", set6)
+		fmt.Fprintf(out, "flush %s\nflush %s\n", opts.Set, set6)
+		for _, e := range v4 {
+			fmt.Fprintf(out, "add %s %s timeout %d\n", opts.Set, e.addr, e.timeout)
+		}
+		for _, e := range v6 {
+			fmt.Fprintf(out, "add %s %s timeout %d\n", set6, e.addr, e.timeout)
+		}
+		return nil
+	}
+
+	fmt.Fprintf(out, "add table %s\n", opts.Table)
+	fmt.Fprintf(out, "add set %s %s { type ipv4_addr; flags timeout; }\n", opts.Table, opts.Set)
+	fmt.Fprintf(out, "add set %s %s { type ipv6_addr; flags timeout; }\n", opts.Table, set6)
+	fmt.Fprintf(out, "flush set %s %s\nflush set %s %s\n", opts.Table, opts.Set, opts.Table, set6)
+	for _, s := range []struct {
+		name string
+		list []element
+	}{{opts.Set, v4}, {set6, v6}} {
+		if len(s.list) == 0 {
+			continue
+		}
+		elems := make([]string, len(s.list))
+		for i, e := range s.list {
+			elems[i] = fmt.Sprintf("%s timeout %ds", e.addr, e.timeout)
+		}
+		fmt.Fprintf(out, "add element %s %s { %s }\n", opts.Table, s.name, strings.Join(elems, ", "))
+	}
+	return nil
+}
+
+// adminBanExport баны в формате сетевого фильтра: ?format=nftables|ipset&set=&table=&tenant=
+func (w *WAF) adminBanExport(rw http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	opts := BanExportOptions{Format: q.Get("format"), Set: q.Get("set"), Table: q.Get("table"), Tenant: q.Get("tenant")}
+	bans := w.tenantBans(opts.Tenant)
+	if bans == nil {
+		writeJSON(rw, http.StatusNotFound, map[string]string{"error": "unknown tenant"})
+		return
+	}
+	if err := opts.normalize(); err != nil {
+		writeJSON(rw, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	RenderBans(bans.Active(), opts, rw)
+}
+
+// FetchBans загружает экспорт банов из API администратора работающего WAF
+func FetchBans(adminURL, apiKey string, opts BanExportOptions, out io.Writer) error {
+	if adminURL == "" {
+		return errors.New("admin URL is required")
+	}
+	q := url.Values{}
+	for k, v := range map[string]string{"format": opts.Format, "set": opts.Set, "table": opts.Table, "tenant": opts.Tenant} {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(adminURL, "/")+"/admin/api/bans/export?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if apiKey != "" {
+		req.Header.Set("X-API-Key", apiKey)
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("admin API responded with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	_, err = io.Copy(out, resp.Body)
+	return err
+}
@@ -0,0 +1,259 @@
+package waf
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BenchOptions параметры нагрузочного прогона цепи модулей
+type BenchOptions struct {
+	Requests      int     // запросов на каждый этап; по умолчанию 20000
+	AttackPercent float64 // доля запросов с атаками, 0–100
+	Concurrency   int     // горутин при замере пропускной способности всей цепи; по умолчанию GOMAXPROCS
+	Seed          int64
+}
+
+// BenchStage вклад одного модуля: разница между цепью до него включительно и цепью без него
+type BenchStage struct {
+	Module      string  `json:"module"`
+	NsPerReq    float64 `json:"ns_per_req"`
+	AllocsPerOp float64 `json:"allocs_per_req"`
+	BytesPerOp  float64 `json:"bytes_per_req"`
+	Blocked     int     `json:"blocked"` // запросы, остановленные цепью до этого модуля включительно
+}
+
+// BenchReport итоги прогона
+type BenchReport struct {
+	Requests   int          `json:"requests"`
+	Baseline   BenchStage   `json:"baseline"` // построение запроса и канонизация пути без модулей
+	Stages     []BenchStage `json:"stages"`
+	Total      BenchStage   `json:"total"` // вся цепь без учета baseline
+	Throughput float64      `json:"throughput_rps"`
+}
+
+// benchWriter принимает ответ цепи без буферизации тела
+type benchWriter struct {
+	header http.Header
+	status int
+}
+
+func (b *benchWriter) Header() http.Header         { return b.header }
+func (b *benchWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (b *benchWriter) WriteHeader(code int) {
+	if b.status == 0 {
+		b.status = code
+	}
+}
+
+// benchRequest заготовка синтетического запроса
+type benchRequest struct {
+	method, uri, contentType, body, ip, ua string
+}
+
+var benchAttacks = []string{
+	"1' OR '1'='1",
+	"<script>alert(document.cookie)</script>",
+	"../../../../etc/passwd",
+	"1 UNION SELECT username,password FROM users--",
+	"<img src=x onerror=alert(1)>",
+}
+
+// benchTraffic синтетический трафик: страницы, API, формы и JSON от множества клиентов
+func benchTraffic(n int, attackPercent float64, seed int64) []benchRequest {
+	rnd := rand.New(rand.NewSource(seed))
+	uas := []string{
+		"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0 Safari/537.36",
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1",
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:127.0) Gecko/20100101 Firefox/127.0",
+	}
+	reqs := make([]benchRequest, n)
+	for i := range reqs {
+		// Клиентов столько, чтобы ограничение частоты не срабатывало на обычном трафике
+		ip := net.IPv4(198, 18+byte(i>>16&1), byte(i>>8), byte(i)).String()
+		value := fmt.Sprintf("item-%d", rnd.Intn(1000))
+		if rnd.Float64()*100 < attackPercent {
+			value = benchAttacks[rnd.Intn(len(benchAttacks))]
+		}
+		r := benchRequest{method: http.MethodGet, ip: ip, ua: uas[rnd.Intn(len(uas))]}
+		switch rnd.Intn(4) {
+		case 0:
+			r.uri = fmt.Sprintf("/products/%d?q=%s&page=%d", rnd.Intn(500), url.QueryEscape(value), rnd.Intn(10))
+		case 1:
+			r.uri = fmt.Sprintf("/api/v2/users/%d/orders?sort=%s", rnd.Intn(10000), url.QueryEscape(value))
+		case 2:
+			r.method, r.uri, r.contentType = http.MethodPost, "/login", "application/x-www-form-urlencoded"
+			r.body = url.Values{"username": {value}, "password": {"hunter2"}}.Encode()
+		default:
+			r.method, r.uri, r.contentType = http.MethodPost, "/api/v2/cart", "application/json"
+			r.body = fmt.Sprintf(`{"sku":%q,"qty":%d,"note":"gift"}`, value, rnd.Intn(5)+1)
+		}
+		reqs[i] = r
+	}
+	return reqs
+}
+
+func (br *benchRequest) build() *http.Request {
+	var body io.Reader = http.NoBody
+	if br.body != "" {
+		body = strings.NewReader(br.body)
+	}
+	r, _ := http.NewRequest(br.method, "http://localhost"+br.uri, body)
+	r.RequestURI = br.uri
+	r.Host = "localhost"
+	r.RemoteAddr = br.ip + ":40000"
+	r.Header.Set("User-Agent", br.ua)
+	r.Header.Set("Accept", "text/html,application/json;q=0.9,*/*;q=0.8")
+	r.Header.Set("Accept-Language", "ru-RU,ru;q=0.9,en;q=0.8")
+	if br.contentType != "" {
+		r.Header.Set("Content-Type", br.contentType)
+	}
+	return r
+}
+
+// benchPass прогоняет трафик через handler в одной горутине
+func benchPass(handler http.Handler, traffic []benchRequest) (stage BenchStage) {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for i := range traffic {
+		rw := &benchWriter{header: make(http.Header)}
+		handler.ServeHTTP(rw, traffic[i].build())
+		if rw.status >= 400 {
+			stage.Blocked++
+		}
+	}
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+	n := float64(len(traffic))
+	stage.NsPerReq = float64(elapsed.Nanoseconds()) / n
+	stage.AllocsPerOp = float64(after.Mallocs-before.Mallocs) / n
+	stage.BytesPerOp = float64(after.TotalAlloc-before.TotalAlloc) / n
+	return stage
+}
+
+// Bench прогоняет синтетический трафик через цепь модулей cfg без бэкенда и измеряет
+// задержку и выделения памяти каждого модуля. Вклад модуля — разница между прогонами
+// цепи до него включительно и без него; каждый прогон идет на новом WAF, чтобы
+// состояния клиентов одного этапа не влияли на следующий.
+func Bench(cfg *Config, opts BenchOptions) (*BenchReport, error) {
+	if opts.Requests <= 0 {
+		opts.Requests = 20000
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = runtime.GOMAXPROCS(0)
+	}
+	traffic := benchTraffic(opts.Requests, opts.AttackPercent, opts.Seed)
+	noop := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	chain := []string{"context", "rate_limit", "signature"}
+	if cfg != nil && len(cfg.MiddlewareChain) > 0 {
+		chain = cfg.MiddlewareChain
+	}
+	// stage собирает новый WAF и первые k модулей цепи
+	stage := func(k int) (http.Handler, []string, error) {
+		w, err := newOfflineWAF(cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		var names []string
+		var mws []Middleware
+		for _, name := range chain {
+			if len(mws) == k {
+				break
+			}
+			m, err := newChainMiddleware(w, name, cfg)
+			if errors.Is(err, errUnknownMiddleware) {
+				continue
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			if m != nil {
+				names, mws = append(names, name), append(mws, m)
+			}
+		}
+		return w.wrap(mws, noop), names, nil
+	}
+
+	_, names, err := stage(len(chain))
+	if err != nil {
+		return nil, err
+	}
+	rep := &BenchReport{Requests: opts.Requests}
+	h0, _, _ := stage(0)
+	benchPass(h0, traffic) // прогрев: первый проход медленнее из-за холодных кешей
+	rep.Baseline = benchPass(h0, traffic)
+	rep.Baseline.Module = "baseline"
+
+	prev := rep.Baseline
+	for k := 1; k <= len(names); k++ {
+		hk, _, err := stage(k)
+		if err != nil {
+			return nil, err
+		}
+		cur := benchPass(hk, traffic)
+		rep.Stages = append(rep.Stages, BenchStage{
+			Module:      names[k-1],
+			NsPerReq:    cur.NsPerReq - prev.NsPerReq,
+			AllocsPerOp: cur.AllocsPerOp - prev.AllocsPerOp,
+			BytesPerOp:  cur.BytesPerOp - prev.BytesPerOp,
+			Blocked:     cur.Blocked,
+		})
+		prev = cur
+	}
+	rep.Total = BenchStage{
+		Module:      "total",
+		NsPerReq:    prev.NsPerReq - rep.Baseline.NsPerReq,
+		AllocsPerOp: prev.AllocsPerOp - rep.Baseline.AllocsPerOp,
+		BytesPerOp:  prev.BytesPerOp - rep.Baseline.BytesPerOp,
+		Blocked:     prev.Blocked,
+	}
+
+	// Пропускная способность всей цепи при параллельной обработке на отдельном WAF
+	h, _, err := stage(len(chain))
+	if err != nil {
+		return nil, err
+	}
+	var wg sync.WaitGroup
+	start := time.Now()
+	for g := 0; g < opts.Concurrency; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := g; i < len(traffic); i += opts.Concurrency {
+				h.ServeHTTP(&benchWriter{header: make(http.Header)}, traffic[i].build())
+			}
+		}(g)
+	}
+	wg.Wait()
+	rep.Throughput = float64(len(traffic)) / time.Since(start).Seconds()
+	return rep, nil
+}
+
+// WriteText выводит таблицу вклада модулей
+func (r *BenchReport) WriteText(out io.Writer) {
+	fmt.Fprintf(out, "Запросов на этап: %d\n", r.Requests)
+	fmt.Fprintf(out, "%-20s %12s %12s %12s %9s\n", "модуль", "нс/запрос", "alloc/запрос", "Б/запрос", "отказано")
+	row := func(s BenchStage) {
+		fmt.Fprintf(out, "%-20s %12.0f %12.1f %12.0f %9d\n", s.Module, s.NsPerReq, s.AllocsPerOp, s.BytesPerOp, s.Blocked)
+	}
+	row(r.Baseline)
+	for _, s := range r.Stages {
+		row(s)
+	}
+	row(r.Total)
+	fmt.Fprintf(out, "Пропускная способность цепи: %.0f запросов/с\n", r.Throughput)
+}
@@ -0,0 +1,47 @@
+package waf
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+)
+
+// peekBody читает не более max байт тела и возвращает их, восстанавливая r.Body
+// так, что бэкенд получит тело целиком. Остаток тела не копируется.
+func peekBody(r *http.Request, max int64) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	head, err := io.ReadAll(io.LimitReader(r.Body, max))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(head), r.Body), r.Body}
+	return head, err
+}
+
+// formValues разбирает url-encoded тело из уже прочитанного фрагмента
+func formValues(r *http.Request, head []byte) url.Values {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/x-www-form-urlencoded" || len(head) == 0 {
+		return nil
+	}
+	form, err := url.ParseQuery(string(head))
+	if err != nil {
+		return nil
+	}
+	return form
+}
+
+// paramsOf собирает параметры query и url-encoded тела запроса
+func paramsOf(r *http.Request) url.Values {
+	params := r.URL.Query()
+	if head, err := peekBody(r, maxCredentialBodySize); err == nil {
+		for k, vs := range formValues(r, head) {
+			params[k] = append(params[k], vs...)
+		}
+	}
+	return params
+}
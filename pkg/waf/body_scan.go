@@ -0,0 +1,160 @@
+package waf
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// defaultMaxBodyInspectSize сколько байт тела проверяется по умолчанию; остаток передается без проверки
+	defaultMaxBodyInspectSize = 64 << 10
+	// bodyScanChunkSize размер порции чтения тела
+	bodyScanChunkSize = 4 << 10
+	// maxBodyTokenSize значения длиннее разбиваются на части, чтобы память на значение была ограничена
+	maxBodyTokenSize = 8 << 10
+)
+
+// виды тел для потокового выделения значений
+const (
+	bodyKindText = iota
+	bodyKindForm
+	bodyKindJSON
+)
+
+// bodyValueScanner выделяет значения из тела запроса по мере чтения порций:
+// поля и значения url-encoded формы, строки JSON, строки текста.
+// Хранит только незавершенное значение, а не все тело.
+type bodyValueScanner struct {
+	kind     int
+	partial  []byte
+	inString bool
+	escape   bool
+	emit     func(string) bool // true — прекратить разбор (найдено совпадение)
+}
+
+// feed разбирает очередную порцию тела
+func (s *bodyValueScanner) feed(chunk []byte) bool {
+	for _, c := range chunk {
+		switch s.kind {
+		case bodyKindForm:
+			if c == '&' || c == '=' {
+				if s.flush() {
+					return true
+				}
+				continue
+			}
+		case bodyKindJSON:
+			if !s.inString {
+				if c == '"' {
+					s.inString = true
+				}
+				continue
+			}
+			if s.escape {
+				s.escape = false
+			} else if c == '\\' {
+				s.escape = true
+			} else if c == '"' {
+				s.inString = false
+				if s.flush() {
+					return true
+				}
+				continue
+			}
+		default:
+			if c == '\n' {
+				if s.flush() {
+					return true
+				}
+				continue
+			}
+		}
+		s.partial = append(s.partial, c)
+		if len(s.partial) >= maxBodyTokenSize && !s.escape && s.flush() {
+			return true
+		}
+	}
+	return false
+}
+
+// flush передает накопленное значение в emit
+func (s *bodyValueScanner) flush() bool {
+	if len(s.partial) == 0 {
+		return false
+	}
+	raw := s.partial
+	s.partial = s.partial[:0]
+
+	value := string(raw)
+	switch s.kind {
+	case bodyKindForm:
+		if v, err := url.QueryUnescape(value); err == nil {
+			value = v
+		}
+	case bodyKindJSON:
+		var v string
+		if json.Unmarshal(append(append([]byte{'"'}, raw...), '"'), &v) == nil {
+			value = v
+		}
+	}
+	return s.emit(value)
+}
+
+// scanBody потоково проверяет не более limit байт тела, передавая выделенные значения в emit.
+// Проверенная часть тела возвращается в r.Body, остаток передается бэкенду без копирования.
+// Проверяются формы, JSON, XML и text/*; multipart и бинарные тела пропускаются.
+// Возвращает true, если emit сообщил о совпадении.
+func scanBody(r *http.Request, limit int64, emit func(string) bool) (bool, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return false, nil
+	}
+	sc := &bodyValueScanner{emit: emit}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		sc.kind = bodyKindForm
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		sc.kind = bodyKindJSON
+	case strings.HasPrefix(mediaType, "text/") || mediaType == "application/xml" || strings.HasSuffix(mediaType, "+xml"):
+		sc.kind = bodyKindText
+	default:
+		return false, nil
+	}
+
+	var head []byte
+	buf := make([]byte, bodyScanChunkSize)
+	matched := false
+	var readErr error
+	for int64(len(head)) < limit && !matched {
+		n := int64(len(buf))
+		if rest := limit - int64(len(head)); rest < n {
+			n = rest
+		}
+		read, err := r.Body.Read(buf[:n])
+		if read > 0 {
+			head = append(head, buf[:read]...)
+			matched = sc.feed(buf[:read])
+		}
+		if err != nil {
+			if err != io.EOF {
+				readErr = err
+			}
+			break
+		}
+	}
+	if !matched {
+		// Незавершенное значение (в том числе обрезанное лимитом) тоже проверяется
+		matched = sc.flush()
+	}
+
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(head), r.Body), r.Body}
+	return matched, readErr
+}
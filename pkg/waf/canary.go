@@ -0,0 +1,113 @@
+package waf
+
+import (
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Группы клиентов canary-правила
+const (
+	cohortControl = "control" // правило только записывается в журнал
+	cohortCanary  = "canary"  // правило применяется
+)
+
+// canarySlice положение клиента в диапазоне [0, 100): клиент входит в canary-группу правила
+// с долей p, если slice < p. Положение зависит только от клиента, поэтому при увеличении доли
+// клиенты из canary-группы в ней остаются, и все canary-правила применяются к одним клиентам
+func canarySlice(id string) float64 {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return float64(h.Sum32()%10000) / 100
+}
+
+// canaryCohortStats счетчики группы
+type canaryCohortStats struct {
+	requests atomic.Uint64 // запросы, на которых проверялось правило
+	hits     atomic.Uint64 // совпадения правила
+	enforced atomic.Uint64 // действие правила выполнено
+}
+
+// canaryRuleStats счетчики canary-правила по группам; сбрасываются при смене доли
+type canaryRuleStats struct {
+	percent float64
+	action  string
+	since   time.Time
+	control canaryCohortStats
+	canary  canaryCohortStats
+}
+
+func (s *canaryRuleStats) cohort(canary bool) *canaryCohortStats {
+	if canary {
+		return &s.canary
+	}
+	return &s.control
+}
+
+// canaryStats счетчики canary-правил WAF по имени правила. Хранятся в WAF, а не в модуле,
+// чтобы перезагрузка конфигурации с той же долей не сбрасывала их
+type canaryStats struct {
+	mu    sync.Mutex
+	rules map[string]*canaryRuleStats
+}
+
+func newCanaryStats() *canaryStats {
+	return &canaryStats{rules: make(map[string]*canaryRuleStats)}
+}
+
+// rule счетчики правила с долей percent и действием action; прежние сохраняются, если они не менялись
+func (c *canaryStats) rule(name string, percent float64, action string) *canaryRuleStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s := c.rules[name]; s != nil && s.percent == percent && s.action == action {
+		return s
+	}
+	s := &canaryRuleStats{percent: percent, action: action, since: time.Now()}
+	c.rules[name] = s
+	return s
+}
+
+// adminRulesCanary счетчики canary-правил по группам; арендатор задается параметром tenant
+func (w *WAF) adminRulesCanary(rw http.ResponseWriter, r *http.Request) {
+	tw := w
+	if tenant := r.URL.Query().Get("tenant"); tenant != "" {
+		if tw = w.Tenant(tenant); tw == nil {
+			writeJSON(rw, http.StatusNotFound, map[string]string{"error": "unknown tenant"})
+			return
+		}
+	}
+	type cohort struct {
+		Requests uint64 `json:"requests"`
+		Hits     uint64 `json:"hits"`
+		Enforced uint64 `json:"enforced"`
+	}
+	type rule struct {
+		Rule          string    `json:"rule"`
+		Action        string    `json:"action"`
+		CanaryPercent float64   `json:"canary_percent"`
+		Since         time.Time `json:"since"`
+		Canary        cohort    `json:"canary"`
+		Control       cohort    `json:"control"` // enforced всегда 0: группа только записывается в журнал
+	}
+	snapshot := func(c *canaryCohortStats) cohort {
+		return cohort{Requests: c.requests.Load(), Hits: c.hits.Load(), Enforced: c.enforced.Load()}
+	}
+	rules := []rule{}
+	tw.canary.mu.Lock()
+	for name, s := range tw.canary.rules {
+		rules = append(rules, rule{
+			Rule:          name,
+			Action:        s.action,
+			CanaryPercent: s.percent,
+			Since:         s.since,
+			Canary:        snapshot(&s.canary),
+			Control:       snapshot(&s.control),
+		})
+	}
+	tw.canary.mu.Unlock()
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Rule < rules[j].Rule })
+	writeJSON(rw, http.StatusOK, map[string]interface{}{"rules": rules})
+}
@@ -0,0 +1,152 @@
+package waf
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// originalPathKey ключ контекста с исходным (неканонизированным) путем запроса
+type originalPathKey struct{}
+
+// originalPath возвращает путь запроса до канонизации
+func originalPath(r *http.Request) string {
+	if p, ok := r.Context().Value(originalPathKey{}).(string); ok {
+		return p
+	}
+	return r.URL.Path
+}
+
+// forwardPathKey ключ контекста с путем для бэкенда, когда он не совпадает с путем для проверок
+type forwardPathKey struct{}
+
+// forwardPath путь для бэкенда: canonical - путь, который видели модули,
+// path и raw - декодированный и закодированный путь, уходящий бэкенду
+type forwardPath struct {
+	canonical, path, raw string
+}
+
+// withForwardPath подставляет путь для бэкенда, если модули не переписали путь запроса
+func withForwardPath(r *http.Request) *http.Request {
+	fp, ok := r.Context().Value(forwardPathKey{}).(forwardPath)
+	if !ok || r.URL.Path != fp.canonical {
+		return r
+	}
+	u := *r.URL
+	u.Path, u.RawPath = fp.path, fp.raw
+	r = r.WithContext(r.Context())
+	r.URL = &u
+	return r
+}
+
+// pathCanonicalizer приводит путь запроса к каноничному виду до всех проверок и проксирования,
+// чтобы /api//users/../admin не обходил правила для /api/admin.
+// Путь декодируется ровно один раз (это делает net/url при разборе запроса),
+// повторно закодированные последовательности (%252e) остаются литералами.
+// Сегменты разрешаются по закодированному пути, поэтому %2F внутри сегмента
+// доходит до бэкенда как есть; модули видят тот же путь, декодированный и канонизированный.
+type pathCanonicalizer struct {
+	backslashAsSlash bool
+	logDetections    bool
+	events           *EventBus
+}
+
+func (c *pathCanonicalizer) push(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		escaped := r.URL.EscapedPath()
+		raw := canonicalEscapedPath(escaped, c.backslashAsSlash)
+		decoded, err := url.PathUnescape(raw)
+		if err != nil {
+			raw, decoded = "", r.URL.Path
+		}
+		canonical := canonicalPath(decoded, c.backslashAsSlash)
+		if canonical != r.URL.Path || raw != escaped {
+			if c.logDetections && c.events != nil && hasDotSegment(r.URL.Path, c.backslashAsSlash) {
+				ip := ClientIP(r)
+				c.events.Publish(requestEvent(r, ip, "path_canonicalization", SeverityInfo, "log", fmt.Sprintf("Путь с относительными сегментами от %s: %q -> %q", ip, r.URL.Path, canonical)))
+			}
+			ctx := context.WithValue(r.Context(), originalPathKey{}, r.URL.Path)
+			if decoded != canonical {
+				// В сегменте с %2F остались точки: модули проверяют путь с разрешенными точками,
+				// бэкенду уходит сегмент без изменений
+				ctx = context.WithValue(ctx, forwardPathKey{}, forwardPath{canonical: canonical, path: decoded, raw: raw})
+			}
+			r = r.WithContext(ctx)
+			r.URL.Path = canonical
+			// net/url использует RawPath, только если он кодирует Path
+			r.URL.RawPath = raw
+			r.RequestURI = r.URL.RequestURI()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// canonicalPath схлопывает повторные слеши и разрешает сегменты . и ..
+// (выход выше корня невозможен). Завершающий слеш сохраняется.
+func canonicalPath(p string, backslashAsSlash bool) string {
+	if backslashAsSlash {
+		p = strings.ReplaceAll(p, `\`, "/")
+	}
+	return resolveSegments(p, func(seg string) string { return seg })
+}
+
+// canonicalEscapedPath делает то же для закодированного пути: %2F остается частью сегмента,
+// сегменты %2e и %2e%2e считаются точками, как после декодирования
+func canonicalEscapedPath(p string, backslashAsSlash bool) string {
+	if backslashAsSlash {
+		p = strings.NewReplacer(`\`, "/", "%5C", "/", "%5c", "/").Replace(p)
+	}
+	return resolveSegments(p, func(seg string) string {
+		if strings.Contains(seg, "%") {
+			if d, err := url.PathUnescape(seg); err == nil {
+				return d
+			}
+		}
+		return seg
+	})
+}
+
+// resolveSegments разрешает сегменты пути; unescape возвращает значение сегмента для сравнения с . и ..
+func resolveSegments(p string, unescape func(string) string) string {
+	// Не origin-form (OPTIONS *) не трогается
+	if !strings.HasPrefix(p, "/") {
+		return p
+	}
+	segments := make([]string, 0, strings.Count(p, "/"))
+	trailing := false
+	for _, seg := range strings.Split(p, "/") {
+		trailing = false
+		switch unescape(seg) {
+		case "", ".":
+			trailing = true
+		case "..":
+			trailing = true
+			if len(segments) > 0 {
+				segments = segments[:len(segments)-1]
+			}
+		default:
+			segments = append(segments, seg)
+		}
+	}
+
+	out := "/" + strings.Join(segments, "/")
+	if trailing && out != "/" {
+		out += "/"
+	}
+	return out
+}
+
+// hasDotSegment проверяет наличие сегментов . и .. в пути
+func hasDotSegment(p string, backslashAsSlash bool) bool {
+	if backslashAsSlash {
+		p = strings.ReplaceAll(p, `\`, "/")
+	}
+	for _, seg := range strings.Split(p, "/") {
+		if seg == "." || seg == ".." {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+package waf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Канонизация не должна превращать %2F внутри сегмента в разделитель пути у бэкенда
+func TestCanonicalPathKeepsEncodedSlash(t *testing.T) {
+	tests := []struct {
+		target  string
+		checked string
+		sent    string
+	}{
+		{"/files/a%2Fb", "/files/a/b", "/files/a%2Fb"},
+		{"/files//a%2Fb", "/files/a/b", "/files/a%2Fb"},
+		{"/api/a%2Fb/../c", "/api/c", "/api/c"},
+		{"/api/./x/%2e%2e/a%2Fb/", "/api/a/b/", "/api/a%2Fb/"},
+		{"/admin/a%2F../..", "/admin/", "/admin/"},
+		{"/x%2F..%2Fadmin", "/admin", "/x%2F..%2Fadmin"},
+		{"/a%5C..%5Cb%2Fc", "/b/c", "/b%2Fc"},
+	}
+	for _, tt := range tests {
+		var checked, sent string
+		backend := markUpstream(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sent = r.URL.EscapedPath()
+		}))
+		h := (&pathCanonicalizer{backslashAsSlash: true}).push(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			checked = r.URL.Path
+			backend.ServeHTTP(w, r)
+		}))
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if checked != tt.checked || sent != tt.sent {
+			t.Errorf("%s: checked %q sent %q, want %q and %q", tt.target, checked, sent, tt.checked, tt.sent)
+		}
+	}
+}
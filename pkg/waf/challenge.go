@@ -0,0 +1,66 @@
+package waf
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// challengeCookieName cookie, подтверждающее прохождение JS-проверки
+const challengeCookieName = "waf_challenge"
+
+// challenger выдает и проверяет JS-challenge, привязанный к IP клиента.
+// Токен подписан HMAC с секретом процесса и имеет ограниченный срок жизни.
+type challenger struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+func newChallenger() *challenger {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return &challenger{secret: secret, ttl: 30 * time.Minute}
+}
+
+// token вычисляет подпись для IP и времени истечения
+func (c *challenger) token(ip string, exp int64) string {
+	mac := hmac.New(sha256.New, c.secret)
+	fmt.Fprintf(mac, "%s|%d", ip, exp)
+	return strconv.FormatInt(exp, 10) + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Passed проверяет, что клиент уже прошел challenge
+func (c *challenger) Passed(r *http.Request, ip string) bool {
+	cookie, err := r.Cookie(challengeCookieName)
+	if err != nil {
+		return false
+	}
+	expStr, _, ok := strings.Cut(cookie.Value, ".")
+	if !ok {
+		return false
+	}
+	exp, err := strconv.ParseInt(expStr, 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return false
+	}
+	return hmac.Equal([]byte(cookie.Value), []byte(c.token(ip, exp)))
+}
+
+// Issue отвечает страницей, которая устанавливает cookie и повторяет запрос
+func (c *challenger) Issue(w http.ResponseWriter, ip string) {
+	exp := time.Now().Add(c.ttl).Unix()
+	tok := c.token(ip, exp)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusTooManyRequests)
+	fmt.Fprintf(w, `<!doctype html><html><body><noscript>JavaScript required</noscript><script>document.cookie=%q+"; path=/; max-age=%d; SameSite=Lax";location.reload();</script></body></html>`,
+		challengeCookieName+"="+tok, int(c.ttl.Seconds()))
+}
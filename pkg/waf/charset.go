@@ -0,0 +1,253 @@
+package waf
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// decodePercentU раскодирует нестандартные последовательности %uXXXX (IIS), которые
+// url.QueryUnescape считает ошибкой: иначе строка с ними не декодировалась бы вовсе
+func decodePercentU(s string) string {
+	if !strings.Contains(s, "%u") && !strings.Contains(s, "%U") {
+		return s
+	}
+	var b strings.Builder
+	units := make([]uint16, 0, 4)
+	flush := func() {
+		b.WriteString(string(utf16.Decode(units)))
+		units = units[:0]
+	}
+	for i := 0; i < len(s); {
+		if i+6 <= len(s) && s[i] == '%' && (s[i+1] == 'u' || s[i+1] == 'U') {
+			if v, err := strconv.ParseUint(s[i+2:i+6], 16, 16); err == nil {
+				units = append(units, uint16(v))
+				i += 6
+				continue
+			}
+		}
+		flush()
+		b.WriteByte(s[i])
+		i++
+	}
+	flush()
+	return b.String()
+}
+
+// decodeCharsets приводит к UTF-8 строки в альтернативных кодировках, которыми обходят
+// фильтры: UTF-16 (нулевые байты между символами ASCII), UTF-7 (+ADw- вместо <)
+// и overlong UTF-8 (\xc0\xbc вместо <)
+func decodeCharsets(s string) string {
+	if u, ok := decodeUTF16(s); ok {
+		s = u
+	}
+	s = decodeUTF7(s)
+	return decodeOverlong(s)
+}
+
+// decodeUTF16 раскодирует строку в UTF-16 с BOM или без него, если в ней больше половины
+// нечетных (LE) или четных (BE) байтов нулевые — признак текста ASCII в UTF-16
+func decodeUTF16(s string) (string, bool) {
+	if len(s) < 4 || len(s)%2 != 0 || strings.IndexByte(s, 0) < 0 {
+		return "", false
+	}
+	be := false
+	switch {
+	case strings.HasPrefix(s, "\xfe\xff"):
+		be, s = true, s[2:]
+	case strings.HasPrefix(s, "\xff\xfe"):
+		s = s[2:]
+	default:
+		var evenZero, oddZero int
+		for i := 0; i < len(s); i += 2 {
+			if s[i] == 0 {
+				evenZero++
+			}
+			if s[i+1] == 0 {
+				oddZero++
+			}
+		}
+		switch half := len(s) / 4; {
+		case evenZero > half && oddZero == 0:
+			be = true
+		case oddZero > half && evenZero == 0:
+		default:
+			return "", false
+		}
+	}
+	units := make([]uint16, len(s)/2)
+	for i := range units {
+		if be {
+			units[i] = uint16(s[2*i])<<8 | uint16(s[2*i+1])
+		} else {
+			units[i] = uint16(s[2*i+1])<<8 | uint16(s[2*i])
+		}
+	}
+	return string(utf16.Decode(units)), true
+}
+
+// utf7Alphabet модифицированный base64 UTF-7 (RFC 2152) без дополнения
+var utf7Alphabet = base64.StdEncoding.WithPadding(base64.NoPadding)
+
+// decodeUTF7 раскодирует последовательности UTF-7 "+...-". Заменяются только те, что дают
+// символы ASCII: так "+ADw-" становится "<", а обычный текст с "+" не искажается.
+func decodeUTF7(s string) string {
+	if strings.IndexByte(s, '+') < 0 {
+		return s
+	}
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s, '+')
+		if i < 0 {
+			break
+		}
+		b.WriteString(s[:i])
+		s = s[i+1:]
+		n := 0
+		for n < len(s) && isBase64Char(s[n]) {
+			n++
+		}
+		if ascii, ok := utf7ASCII(s[:n]); ok {
+			b.WriteString(ascii)
+			s = strings.TrimPrefix(s[n:], "-")
+			continue
+		}
+		b.WriteByte('+')
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+// utf7ASCII раскодирует base64-часть последовательности UTF-7, если она дает только ASCII
+func utf7ASCII(enc string) (string, bool) {
+	// Символ UTF-16 занимает 16 бит, т.е. не меньше трех символов base64
+	if len(enc) < 3 {
+		return "", false
+	}
+	// Неполный последний символ base64 (меньше 8 бит) отбрасывается
+	if len(enc)%4 == 1 {
+		enc = enc[:len(enc)-1]
+	}
+	raw, err := utf7Alphabet.DecodeString(enc)
+	if err != nil {
+		return "", false
+	}
+	if len(raw) < 2 {
+		return "", false
+	}
+	out := make([]byte, 0, len(raw)/2)
+	for i := 0; i+1 < len(raw); i += 2 {
+		if raw[i] != 0 || raw[i+1] >= utf8.RuneSelf {
+			return "", false
+		}
+		out = append(out, raw[i+1])
+	}
+	return string(out), true
+}
+
+func isBase64Char(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '+' || c == '/'
+}
+
+// decodeOverlong заменяет избыточно длинные (overlong) последовательности UTF-8 на символ,
+// который они кодируют. Go и большинство библиотек отвергают их, но часть бэкендов
+// раскодирует, и тогда \xc0\xbc становится "<" уже после проверки.
+func decodeOverlong(s string) string {
+	if !hasOverlong(s) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		if r, n := overlongAt(s, i); n > 0 {
+			b.WriteRune(r)
+			i += n
+			continue
+		}
+		b.WriteByte(s[i])
+		i++
+	}
+	return b.String()
+}
+
+// overlongAt раскодирует overlong-последовательность в позиции i; n — ее длина или 0
+func overlongAt(s string, i int) (rune, int) {
+	cont := func(j int) bool { return j < len(s) && s[j]&0xc0 == 0x80 }
+	switch c := s[i]; {
+	case (c == 0xc0 || c == 0xc1) && cont(i+1):
+		return rune(c&0x1f)<<6 | rune(s[i+1]&0x3f), 2
+	case c == 0xe0 && cont(i+1) && s[i+1] < 0xa0 && cont(i+2):
+		return rune(s[i+1]&0x3f)<<6 | rune(s[i+2]&0x3f), 3
+	case c == 0xf0 && cont(i+1) && s[i+1] < 0x90 && cont(i+2) && cont(i+3):
+		return rune(s[i+1]&0x3f)<<12 | rune(s[i+2]&0x3f)<<6 | rune(s[i+3]&0x3f), 4
+	}
+	return 0, 0
+}
+
+func hasOverlong(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0xc0 {
+			if _, n := overlongAt(s, i); n > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// encodingEvasion проверяет строку запроса (после URL-декодирования) на приемы обхода
+// через кодировку. evasion — overlong UTF-8 или UTF-7 с разметкой: легитимные клиенты их
+// не отправляют. Иначе reason сообщает о некорректном UTF-8, который встречается и
+// в запросах старых клиентов в однобайтовых кодировках.
+func encodingEvasion(s string) (reason string, evasion bool) {
+	s = decodePercentU(s)
+	for i := 0; i < 5; i++ {
+		decoded := unescapeLoose(s)
+		if decoded == s {
+			break
+		}
+		s = decoded
+	}
+	if _, ok := decodeUTF16(s); ok {
+		return "UTF-16", true
+	}
+	switch {
+	case hasOverlong(s):
+		return "overlong UTF-8", true
+	case markupCount(decodeUTF7(s)) > markupCount(s):
+		return "UTF-7", true
+	case !utf8.ValidString(s):
+		return "ill-formed UTF-8", false
+	}
+	return "", false
+}
+
+func markupCount(s string) int {
+	n := 0
+	for _, c := range []byte(s) {
+		if c == '<' || c == '>' || c == '"' || c == '\'' {
+			n++
+		}
+	}
+	return n
+}
+
+// unescapeLoose URL-декодирует строку, оставляя некорректные %-последовательности как есть
+func unescapeLoose(s string) string {
+	if strings.IndexByte(s, '%') < 0 {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,172 @@
+package waf
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// состояния автомата circuit breaker
+const (
+	breakerClosed = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// CircuitBreakerMiddleware перестает отправлять запросы бэкенду после серии ошибок подряд
+// (5xx, недоступность, таймауты) и на время openDuration отвечает статической ошибкой.
+// Затем пропускает пробные запросы (half-open): успех закрывает цепь, ошибка снова размыкает.
+// Если модуль cache стоит в цепочке раньше, на время размыкания он отдает устаревшие копии.
+type CircuitBreakerMiddleware struct {
+	waf              *WAF
+	failureThreshold int
+	openDuration     time.Duration
+	halfOpenProbes   int
+	failureStatuses  map[int]bool
+	status           int
+	body             string
+	contentType      string
+	*breakerState
+}
+
+// breakerState состояние автомата; сохраняется при перезагрузке конфигурации, чтобы
+// разомкнутая цепь не замыкалась от смены настроек
+type breakerState struct {
+	mu        sync.Mutex
+	state     int
+	failures  int
+	openUntil time.Time
+	probes    int // пробных запросов в полете (half-open)
+}
+
+// NewCircuitBreakerMiddleware создает circuit breaker с дефолт настройками
+func NewCircuitBreakerMiddleware(w *WAF) *CircuitBreakerMiddleware {
+	return &CircuitBreakerMiddleware{
+		waf:              w,
+		failureThreshold: 5,
+		openDuration:     30 * time.Second,
+		halfOpenProbes:   1,
+		failureStatuses: map[int]bool{
+			http.StatusInternalServerError: true,
+			http.StatusBadGateway:          true,
+			http.StatusServiceUnavailable:  true,
+			http.StatusGatewayTimeout:      true,
+		},
+		status:      http.StatusServiceUnavailable,
+		body:        "Service Unavailable",
+		contentType: "text/plain; charset=utf-8",
+		breakerState: w.keep("circuit_breaker", func() interface{} {
+			return &breakerState{}
+		}).(*breakerState),
+	}
+}
+
+// NewCircuitBreakerMiddlewareWithConfig создает circuit breaker из конфига
+func NewCircuitBreakerMiddlewareWithConfig(w *WAF, cfg CircuitBreakerConfig) *CircuitBreakerMiddleware {
+	m := NewCircuitBreakerMiddleware(w)
+	if cfg.FailureThreshold > 0 {
+		m.failureThreshold = cfg.FailureThreshold
+	}
+	if cfg.OpenSeconds > 0 {
+		m.openDuration = time.Duration(cfg.OpenSeconds) * time.Second
+	}
+	if cfg.HalfOpenProbes > 0 {
+		m.halfOpenProbes = cfg.HalfOpenProbes
+	}
+	if len(cfg.FailureStatuses) > 0 {
+		m.failureStatuses = make(map[int]bool, len(cfg.FailureStatuses))
+		for _, code := range cfg.FailureStatuses {
+			m.failureStatuses[code] = true
+		}
+	}
+	if cfg.Response.Status > 0 {
+		m.status = cfg.Response.Status
+	}
+	if cfg.Response.Body != "" {
+		m.body = cfg.Response.Body
+	}
+	if cfg.Response.ContentType != "" {
+		m.contentType = cfg.Response.ContentType
+	}
+	return m
+}
+
+func (m *CircuitBreakerMiddleware) push(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.waf == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := ClientIP(r)
+		if m.waf.banned(r, ip) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		allowed, probe, retryAfter := m.allow()
+		if !allowed {
+			w.Header().Set("Content-Type", m.contentType)
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			w.WriteHeader(m.status)
+			w.Write([]byte(m.body))
+			return
+		}
+
+		rec := newStatusRecorder(w)
+		next.ServeHTTP(rec, r)
+		m.record(!m.failureStatuses[rec.status], probe)
+	})
+}
+
+// allow решает, можно ли передать запрос бэкенду; probe — запрос пробный (half-open)
+func (m *CircuitBreakerMiddleware) allow() (allowed, probe bool, retryAfter time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch m.state {
+	case breakerOpen:
+		if remaining := time.Until(m.openUntil); remaining > 0 {
+			return false, false, remaining
+		}
+		m.state = breakerHalfOpen
+		m.probes = 0
+		log.Printf("[WAF] Circuit breaker: пробные запросы к бэкенду (half-open)")
+		fallthrough
+	case breakerHalfOpen:
+		if m.probes >= m.halfOpenProbes {
+			return false, false, time.Second
+		}
+		m.probes++
+		return true, true, 0
+	}
+	return true, false, 0
+}
+
+// record учитывает результат запроса к бэкенду
+func (m *CircuitBreakerMiddleware) record(success, probe bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if probe {
+		m.probes--
+	}
+	if success {
+		m.failures = 0
+		if m.state == breakerHalfOpen {
+			m.state = breakerClosed
+			m.waf.emit(SecurityEvent{Type: EventUpstream, Module: "circuit_breaker", Severity: SeverityInfo, Action: "close",
+				Message: "Circuit breaker замкнут: бэкенд восстановился"})
+		}
+		return
+	}
+
+	m.failures++
+	if m.state == breakerHalfOpen || (m.state == breakerClosed && m.failures >= m.failureThreshold) {
+		m.state = breakerOpen
+		m.openUntil = time.Now().Add(m.openDuration)
+		m.waf.emit(SecurityEvent{Type: EventUpstream, Module: "circuit_breaker", Severity: SeverityCritical, Action: "open",
+			Message: fmt.Sprintf("Circuit breaker разомкнут на %v после %d ошибок бэкенда подряд", m.openDuration, m.failures)})
+	}
+}
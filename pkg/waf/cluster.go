@@ -0,0 +1,487 @@
+package waf
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultClusterInterval = time.Second
+	defaultClusterFanout   = 3
+	clusterMaxHops         = 6               // пересылок слуха; при fanout 3 покрывает сотни экземпляров
+	clusterMaxSkew         = 2 * time.Minute // более старые сообщения отклоняются как повтор
+	clusterMaxPacket       = 64 << 10
+	clusterSyncBans        = 64 // банов в одной датаграмме полной синхронизации
+	clusterSyncRounds      = 30 // раундов между полными синхронизациями со случайным экземпляром
+	clusterRiskRepeat      = 10 * time.Second
+)
+
+// Типы сообщений кластера
+const (
+	clusterMsgPing  = "ping" // список экземпляров
+	clusterMsgBan   = "ban"
+	clusterMsgUnban = "unban"
+	clusterMsgRisk  = "risk"
+	clusterMsgSync  = "sync" // все действующие баны, без пересылки
+)
+
+// clusterMessage датаграмма кластера; перед JSON идет HMAC-SHA256 общего ключа
+type clusterMessage struct {
+	ID      string             `json:"id"`
+	From    string             `json:"from"` // случайный идентификатор экземпляра
+	Node    string             `json:"node"`
+	Time    int64              `json:"time"` // unix ms создания исходного сообщения
+	Hops    int                `json:"hops,omitempty"`
+	Type    string             `json:"type"`
+	Members []string           `json:"members,omitempty"`
+	Bans    []clusterBanEntry  `json:"bans,omitempty"`
+	Risk    []clusterRiskEntry `json:"risk,omitempty"`
+}
+
+type clusterBanEntry struct {
+	Tenant string `json:"tenant,omitempty"`
+	ID     string `json:"id"`
+	TTL    int64  `json:"ttl_ms,omitempty"` // оставшееся время бана; для unban не задается
+	At     int64  `json:"at,omitempty"`     // unix ms решения; более позднее решение по тому же id побеждает
+}
+
+type clusterRiskEntry struct {
+	IP    string  `json:"ip"`
+	Score float64 `json:"score"`
+}
+
+type clusterMember struct {
+	node     string
+	lastSeen time.Time
+	direct   bool // получали сообщения от самого экземпляра, а не только из списков других
+}
+
+// clusterNode обмен банами и риском клиентов между экземплярами WAF по UDP без внешнего
+// хранилища. Новый бан рассылается слухом: каждый экземпляр пересылает его fanout случайным
+// соседям, так что бан доходит до всех за несколько пересылок. Пропуски потерянных датаграмм
+// закрывает периодическая полная синхронизация банов
+type clusterNode struct {
+	waf           *WAF
+	key           []byte
+	bind          string
+	self          string
+	node          string
+	seeds         []string
+	interval      time.Duration
+	fanout        int
+	riskThreshold float64 // 0 — риск не передается
+
+	conn *net.UDPConn
+
+	mu        sync.Mutex
+	members   map[string]*clusterMember // по адресу host:port
+	selfAddrs map[string]bool           // адреса seeds, которые оказались этим экземпляром
+	seen      map[string]time.Time      // идентификаторы полученных сообщений
+	versions  map[string]int64          // At последнего решения по tenant/id
+	riskSent  map[string]time.Time
+}
+
+// newClusterNode проверяет конфигурацию; порт открывает start
+func newClusterNode(w *WAF, cfg ClusterConfig) (*clusterNode, error) {
+	if len(cfg.Secret) < 16 {
+		return nil, errors.New("cluster: secret must be at least 16 bytes")
+	}
+	if _, err := net.ResolveUDPAddr("udp", cfg.Bind); err != nil {
+		return nil, err
+	}
+	c := &clusterNode{
+		waf:       w,
+		key:       []byte(cfg.Secret),
+		bind:      cfg.Bind,
+		self:      newRequestID(),
+		node:      cfg.Node,
+		seeds:     cfg.Peers,
+		interval:  defaultClusterInterval,
+		fanout:    defaultClusterFanout,
+		members:   make(map[string]*clusterMember),
+		selfAddrs: make(map[string]bool),
+		seen:      make(map[string]time.Time),
+		versions:  make(map[string]int64),
+		riskSent:  make(map[string]time.Time),
+	}
+	if c.node == "" {
+		c.node, _ = os.Hostname()
+	}
+	if cfg.GossipIntervalMs > 0 {
+		c.interval = time.Duration(cfg.GossipIntervalMs) * time.Millisecond
+	}
+	if cfg.Fanout > 0 {
+		c.fanout = cfg.Fanout
+	}
+	if w != nil && w.reputation != nil {
+		c.riskThreshold = w.reputation.tightenScore
+		if cfg.RiskThreshold > 0 {
+			c.riskThreshold = cfg.RiskThreshold
+		}
+	}
+	return c, nil
+}
+
+// start открывает UDP-порт, подписывается на баны и срабатывания и начинает обмен
+func (c *clusterNode) start() error {
+	var err error
+	if c.conn, err = sockets.listenUDP("cluster", c.bind); err != nil {
+		return err
+	}
+	c.waf.cluster = c
+	c.waf.events.subscribe("cluster", c, eventFilter{types: toSet([]string{EventBan, EventDetection})})
+	log.Printf("[WAF] cluster: узел %s на %s, известных экземпляров %d", c.node, c.conn.LocalAddr(), len(c.seeds))
+	go c.readLoop()
+	go c.gossipLoop()
+	return nil
+}
+
+// HandleEvent рассылает локальные баны и клиентов, чей риск превысил порог
+func (c *clusterNode) HandleEvent(ev SecurityEvent) {
+	switch {
+	case ev.Type == EventBan && ev.Module == "bans":
+		seconds, _ := ev.Fields["duration_seconds"].(float64)
+		at := ev.Time.UnixMilli()
+		c.mu.Lock()
+		c.versions[ev.Tenant+"/"+ev.IP] = at
+		c.mu.Unlock()
+		c.publish(clusterMessage{Type: clusterMsgBan, Bans: []clusterBanEntry{{Tenant: ev.Tenant, ID: ev.IP, TTL: int64(seconds * 1000), At: at}}})
+	case ev.Type == EventDetection && ev.Tenant == "" && ev.IP != "" && c.riskThreshold > 0:
+		score := c.waf.reputation.score(ev.IP)
+		if score < c.riskThreshold {
+			return
+		}
+		c.mu.Lock()
+		if time.Since(c.riskSent[ev.IP]) < clusterRiskRepeat {
+			c.mu.Unlock()
+			return
+		}
+		c.riskSent[ev.IP] = time.Now()
+		c.mu.Unlock()
+		c.publish(clusterMessage{Type: clusterMsgRisk, Risk: []clusterRiskEntry{{IP: ev.IP, Score: score}}})
+	}
+}
+
+// announceUnban рассылает снятие бана через API администратора
+func (c *clusterNode) announceUnban(tenant, id string) {
+	if c == nil {
+		return
+	}
+	at := time.Now().UnixMilli()
+	c.mu.Lock()
+	c.versions[tenant+"/"+id] = at
+	c.mu.Unlock()
+	c.publish(clusterMessage{Type: clusterMsgUnban, Bans: []clusterBanEntry{{Tenant: tenant, ID: id, At: at}}})
+}
+
+// publish отправляет новое сообщение fanout случайным экземплярам
+func (c *clusterNode) publish(msg clusterMessage) {
+	msg.ID, msg.From, msg.Node, msg.Time = newRequestID(), c.self, c.node, time.Now().UnixMilli()
+	c.mu.Lock()
+	c.seen[msg.ID] = time.Now()
+	targets := c.pick(c.fanout, "")
+	c.mu.Unlock()
+	c.send(msg, targets...)
+}
+
+// pick случайные экземпляры, кроме except; вызывается под c.mu
+func (c *clusterNode) pick(n int, except string) []string {
+	addrs := make([]string, 0, len(c.members))
+	for addr := range c.members {
+		if addr != except {
+			addrs = append(addrs, addr)
+		}
+	}
+	rand.Shuffle(len(addrs), func(i, j int) { addrs[i], addrs[j] = addrs[j], addrs[i] })
+	return addrs[:min(n, len(addrs))]
+}
+
+func (c *clusterNode) send(msg clusterMessage, addrs ...string) {
+	if len(addrs) == 0 {
+		return
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write(body)
+	packet := append(mac.Sum(nil), body...)
+	for _, a := range addrs {
+		addr, err := net.ResolveUDPAddr("udp", a)
+		if err != nil {
+			continue
+		}
+		if _, err := c.conn.WriteToUDP(packet, addr); err != nil {
+			log.Printf("[WAF] cluster: отправка %s: %v", a, err)
+		}
+	}
+}
+
+func (c *clusterNode) readLoop() {
+	buf := make([]byte, clusterMaxPacket)
+	for {
+		n, from, err := c.conn.ReadFromUDP(buf)
+		if err != nil {
+			log.Printf("[WAF] cluster: %v", err)
+			return
+		}
+		c.receive(buf[:n], from)
+	}
+}
+
+// receive проверяет подпись и свежесть сообщения, учитывает отправителя и применяет сообщение
+func (c *clusterNode) receive(packet []byte, from *net.UDPAddr) {
+	if len(packet) <= sha256.Size {
+		return
+	}
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write(packet[sha256.Size:])
+	if !hmac.Equal(mac.Sum(nil), packet[:sha256.Size]) {
+		return
+	}
+	var msg clusterMessage
+	if err := json.Unmarshal(packet[sha256.Size:], &msg); err != nil {
+		return
+	}
+	addr := from.String()
+	now := time.Now()
+	c.mu.Lock()
+	if msg.From == c.self {
+		// Свое сообщение без пересылок: seed указывает на этот же экземпляр.
+		// Пересланный слух о своем бане просто отбрасывается
+		if msg.Hops == 0 {
+			c.selfAddrs[addr] = true
+			delete(c.members, addr)
+		}
+		c.mu.Unlock()
+		return
+	}
+	if age := now.Sub(time.UnixMilli(msg.Time)); age > clusterMaxSkew || age < -clusterMaxSkew {
+		c.mu.Unlock()
+		return
+	}
+	if _, dup := c.seen[msg.ID]; dup {
+		c.mu.Unlock()
+		return
+	}
+	c.seen[msg.ID] = now
+	joined := false
+	if msg.Hops == 0 {
+		// Сообщение пришло от самого автора, адрес отправителя — его порт обмена
+		m := c.members[addr]
+		if m == nil || !m.direct {
+			joined = true
+			m = &clusterMember{}
+			c.members[addr] = m
+		}
+		m.node, m.lastSeen, m.direct = msg.Node, now, true
+	}
+	for _, a := range msg.Members {
+		if c.members[a] == nil && !c.selfAddrs[a] {
+			c.members[a] = &clusterMember{lastSeen: now}
+		}
+	}
+	var forward []string
+	if msg.Type != clusterMsgPing && msg.Type != clusterMsgSync && msg.Hops < clusterMaxHops {
+		forward = c.pick(c.fanout, addr)
+	}
+	c.mu.Unlock()
+
+	if joined {
+		log.Printf("[WAF] cluster: подключен экземпляр %s (%s)", msg.Node, addr)
+		// Новый экземпляр получает список и все действующие баны сразу, не дожидаясь раунда
+		c.ping(addr)
+		c.syncTo(addr)
+	}
+	switch msg.Type {
+	case clusterMsgBan, clusterMsgUnban, clusterMsgSync:
+		c.applyBans(msg.Type == clusterMsgUnban, msg.Bans)
+	case clusterMsgRisk:
+		for _, r := range msg.Risk {
+			c.waf.reputation.raise(r.IP, r.Score)
+		}
+	}
+	if len(forward) > 0 {
+		msg.Hops++
+		c.send(msg, forward...)
+	}
+}
+
+// applyBans применяет баны и снятия банов, если они новее известного решения по тому же id
+func (c *clusterNode) applyBans(unban bool, entries []clusterBanEntry) {
+	for _, e := range entries {
+		key := e.Tenant + "/" + e.ID
+		c.mu.Lock()
+		newer := e.At > c.versions[key]
+		if newer {
+			c.versions[key] = e.At
+		}
+		c.mu.Unlock()
+		bans := c.waf.tenantBans(e.Tenant)
+		if !newer || bans == nil || e.ID == "" {
+			continue
+		}
+		if unban {
+			bans.Unban(e.ID)
+		} else if e.TTL > 0 {
+			bans.banUntil(e.ID, time.Now().Add(time.Duration(e.TTL)*time.Millisecond))
+		}
+	}
+}
+
+// gossipLoop каждый раунд рассылает список экземпляров, удаляет молчащие и устаревшие записи
+// и время от времени синхронизирует все баны со случайным экземпляром
+func (c *clusterNode) gossipLoop() {
+	dead := max(10*c.interval, 10*time.Second)
+	for round := 0; ; round++ {
+		if round%10 == 0 {
+			c.resolveSeeds()
+		}
+		now := time.Now()
+		c.mu.Lock()
+		for addr, m := range c.members {
+			if now.Sub(m.lastSeen) > dead {
+				delete(c.members, addr)
+				if m.direct {
+					log.Printf("[WAF] cluster: экземпляр %s (%s) не отвечает", m.node, addr)
+				}
+			}
+		}
+		for id, t := range c.seen {
+			if now.Sub(t) > 2*clusterMaxSkew {
+				delete(c.seen, id)
+			}
+		}
+		for ip, t := range c.riskSent {
+			if now.Sub(t) > clusterRiskRepeat {
+				delete(c.riskSent, ip)
+			}
+		}
+		for key, at := range c.versions {
+			if now.Sub(time.UnixMilli(at)) > 24*time.Hour {
+				delete(c.versions, key)
+			}
+		}
+		targets := c.pick(c.fanout, "")
+		var syncTarget []string
+		if round%clusterSyncRounds == clusterSyncRounds-1 {
+			syncTarget = c.pick(1, "")
+		}
+		c.mu.Unlock()
+		for _, addr := range targets {
+			c.ping(addr)
+		}
+		for _, addr := range syncTarget {
+			c.syncTo(addr)
+		}
+		time.Sleep(c.interval)
+	}
+}
+
+// resolveSeeds добавляет адреса peers; имя с несколькими адресами (headless service) дает
+// несколько экземпляров
+func (c *clusterNode) resolveSeeds() {
+	var addrs []string
+	for _, seed := range c.seeds {
+		host, port, err := net.SplitHostPort(seed)
+		if err != nil {
+			continue
+		}
+		ips, err := net.LookupHost(host)
+		if err != nil {
+			continue
+		}
+		for _, ip := range ips {
+			addrs = append(addrs, net.JoinHostPort(ip, port))
+		}
+	}
+	now := time.Now()
+	c.mu.Lock()
+	for _, a := range addrs {
+		if c.members[a] == nil && !c.selfAddrs[a] {
+			c.members[a] = &clusterMember{lastSeen: now}
+		}
+	}
+	c.mu.Unlock()
+}
+
+// ping отправляет экземпляры, от которых были сообщения напрямую
+func (c *clusterNode) ping(addr string) {
+	c.mu.Lock()
+	var members []string
+	for a, m := range c.members {
+		if m.direct && a != addr {
+			members = append(members, a)
+		}
+	}
+	c.mu.Unlock()
+	sort.Strings(members)
+	c.send(clusterMessage{ID: newRequestID(), From: c.self, Node: c.node, Time: time.Now().UnixMilli(), Type: clusterMsgPing, Members: members}, addr)
+}
+
+// syncTo отправляет все действующие баны основного WAF и арендаторов частями
+func (c *clusterNode) syncTo(addr string) {
+	now := time.Now()
+	var entries []clusterBanEntry
+	collect := func(tenant string, bans *BanList) {
+		for id, until := range bans.Active() {
+			if len(id) > 256 {
+				continue
+			}
+			c.mu.Lock()
+			at := c.versions[tenant+"/"+id]
+			c.mu.Unlock()
+			entries = append(entries, clusterBanEntry{Tenant: tenant, ID: id, TTL: until.Sub(now).Milliseconds(), At: max(at, 1)})
+		}
+	}
+	collect("", c.waf.bans)
+	c.waf.mu.RLock()
+	tenants := c.waf.tenants
+	c.waf.mu.RUnlock()
+	for _, t := range tenants {
+		collect(t.name, t.waf.bans)
+	}
+	for len(entries) > 0 {
+		n := min(clusterSyncBans, len(entries))
+		c.send(clusterMessage{ID: newRequestID(), From: c.self, Node: c.node, Time: time.Now().UnixMilli(), Type: clusterMsgSync, Bans: entries[:n]}, addr)
+		entries = entries[n:]
+	}
+}
+
+// adminCluster состояние кластера: этот экземпляр и известные экземпляры
+func (w *WAF) adminCluster(rw http.ResponseWriter, r *http.Request) {
+	c := w.cluster
+	if c == nil {
+		writeJSON(rw, http.StatusNotFound, map[string]string{"error": "cluster is disabled"})
+		return
+	}
+	type member struct {
+		Addr     string    `json:"addr"`
+		Node     string    `json:"node,omitempty"`
+		LastSeen time.Time `json:"last_seen"`
+		Direct   bool      `json:"direct"`
+	}
+	members := []member{}
+	c.mu.Lock()
+	for addr, m := range c.members {
+		members = append(members, member{Addr: addr, Node: m.node, LastSeen: m.lastSeen, Direct: m.direct})
+	}
+	c.mu.Unlock()
+	sort.Slice(members, func(i, j int) bool { return members[i].Addr < members[j].Addr })
+	writeJSON(rw, http.StatusOK, map[string]interface{}{
+		"node":    c.node,
+		"addr":    c.conn.LocalAddr().String(),
+		"members": members,
+	})
+}
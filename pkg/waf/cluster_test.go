@@ -0,0 +1,80 @@
+package waf
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+)
+
+func clusterPacket(key string, msg clusterMessage) []byte {
+	body, _ := json.Marshal(msg)
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(body)
+	return append(mac.Sum(nil), body...)
+}
+
+func TestClusterReceiveRejects(t *testing.T) {
+	const secret = "cluster-secret-0123456789"
+	w, err := NewWAF("http://127.0.0.1:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := newClusterNode(w, ClusterConfig{Secret: secret, Bind: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.conn, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}); err != nil {
+		t.Fatal(err)
+	}
+	defer c.conn.Close()
+	from := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9}
+
+	now := time.Now()
+	ban := func(id string, at time.Time) clusterMessage {
+		return clusterMessage{ID: newRequestID(), From: "peer", Node: "peer", Time: at.UnixMilli(), Type: clusterMsgBan,
+			Bans: []clusterBanEntry{{ID: id, TTL: 60000, At: at.UnixMilli()}}}
+	}
+	// Снятие бана новее любого бана ниже: устаревший бан по тому же id не применяется
+	c.receive(clusterPacket(secret, clusterMessage{ID: newRequestID(), From: "peer", Time: now.UnixMilli(), Type: clusterMsgUnban,
+		Bans: []clusterBanEntry{{ID: "10.0.0.6", At: now.UnixMilli()}}}), from)
+
+	for _, tc := range []struct {
+		name   string
+		id     string
+		packet []byte
+		banned bool
+	}{
+		{"valid", "10.0.0.1", clusterPacket(secret, ban("10.0.0.1", now)), true},
+		{"forged hmac", "10.0.0.2", clusterPacket("another-secret-0123456789", ban("10.0.0.2", now)), false},
+		{"tampered body", "10.0.0.3", func() []byte {
+			p := clusterPacket(secret, ban("10.0.0.3", now))
+			p[len(p)-2] ^= 1
+			return p
+		}(), false},
+		{"truncated", "10.0.0.4", clusterPacket(secret, ban("10.0.0.4", now))[:sha256.Size], false},
+		{"too old", "10.0.0.5", clusterPacket(secret, ban("10.0.0.5", now.Add(-clusterMaxSkew-time.Second))), false},
+		{"from the future", "10.0.0.5", clusterPacket(secret, ban("10.0.0.5", now.Add(clusterMaxSkew+time.Second))), false},
+		{"older than known decision", "10.0.0.6", clusterPacket(secret, ban("10.0.0.6", now.Add(-time.Second))), false},
+	} {
+		c.receive(tc.packet, from)
+		if got := w.bans.IsBanned(tc.id); got != tc.banned {
+			t.Errorf("%s: banned %v, want %v", tc.name, got, tc.banned)
+		}
+	}
+
+	// Повтор перехваченной датаграммы с другого адреса отбрасывается целиком
+	packet := clusterPacket(secret, ban("10.0.0.7", now))
+	c.receive(packet, from)
+	w.bans.Unban("10.0.0.7")
+	replayFrom := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 10}
+	c.receive(packet, replayFrom)
+	c.mu.Lock()
+	_, joined := c.members[replayFrom.String()]
+	c.mu.Unlock()
+	if w.bans.IsBanned("10.0.0.7") || joined {
+		t.Error("replayed packet accepted")
+	}
+}
@@ -0,0 +1,909 @@
+package waf
+
+import "encoding/json"
+
+// Структуры конфигурации WAF
+type RateLimitConfig struct {
+	Limit             float64 `json:"limit"`
+	Burst             int     `json:"burst"`
+	BanSeconds        int     `json:"ban_seconds"`
+	Multiplier        float64 `json:"multiplier"`
+	ViolationResetHrs int     `json:"violation_reset_hours"`
+
+	Keys     []RateLimitKeyConfig `json:"keys"` // по умолчанию один ключ ip
+	Identity IdentityConfig       `json:"identity"`
+}
+
+// RateLimitKeyConfig ключ, по которому считается отдельный лимит. Части составного ключа
+// перечисляются через +: ip, ja3, session, account, ua, header:<имя>, cookie:<имя>.
+// Лимит и бан по умолчанию — как у модуля.
+type RateLimitKeyConfig struct {
+	Key        string  `json:"key"`
+	Limit      float64 `json:"limit"`
+	Burst      int     `json:"burst"`
+	BanSeconds int     `json:"ban_seconds"`
+}
+
+type SignatureConfig struct {
+	LogMatches       bool   `json:"log_matches"`
+	InspectBody      bool   `json:"inspect_body"`
+	MaxBodyInspectKB int    `json:"max_body_inspect_kb"` // проверяется только начало тела, остаток передается без проверки
+	VerdictCacheSize int    `json:"verdict_cache_size"`  // вердиктов в LRU по хешу строки; 0 — 4096, -1 — без кеша
+	MatcherBackend   string `json:"matcher_backend"`     // regexp (по умолчанию), ahocorasick, hyperscan (тег сборки hyperscan)
+}
+
+type ContextConfig struct {
+	WindowSeconds       int                            `json:"window_seconds"`
+	Threshold           int                            `json:"threshold"`
+	BanSeconds          int                            `json:"ban_seconds"`
+	Multiplier          float64                        `json:"multiplier"`
+	ViolationResetHours int                            `json:"violation_reset_hours"`
+	ResourceExtractor   ContextResourceExtractorConfig `json:"resource_extractor"`
+	Routes              []ContextRouteConfig           `json:"routes"`
+	Identity            IdentityConfig                 `json:"identity"`
+}
+
+// IdentityConfig задает, откуда брать идентичность клиента вместо IP
+type IdentityConfig struct {
+	SessionHeader     string  `json:"session_header"`
+	SessionCookie     string  `json:"session_cookie"`
+	AccountHeader     string  `json:"account_header"`
+	JWTClaim          string  `json:"jwt_claim"`           // claim проверенного JWT (например sub), идентифицирующий аккаунт
+	IPThresholdFactor float64 `json:"ip_threshold_factor"` // порог для IP = порог * factor; 0 — IP отдельно не отслеживается
+	Device            bool    `json:"device"`              // cookie устройства (модуль device), если нет аккаунта и сессии
+
+	TLSFingerprintHeader string `json:"tls_fingerprint_header"` // заголовок с JA3 от TLS-терминатора; по умолчанию X-JA3-Fingerprint
+}
+
+// ContextRouteConfig переопределяет окно и порог для маршрута (например /invoices/{id})
+type ContextRouteConfig struct {
+	Path              string                         `json:"path"`
+	WindowSeconds     int                            `json:"window_seconds"`
+	Threshold         int                            `json:"threshold"`
+	ResourceExtractor ContextResourceExtractorConfig `json:"resource_extractor"`
+}
+
+type ContextResourceExtractorConfig struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+// LoginProtectionConfig настройки защиты эндпоинтов входа от перебора учетных данных
+type LoginProtectionConfig struct {
+	Paths              []string `json:"paths"`
+	UsernameFields     []string `json:"username_fields"`
+	FailureStatuses    []int    `json:"failure_statuses"`
+	WindowSeconds      int      `json:"window_seconds"`
+	MaxFailuresPerIP   int      `json:"max_failures_per_ip"`
+	MaxFailuresPerUser int      `json:"max_failures_per_user"`
+	MaxFailuresPerPair int      `json:"max_failures_per_pair"`
+	MaxUsernamesPerIP  int      `json:"max_usernames_per_ip"`
+	Action             string   `json:"action"` // ban, throttle, delay, challenge
+	BanSeconds         int      `json:"ban_seconds"`
+	DelayMs            int      `json:"delay_ms"`
+
+	AccountLockout AccountLockoutConfig `json:"account_lockout"`
+}
+
+// AccountLockoutConfig настройки временной блокировки аккаунта после неудачных входов
+type AccountLockoutConfig struct {
+	MaxFailures       int    `json:"max_failures"` // 0 — блокировка аккаунтов выключена
+	WindowSeconds     int    `json:"window_seconds"`
+	LockoutSeconds    int    `json:"lockout_seconds"`     // удваивается при повторных блокировках
+	MaxLockoutSeconds int    `json:"max_lockout_seconds"` // верхняя граница длительности блокировки
+	WebhookURL        string `json:"webhook_url"`         // POST JSON события account_locked
+	WebhookSecret     string `json:"webhook_secret"`      // передается в Authorization: Bearer
+}
+
+// EnumerationConfig настройки обнаружения перебора аккаунтов (регистрация, сброс пароля)
+type EnumerationConfig struct {
+	Paths         []string       `json:"paths"`
+	Fields        []string       `json:"fields"`
+	WindowSeconds int            `json:"window_seconds"`
+	Threshold     int            `json:"threshold"`
+	Action        string         `json:"action"` // throttle, delay, challenge, ban
+	BanSeconds    int            `json:"ban_seconds"`
+	DelayMs       int            `json:"delay_ms"`
+	Identity      IdentityConfig `json:"identity"`
+}
+
+// ScannerConfig настройки обнаружения сканеров уязвимостей
+type ScannerConfig struct {
+	UserAgentPatternsFile string `json:"user_agent_patterns_file"`
+	PathPatternsFile      string `json:"path_patterns_file"`
+	NotFoundThreshold     int    `json:"not_found_threshold"`
+	NotFoundWindowSeconds int    `json:"not_found_window_seconds"`
+	Action                string `json:"action"` // log (по умолчанию), ban, tarpit
+	BanSeconds            int    `json:"ban_seconds"`
+	TarpitSeconds         int    `json:"tarpit_seconds"`
+}
+
+// GoodBotsConfig проверка поисковых роботов обратным и прямым разрешением DNS
+type GoodBotsConfig struct {
+	Bots            []GoodBotConfig `json:"bots"`            // по умолчанию Googlebot, Bingbot, YandexBot, Applebot, Baiduspider
+	ImpostorAction  string          `json:"impostor_action"` // log, block, ban, throttle, delay, challenge; по умолчанию block
+	BanSeconds      int             `json:"ban_seconds"`
+	RiskScore       float64         `json:"risk_score"` // добавляется к риску самозванца; по умолчанию 10
+	CacheSeconds    int             `json:"cache_seconds"`
+	LookupTimeoutMs int             `json:"lookup_timeout_ms"`
+}
+
+// GoodBotConfig робот: подстрока User-Agent и домены обратных DNS-имен его адресов
+type GoodBotConfig struct {
+	Name      string   `json:"name"`
+	UserAgent string   `json:"user_agent"`
+	Domains   []string `json:"domains"`
+}
+
+// DeviceConfig выдача подписанной cookie с идентификатором устройства
+type DeviceConfig struct {
+	CookieName string `json:"cookie_name"` // по умолчанию waf_device
+	Secret     string `json:"secret"`      // пусто — случайный секрет процесса, cookie действуют до перезапуска
+	MaxAgeDays int    `json:"max_age_days"`
+	Secure     bool   `json:"secure"`
+}
+
+// HeaderAnomalyConfig проверка набора заголовков на соответствие браузерам
+type HeaderAnomalyConfig struct {
+	RawHeaders bool    `json:"raw_headers"` // записывать исходный порядок и регистр заголовков на listener (при запуске)
+	AllClients bool    `json:"all_clients"` // проверять не только клиентов с браузерным User-Agent
+	RiskScore  float64 `json:"risk_score"`  // риск за каждое отклонение; по умолчанию 2
+	Threshold  int     `json:"threshold"`   // отклонений для действия; 0 — только риск
+	Action     string  `json:"action"`      // challenge (по умолчанию), block, ban, throttle, delay
+	BanSeconds int     `json:"ban_seconds"`
+}
+
+// ContentTypeConfig проверка соответствия тела запроса заявленному Content-Type
+type ContentTypeConfig struct {
+	InspectBytes       int64   `json:"inspect_bytes"`        // сколько байт тела проверять; по умолчанию 64 КБ
+	RequireContentType bool    `json:"require_content_type"` // тело без Content-Type считается несоответствием
+	SniffOpaque        bool    `json:"sniff_opaque"`         // искать JSON и multipart в application/octet-stream и др.
+	RiskScore          float64 `json:"risk_score"`
+	Action             string  `json:"action"` // block (по умолчанию), log, challenge, ban, throttle, delay
+	BanSeconds         int     `json:"ban_seconds"`
+}
+
+// ControlCharsConfig проверка NUL и управляющих символов в пути, параметрах и заголовках
+type ControlCharsConfig struct {
+	StrictParams  bool     `json:"strict_params"`  // запретить \t, \r, \n и в значениях параметров
+	IgnoreHeaders []string `json:"ignore_headers"` // заголовки, которые не проверяются
+	Action        string   `json:"action"`         // block (по умолчанию), log, challenge, ban, throttle, delay
+	BanSeconds    int      `json:"ban_seconds"`
+}
+
+// CRLFConfig обнаружение CRLF-инъекций в параметрах (header injection, response splitting)
+type CRLFConfig struct {
+	BodyParams bool   `json:"body_params"` // проверять и параметры тела формы
+	Action     string `json:"action"`      // block (по умолчанию), log, challenge, ban, throttle, delay
+	BanSeconds int    `json:"ban_seconds"`
+}
+
+// HostHeaderConfig проверка заголовка Host
+type HostHeaderConfig struct {
+	AllowedHosts           []string `json:"allowed_hosts"`            // имена сайта, в т.ч. *.example.com; пусто — только согласованность
+	AllowAbsoluteURI       bool     `json:"allow_absolute_uri"`       // разрешить запросы в absolute-form (GET http://host/...)
+	StripOverrides         bool     `json:"strip_overrides"`          // удалять X-Forwarded-Host и др. вместо проверки
+	BlockPrivateResolution bool     `json:"block_private_resolution"` // отклонять Host, разрешающийся в частные адреса (DNS rebinding)
+	RequireSNIMatch        bool     `json:"require_sni_match"`        // по TLS Host должен совпадать с SNI
+	Action                 string   `json:"action"`                   // block (по умолчанию), log, challenge, ban, throttle, delay
+	BanSeconds             int      `json:"ban_seconds"`
+}
+
+// HotlinkConfig политики доступа к путям по Referer
+type HotlinkConfig struct {
+	Rules []HotlinkRuleConfig `json:"rules"` // применяется первое правило, маршрут которого совпал
+}
+
+// HotlinkRuleConfig правило Referer для группы маршрутов
+type HotlinkRuleConfig struct {
+	Routes          []string `json:"routes"`           // шаблоны маршрутов (/media/*)
+	AllowedReferers []string `json:"allowed_referers"` // домены-источники, в т.ч. *.example.com; свой Host разрешен всегда
+	BlockEmpty      bool     `json:"block_empty"`      // запрещать запросы без Referer
+	Action          string   `json:"action"`           // block (по умолчанию), redirect, log
+	RedirectURL     string   `json:"redirect_url"`     // заглушка (например, изображение с водяным знаком) для redirect
+}
+
+// UploadConfig политика типов файлов в multipart-загрузках
+type UploadConfig struct {
+	Routes            []string        `json:"routes"`             // шаблоны маршрутов; пусто — все
+	AllowedExtensions []string        `json:"allowed_extensions"` // пусто — любые, кроме запрещенных
+	BlockedExtensions []string        `json:"blocked_extensions"` // заменяет встроенный список исполняемых расширений
+	AllowedTypes      []string        `json:"allowed_types"`      // типы по сигнатуре или заявленные; пусто — любые
+	Action            string          `json:"action"`             // block (по умолчанию) или log
+	Antivirus         AntivirusConfig `json:"antivirus"`
+}
+
+// AntivirusConfig проверка загружаемых файлов антивирусом
+type AntivirusConfig struct {
+	Type      string `json:"type"`       // clamd или icap; пусто — без антивируса
+	Address   string `json:"address"`    // clamd: unix:/run/clamav/clamd.ctl или host:3310; icap: icap://host:1344/avscan
+	TimeoutMs int    `json:"timeout_ms"` // простой антивируса; по умолчанию 10000
+	FailOpen  bool   `json:"fail_open"`  // пропускать файлы, если антивирус недоступен (по умолчанию отклонять)
+}
+
+// VerdictConfig внешний HTTP-сервис вердиктов для выбранных запросов
+type VerdictConfig struct {
+	URL            string            `json:"url"`
+	Headers        map[string]string `json:"headers"`         // заголовки запроса к сервису (авторизация)
+	Routes         []string          `json:"routes"`          // пусто — все пути
+	MinRisk        float64           `json:"min_risk"`        // отправлять, только если риск клиента не меньше
+	BodyBytes      int64             `json:"body_bytes"`      // сколько байт тела включать в сводку; 0 — без тела
+	RedactHeaders  []string          `json:"redact_headers"`  // не передавать, кроме Authorization и Cookie
+	ScoreThreshold float64           `json:"score_threshold"` // риск клиента, при котором вердикт score отклоняет запрос
+	Action         string            `json:"action"`          // block (по умолчанию), log, challenge, ban, throttle, delay
+	BanSeconds     int               `json:"ban_seconds"`
+	TimeoutMs      int               `json:"timeout_ms"`    // по умолчанию 200
+	CacheSeconds   int               `json:"cache_seconds"` // по умолчанию 60; ответ может задать свой срок
+	CacheKey       string            `json:"cache_key"`     // request (по умолчанию) или ip
+	MaxCacheSize   int               `json:"max_cache_size"`
+	FailOpen       bool              `json:"fail_open"` // пропускать запросы при недоступности сервиса
+}
+
+// SequenceConfig настройки анализа последовательностей переходов (цепь Маркова)
+type SequenceConfig struct {
+	TrainingSeconds int     `json:"training_seconds"`
+	MinObservations int     `json:"min_observations"`
+	HistorySize     int     `json:"history_size"`
+	Threshold       float64 `json:"threshold"`
+	RiskScore       float64 `json:"risk_score"`
+	BanRisk         float64 `json:"ban_risk"`
+	BanSeconds      int     `json:"ban_seconds"`
+}
+
+// ParamAnomalyConfig настройки анализа длины и энтропии значений параметров
+type ParamAnomalyConfig struct {
+	TrainingSeconds int     `json:"training_seconds"`
+	MinSamples      int     `json:"min_samples"`
+	ZThreshold      float64 `json:"z_threshold"`
+	MinLength       int     `json:"min_length"`
+	MinEntropy      float64 `json:"min_entropy"`
+	RiskScore       float64 `json:"risk_score"`
+	Action          string  `json:"action"` // log, block
+}
+
+// AnomalyModelConfig настройки ML-оценки аномальности запросов
+type AnomalyModelConfig struct {
+	Mode       string  `json:"mode"` // record, score
+	RecordPath string  `json:"record_path"`
+	ModelPath  string  `json:"model_path"`
+	Threshold  float64 `json:"threshold"`
+	RiskScore  float64 `json:"risk_score"`
+	Action     string  `json:"action"` // log, block
+}
+
+// PositiveModelConfig настройки обучаемой позитивной модели (allowlist)
+type PositiveModelConfig struct {
+	Mode         string  `json:"mode"` // observe, enforce
+	PolicyPath   string  `json:"policy_path"`
+	FlushSeconds int     `json:"flush_seconds"`
+	Action       string  `json:"action"` // block, log
+	LengthSlack  float64 `json:"length_slack"`
+}
+
+// OpenAPIConfig настройки проверки запросов по спецификации OpenAPI 3 (JSON)
+type OpenAPIConfig struct {
+	SpecPath                string `json:"spec_path"`
+	BasePath                string `json:"base_path"`
+	AllowUnknownQueryParams bool   `json:"allow_unknown_query_params"`
+	Action                  string `json:"action"` // block, log
+}
+
+// RequestSchemaConfig настройки проверки JSON тел по схемам маршрутов
+type RequestSchemaConfig struct {
+	Routes       []RequestSchemaRouteConfig `json:"routes"`
+	MaxBodyBytes int64                      `json:"max_body_bytes"`
+	Action       string                     `json:"action"` // block, log
+}
+
+// RequestSchemaRouteConfig JSON Schema для маршрута: из файла или inline
+type RequestSchemaRouteConfig struct {
+	Path       string          `json:"path"`
+	Methods    []string        `json:"methods"`
+	SchemaPath string          `json:"schema_path"`
+	Schema     json.RawMessage `json:"schema"`
+}
+
+// CSRFConfig настройки CSRF защиты (Origin/Referer и double-submit токен)
+type CSRFConfig struct {
+	Routes         []string `json:"routes"`
+	AllowedOrigins []string `json:"allowed_origins"`
+	RequireOrigin  bool     `json:"require_origin"`
+	CookieName     string   `json:"cookie_name"`
+	HeaderName     string   `json:"header_name"`
+	FieldName      string   `json:"field_name"`
+	Secret         string   `json:"secret"`
+}
+
+// CORSConfig CORS политика, применяемая WAF
+type CORSConfig struct {
+	AllowedOrigins   []string `json:"allowed_origins"` // "*", точные источники или https://*.example.com
+	AllowedMethods   []string `json:"allowed_methods"`
+	AllowedHeaders   []string `json:"allowed_headers"`
+	ExposedHeaders   []string `json:"exposed_headers"`
+	AllowCredentials bool     `json:"allow_credentials"`
+	MaxAgeSeconds    int      `json:"max_age_seconds"`
+	BlockDisallowed  bool     `json:"block_disallowed"`
+}
+
+// CookieSecurityConfig политика атрибутов и защиты значений cookie бэкенда
+type CookieSecurityConfig struct {
+	ForceSecure     bool     `json:"force_secure"`
+	ForceHTTPOnly   bool     `json:"force_http_only"`
+	HTTPOnlyExclude []string `json:"http_only_exclude"`
+	SameSite        string   `json:"same_site"` // lax, strict, none
+	Encrypt         []string `json:"encrypt"`
+	Sign            []string `json:"sign"`
+	Secret          string   `json:"secret"`
+}
+
+// JWTConfig настройки проверки JWT
+type JWTConfig struct {
+	Routes             []string          `json:"routes"`   // пусто — все пути
+	Optional           bool              `json:"optional"` // запросы без токена пропускаются без claims
+	CookieName         string            `json:"cookie_name"`
+	Algorithms         []string          `json:"algorithms"` // по умолчанию RS256, ES256
+	HMACSecret         string            `json:"hmac_secret"`
+	PublicKeyFiles     map[string]string `json:"public_key_files"` // kid -> PEM; "" — ключ без kid
+	JWKSURL            string            `json:"jwks_url"`
+	JWKSRefreshSeconds int               `json:"jwks_refresh_seconds"`
+	Audience           string            `json:"audience"`
+	Issuer             string            `json:"issuer"`
+	LeewaySeconds      int               `json:"leeway_seconds"`
+	ForwardClaims      map[string]string `json:"forward_claims"` // claim -> заголовок запроса к бэкенду
+}
+
+// IntrospectionConfig настройки интроспекции OAuth2 токенов (RFC 7662)
+type IntrospectionConfig struct {
+	Endpoint             string            `json:"endpoint"`
+	ClientID             string            `json:"client_id"`
+	ClientSecret         string            `json:"client_secret"`
+	Routes               []string          `json:"routes"`
+	Optional             bool              `json:"optional"`
+	CookieName           string            `json:"cookie_name"`
+	RequiredScopes       []string          `json:"required_scopes"`
+	ForwardClaims        map[string]string `json:"forward_claims"`
+	CacheSeconds         int               `json:"cache_seconds"`          // не дольше exp токена
+	NegativeCacheSeconds int               `json:"negative_cache_seconds"` // кеш неактивных токенов
+	MaxCacheSize         int               `json:"max_cache_size"`
+	TimeoutSeconds       int               `json:"timeout_seconds"`
+	FailOpen             bool              `json:"fail_open"` // пропускать запросы при недоступности endpoint
+}
+
+// CacheConfig настройки кеша ответов на GET запросы
+type CacheConfig struct {
+	MaxSizeMB           int `json:"max_size_mb"`
+	MaxEntryKB          int `json:"max_entry_kb"`
+	DefaultTTLSeconds   int `json:"default_ttl_seconds"`    // для ответов без Cache-Control/Expires; 0 — не кешировать
+	StaleIfErrorSeconds int `json:"stale_if_error_seconds"` // сколько отдавать устаревшую копию при ошибках бэкенда
+}
+
+// RewriteConfig правила переписывания запросов и ответов
+type RewriteConfig struct {
+	Request  RewriteRequestConfig  `json:"request"`
+	Response RewriteResponseConfig `json:"response"`
+}
+
+type RewriteRequestConfig struct {
+	RemoveHeaders []string            `json:"remove_headers"`
+	SetHeaders    map[string]string   `json:"set_headers"`
+	PathPrefixes  []PathPrefixRewrite `json:"path_prefixes"` // применяется первое совпавшее правило
+	PathRegex     []RegexRewrite      `json:"path_regex"`
+}
+
+type RewriteResponseConfig struct {
+	RemoveHeaders    []string          `json:"remove_headers"`
+	SetHeaders       map[string]string `json:"set_headers"`
+	BodyRegex        []RegexRewrite    `json:"body_regex"`
+	BodyContentTypes []string          `json:"body_content_types"` // по умолчанию text/html
+	MaxBodyKB        int               `json:"max_body_kb"`        // большие ответы передаются без изменений
+}
+
+// PathPrefixRewrite заменяет префикс пути from на to
+type PathPrefixRewrite struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// RegexRewrite замена по регулярному выражению; replacement поддерживает $1 и ${name}
+type RegexRewrite struct {
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement"`
+}
+
+// PathCanonicalizationConfig настройки канонизации путей (включена по умолчанию)
+type PathCanonicalizationConfig struct {
+	Disable         bool `json:"disable"`
+	KeepBackslashes bool `json:"keep_backslashes"` // не считать \ разделителем пути
+}
+
+// RequestDecompressionConfig распаковка тел запросов с Content-Encoding перед проверкой
+type RequestDecompressionConfig struct {
+	Disable          bool  `json:"disable"`
+	MaxBytes         int64 `json:"max_bytes"`         // размер распакованного тела; по умолчанию 10 МБ
+	AllowUnsupported bool  `json:"allow_unsupported"` // пропускать без проверки br, zstd и др. вместо 415
+}
+
+// ConnectionLimitConfig лимиты TCP соединений на уровне listener (0 — без ограничения)
+type ConnectionLimitConfig struct {
+	MaxPerIP int `json:"max_per_ip"` // соединения сверх лимита сразу закрываются
+	MaxTotal int `json:"max_total"`  // при достижении лимита новые соединения ждут в очереди
+}
+
+// UpstreamsConfig пул бэкендов; если задан, заменяет server_address
+type UpstreamsConfig struct {
+	Targets     []string          `json:"targets"`
+	HealthCheck HealthCheckConfig `json:"health_check"`
+}
+
+// HealthCheckConfig настройки активных проверок бэкендов
+type HealthCheckConfig struct {
+	Path               string `json:"path"`
+	IntervalSeconds    int    `json:"interval_seconds"`
+	TimeoutSeconds     int    `json:"timeout_seconds"`
+	HealthyThreshold   int    `json:"healthy_threshold"`   // успешных проверок подряд для возврата в пул
+	UnhealthyThreshold int    `json:"unhealthy_threshold"` // неудачных проверок подряд для исключения
+	ExpectedStatuses   []int  `json:"expected_statuses"`   // по умолчанию 2xx и 3xx
+}
+
+// CircuitBreakerConfig настройки размыкания цепи при ошибках бэкенда
+type CircuitBreakerConfig struct {
+	FailureThreshold int                  `json:"failure_threshold"` // ошибок подряд для размыкания
+	OpenSeconds      int                  `json:"open_seconds"`
+	HalfOpenProbes   int                  `json:"half_open_probes"` // одновременных пробных запросов
+	FailureStatuses  []int                `json:"failure_statuses"` // по умолчанию 500, 502, 503, 504
+	Response         StaticResponseConfig `json:"response"`
+}
+
+// StaticResponseConfig статический ответ WAF
+type StaticResponseConfig struct {
+	Status      int    `json:"status"`
+	Body        string `json:"body"`
+	ContentType string `json:"content_type"`
+}
+
+// RetryConfig политика повторов идемпотентных запросов к бэкенду
+type RetryConfig struct {
+	MaxRetries    int                `json:"max_retries"`
+	Routes        []RetryRouteConfig `json:"routes"`
+	RetryStatuses []int              `json:"retry_statuses"` // по умолчанию 502, 503, 504
+	BackoffMs     int                `json:"backoff_ms"`
+	MaxBackoffMs  int                `json:"max_backoff_ms"`
+	Budget        RetryBudgetConfig  `json:"budget"`
+}
+
+// RetryRouteConfig число повторов для маршрута; 0 — не повторять
+type RetryRouteConfig struct {
+	Path       string `json:"path"`
+	MaxRetries int    `json:"max_retries"`
+}
+
+// RetryBudgetConfig доля повторов от числа запросов за окно
+type RetryBudgetConfig struct {
+	Ratio         float64 `json:"ratio"`
+	MinRetries    int     `json:"min_retries"` // разрешено в окне независимо от ratio
+	WindowSeconds int     `json:"window_seconds"`
+}
+
+// RequestIDConfig идентификаторы запросов
+type RequestIDConfig struct {
+	Header        string `json:"header"`         // по умолчанию X-Request-ID
+	TrustIncoming bool   `json:"trust_incoming"` // принимать идентификатор от балансировщика перед WAF
+}
+
+// TimeoutsConfig таймауты в миллисекундах; 0 — значение по умолчанию
+type TimeoutsConfig struct {
+	ReadHeaderMs             int `json:"read_header_ms"` // по умолчанию 10 с
+	ReadMs                   int `json:"read_ms"`        // чтение всего запроса, 60 с
+	WriteMs                  int `json:"write_ms"`       // запись ответа; по умолчанию без ограничения
+	IdleMs                   int `json:"idle_ms"`        // keep-alive соединения клиента, 120 с
+	UpstreamDialMs           int `json:"upstream_dial_ms"`
+	UpstreamTLSHandshakeMs   int `json:"upstream_tls_handshake_ms"`
+	UpstreamResponseHeaderMs int `json:"upstream_response_header_ms"`
+	UpstreamTotalMs          int `json:"upstream_total_ms"` // весь запрос к бэкенду; по умолчанию без ограничения
+	UpstreamIdleMs           int `json:"upstream_idle_ms"`
+	ShutdownMs               int `json:"shutdown_ms"` // ожидание активных запросов при остановке и обновлении, 30 с
+}
+
+// TransportConfig пул соединений обратного прокси к бэкендам
+type TransportConfig struct {
+	MaxIdleConns        int  `json:"max_idle_conns"`          // по умолчанию 512
+	MaxIdleConnsPerHost int  `json:"max_idle_conns_per_host"` // по умолчанию 128 (в net/http — 2)
+	MaxConnsPerHost     int  `json:"max_conns_per_host"`      // 0 — без ограничения
+	KeepAliveSeconds    int  `json:"keep_alive_seconds"`      // период TCP keep-alive, 30 с; -1 — выключен
+	DisableKeepAlives   bool `json:"disable_keep_alives"`     // новое соединение на каждый запрос
+	DisableCompression  bool `json:"disable_compression"`     // не запрашивать gzip у бэкенда за клиента, не поддерживающего сжатие
+}
+
+// TLSConfig терминация TLS на основном listener
+type TLSConfig struct {
+	CertFile      string   `json:"cert_file"` // пусто — listener без TLS
+	KeyFile       string   `json:"key_file"`
+	MinVersion    string   `json:"min_version"`    // 1.0, 1.1, 1.2 (по умолчанию), 1.3
+	CipherSuites  []string `json:"cipher_suites"`  // имена Go для TLS 1.0–1.2; пусто — набор Go по умолчанию
+	ReloadSeconds int      `json:"reload_seconds"` // период проверки файлов сертификата; по умолчанию 60
+	OCSPStapling  bool     `json:"ocsp_stapling"`
+
+	Certificates []TLSCertConfig `json:"certificates"` // сертификаты других доменов; выбираются по SNI
+}
+
+// TLSCertConfig дополнительный сертификат listener, выбираемый по SNI
+type TLSCertConfig struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+}
+
+// SNIRouteConfig бэкенд для соединений с указанными именами SNI
+type SNIRouteConfig struct {
+	SNI           []string        `json:"sni"` // точные имена или *.example.com
+	ServerAddress string          `json:"server_address"`
+	Upstreams     UpstreamsConfig `json:"upstreams"` // вместо server_address — пул с проверками доступности
+}
+
+// TLSClientConfig обнаружение клиентов с устаревшим стеком TLS
+type TLSClientConfig struct {
+	WeakVersion string  `json:"weak_version"` // версии ниже считаются устаревшими; по умолчанию 1.2
+	Action      string  `json:"action"`       // log (по умолчанию), rate_limit, block, challenge
+	RiskScore   float64 `json:"risk_score"`
+	Limit       float64 `json:"limit"` // запросов в секунду для rate_limit; по умолчанию 0.5
+	Burst       int     `json:"burst"`
+}
+
+// HTTP3Config QUIC listener (требует сборки с тегом http3)
+type HTTP3Config struct {
+	Addr          string `json:"addr"` // UDP адрес, например :443; пусто — HTTP/3 выключен
+	CertFile      string `json:"cert_file"`
+	KeyFile       string `json:"key_file"`
+	AdvertisePort int    `json:"advertise_port"` // порт в Alt-Svc, если отличается от порта addr
+}
+
+// ExtAuthzConfig режим внешней авторизации Envoy (требует сборки с тегом extauthz)
+type ExtAuthzConfig struct {
+	Addr         string `json:"addr"`           // TCP адрес gRPC сервера, например :9191; пусто — режим выключен
+	MaxBodyBytes int    `json:"max_body_bytes"` // тело из CheckRequest, переданное модулям; по умолчанию 64 КБ
+}
+
+// KubernetesConfig политика из объекта WAFPolicy (CRD); spec накладывается поверх файла конфигурации
+type KubernetesConfig struct {
+	Policy    string `json:"policy"`     // имя объекта WAFPolicy; пусто — режим выключен
+	Namespace string `json:"namespace"`  // по умолчанию namespace пода
+	APIServer string `json:"api_server"` // по умолчанию адрес API из окружения пода
+}
+
+// RuleUpdatesConfig периодическая загрузка подписанного набора правил; набор накладывается
+// поверх файла конфигурации
+type RuleUpdatesConfig struct {
+	URL             string   `json:"url"`              // адрес набора; пусто — режим выключен
+	SignatureURL    string   `json:"signature_url"`    // подпись Ed25519 в base64; по умолчанию url + ".sig"
+	PublicKeys      []string `json:"public_keys"`      // доверенные открытые ключи в base64; несколько — для смены ключа
+	IntervalSeconds int      `json:"interval_seconds"` // период проверки; по умолчанию 300
+	// StatePath файл с версией последнего примененного набора; по умолчанию rule_updates.json
+	// в rule_bundles.dir или в рабочем каталоге
+	StatePath string `json:"state_path"`
+}
+
+// ClusterConfig обмен банами и риском клиентов между экземплярами WAF по UDP без Redis
+type ClusterConfig struct {
+	Bind             string   `json:"bind"`               // UDP-адрес обмена, например :7946; пусто — режим выключен
+	Peers            []string `json:"peers"`              // адреса host:port других экземпляров; имя может давать несколько адресов
+	Secret           string   `json:"secret"`             // общий ключ подписи сообщений, не короче 16 байт
+	Node             string   `json:"node"`               // имя экземпляра в журнале и API; по умолчанию имя хоста
+	GossipIntervalMs int      `json:"gossip_interval_ms"` // период обмена списком экземпляров; по умолчанию 1000
+	Fanout           int      `json:"fanout"`             // экземпляров, которым пересылается каждое сообщение; по умолчанию 3
+	RiskThreshold    float64  `json:"risk_threshold"`     // штраф репутации для передачи клиента; по умолчанию reputation.tighten_score
+}
+
+// FleetConfig синхронизация конфигурации с лидером: экземпляр забирает конфигурацию через
+// API администратора лидера и сообщает ему примененную версию
+type FleetConfig struct {
+	Leader          string `json:"leader"`           // адрес API администратора лидера; пусто — режим выключен
+	APIKey          string `json:"api_key"`          // ключ с ролью admin на лидере
+	Node            string `json:"node"`             // имя экземпляра в отчетах; по умолчанию имя хоста
+	IntervalSeconds int    `json:"interval_seconds"` // период проверки; по умолчанию 10
+}
+
+// StandbyConfig резервный экземпляр: повторяет конфигурацию, баны и состояния клиентов
+// основного и начинает применять правила только после повышения
+type StandbyConfig struct {
+	Primary              string `json:"primary"`                // адрес API администратора основного; пусто — режим выключен
+	APIKey               string `json:"api_key"`                // ключ с ролью admin на основном
+	Node                 string `json:"node"`                   // имя в отчетах основному; по умолчанию имя хоста
+	IntervalSeconds      int    `json:"interval_seconds"`       // период копирования; по умолчанию 2
+	PromoteAfterFailures int    `json:"promote_after_failures"` // повысить после N неудачных обращений подряд; 0 — только вручную
+}
+
+// ForwardAuthConfig endpoint проверки для nginx auth_request / Traefik ForwardAuth
+type ForwardAuthConfig struct {
+	Addr       string `json:"addr"`        // отдельный listener, доступный только прокси; пусто — выключен
+	Path       string `json:"path"`        // по умолчанию /verify
+	DenyStatus int    `json:"deny_status"` // код всех отказов (nginx принимает только 401/403); 0 — код модуля
+	// ClientIPHeader заголовок с адресом клиента, который прокси всегда перезаписывает,
+	// например X-Real-IP; пусто — запись X-Forwarded-For, добавленная прокси
+	ClientIPHeader string `json:"client_ip_header"`
+	TrustedHops    int    `json:"trusted_hops"` // прокси, дописывающих X-Forwarded-For перед WAF; по умолчанию 1
+}
+
+// WASMConfig модули обнаружения WebAssembly (требует сборки с тегом wazero)
+type WASMConfig struct {
+	Modules     []WASMModuleConfig `json:"modules"`
+	TimeoutMs   int                `json:"timeout_ms"`    // на вызов модуля, по умолчанию 10 мс
+	MaxMemoryMB int                `json:"max_memory_mb"` // память экземпляра модуля, по умолчанию 16 МБ
+	MaxBodyKB   int                `json:"max_body_kb"`   // начало тела, передаваемое модулям; 0 — тело не передается
+	BanRisk     float64            `json:"ban_risk"`      // бан при достижении накопленного риска; 0 — выключен
+	BanSeconds  int                `json:"ban_seconds"`
+}
+
+type WASMModuleConfig struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// RulesConfig пользовательские правила на языке выражений
+type RulesConfig struct {
+	Lists         map[string][]string `json:"lists"` // именованные списки (строки, IP, CIDR), доступны в выражениях по имени
+	Rules         []RuleConfig        `json:"rules"`
+	CanaryPercent float64             `json:"canary_percent"` // доля клиентов для всех правил без своей доли; 0 или 100 — все клиенты
+}
+
+type RuleConfig struct {
+	Name       string  `json:"name"`
+	When       string  `json:"when"`   // условие, например request.path.startsWith("/admin")
+	Action     string  `json:"action"` // block (по умолчанию), ban, throttle, delay, challenge, log, allow
+	BanSeconds int     `json:"ban_seconds"`
+	DelayMs    int     `json:"delay_ms"`
+	RiskScore  float64 `json:"risk_score"` // добавляется к риску клиента при совпадении
+	// CanaryPercent правило применяется к этой доле клиентов (по хэшу IP), для остальных только
+	// записывается в журнал; 0 — доля rules.canary_percent, 100 — все клиенты
+	CanaryPercent float64 `json:"canary_percent"`
+}
+
+// EventsConfig получатели событий безопасности
+type EventsConfig struct {
+	Sinks  []EventSinkConfig `json:"sinks"`  // пусто — события пишутся только в журнал
+	Buffer int               `json:"buffer"` // очередь каждого получателя, по умолчанию 1024 события
+}
+
+type EventSinkConfig struct {
+	Type        string          `json:"type"` // log, webhook, metrics, syslog, nats, kafka или тип, зарегистрированный RegisterEventSink
+	Name        string          `json:"name"`
+	MinSeverity string          `json:"min_severity"` // info, warning, critical
+	Types       []string        `json:"types"`        // detection, ban, account_lock, upstream, shadow; пусто — все
+	Modules     []string        `json:"modules"`      // пусто — все модули
+	Tenants     []string        `json:"tenants"`      // пусто — все арендаторы; "" — основной WAF
+	Settings    json.RawMessage `json:"settings"`     // параметры получателя
+}
+
+// TenantConfig арендатор: запросы с его Host или префиксом пути обрабатываются отдельной
+// цепью модулей с собственными банами и лимитами
+type TenantConfig struct {
+	Name       string          `json:"name"`
+	Hosts      []string        `json:"hosts"`       // точные имена или *.example.com
+	PathPrefix string          `json:"path_prefix"` // вместе с hosts — должны совпасть оба
+	Config     json.RawMessage `json:"config"`      // накладывается на основную конфигурацию
+}
+
+// ProfileBindingConfig привязка профиля безопасности к хостам и маршрутам
+type ProfileBindingConfig struct {
+	Profile string   `json:"profile"`
+	Hosts   []string `json:"hosts"`  // точные имена или *.example.com; пусто — любой Host
+	SNI     []string `json:"sni"`    // имена TLS SNI (точные или *.example.com); пусто — любое соединение
+	Routes  []string `json:"routes"` // шаблоны маршрутов (/api/*); пусто — любой путь
+}
+
+// ScheduleConfig вариант политики по расписанию: настройки config накладываются на основную
+// конфигурацию, пока текущая минута подходит под выражение cron
+type ScheduleConfig struct {
+	Name     string          `json:"name"`
+	Cron     string          `json:"cron"`     // минута час день месяц день_недели
+	Timezone string          `json:"timezone"` // IANA, например Europe/Moscow; по умолчанию локальное время
+	Config   json.RawMessage `json:"config"`
+}
+
+// MaintenanceConfig режим обслуживания: статический ответ вместо проксирования
+type MaintenanceConfig struct {
+	Enabled           bool            `json:"enabled"`
+	Routes            []string        `json:"routes"` // пусто — все запросы
+	Allow             []string        `json:"allow"`  // IP и CIDR, запросы которых идут к бэкенду как обычно
+	Status            int             `json:"status"` // по умолчанию 503
+	RetryAfterSeconds int             `json:"retry_after_seconds"`
+	HTML              string          `json:"html"`
+	HTMLFile          string          `json:"html_file"`
+	JSON              json.RawMessage `json:"json"` // ответ клиентам, которые принимают JSON и не принимают HTML
+}
+
+// ReputationConfig локальная репутация клиентов: штрафы за мелкие события и срабатывания
+// затухают со временем, модули снижают пороги для клиентов с большим штрафом
+type ReputationConfig struct {
+	Enabled         bool `json:"enabled"`
+	HalfLifeSeconds int  `json:"half_life_seconds"` // штраф уменьшается вдвое; по умолчанию 3600
+	// Штраф по источнику (not_found, rate_warning, rate_exceeded, signature_near_miss,
+	// login_failure), модулю или важности срабатывания
+	Weights      map[string]float64 `json:"weights"`
+	TightenScore float64            `json:"tighten_score"` // штраф, при котором пороги снижаются вдвое; по умолчанию 10
+	MinFactor    float64            `json:"min_factor"`    // наименьший множитель порогов; по умолчанию 0.25
+}
+
+// ForensicsConfig история последних запросов каждого клиента для событий бана
+type ForensicsConfig struct {
+	Requests int `json:"requests"` // запросов на клиента; 0 — история не ведется
+}
+
+// ExclusionConfig исключение для ложных срабатываний: срабатывание модуля module на пути path
+// не учитывается. Пустые rule и param подходят к любому правилу и параметру.
+type ExclusionConfig struct {
+	Module  string `json:"module"`
+	Rule    string `json:"rule"`  // правило rules или тип атаки signature (SQLi, XSS, ...)
+	Path    string `json:"path"`  // шаблон маршрута, например /search/{id}; пусто — любой путь
+	Param   string `json:"param"` // query-параметр
+	Comment string `json:"comment"`
+}
+
+// GeoIPConfig базы в формате MaxMind DB (GeoLite2, GeoIP2, DB-IP) для обогащения событий
+type GeoIPConfig struct {
+	CityDB    string `json:"city_db"`    // страна и город, например GeoLite2-City.mmdb
+	CountryDB string `json:"country_db"` // только страна, если базы городов нет
+	ASNDB     string `json:"asn_db"`     // автономная система, например GeoLite2-ASN.mmdb
+}
+
+// ShadowConfig теневой режим: доля запросов проверяется набором правил-кандидатом без применения
+type ShadowConfig struct {
+	Percent float64         `json:"percent"` // доля зеркалируемых запросов, 0–100; 0 — выключен
+	Config  json.RawMessage `json:"config"`  // настройки кандидата поверх основной конфигурации
+}
+
+// DecisionConfig движок решений: детекторы сообщают о срабатываниях, действие выбирается
+// по сумме их весов в конце цепи
+type DecisionConfig struct {
+	Enabled bool `json:"enabled"`
+	// Вес срабатывания по ключу "модуль:правило" или "модуль:атака", "модуль" или важности
+	// (info, warning, critical); по умолчанию 1, 3 и 5 по важности
+	Weights        map[string]float64 `json:"weights"`
+	ChallengeScore float64            `json:"challenge_score"` // 0 — без проверки клиента
+	BlockScore     float64            `json:"block_score"`     // по умолчанию 5
+	BanScore       float64            `json:"ban_score"`       // 0 — движок не банит
+	BanSeconds     int                `json:"ban_seconds"`     // по умолчанию 600
+	// Действие по важности срабатывания (info, warning, critical -> log, challenge, block, ban);
+	// применяется, если оно строже действия по сумме весов
+	SeverityActions map[string]string     `json:"severity_actions"`
+	Routes          []DecisionRouteConfig `json:"routes"` // первый подходящий маршрут заменяет общие настройки
+}
+
+// DecisionRouteConfig действия по важности для маршрута
+type DecisionRouteConfig struct {
+	Path            string            `json:"path"`
+	SeverityActions map[string]string `json:"severity_actions"`
+	BanSeconds      int               `json:"ban_seconds"` // 0 — как в общих настройках
+}
+
+// RuleTestsConfig корпуса тестов правил, прогоняемые при запуске
+type RuleTestsConfig struct {
+	Files       []string `json:"files"`
+	FailOnError bool     `json:"fail_on_error"` // не запускаться, если тест не пройден
+}
+
+// AdminConfig панель администратора и API управления банами
+type AdminConfig struct {
+	Addr string          `json:"addr"` // отдельный listener, недоступный клиентам; пусто — выключена
+	Auth AdminAuthConfig `json:"auth"`
+}
+
+// AdminAuthConfig аутентификация панели администратора; без ключей и OIDC доступ не проверяется
+type AdminAuthConfig struct {
+	APIKeys []AdminAPIKeyConfig `json:"api_keys"`
+	OIDC    *AdminOIDCConfig    `json:"oidc"`
+}
+
+// AdminAPIKeyConfig ключ API (заголовок X-API-Key или Authorization: Bearer)
+type AdminAPIKeyConfig struct {
+	Name string `json:"name"` // имя исполнителя в журнале действий
+	Key  string `json:"key"`
+	Role string `json:"role"` // viewer, operator или admin
+}
+
+// AdminOIDCConfig вход по токенам OpenID Connect
+type AdminOIDCConfig struct {
+	Issuer      string            `json:"issuer"`
+	Audience    string            `json:"audience"`   // client_id панели
+	JWKSURL     string            `json:"jwks_url"`   // пусто — из discovery издателя
+	Algorithms  []string          `json:"algorithms"` // по умолчанию RS256, ES256
+	UserClaim   string            `json:"user_claim"` // по умолчанию email, затем sub
+	RoleClaim   string            `json:"role_claim"` // по умолчанию roles
+	Roles       map[string]string `json:"roles"`      // значение claim -> роль
+	DefaultRole string            `json:"default_role"`
+}
+
+// AuditConfig журнал административных действий
+// RuleBundlesConfig история применявшихся версий конфигурации для отката
+type RuleBundlesConfig struct {
+	Keep int    `json:"keep"` // сколько версий хранить; 0 — 10
+	Dir  string `json:"dir"`  // каталог для версий на диске; пусто — только в памяти
+}
+
+type AuditConfig struct {
+	Path string `json:"path"` // файл JSON Lines только для дозаписи; пусто — записи идут в журнал WAF
+}
+
+type Config struct {
+	RateLimit                       RateLimitConfig             `json:"rate_limit"`
+	Signature                       SignatureConfig             `json:"signature"`
+	Context                         ContextConfig               `json:"context"`
+	LoginProtection                 LoginProtectionConfig       `json:"login_protection"`
+	Enumeration                     EnumerationConfig           `json:"enumeration"`
+	Scanner                         ScannerConfig               `json:"scanner"`
+	Sequence                        SequenceConfig              `json:"sequence"`
+	ParamAnomaly                    ParamAnomalyConfig          `json:"param_anomaly"`
+	AnomalyModel                    AnomalyModelConfig          `json:"anomaly_model"`
+	PositiveModel                   PositiveModelConfig         `json:"positive_model"`
+	OpenAPI                         OpenAPIConfig               `json:"openapi"`
+	RequestSchema                   RequestSchemaConfig         `json:"json_schema"`
+	CSRF                            CSRFConfig                  `json:"csrf"`
+	CORS                            CORSConfig                  `json:"cors"`
+	CookieSecurity                  CookieSecurityConfig        `json:"cookie_security"`
+	JWT                             JWTConfig                   `json:"jwt"`
+	Introspection                   IntrospectionConfig         `json:"introspection"`
+	Cache                           CacheConfig                 `json:"cache"`
+	Rewrite                         RewriteConfig               `json:"rewrite"`
+	PathCanonicalization            PathCanonicalizationConfig  `json:"path_canonicalization"`
+	ConnectionLimit                 ConnectionLimitConfig       `json:"connection_limit"`
+	Upstreams                       UpstreamsConfig             `json:"upstreams"`
+	CircuitBreaker                  CircuitBreakerConfig        `json:"circuit_breaker"`
+	Retry                           RetryConfig                 `json:"retry"`
+	Timeouts                        TimeoutsConfig              `json:"timeouts"`
+	Transport                       TransportConfig             `json:"transport"`
+	RequestDecompression            RequestDecompressionConfig  `json:"request_decompression"`
+	RequestID                       RequestIDConfig             `json:"request_id"`
+	HTTP3                           HTTP3Config                 `json:"http3"`
+	TLS                             TLSConfig                   `json:"tls"`
+	TLSClient                       TLSClientConfig             `json:"tls_client"`
+	SNIRoutes                       []SNIRouteConfig            `json:"sni_routes"` // проверяются по порядку; без совпадения — server_address
+	ExtAuthz                        ExtAuthzConfig              `json:"ext_authz"`
+	Kubernetes                      KubernetesConfig            `json:"kubernetes"`
+	RuleUpdates                     RuleUpdatesConfig           `json:"rule_updates"`
+	Cluster                         ClusterConfig               `json:"cluster"`
+	Fleet                           FleetConfig                 `json:"fleet"`
+	Standby                         StandbyConfig               `json:"standby"`
+	ForwardAuth                     ForwardAuthConfig           `json:"forward_auth"`
+	WASM                            WASMConfig                  `json:"wasm"`
+	Rules                           RulesConfig                 `json:"rules"`
+	Events                          EventsConfig                `json:"events"`
+	Admin                           AdminConfig                 `json:"admin"`
+	Audit                           AuditConfig                 `json:"audit"`
+	RuleBundles                     RuleBundlesConfig           `json:"rule_bundles"`
+	Tenants                         []TenantConfig              `json:"tenants"`
+	Profiles                        map[string]json.RawMessage  `json:"profiles"` // имя -> настройки поверх основной конфигурации
+	ProfileBindings                 []ProfileBindingConfig      `json:"profile_bindings"`
+	Shadow                          ShadowConfig                `json:"shadow"`
+	Decision                        DecisionConfig              `json:"decision"`
+	Maintenance                     MaintenanceConfig           `json:"maintenance"`
+	Reputation                      ReputationConfig            `json:"reputation"`
+	Forensics                       ForensicsConfig             `json:"forensics"`
+	GoodBots                        GoodBotsConfig              `json:"good_bots"`
+	Device                          DeviceConfig                `json:"device"`
+	HeaderAnomaly                   HeaderAnomalyConfig         `json:"header_anomaly"`
+	ContentType                     ContentTypeConfig           `json:"content_type"`
+	ControlChars                    ControlCharsConfig          `json:"control_chars"`
+	CRLF                            CRLFConfig                  `json:"crlf"`
+	HostHeader                      HostHeaderConfig            `json:"host_header"`
+	Hotlink                         HotlinkConfig               `json:"hotlink"`
+	Upload                          UploadConfig                `json:"upload"`
+	Verdict                         VerdictConfig               `json:"verdict"`
+	GeoIP                           GeoIPConfig                 `json:"geoip"`
+	Exclusions                      []ExclusionConfig           `json:"exclusions"`
+	Schedules                       []ScheduleConfig            `json:"schedules"` // применяются по порядку поверх основной конфигурации
+	RuleTests                       RuleTestsConfig             `json:"rule_tests"`
+	Plugins                         map[string]json.RawMessage  `json:"plugins"`      // настройки плагинов по имени модуля
+	PluginFiles                     []string                    `json:"plugin_files"` // плагины .so (go build -buildmode=plugin)
+	MiddlewareChain                 []string                    `json:"middleware_chain"`
+	WAFPort                         string                      `json:"waf_port"`
+	ListenFDName                    string                      `json:"listen_fd_name"` // имя сокета из LISTEN_FDNAMES при socket activation
+	ServerAddress                   string                      `json:"server_address"`
+	PathTraversalPatternsPath       string                      `json:"path_traversal_patterns_path"`
+	PathTraversalPatternsSource     PathTraversalPatternsSource `json:"path_traversal_patterns_source"`
+	PathTraversalPatternsSourceFile PathTraversalPatternsSource `json:"path_traversal_patterns_source_file"`
+}
+
+type PathTraversalPatternsSource struct {
+	SourceType string `json:"source_type"`
+	Source     string `json:"source"`
+	Format     string `json:"format"`
+	Enable     bool   `json:"enable"`
+}
@@ -0,0 +1,102 @@
+package waf
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// LoadConfig загружает конфиг из JSON. При отсутствии файла возвращает nil;
+// файл, который не удалось прочитать или разобрать, считается ошибкой
+func LoadConfig(path string) (*Config, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		// нет файла = нет конфига
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	c, err := parseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// parseConfig разбирает содержимое файла конфигурации и подставляет ссылки на секреты
+func parseConfig(data []byte) (*Config, error) {
+	var c Config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, jsonPositionError(data, err)
+	}
+	// Позиции ошибок указываются по исходному файлу, поэтому секреты подставляются
+	// после первого разбора; подстановка меняет только строки и не может сломать типы
+	resolved, err := resolveSecrets(data)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(resolved, data) {
+		c = Config{}
+		if err := json.Unmarshal(resolved, &c); err != nil {
+			return nil, err
+		}
+	}
+	return &c, nil
+}
+
+// jsonPositionError дополняет ошибку разбора JSON строкой и столбцом
+func jsonPositionError(data []byte, err error) error {
+	var offset int64
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+	default:
+		return err
+	}
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	line, col := 1, 1
+	for _, b := range data[:offset] {
+		if b == '\n' {
+			line, col = line+1, 1
+		} else {
+			col++
+		}
+	}
+	return fmt.Errorf("line %d, column %d: %w", line, col, err)
+}
+
+// mergeConfig накладывает JSON patch в формате файла конфигурации на копию base.
+// Заданные в patch поля заменяют значения base, неизвестные поля считаются ошибкой.
+func mergeConfig(base *Config, patch json.RawMessage) (*Config, error) {
+	var c Config
+	if base != nil {
+		data, err := json.Marshal(base)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &c); err != nil {
+			return nil, err
+		}
+	}
+	if len(patch) > 0 {
+		dec := json.NewDecoder(bytes.NewReader(patch))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&c); err != nil {
+			return nil, err
+		}
+	}
+	return &c, nil
+}
@@ -0,0 +1,268 @@
+package waf
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultRuleBundlesKeep версий конфигурации в истории по умолчанию
+const defaultRuleBundlesKeep = 10
+
+// ruleBundle версия конфигурации (набора правил), применявшаяся к WAF
+type ruleBundle struct {
+	Version    string    `json:"version"` // configVersion без кавычек
+	LoadedAt   time.Time `json:"loaded_at"`
+	Source     string    `json:"source"` // кто применил: startup, admin, kubernetes, rollback...
+	Chain      []string  `json:"chain"`
+	Rules      int       `json:"rules"`                 // правил rules.rules
+	RolledBack bool      `json:"rolled_back,omitempty"` // с этой версии откатывались; откат по умолчанию ее пропускает
+	Config     *Config   `json:"config"`                // nil — настройки по умолчанию
+
+	file string // файл версии в каталоге истории
+}
+
+// bundleHistory последние применявшиеся версии конфигурации, от старых к новым;
+// последняя — текущая
+type bundleHistory struct {
+	mu      sync.Mutex
+	keep    int
+	dir     string // пусто — история только в памяти
+	bundles []*ruleBundle
+}
+
+func newBundleHistory() *bundleHistory {
+	return &bundleHistory{keep: defaultRuleBundlesKeep}
+}
+
+// SetRuleBundles настраивает историю версий конфигурации. С каталогом dir версии
+// сохраняются на диск и загружаются из него при запуске, так что откат доступен и после
+// перезапуска. Вызывается до первого Reload.
+func (w *WAF) SetRuleBundles(cfg RuleBundlesConfig) error {
+	h := newBundleHistory()
+	if cfg.Keep > 0 {
+		h.keep = cfg.Keep
+	}
+	if cfg.Dir != "" {
+		if err := os.MkdirAll(cfg.Dir, 0o700); err != nil {
+			return err
+		}
+		h.dir = cfg.Dir
+		if err := h.load(); err != nil {
+			return err
+		}
+	}
+	w.bundles = h
+	return nil
+}
+
+// load читает версии из каталога истории
+func (h *bundleHistory) load() error {
+	files, err := filepath.Glob(filepath.Join(h.dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		b := &ruleBundle{file: file}
+		if err := json.Unmarshal(data, b); err != nil || b.Version == "" {
+			log.Printf("[WAF] rule_bundles: %s пропущен: %v", file, err)
+			continue
+		}
+		h.bundles = append(h.bundles, b)
+	}
+	sort.SliceStable(h.bundles, func(i, j int) bool { return h.bundles[i].LoadedAt.Before(h.bundles[j].LoadedAt) })
+	h.prune()
+	return nil
+}
+
+// record добавляет примененную конфигурацию; повторное применение текущей версии
+// (например, перезагрузка по расписанию) новой записи не создает
+func (h *bundleHistory) record(cfg *Config, source string) {
+	version := strings.Trim(configVersion(cfg), `"`)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if n := len(h.bundles); n > 0 && h.bundles[n-1].Version == version {
+		return
+	}
+	b := &ruleBundle{Version: version, LoadedAt: time.Now().UTC(), Source: source, Config: cfg}
+	if cfg != nil {
+		b.Chain, b.Rules = effectiveChain(cfg), len(cfg.Rules.Rules)
+	} else {
+		b.Chain = effectiveChain(&Config{})
+	}
+	if h.dir != "" {
+		b.file = filepath.Join(h.dir, fmt.Sprintf("%d-%s.json", b.LoadedAt.UnixNano(), version))
+		h.save(b)
+	}
+	// Версия хранится один раз: повторное применение (например, откат) переносит ее в конец
+	kept := h.bundles[:0]
+	for _, old := range h.bundles {
+		if old.Version == version {
+			h.remove(old)
+			continue
+		}
+		kept = append(kept, old)
+	}
+	h.bundles = append(kept, b)
+	h.prune()
+}
+
+func (h *bundleHistory) remove(b *ruleBundle) {
+	if b.file == "" {
+		return
+	}
+	if err := os.Remove(b.file); err != nil && !os.IsNotExist(err) {
+		log.Printf("[WAF] rule_bundles: %v", err)
+	}
+}
+
+// save записывает версию на диск; файл содержит секреты конфигурации и доступен только владельцу
+func (h *bundleHistory) save(b *ruleBundle) {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err == nil {
+		tmp := b.file + ".tmp"
+		if err = os.WriteFile(tmp, data, 0o600); err == nil {
+			err = os.Rename(tmp, b.file)
+		}
+	}
+	if err != nil {
+		log.Printf("[WAF] rule_bundles: не удалось сохранить версию %s: %v", b.Version, err)
+	}
+}
+
+// prune удаляет версии сверх keep, начиная со старых
+func (h *bundleHistory) prune() {
+	for len(h.bundles) > h.keep {
+		h.remove(h.bundles[0])
+		h.bundles = h.bundles[1:]
+	}
+}
+
+// list версии от новых к старым
+func (h *bundleHistory) list() []*ruleBundle {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make([]*ruleBundle, len(h.bundles))
+	for i, b := range h.bundles {
+		c := *b
+		out[len(out)-1-i] = &c
+	}
+	return out
+}
+
+// find ищет версию; пустая версия — ближайшая предыдущая, не отмененная откатом.
+// Возвращается копия: markRolledBack меняет записи истории под h.mu
+func (h *bundleHistory) find(version string) (*ruleBundle, bool) {
+	version = strings.Trim(version, `"`)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := len(h.bundles)
+	for i := n - 1; i >= 0; i-- {
+		b := h.bundles[i]
+		if (version != "" && b.Version == version) || (version == "" && i < n-1 && !b.RolledBack) {
+			c := *b
+			return &c, true
+		}
+	}
+	return nil, false
+}
+
+// markRolledBack отмечает версию, с которой выполнен откат
+func (h *bundleHistory) markRolledBack(version string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, b := range h.bundles {
+		if b.Version == version {
+			b.RolledBack = true
+			if b.file != "" {
+				h.save(b)
+			}
+		}
+	}
+}
+
+// bundleInfo описание версии в API без самой конфигурации
+type bundleInfo struct {
+	*ruleBundle
+	Config  *Config `json:"config,omitempty"`
+	Current bool    `json:"current,omitempty"`
+}
+
+// adminConfigVersions список версий конфигурации от новых к старым
+func (w *WAF) adminConfigVersions(rw http.ResponseWriter, r *http.Request) {
+	bundles := w.bundles.list()
+	out := make([]bundleInfo, len(bundles))
+	for i, b := range bundles {
+		out[i] = bundleInfo{ruleBundle: b, Current: i == 0}
+	}
+	writeJSON(rw, http.StatusOK, out)
+}
+
+// adminConfigVersion конфигурация версии со скрытыми секретами и разница с текущей
+func (w *WAF) adminConfigVersion(rw http.ResponseWriter, r *http.Request) {
+	b, ok := w.bundles.find(r.PathValue("version"))
+	if !ok {
+		writeJSON(rw, http.StatusNotFound, map[string]string{"error": "version not found"})
+		return
+	}
+	writeJSON(rw, http.StatusOK, map[string]interface{}{
+		"version": bundleInfo{ruleBundle: b},
+		"config":  redactConfig(b.Config),
+		"diff":    diffConfigs(w.config(), bundleConfig(b)),
+	})
+}
+
+// adminConfigRollback применяет сохраненную версию: {"version": "..."}; без версии —
+// предыдущую. Версия, с которой выполнен откат, отмечается, и повторный откат
+// по умолчанию уходит дальше в историю, а не возвращает ее.
+func (w *WAF) adminConfigRollback(rw http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Version string `json:"version"`
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(rw, r.Body, 1<<16))
+	if err == nil && len(strings.TrimSpace(string(data))) > 0 {
+		err = json.Unmarshal(data, &req)
+	}
+	if err != nil {
+		writeJSON(rw, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	b, ok := w.bundles.find(req.Version)
+	if !ok {
+		writeJSON(rw, http.StatusNotFound, map[string]string{"error": "no version to roll back to"})
+		return
+	}
+	before := w.config()
+	from := strings.Trim(configVersion(before), `"`)
+	if err := w.reload(b.Config, "rollback"); err != nil {
+		writeJSON(rw, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+		return
+	}
+	if from != b.Version {
+		w.bundles.markRolledBack(from)
+	}
+	log.Printf("[WAF] Конфигурация откачена с версии %s на %s (%s)", from, b.Version, b.LoadedAt.Format(time.RFC3339))
+	w.auditRequest(r, "config_rollback", b.Version, redactConfig(before), redactConfig(b.Config))
+	rw.Header().Set("ETag", configVersion(b.Config))
+	writeJSON(rw, http.StatusOK, redactConfig(b.Config))
+}
+
+// bundleConfig конфигурация версии для сравнения; nil — настройки по умолчанию
+func bundleConfig(b *ruleBundle) *Config {
+	if b.Config == nil {
+		return &Config{}
+	}
+	return b.Config
+}
@@ -0,0 +1,114 @@
+package waf
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// connLimitListener ограничивает число открытых соединений с одного IP и общее число соединений.
+// Работает на уровне TCP, до разбора HTTP, поэтому защищает от исчерпания соединений,
+// которое не видно HTTP middleware (rate limit считает запросы, а не соединения).
+type connLimitListener struct {
+	net.Listener
+	maxPerIP int
+	slots    chan struct{} // nil — общее число не ограничено
+	mu       sync.Mutex
+	perIP    map[string]int
+	events   *EventBus
+}
+
+// newConnLimitListener оборачивает listener; нулевые лимиты отключают соответствующую проверку
+func newConnLimitListener(l net.Listener, maxPerIP, maxTotal int) *connLimitListener {
+	cl := &connLimitListener{
+		Listener: l,
+		maxPerIP: maxPerIP,
+		perIP:    make(map[string]int),
+	}
+	if maxTotal > 0 {
+		cl.slots = make(chan struct{}, maxTotal)
+	}
+	return cl
+}
+
+// Accept принимает соединение. При достижении общего лимита ожидает освобождения слота
+// (новые соединения ждут в очереди ядра), соединения сверх лимита на IP сразу закрываются.
+func (l *connLimitListener) Accept() (net.Conn, error) {
+	for {
+		if l.slots != nil {
+			l.slots <- struct{}{}
+		}
+		c, err := l.Listener.Accept()
+		if err != nil {
+			l.releaseSlot()
+			return nil, err
+		}
+
+		ip := extractIP(c.RemoteAddr().String())
+		if !l.acquire(ip) {
+			if l.events != nil {
+				l.events.Publish(SecurityEvent{
+					Type:     EventDetection,
+					Module:   "connection_limit",
+					Severity: SeverityWarning,
+					IP:       ip,
+					Action:   "block",
+					Message:  fmt.Sprintf("Превышен лимит соединений с %s (%d), соединение закрыто", ip, l.maxPerIP),
+				})
+			}
+			c.Close()
+			l.releaseSlot()
+			continue
+		}
+		return &limitedConn{Conn: c, release: func() {
+			l.releaseIP(ip)
+			l.releaseSlot()
+		}}, nil
+	}
+}
+
+// acquire учитывает соединение с IP, если лимит не превышен
+func (l *connLimitListener) acquire(ip string) bool {
+	if l.maxPerIP <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.perIP[ip] >= l.maxPerIP {
+		return false
+	}
+	l.perIP[ip]++
+	return true
+}
+
+func (l *connLimitListener) releaseIP(ip string) {
+	if l.maxPerIP <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.perIP[ip] <= 1 {
+		delete(l.perIP, ip)
+	} else {
+		l.perIP[ip]--
+	}
+}
+
+func (l *connLimitListener) releaseSlot() {
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// limitedConn освобождает место в лимитах при закрытии (однократно)
+type limitedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
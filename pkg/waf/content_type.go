@@ -0,0 +1,185 @@
+package waf
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ContentTypeMiddleware проверяет, что тело запроса соответствует заявленному Content-Type.
+// Сигнатуры разбирают тело по заявленному типу, а бэкенд может разобрать его иначе
+// (JSON под видом формы, multipart под видом JSON); такое расхождение парсеров
+// позволяет пронести атаку мимо проверки тела.
+type ContentTypeMiddleware struct {
+	waf           *WAF
+	inspectBytes  int64
+	requireType   bool // тело без Content-Type считается несоответствием
+	sniffOpaque   bool // искать JSON и multipart в телах с типом, который WAF не разбирает
+	riskScore     float64
+	action        string // block, log, challenge, ban, throttle, delay
+	banDuration   time.Duration
+	delay         time.Duration
+	logDetections bool
+}
+
+// NewContentTypeMiddlewareWithConfig создает проверку Content-Type из конфига
+func NewContentTypeMiddlewareWithConfig(w *WAF, cfg ContentTypeConfig) *ContentTypeMiddleware {
+	m := &ContentTypeMiddleware{
+		waf:           w,
+		inspectBytes:  defaultMaxBodyInspectSize,
+		requireType:   cfg.RequireContentType,
+		sniffOpaque:   cfg.SniffOpaque,
+		riskScore:     cfg.RiskScore,
+		action:        "block",
+		banDuration:   10 * time.Minute,
+		delay:         2 * time.Second,
+		logDetections: true,
+	}
+	if cfg.InspectBytes > 0 {
+		m.inspectBytes = cfg.InspectBytes
+	}
+	if cfg.Action != "" {
+		m.action = cfg.Action
+	}
+	if cfg.BanSeconds > 0 {
+		m.banDuration = time.Duration(cfg.BanSeconds) * time.Second
+	}
+	return m
+}
+
+func (m *ContentTypeMiddleware) push(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.waf == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := ClientIP(r)
+		if m.waf.banned(r, ip) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		head, err := peekBody(r, m.inspectBytes)
+		if err != nil || len(head) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		complete := int64(len(head)) < m.inspectBytes
+		declared, reason := m.mismatch(r.Header.Values("Content-Type"), head, complete)
+		if reason == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		detected := sniffBody(head)
+		if m.riskScore > 0 {
+			addRiskScore(m.waf.states.Get(ip), m.riskScore)
+		}
+		ev := requestEvent(r, ip, "content_type", SeverityWarning, m.action, fmt.Sprintf("Тело запроса от %s не соответствует Content-Type %q: %s", ip, declared, reason))
+		ev.Fields = map[string]interface{}{"declared": declared, "detected": detected, "reason": reason}
+		if m.waf.decide(r, ev, m.logDetections, func() bool {
+			switch m.action {
+			case "log":
+				return false
+			case "block":
+				return forbid(w)()
+			}
+			return enforceAction(w, r, m.waf, ip, m.action, m.banDuration, m.delay)
+		}) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// mismatch возвращает заявленный тип и причину несоответствия тела ("" — тело соответствует).
+// complete — head содержит тело целиком, и его можно проверить полностью.
+func (m *ContentTypeMiddleware) mismatch(values []string, head []byte, complete bool) (string, string) {
+	switch len(values) {
+	case 0:
+		if m.requireType {
+			return "", "body without Content-Type"
+		}
+		return "", ""
+	case 1:
+	default:
+		// Прокси и бэкенд могут взять разные заголовки
+		return strings.Join(values, ", "), "multiple Content-Type headers"
+	}
+	declared := values[0]
+	mediaType, params, err := mime.ParseMediaType(declared)
+	if err != nil {
+		return declared, "malformed Content-Type"
+	}
+
+	body := bytes.TrimLeft(bytes.TrimPrefix(head, []byte("\xef\xbb\xbf")), " \t\r\n")
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		if len(body) == 0 || !strings.ContainsRune(`{["-0123456789tfn`, rune(body[0])) {
+			return mediaType, "body is not JSON"
+		}
+		if complete && !json.Valid(body) {
+			return mediaType, "malformed JSON"
+		}
+	case mediaType == "application/x-www-form-urlencoded":
+		if kind := sniffBody(head); kind == "json" || kind == "multipart" || kind == "xml" {
+			return mediaType, "body is " + kind
+		}
+		if complete {
+			if _, err := url.ParseQuery(string(head)); err != nil {
+				return mediaType, "malformed form"
+			}
+		}
+	case strings.HasPrefix(mediaType, "multipart/"):
+		boundary := params["boundary"]
+		if boundary == "" {
+			return mediaType, "multipart without boundary"
+		}
+		// Преамбула допустима по RFC 2046, но браузеры ее не отправляют, а парсеры
+		// расходятся в том, где начинается первая часть
+		if !bytes.HasPrefix(bytes.TrimLeft(head, "\r\n"), []byte("--"+boundary)) {
+			return mediaType, "body does not start with the boundary"
+		}
+	case mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml"):
+		if len(body) == 0 || body[0] != '<' {
+			return mediaType, "body is not XML"
+		}
+	case m.sniffOpaque && !strings.HasPrefix(mediaType, "text/"):
+		// Тело непроверяемого типа (octet-stream и т.п.), которое бэкенд может разобрать как данные
+		if kind := sniffBody(head); kind == "json" || kind == "multipart" {
+			return mediaType, "body is " + kind
+		}
+	}
+	return mediaType, ""
+}
+
+// sniffBody определяет вид тела по его началу: json, xml, multipart, form или ""
+func sniffBody(head []byte) string {
+	body := bytes.TrimLeft(bytes.TrimPrefix(head, []byte("\xef\xbb\xbf")), " \t\r\n")
+	if len(body) == 0 {
+		return ""
+	}
+	switch {
+	case body[0] == '{' || body[0] == '[':
+		if json.Valid(body) || bytes.IndexByte(body[1:], '"') >= 0 {
+			return "json"
+		}
+	case body[0] == '<':
+		return "xml"
+	case bytes.HasPrefix(body, []byte("--")):
+		line, _, _ := bytes.Cut(body, []byte("\n"))
+		if len(bytes.TrimSpace(line)) > 2 && bytes.Contains(bytes.ToLower(body), []byte("content-disposition:")) {
+			return "multipart"
+		}
+	}
+	if eq := bytes.IndexByte(body, '='); eq > 0 && bytes.IndexAny(body[:eq], " \t\r\n\"{}<>") < 0 {
+		return "form"
+	}
+	return ""
+}
@@ -0,0 +1,328 @@
+package waf
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ContextMiddleware анализирует аномалии поведения пользователя (BOLA, сканирование)
+// Отслеживает уникальные ресурсы за временное окно
+type ContextMiddleware struct {
+	waf               *WAF
+	window            time.Duration
+	threshold         int
+	banDuration       time.Duration
+	multiplier        float64
+	violationResetTTL time.Duration
+	logDetections     bool
+	resourceExtractor ContextResourceExtractorConfig
+	routes            []contextRoute
+	identity          IdentityConfig
+}
+
+// contextRoute переопределяет окно и порог анализа для конкретного маршрута
+type contextRoute struct {
+	pattern   routePattern
+	window    time.Duration
+	threshold int
+	extractor ContextResourceExtractorConfig
+}
+
+// NewContextMiddleware создает анализатор контекста с дефолт настройками
+func NewContextMiddleware(w *WAF) *ContextMiddleware {
+	return &ContextMiddleware{
+		waf:               w,
+		window:            60 * time.Second,
+		threshold:         20,
+		banDuration:       5 * time.Minute,
+		multiplier:        2.0,
+		violationResetTTL: 24 * time.Hour,
+		logDetections:     true,
+	}
+}
+
+// NewContextMiddlewareWithConfig создает анализатор с кастомными настройками
+func NewContextMiddlewareWithConfig(w *WAF, window time.Duration, threshold int, banDuration time.Duration, extractor ContextResourceExtractorConfig) *ContextMiddleware {
+	return &ContextMiddleware{
+		waf:               w,
+		window:            window,
+		threshold:         threshold,
+		banDuration:       banDuration,
+		multiplier:        2.0,
+		violationResetTTL: 24 * time.Hour,
+		logDetections:     true,
+		resourceExtractor: extractor,
+	}
+}
+
+// SetIdentity задает источник идентичности клиента (сессия, аккаунт) вместо IP
+func (m *ContextMiddleware) SetIdentity(cfg IdentityConfig) {
+	m.identity = cfg
+}
+
+// SetRoutes задает параметры анализа для отдельных маршрутов.
+// Маршруты проверяются в порядке объявления, первый совпавший применяется.
+func (m *ContextMiddleware) SetRoutes(routes []ContextRouteConfig) {
+	m.routes = m.routes[:0]
+	for _, rc := range routes {
+		if rc.Path == "" {
+			continue
+		}
+		route := contextRoute{
+			pattern:   compileRoutePattern(rc.Path),
+			window:    m.window,
+			threshold: m.threshold,
+			extractor: rc.ResourceExtractor,
+		}
+		if rc.WindowSeconds > 0 {
+			route.window = time.Duration(rc.WindowSeconds) * time.Second
+		}
+		if rc.Threshold > 0 {
+			route.threshold = rc.Threshold
+		}
+		m.routes = append(m.routes, route)
+	}
+}
+
+// resolveRoute возвращает окно, порог, ключ хранения ресурсов и сам ресурс для запроса.
+func (m *ContextMiddleware) resolveRoute(r *http.Request) (time.Duration, int, string, string) {
+	for _, route := range m.routes {
+		params, ok := route.pattern.match(r.URL.Path)
+		if !ok {
+			continue
+		}
+		var resource string
+		if route.extractor.Type != "" {
+			resource = m.extractResourceIDWith(r, route.extractor)
+		} else if resource = route.pattern.lastParam(params); resource == "" {
+			resource = m.extractResourceID(r)
+		}
+		return route.window, route.threshold, "resources:" + route.pattern.raw, resource
+	}
+	return m.window, m.threshold, "resources", m.extractResourceID(r)
+}
+
+// extractResourceID извлекает идентификатор ресурса из запроса.
+// Если extractor не задан, используется дефолтная логика проекта.
+func (m *ContextMiddleware) extractResourceID(r *http.Request) string {
+	return m.extractResourceIDWith(r, m.resourceExtractor)
+}
+
+// extractResourceIDWith извлекает идентификатор ресурса по заданному правилу.
+func (m *ContextMiddleware) extractResourceIDWith(r *http.Request, extractor ContextResourceExtractorConfig) string {
+	switch extractor.Type {
+	case "":
+		return extractResourceIDDefault(r)
+	case "query_param":
+		return strings.TrimSpace(r.URL.Query().Get(extractor.Name))
+	case "path_segment":
+		return extractPathSegmentByName(r.URL.Path, extractor.Name)
+	case "last_segment":
+		return extractLastPathSegment(r.URL.Path)
+	case "last_numeric_segment":
+		return extractLastNumericPathSegment(r.URL.Path)
+	default:
+		if m.logDetections {
+			log.Printf("[WAF] Неизвестный тип извлечения ресурса для context: %s. Используется логика по умолчанию", extractor.Type)
+		}
+		return extractResourceIDDefault(r)
+	}
+}
+
+// extractResourceIDDefault ихвлечение id из url.
+func extractResourceIDDefault(r *http.Request) string {
+	resource := strings.TrimSpace(r.URL.Query().Get("id"))
+	if resource != "" {
+		return resource
+	}
+	return extractLastNumericPathSegment(r.URL.Path)
+}
+
+// extractPathSegmentByName ищет сегмент пути по имени и возвращает следующий за ним.
+// Например, для /api/users/42 при имени users будет возвращено 42.
+func extractPathSegmentByName(path, name string) string {
+	name = strings.Trim(strings.TrimSpace(name), "/")
+	if name == "" {
+		return ""
+	}
+	parts := splitPathSegments(path)
+	for i := 0; i < len(parts)-1; i++ {
+		if parts[i] == name {
+			return parts[i+1]
+		}
+	}
+	return ""
+}
+
+// extractLastPathSegment возвращает последний непустой сегмент пути.
+func extractLastPathSegment(path string) string {
+	parts := splitPathSegments(path)
+	if len(parts) == 0 {
+		return ""
+	}
+	return parts[len(parts)-1]
+}
+
+// extractLastNumericPathSegment возвращает последний числовой сегмент пути.
+func extractLastNumericPathSegment(path string) string {
+	last := extractLastPathSegment(path)
+	if last == "" {
+		return ""
+	}
+	if _, err := strconv.Atoi(last); err == nil {
+		return last
+	}
+	return ""
+}
+
+// splitPathSegments разбивает путь на непустые сегменты.
+func splitPathSegments(path string) []string {
+	rawParts := strings.Split(strings.Trim(path, "/"), "/")
+	parts := make([]string, 0, len(rawParts))
+	for _, part := range rawParts {
+		part = strings.TrimSpace(part)
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// trackResource отмечает обращение к ресурсу и возвращает число уникальных ресурсов в окне
+func (m *ContextMiddleware) trackResource(st *State, key, resource string, window time.Duration) int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	now := time.Now()
+
+	// Инициализировать или получить карту ресурсов
+	var resources map[string]time.Time
+	if v, ok := st.Meta[key]; ok {
+		resources = v.(map[string]time.Time)
+	} else {
+		resources = make(map[string]time.Time)
+	}
+
+	// Установить время последнего доступ к ресурсу
+	if resource != "" {
+		resources[resource] = now
+	}
+
+	// Удалить старые записи вне временного окна
+	for k, t := range resources {
+		if now.Sub(t) > window {
+			delete(resources, k)
+		}
+	}
+
+	st.Meta[key] = resources
+	st.LastSeen = now
+	return len(resources)
+}
+
+// registerViolation увеличивает счетчик нарушений BOLA и возвращает длительность бана
+func (m *ContextMiddleware) registerViolation(st *State) (time.Duration, int) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	now := time.Now()
+
+	// Сброс счетчика нарушений через установленное время
+	var bolaViolations int
+	var lastBolaViolationTime time.Time
+	if v, ok := st.Meta["bola_violations"]; ok {
+		bolaViolations = v.(int)
+	}
+	if v, ok := st.Meta["last_bola_violation_time"]; ok {
+		lastBolaViolationTime = v.(time.Time)
+	}
+
+	if !lastBolaViolationTime.IsZero() && now.Sub(lastBolaViolationTime) > m.violationResetTTL {
+		bolaViolations = 0
+	}
+
+	// Увеличить счетчик нарушений
+	bolaViolations++
+	st.Meta["bola_violations"] = bolaViolations
+	st.Meta["last_bola_violation_time"] = now
+
+	// Вычислить длительность бана
+	banDuration := time.Duration(float64(m.banDuration) * math.Pow(m.multiplier, float64(bolaViolations-1)))
+	return banDuration, bolaViolations
+}
+
+// resetViolations сбрасывает счетчик BOLA только если TTL истек
+func (m *ContextMiddleware) resetViolations(st *State) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	var lastBolaViolationTime time.Time
+	if v, ok := st.Meta["last_bola_violation_time"]; ok {
+		lastBolaViolationTime = v.(time.Time)
+	}
+	if !lastBolaViolationTime.IsZero() && time.Since(lastBolaViolationTime) > m.violationResetTTL {
+		st.Meta["bola_violations"] = 0
+		st.Meta["last_bola_violation_time"] = time.Time{}
+	}
+}
+
+// contextSubject ключ отслеживания и его порог
+type contextSubject struct {
+	id        string
+	threshold int
+}
+
+func (m *ContextMiddleware) push(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.waf == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := ClientIP(r)
+		id := identityKey(r, m.identity, ip)
+
+		if m.waf.banned(r, ip) || (id != ip && m.waf.banned(r, id)) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		// Определить параметры маршрута и извлечь идентификатор ресурса
+		window, threshold, resourcesKey, resource := m.resolveRoute(r)
+
+		// Поведение отслеживается по сессии/аккаунту, IP — дополнительно с повышенным порогом
+		subjects := []contextSubject{{id: id, threshold: threshold}}
+		if id != ip && m.identity.IPThresholdFactor > 0 {
+			subjects = append(subjects, contextSubject{id: ip, threshold: int(float64(threshold) * m.identity.IPThresholdFactor)})
+		}
+
+		for _, sub := range subjects {
+			st := m.waf.states.Get(sub.id)
+			if st == nil {
+				continue
+			}
+
+			// Анализ аномалий: срабатывание при превышении порога
+			uniqueCount := m.trackResource(st, resourcesKey, resource, window)
+			if uniqueCount > m.waf.reputation.tighten(ip, sub.threshold) {
+				banDuration, violationCount := m.registerViolation(st)
+				ev := requestEvent(r, sub.id, "context", SeverityCritical, "ban", fmt.Sprintf("Обнаружено поведение, похожее на BOLA, от %s: %d уникальных ресурсов за %s, заблокирован на %s (нарушение #%d)", sub.id, uniqueCount, window, banDuration, violationCount))
+				if m.waf.decide(r, ev, m.logDetections, func() bool {
+					m.waf.forensics.link(sub.id, ip)
+					m.waf.bans.Ban(sub.id, banDuration)
+					w.Header().Set("Retry-After", strconv.FormatInt(int64(banDuration.Seconds()), 10))
+					return forbid(w)()
+				}) {
+					return
+				}
+				break
+			}
+
+			m.resetViolations(st)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,232 @@
+package waf
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// CookieSecurityMiddleware переписывает Set-Cookie ответов бэкенда: принудительно
+// выставляет Secure, HttpOnly и SameSite, а также прозрачно шифрует или подписывает
+// значения выбранных cookie. Во входящих запросах значения расшифровываются
+// и проверяются до передачи бэкенду; поддельные cookie отбрасываются.
+type CookieSecurityMiddleware struct {
+	waf           *WAF
+	forceSecure   bool
+	forceHTTPOnly bool
+	httpOnlySkip  map[string]bool
+	sameSite      http.SameSite
+	encrypt       map[string]bool
+	sign          map[string]bool
+	aead          cipher.AEAD
+	macKey        []byte
+	logDetections bool
+}
+
+// NewCookieSecurityMiddlewareWithConfig создает middleware защиты cookie из конфига
+func NewCookieSecurityMiddlewareWithConfig(w *WAF, cfg CookieSecurityConfig) (*CookieSecurityMiddleware, error) {
+	m := &CookieSecurityMiddleware{
+		waf:           w,
+		forceSecure:   cfg.ForceSecure,
+		forceHTTPOnly: cfg.ForceHTTPOnly,
+		httpOnlySkip:  toSet(cfg.HTTPOnlyExclude),
+		encrypt:       toSet(cfg.Encrypt),
+		sign:          toSet(cfg.Sign),
+		logDetections: true,
+	}
+	switch strings.ToLower(cfg.SameSite) {
+	case "":
+	case "lax":
+		m.sameSite = http.SameSiteLaxMode
+	case "strict":
+		m.sameSite = http.SameSiteStrictMode
+	case "none":
+		m.sameSite = http.SameSiteNoneMode
+	default:
+		return nil, errors.New("unknown same_site value: " + cfg.SameSite)
+	}
+
+	if len(m.encrypt) > 0 || len(m.sign) > 0 {
+		if cfg.Secret == "" {
+			return nil, errors.New("cookie_security: secret is required for encrypt/sign")
+		}
+		key := sha256.Sum256([]byte("enc|" + cfg.Secret))
+		block, err := aes.NewCipher(key[:])
+		if err != nil {
+			return nil, err
+		}
+		if m.aead, err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+		macKey := sha256.Sum256([]byte("mac|" + cfg.Secret))
+		m.macKey = macKey[:]
+	}
+	return m, nil
+}
+
+func (m *CookieSecurityMiddleware) push(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.waf == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := ClientIP(r)
+		if m.waf.banned(r, ip) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		if len(m.encrypt) > 0 || len(m.sign) > 0 {
+			m.unwrapRequestCookies(r, ip)
+		}
+
+		next.ServeHTTP(newHeaderHookWriter(w, func(_ int, h http.Header) {
+			m.rewriteSetCookies(h)
+		}), r)
+	})
+}
+
+// rewriteSetCookies применяет политику ко всем Set-Cookie ответа
+func (m *CookieSecurityMiddleware) rewriteSetCookies(h http.Header) {
+	raw := h.Values("Set-Cookie")
+	if len(raw) == 0 {
+		return
+	}
+	rewritten := make([]string, 0, len(raw))
+	for _, line := range raw {
+		c, err := http.ParseSetCookie(line)
+		if err != nil {
+			rewritten = append(rewritten, line)
+			continue
+		}
+		if m.forceSecure {
+			c.Secure = true
+		}
+		if m.forceHTTPOnly && !m.httpOnlySkip[c.Name] {
+			c.HttpOnly = true
+		}
+		if m.sameSite != 0 {
+			c.SameSite = m.sameSite
+			if c.SameSite == http.SameSiteNoneMode {
+				c.Secure = true
+			}
+		}
+		// Удаляющие cookie (пустое значение) не шифруются
+		if c.Value != "" {
+			switch {
+			case m.encrypt[c.Name]:
+				c.Value = m.seal(c.Name, c.Value)
+			case m.sign[c.Name]:
+				c.Value = c.Value + "." + m.mac(c.Name, c.Value)
+			}
+		}
+		rewritten = append(rewritten, c.String())
+	}
+	h["Set-Cookie"] = rewritten
+}
+
+// unwrapRequestCookies расшифровывает и проверяет защищенные cookie запроса. Заголовок
+// Cookie меняется только в парах защищенных cookie: остальные передаются бэкенду как есть,
+// даже если их не разбирает net/http (нестандартные имена, кавычки, недопустимые символы)
+func (m *CookieSecurityMiddleware) unwrapRequestCookies(r *http.Request, ip string) {
+	lines := r.Header.Values("Cookie")
+	changed := false
+	out := make([]string, 0, len(lines))
+	for _, line := range lines {
+		pairs := strings.Split(line, ";")
+		kept := make([]string, 0, len(pairs))
+		for _, pair := range pairs {
+			name, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+			var err error
+			switch {
+			case m.encrypt[name]:
+				value, err = m.open(name, strings.Trim(value, `"`))
+			case m.sign[name]:
+				value, err = m.verify(name, strings.Trim(value, `"`))
+			default:
+				kept = append(kept, pair)
+				continue
+			}
+			changed = true
+			if err != nil {
+				if m.logDetections {
+					m.waf.emit(requestEvent(r, ip, "cookie_security", SeverityWarning, "drop", fmt.Sprintf("Поддельный или поврежденный cookie %s от %s отброшен: %v", name, ip, err)))
+				}
+				continue
+			}
+			lead := pair[:len(pair)-len(strings.TrimLeft(pair, " \t"))]
+			kept = append(kept, lead+(&http.Cookie{Name: name, Value: value}).String())
+		}
+		if joined := strings.TrimLeft(strings.Join(kept, ";"), " \t"); joined != "" {
+			out = append(out, joined)
+		}
+	}
+	if !changed {
+		return
+	}
+	r.Header.Del("Cookie")
+	if len(out) > 0 {
+		r.Header["Cookie"] = out
+	}
+}
+
+// seal шифрует значение cookie; имя cookie используется как связанные данные
+func (m *CookieSecurityMiddleware) seal(name, value string) string {
+	nonce := make([]byte, m.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	out := m.aead.Seal(nonce, nonce, []byte(value), []byte(name))
+	return base64.RawURLEncoding.EncodeToString(out)
+}
+
+// open расшифровывает значение cookie
+func (m *CookieSecurityMiddleware) open(name, value string) (string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(data) < m.aead.NonceSize() {
+		return "", errors.New("invalid encrypted cookie")
+	}
+	nonce, ciphertext := data[:m.aead.NonceSize()], data[m.aead.NonceSize():]
+	plain, err := m.aead.Open(nil, nonce, ciphertext, []byte(name))
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// mac вычисляет подпись значения cookie
+func (m *CookieSecurityMiddleware) mac(name, value string) string {
+	h := hmac.New(sha256.New, m.macKey)
+	h.Write([]byte(name + "=" + value))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// verify проверяет подпись и возвращает исходное значение cookie
+func (m *CookieSecurityMiddleware) verify(name, value string) (string, error) {
+	i := strings.LastIndexByte(value, '.')
+	if i < 0 {
+		return "", errors.New("missing cookie signature")
+	}
+	plain, sig := value[:i], value[i+1:]
+	if !hmac.Equal([]byte(sig), []byte(m.mac(name, plain))) {
+		return "", errors.New("bad cookie signature")
+	}
+	return plain, nil
+}
+
+// toSet превращает список строк в множество
+func toSet(items []string) map[string]bool {
+	set := make(map[string]bool, len(items))
+	for _, it := range items {
+		set[it] = true
+	}
+	return set
+}
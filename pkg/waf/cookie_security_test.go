@@ -0,0 +1,34 @@
+package waf
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUnwrapRequestCookiesKeepsOthers(t *testing.T) {
+	w, err := NewWAF("http://127.0.0.1:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewCookieSecurityMiddlewareWithConfig(w, CookieSecurityConfig{Secret: "0123456789abcdef", Encrypt: []string{"sess"}, Sign: []string{"uid"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess := m.seal("sess", "s1")
+	uid := "42." + m.mac("uid", "42")
+	for _, tc := range []struct {
+		name, in, want string
+	}{
+		{"unprotected only", `a=1;  weird name=x; q="quoted"; b=%zz`, `a=1;  weird name=x; q="quoted"; b=%zz`},
+		{"protected among others", `a=1; sess=` + sess + `; x{y}=z; uid=` + uid, `a=1; sess=s1; x{y}=z; uid=42`},
+		{"forged dropped", `sess=forged; a=1; uid=42.bad`, `a=1`},
+		{"only forged", `uid=42`, ``},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Cookie", tc.in)
+		m.unwrapRequestCookies(r, "203.0.113.7")
+		if got := r.Header.Get("Cookie"); got != tc.want {
+			t.Errorf("%s: Cookie %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
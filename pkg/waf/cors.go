@@ -0,0 +1,188 @@
+package waf
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// CORSMiddleware применяет строгую CORS политику на уровне WAF независимо от бэкенда:
+// отвечает на preflight-запросы, заменяет Access-Control-* заголовки ответа
+// и при необходимости отклоняет запросы с неразрешенных источников.
+type CORSMiddleware struct {
+	waf              *WAF
+	allowAll         bool
+	origins          map[string]bool
+	wildcardOrigins  []string // суффиксы для шаблонов вида https://*.example.com
+	methods          map[string]bool
+	allowedMethods   string
+	headers          map[string]bool
+	allowedHeaders   string
+	exposedHeaders   string
+	allowCredentials bool
+	maxAge           int
+	blockDisallowed  bool
+	logDetections    bool
+}
+
+// NewCORSMiddlewareWithConfig создает CORS middleware из конфига
+func NewCORSMiddlewareWithConfig(w *WAF, cfg CORSConfig) *CORSMiddleware {
+	m := &CORSMiddleware{
+		waf:              w,
+		origins:          make(map[string]bool),
+		methods:          make(map[string]bool),
+		headers:          make(map[string]bool),
+		allowCredentials: cfg.AllowCredentials,
+		maxAge:           600,
+		blockDisallowed:  cfg.BlockDisallowed,
+		exposedHeaders:   strings.Join(cfg.ExposedHeaders, ", "),
+		logDetections:    true,
+	}
+	for _, o := range cfg.AllowedOrigins {
+		o = strings.ToLower(strings.TrimRight(o, "/"))
+		switch {
+		case o == "*":
+			m.allowAll = true
+		case strings.Contains(o, "://*."):
+			scheme, host, _ := strings.Cut(o, "://*")
+			m.wildcardOrigins = append(m.wildcardOrigins, scheme+"://|"+host)
+		default:
+			m.origins[o] = true
+		}
+	}
+	methods := []string{http.MethodGet, http.MethodHead, http.MethodPost}
+	if len(cfg.AllowedMethods) > 0 {
+		methods = methods[:0]
+		for _, method := range cfg.AllowedMethods {
+			methods = append(methods, strings.ToUpper(method))
+		}
+	}
+	for _, method := range methods {
+		m.methods[method] = true
+	}
+	m.allowedMethods = strings.Join(methods, ", ")
+	for _, h := range cfg.AllowedHeaders {
+		m.headers[http.CanonicalHeaderKey(h)] = true
+	}
+	m.allowedHeaders = strings.Join(cfg.AllowedHeaders, ", ")
+	if cfg.MaxAgeSeconds > 0 {
+		m.maxAge = cfg.MaxAgeSeconds
+	}
+	return m
+}
+
+func (m *CORSMiddleware) push(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.waf == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := ClientIP(r)
+		if m.waf.banned(r, ip) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			// Не CORS запрос: только убрать CORS заголовки бэкенда
+			next.ServeHTTP(newHeaderHookWriter(w, func(_ int, h http.Header) { stripCORSHeaders(h) }), r)
+			return
+		}
+		allowed := m.originAllowed(origin)
+
+		// Preflight обрабатывается WAF без обращения к бэкенду
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			m.preflight(w, r, ip, origin, allowed)
+			return
+		}
+
+		if !allowed && m.blockDisallowed {
+			ev := requestEvent(r, ip, "cors", SeverityWarning, "block", fmt.Sprintf("CORS: запрос с неразрешенного источника %s от %s: %s %s", origin, ip, r.Method, r.URL.Path))
+			m.waf.refuse(w, r, ev, m.logDetections, func() { http.Error(w, "Forbidden", http.StatusForbidden) })
+			return
+		}
+
+		next.ServeHTTP(newHeaderHookWriter(w, func(_ int, h http.Header) {
+			stripCORSHeaders(h)
+			if allowed {
+				m.setAllowOrigin(h, origin)
+				if m.exposedHeaders != "" {
+					h.Set("Access-Control-Expose-Headers", m.exposedHeaders)
+				}
+			}
+		}), r)
+	})
+}
+
+// preflight отвечает на OPTIONS-запрос с Access-Control-Request-Method
+func (m *CORSMiddleware) preflight(w http.ResponseWriter, r *http.Request, ip, origin string, allowed bool) {
+	method := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+	reason := ""
+	switch {
+	case !allowed:
+		reason = "источник не разрешен"
+	case !m.methods[method]:
+		reason = "метод " + method + " не разрешен"
+	default:
+		for _, h := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+			if h = strings.TrimSpace(h); h != "" && !m.headers[http.CanonicalHeaderKey(h)] {
+				reason = "заголовок " + h + " не разрешен"
+				break
+			}
+		}
+	}
+	if reason != "" {
+		ev := requestEvent(r, ip, "cors", SeverityInfo, "block", fmt.Sprintf("CORS preflight отклонен от %s (%s): %s", ip, origin, reason))
+		m.waf.refuse(w, r, ev, m.logDetections, func() { http.Error(w, "Forbidden", http.StatusForbidden) })
+		return
+	}
+
+	h := w.Header()
+	m.setAllowOrigin(h, origin)
+	h.Set("Access-Control-Allow-Methods", m.allowedMethods)
+	if m.allowedHeaders != "" {
+		h.Set("Access-Control-Allow-Headers", m.allowedHeaders)
+	}
+	h.Set("Access-Control-Max-Age", strconv.Itoa(m.maxAge))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setAllowOrigin выставляет разрешенный источник и credentials
+func (m *CORSMiddleware) setAllowOrigin(h http.Header, origin string) {
+	if m.allowAll && !m.allowCredentials {
+		h.Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	h.Set("Access-Control-Allow-Origin", origin)
+	h.Add("Vary", "Origin")
+	if m.allowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// originAllowed проверяет источник по списку и шаблонам поддоменов
+func (m *CORSMiddleware) originAllowed(origin string) bool {
+	origin = strings.ToLower(strings.TrimRight(origin, "/"))
+	if m.allowAll || m.origins[origin] {
+		return true
+	}
+	for _, w := range m.wildcardOrigins {
+		scheme, suffix, _ := strings.Cut(w, "|")
+		if host, ok := strings.CutPrefix(origin, scheme); ok && strings.HasSuffix(host, suffix) && len(host) > len(suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// stripCORSHeaders удаляет CORS заголовки, выставленные бэкендом
+func stripCORSHeaders(h http.Header) {
+	for k := range h {
+		if strings.HasPrefix(k, "Access-Control-") {
+			h.Del(k)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package waf
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CRLFMiddleware обнаруживает CR/LF в параметрах, за которыми следует строка заголовка
+// или пустая строка. Бэкенд, подставляющий параметр в Location или Set-Cookie без
+// экранирования, иначе позволяет добавить в ответ свои заголовки (header injection)
+// или целый ответ (response splitting).
+//
+// Если запрос не заблокирован (action log или движок решений), модуль проверяет ответ:
+// внедренные заголовки, которые бэкенд отразил в ответе, удаляются до отправки клиенту.
+type CRLFMiddleware struct {
+	waf           *WAF
+	bodyParams    bool // проверять и параметры тела формы
+	action        string
+	banDuration   time.Duration
+	delay         time.Duration
+	logDetections bool
+}
+
+// NewCRLFMiddlewareWithConfig создает проверку CRLF-инъекций из конфига
+func NewCRLFMiddlewareWithConfig(w *WAF, cfg CRLFConfig) *CRLFMiddleware {
+	m := &CRLFMiddleware{
+		waf:           w,
+		bodyParams:    cfg.BodyParams,
+		action:        "block",
+		banDuration:   10 * time.Minute,
+		delay:         2 * time.Second,
+		logDetections: true,
+	}
+	if cfg.Action != "" {
+		m.action = cfg.Action
+	}
+	if cfg.BanSeconds > 0 {
+		m.banDuration = time.Duration(cfg.BanSeconds) * time.Second
+	}
+	return m
+}
+
+// injectedHeader строка заголовка, найденная в параметре после CR/LF
+type injectedHeader struct {
+	name, value string
+}
+
+func (m *CRLFMiddleware) push(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.waf == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := ClientIP(r)
+		if m.waf.banned(r, ip) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		var injected []injectedHeader
+		params := r.URL.Query()
+		if m.bodyParams {
+			params = paramsOf(r)
+		}
+		candidates := map[string][]string{"": {r.URL.Path}}
+		for param, values := range params {
+			candidates[param] = values
+		}
+		for param, values := range candidates {
+			for _, v := range values {
+				headers, split := headerInjection(v)
+				if len(headers) == 0 && !split {
+					continue
+				}
+				names := make([]string, len(headers))
+				for i, h := range headers {
+					names[i] = h.name
+				}
+				kind := "внедрение заголовков"
+				if split {
+					kind = "разделение ответа"
+				}
+				ev := requestEvent(r, ip, "crlf", SeverityCritical, m.action, fmt.Sprintf("Обнаружена CRLF-инъекция (%s) от %s: payload -> %q", kind, ip, v))
+				ev.Fields = map[string]interface{}{"attack": "CRLF", "payload": v, "headers": names, "splitting": split}
+				if param != "" {
+					ev.Fields["param"] = param
+				}
+				if m.waf.excluded(ev) {
+					continue
+				}
+				if m.waf.decide(r, ev, m.logDetections, func() bool {
+					switch m.action {
+					case "log":
+						return false
+					case "block":
+						return forbid(w)()
+					}
+					return enforceAction(w, r, m.waf, ip, m.action, m.banDuration, m.delay)
+				}) {
+					return
+				}
+				injected = append(injected, headers...)
+			}
+		}
+		if len(injected) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Запрос пропущен: удалить из ответа заголовки, которые бэкенд отразил из параметров
+		next.ServeHTTP(newHeaderHookWriter(w, func(status int, h http.Header) {
+			var reflected []string
+			for _, inj := range injected {
+				values := h.Values(inj.name)
+				kept := values[:0:0]
+				for _, v := range values {
+					if strings.TrimSpace(v) == inj.value {
+						reflected = append(reflected, inj.name)
+						continue
+					}
+					kept = append(kept, v)
+				}
+				if len(kept) != len(values) {
+					h.Del(inj.name)
+					for _, v := range kept {
+						h.Add(inj.name, v)
+					}
+				}
+			}
+			if len(reflected) > 0 {
+				ev := requestEvent(r, ip, "crlf", SeverityCritical, "strip", fmt.Sprintf("Бэкенд отразил в ответе заголовки из параметров запроса от %s: %s (удалены)", ip, strings.Join(reflected, ", ")))
+				ev.Fields = map[string]interface{}{"attack": "CRLF", "headers": reflected, "reflected": true}
+				m.waf.emit(ev)
+			}
+		}), r)
+	})
+}
+
+// headerInjection ищет в раскодированном значении строки заголовков после CR/LF.
+// split — после заголовков идет пустая строка и содержимое, т.е. внедряется тело ответа.
+// Учитываются повторное URL-кодирование, %uXXXX и символы Unicode, младший байт
+// которых равен CR или LF (U+560A, U+560D): часть серверов отбрасывает старший байт.
+func headerInjection(v string) (headers []injectedHeader, split bool) {
+	v = decodePercentU(v)
+	for i := 0; i < 3; i++ {
+		decoded := unescapeLoose(v)
+		if decoded == v {
+			break
+		}
+		v = decoded
+	}
+	v = strings.Map(func(r rune) rune {
+		if r > 0xff && (r&0xff == '\r' || r&0xff == '\n') {
+			return r & 0xff
+		}
+		return r
+	}, v)
+	if !strings.ContainsAny(v, "\r\n") {
+		return nil, false
+	}
+
+	normalized := strings.ReplaceAll(strings.ReplaceAll(v, "\r\n", "\n"), "\r", "\n")
+	parts := strings.Split(normalized, "\n")
+	for i, line := range parts {
+		if i == 0 {
+			continue
+		}
+		if line == "" {
+			// Пустая строка в обычном тексте разделяет абзацы; разделением ответа она
+			// считается после внедренных заголовков или перед разметкой и статус-строкой
+			rest := strings.TrimSpace(strings.Join(parts[i+1:], "\n"))
+			if rest != "" && (len(headers) > 0 || strings.HasPrefix(rest, "<") || strings.HasPrefix(rest, "HTTP/")) {
+				split = true
+			}
+			continue
+		}
+		if name, value, ok := headerLine(line); ok {
+			headers = append(headers, injectedHeader{name: name, value: value})
+		}
+	}
+	return headers, split
+}
+
+// headerLine разбирает строку вида "Name: value"
+func headerLine(line string) (string, string, bool) {
+	name, value, ok := strings.Cut(line, ":")
+	if !ok || name == "" {
+		return "", "", false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return "", "", false
+		}
+	}
+	return http.CanonicalHeaderKey(name), strings.TrimSpace(value), true
+}
@@ -0,0 +1,181 @@
+package waf
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// CSRFMiddleware защищает изменяющие состояние запросы (POST, PUT, PATCH, DELETE):
+// проверяет Origin/Referer и double-submit токен. Токен выдается самим WAF
+// в cookie, клиент повторяет его в заголовке или поле формы.
+type CSRFMiddleware struct {
+	waf            *WAF
+	routes         []routePattern
+	allowedOrigins map[string]bool
+	requireOrigin  bool
+	cookieName     string
+	headerName     string
+	fieldName      string
+	secret         []byte
+	logDetections  bool
+}
+
+// NewCSRFMiddlewareWithConfig создает CSRF middleware из конфига
+func NewCSRFMiddlewareWithConfig(w *WAF, cfg CSRFConfig) *CSRFMiddleware {
+	m := &CSRFMiddleware{
+		waf:            w,
+		allowedOrigins: make(map[string]bool),
+		requireOrigin:  cfg.RequireOrigin,
+		cookieName:     "waf_csrf",
+		headerName:     "X-CSRF-Token",
+		fieldName:      "csrf_token",
+		logDetections:  true,
+	}
+	for _, p := range cfg.Routes {
+		m.routes = append(m.routes, compileRoutePattern(p))
+	}
+	for _, o := range cfg.AllowedOrigins {
+		m.allowedOrigins[strings.ToLower(strings.TrimRight(o, "/"))] = true
+	}
+	if cfg.CookieName != "" {
+		m.cookieName = cfg.CookieName
+	}
+	if cfg.HeaderName != "" {
+		m.headerName = cfg.HeaderName
+	}
+	if cfg.FieldName != "" {
+		m.fieldName = cfg.FieldName
+	}
+	if cfg.Secret != "" {
+		m.secret = []byte(cfg.Secret)
+	} else {
+		// Без секрета токены действительны до перезапуска: ключ общий для всех сборок цепи
+		m.secret = w.keep("csrf.secret", randomSecret).([]byte)
+	}
+	return m
+}
+
+func (m *CSRFMiddleware) push(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.waf == nil || !m.protects(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := ClientIP(r)
+		if m.waf.banned(r, ip) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			// Безопасные методы: выдать токен, если у клиента его еще нет
+			if c, err := r.Cookie(m.cookieName); err != nil || !m.validToken(c.Value) {
+				http.SetCookie(w, &http.Cookie{
+					Name:     m.cookieName,
+					Value:    m.newToken(),
+					Path:     "/",
+					Secure:   r.TLS != nil,
+					SameSite: http.SameSiteStrictMode,
+				})
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if reason := m.check(r); reason != "" {
+			ev := requestEvent(r, ip, "csrf", SeverityWarning, "block", fmt.Sprintf("CSRF проверка не пройдена от %s: %s %s: %s", ip, r.Method, r.URL.Path, reason))
+			if m.waf.decide(r, ev, m.logDetections, forbid(w)) {
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// protects проверяет, защищается ли путь (пустой список маршрутов — все пути)
+func (m *CSRFMiddleware) protects(path string) bool {
+	if len(m.routes) == 0 {
+		return true
+	}
+	for _, p := range m.routes {
+		if _, ok := p.match(path); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// check возвращает причину отказа для изменяющего запроса
+func (m *CSRFMiddleware) check(r *http.Request) string {
+	origin := r.Header.Get("Origin")
+	if origin == "" || origin == "null" {
+		if ref := r.Header.Get("Referer"); ref != "" {
+			if u, err := url.Parse(ref); err == nil {
+				origin = u.Scheme + "://" + u.Host
+			}
+		}
+	}
+	if origin == "" {
+		if m.requireOrigin {
+			return "отсутствуют Origin и Referer"
+		}
+	} else if !m.originAllowed(r, origin) {
+		return "недопустимый источник " + origin
+	}
+
+	cookie, err := r.Cookie(m.cookieName)
+	if err != nil || !m.validToken(cookie.Value) {
+		return "отсутствует или недействителен CSRF cookie"
+	}
+	submitted := r.Header.Get(m.headerName)
+	if submitted == "" {
+		if head, err := peekBody(r, maxCredentialBodySize); err == nil {
+			submitted = formValues(r, head).Get(m.fieldName)
+		}
+	}
+	if submitted == "" || !hmac.Equal([]byte(submitted), []byte(cookie.Value)) {
+		return "CSRF токен не совпадает с cookie"
+	}
+	return ""
+}
+
+// originAllowed разрешает собственный хост и источники из allowed_origins
+func (m *CSRFMiddleware) originAllowed(r *http.Request, origin string) bool {
+	origin = strings.ToLower(strings.TrimRight(origin, "/"))
+	if m.allowedOrigins[origin] {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// newToken создает случайный токен, подписанный секретом WAF
+func (m *CSRFMiddleware) newToken() string {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	n := hex.EncodeToString(nonce)
+	return n + "." + m.sign(n)
+}
+
+// validToken проверяет подпись токена (защита от подброшенных cookie)
+func (m *CSRFMiddleware) validToken(token string) bool {
+	nonce, sig, ok := strings.Cut(token, ".")
+	return ok && hmac.Equal([]byte(sig), []byte(m.sign(nonce)))
+}
+
+func (m *CSRFMiddleware) sign(nonce string) string {
+	mac := hmac.New(sha256.New, m.secret)
+	mac.Write([]byte(nonce))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,61 @@
+package waf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCSRFRejects(t *testing.T) {
+	w, err := NewWAF("http://127.0.0.1:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewCSRFMiddlewareWithConfig(w, CSRFConfig{RequireOrigin: true, AllowedOrigins: []string{"https://app.example.com"}, Secret: "csrf-secret"})
+	token := m.newToken()
+	forged := NewCSRFMiddlewareWithConfig(nil, CSRFConfig{Secret: "other"}).newToken()
+
+	for _, tc := range []struct {
+		name           string
+		origin, cookie string
+		header, form   string
+		ok             bool
+	}{
+		{"valid header", "https://waf.example.com", token, token, "", true},
+		{"valid form field", "https://app.example.com", token, "", token, true},
+		{"no origin", "", token, token, "", false},
+		{"foreign origin", "https://evil.example.com", token, token, "", false},
+		{"no cookie", "https://waf.example.com", "", token, "", false},
+		{"cookie signed by other secret", "https://waf.example.com", forged, forged, "", false},
+		{"unsigned cookie", "https://waf.example.com", "abc", "abc", "", false},
+		{"no submitted token", "https://waf.example.com", token, "", "", false},
+		{"token mismatch", "https://waf.example.com", token, m.newToken(), "", false},
+	} {
+		var body string
+		if tc.form != "" {
+			body = "csrf_token=" + tc.form
+		}
+		r := httptest.NewRequest(http.MethodPost, "http://waf.example.com/transfer", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if tc.origin != "" {
+			r.Header.Set("Origin", tc.origin)
+		}
+		if tc.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: "waf_csrf", Value: tc.cookie})
+		}
+		if tc.header != "" {
+			r.Header.Set("X-CSRF-Token", tc.header)
+		}
+
+		rec := httptest.NewRecorder()
+		reached := false
+		m.push(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { reached = true })).ServeHTTP(rec, r)
+		if reached != tc.ok {
+			t.Errorf("%s: passed %v, want %v (status %d)", tc.name, reached, tc.ok, rec.Code)
+		}
+		if !tc.ok && rec.Code != http.StatusForbidden {
+			t.Errorf("%s: status %d, want 403", tc.name, rec.Code)
+		}
+	}
+}
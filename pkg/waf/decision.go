@@ -0,0 +1,335 @@
+package waf
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Веса срабатываний по умолчанию по важности события
+var defaultDecisionWeights = map[string]float64{
+	SeverityInfo:     1,
+	SeverityWarning:  3,
+	SeverityCritical: 5,
+}
+
+// Действия движка решений по возрастанию строгости
+var decisionActionRank = map[string]int{"log": 0, "challenge": 1, "block": 2, "ban": 3}
+
+// decisionTier действия по важности срабатываний: общие или для маршрута
+type decisionTier struct {
+	pattern     routePattern
+	actions     map[string]string // важность -> действие
+	banDuration time.Duration
+}
+
+// action самое строгое действие для срабатываний
+func (t *decisionTier) action(findings []SecurityEvent) string {
+	action := ""
+	for _, ev := range findings {
+		if a := t.actions[ev.Severity]; a != "" && (action == "" || decisionActionRank[a] > decisionActionRank[action]) {
+			action = a
+		}
+	}
+	return action
+}
+
+// decisionEngine единая точка применения решений. Детекторы только сообщают о срабатываниях
+// (decide), а движок в конце цепи суммирует их веса и выбирает действие: пропустить,
+// проверить клиента (challenge), отказать или забанить. Модули, которые по протоколу
+// отвечают сами (429 лимита, 401 аутентификации, отказы CORS и hotlink), передают отказ
+// движку (refuse): он учитывается с остальными срабатываниями, а банит только движок.
+// Движок включается явно (decision.enabled); без него модули применяют свои действия.
+type decisionEngine struct {
+	waf            *WAF
+	weights        map[string]float64
+	challengeScore float64
+	blockScore     float64
+	banScore       float64
+	tier           decisionTier
+	routes         []decisionTier
+}
+
+func newDecisionEngine(w *WAF, cfg DecisionConfig) (*decisionEngine, error) {
+	e := &decisionEngine{
+		waf:            w,
+		weights:        make(map[string]float64),
+		challengeScore: cfg.ChallengeScore,
+		blockScore:     5,
+		banScore:       cfg.BanScore,
+		tier:           decisionTier{banDuration: 10 * time.Minute},
+	}
+	for k, v := range defaultDecisionWeights {
+		e.weights[k] = v
+	}
+	for k, v := range cfg.Weights {
+		e.weights[k] = v
+	}
+	if cfg.BlockScore > 0 {
+		e.blockScore = cfg.BlockScore
+	}
+	if cfg.BanSeconds > 0 {
+		e.tier.banDuration = time.Duration(cfg.BanSeconds) * time.Second
+	}
+	var err error
+	if e.tier.actions, err = severityActions(cfg.SeverityActions); err != nil {
+		return nil, err
+	}
+	for _, rc := range cfg.Routes {
+		t := decisionTier{pattern: compileRoutePattern(rc.Path), banDuration: e.tier.banDuration}
+		if t.actions, err = severityActions(rc.SeverityActions); err != nil {
+			return nil, fmt.Errorf("route %s: %w", rc.Path, err)
+		}
+		if rc.BanSeconds > 0 {
+			t.banDuration = time.Duration(rc.BanSeconds) * time.Second
+		}
+		e.routes = append(e.routes, t)
+	}
+	return e, nil
+}
+
+// severityActions проверяет соответствие важности и действий
+func severityActions(m map[string]string) (map[string]string, error) {
+	for severity, action := range m {
+		if _, ok := severityRank[severity]; !ok {
+			return nil, fmt.Errorf("severity_actions: unknown severity %q", severity)
+		}
+		if _, ok := decisionActionRank[action]; !ok {
+			return nil, fmt.Errorf("severity_actions: unknown action %q for %s", action, severity)
+		}
+	}
+	return m, nil
+}
+
+// tierFor настройки первого подходящего маршрута или общие
+func (e *decisionEngine) tierFor(r *http.Request) *decisionTier {
+	for i := range e.routes {
+		if _, ok := e.routes[i].pattern.match(r.URL.Path); ok {
+			return &e.routes[i]
+		}
+	}
+	return &e.tier
+}
+
+// action действие для срабатываний: строжайшее из действия по сумме весов и по важности
+func (e *decisionEngine) action(tier *decisionTier, findings []SecurityEvent) (string, float64, []string) {
+	score := 0.0
+	var counted []SecurityEvent
+	var modules []string
+	for _, ev := range findings {
+		if w := e.weight(ev); w > 0 {
+			score += w
+			counted = append(counted, ev)
+			modules = append(modules, ev.Module)
+		}
+	}
+	sort.Strings(modules)
+	action := ""
+	switch {
+	case e.banScore > 0 && score >= e.banScore:
+		action = "ban"
+	case score >= e.blockScore:
+		action = "block"
+	case e.challengeScore > 0 && score >= e.challengeScore:
+		action = "challenge"
+	}
+	if a := tier.action(counted); a != "" && decisionActionRank[a] > decisionActionRank[action] {
+		action = a
+	}
+	return action, score, modules
+}
+
+// decide передает срабатывание детектора политике; logged — публиковать событие в шину
+// (настройка журналирования детектора). Без движка решений детектор применяет собственное
+// действие: enforce отвечает клиенту и возвращает true, если запрос остановлен. С движком
+// срабатывание только учитывается, decide возвращает false, и детектор пропускает запрос дальше.
+// Срабатывания, подпадающие под исключения (exclusions), не учитываются вовсе.
+func (w *WAF) decide(r *http.Request, ev SecurityEvent, logged bool, enforce func() bool) bool {
+	if w.excluded(ev) {
+		return false
+	}
+	info := requestInfoOf(r)
+	if logged {
+		w.emit(ev)
+	} else {
+		if info != nil {
+			info.addFinding(ev)
+		}
+		w.reputation.observe(ev)
+	}
+	if info != nil && info.engine != nil {
+		return false
+	}
+	return enforce()
+}
+
+// refuse отказ, который модуль возвращает сам по протоколу: 429 лимита, 401 аутентификации,
+// 403 CORS и hotlink. Без движка решений событие публикуется (logged) и respond отвечает клиенту.
+// С движком отказ проходит через него: срабатывание учитывается вместе с остальными
+// срабатываниями запроса, и если их сумма требует бана, движок банит клиента и отвечает 403,
+// иначе клиент получает ответ модуля.
+func (w *WAF) refuse(rw http.ResponseWriter, r *http.Request, ev SecurityEvent, logged bool, respond func()) {
+	info := requestInfoOf(r)
+	if info == nil || info.engine == nil {
+		if logged {
+			w.emit(ev)
+		}
+		respond()
+		return
+	}
+	if !w.excluded(ev) {
+		if logged {
+			w.emit(ev)
+		} else {
+			info.addFinding(ev)
+			w.reputation.observe(ev)
+		}
+	}
+	info.engine.refuse(rw, r, respond)
+}
+
+// Decide передает срабатывание модуля плагина политике WAF так же, как встроенные детекторы.
+// Событие публикуется. Без движка решений клиент получает 403 и Decide возвращает true.
+// С движком (decision.enabled) срабатывание только учитывается, Decide возвращает false,
+// и модуль передает запрос дальше: действие выбирает движок в конце цепи.
+// Срабатывания, подпадающие под исключения, не учитываются, и Decide возвращает false.
+func (w *WAF) Decide(rw http.ResponseWriter, r *http.Request, ev SecurityEvent) bool {
+	full := requestEvent(r, ClientIP(r), ev.Module, ev.Severity, ev.Action, ev.Message)
+	full.Fields = ev.Fields
+	if full.Severity == "" {
+		full.Severity = SeverityWarning
+	}
+	if full.Action == "" {
+		full.Action = "block"
+	}
+	return w.decide(r, full, true, forbid(rw))
+}
+
+// forbid действие детекторов по умолчанию: отказ 403
+func forbid(w http.ResponseWriter) func() bool {
+	return func() bool {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return true
+	}
+}
+
+// weight вес срабатывания: по модулю с правилом или типом атаки (signature:SQLi, rules:no-old-api),
+// по модулю, по важности. Срабатывания с действием log по умолчанию не учитываются.
+func (e *decisionEngine) weight(ev SecurityEvent) float64 {
+	for _, key := range []string{"rule", "attack"} {
+		if v, ok := ev.Fields[key].(string); ok && v != "" {
+			if w, ok := e.weights[ev.Module+":"+v]; ok {
+				return w
+			}
+		}
+	}
+	if w, ok := e.weights[ev.Module]; ok {
+		return w
+	}
+	if ev.Action == "log" {
+		return 0
+	}
+	return e.weights[ev.Severity]
+}
+
+// decisionEntry начало цепи с движком решений: один раз проверяет бан и включает
+// отложенное применение действий детекторами
+type decisionEntry struct{ engine *decisionEngine }
+
+func (m *decisionEntry) push(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := requestInfoOf(r)
+		if info == nil {
+			// Цепь без Handler (офлайн-прогоны): контекст анализа создается здесь
+			info = &requestInfo{}
+			r = r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info))
+		}
+		info.engine = m.engine
+		if m.engine.waf.banned(r, ClientIP(r)) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// decisionExit конец цепи: решение по срабатываниям до передачи бэкенду, затем
+// бан по срабатываниям, появившимся при обработке ответа (всплески 404, неудачные входы)
+type decisionExit struct{ engine *decisionEngine }
+
+func (m *decisionExit) push(next http.Handler) http.Handler {
+	e := m.engine
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		findings := Findings(r)
+		tier := e.tierFor(r)
+		action, score, modules := e.action(tier, findings)
+		ip := ClientIP(r)
+		switch action {
+		case "ban":
+			e.ban(w, r, ip, tier, score, modules)
+			return
+		case "block":
+			e.apply(r, ip, action, score, modules)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		case "challenge":
+			if !e.waf.challenges.Passed(r, ip) {
+				e.apply(r, ip, action, score, modules)
+				e.waf.challenges.Issue(w, ip)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+
+		after := Findings(r)
+		if len(after) == len(findings) {
+			return
+		}
+		if action, score, modules := e.action(tier, after); action == "ban" {
+			e.apply(r, ip, action, score, modules)
+			e.waf.bans.Ban(ip, tier.banDuration)
+		}
+	})
+}
+
+// decisionEnabled проверяет, что запрос обрабатывает цепь с движком решений
+func decisionEnabled(r *http.Request) bool {
+	info := requestInfoOf(r)
+	return info != nil && info.engine != nil
+}
+
+// refuse применяет отказ модуля: бан, если срабатывания запроса его требуют, иначе ответ модуля
+func (e *decisionEngine) refuse(w http.ResponseWriter, r *http.Request, respond func()) {
+	tier := e.tierFor(r)
+	if action, score, modules := e.action(tier, Findings(r)); action == "ban" {
+		e.ban(w, r, ClientIP(r), tier, score, modules)
+		return
+	}
+	respond()
+}
+
+// ban банит клиента на срок маршрута и отвечает 403
+func (e *decisionEngine) ban(w http.ResponseWriter, r *http.Request, ip string, tier *decisionTier, score float64, modules []string) {
+	e.apply(r, ip, "ban", score, modules)
+	e.waf.bans.Ban(ip, tier.banDuration)
+	w.Header().Set("Retry-After", strconv.FormatInt(int64(tier.banDuration.Seconds()), 10))
+	http.Error(w, "Forbidden", http.StatusForbidden)
+}
+
+// apply публикует решение движка
+func (e *decisionEngine) apply(r *http.Request, ip, action string, score float64, modules []string) {
+	severity := SeverityWarning
+	if action != "challenge" {
+		severity = SeverityCritical
+	}
+	ev := requestEvent(r, ip, "decision", severity, action,
+		fmt.Sprintf("Решение %s для %s %s %s: сумма срабатываний %.1f (%s)", action, ip, r.Method, r.URL.Path, score, strings.Join(modules, ", ")))
+	ev.Type = EventDecision
+	ev.Fields = map[string]interface{}{"score": score, "modules": modules}
+	e.waf.emit(ev)
+}
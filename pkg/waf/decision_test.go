@@ -0,0 +1,88 @@
+package waf
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// Отказ rate_limit с движком решений проходит через движок: банит только движок
+func TestDecisionRefuse(t *testing.T) {
+	const ip = "192.0.2.1"
+	for _, tc := range []struct {
+		name     string
+		decision DecisionConfig
+		status   int  // ответ на второй запрос
+		banned   bool // клиент забанен после него
+	}{
+		{"without engine module bans itself", DecisionConfig{}, http.StatusTooManyRequests, true},
+		{"engine below ban_score", DecisionConfig{Enabled: true, BanScore: 10}, http.StatusTooManyRequests, false},
+		{"engine reaches ban_score", DecisionConfig{Enabled: true, BanScore: 10, Weights: map[string]float64{"rate_limit": 10}}, http.StatusForbidden, true},
+	} {
+		w, err := New(&Config{
+			ServerAddress:   "http://127.0.0.1:1",
+			MiddlewareChain: []string{"rate_limit"},
+			RateLimit:       RateLimitConfig{Limit: 0.001, Burst: 1, BanSeconds: 60},
+			Decision:        tc.decision,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := w.Handler(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+		statuses := make([]int, 2)
+		for i := range statuses {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://waf.example.com/", nil))
+			statuses[i] = rec.Code
+		}
+		if statuses[0] != http.StatusOK || statuses[1] != tc.status {
+			t.Errorf("%s: statuses %v, want [200 %d]", tc.name, statuses, tc.status)
+		}
+		if banned := w.bans.IsBanned(ip); banned != tc.banned {
+			t.Errorf("%s: banned %v, want %v", tc.name, banned, tc.banned)
+		}
+	}
+}
+
+var registerDecidePlugin sync.Once
+
+// Срабатывание плагина через Decide: без движка 403, с движком решение в конце цепи
+func TestDecide(t *testing.T) {
+	registerDecidePlugin.Do(func() {
+		RegisterPlugin("test_decide", func(w *WAF, _ json.RawMessage) (func(http.Handler) http.Handler, error) {
+			return func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+					if w.Decide(rw, r, SecurityEvent{Module: "test_decide", Severity: SeverityCritical, Message: "test"}) {
+						return
+					}
+					next.ServeHTTP(rw, r)
+				})
+			}, nil
+		})
+	})
+
+	for _, tc := range []struct {
+		name     string
+		decision DecisionConfig
+		status   int
+		reached  bool
+	}{
+		{"without engine", DecisionConfig{}, http.StatusForbidden, false},
+		{"engine blocks", DecisionConfig{Enabled: true}, http.StatusForbidden, false},
+		{"engine below block_score", DecisionConfig{Enabled: true, BlockScore: 100}, http.StatusOK, true},
+	} {
+		w, err := New(&Config{ServerAddress: "http://127.0.0.1:1", MiddlewareChain: []string{"test_decide"}, Decision: tc.decision})
+		if err != nil {
+			t.Fatal(err)
+		}
+		reached := false
+		rec := httptest.NewRecorder()
+		w.Handler(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { reached = true })).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://waf.example.com/", nil))
+		if rec.Code != tc.status || reached != tc.reached {
+			t.Errorf("%s: status %d, reached %v, want %d, %v", tc.name, rec.Code, reached, tc.status, tc.reached)
+		}
+	}
+}
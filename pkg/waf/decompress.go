@@ -0,0 +1,137 @@
+package waf
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+var (
+	errDecodedBodyTooLarge = errors.New("decompressed request body exceeds limit")
+	errBodyEncoding        = errors.New("malformed compressed request body")
+)
+
+// bodyDecoder распаковывает тела запросов с Content-Encoding gzip, deflate и br до всех модулей:
+// сигнатуры, схемы и правила видят распакованное тело, и сжатие не скрывает атаку.
+// Бэкенд получает распакованное тело без Content-Encoding. Распаковка потоковая,
+// размер результата ограничен maxBytes (защита от zip-бомб). Тела в кодировках, которые
+// WAF не умеет распаковывать (zstd), по умолчанию отклоняются: их нельзя проверить.
+type bodyDecoder struct {
+	maxBytes         int64
+	allowUnsupported bool
+	logDetections    bool
+	events           *EventBus
+}
+
+const defaultMaxDecodedBody = 10 << 20
+
+func (d *bodyDecoder) push(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ce := strings.TrimSpace(r.Header.Get("Content-Encoding"))
+		if ce == "" || r.Body == nil || r.Body == http.NoBody {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Кодировки перечислены в порядке применения, распаковываются в обратном
+		encodings := strings.Split(strings.ToLower(ce), ",")
+		body := io.Reader(r.Body)
+		for i := len(encodings) - 1; i >= 0; i-- {
+			switch enc := strings.TrimSpace(encodings[i]); enc {
+			case "identity", "":
+			case "gzip", "x-gzip":
+				body = &lazyDecoder{src: body, open: func(src io.Reader) (io.Reader, error) { return gzip.NewReader(src) }}
+			case "deflate":
+				body = &lazyDecoder{src: body, open: openDeflate}
+			case "br":
+				body = &lazyDecoder{src: body, open: func(src io.Reader) (io.Reader, error) { return brotli.NewReader(src), nil }}
+			default:
+				if d.allowUnsupported {
+					next.ServeHTTP(w, r)
+					return
+				}
+				ip := ClientIP(r)
+				if d.logDetections && d.events != nil {
+					d.events.Publish(requestEvent(r, ip, "decompression", SeverityWarning, "block", fmt.Sprintf("Тело запроса от %s в неподдерживаемой кодировке %q не может быть проверено", ip, enc)))
+				}
+				http.Error(w, "Unsupported Media Type", http.StatusUnsupportedMediaType)
+				return
+			}
+		}
+
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{&cappedReader{r: body, remaining: d.maxBytes}, r.Body}
+		r.Header.Del("Content-Encoding")
+		r.Header.Del("Content-Length")
+		r.ContentLength = -1
+		r.TransferEncoding = []string{"chunked"}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// lazyDecoder создает распаковщик при первом чтении: заголовок gzip читается из тела,
+// и ошибка должна прийти читающему модулю или бэкенду, а не в момент подмены тела
+type lazyDecoder struct {
+	src  io.Reader
+	open func(io.Reader) (io.Reader, error)
+	dec  io.Reader
+	err  error
+}
+
+func (l *lazyDecoder) Read(p []byte) (int, error) {
+	if l.dec == nil && l.err == nil {
+		if l.dec, l.err = l.open(l.src); l.err != nil {
+			l.err = fmt.Errorf("%w: %v", errBodyEncoding, l.err)
+		}
+	}
+	if l.err != nil {
+		return 0, l.err
+	}
+	n, err := l.dec.Read(p)
+	if err != nil && err != io.EOF && !errors.Is(err, errDecodedBodyTooLarge) {
+		err = fmt.Errorf("%w: %v", errBodyEncoding, err)
+	}
+	return n, err
+}
+
+// openDeflate распаковывает deflate в обертке zlib (RFC 9110) и, как многие серверы, без нее
+func openDeflate(src io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(src)
+	if h, err := br.Peek(2); err == nil && h[0]&0x0f == 8 && (uint16(h[0])<<8|uint16(h[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+// cappedReader возвращает ошибку, если распакованное тело больше лимита
+type cappedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (c *cappedReader) Read(p []byte) (int, error) {
+	if c.remaining <= 0 {
+		// Проверить, что тело действительно длиннее лимита, а не закончилось ровно на нем
+		var one [1]byte
+		if n, _ := c.r.Read(one[:]); n > 0 {
+			return 0, errDecodedBodyTooLarge
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.remaining -= int64(n)
+	return n, err
+}
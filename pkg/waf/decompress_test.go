@@ -0,0 +1,92 @@
+package waf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func compressBody(t *testing.T, enc string, body []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch enc {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "br":
+		w = brotli.NewWriter(&buf)
+	default:
+		t.Fatalf("unknown encoding %q", enc)
+	}
+	w.Write(body)
+	w.Close()
+	return buf.Bytes()
+}
+
+// Модули и бэкенд получают распакованное тело, лимит действует для всех кодировок
+func TestBodyDecoder(t *testing.T) {
+	payload := []byte("q=1' UNION SELECT password FROM users--")
+	bomb := bytes.Repeat([]byte{'a'}, 1<<20)
+
+	for _, tc := range []struct {
+		name     string
+		encoding string
+		body     []byte
+		want     string
+		err      error
+		status   int
+	}{
+		{"gzip", "gzip", compressBody(t, "gzip", payload), string(payload), nil, 0},
+		{"deflate", "deflate", compressBody(t, "deflate", payload), string(payload), nil, 0},
+		{"brotli", "br", compressBody(t, "br", payload), string(payload), nil, 0},
+		{"brotli then gzip", "br, gzip", compressBody(t, "gzip", compressBody(t, "br", payload)), string(payload), nil, 0},
+		{"brotli over limit", "br", compressBody(t, "br", bomb), "", errDecodedBodyTooLarge, 0},
+		{"malformed brotli", "br", []byte("not brotli at all"), "", errBodyEncoding, 0},
+		{"unsupported", "zstd", payload, "", nil, http.StatusUnsupportedMediaType},
+	} {
+		d := &bodyDecoder{maxBytes: 64 << 10}
+		r := httptest.NewRequest(http.MethodPost, "http://waf.example.com/search", bytes.NewReader(tc.body))
+		r.Header.Set("Content-Encoding", tc.encoding)
+
+		var got []byte
+		var readErr error
+		reached := false
+		rec := httptest.NewRecorder()
+		d.push(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			reached = true
+			if ce := r.Header.Get("Content-Encoding"); ce != "" {
+				t.Errorf("%s: Content-Encoding %q left for the backend", tc.name, ce)
+			}
+			got, readErr = io.ReadAll(r.Body)
+		})).ServeHTTP(rec, r)
+
+		if tc.status != 0 {
+			if reached || rec.Code != tc.status {
+				t.Errorf("%s: reached %v, status %d, want %d", tc.name, reached, rec.Code, tc.status)
+			}
+			continue
+		}
+		if !reached {
+			t.Errorf("%s: request did not reach the handler (status %d)", tc.name, rec.Code)
+			continue
+		}
+		if tc.err != nil {
+			if !errors.Is(readErr, tc.err) {
+				t.Errorf("%s: error %v, want %v", tc.name, readErr, tc.err)
+			}
+			continue
+		}
+		if readErr != nil || string(got) != tc.want {
+			t.Errorf("%s: body %q, error %v, want %q", tc.name, got, readErr, tc.want)
+		}
+	}
+}
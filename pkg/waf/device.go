@@ -0,0 +1,143 @@
+package waf
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DeviceMiddleware выдает клиенту при первом визите подписанную httpOnly cookie с
+// идентификатором устройства. Идентификатор из cookie, пришедшей обратно, служит
+// дополнительной идентичностью клиента (identity.device): за CGNAT поведение отдельных
+// устройств отслеживается раздельно, а не по общему IP.
+type DeviceMiddleware struct {
+	waf           *WAF
+	cookieName    string
+	secret        []byte
+	maxAge        time.Duration
+	secure        bool
+	logDetections bool
+}
+
+// NewDeviceMiddlewareWithConfig создает выдачу cookie устройства из конфига
+func NewDeviceMiddlewareWithConfig(w *WAF, cfg DeviceConfig) *DeviceMiddleware {
+	m := &DeviceMiddleware{
+		waf:           w,
+		cookieName:    "waf_device",
+		maxAge:        365 * 24 * time.Hour,
+		secure:        cfg.Secure,
+		logDetections: true,
+	}
+	if cfg.CookieName != "" {
+		m.cookieName = cfg.CookieName
+	}
+	if cfg.MaxAgeDays > 0 {
+		m.maxAge = time.Duration(cfg.MaxAgeDays) * 24 * time.Hour
+	}
+	if cfg.Secret != "" {
+		key := sha256.Sum256([]byte("device|" + cfg.Secret))
+		m.secret = key[:]
+	} else {
+		// Без секрета cookie действительны до перезапуска: ключ общий для всех сборок цепи
+		m.secret = w.keep("device.secret", randomSecret).([]byte)
+	}
+	return m
+}
+
+func (m *DeviceMiddleware) push(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.waf == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := ClientIP(r)
+		if m.waf.banned(r, ip) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		if c, err := r.Cookie(m.cookieName); err != nil {
+			m.issue(w)
+		} else if id := m.verify(c.Value); id != "" {
+			if info := requestInfoOf(r); info != nil {
+				info.device = id
+			}
+			if m.waf.banned(r, "device:"+id) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+		} else {
+			// Подделанная или выданная с другим секретом cookie заменяется новой
+			if m.logDetections {
+				m.waf.emit(requestEvent(r, ip, "device", SeverityWarning, "log", fmt.Sprintf("Недействительная cookie устройства %s от %s заменена", m.cookieName, ip)))
+			}
+			m.issue(w)
+		}
+
+		// Бэкенду cookie устройства не нужна
+		removeCookie(r, m.cookieName)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// issue выдает новую cookie устройства
+func (m *DeviceMiddleware) issue(w http.ResponseWriter) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return
+	}
+	id := hex.EncodeToString(raw)
+	http.SetCookie(w, &http.Cookie{
+		Name:     m.cookieName,
+		Value:    id + "." + m.mac(id),
+		Path:     "/",
+		MaxAge:   int(m.maxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   m.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// verify возвращает идентификатор устройства из значения cookie или "", если подпись неверна
+func (m *DeviceMiddleware) verify(value string) string {
+	id, mac, ok := strings.Cut(value, ".")
+	if !ok || len(id) != 32 || !hmac.Equal([]byte(mac), []byte(m.mac(id))) {
+		return ""
+	}
+	return id
+}
+
+func (m *DeviceMiddleware) mac(id string) string {
+	h := hmac.New(sha256.New, m.secret)
+	h.Write([]byte(id))
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// removeCookie убирает cookie из запроса, оставляя остальные
+func removeCookie(r *http.Request, name string) {
+	cookies := r.Cookies()
+	parts := make([]string, 0, len(cookies))
+	for _, c := range cookies {
+		if c.Name != name {
+			parts = append(parts, (&http.Cookie{Name: c.Name, Value: c.Value}).String())
+		}
+	}
+	r.Header.Del("Cookie")
+	if len(parts) > 0 {
+		r.Header.Set("Cookie", strings.Join(parts, "; "))
+	}
+}
+
+// deviceID идентификатор устройства из проверенной cookie запроса или ""
+func deviceID(r *http.Request) string {
+	if info := requestInfoOf(r); info != nil {
+		return info.device
+	}
+	return ""
+}
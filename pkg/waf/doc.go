@@ -0,0 +1,19 @@
+// Package waf — stateful Web Application Firewall для net/http.
+//
+// Пакет можно запускать как обратный прокси (Run, RunWithConfig) или встраивать
+// в собственный сервис:
+//
+//	cfg, err := waf.LoadConfig("waf_config.json")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	w, err := waf.New(cfg)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	http.ListenAndServe(":8080", w.Handler(appMux))
+//
+// Модули защиты создаются конструкторами NewXxxMiddleware / NewXxxMiddlewareWithConfig
+// и добавляются в цепь через RegisterMiddleware. Все модули одного WAF используют общие
+// хранилища состояний клиентов (States) и блокировок (Bans).
+package waf
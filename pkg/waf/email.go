@@ -0,0 +1,316 @@
+package waf
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func init() {
+	eventSinks["email"] = newEmailSink
+}
+
+// emailSink отправляет письма по SMTP: немедленно о важных событиях и сводки
+// заблокированного трафика раз в час или в сутки. Режим задается для каждого получателя.
+type emailSink struct {
+	addr     string
+	host     string
+	from     string
+	auth     smtp.Auth
+	implicit bool // TLS с начала соединения (порт 465); иначе STARTTLS, если сервер его предлагает
+
+	immediate []emailRecipient
+	digests   map[time.Duration]*emailDigest
+}
+
+type emailRecipient struct {
+	address     string
+	minSeverity int
+	limiter     *rate.Limiter
+}
+
+// emailDigest накопленная за период сводка
+type emailDigest struct {
+	period     time.Duration
+	recipients []string
+
+	mu       sync.Mutex
+	start    time.Time
+	blocked  int
+	bans     int
+	byModule map[string]int
+	byIP     map[string]int
+	byPath   map[string]int
+}
+
+func newEmailSink(raw json.RawMessage) (EventSink, error) {
+	var s struct {
+		SMTP struct {
+			Addr     string `json:"addr"` // host:port
+			Username string `json:"username"`
+			Password string `json:"password"`
+			From     string `json:"from"`
+			TLS      bool   `json:"tls"` // TLS с начала соединения (порт 465)
+		} `json:"smtp"`
+		Recipients []struct {
+			Address    string `json:"address"`
+			Immediate  string `json:"immediate"`    // минимальная важность немедленных писем; по умолчанию critical, "none" — выключены
+			Digest     string `json:"digest"`       // hourly, daily или пусто
+			MaxPerHour int    `json:"max_per_hour"` // лимит немедленных писем, по умолчанию 20
+		} `json:"recipients"`
+	}
+	if err := decodeSettings(raw, &s); err != nil {
+		return nil, err
+	}
+	if s.SMTP.Addr == "" || s.SMTP.From == "" {
+		return nil, errors.New("smtp.addr and smtp.from are required")
+	}
+	if len(s.Recipients) == 0 {
+		return nil, errors.New("recipients are required")
+	}
+	host, _, err := net.SplitHostPort(s.SMTP.Addr)
+	if err != nil {
+		return nil, err
+	}
+	e := &emailSink{
+		addr:     s.SMTP.Addr,
+		host:     host,
+		from:     s.SMTP.From,
+		implicit: s.SMTP.TLS,
+		digests:  make(map[time.Duration]*emailDigest),
+	}
+	if s.SMTP.Username != "" {
+		e.auth = smtp.PlainAuth("", s.SMTP.Username, s.SMTP.Password, host)
+	}
+
+	for _, rc := range s.Recipients {
+		if rc.Address == "" {
+			return nil, errors.New("recipient address is required")
+		}
+		switch rc.Immediate {
+		case "none":
+		case "":
+			rc.Immediate = SeverityCritical
+			fallthrough
+		default:
+			rank, ok := severityRank[rc.Immediate]
+			if !ok {
+				return nil, fmt.Errorf("recipient %s: unknown severity %q", rc.Address, rc.Immediate)
+			}
+			perHour := 20
+			if rc.MaxPerHour > 0 {
+				perHour = rc.MaxPerHour
+			}
+			e.immediate = append(e.immediate, emailRecipient{
+				address:     rc.Address,
+				minSeverity: rank,
+				limiter:     rate.NewLimiter(rate.Limit(float64(perHour)/3600), perHour),
+			})
+		}
+
+		var period time.Duration
+		switch rc.Digest {
+		case "":
+			continue
+		case "hourly":
+			period = time.Hour
+		case "daily":
+			period = 24 * time.Hour
+		default:
+			return nil, fmt.Errorf("recipient %s: unknown digest %q", rc.Address, rc.Digest)
+		}
+		d := e.digests[period]
+		if d == nil {
+			d = &emailDigest{period: period}
+			d.reset(time.Now())
+			e.digests[period] = d
+		}
+		d.recipients = append(d.recipients, rc.Address)
+	}
+	for _, d := range e.digests {
+		go e.runDigest(d)
+	}
+	return e, nil
+}
+
+func (e *emailSink) HandleEvent(ev SecurityEvent) {
+	for _, d := range e.digests {
+		d.add(ev)
+	}
+	var to []string
+	for _, r := range e.immediate {
+		if severityRank[ev.Severity] >= r.minSeverity && r.limiter.Allow() {
+			to = append(to, r.address)
+		}
+	}
+	if len(to) == 0 {
+		return
+	}
+	subject := fmt.Sprintf("[WAF %s] %s: %s", strings.ToUpper(ev.Severity), ev.Module, ev.Type)
+	var body strings.Builder
+	fmt.Fprintf(&body, "%s\n\nВремя: %s\n", ev.Message, ev.Time.Format(time.RFC3339))
+	if ev.IP != "" {
+		fmt.Fprintf(&body, "Клиент: %s\n", ev.IP)
+	}
+	if ev.Path != "" {
+		fmt.Fprintf(&body, "Запрос: %s %s\n", ev.Method, ev.Path)
+	}
+	if ev.Action != "" {
+		fmt.Fprintf(&body, "Действие: %s\n", ev.Action)
+	}
+	if err := e.send(to, subject, body.String()); err != nil {
+		log.Printf("[WAF] Ошибка отправки письма: %v", err)
+	}
+}
+
+// blockedAction сообщает, что событие означает отказ клиенту
+func blockedAction(action string) bool {
+	switch action {
+	case "block", "ban", "throttle", "challenge", "tarpit":
+		return true
+	}
+	return false
+}
+
+func (d *emailDigest) reset(now time.Time) {
+	d.start = now
+	d.blocked, d.bans = 0, 0
+	d.byModule = make(map[string]int)
+	d.byIP = make(map[string]int)
+	d.byPath = make(map[string]int)
+}
+
+func (d *emailDigest) add(ev SecurityEvent) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch {
+	case ev.Type == EventBan:
+		d.bans++
+	case ev.Type == EventDetection && blockedAction(ev.Action):
+		d.blocked++
+		d.byModule[ev.Module]++
+		if ev.IP != "" {
+			d.byIP[ev.IP]++
+		}
+		if ev.Path != "" {
+			d.byPath[ev.Path]++
+		}
+	}
+}
+
+// runDigest отправляет сводку в начале каждого периода (час или полночь UTC)
+func (e *emailSink) runDigest(d *emailDigest) {
+	for {
+		now := time.Now()
+		time.Sleep(now.Truncate(d.period).Add(d.period).Sub(now))
+
+		d.mu.Lock()
+		subject, body, empty := d.render(time.Now())
+		d.reset(time.Now())
+		d.mu.Unlock()
+		if empty {
+			continue
+		}
+		if err := e.send(d.recipients, subject, body); err != nil {
+			log.Printf("[WAF] Ошибка отправки сводки: %v", err)
+		}
+	}
+}
+
+// render формирует текст сводки; empty=true — за период ничего не заблокировано
+func (d *emailDigest) render(now time.Time) (subject, body string, empty bool) {
+	if d.blocked == 0 && d.bans == 0 {
+		return "", "", true
+	}
+	subject = fmt.Sprintf("[WAF] Сводка: заблокировано %d запросов, %d банов", d.blocked, d.bans)
+	var b strings.Builder
+	fmt.Fprintf(&b, "Период: %s — %s\n", d.start.Format(time.RFC3339), now.Format(time.RFC3339))
+	fmt.Fprintf(&b, "Заблокировано запросов: %d\nНовых банов: %d\n", d.blocked, d.bans)
+	writeTop(&b, "Модули", d.byModule, 0)
+	writeTop(&b, "Клиенты", d.byIP, 10)
+	writeTop(&b, "Пути", d.byPath, 10)
+	return subject, b.String(), false
+}
+
+// writeTop выводит значения по убыванию счетчика; limit=0 — все
+func writeTop(b *strings.Builder, title string, counts map[string]int, limit int) {
+	if len(counts) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+	fmt.Fprintf(b, "\n%s:\n", title)
+	for _, k := range keys {
+		fmt.Fprintf(b, "  %6d  %s\n", counts[k], k)
+	}
+}
+
+// send отправляет письмо в text/plain UTF-8
+func (e *emailSink) send(to []string, subject, body string) error {
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\nTo: %s\r\n", e.from, strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: =?UTF-8?B?%s?=\r\n", base64.StdEncoding.EncodeToString([]byte(subject)))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\nContent-Transfer-Encoding: 8bit\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	if !e.implicit {
+		return smtp.SendMail(e.addr, e.auth, e.from, to, []byte(msg.String()))
+	}
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 10 * time.Second}, "tcp", e.addr, &tls.Config{ServerName: e.host})
+	if err != nil {
+		return err
+	}
+	c, err := smtp.NewClient(conn, e.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if e.auth != nil {
+		if err := c.Auth(e.auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(e.from); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := c.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(msg.String())); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
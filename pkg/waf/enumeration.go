@@ -0,0 +1,117 @@
+package waf
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// EnumerationMiddleware обнаруживает перебор аккаунтов на эндпоинтах регистрации
+// и сброса пароля: много разных логинов/email от одного клиента за окно.
+// Работает аналогично подсчету уникальных ресурсов в ContextMiddleware.
+type EnumerationMiddleware struct {
+	waf           *WAF
+	paths         []routePattern
+	fields        []string
+	window        time.Duration
+	threshold     int
+	action        string // throttle, delay, challenge, ban
+	banDuration   time.Duration
+	delay         time.Duration
+	identity      IdentityConfig
+	logDetections bool
+}
+
+// NewEnumerationMiddleware создает детектор перебора аккаунтов с дефолт настройками
+func NewEnumerationMiddleware(w *WAF, paths []string) *EnumerationMiddleware {
+	m := &EnumerationMiddleware{
+		waf:           w,
+		fields:        []string{"username", "login", "email"},
+		window:        10 * time.Minute,
+		threshold:     5,
+		action:        "throttle",
+		banDuration:   10 * time.Minute,
+		delay:         2 * time.Second,
+		logDetections: true,
+	}
+	for _, p := range paths {
+		m.paths = append(m.paths, compileRoutePattern(p))
+	}
+	return m
+}
+
+// NewEnumerationMiddlewareWithConfig создает детектор перебора аккаунтов из конфига
+func NewEnumerationMiddlewareWithConfig(w *WAF, cfg EnumerationConfig) *EnumerationMiddleware {
+	m := NewEnumerationMiddleware(w, cfg.Paths)
+	if len(cfg.Fields) > 0 {
+		m.fields = cfg.Fields
+	}
+	if cfg.WindowSeconds > 0 {
+		m.window = time.Duration(cfg.WindowSeconds) * time.Second
+	}
+	if cfg.Threshold > 0 {
+		m.threshold = cfg.Threshold
+	}
+	if cfg.Action != "" {
+		m.action = cfg.Action
+	}
+	if cfg.BanSeconds > 0 {
+		m.banDuration = time.Duration(cfg.BanSeconds) * time.Second
+	}
+	if cfg.DelayMs > 0 {
+		m.delay = time.Duration(cfg.DelayMs) * time.Millisecond
+	}
+	m.identity = cfg.Identity
+	return m
+}
+
+func (m *EnumerationMiddleware) push(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.waf == nil || !m.isEnumerationPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := ClientIP(r)
+		if m.waf.banned(r, ip) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		account := extractCredentialField(r, m.fields)
+		if account == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		id := identityKey(r, m.identity, ip)
+		st := m.waf.states.Get(id)
+		if st == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Подсчитать разные аккаунты, запрошенные клиентом за окно
+		count := distinctCount(st, "enumeration_accounts", account, m.window, true)
+		if count > m.waf.reputation.tighten(ip, m.threshold) {
+			ev := requestEvent(r, id, "enumeration", SeverityWarning, m.action, fmt.Sprintf("Обнаружен перебор аккаунтов от %s на %s: %d разных аккаунтов за %s, действие: %s", id, r.URL.Path, count, m.window, m.action))
+			if m.waf.decide(r, ev, m.logDetections, func() bool {
+				return enforceAction(w, r, m.waf, id, m.action, m.banDuration, m.delay)
+			}) {
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isEnumerationPath проверяет, относится ли путь к защищаемым эндпоинтам
+func (m *EnumerationMiddleware) isEnumerationPath(path string) bool {
+	for _, p := range m.paths {
+		if _, ok := p.match(path); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+//go:build kafka
+
+package waf
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+)
+
+func init() {
+	eventSinks["kafka"] = newKafkaSink
+}
+
+// kafkaSink публикует события в топик Kafka. Ключ сообщения — IP клиента,
+// поэтому события одного клиента попадают в одну партицию и сохраняют порядок.
+type kafkaSink struct {
+	writer *kafka.Writer
+}
+
+func newKafkaSink(raw json.RawMessage) (EventSink, error) {
+	var s struct {
+		Brokers  []string `json:"brokers"`
+		Topic    string   `json:"topic"` // по умолчанию waf-events
+		TLS      bool     `json:"tls"`
+		Username string   `json:"username"` // SASL PLAIN
+		Password string   `json:"password"`
+		BatchMs  int      `json:"batch_ms"` // задержка накопления пакета, по умолчанию 100 мс
+	}
+	if err := decodeSettings(raw, &s); err != nil {
+		return nil, err
+	}
+	if len(s.Brokers) == 0 {
+		return nil, errors.New("brokers are required")
+	}
+	if s.Topic == "" {
+		s.Topic = "waf-events"
+	}
+	batch := 100 * time.Millisecond
+	if s.BatchMs > 0 {
+		batch = time.Duration(s.BatchMs) * time.Millisecond
+	}
+	transport := &kafka.Transport{}
+	if s.TLS {
+		transport.TLS = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	if s.Username != "" {
+		transport.SASL = plain.Mechanism{Username: s.Username, Password: s.Password}
+	}
+	w := &kafka.Writer{
+		Addr:         kafka.TCP(s.Brokers...),
+		Topic:        s.Topic,
+		Balancer:     &kafka.Hash{},
+		BatchTimeout: batch,
+		RequiredAcks: kafka.RequireOne,
+		Async:        true,
+		Transport:    transport,
+		Completion: func(messages []kafka.Message, err error) {
+			if err != nil {
+				log.Printf("[WAF] Ошибка отправки %d событий в Kafka: %v", len(messages), err)
+			}
+		},
+	}
+	return &kafkaSink{writer: w}, nil
+}
+
+func (s *kafkaSink) HandleEvent(ev SecurityEvent) {
+	value, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	msg := kafka.Message{
+		Key:   []byte(ev.IP),
+		Value: value,
+		Headers: []kafka.Header{
+			{Key: "type", Value: []byte(ev.Type)},
+			{Key: "module", Value: []byte(ev.Module)},
+		},
+		Time: ev.Time,
+	}
+	// В асинхронном режиме WriteMessages не ждет брокер; ошибки приходят в Completion
+	if err := s.writer.WriteMessages(context.Background(), msg); err != nil {
+		log.Printf("[WAF] Ошибка отправки события в Kafka: %v", err)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !kafka
+
+package waf
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+func init() {
+	eventSinks["kafka"] = func(json.RawMessage) (EventSink, error) {
+		return nil, errors.New("Kafka support is not compiled in (build with -tags kafka)")
+	}
+}
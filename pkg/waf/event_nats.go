@@ -0,0 +1,162 @@
+package waf
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	eventSinks["nats"] = newNATSSink
+}
+
+// natsSink публикует события в NATS. Клиент реализует только нужную часть текстового
+// протокола (CONNECT, PUB, PING/PONG), поэтому внешняя библиотека не требуется.
+type natsSink struct {
+	addr    string
+	subject string // может содержать {type}, {module}, {severity}
+	connect []byte
+
+	mu   sync.Mutex
+	conn net.Conn
+	w    *bufio.Writer
+}
+
+func newNATSSink(raw json.RawMessage) (EventSink, error) {
+	var s struct {
+		URL      string `json:"url"`     // nats://host:4222, по умолчанию nats://127.0.0.1:4222
+		Subject  string `json:"subject"` // по умолчанию waf.events.{type}
+		User     string `json:"user"`
+		Password string `json:"password"`
+		Token    string `json:"token"`
+	}
+	if err := decodeSettings(raw, &s); err != nil {
+		return nil, err
+	}
+	if s.URL == "" {
+		s.URL = "nats://127.0.0.1:4222"
+	}
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "nats" {
+		return nil, fmt.Errorf("unsupported url scheme %q", u.Scheme)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	if u.User != nil && s.User == "" {
+		s.User = u.User.Username()
+		s.Password, _ = u.User.Password()
+	}
+	if s.Subject == "" {
+		s.Subject = "waf.events.{type}"
+	}
+	opts := map[string]interface{}{"verbose": false, "pedantic": false, "name": "waf-lya", "lang": "go"}
+	if s.User != "" {
+		opts["user"], opts["pass"] = s.User, s.Password
+	}
+	if s.Token != "" {
+		opts["auth_token"] = s.Token
+	}
+	connect, err := json.Marshal(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &natsSink{addr: addr, subject: s.Subject, connect: connect}, nil
+}
+
+func (s *natsSink) HandleEvent(ev SecurityEvent) {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	subject := strings.NewReplacer("{type}", ev.Type, "{module}", ev.Module, "{severity}", ev.Severity).Replace(s.subject)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for attempt := 0; attempt < 2; attempt++ {
+		if s.conn == nil {
+			if err := s.dial(); err != nil {
+				log.Printf("[WAF] Ошибка подключения к NATS %s: %v", s.addr, err)
+				return
+			}
+		}
+		s.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		fmt.Fprintf(s.w, "PUB %s %d\r\n", subject, len(payload))
+		s.w.Write(payload)
+		s.w.WriteString("\r\n")
+		if err := s.w.Flush(); err == nil {
+			return
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	log.Printf("[WAF] Не удалось отправить событие в NATS %s", s.addr)
+}
+
+// dial подключается к серверу: INFO, CONNECT и чтение входящих PING в отдельной горутине
+func (s *natsSink) dial() error {
+	conn, err := net.DialTimeout("tcp", s.addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	info, err := r.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if !strings.HasPrefix(info, "INFO ") {
+		conn.Close()
+		return errors.New("unexpected greeting " + strings.TrimSpace(info))
+	}
+	conn.SetReadDeadline(time.Time{})
+	w := bufio.NewWriter(conn)
+	fmt.Fprintf(w, "CONNECT %s\r\n", s.connect)
+	if err := w.Flush(); err != nil {
+		conn.Close()
+		return err
+	}
+	s.conn, s.w = conn, w
+	go s.readLoop(conn, r)
+	return nil
+}
+
+// readLoop отвечает на PING сервера и записывает ошибки протокола
+func (s *natsSink) readLoop(conn net.Conn, r *bufio.Reader) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			s.mu.Lock()
+			if s.conn == conn {
+				s.conn.Close()
+				s.conn = nil
+			}
+			s.mu.Unlock()
+			return
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PING":
+			s.mu.Lock()
+			if s.conn == conn {
+				s.w.WriteString("PONG\r\n")
+				s.w.Flush()
+			}
+			s.mu.Unlock()
+		case strings.HasPrefix(line, "-ERR"):
+			log.Printf("[WAF] NATS %s: %s", s.addr, line)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package waf
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// EventSchemaVersion версия JSON-представления событий. Меняется только при несовместимых
+// изменениях (удаление или переименование поля, смена типа); новые поля добавляются
+// без смены версии
+const EventSchemaVersion = 1
+
+// EventJSON JSON-представление SecurityEvent, общее для всех получателей: webhook, NATS,
+// Kafka, syslog в формате JSON, поток и API панели администратора
+type EventJSON struct {
+	SchemaVersion int           `json:"schema_version"`
+	Time          time.Time     `json:"time"` // UTC, RFC 3339 с наносекундами
+	Type          string        `json:"type"` // detection, ban, account_lock, upstream, shadow, decision
+	Module        string        `json:"module"`
+	Tenant        string        `json:"tenant,omitempty"`
+	Severity      string        `json:"severity"` // info, warning, critical
+	Category      string        `json:"category"` // класс атаки или нарушения, см. eventCategories
+	RuleID        string        `json:"rule_id"`  // модуль[:правило или тип атаки], например signature:sqli, rules:no-old-api
+	Action        string        `json:"action,omitempty"`
+	Message       string        `json:"message"`
+	Client        *EventClient  `json:"client,omitempty"`
+	Request       *EventRequest `json:"request,omitempty"`
+	// Fields подробности модуля; стабильны только ключи rule, attack, param, payload
+	Fields map[string]interface{} `json:"fields,omitempty"`
+}
+
+// EventClient клиент, вызвавший событие
+type EventClient struct {
+	IP      string `json:"ip"`
+	Country string `json:"country,omitempty"` // ISO 3166-1, при настроенных базах GeoIP
+	City    string `json:"city,omitempty"`
+	ASN     uint64 `json:"asn,omitempty"`
+	ASOrg   string `json:"as_org,omitempty"`
+	Device  string `json:"device,omitempty"` // идентификатор устройства (модуль device)
+	Bot     string `json:"bot,omitempty"`    // подтвержденный поисковый робот (good_bots)
+}
+
+// EventRequest краткое описание запроса
+type EventRequest struct {
+	ID        string `json:"id,omitempty"`
+	Method    string `json:"method,omitempty"`
+	Host      string `json:"host,omitempty"`
+	Path      string `json:"path,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+}
+
+// eventCategories категории событий модулей; signature уточняет категорию типом атаки
+// (sqli, xss, path_traversal). Модули без категории относятся к policy
+var eventCategories = map[string]string{
+	"signature":             "injection",
+	"control_chars":         "injection",
+	"crlf":                  "injection",
+	"header_anomaly":        "protocol",
+	"host_header":           "protocol",
+	"content_type":          "protocol",
+	"tls":                   "protocol",
+	"tls_client":            "protocol",
+	"decompression":         "protocol",
+	"path_canonicalization": "protocol",
+	"rate_limit":            "automation",
+	"connection_limit":      "automation",
+	"scanner":               "automation",
+	"enumeration":           "automation",
+	"sequence":              "automation",
+	"context":               "automation",
+	"good_bots":             "automation",
+	"device":                "automation",
+	"login_protection":      "credential_attack",
+	"jwt":                   "access_control",
+	"introspection":         "access_control",
+	"csrf":                  "access_control",
+	"cors":                  "access_control",
+	"cookie_security":       "access_control",
+	"hotlink":               "access_control",
+	"openapi":               "schema",
+	"json_schema":           "schema",
+	"param_anomaly":         "anomaly",
+	"anomaly_model":         "anomaly",
+	"positive_model":        "anomaly",
+	"upload":                "upload",
+	"upstreams":             "availability",
+	"circuit_breaker":       "availability",
+}
+
+// attackIDs идентификаторы типов атак signature и crlf для rule_id и category
+var attackIDs = map[string]string{
+	"SQLi":         "sqli",
+	"XSS":          "xss",
+	"обхода путей": "path_traversal",
+	"CRLF":         "crlf",
+}
+
+// eventRule правило или тип атаки события; "" — модуль не уточняет
+func eventRule(ev SecurityEvent) string {
+	if rule, ok := ev.Fields["rule"].(string); ok && rule != "" {
+		return rule
+	}
+	if attack, ok := ev.Fields["attack"].(string); ok && attack != "" {
+		if id, ok := attackIDs[attack]; ok {
+			return id
+		}
+		return strings.ToLower(attack)
+	}
+	return ""
+}
+
+// JSON JSON-представление события версии EventSchemaVersion
+func (ev SecurityEvent) JSON() EventJSON {
+	out := EventJSON{
+		SchemaVersion: EventSchemaVersion,
+		Time:          ev.Time.UTC(),
+		Type:          ev.Type,
+		Module:        ev.Module,
+		Tenant:        ev.Tenant,
+		Severity:      ev.Severity,
+		Category:      "policy",
+		RuleID:        ev.Module,
+		Action:        ev.Action,
+		Message:       ev.Message,
+		Fields:        ev.Fields,
+	}
+	if c, ok := eventCategories[ev.Module]; ok {
+		out.Category = c
+	}
+	if rule := eventRule(ev); rule != "" {
+		out.RuleID += ":" + rule
+		if _, ok := ev.Fields["attack"].(string); ok && ev.Module == "signature" {
+			out.Category = rule
+		}
+	}
+	if ev.IP != "" {
+		out.Client = &EventClient{IP: ev.IP, Country: ev.Country, City: ev.City, ASN: ev.ASN, ASOrg: ev.ASOrg, Device: ev.Device, Bot: ev.Bot}
+	}
+	if ev.RequestID != "" || ev.Method != "" || ev.Path != "" {
+		out.Request = &EventRequest{ID: ev.RequestID, Method: ev.Method, Host: ev.Host, Path: ev.Path, UserAgent: ev.UserAgent}
+	}
+	return out
+}
+
+// MarshalJSON кодирует событие в формате EventJSON
+func (ev SecurityEvent) MarshalJSON() ([]byte, error) {
+	return json.Marshal(ev.JSON())
+}
+
+// UnmarshalJSON читает событие в формате EventJSON; category и rule_id вычисляются
+// заново из модуля и fields
+func (ev *SecurityEvent) UnmarshalJSON(data []byte) error {
+	var in EventJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	*ev = SecurityEvent{
+		Time:     in.Time,
+		Type:     in.Type,
+		Module:   in.Module,
+		Tenant:   in.Tenant,
+		Severity: in.Severity,
+		Action:   in.Action,
+		Message:  in.Message,
+		Fields:   in.Fields,
+	}
+	if c := in.Client; c != nil {
+		ev.IP, ev.Country, ev.City, ev.ASN, ev.ASOrg, ev.Device, ev.Bot = c.IP, c.Country, c.City, c.ASN, c.ASOrg, c.Device, c.Bot
+	}
+	if r := in.Request; r != nil {
+		ev.RequestID, ev.Method, ev.Host, ev.Path, ev.UserAgent = r.ID, r.Method, r.Host, r.Path, r.UserAgent
+	}
+	return nil
+}
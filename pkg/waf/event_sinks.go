@@ -0,0 +1,297 @@
+package waf
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// decodeSettings разбирает секцию settings получателя; пустая секция допустима
+func decodeSettings(raw json.RawMessage, v interface{}) error {
+	if len(raw) == 0 {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// logSink пишет события в журнал в прежнем формате "[время] сообщение".
+// Баны не пишутся: причина бана уже описана событием обнаружения.
+type logSink struct {
+	includeBans bool
+}
+
+func newLogSink(raw json.RawMessage) (EventSink, error) {
+	var s struct {
+		IncludeBans bool `json:"include_bans"`
+	}
+	if err := decodeSettings(raw, &s); err != nil {
+		return nil, err
+	}
+	return &logSink{includeBans: s.IncludeBans}, nil
+}
+
+func (s *logSink) HandleEvent(ev SecurityEvent) {
+	if ev.Type == EventBan && !s.includeBans {
+		return
+	}
+	var tags strings.Builder
+	if ev.RequestID != "" {
+		fmt.Fprintf(&tags, " [request_id=%s]", ev.RequestID)
+	}
+	if ev.Country != "" || ev.ASN != 0 {
+		fmt.Fprintf(&tags, " [country=%s asn=%d]", ev.Country, ev.ASN)
+	}
+	log.Printf("[%s] %s%s", ev.Time.Format(time.RFC3339), ev.Message, tags.String())
+}
+
+// webhookSink отправляет каждое событие JSON-запросом POST
+type webhookSink struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+func newWebhookSink(raw json.RawMessage) (EventSink, error) {
+	var s struct {
+		URL       string `json:"url"`
+		Secret    string `json:"secret"` // передается как Authorization: Bearer
+		TimeoutMs int    `json:"timeout_ms"`
+	}
+	if err := decodeSettings(raw, &s); err != nil {
+		return nil, err
+	}
+	if s.URL == "" {
+		return nil, errors.New("url is required")
+	}
+	timeout := 5 * time.Second
+	if s.TimeoutMs > 0 {
+		timeout = time.Duration(s.TimeoutMs) * time.Millisecond
+	}
+	return &webhookSink{url: s.URL, secret: s.Secret, client: &http.Client{Timeout: timeout}}, nil
+}
+
+func (s *webhookSink) HandleEvent(ev SecurityEvent) {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("[WAF] Ошибка webhook событий: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.secret != "" {
+		req.Header.Set("Authorization", "Bearer "+s.secret)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		log.Printf("[WAF] Ошибка webhook событий: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("[WAF] Webhook событий вернул %s", resp.Status)
+	}
+}
+
+// metricsSink считает события и отдает счетчики в формате Prometheus на отдельном адресе
+type metricsSink struct {
+	mu     sync.Mutex
+	counts map[metricsKey]uint64
+}
+
+type metricsKey struct {
+	typ, module, severity, action, tenant string
+}
+
+func newMetricsSink(raw json.RawMessage) (EventSink, error) {
+	var s struct {
+		Addr string `json:"addr"`
+		Path string `json:"path"` // по умолчанию /metrics
+	}
+	if err := decodeSettings(raw, &s); err != nil {
+		return nil, err
+	}
+	if s.Addr == "" {
+		return nil, errors.New("addr is required")
+	}
+	if s.Path == "" {
+		s.Path = "/metrics"
+	}
+	m := &metricsSink{counts: make(map[metricsKey]uint64)}
+	ln, err := sockets.listenTCP("metrics", s.Addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(s.Path, m)
+	log.Printf("[WAF] Метрики событий: http://%s%s", ln.Addr(), s.Path)
+	go func() {
+		if err := http.Serve(ln, mux); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Printf("[WAF] Ошибка сервера метрик: %v", err)
+		}
+	}()
+	return m, nil
+}
+
+func (m *metricsSink) HandleEvent(ev SecurityEvent) {
+	m.mu.Lock()
+	m.counts[metricsKey{ev.Type, ev.Module, ev.Severity, ev.Action, ev.Tenant}]++
+	m.mu.Unlock()
+}
+
+func (m *metricsSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	keys := make([]metricsKey, 0, len(m.counts))
+	for k := range m.counts {
+		keys = append(keys, k)
+	}
+	counts := make(map[metricsKey]uint64, len(m.counts))
+	for k, v := range m.counts {
+		counts[k] = v
+	}
+	m.mu.Unlock()
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.typ != b.typ {
+			return a.typ < b.typ
+		}
+		if a.module != b.module {
+			return a.module < b.module
+		}
+		if a.severity != b.severity {
+			return a.severity < b.severity
+		}
+		if a.action != b.action {
+			return a.action < b.action
+		}
+		return a.tenant < b.tenant
+	})
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(w, "# HELP waf_security_events_total Security events by type, module, severity, action and tenant.")
+	fmt.Fprintln(w, "# TYPE waf_security_events_total counter")
+	for _, k := range keys {
+		fmt.Fprintf(w, "waf_security_events_total{type=%s,module=%s,severity=%s,action=%s,tenant=%s} %d\n",
+			strconv.Quote(k.typ), strconv.Quote(k.module), strconv.Quote(k.severity), strconv.Quote(k.action), strconv.Quote(k.tenant), counts[k])
+	}
+}
+
+// syslogSink передает события в SIEM по syslog (RFC 5424) в формате JSON или CEF
+type syslogSink struct {
+	network, addr string
+	tag           string
+	cef           bool
+	hostname      string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func newSyslogSink(raw json.RawMessage) (EventSink, error) {
+	var s struct {
+		Network string `json:"network"` // udp (по умолчанию), tcp, unix
+		Addr    string `json:"addr"`
+		Tag     string `json:"tag"`    // APP-NAME, по умолчанию waf
+		Format  string `json:"format"` // json (по умолчанию) или cef
+	}
+	if err := decodeSettings(raw, &s); err != nil {
+		return nil, err
+	}
+	if s.Addr == "" {
+		return nil, errors.New("addr is required")
+	}
+	if s.Network == "" {
+		s.Network = "udp"
+	}
+	if s.Tag == "" {
+		s.Tag = "waf"
+	}
+	if s.Format != "" && s.Format != "json" && s.Format != "cef" {
+		return nil, fmt.Errorf("unknown format %q", s.Format)
+	}
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "-"
+	}
+	return &syslogSink{network: s.Network, addr: s.Addr, tag: s.Tag, cef: s.Format == "cef", hostname: hostname}, nil
+}
+
+func (s *syslogSink) HandleEvent(ev SecurityEvent) {
+	var msg string
+	if s.cef {
+		msg = formatCEF(ev)
+	} else {
+		b, err := json.Marshal(ev)
+		if err != nil {
+			return
+		}
+		msg = string(b)
+	}
+	// facility local0; critical -> crit, warning -> warning, info -> info
+	sev := map[string]int{SeverityCritical: 2, SeverityWarning: 4, SeverityInfo: 6}[ev.Severity]
+	if sev == 0 {
+		sev = 4
+	}
+	line := fmt.Sprintf("<%d>1 %s %s %s - - - %s", 16*8+sev, ev.Time.UTC().Format(time.RFC3339Nano), s.hostname, s.tag, msg)
+	if s.network == "tcp" {
+		// Octet counting (RFC 6587): сообщение может содержать переводы строк
+		line = strconv.Itoa(len(line)) + " " + line
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for attempt := 0; attempt < 2; attempt++ {
+		if s.conn == nil {
+			conn, err := net.DialTimeout(s.network, s.addr, 5*time.Second)
+			if err != nil {
+				log.Printf("[WAF] Ошибка подключения к syslog %s: %v", s.addr, err)
+				return
+			}
+			s.conn = conn
+		}
+		s.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		if _, err := s.conn.Write([]byte(line)); err == nil {
+			return
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	log.Printf("[WAF] Не удалось отправить событие в syslog %s", s.addr)
+}
+
+// formatCEF форматирует событие в ArcSight CEF
+func formatCEF(ev SecurityEvent) string {
+	header := strings.NewReplacer(`\`, `\\`, `|`, `\|`)
+	ext := strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`)
+	sev := map[string]int{SeverityCritical: 9, SeverityWarning: 6, SeverityInfo: 3}[ev.Severity]
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "CEF:0|WAF-lya|WAF|1.0|%s.%s|%s|%d|rt=%d",
+		header.Replace(ev.Module), header.Replace(ev.Type), header.Replace(ev.Message), sev, ev.Time.UnixMilli())
+	if ev.IP != "" {
+		sb.WriteString(" src=" + ext.Replace(ev.IP))
+	}
+	if ev.Method != "" {
+		sb.WriteString(" requestMethod=" + ext.Replace(ev.Method))
+	}
+	if ev.Path != "" {
+		sb.WriteString(" request=" + ext.Replace(ev.Path))
+	}
+	if ev.Action != "" {
+		sb.WriteString(" act=" + ext.Replace(ev.Action))
+	}
+	return sb.String()
+}
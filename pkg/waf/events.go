@@ -0,0 +1,361 @@
+package waf
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Типы событий безопасности
+const (
+	EventDetection   = "detection"    // срабатывание модуля обнаружения
+	EventBan         = "ban"          // клиент заблокирован
+	EventAccountLock = "account_lock" // аккаунт заблокирован после неудачных входов
+	EventUpstream    = "upstream"     // изменение доступности бэкенда или circuit breaker
+	EventShadow      = "shadow"       // набор правил-кандидат заблокировал бы запрос
+	EventDecision    = "decision"     // движок решений применил действие по сумме срабатываний
+)
+
+// Уровни важности событий
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+var severityRank = map[string]int{SeverityInfo: 0, SeverityWarning: 1, SeverityCritical: 2}
+
+// SecurityEvent событие, которое модули публикуют в шину событий WAF.
+// Обнаружение отделено от оповещения: куда попадет событие, решают получатели шины.
+// В JSON событие кодируется в стабильном формате EventJSON.
+type SecurityEvent struct {
+	Time      time.Time
+	Type      string
+	Module    string
+	Tenant    string
+	Severity  string
+	IP        string
+	Method    string
+	Host      string
+	Path      string
+	UserAgent string
+	RequestID string
+	Country   string // ISO 3166-1, при настроенных базах GeoIP
+	City      string
+	ASN       uint64
+	ASOrg     string
+	Device    string // идентификатор устройства из проверенной cookie (device)
+	Bot       string // подтвержденный поисковый робот (good_bots)
+	Action    string // block, ban, throttle, challenge, delay, log
+	Message   string
+	Fields    map[string]interface{}
+
+	request *requestInfo // контекст анализа запроса, в котором накапливаются срабатывания
+}
+
+// EventSink получатель событий. HandleEvent вызывается из отдельной горутины получателя,
+// события приходят по порядку; медленный получатель не задерживает запросы.
+type EventSink interface {
+	HandleEvent(ev SecurityEvent)
+}
+
+// EventSinkFunc позволяет использовать функцию как получателя событий
+type EventSinkFunc func(ev SecurityEvent)
+
+func (f EventSinkFunc) HandleEvent(ev SecurityEvent) { f(ev) }
+
+// eventFilter отбирает события для получателя
+type eventFilter struct {
+	minSeverity int
+	types       map[string]bool // nil — все типы
+	modules     map[string]bool // nil — все модули
+	tenants     map[string]bool // nil — все арендаторы; "" — основной WAF
+}
+
+func (f eventFilter) match(ev SecurityEvent) bool {
+	if severityRank[ev.Severity] < f.minSeverity {
+		return false
+	}
+	if f.types != nil && !f.types[ev.Type] {
+		return false
+	}
+	if f.tenants != nil && !f.tenants[ev.Tenant] {
+		return false
+	}
+	return f.modules == nil || f.modules[ev.Module]
+}
+
+type eventSubscription struct {
+	name    string
+	sink    EventSink
+	filter  eventFilter
+	queue   chan SecurityEvent
+	dropped atomic.Uint64
+	config  bool // получатель из конфига; заменяется SetEvents
+}
+
+func (s *eventSubscription) run() {
+	for ev := range s.queue {
+		s.deliver(ev)
+	}
+}
+
+func (s *eventSubscription) deliver(ev SecurityEvent) {
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("[WAF] events %s: %v", s.name, p)
+		}
+	}()
+	s.sink.HandleEvent(ev)
+}
+
+// EventBus шина событий безопасности. У каждого получателя своя очередь;
+// при переполнении очереди событие для этого получателя отбрасывается.
+type EventBus struct {
+	mu     sync.RWMutex
+	subs   []*eventSubscription
+	buffer int
+
+	parent *EventBus // шина арендатора передает события в parent с полем Tenant
+	tenant string
+
+	tap func(SecurityEvent) // синхронный получатель для офлайн-анализа; задается до обработки запросов
+	geo *geoResolver        // nil — события не обогащаются данными GeoIP
+}
+
+// newEventBus создает шину с получателем-журналом
+func newEventBus() *EventBus {
+	b := &EventBus{buffer: 1024}
+	s := &eventSubscription{name: "log", sink: &logSink{}, queue: make(chan SecurityEvent, b.buffer), config: true}
+	go s.run()
+	b.subs = []*eventSubscription{s}
+	return b
+}
+
+// forTenant возвращает шину арендатора, отмечающую события его именем
+func (b *EventBus) forTenant(name string) *EventBus {
+	return &EventBus{parent: b, tenant: name}
+}
+
+// Subscribe подключает получателя ко всем событиям; возвращает функцию отключения.
+// На шине арендатора получатель видит только события этого арендатора.
+func (b *EventBus) Subscribe(name string, sink EventSink) (unsubscribe func()) {
+	return b.subscribe(name, sink, eventFilter{})
+}
+
+func (b *EventBus) subscribe(name string, sink EventSink, filter eventFilter) func() {
+	if b.parent != nil {
+		filter.tenants = map[string]bool{b.tenant: true}
+		return b.parent.subscribe(name, sink, filter)
+	}
+	s := &eventSubscription{name: name, sink: sink, filter: filter, queue: make(chan SecurityEvent, b.buffer)}
+	go s.run()
+	b.mu.Lock()
+	b.subs = append(b.subs, s)
+	b.mu.Unlock()
+	return func() { b.remove(s) }
+}
+
+func (b *EventBus) remove(s *eventSubscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, cur := range b.subs {
+		if cur == s {
+			b.subs = append(b.subs[:i:i], b.subs[i+1:]...)
+			close(s.queue)
+			return
+		}
+	}
+}
+
+// Publish передает событие подходящим получателям, не блокируясь
+func (b *EventBus) Publish(ev SecurityEvent) {
+	if b.parent != nil {
+		ev.Tenant = b.tenant
+		b.parent.Publish(ev)
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	if ev.Severity == "" {
+		ev.Severity = SeverityWarning
+	}
+	b.geo.enrich(&ev)
+	if b.tap != nil {
+		b.tap(ev)
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, s := range b.subs {
+		if !s.filter.match(ev) {
+			continue
+		}
+		select {
+		case s.queue <- ev:
+		default:
+			// Пишется на 1, 2, 4, 8... потерянном событии, чтобы не засорять журнал
+			if n := s.dropped.Add(1); n&(n-1) == 0 {
+				log.Printf("[WAF] events %s: очередь переполнена, потеряно событий: %d", s.name, n)
+			}
+		}
+	}
+}
+
+// replace атомарно заменяет получателей из конфига; подключенные через Subscribe остаются
+func (b *EventBus) replace(subs []*eventSubscription) {
+	b.mu.Lock()
+	var old []*eventSubscription
+	for _, s := range b.subs {
+		if s.config {
+			old = append(old, s)
+		} else {
+			subs = append(subs, s)
+		}
+	}
+	b.subs = subs
+	b.mu.Unlock()
+	for _, s := range old {
+		close(s.queue)
+	}
+}
+
+// EventSinkFactory создает получателя событий по секции settings конфигурации
+type EventSinkFactory func(settings json.RawMessage) (EventSink, error)
+
+var (
+	eventSinksMu sync.RWMutex
+	eventSinks   = map[string]EventSinkFactory{
+		"log":     newLogSink,
+		"webhook": newWebhookSink,
+		"metrics": newMetricsSink,
+		"syslog":  newSyslogSink,
+	}
+)
+
+// RegisterEventSink регистрирует тип получателя событий для секции events.sinks конфигурации
+func RegisterEventSink(typ string, factory EventSinkFactory) {
+	eventSinksMu.Lock()
+	defer eventSinksMu.Unlock()
+	if factory == nil {
+		panic("waf: RegisterEventSink factory is nil")
+	}
+	if _, dup := eventSinks[typ]; dup {
+		panic("waf: RegisterEventSink called twice for " + typ)
+	}
+	eventSinks[typ] = factory
+}
+
+// EventSinkTypes возвращает зарегистрированные типы получателей
+func EventSinkTypes() []string {
+	eventSinksMu.RLock()
+	defer eventSinksMu.RUnlock()
+	types := make([]string, 0, len(eventSinks))
+	for typ := range eventSinks {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Events возвращает шину событий WAF (для подключения своих получателей)
+func (w *WAF) Events() *EventBus {
+	return w.events
+}
+
+// SetEvents заменяет получателей событий на заданных в конфиге.
+// Без sinks события пишутся только в журнал.
+func (w *WAF) SetEvents(cfg EventsConfig) error {
+	if w.events.parent != nil {
+		return errors.New("events are configured on the main WAF, not on a tenant")
+	}
+	if cfg.Buffer > 0 {
+		w.events.buffer = cfg.Buffer
+	}
+	if len(cfg.Sinks) == 0 {
+		return nil
+	}
+	var subs []*eventSubscription
+	for i, sc := range cfg.Sinks {
+		name := sc.Name
+		if name == "" {
+			name = fmt.Sprintf("%s#%d", sc.Type, i+1)
+		}
+		eventSinksMu.RLock()
+		factory, ok := eventSinks[sc.Type]
+		eventSinksMu.RUnlock()
+		if !ok {
+			return fmt.Errorf("sink %s: unknown type %q", name, sc.Type)
+		}
+		filter := eventFilter{}
+		if sc.MinSeverity != "" {
+			rank, ok := severityRank[sc.MinSeverity]
+			if !ok {
+				return fmt.Errorf("sink %s: unknown severity %q", name, sc.MinSeverity)
+			}
+			filter.minSeverity = rank
+		}
+		if len(sc.Types) > 0 {
+			filter.types = toSet(sc.Types)
+		}
+		if len(sc.Modules) > 0 {
+			filter.modules = toSet(sc.Modules)
+		}
+		if len(sc.Tenants) > 0 {
+			filter.tenants = toSet(sc.Tenants)
+		}
+		sink, err := factory(sc.Settings)
+		if err != nil {
+			return fmt.Errorf("sink %s: %w", name, err)
+		}
+		subs = append(subs, &eventSubscription{name: name, sink: sink, filter: filter, queue: make(chan SecurityEvent, w.events.buffer), config: true})
+	}
+	for _, s := range subs {
+		go s.run()
+	}
+	w.events.replace(subs)
+	return nil
+}
+
+// emit публикует событие в шину WAF
+func (w *WAF) emit(ev SecurityEvent) {
+	if ev.request != nil {
+		ev.Device, ev.Bot = ev.request.device, ev.request.bot
+		if ev.Type == EventDetection {
+			ev.request.addFinding(ev)
+		}
+		ev.request = nil
+	}
+	if w == nil {
+		return
+	}
+	w.reputation.observe(ev)
+	if w.events == nil {
+		return
+	}
+	w.events.Publish(ev)
+}
+
+// requestEvent событие обнаружения по запросу клиента
+func requestEvent(r *http.Request, ip, module, severity, action, message string) SecurityEvent {
+	return SecurityEvent{
+		Type:      EventDetection,
+		Module:    module,
+		Severity:  severity,
+		IP:        ip,
+		Method:    r.Method,
+		Host:      r.Host,
+		Path:      r.URL.Path,
+		UserAgent: r.UserAgent(),
+		RequestID: requestID(r),
+		request:   requestInfoOf(r),
+		Action:    action,
+		Message:   message,
+	}
+}
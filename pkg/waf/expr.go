@@ -0,0 +1,721 @@
+package waf
+
+import (
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Язык условий правил — подмножество CEL:
+//
+//	request.path.startsWith("/admin") && !ip_in(client.ip, internal_cidrs)
+//	request.method in ["PUT", "DELETE"] && request.headers["x-api-key"] == ""
+//	client.risk >= 5 || request.query["debug"].matches("^(1|true)$")
+//
+// Операторы: || && ! == != < <= > >= in, литералы строк, чисел, true/false и списков.
+// Методы строк: startsWith, endsWith, contains, matches, lowerAscii, size.
+// Функции: ip_in(ip, список IP/CIDR), size(x), lower(s).
+// Отсутствующий заголовок или параметр равен пустой строке.
+
+// exprNode узел разобранного выражения
+type exprNode interface {
+	eval(env map[string]interface{}) (interface{}, error)
+}
+
+// exprList именованный список из конфигурации; IP и CIDR разобраны заранее для ip_in
+type exprList struct {
+	values   []string
+	prefixes []netip.Prefix
+}
+
+func newExprList(values []string) *exprList {
+	l := &exprList{values: values}
+	for _, v := range values {
+		if p, err := parsePrefix(v); err == nil {
+			l.prefixes = append(l.prefixes, p)
+		}
+	}
+	return l
+}
+
+// parsePrefix разбирает CIDR или одиночный IP
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		return p.Masked(), err
+	}
+	a, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(a, a.BitLen()), nil
+}
+
+// compileExpr разбирает выражение; vars — имена доступных переменных
+func compileExpr(src string, vars map[string]bool) (exprNode, error) {
+	toks, err := lexExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{toks: toks, vars: vars}
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+	}
+	return n, nil
+}
+
+// --- лексер ---
+
+const (
+	tokEOF = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokOp
+)
+
+type exprToken struct {
+	kind int
+	text string
+	pos  int
+}
+
+func lexExpr(src string) ([]exprToken, error) {
+	var toks []exprToken
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '_' || unicode.IsLetter(rune(c)):
+			start := i
+			for i < len(src) && (src[i] == '_' || unicode.IsLetter(rune(src[i])) || unicode.IsDigit(rune(src[i]))) {
+				i++
+			}
+			toks = append(toks, exprToken{tokIdent, src[start:i], start})
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(src) && (src[i] >= '0' && src[i] <= '9' || src[i] == '.') {
+				i++
+			}
+			toks = append(toks, exprToken{tokNumber, src[start:i], start})
+		case c == '"' || c == '\'':
+			start := i
+			var sb strings.Builder
+			i++
+			for {
+				if i >= len(src) {
+					return nil, fmt.Errorf("unterminated string at %d", start)
+				}
+				if src[i] == c {
+					i++
+					break
+				}
+				if src[i] == '\\' && i+1 < len(src) {
+					i++
+					switch src[i] {
+					case 'n':
+						sb.WriteByte('\n')
+					case 't':
+						sb.WriteByte('\t')
+					default:
+						sb.WriteByte(src[i])
+					}
+					i++
+					continue
+				}
+				sb.WriteByte(src[i])
+				i++
+			}
+			toks = append(toks, exprToken{tokString, sb.String(), start})
+		default:
+			two := ""
+			if i+1 < len(src) {
+				two = src[i : i+2]
+			}
+			switch two {
+			case "&&", "||", "==", "!=", "<=", ">=":
+				toks = append(toks, exprToken{tokOp, two, i})
+				i += 2
+				continue
+			}
+			if strings.IndexByte("!<>()[].,", c) < 0 {
+				return nil, fmt.Errorf("unexpected character %q at %d", c, i)
+			}
+			toks = append(toks, exprToken{tokOp, string(c), i})
+			i++
+		}
+	}
+	return append(toks, exprToken{kind: tokEOF, pos: len(src)}), nil
+}
+
+// --- парсер ---
+
+type exprParser struct {
+	toks []exprToken
+	pos  int
+	vars map[string]bool
+}
+
+func (p *exprParser) peek() exprToken { return p.toks[p.pos] }
+
+func (p *exprParser) next() exprToken {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *exprParser) accept(op string) bool {
+	if t := p.peek(); t.kind == tokOp && t.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) expect(op string) error {
+	if !p.accept(op) {
+		t := p.peek()
+		return fmt.Errorf("expected %q at %d", op, t.pos)
+	}
+	return nil
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryNode{op: "||", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryNode{op: "&&", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.accept("!") {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{x: x}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *exprParser) parseCompare() (exprNode, error) {
+	l, err := p.parsePostfix()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	op := ""
+	switch {
+	case t.kind == tokOp && (t.text == "==" || t.text == "!=" || t.text == "<" || t.text == "<=" || t.text == ">" || t.text == ">="):
+		op = t.text
+	case t.kind == tokIdent && t.text == "in":
+		op = "in"
+	default:
+		return l, nil
+	}
+	p.next()
+	r, err := p.parsePostfix()
+	if err != nil {
+		return nil, err
+	}
+	return &binaryNode{op: op, l: l, r: r}, nil
+}
+
+func (p *exprParser) parsePostfix() (exprNode, error) {
+	x, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.accept("."):
+			t := p.next()
+			if t.kind != tokIdent {
+				return nil, fmt.Errorf("expected field name at %d", t.pos)
+			}
+			if p.accept("(") {
+				args, err := p.parseArgs(")")
+				if err != nil {
+					return nil, err
+				}
+				x, err = newMethodNode(x, t.text, args)
+				if err != nil {
+					return nil, fmt.Errorf("%v at %d", err, t.pos)
+				}
+			} else {
+				x = &fieldNode{x: x, name: t.text}
+			}
+		case p.accept("["):
+			idx, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			x = &indexNode{x: x, idx: idx}
+		default:
+			return x, nil
+		}
+	}
+}
+
+func (p *exprParser) parseArgs(closing string) ([]exprNode, error) {
+	var args []exprNode
+	if p.accept(closing) {
+		return args, nil
+	}
+	for {
+		a, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, a)
+		if p.accept(closing) {
+			return args, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number %q at %d", t.text, t.pos)
+		}
+		return &litNode{v: f}, nil
+	case tokString:
+		return &litNode{v: t.text}, nil
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return &litNode{v: true}, nil
+		case "false":
+			return &litNode{v: false}, nil
+		}
+		if p.accept("(") {
+			args, err := p.parseArgs(")")
+			if err != nil {
+				return nil, err
+			}
+			n, err := newCallNode(t.text, args)
+			if err != nil {
+				return nil, fmt.Errorf("%v at %d", err, t.pos)
+			}
+			return n, nil
+		}
+		if !p.vars[t.text] {
+			return nil, fmt.Errorf("unknown identifier %q at %d", t.text, t.pos)
+		}
+		return &identNode{name: t.text}, nil
+	case tokOp:
+		switch t.text {
+		case "(":
+			x, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return x, nil
+		case "[":
+			elems, err := p.parseArgs("]")
+			if err != nil {
+				return nil, err
+			}
+			return &listNode{elems: elems}, nil
+		}
+	case tokEOF:
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+}
+
+// --- узлы ---
+
+type litNode struct{ v interface{} }
+
+func (n *litNode) eval(map[string]interface{}) (interface{}, error) { return n.v, nil }
+
+type identNode struct{ name string }
+
+func (n *identNode) eval(env map[string]interface{}) (interface{}, error) { return env[n.name], nil }
+
+type listNode struct{ elems []exprNode }
+
+func (n *listNode) eval(env map[string]interface{}) (interface{}, error) {
+	out := make([]interface{}, len(n.elems))
+	for i, e := range n.elems {
+		v, err := e.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+type fieldNode struct {
+	x    exprNode
+	name string
+}
+
+func (n *fieldNode) eval(env map[string]interface{}) (interface{}, error) {
+	x, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	return lookup(x, n.name)
+}
+
+type indexNode struct{ x, idx exprNode }
+
+func (n *indexNode) eval(env map[string]interface{}) (interface{}, error) {
+	x, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := n.idx.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if key, ok := idx.(string); ok {
+		return lookup(x, key)
+	}
+	i, ok := idx.(float64)
+	if !ok {
+		return nil, fmt.Errorf("invalid index %v", idx)
+	}
+	switch l := x.(type) {
+	case []interface{}:
+		if int(i) >= 0 && int(i) < len(l) {
+			return l[int(i)], nil
+		}
+	case *exprList:
+		if int(i) >= 0 && int(i) < len(l.values) {
+			return l.values[int(i)], nil
+		}
+	}
+	return nil, fmt.Errorf("index %v out of range", idx)
+}
+
+// lookup поле объекта или значение карты; отсутствующий ключ — пустая строка
+func lookup(x interface{}, key string) (interface{}, error) {
+	switch m := x.(type) {
+	case map[string]interface{}:
+		if v, ok := m[key]; ok {
+			return v, nil
+		}
+		return nil, fmt.Errorf("no field %q", key)
+	case map[string]string:
+		return m[key], nil
+	}
+	return nil, fmt.Errorf("cannot access %q of %T", key, x)
+}
+
+type notNode struct{ x exprNode }
+
+func (n *notNode) eval(env map[string]interface{}) (interface{}, error) {
+	v, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return nil, fmt.Errorf("! expects bool, got %T", v)
+	}
+	return !b, nil
+}
+
+type binaryNode struct {
+	op   string
+	l, r exprNode
+}
+
+func (n *binaryNode) eval(env map[string]interface{}) (interface{}, error) {
+	l, err := n.l.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "&&" || n.op == "||" {
+		lb, ok := l.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s expects bool, got %T", n.op, l)
+		}
+		if (n.op == "&&" && !lb) || (n.op == "||" && lb) {
+			return lb, nil
+		}
+		r, err := n.r.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		rb, ok := r.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s expects bool, got %T", n.op, r)
+		}
+		return rb, nil
+	}
+
+	r, err := n.r.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return l == r, nil
+	case "!=":
+		return l != r, nil
+	case "in":
+		return contains(r, l)
+	}
+	switch lv := l.(type) {
+	case float64:
+		rv, ok := r.(float64)
+		if !ok {
+			return nil, fmt.Errorf("cannot compare number with %T", r)
+		}
+		return compareOrdered(n.op, lv, rv), nil
+	case string:
+		rv, ok := r.(string)
+		if !ok {
+			return nil, fmt.Errorf("cannot compare string with %T", r)
+		}
+		return compareOrdered(n.op, lv, rv), nil
+	}
+	return nil, fmt.Errorf("cannot compare %T", l)
+}
+
+func compareOrdered[T float64 | string](op string, l, r T) bool {
+	switch op {
+	case "<":
+		return l < r
+	case "<=":
+		return l <= r
+	case ">":
+		return l > r
+	}
+	return l >= r
+}
+
+// contains реализует оператор in для списков и ключей карт
+func contains(coll, v interface{}) (bool, error) {
+	switch c := coll.(type) {
+	case []interface{}:
+		for _, e := range c {
+			if e == v {
+				return true, nil
+			}
+		}
+		return false, nil
+	case *exprList:
+		s, _ := v.(string)
+		for _, e := range c.values {
+			if e == s {
+				return true, nil
+			}
+		}
+		return false, nil
+	case map[string]string:
+		s, _ := v.(string)
+		_, ok := c[s]
+		return ok, nil
+	}
+	return false, fmt.Errorf("in expects list or map, got %T", coll)
+}
+
+// methodNode метод строки; регулярное выражение matches с литералом компилируется заранее
+type methodNode struct {
+	recv exprNode
+	name string
+	args []exprNode
+	re   *regexp.Regexp
+}
+
+func newMethodNode(recv exprNode, name string, args []exprNode) (exprNode, error) {
+	n := &methodNode{recv: recv, name: name, args: args}
+	want := 1
+	switch name {
+	case "startsWith", "endsWith", "contains":
+	case "matches":
+		if len(args) != 1 {
+			break
+		}
+		if lit, ok := args[0].(*litNode); ok {
+			pattern, ok := lit.v.(string)
+			if !ok {
+				return nil, fmt.Errorf("matches expects a string pattern")
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, err
+			}
+			n.re = re
+		}
+	case "lowerAscii", "size":
+		want = 0
+	default:
+		return nil, fmt.Errorf("unknown method %q", name)
+	}
+	if len(args) != want {
+		return nil, fmt.Errorf("%s expects %d argument(s)", name, want)
+	}
+	return n, nil
+}
+
+func (n *methodNode) eval(env map[string]interface{}) (interface{}, error) {
+	rv, err := n.recv.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if n.name == "size" {
+		return size(rv)
+	}
+	s, ok := rv.(string)
+	if !ok {
+		return nil, fmt.Errorf("%s expects string receiver, got %T", n.name, rv)
+	}
+	if n.name == "lowerAscii" {
+		return strings.ToLower(s), nil
+	}
+	av, err := n.args[0].eval(env)
+	if err != nil {
+		return nil, err
+	}
+	arg, ok := av.(string)
+	if !ok {
+		return nil, fmt.Errorf("%s expects string argument, got %T", n.name, av)
+	}
+	switch n.name {
+	case "startsWith":
+		return strings.HasPrefix(s, arg), nil
+	case "endsWith":
+		return strings.HasSuffix(s, arg), nil
+	case "contains":
+		return strings.Contains(s, arg), nil
+	}
+	re := n.re
+	if re == nil {
+		if re, err = regexp.Compile(arg); err != nil {
+			return nil, err
+		}
+	}
+	return re.MatchString(s), nil
+}
+
+func size(v interface{}) (interface{}, error) {
+	switch x := v.(type) {
+	case string:
+		return float64(len(x)), nil
+	case []interface{}:
+		return float64(len(x)), nil
+	case *exprList:
+		return float64(len(x.values)), nil
+	case map[string]string:
+		return float64(len(x)), nil
+	}
+	return nil, fmt.Errorf("size of %T", v)
+}
+
+type callNode struct {
+	fn   string
+	args []exprNode
+}
+
+func newCallNode(fn string, args []exprNode) (exprNode, error) {
+	want := map[string]int{"ip_in": 2, "size": 1, "lower": 1}
+	n, ok := want[fn]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q", fn)
+	}
+	if len(args) != n {
+		return nil, fmt.Errorf("%s expects %d argument(s)", fn, n)
+	}
+	return &callNode{fn: fn, args: args}, nil
+}
+
+func (n *callNode) eval(env map[string]interface{}) (interface{}, error) {
+	args := make([]interface{}, len(n.args))
+	for i, a := range n.args {
+		v, err := a.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	switch n.fn {
+	case "size":
+		return size(args[0])
+	case "lower":
+		s, _ := args[0].(string)
+		return strings.ToLower(s), nil
+	}
+
+	// ip_in(ip, список)
+	s, _ := args[0].(string)
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return false, nil
+	}
+	addr = addr.Unmap()
+	var prefixes []netip.Prefix
+	switch l := args[1].(type) {
+	case *exprList:
+		prefixes = l.prefixes
+	case []interface{}:
+		for _, e := range l {
+			if es, ok := e.(string); ok {
+				if p, err := parsePrefix(es); err == nil {
+					prefixes = append(prefixes, p)
+				}
+			}
+		}
+	default:
+		return nil, fmt.Errorf("ip_in expects a list, got %T", args[1])
+	}
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,128 @@
+//go:build extauthz
+
+package waf
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// extAuthzSupported WAF собран с поддержкой Envoy ext_authz
+const extAuthzSupported = true
+
+// extAuthzServer реализует envoy.service.auth.v3.Authorization поверх цепи модулей WAF
+type extAuthzServer struct {
+	authv3.UnimplementedAuthorizationServer
+	waf     *WAF
+	maxBody int
+}
+
+// serveExtAuthz запускает gRPC сервер внешней авторизации
+func serveExtAuthz(w *WAF, cfg ExtAuthzConfig) error {
+	ln, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		return err
+	}
+	srv := &extAuthzServer{waf: w, maxBody: 64 << 10}
+	if cfg.MaxBodyBytes > 0 {
+		srv.maxBody = cfg.MaxBodyBytes
+	}
+	gs := grpc.NewServer()
+	authv3.RegisterAuthorizationServer(gs, srv)
+	return gs.Serve(ln)
+}
+
+// Check проверяет запрос, описанный Envoy, и возвращает разрешение или готовый ответ отказа
+func (s *extAuthzServer) Check(ctx context.Context, req *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	r, err := s.httpRequest(ctx, req)
+	if err != nil {
+		return deniedResponse(http.StatusBadRequest, nil, []byte("Bad Request")), nil
+	}
+
+	v := s.waf.authorize(r)
+	if !v.allowed {
+		return deniedResponse(v.status, v.header, v.body), nil
+	}
+	ok := &authv3.OkHttpResponse{HeadersToRemove: v.removeHeaders}
+	for k, vals := range v.setHeaders {
+		ok.Headers = append(ok.Headers, &corev3.HeaderValueOption{
+			Header: &corev3.HeaderValue{Key: k, Value: strings.Join(vals, ", ")},
+		})
+	}
+	return &authv3.CheckResponse{
+		Status:       &rpcstatus.Status{Code: int32(codes.OK)},
+		HttpResponse: &authv3.CheckResponse_OkResponse{OkResponse: ok},
+	}, nil
+}
+
+// httpRequest восстанавливает http.Request из атрибутов CheckRequest
+func (s *extAuthzServer) httpRequest(ctx context.Context, req *authv3.CheckRequest) (*http.Request, error) {
+	attrs := req.GetAttributes().GetRequest().GetHttp()
+	u, err := url.ParseRequestURI(attrs.GetPath())
+	if err != nil {
+		return nil, err
+	}
+	u.Host = attrs.GetHost()
+	u.Scheme = attrs.GetScheme()
+	if u.Scheme == "" {
+		u.Scheme = "http"
+	}
+
+	body := attrs.GetRawBody()
+	if len(body) == 0 {
+		body = []byte(attrs.GetBody())
+	}
+	if len(body) > s.maxBody {
+		body = body[:s.maxBody]
+	}
+
+	r, err := http.NewRequestWithContext(ctx, attrs.GetMethod(), u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	r.RequestURI = attrs.GetPath()
+	r.Host = attrs.GetHost()
+	for k, v := range attrs.GetHeaders() {
+		if strings.HasPrefix(k, ":") {
+			continue // псевдозаголовки HTTP/2 уже разобраны в метод, путь и host
+		}
+		r.Header.Set(k, v)
+	}
+	r.ContentLength = int64(len(body))
+
+	if sa := req.GetAttributes().GetSource().GetAddress().GetSocketAddress(); sa != nil {
+		r.RemoteAddr = net.JoinHostPort(sa.GetAddress(), strconv.Itoa(int(sa.GetPortValue())))
+	}
+	return r, nil
+}
+
+// deniedResponse ответ отказа, который Envoy отдает клиенту без обращения к бэкенду
+func deniedResponse(status int, header http.Header, body []byte) *authv3.CheckResponse {
+	denied := &authv3.DeniedHttpResponse{
+		Status: &typev3.HttpStatus{Code: typev3.StatusCode(status)},
+		Body:   string(body),
+	}
+	for k, vals := range header {
+		for _, v := range vals {
+			denied.Headers = append(denied.Headers, &corev3.HeaderValueOption{
+				Header: &corev3.HeaderValue{Key: k, Value: v},
+			})
+		}
+	}
+	return &authv3.CheckResponse{
+		Status:       &rpcstatus.Status{Code: int32(codes.PermissionDenied)},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{DeniedResponse: denied},
+	}
+}
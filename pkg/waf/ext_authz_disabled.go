@@ -0,0 +1,12 @@
+//go:build !extauthz
+
+package waf
+
+import "errors"
+
+// extAuthzSupported WAF собран без поддержки Envoy ext_authz (нужен тег сборки extauthz)
+const extAuthzSupported = false
+
+func serveExtAuthz(*WAF, ExtAuthzConfig) error {
+	return errors.New("ext_authz support is not compiled in (build with -tags extauthz)")
+}
@@ -0,0 +1,116 @@
+package waf
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+func init() {
+	eventSinks["fail2ban"] = newFail2banSink
+}
+
+// fail2banTime формат времени строки; совпадает с datepattern фильтра deploy/fail2ban
+const fail2banTime = "2006-01-02T15:04:05-0700"
+
+// fail2banSink пишет события с IP клиента в файл построчно для фильтра fail2ban:
+//
+//	2026-10-16T05:55:57+0300 waf detection from 203.0.113.7 rule=signature:sqli severity=critical action=block
+//
+// Адрес клиента стоит до полей, которые задает клиент, поэтому подделать его путем
+// или заголовком нельзя. Файл открывается заново, если его переместил logrotate
+type fail2banSink struct {
+	path string
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+func newFail2banSink(raw json.RawMessage) (EventSink, error) {
+	var s struct {
+		Path string `json:"path"`
+	}
+	if err := decodeSettings(raw, &s); err != nil {
+		return nil, err
+	}
+	if s.Path == "" {
+		return nil, errors.New("path is required")
+	}
+	f, err := os.OpenFile(s.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return nil, err
+	}
+	return &fail2banSink{path: s.Path, f: f}, nil
+}
+
+func (s *fail2banSink) HandleEvent(ev SecurityEvent) {
+	addr, err := netip.ParseAddr(ev.IP)
+	if err != nil {
+		return
+	}
+	line := formatFail2ban(ev, addr.Unmap())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reopen(); err != nil {
+		log.Printf("[WAF] fail2ban: %v", err)
+		return
+	}
+	if _, err := s.f.WriteString(line); err != nil {
+		log.Printf("[WAF] fail2ban: ошибка записи в %s: %v", s.path, err)
+	}
+}
+
+// reopen открывает файл заново, если по пути лежит уже другой файл или его нет
+func (s *fail2banSink) reopen() error {
+	cur, err := s.f.Stat()
+	if err != nil {
+		return err
+	}
+	if st, err := os.Stat(s.path); err == nil && os.SameFile(cur, st) {
+		return nil
+	}
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+	s.f.Close()
+	s.f = f
+	return nil
+}
+
+// formatFail2ban строка события; значения без пробелов пишутся как есть, остальные в кавычках
+func formatFail2ban(ev SecurityEvent, addr netip.Addr) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s waf %s from %s", ev.Time.Format(fail2banTime), fail2banValue(ev.Type), addr)
+	j := ev.JSON()
+	for _, kv := range [][2]string{
+		{"rule", j.RuleID},
+		{"severity", ev.Severity},
+		{"action", ev.Action},
+		{"tenant", ev.Tenant},
+		{"request_id", ev.RequestID},
+		{"method", ev.Method},
+		{"host", ev.Host},
+		{"path", ev.Path},
+	} {
+		if kv[1] != "" {
+			sb.WriteString(" " + kv[0] + "=" + fail2banValue(kv[1]))
+		}
+	}
+	sb.WriteByte('\n')
+	return sb.String()
+}
+
+func fail2banValue(v string) string {
+	if strings.ContainsFunc(v, func(r rune) bool { return r <= ' ' || r == '"' || r == '\\' || r == 0x7f }) {
+		return strconv.Quote(v)
+	}
+	return v
+}
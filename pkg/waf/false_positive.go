@@ -0,0 +1,214 @@
+package waf
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	adminMaxDetections     = 10000 // срабатываний, которые можно отметить как ложные по идентификатору запроса
+	adminMaxFalsePositives = 1000  // последних отметок в API
+)
+
+// exclusion скомпилированное исключение ложных срабатываний
+type exclusion struct {
+	module, rule, param string
+	path                *routePattern
+}
+
+func compileExclusions(cfgs []ExclusionConfig) []exclusion {
+	var out []exclusion
+	for _, c := range cfgs {
+		if c.Module == "" {
+			continue
+		}
+		e := exclusion{module: c.Module, rule: c.Rule, param: c.Param}
+		if c.Path != "" {
+			p := compileRoutePattern(c.Path)
+			e.path = &p
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+func (e *exclusion) match(ev SecurityEvent) bool {
+	if e.module != ev.Module {
+		return false
+	}
+	if e.rule != "" && e.rule != detectionRule(ev) {
+		return false
+	}
+	if e.param != "" {
+		if p, _ := ev.Fields["param"].(string); p != e.param {
+			return false
+		}
+	}
+	if e.path != nil {
+		if _, ok := e.path.match(ev.Path); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// detectionRule правило или тип атаки срабатывания
+func detectionRule(ev SecurityEvent) string {
+	if rule, ok := ev.Fields["rule"].(string); ok {
+		return rule
+	}
+	attack, _ := ev.Fields["attack"].(string)
+	return attack
+}
+
+// excluded проверяет, подпадает ли срабатывание под исключения конфигурации
+func (w *WAF) excluded(ev SecurityEvent) bool {
+	w.mu.RLock()
+	exclusions := w.exclusions
+	w.mu.RUnlock()
+	for i := range exclusions {
+		if exclusions[i].match(ev) {
+			return true
+		}
+	}
+	return false
+}
+
+// FalsePositive отметка ложного срабатывания
+type FalsePositive struct {
+	Time      time.Time        `json:"time"`
+	Actor     string           `json:"actor"`
+	RequestID string           `json:"request_id"`
+	Detection SecurityEvent    `json:"detection"`
+	Note      string           `json:"note,omitempty"`
+	Exclusion *ExclusionConfig `json:"exclusion,omitempty"` // исключение, добавленное в конфигурацию
+}
+
+// falsePositiveRate доля ложных срабатываний правила
+type falsePositiveRate struct {
+	Name           string  `json:"name"`
+	Hits           int     `json:"hits"`
+	FalsePositives int     `json:"false_positives"`
+	Rate           float64 `json:"rate"`
+}
+
+// rememberDetection сохраняет срабатывание для поиска по идентификатору запроса; вызывается под s.mu
+func (s *adminStats) rememberDetection(ev SecurityEvent) {
+	if ev.RequestID == "" {
+		return
+	}
+	if _, ok := s.byRequest[ev.RequestID]; !ok {
+		if len(s.requestOrder) >= adminMaxDetections {
+			delete(s.byRequest, s.requestOrder[0])
+			s.requestOrder = s.requestOrder[1:]
+		}
+		s.requestOrder = append(s.requestOrder, ev.RequestID)
+	}
+	s.byRequest[ev.RequestID] = append(s.byRequest[ev.RequestID], ev)
+}
+
+// falsePositiveRates доли ложных срабатываний по правилам, начиная с наибольшей
+func (s *adminStats) falsePositiveRates() []falsePositiveRate {
+	out := make([]falsePositiveRate, 0, len(s.falseHits))
+	for name, n := range s.falseHits {
+		hits := max(s.hits[name], n)
+		out = append(out, falsePositiveRate{Name: name, Hits: hits, FalsePositives: n, Rate: float64(n) / float64(hits)})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Rate != out[j].Rate {
+			return out[i].Rate > out[j].Rate
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+// adminFalsePositive отмечает срабатывание как ложное:
+// {"request_id": "...", "module": "signature", "exclude": true, "note": "..."}.
+// С exclude в конфигурацию добавляется узкое исключение: модуль, правило, шаблон пути и параметр.
+func (w *WAF) adminFalsePositive(rw http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RequestID string `json:"request_id"`
+		Module    string `json:"module"` // нужен, если у запроса несколько срабатываний
+		Exclude   bool   `json:"exclude"`
+		Note      string `json:"note"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(rw, r.Body, 4096)).Decode(&req); err != nil || req.RequestID == "" {
+		writeJSON(rw, http.StatusBadRequest, map[string]string{"error": "request_id is required"})
+		return
+	}
+	s := w.stats
+	s.mu.Lock()
+	var matched []SecurityEvent
+	for _, ev := range s.byRequest[req.RequestID] {
+		if req.Module == "" || ev.Module == req.Module {
+			matched = append(matched, ev)
+		}
+	}
+	s.mu.Unlock()
+	switch {
+	case len(matched) == 0:
+		writeJSON(rw, http.StatusNotFound, map[string]string{"error": "no detection for this request_id"})
+		return
+	case len(matched) > 1:
+		writeJSON(rw, http.StatusConflict, map[string]string{"error": "several detections for this request_id, set module"})
+		return
+	}
+	ev := matched[0]
+	fp := FalsePositive{Time: time.Now(), Actor: adminActor(r), RequestID: req.RequestID, Detection: ev, Note: req.Note}
+
+	if req.Exclude {
+		if ev.Tenant != "" {
+			writeJSON(rw, http.StatusUnprocessableEntity, map[string]string{"error": "exclusions for tenant detections are added to the tenant config"})
+			return
+		}
+		ex := ExclusionConfig{Module: ev.Module, Rule: detectionRule(ev), Path: pathTemplate(ev.Path), Comment: "false positive " + req.RequestID}
+		ex.Param, _ = ev.Fields["param"].(string)
+		before := w.config()
+		cfg, err := mergeConfig(before, nil)
+		if err == nil {
+			known := false
+			for _, e := range cfg.Exclusions {
+				known = known || (e.Module == ex.Module && e.Rule == ex.Rule && e.Path == ex.Path && e.Param == ex.Param)
+			}
+			if !known {
+				cfg.Exclusions = append(cfg.Exclusions, ex)
+				err = w.reload(cfg, "false_positive")
+			}
+		}
+		if err != nil {
+			writeJSON(rw, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+			return
+		}
+		fp.Exclusion = &ex
+	}
+
+	name := ev.Module
+	if rule, ok := ev.Fields["rule"].(string); ok {
+		name += ":" + rule
+	}
+	s.mu.Lock()
+	s.falseHits[name]++
+	s.falsePositives = append(s.falsePositives, fp)
+	if len(s.falsePositives) > adminMaxFalsePositives {
+		s.falsePositives = s.falsePositives[len(s.falsePositives)-adminMaxFalsePositives:]
+	}
+	s.mu.Unlock()
+	w.auditRequest(r, "false_positive", req.RequestID, nil, fp)
+	writeJSON(rw, http.StatusCreated, fp)
+}
+
+// adminFalsePositives отметки ложных срабатываний, новые первыми, и доли по правилам
+func (w *WAF) adminFalsePositives(rw http.ResponseWriter, r *http.Request) {
+	s := w.stats
+	s.mu.Lock()
+	recent := make([]FalsePositive, 0, len(s.falsePositives))
+	for i := len(s.falsePositives) - 1; i >= 0; i-- {
+		recent = append(recent, s.falsePositives[i])
+	}
+	rates := s.falsePositiveRates()
+	s.mu.Unlock()
+	writeJSON(rw, http.StatusOK, map[string]interface{}{"rates": rates, "recent": recent})
+}
@@ -0,0 +1,285 @@
+package waf
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultFleetInterval = 10 * time.Second
+	fleetForgetAfter     = 24 * time.Hour // экземпляр без отчетов дольше удаляется из списка
+)
+
+// fleetLocalKeys секции конфигурации, которые относятся к самому экземпляру (адреса, сертификаты,
+// бэкенды, получатели событий) и не берутся у лидера
+var fleetLocalKeys = []string{
+	"waf_port", "listen_fd_name", "server_address", "upstreams", "sni_routes", "tls", "http3",
+	"timeouts", "transport", "admin", "audit", "rule_bundles", "events", "cluster", "fleet", "standby",
+	"kubernetes", "rule_updates", "ext_authz", "forward_auth", "plugin_files",
+}
+
+// fleetReport отчет экземпляра лидеру о примененной конфигурации
+type fleetReport struct {
+	Node            string    `json:"node"`
+	Version         string    `json:"version"` // версия конфигурации лидера, примененная экземпляром; пусто — еще не применялась
+	AppliedAt       time.Time `json:"applied_at,omitzero"`
+	Error           string    `json:"error,omitempty"` // последняя ошибка загрузки или применения
+	IntervalSeconds int       `json:"interval_seconds"`
+	ReportedAt      time.Time `json:"reported_at"`
+	Remote          string    `json:"remote"`
+}
+
+// fleetRegistry отчеты экземпляров на лидере
+type fleetRegistry struct {
+	mu    sync.Mutex
+	nodes map[string]*fleetReport
+}
+
+func newFleetRegistry() *fleetRegistry {
+	return &fleetRegistry{nodes: make(map[string]*fleetReport)}
+}
+
+// fleetFollower периодически забирает конфигурацию лидера и применяет ее без перезапуска,
+// сохраняя собственные секции fleetLocalKeys. Изменение конфигурации на самом экземпляре
+// (через API администратора) считается расхождением и перезаписывается конфигурацией лидера
+type fleetFollower struct {
+	waf      *WAF
+	base     *Config // конфигурация из файла
+	leader   string
+	apiKey   string
+	node     string
+	interval time.Duration
+	client   *http.Client
+
+	etag      string // версия лидера из последнего ответа
+	local     string // configVersion примененной конфигурации
+	applied   string // версия лидера, которая действует на экземпляре
+	appliedAt time.Time
+	lastErr   string
+}
+
+// newFleetFollower проверяет адрес лидера из конфига
+func newFleetFollower(w *WAF, base *Config, cfg FleetConfig) (*fleetFollower, error) {
+	u, err := url.Parse(cfg.Leader)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("fleet: invalid leader URL %q", cfg.Leader)
+	}
+	f := &fleetFollower{
+		waf:      w,
+		base:     base,
+		leader:   strings.TrimSuffix(cfg.Leader, "/"),
+		apiKey:   cfg.APIKey,
+		node:     cfg.Node,
+		interval: defaultFleetInterval,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+	if f.node == "" {
+		f.node, _ = os.Hostname()
+	}
+	if cfg.IntervalSeconds > 0 {
+		f.interval = time.Duration(cfg.IntervalSeconds) * time.Second
+	}
+	return f, nil
+}
+
+// run синхронизирует конфигурацию сразу и затем каждые interval
+func (f *fleetFollower) run() {
+	for {
+		f.round()
+		time.Sleep(f.interval)
+	}
+}
+
+// round синхронизирует конфигурацию и отправляет отчет лидеру
+func (f *fleetFollower) round() {
+	f.lastErr = ""
+	if err := f.sync(); err != nil {
+		f.lastErr = err.Error()
+		log.Printf("[WAF] fleet: %v", err)
+	}
+	if err := f.report(); err != nil {
+		log.Printf("[WAF] fleet: отчет лидеру: %v", err)
+	}
+}
+
+// sync загружает конфигурацию лидера, если она изменилась или экземпляр разошелся с ней.
+// Конфигурация с ошибкой не применяется, продолжает действовать предыдущая
+func (f *fleetFollower) sync() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.leader+"/admin/api/fleet/config", nil)
+	if err != nil {
+		return err
+	}
+	if f.etag != "" && configVersion(f.waf.config()) == f.local {
+		req.Header.Set("If-None-Match", f.etag)
+	}
+	f.authorize(req)
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil
+	case http.StatusOK:
+	default:
+		return fmt.Errorf("GET %s: %s", req.URL, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxRuleBundleBytes+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > maxRuleBundleBytes {
+		return fmt.Errorf("GET %s: config exceeds %d bytes", req.URL, maxRuleBundleBytes)
+	}
+	version := resp.Header.Get("ETag")
+
+	var sections map[string]json.RawMessage
+	if err := json.Unmarshal(data, &sections); err != nil {
+		return fmt.Errorf("leader config: %w", err)
+	}
+	for _, k := range fleetLocalKeys {
+		delete(sections, k)
+	}
+	patch, err := json.Marshal(sections)
+	if err != nil {
+		return err
+	}
+	before := f.waf.config()
+	cfg, err := mergeConfig(f.base, patch)
+	if err == nil {
+		err = f.waf.reload(cfg, "fleet")
+	}
+	// Отклоненная версия не загружается повторно, пока лидер ее не сменит
+	f.etag, f.local = version, configVersion(f.waf.config())
+	if err != nil {
+		return fmt.Errorf("leader config %s rejected: %w", version, err)
+	}
+	f.applied, f.appliedAt = version, time.Now()
+	f.waf.audit.record(AuditRecord{
+		Actor:  "fleet",
+		Action: "config_change",
+		Target: f.leader,
+		Before: redactConfig(before),
+		After:  redactConfig(cfg),
+	})
+	log.Printf("[WAF] fleet: применена конфигурация лидера %s версии %s", f.leader, version)
+	return nil
+}
+
+// report сообщает лидеру примененную версию; служит и признаком того, что экземпляр работает
+func (f *fleetFollower) report() error {
+	body, err := json.Marshal(fleetReport{
+		Node:            f.node,
+		Version:         f.applied,
+		AppliedAt:       f.appliedAt,
+		Error:           f.lastErr,
+		IntervalSeconds: int(f.interval / time.Second),
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, f.leader+"/admin/api/fleet/report", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	f.authorize(req)
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("POST %s: %s", req.URL, resp.Status)
+	}
+	return nil
+}
+
+func (f *fleetFollower) authorize(req *http.Request) {
+	if f.apiKey != "" {
+		req.Header.Set("X-API-Key", f.apiKey)
+	}
+}
+
+// adminFleetConfig текущая конфигурация лидера без скрытия секретов: экземпляры применяют
+// ее как есть. Версия передается в ETag; с If-None-Match той же версии ответ 304
+func (w *WAF) adminFleetConfig(rw http.ResponseWriter, r *http.Request) {
+	cfg := w.config()
+	version := configVersion(cfg)
+	rw.Header().Set("ETag", version)
+	if r.Header.Get("If-None-Match") == version {
+		rw.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	writeJSON(rw, http.StatusOK, cfg)
+}
+
+// adminFleetReport принимает отчет экземпляра
+func (w *WAF) adminFleetReport(rw http.ResponseWriter, r *http.Request) {
+	var rep fleetReport
+	if err := json.NewDecoder(http.MaxBytesReader(rw, r.Body, 16<<10)).Decode(&rep); err != nil || rep.Node == "" {
+		writeJSON(rw, http.StatusBadRequest, map[string]string{"error": "node is required"})
+		return
+	}
+	rep.ReportedAt, rep.Remote = time.Now().UTC(), r.RemoteAddr
+	w.fleet.mu.Lock()
+	w.fleet.nodes[rep.Node] = &rep
+	w.fleet.mu.Unlock()
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// adminFleet состояние экземпляров: in_sync — применена текущая версия лидера,
+// stale — отчета нет дольше трех периодов синхронизации
+func (w *WAF) adminFleet(rw http.ResponseWriter, r *http.Request) {
+	type node struct {
+		fleetReport
+		InSync bool `json:"in_sync"`
+		Stale  bool `json:"stale"`
+	}
+	version := configVersion(w.config())
+	now := time.Now()
+	nodes := []node{}
+	w.fleet.mu.Lock()
+	for name, rep := range w.fleet.nodes {
+		age := now.Sub(rep.ReportedAt)
+		if age > fleetForgetAfter {
+			delete(w.fleet.nodes, name)
+			continue
+		}
+		interval := time.Duration(rep.IntervalSeconds) * time.Second
+		if interval <= 0 {
+			interval = defaultFleetInterval
+		}
+		nodes = append(nodes, node{fleetReport: *rep, InSync: rep.Version == version && rep.Error == "", Stale: age > 3*interval})
+	}
+	w.fleet.mu.Unlock()
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Node < nodes[j].Node })
+	drift := 0
+	for _, n := range nodes {
+		if !n.InSync || n.Stale {
+			drift++
+		}
+	}
+	writeJSON(rw, http.StatusOK, map[string]interface{}{
+		"version": version,
+		"nodes":   nodes,
+		"drift":   drift,
+	})
+}
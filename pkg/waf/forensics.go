@@ -0,0 +1,147 @@
+package waf
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxForensicTarget длина пути с query в записи истории
+const maxForensicTarget = 256
+
+// ForensicRequest краткая запись запроса в истории клиента
+type ForensicRequest struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id,omitempty"`
+	Method    string    `json:"method"`
+	Host      string    `json:"host,omitempty"`
+	Target    string    `json:"target"` // путь с query, не длиннее 256 байт
+	UserAgent string    `json:"user_agent,omitempty"`
+	Status    int       `json:"status,omitempty"`   // 0 — запрос еще обрабатывался
+	Findings  []string  `json:"findings,omitempty"` // rule_id срабатываний
+}
+
+// forensicRing последние запросы клиента; хранится в State.Meta["forensics"]
+type forensicRing struct {
+	mu      sync.Mutex
+	entries []*ForensicRequest
+	next    int
+}
+
+func (h *forensicRing) add(e *ForensicRequest, size int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.entries) < size {
+		h.entries = append(h.entries, e)
+		return
+	}
+	h.entries[h.next] = e
+	h.next = (h.next + 1) % len(h.entries)
+}
+
+// snapshot записи от старых к новым
+func (h *forensicRing) snapshot() []ForensicRequest {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make([]ForensicRequest, 0, len(h.entries))
+	for i := range h.entries {
+		out = append(out, *h.entries[(h.next+i)%len(h.entries)])
+	}
+	return out
+}
+
+// forensicLog история последних запросов каждого IP. Когда клиента банят, история попадает
+// в событие ban, и аналитик видит, что привело к бану, без поиска по журналам доступа.
+type forensicLog struct {
+	states *StateStore
+	size   int
+}
+
+// SetForensics включает историю запросов клиентов для событий бана
+func (w *WAF) SetForensics(cfg ForensicsConfig) {
+	if cfg.Requests <= 0 {
+		w.forensics = nil
+	} else {
+		w.forensics = &forensicLog{states: w.states, size: cfg.Requests}
+	}
+	w.bans.forensics = w.forensics
+}
+
+// ring история идентификатора; create — создать, если ее нет
+func (f *forensicLog) ring(id string, create bool) *forensicRing {
+	var st *State
+	if create {
+		st = f.states.Get(id)
+	} else if v, ok := f.states.store.Load(id); ok {
+		st = v.(*State)
+	}
+	if st == nil {
+		return nil
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	h, _ := st.Meta["forensics"].(*forensicRing)
+	if h == nil && create {
+		h = &forensicRing{}
+		st.Meta["forensics"] = h
+	}
+	return h
+}
+
+// begin записывает запрос в историю IP до обработки цепью, чтобы запрос, вызвавший бан,
+// попал в событие. Возвращенная функция дописывает код ответа и срабатывания
+func (f *forensicLog) begin(r *http.Request, ip string) func(status int) {
+	if f == nil || ip == "" {
+		return func(int) {}
+	}
+	target := r.URL.RequestURI()
+	if len(target) > maxForensicTarget {
+		target = target[:maxForensicTarget]
+	}
+	e := &ForensicRequest{
+		Time:      time.Now().UTC(),
+		RequestID: requestID(r),
+		Method:    r.Method,
+		Host:      r.Host,
+		Target:    target,
+		UserAgent: r.UserAgent(),
+	}
+	h := f.ring(ip, true)
+	h.add(e, f.size)
+	return func(status int) {
+		var findings []string
+		for _, ev := range Findings(r) {
+			findings = append(findings, ev.JSON().RuleID)
+		}
+		h.mu.Lock()
+		e.Status, e.Findings = status, findings
+		h.mu.Unlock()
+	}
+}
+
+// link связывает идентификатор клиента (сессию, аккаунт, составной ключ) с историей его IP,
+// чтобы бан по такому идентификатору тоже содержал историю
+func (f *forensicLog) link(id, ip string) {
+	if f == nil || id == ip || ip == "" {
+		return
+	}
+	h := f.ring(ip, true)
+	st := f.states.Get(id)
+	if st == nil {
+		return
+	}
+	st.mu.Lock()
+	st.Meta["forensics"] = h
+	st.mu.Unlock()
+}
+
+// history история идентификатора; nil — история не ведется или запросов не было
+func (f *forensicLog) history(id string) []ForensicRequest {
+	if f == nil {
+		return nil
+	}
+	if h := f.ring(id, false); h != nil {
+		return h.snapshot()
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package waf
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+)
+
+// ForwardAuthHandler отвечает на проверочные подзапросы nginx auth_request и Traefik ForwardAuth.
+// Исходный запрос восстанавливается из заголовков прокси и проверяется всей цепью модулей:
+// 200 — запрос разрешен (заголовки, добавленные модулями, возвращаются в ответе),
+// иначе — ответ модуля, отклонившего запрос (или deny_status, если задан).
+// Адрес клиента берется из заголовка client_ip_header или из записи X-Forwarded-For,
+// добавленной прокси, поэтому обработчик должен быть доступен только прокси.
+func (w *WAF) ForwardAuthHandler(cfg ForwardAuthConfig) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		orig, err := originalRequest(r, cfg)
+		if err != nil {
+			http.Error(rw, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		v := w.authorize(orig)
+		if v.allowed {
+			for k, vals := range v.setHeaders {
+				rw.Header()[k] = vals
+			}
+			rw.WriteHeader(http.StatusOK)
+			return
+		}
+
+		status := v.status
+		if cfg.DenyStatus > 0 {
+			status = cfg.DenyStatus
+		}
+		for k, vals := range v.header {
+			rw.Header()[k] = vals
+		}
+		rw.WriteHeader(status)
+		rw.Write(v.body)
+	})
+}
+
+// forwardAuthHeaders заголовки, которыми прокси описывает исходный запрос; модулям не передаются
+var forwardAuthHeaders = []string{
+	"X-Original-Uri", "X-Original-Method", "X-Original-Url",
+	"X-Forwarded-Uri", "X-Forwarded-Method",
+}
+
+// originalRequest восстанавливает исходный запрос клиента из подзапроса прокси
+// (nginx: X-Original-URI, X-Original-Method; Traefik: X-Forwarded-Uri, X-Forwarded-Method, X-Forwarded-Host)
+func originalRequest(r *http.Request, cfg ForwardAuthConfig) (*http.Request, error) {
+	method := firstHeader(r, "X-Original-Method", "X-Forwarded-Method")
+	if method == "" {
+		method = http.MethodGet
+	}
+	uri := firstHeader(r, "X-Original-Uri", "X-Forwarded-Uri")
+	if uri == "" {
+		uri = "/"
+	}
+	u, err := url.ParseRequestURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	u.Host = firstHeader(r, "X-Forwarded-Host")
+	if u.Host == "" {
+		u.Host = r.Host
+	}
+	u.Scheme = strings.ToLower(firstHeader(r, "X-Forwarded-Proto"))
+	if u.Scheme == "" {
+		u.Scheme = "http"
+	}
+
+	orig, err := http.NewRequestWithContext(r.Context(), method, u.String(), http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	orig.RequestURI = uri
+	orig.Host = u.Host
+	orig.Header = r.Header.Clone()
+	for _, h := range forwardAuthHeaders {
+		orig.Header.Del(h)
+	}
+	if u.Scheme == "https" {
+		// Модули, проверяющие r.TLS (например, csrf), должны видеть исходный протокол
+		orig.TLS = &tls.ConnectionState{}
+	}
+
+	ip, err := forwardAuthClientIP(r, cfg)
+	if err != nil {
+		return nil, err
+	}
+	orig.RemoteAddr = net.JoinHostPort(ip, "0")
+	return orig, nil
+}
+
+// forwardAuthClientIP адрес клиента исходного запроса. Клиент может прислать любые
+// X-Forwarded-For и X-Real-IP, и прокси передает их в подзапросе, поэтому доверять можно
+// только заголовку, который прокси всегда перезаписывает (client_ip_header), или записям
+// X-Forwarded-For, которые дописали сами прокси: trusted_hops записей с конца
+func forwardAuthClientIP(r *http.Request, cfg ForwardAuthConfig) (string, error) {
+	if cfg.ClientIPHeader != "" {
+		addr, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get(cfg.ClientIPHeader)))
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", cfg.ClientIPHeader, err)
+		}
+		return addr.Unmap().String(), nil
+	}
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(v, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	if len(hops) == 0 {
+		return extractIP(r.RemoteAddr), nil
+	}
+	trusted := cfg.TrustedHops
+	if trusted <= 0 {
+		trusted = 1
+	}
+	// Если записей меньше, чем прокси, все они добавлены прокси: берется самая левая
+	i := len(hops) - trusted
+	if i < 0 {
+		i = 0
+	}
+	addr, err := netip.ParseAddr(hops[i])
+	if err != nil {
+		return "", fmt.Errorf("X-Forwarded-For: %w", err)
+	}
+	return addr.Unmap().String(), nil
+}
+
+// firstHeader возвращает первое непустое значение из заголовков names
+func firstHeader(r *http.Request, names ...string) string {
+	for _, name := range names {
+		if v := r.Header.Get(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
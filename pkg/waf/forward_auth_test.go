@@ -0,0 +1,38 @@
+package waf
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestForwardAuthClientIP(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		cfg     ForwardAuthConfig
+		headers map[string]string
+		want    string // пусто — ошибка
+	}{
+		{"proxy appends to spoofed XFF", ForwardAuthConfig{}, map[string]string{"X-Forwarded-For": "6.6.6.6, 203.0.113.7"}, "203.0.113.7"},
+		{"spoofed X-Real-IP ignored", ForwardAuthConfig{}, map[string]string{"X-Real-IP": "6.6.6.6", "X-Forwarded-For": "203.0.113.7"}, "203.0.113.7"},
+		{"no XFF uses connection", ForwardAuthConfig{}, map[string]string{"X-Real-IP": "6.6.6.6"}, "192.0.2.1"},
+		{"two proxies", ForwardAuthConfig{TrustedHops: 2}, map[string]string{"X-Forwarded-For": "6.6.6.6, 203.0.113.7, 10.0.0.2"}, "203.0.113.7"},
+		{"fewer entries than proxies", ForwardAuthConfig{TrustedHops: 3}, map[string]string{"X-Forwarded-For": "203.0.113.7, 10.0.0.2"}, "203.0.113.7"},
+		{"configured header", ForwardAuthConfig{ClientIPHeader: "X-Real-IP"}, map[string]string{"X-Real-IP": "203.0.113.7", "X-Forwarded-For": "6.6.6.6"}, "203.0.113.7"},
+		{"configured header missing", ForwardAuthConfig{ClientIPHeader: "X-Real-IP"}, map[string]string{"X-Forwarded-For": "203.0.113.7"}, ""},
+		{"garbage entry", ForwardAuthConfig{}, map[string]string{"X-Forwarded-For": "203.0.113.7, evil"}, ""},
+	} {
+		r := httptest.NewRequest("GET", "/verify", nil)
+		for k, v := range tc.headers {
+			r.Header.Set(k, v)
+		}
+		orig, err := originalRequest(r, tc.cfg)
+		switch {
+		case tc.want == "" && err == nil:
+			t.Errorf("%s: accepted, client %s", tc.name, orig.RemoteAddr)
+		case tc.want != "" && err != nil:
+			t.Errorf("%s: %v", tc.name, err)
+		case tc.want != "" && ClientIP(orig) != tc.want:
+			t.Errorf("%s: client %s, want %s", tc.name, ClientIP(orig), tc.want)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package waf
+
+import (
+	"fmt"
+	"net/netip"
+	"sync"
+)
+
+const geoCacheSize = 65536 // адресов в кеше; при переполнении кеш очищается
+
+// geoInfo страна, город и автономная система адреса
+type geoInfo struct {
+	country, city string
+	asn           uint64
+	asOrg         string
+}
+
+// geoResolver обогащает события данными баз GeoIP и ASN
+type geoResolver struct {
+	city, country, asn *mmdbReader // nil — база не задана
+
+	mu    sync.RWMutex
+	cache map[netip.Addr]geoInfo
+}
+
+func newGeoResolver(cfg GeoIPConfig) (*geoResolver, error) {
+	g := &geoResolver{cache: make(map[netip.Addr]geoInfo)}
+	for _, db := range []struct {
+		path string
+		dst  **mmdbReader
+	}{{cfg.CityDB, &g.city}, {cfg.CountryDB, &g.country}, {cfg.ASNDB, &g.asn}} {
+		if db.path == "" {
+			continue
+		}
+		r, err := openMMDB(db.path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", db.path, err)
+		}
+		*db.dst = r
+	}
+	if g.city == nil && g.country == nil && g.asn == nil {
+		return nil, nil
+	}
+	return g, nil
+}
+
+// SetGeoIP подключает базы GeoIP и ASN в формате MaxMind DB; события получают поля
+// country, city, asn и as_org
+func (w *WAF) SetGeoIP(cfg GeoIPConfig) error {
+	g, err := newGeoResolver(cfg)
+	if err != nil {
+		return err
+	}
+	w.events.geo = g
+	return nil
+}
+
+func (g *geoResolver) resolve(ip string) geoInfo {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return geoInfo{}
+	}
+	addr = addr.Unmap()
+	g.mu.RLock()
+	info, ok := g.cache[addr]
+	g.mu.RUnlock()
+	if ok {
+		return info
+	}
+
+	if g.city != nil {
+		if rec, _ := g.city.lookup(addr); rec != nil {
+			info.country = mmdbString(rec, "country", "iso_code")
+			info.city = mmdbString(rec, "city", "names", "en")
+		}
+	}
+	if info.country == "" && g.country != nil {
+		if rec, _ := g.country.lookup(addr); rec != nil {
+			info.country = mmdbString(rec, "country", "iso_code")
+		}
+	}
+	if g.asn != nil {
+		if rec, _ := g.asn.lookup(addr); rec != nil {
+			info.asn, _ = mmdbValue(rec, "autonomous_system_number").(uint64)
+			info.asOrg = mmdbString(rec, "autonomous_system_organization")
+		}
+	}
+
+	g.mu.Lock()
+	if len(g.cache) >= geoCacheSize {
+		clear(g.cache)
+	}
+	g.cache[addr] = info
+	g.mu.Unlock()
+	return info
+}
+
+// enrich дополняет событие данными об адресе клиента
+func (g *geoResolver) enrich(ev *SecurityEvent) {
+	if g == nil || ev.IP == "" || (ev.Country != "" || ev.ASN != 0) {
+		return
+	}
+	info := g.resolve(ev.IP)
+	ev.Country, ev.City, ev.ASN, ev.ASOrg = info.country, info.city, info.asn, info.asOrg
+}
+
+// mmdbValue значение по пути ключей во вложенных map записи
+func mmdbValue(rec interface{}, path ...string) interface{} {
+	for _, key := range path {
+		m, ok := rec.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		rec = m[key]
+	}
+	return rec
+}
+
+func mmdbString(rec interface{}, path ...string) string {
+	s, _ := mmdbValue(rec, path...).(string)
+	return s
+}
@@ -0,0 +1,260 @@
+package waf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// goodBot поисковый робот: признак в User-Agent и домены его обратных DNS-имен
+type goodBot struct {
+	name    string
+	token   string // в нижнем регистре
+	domains []string
+}
+
+var defaultGoodBots = []goodBot{
+	{name: "Googlebot", token: "googlebot", domains: []string{"googlebot.com", "google.com", "googleusercontent.com"}},
+	{name: "Bingbot", token: "bingbot", domains: []string{"search.msn.com"}},
+	{name: "YandexBot", token: "yandex", domains: []string{"yandex.ru", "yandex.net", "yandex.com"}},
+	{name: "Applebot", token: "applebot", domains: []string{"applebot.apple.com"}},
+	{name: "Baiduspider", token: "baiduspider", domains: []string{"baidu.com", "baidu.jp"}},
+}
+
+// botVerdict результат проверки адреса
+type botVerdict int
+
+const (
+	botUnknown  botVerdict = iota // DNS недоступен: ни исключений, ни наказания
+	botVerified                   // обратное и прямое разрешение подтвердили робота
+	botImpostor                   // адрес не принадлежит заявленному роботу
+)
+
+type botCacheEntry struct {
+	verdict botVerdict
+	until   time.Time
+}
+
+const goodBotCacheMax = 100000
+
+// GoodBotMiddleware проверяет роботов, заявленных в User-Agent, обратным и затем прямым
+// разрешением DNS. Подтвержденные роботы не ограничиваются rate_limit, самозванцы
+// получают высокий риск и действие impostor_action.
+type GoodBotMiddleware struct {
+	waf           *WAF
+	bots          []goodBot
+	action        string // log, block, ban, throttle, delay, challenge
+	banDuration   time.Duration
+	delay         time.Duration
+	riskScore     float64
+	cacheTTL      time.Duration
+	timeout       time.Duration
+	logDetections bool
+
+	lookupAddr func(ctx context.Context, addr string) ([]string, error)
+	lookupHost func(ctx context.Context, host string) ([]string, error)
+
+	mu      sync.Mutex
+	cache   map[string]botCacheEntry // bot|ip -> результат
+	pending map[string]chan struct{}
+}
+
+// NewGoodBotMiddlewareWithConfig создает проверку поисковых роботов из конфига
+func NewGoodBotMiddlewareWithConfig(w *WAF, cfg GoodBotsConfig) *GoodBotMiddleware {
+	m := &GoodBotMiddleware{
+		waf:           w,
+		bots:          defaultGoodBots,
+		action:        "block",
+		banDuration:   time.Hour,
+		delay:         2 * time.Second,
+		riskScore:     10,
+		cacheTTL:      time.Hour,
+		timeout:       2 * time.Second,
+		logDetections: true,
+		lookupAddr:    net.DefaultResolver.LookupAddr,
+		lookupHost:    net.DefaultResolver.LookupHost,
+		cache:         make(map[string]botCacheEntry),
+		pending:       make(map[string]chan struct{}),
+	}
+	if len(cfg.Bots) > 0 {
+		m.bots = nil
+		for _, b := range cfg.Bots {
+			bot := goodBot{name: b.Name, token: strings.ToLower(b.UserAgent)}
+			for _, d := range b.Domains {
+				bot.domains = append(bot.domains, strings.ToLower(strings.Trim(d, ".")))
+			}
+			m.bots = append(m.bots, bot)
+		}
+	}
+	if cfg.ImpostorAction != "" {
+		m.action = cfg.ImpostorAction
+	}
+	if cfg.BanSeconds > 0 {
+		m.banDuration = time.Duration(cfg.BanSeconds) * time.Second
+	}
+	if cfg.RiskScore > 0 {
+		m.riskScore = cfg.RiskScore
+	}
+	if cfg.CacheSeconds > 0 {
+		m.cacheTTL = time.Duration(cfg.CacheSeconds) * time.Second
+	}
+	if cfg.LookupTimeoutMs > 0 {
+		m.timeout = time.Duration(cfg.LookupTimeoutMs) * time.Millisecond
+	}
+	return m
+}
+
+func (m *GoodBotMiddleware) push(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.waf == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := ClientIP(r)
+		if m.waf.banned(r, ip) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		bot := m.claimed(r.UserAgent())
+		if bot == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		switch m.verify(r.Context(), bot, ip) {
+		case botVerified:
+			if info := requestInfoOf(r); info != nil {
+				info.bot = bot.name
+			}
+		case botImpostor:
+			risk := addRiskScore(m.waf.states.Get(ip), m.riskScore)
+			ev := requestEvent(r, ip, "good_bots", SeverityCritical, m.action, fmt.Sprintf("Самозванец %s от %s: адрес не принадлежит %s, риск %.1f", bot.name, ip, strings.Join(bot.domains, ", "), risk))
+			ev.Fields = map[string]interface{}{"rule": bot.name}
+			if m.waf.decide(r, ev, m.logDetections, func() bool {
+				switch m.action {
+				case "log":
+					return false
+				case "block":
+					return forbid(w)()
+				}
+				return enforceAction(w, r, m.waf, ip, m.action, m.banDuration, m.delay)
+			}) {
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// claimed робот, которым представляется клиент
+func (m *GoodBotMiddleware) claimed(ua string) *goodBot {
+	ua = strings.ToLower(ua)
+	for i := range m.bots {
+		if m.bots[i].token != "" && strings.Contains(ua, m.bots[i].token) {
+			return &m.bots[i]
+		}
+	}
+	return nil
+}
+
+// verify проверяет адрес с кешированием; параллельные запросы одного адреса ждут одну проверку
+func (m *GoodBotMiddleware) verify(ctx context.Context, bot *goodBot, ip string) botVerdict {
+	key := bot.name + "|" + ip
+	for {
+		m.mu.Lock()
+		if e, ok := m.cache[key]; ok && time.Now().Before(e.until) {
+			m.mu.Unlock()
+			return e.verdict
+		}
+		wait, busy := m.pending[key]
+		if !busy {
+			wait = make(chan struct{})
+			m.pending[key] = wait
+		}
+		m.mu.Unlock()
+		if !busy {
+			break
+		}
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return botUnknown
+		}
+	}
+
+	lctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	verdict := m.resolve(lctx, bot, ip)
+	cancel()
+
+	ttl := m.cacheTTL
+	if verdict == botUnknown {
+		ttl = time.Minute
+	}
+	m.mu.Lock()
+	if len(m.cache) >= goodBotCacheMax {
+		clear(m.cache)
+	}
+	m.cache[key] = botCacheEntry{verdict: verdict, until: time.Now().Add(ttl)}
+	close(m.pending[key])
+	delete(m.pending, key)
+	m.mu.Unlock()
+	return verdict
+}
+
+// resolve обратное разрешение адреса, проверка домена и прямое разрешение имени обратно в адрес
+func (m *GoodBotMiddleware) resolve(ctx context.Context, bot *goodBot, ip string) botVerdict {
+	names, err := m.lookupAddr(ctx, ip)
+	if err != nil {
+		if isNotFound(err) {
+			return botImpostor
+		}
+		return botUnknown
+	}
+	unknown := false
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		if !bot.owns(name) {
+			continue
+		}
+		addrs, err := m.lookupHost(ctx, name)
+		if err != nil {
+			unknown = unknown || !isNotFound(err)
+			continue
+		}
+		for _, a := range addrs {
+			if sameIP(a, ip) {
+				return botVerified
+			}
+		}
+	}
+	if unknown {
+		return botUnknown
+	}
+	return botImpostor
+}
+
+func (b *goodBot) owns(host string) bool {
+	for _, d := range b.domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+func sameIP(a, b string) bool {
+	x, y := net.ParseIP(a), net.ParseIP(b)
+	return x != nil && x.Equal(y)
+}
@@ -0,0 +1,145 @@
+package waf
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// headerOrderRules пары заголовков, которые браузеры (Chrome, Firefox, Safari) по HTTP/1.1
+// отправляют в этом порядке. Библиотеки HTTP часто нарушают его, например
+// python-requests ставит Accept-Encoding перед Accept.
+var headerOrderRules = [][2]string{
+	{"User-Agent", "Accept"},
+	{"Accept", "Accept-Language"},
+	{"Accept", "Accept-Encoding"},
+}
+
+// headerCasingChecked заголовки, которые браузеры по HTTP/1.1 пишут в каноническом регистре
+var headerCasingChecked = map[string]bool{
+	"Host": true, "User-Agent": true, "Accept": true, "Accept-Language": true,
+	"Accept-Encoding": true, "Connection": true, "Cookie": true, "Referer": true,
+}
+
+// HeaderAnomalyMiddleware сравнивает набор заголовков запроса с тем, что отправляют браузеры:
+// отсутствие Accept, Accept-Language, Accept-Encoding, порядок и регистр имен. Каждое
+// отклонение добавляет риск клиенту; простые скрипты, выдающие себя за браузер,
+// накапливают риск и учитываются движком решений и правилами. Порядок и регистр
+// проверяются, только если listener записывает исходные заголовки (raw_headers).
+type HeaderAnomalyMiddleware struct {
+	waf           *WAF
+	allClients    bool
+	riskScore     float64 // за каждое отклонение
+	threshold     int     // число отклонений для действия; 0 — только риск и событие
+	action        string  // challenge, block, ban, throttle, delay
+	banDuration   time.Duration
+	delay         time.Duration
+	logDetections bool
+}
+
+// NewHeaderAnomalyMiddlewareWithConfig создает проверку набора заголовков из конфига
+func NewHeaderAnomalyMiddlewareWithConfig(w *WAF, cfg HeaderAnomalyConfig) *HeaderAnomalyMiddleware {
+	m := &HeaderAnomalyMiddleware{
+		waf:           w,
+		allClients:    cfg.AllClients,
+		riskScore:     2,
+		threshold:     cfg.Threshold,
+		action:        "challenge",
+		banDuration:   10 * time.Minute,
+		delay:         2 * time.Second,
+		logDetections: true,
+	}
+	if cfg.RiskScore > 0 {
+		m.riskScore = cfg.RiskScore
+	}
+	if cfg.Action != "" {
+		m.action = cfg.Action
+	}
+	if cfg.BanSeconds > 0 {
+		m.banDuration = time.Duration(cfg.BanSeconds) * time.Second
+	}
+	return m
+}
+
+func (m *HeaderAnomalyMiddleware) push(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.waf == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := ClientIP(r)
+		if m.waf.banned(r, ip) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		// Проверенные поисковые роботы отправляют свой набор заголовков
+		if verifiedBot(r) != "" || (!m.allClients && !strings.Contains(r.UserAgent(), "Mozilla/")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		anomalies := headerAnomalies(r, rawHeaders(r))
+		if len(anomalies) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		risk := addRiskScore(m.waf.states.Get(ip), m.riskScore*float64(len(anomalies)))
+		severity, action := SeverityInfo, "log"
+		if m.threshold > 0 && len(anomalies) >= m.threshold {
+			severity, action = SeverityWarning, m.action
+		}
+		ev := requestEvent(r, ip, "header_anomaly", severity, action, fmt.Sprintf("Набор заголовков не похож на браузер у %s: %s, риск %.1f", ip, strings.Join(anomalies, ", "), risk))
+		ev.Fields = map[string]interface{}{"anomalies": anomalies}
+		if m.waf.decide(r, ev, m.logDetections, func() bool {
+			switch action {
+			case "log":
+				return false
+			case "block":
+				return forbid(w)()
+			}
+			return enforceAction(w, r, m.waf, ip, action, m.banDuration, m.delay)
+		}) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// headerAnomalies отклонения набора заголовков от браузерного. raw — имена в исходном
+// порядке и регистре; без них проверяется только наличие заголовков.
+func headerAnomalies(r *http.Request, raw []string) []string {
+	var out []string
+	for _, h := range []string{"Accept", "Accept-Language", "Accept-Encoding"} {
+		if r.Header.Get(h) == "" {
+			out = append(out, "missing "+h)
+		}
+	}
+	if raw == nil {
+		return out
+	}
+	pos := make(map[string]int, len(raw))
+	for i, name := range raw {
+		canonical := http.CanonicalHeaderKey(name)
+		if _, ok := pos[canonical]; !ok {
+			pos[canonical] = i
+		}
+		if headerCasingChecked[canonical] && name != canonical {
+			out = append(out, "casing "+name)
+		}
+	}
+	if i, ok := pos["Host"]; ok && i != 0 {
+		out = append(out, "Host not first")
+	}
+	for _, rule := range headerOrderRules {
+		a, okA := pos[rule[0]]
+		b, okB := pos[rule[1]]
+		if okA && okB && a > b {
+			out = append(out, "order "+rule[1]+" before "+rule[0])
+		}
+	}
+	return out
+}
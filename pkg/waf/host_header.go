@@ -0,0 +1,277 @@
+package waf
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hostOverrideHeaders заголовки, которыми фреймворки подменяют Host при построении ссылок
+var hostOverrideHeaders = []string{"X-Forwarded-Host", "X-Host", "X-Original-Host", "X-Forwarded-Server", "X-Http-Host-Override"}
+
+// HostHeaderMiddleware проверяет Host по списку имен сайта. Приложения строят из Host
+// ссылки в письмах сброса пароля и ключи кеша, поэтому чужой Host, absolute-URI с другим
+// хостом или X-Forwarded-Host от клиента позволяют отравить ссылку или кеш.
+//
+// Для сервисов, открытых браузерам, модуль защищает и от DNS rebinding: страница злоумышленника
+// перепривязывает свое имя на внутренний адрес, и браузер отправляет сервису запросы с чужим
+// Host. Такие запросы отклоняются, если имя из Host указывает на частные адреса.
+type HostHeaderMiddleware struct {
+	waf            *WAF
+	allowed        []string // точные имена и шаблоны *.example.com; пусто — проверяется только согласованность
+	allowAbsolute  bool
+	stripOverrides bool // удалять заголовки подмены Host вместо проверки
+	blockPrivate   bool // отклонять Host, который разрешается в частные адреса
+	requireSNI     bool // по TLS Host должен совпадать с SNI
+	action         string
+	banDuration    time.Duration
+	delay          time.Duration
+	logDetections  bool
+
+	lookup   func(ctx context.Context, host string) ([]net.IPAddr, error)
+	mu       sync.Mutex
+	resolved map[string]hostResolution
+}
+
+// hostResolution кешированный результат разрешения имени из Host
+type hostResolution struct {
+	private bool
+	until   time.Time
+}
+
+const (
+	hostResolveTTL     = time.Minute
+	hostResolveTimeout = 2 * time.Second
+	hostResolveMax     = 10000
+)
+
+// NewHostHeaderMiddlewareWithConfig создает проверку Host из конфига
+func NewHostHeaderMiddlewareWithConfig(w *WAF, cfg HostHeaderConfig) *HostHeaderMiddleware {
+	m := &HostHeaderMiddleware{
+		waf:            w,
+		allowAbsolute:  cfg.AllowAbsoluteURI,
+		stripOverrides: cfg.StripOverrides,
+		blockPrivate:   cfg.BlockPrivateResolution,
+		requireSNI:     cfg.RequireSNIMatch,
+		lookup:         net.DefaultResolver.LookupIPAddr,
+		resolved:       make(map[string]hostResolution),
+		action:         "block",
+		banDuration:    10 * time.Minute,
+		delay:          2 * time.Second,
+		logDetections:  true,
+	}
+	for _, h := range cfg.AllowedHosts {
+		m.allowed = append(m.allowed, strings.ToLower(strings.TrimSuffix(h, ".")))
+	}
+	if cfg.Action != "" {
+		m.action = cfg.Action
+	}
+	if cfg.BanSeconds > 0 {
+		m.banDuration = time.Duration(cfg.BanSeconds) * time.Second
+	}
+	return m
+}
+
+func (m *HostHeaderMiddleware) push(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.waf == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := ClientIP(r)
+		if m.waf.banned(r, ip) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		if m.stripOverrides {
+			for _, h := range hostOverrideHeaders {
+				r.Header.Del(h)
+			}
+		}
+		reason, value := m.check(r)
+		if reason == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ev := requestEvent(r, ip, "host_header", SeverityWarning, m.action, fmt.Sprintf("Недопустимый Host от %s: %s (%q)", ip, reason, value))
+		ev.Fields = map[string]interface{}{"reason": reason, "host": value}
+		if m.waf.decide(r, ev, m.logDetections, func() bool {
+			switch m.action {
+			case "log":
+				return false
+			case "block":
+				if reason == reasonSNIMismatch {
+					// Браузер, объединивший соединения HTTP/2 для разных имен, повторит
+					// запрос по новому соединению
+					http.Error(w, "Misdirected Request", http.StatusMisdirectedRequest)
+					return true
+				}
+				return forbid(w)()
+			}
+			return enforceAction(w, r, m.waf, ip, m.action, m.banDuration, m.delay)
+		}) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// check возвращает причину отказа и проверенное значение; "" — Host допустим
+func (m *HostHeaderMiddleware) check(r *http.Request) (string, string) {
+	if r.Host == "" {
+		return "missing Host", ""
+	}
+	if !validHost(r.Host) {
+		return "malformed Host", r.Host
+	}
+	host := requestHost(r)
+	// absolute-form (GET http://other/ HTTP/1.1): net/http берет хост из URI, а приложение
+	// за WAF может прочитать заголовок Host
+	if r.URL.IsAbs() && !m.allowAbsolute {
+		return "absolute-URI", r.RequestURI
+	}
+	if len(m.allowed) > 0 && !matchHost(m.allowed, host) {
+		return "host not allowed", r.Host
+	}
+	if m.requireSNI && r.TLS != nil {
+		// Без SNI обращаются по IP-адресу; тогда и Host должен быть адресом
+		if sni := serverName(r); sni != host && (sni != "" || net.ParseIP(host) == nil) {
+			return reasonSNIMismatch, r.Host + " / " + sni
+		}
+	}
+	if m.blockPrivate && !matchHost(m.allowed, host) && m.resolvesPrivate(r.Context(), host) {
+		return "host resolves to private address", r.Host
+	}
+	if m.stripOverrides {
+		return "", ""
+	}
+	for _, h := range hostOverrideHeaders {
+		for _, v := range r.Header.Values(h) {
+			if reason := m.checkOverride(v, host); reason != "" {
+				return h + " " + reason, v
+			}
+		}
+	}
+	for _, v := range r.Header.Values("Forwarded") {
+		for _, elem := range strings.Split(v, ",") {
+			for _, pair := range strings.Split(elem, ";") {
+				if k, val, ok := strings.Cut(strings.TrimSpace(pair), "="); ok && strings.EqualFold(k, "host") {
+					if reason := m.checkOverride(strings.Trim(val, `"`), host); reason != "" {
+						return "Forwarded " + reason, v
+					}
+				}
+			}
+		}
+	}
+	return "", ""
+}
+
+const reasonSNIMismatch = "Host does not match SNI"
+
+// resolvesPrivate сообщает, указывает ли имя (или IP-литерал) на частные, loopback или
+// link-local адреса. Ошибка разрешения не считается отказом: внутренние имена могут
+// не разрешаться с WAF. Результат кешируется на минуту.
+func (m *HostHeaderMiddleware) resolvesPrivate(ctx context.Context, host string) bool {
+	if ip := net.ParseIP(host); ip != nil {
+		return privateIP(ip)
+	}
+	m.mu.Lock()
+	if e, ok := m.resolved[host]; ok && time.Now().Before(e.until) {
+		m.mu.Unlock()
+		return e.private
+	}
+	m.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, hostResolveTimeout)
+	defer cancel()
+	addrs, err := m.lookup(ctx, host)
+	private := false
+	for _, a := range addrs {
+		if privateIP(a.IP) {
+			private = true
+			break
+		}
+	}
+	if err != nil && ctx.Err() != nil {
+		// Таймаут не кешируется: следующий запрос повторит разрешение
+		return false
+	}
+
+	m.mu.Lock()
+	if len(m.resolved) >= hostResolveMax {
+		clear(m.resolved)
+	}
+	m.resolved[host] = hostResolution{private: private, until: time.Now().Add(hostResolveTTL)}
+	m.mu.Unlock()
+	return private
+}
+
+// cgnat общее адресное пространство операторов (RFC 6598)
+var cgnat = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// privateIP адрес внутренней сети: частный, loopback, link-local, CGNAT или неуказанный
+func privateIP(ip net.IP) bool {
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() || cgnat.Contains(ip)
+}
+
+// checkOverride проверяет значение заголовка подмены Host: оно должно совпадать с Host
+// или входить в список разрешенных имен
+func (m *HostHeaderMiddleware) checkOverride(v, host string) string {
+	for _, part := range strings.Split(v, ",") {
+		part = strings.TrimSpace(part)
+		if !validHost(part) {
+			return "malformed"
+		}
+		h := part
+		if hh, _, err := net.SplitHostPort(part); err == nil {
+			h = hh
+		}
+		h = strings.ToLower(strings.TrimSuffix(h, "."))
+		if h != host && !matchHost(m.allowed, h) {
+			return "does not match Host"
+		}
+	}
+	return ""
+}
+
+// validHost проверяет синтаксис host[:port]: имя из букв, цифр, '-', '.', '_' или IP-литерал
+// в скобках и числовой порт. Отсекает userinfo (good.com@evil.com), пробелы и мусор.
+func validHost(hostport string) bool {
+	host, port := hostport, ""
+	if strings.HasPrefix(hostport, "[") {
+		end := strings.IndexByte(hostport, ']')
+		if end < 0 || net.ParseIP(hostport[1:end]) == nil {
+			return false
+		}
+		host, port = "", hostport[end+1:]
+		if port != "" && !strings.HasPrefix(port, ":") {
+			return false
+		}
+		port = strings.TrimPrefix(port, ":")
+	} else if i := strings.IndexByte(hostport, ':'); i >= 0 {
+		host, port = hostport[:i], hostport[i+1:]
+	}
+	if hostport == "" || (port == "" && strings.HasSuffix(hostport, ":")) {
+		return false
+	}
+	if port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 || port[0] == '+' {
+			return false
+		}
+	}
+	for i := 0; i < len(host); i++ {
+		c := host[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '.' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,138 @@
+package waf
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// HotlinkMiddleware ограничивает доступ к путям по Referer: например, /media/* отдается,
+// только если на файл ссылаются страницы своих доменов. Чужие сайты, встраивающие
+// картинки и видео, получают отказ или перенаправление на заглушку с водяным знаком.
+type HotlinkMiddleware struct {
+	waf           *WAF
+	rules         []hotlinkRule
+	logDetections bool
+}
+
+type hotlinkRule struct {
+	routes      []routePattern
+	allowed     []string // домены-источники, в т.ч. *.example.com; свой Host разрешен всегда
+	blockEmpty  bool     // запрос без Referer считается чужим
+	action      string   // block, redirect, log
+	redirectURL string
+}
+
+// NewHotlinkMiddlewareWithConfig создает политики Referer из конфига
+func NewHotlinkMiddlewareWithConfig(w *WAF, cfg HotlinkConfig) (*HotlinkMiddleware, error) {
+	m := &HotlinkMiddleware{waf: w, logDetections: true}
+	for i, rc := range cfg.Rules {
+		if len(rc.Routes) == 0 {
+			return nil, fmt.Errorf("rule #%d: routes are required", i+1)
+		}
+		rule := hotlinkRule{blockEmpty: rc.BlockEmpty, action: "block", redirectURL: rc.RedirectURL}
+		if rc.Action != "" {
+			rule.action = rc.Action
+		}
+		switch rule.action {
+		case "block", "log":
+		case "redirect":
+			if rc.RedirectURL == "" {
+				return nil, fmt.Errorf("rule #%d: redirect_url is required for redirect", i+1)
+			}
+		default:
+			return nil, fmt.Errorf("rule #%d: unknown action %q", i+1, rule.action)
+		}
+		for _, rt := range rc.Routes {
+			rule.routes = append(rule.routes, compileRoutePattern(rt))
+		}
+		for _, h := range rc.AllowedReferers {
+			rule.allowed = append(rule.allowed, strings.ToLower(strings.TrimSuffix(h, ".")))
+		}
+		m.rules = append(m.rules, rule)
+	}
+	return m, nil
+}
+
+func (m *HotlinkMiddleware) push(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.waf == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := ClientIP(r)
+		if m.waf.banned(r, ip) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		rule := m.ruleFor(r.URL.Path)
+		if rule == nil || rule.allows(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// Заглушка сама может попасть под правило; перенаправлять на нее повторно нельзя
+		if rule.action == "redirect" && sameResource(r, rule.redirectURL) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		referer := r.Referer()
+		ev := requestEvent(r, ip, "hotlink", SeverityInfo, rule.action, fmt.Sprintf("Ссылка на %s с чужого источника %q от %s", r.URL.Path, referer, ip))
+		ev.Fields = map[string]interface{}{"referer": referer}
+		w.Header().Add("Vary", "Referer")
+		switch rule.action {
+		case "log":
+			if m.logDetections {
+				m.waf.emit(ev)
+			}
+			next.ServeHTTP(w, r)
+		case "redirect":
+			m.waf.refuse(w, r, ev, m.logDetections, func() {
+				w.Header().Set("Cache-Control", "no-store")
+				http.Redirect(w, r, rule.redirectURL, http.StatusFound)
+			})
+		default:
+			m.waf.refuse(w, r, ev, m.logDetections, func() { http.Error(w, "Forbidden", http.StatusForbidden) })
+		}
+	})
+}
+
+// ruleFor первое правило, маршрут которого совпадает с путем; nil — путь не ограничен
+func (m *HotlinkMiddleware) ruleFor(urlPath string) *hotlinkRule {
+	for i := range m.rules {
+		for _, rp := range m.rules[i].routes {
+			if _, ok := rp.match(urlPath); ok {
+				return &m.rules[i]
+			}
+		}
+	}
+	return nil
+}
+
+// allows сообщает, пришел ли запрос со своего источника. Без Referer запрос разрешен
+// (прямой переход, Referrer-Policy: no-referrer), если только браузер не сообщил
+// Sec-Fetch-Site: cross-site — так обходят проверку, скрывая Referer.
+func (rule *hotlinkRule) allows(r *http.Request) bool {
+	referer := r.Referer()
+	if referer == "" {
+		return !rule.blockEmpty && r.Header.Get("Sec-Fetch-Site") != "cross-site"
+	}
+	u, err := url.Parse(referer)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	return host == requestHost(r) || matchHost(rule.allowed, host)
+}
+
+// sameResource сообщает, что запрос обращается к адресу перенаправления
+func sameResource(r *http.Request, target string) bool {
+	u, err := url.Parse(target)
+	if err != nil {
+		return false
+	}
+	return u.Path == r.URL.Path && (u.Host == "" || strings.EqualFold(u.Hostname(), requestHost(r)))
+}
@@ -0,0 +1,27 @@
+//go:build http3
+
+package waf
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// http3Supported WAF собран с поддержкой HTTP/3
+const http3Supported = true
+
+// serveHTTP3 обслуживает QUIC на conn с той же цепью обработчиков, что и TCP listener
+func serveHTTP3(cfg HTTP3Config, conn net.PacketConn, handler http.Handler) error {
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return err
+	}
+	srv := &http3.Server{
+		Handler:   handler,
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+	}
+	return srv.Serve(conn)
+}
@@ -0,0 +1,16 @@
+//go:build !http3
+
+package waf
+
+import (
+	"errors"
+	"net"
+	"net/http"
+)
+
+// http3Supported WAF собран без поддержки HTTP/3 (нужен тег сборки http3)
+const http3Supported = false
+
+func serveHTTP3(HTTP3Config, net.PacketConn, http.Handler) error {
+	return errors.New("HTTP/3 support is not compiled in (build with -tags http3)")
+}
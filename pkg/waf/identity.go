@@ -0,0 +1,89 @@
+package waf
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// defaultTLSFingerprintHeader заголовок, в котором TLS-терминатор передает отпечаток JA3
+const defaultTLSFingerprintHeader = "X-JA3-Fingerprint"
+
+// identityKey возвращает ключ для отслеживания поведения клиента.
+// Приоритет: аккаунт, затем сессия, устройство, иначе IP. Префиксы исключают пересечение с IP.
+func identityKey(r *http.Request, cfg IdentityConfig, ip string) string {
+	if cfg.JWTClaim != "" {
+		if v, ok := JWTClaims(r)[cfg.JWTClaim].(string); ok && v != "" {
+			return "account:" + v
+		}
+	}
+	if cfg.AccountHeader != "" {
+		if v := strings.TrimSpace(r.Header.Get(cfg.AccountHeader)); v != "" {
+			return "account:" + v
+		}
+	}
+	if cfg.SessionHeader != "" {
+		if v := strings.TrimSpace(r.Header.Get(cfg.SessionHeader)); v != "" {
+			return "session:" + v
+		}
+	}
+	if cfg.SessionCookie != "" {
+		if c, err := r.Cookie(cfg.SessionCookie); err == nil && c.Value != "" {
+			return "session:" + c.Value
+		}
+	}
+	if cfg.Device {
+		if id := deviceID(r); id != "" {
+			return "device:" + id
+		}
+	}
+	return ip
+}
+
+// identityParts проверяет части составного ключа (ip+ja3, session, header:X-Device-Id)
+func identityParts(key string) ([]string, error) {
+	parts := strings.Split(key, "+")
+	for i, p := range parts {
+		p = strings.TrimSpace(p)
+		parts[i] = p
+		switch {
+		case p == "ip", p == "ja3", p == "session", p == "account", p == "device", p == "ua":
+		case strings.HasPrefix(p, "header:") && len(p) > len("header:"):
+		case strings.HasPrefix(p, "cookie:") && len(p) > len("cookie:"):
+		default:
+			return nil, fmt.Errorf("key %q: unknown part %q", key, p)
+		}
+	}
+	return parts, nil
+}
+
+// identityPart значение части составного ключа; "" — в запросе ее нет
+func identityPart(r *http.Request, cfg IdentityConfig, part, ip string) string {
+	switch part {
+	case "ip":
+		return ip
+	case "ja3":
+		h := cfg.TLSFingerprintHeader
+		if h == "" {
+			h = defaultTLSFingerprintHeader
+		}
+		return strings.TrimSpace(r.Header.Get(h))
+	case "ua":
+		return r.UserAgent()
+	case "device":
+		return deviceID(r)
+	case "session":
+		return strings.TrimPrefix(identityKey(r, IdentityConfig{SessionHeader: cfg.SessionHeader, SessionCookie: cfg.SessionCookie}, ""), "session:")
+	case "account":
+		return strings.TrimPrefix(identityKey(r, IdentityConfig{JWTClaim: cfg.JWTClaim, AccountHeader: cfg.AccountHeader}, ""), "account:")
+	}
+	if name, ok := strings.CutPrefix(part, "header:"); ok {
+		return strings.TrimSpace(r.Header.Get(name))
+	}
+	if name, ok := strings.CutPrefix(part, "cookie:"); ok {
+		if c, err := r.Cookie(name); err == nil {
+			return c.Value
+		}
+	}
+	return ""
+}
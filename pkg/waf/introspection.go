@@ -0,0 +1,251 @@
+package waf
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// introspectionEntry закешированный ответ endpoint'а интроспекции
+type introspectionEntry struct {
+	active  bool
+	claims  map[string]interface{}
+	expires time.Time
+}
+
+// IntrospectionMiddleware проверяет непрозрачные bearer токены через endpoint
+// интроспекции OAuth2 (RFC 7662) и отклоняет неактивные токены с 401.
+// Ответы кешируются, но не дольше срока действия токена (exp).
+// Ответ интроспекции доступен последующим middleware через JWTClaims.
+type IntrospectionMiddleware struct {
+	waf            *WAF
+	endpoint       string
+	clientID       string
+	clientSecret   string
+	routes         []routePattern
+	optional       bool
+	cookieName     string
+	requiredScopes []string
+	forwardClaims  map[string]string
+	cacheTTL       time.Duration
+	negativeTTL    time.Duration
+	maxCacheSize   int
+	failOpen       bool
+	client         *http.Client
+	mu             sync.Mutex
+	cache          map[string]*introspectionEntry
+	logDetections  bool
+}
+
+// NewIntrospectionMiddlewareWithConfig создает middleware интроспекции из конфига
+func NewIntrospectionMiddlewareWithConfig(w *WAF, cfg IntrospectionConfig) (*IntrospectionMiddleware, error) {
+	if cfg.Endpoint == "" {
+		return nil, errors.New("introspection: endpoint is required")
+	}
+	m := &IntrospectionMiddleware{
+		waf:            w,
+		endpoint:       cfg.Endpoint,
+		clientID:       cfg.ClientID,
+		clientSecret:   cfg.ClientSecret,
+		optional:       cfg.Optional,
+		cookieName:     cfg.CookieName,
+		requiredScopes: cfg.RequiredScopes,
+		forwardClaims:  cfg.ForwardClaims,
+		cacheTTL:       5 * time.Minute,
+		negativeTTL:    30 * time.Second,
+		maxCacheSize:   10000,
+		failOpen:       cfg.FailOpen,
+		client:         &http.Client{Timeout: 5 * time.Second},
+		cache:          make(map[string]*introspectionEntry),
+		logDetections:  true,
+	}
+	for _, p := range cfg.Routes {
+		m.routes = append(m.routes, compileRoutePattern(p))
+	}
+	if cfg.CacheSeconds > 0 {
+		m.cacheTTL = time.Duration(cfg.CacheSeconds) * time.Second
+	}
+	if cfg.NegativeCacheSeconds > 0 {
+		m.negativeTTL = time.Duration(cfg.NegativeCacheSeconds) * time.Second
+	}
+	if cfg.MaxCacheSize > 0 {
+		m.maxCacheSize = cfg.MaxCacheSize
+	}
+	if cfg.TimeoutSeconds > 0 {
+		m.client.Timeout = time.Duration(cfg.TimeoutSeconds) * time.Second
+	}
+	return m, nil
+}
+
+func (m *IntrospectionMiddleware) push(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.waf == nil || !m.protects(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := ClientIP(r)
+		if m.waf.banned(r, ip) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		// Заголовки с claims выставляет только WAF, клиентские значения удаляются
+		for _, header := range m.forwardClaims {
+			r.Header.Del(header)
+		}
+
+		token := bearerToken(r, m.cookieName)
+		if token == "" {
+			if m.optional {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ev := requestEvent(r, ip, "introspection", SeverityInfo, "block", fmt.Sprintf("Запрос без токена от %s: %s %s", ip, r.Method, r.URL.Path))
+			m.waf.refuse(w, r, ev, false, func() {
+				w.Header().Set("WWW-Authenticate", `Bearer`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			})
+			return
+		}
+
+		entry, err := m.introspect(token)
+		if err != nil {
+			log.Printf("[WAF] Ошибка интроспекции токена: %v", err)
+			if m.failOpen {
+				next.ServeHTTP(w, r)
+				return
+			}
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if reason := m.check(entry); reason != "" {
+			ev := requestEvent(r, ip, "introspection", SeverityWarning, "block", fmt.Sprintf("Токен отклонен интроспекцией от %s: %s %s: %s", ip, r.Method, r.URL.Path, reason))
+			m.waf.refuse(w, r, ev, m.logDetections, func() {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			})
+			return
+		}
+
+		for claim, header := range m.forwardClaims {
+			if v, ok := entry.claims[claim]; ok {
+				r.Header.Set(header, fmt.Sprint(v))
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), jwtClaimsKey{}, entry.claims)))
+	})
+}
+
+// protects проверяет, требуется ли токен для пути (пустой список маршрутов — все пути)
+func (m *IntrospectionMiddleware) protects(path string) bool {
+	if len(m.routes) == 0 {
+		return true
+	}
+	for _, p := range m.routes {
+		if _, ok := p.match(path); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// check возвращает причину отказа для результата интроспекции
+func (m *IntrospectionMiddleware) check(entry *introspectionEntry) string {
+	if !entry.active {
+		return "токен неактивен"
+	}
+	if len(m.requiredScopes) > 0 {
+		scope, _ := entry.claims["scope"].(string)
+		granted := toSet(strings.Fields(scope))
+		for _, s := range m.requiredScopes {
+			if !granted[s] {
+				return "нет scope " + s
+			}
+		}
+	}
+	return ""
+}
+
+// introspect возвращает результат интроспекции из кеша или запрашивает endpoint
+func (m *IntrospectionMiddleware) introspect(token string) (*introspectionEntry, error) {
+	sum := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(sum[:])
+	now := time.Now()
+
+	m.mu.Lock()
+	if e, ok := m.cache[key]; ok && now.Before(e.expires) {
+		m.mu.Unlock()
+		return e, nil
+	}
+	m.mu.Unlock()
+
+	entry, err := m.request(token)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := m.negativeTTL
+	if entry.active {
+		ttl = m.cacheTTL
+		if exp, ok := entry.claims["exp"].(float64); ok {
+			if untilExp := time.Until(time.Unix(int64(exp), 0)); untilExp < ttl {
+				ttl = untilExp
+			}
+		}
+	}
+	entry.expires = now.Add(ttl)
+
+	m.mu.Lock()
+	if len(m.cache) >= m.maxCacheSize {
+		for k, e := range m.cache {
+			if now.After(e.expires) {
+				delete(m.cache, k)
+			}
+		}
+		// Кеш заполнен действующими записями: сбросить его целиком
+		if len(m.cache) >= m.maxCacheSize {
+			m.cache = make(map[string]*introspectionEntry)
+		}
+	}
+	m.cache[key] = entry
+	m.mu.Unlock()
+	return entry, nil
+}
+
+// request выполняет запрос интроспекции (RFC 7662, раздел 2.1)
+func (m *IntrospectionMiddleware) request(token string) (*introspectionEntry, error) {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequest(http.MethodPost, m.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if m.clientID != "" {
+		req.SetBasicAuth(url.QueryEscape(m.clientID), url.QueryEscape(m.clientSecret))
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("bad response: " + resp.Status)
+	}
+	var claims map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&claims); err != nil {
+		return nil, err
+	}
+	active, _ := claims["active"].(bool)
+	return &introspectionEntry{active: active, claims: claims}, nil
+}
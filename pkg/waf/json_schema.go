@@ -0,0 +1,254 @@
+package waf
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// jsonSchema подмножество JSON Schema для параметров и тел запросов.
+// Поддерживаются type, format, enum, pattern, minLength/maxLength, minimum/maximum,
+// properties, required, additionalProperties, items, minItems/maxItems, nullable.
+type jsonSchema struct {
+	Ref                  string                 `json:"$ref"`
+	Type                 string                 `json:"type"`
+	Format               string                 `json:"format"`
+	Enum                 []interface{}          `json:"enum"`
+	Pattern              string                 `json:"pattern"`
+	MinLength            *int                   `json:"minLength"`
+	MaxLength            *int                   `json:"maxLength"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+	Items                *jsonSchema            `json:"items"`
+	MinItems             *int                   `json:"minItems"`
+	MaxItems             *int                   `json:"maxItems"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	Required             []string               `json:"required"`
+	AdditionalProperties json.RawMessage        `json:"additionalProperties"`
+	Nullable             bool                   `json:"nullable"`
+
+	patternOnce sync.Once
+	patternRe   *regexp.Regexp
+}
+
+// resolveSchemaRef заменяет ссылку вида #/components/schemas/Name на схему
+func resolveSchemaRef(s *jsonSchema, schemas map[string]*jsonSchema) *jsonSchema {
+	for depth := 0; s != nil && s.Ref != "" && depth < 16; depth++ {
+		name := strings.TrimPrefix(s.Ref, "#/components/schemas/")
+		s = schemas[name]
+	}
+	return s
+}
+
+// validateParam проверяет строковое значение параметра, приводя его к типу схемы
+func (s *jsonSchema) validateParam(v string) error {
+	if s == nil {
+		return nil
+	}
+	switch s.Type {
+	case "integer":
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("ожидалось целое число")
+		}
+		return s.validateNumber(float64(n), v)
+	case "number":
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+			return fmt.Errorf("ожидалось число")
+		}
+		return s.validateNumber(n, v)
+	case "boolean":
+		if v != "true" && v != "false" {
+			return fmt.Errorf("ожидалось true или false")
+		}
+		return s.validateEnum(v)
+	case "array":
+		for _, item := range strings.Split(v, ",") {
+			if err := s.Items.validateParam(item); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return s.validateString(v)
+	}
+}
+
+// validateNumber проверяет границы числа
+func (s *jsonSchema) validateNumber(n float64, raw string) error {
+	if s.Minimum != nil && n < *s.Minimum {
+		return fmt.Errorf("значение меньше минимума %v", *s.Minimum)
+	}
+	if s.Maximum != nil && n > *s.Maximum {
+		return fmt.Errorf("значение больше максимума %v", *s.Maximum)
+	}
+	return s.validateEnum(raw)
+}
+
+// validateString проверяет длину, формат, шаблон и перечисление строки
+func (s *jsonSchema) validateString(v string) error {
+	length := len([]rune(v))
+	if s.MinLength != nil && length < *s.MinLength {
+		return fmt.Errorf("длина меньше %d", *s.MinLength)
+	}
+	if s.MaxLength != nil && length > *s.MaxLength {
+		return fmt.Errorf("длина больше %d", *s.MaxLength)
+	}
+	switch s.Format {
+	case "uuid":
+		if !uuidRe.MatchString(v) {
+			return fmt.Errorf("ожидался uuid")
+		}
+	case "email":
+		if !isEmail(v) {
+			return fmt.Errorf("ожидался email")
+		}
+	}
+	if s.Pattern != "" {
+		s.patternOnce.Do(func() {
+			s.patternRe, _ = regexp.Compile(s.Pattern)
+		})
+		if s.patternRe != nil && !s.patternRe.MatchString(v) {
+			return fmt.Errorf("значение не соответствует шаблону")
+		}
+	}
+	return s.validateEnum(v)
+}
+
+// validateEnum проверяет, что значение входит в перечисление (сравнение по строковому виду)
+func (s *jsonSchema) validateEnum(v string) error {
+	if len(s.Enum) == 0 {
+		return nil
+	}
+	for _, e := range s.Enum {
+		if fmt.Sprint(e) == v {
+			return nil
+		}
+	}
+	return fmt.Errorf("значение вне перечисления")
+}
+
+// validateValue проверяет значение, декодированное json.Decoder с UseNumber.
+// path указывает положение значения в документе для сообщения об ошибке.
+func (s *jsonSchema) validateValue(v interface{}, path string, schemas map[string]*jsonSchema) error {
+	s = resolveSchemaRef(s, schemas)
+	if s == nil {
+		return nil
+	}
+	if v == nil {
+		if s.Nullable || s.Type == "" || s.Type == "null" {
+			return nil
+		}
+		return fmt.Errorf("%s: null недопустим", path)
+	}
+
+	switch val := v.(type) {
+	case map[string]interface{}:
+		if s.Type != "" && s.Type != "object" {
+			return fmt.Errorf("%s: ожидался тип %s, получен object", path, s.Type)
+		}
+		return s.validateObject(val, path, schemas)
+	case []interface{}:
+		if s.Type != "" && s.Type != "array" {
+			return fmt.Errorf("%s: ожидался тип %s, получен array", path, s.Type)
+		}
+		if s.MinItems != nil && len(val) < *s.MinItems {
+			return fmt.Errorf("%s: элементов меньше %d", path, *s.MinItems)
+		}
+		if s.MaxItems != nil && len(val) > *s.MaxItems {
+			return fmt.Errorf("%s: элементов больше %d", path, *s.MaxItems)
+		}
+		for i, item := range val {
+			if err := s.Items.validateValue(item, fmt.Sprintf("%s[%d]", path, i), schemas); err != nil {
+				return err
+			}
+		}
+		return nil
+	case json.Number:
+		switch s.Type {
+		case "", "number":
+		case "integer":
+			if _, err := val.Int64(); err != nil {
+				return fmt.Errorf("%s: ожидалось целое число", path)
+			}
+		default:
+			return fmt.Errorf("%s: ожидался тип %s, получено число", path, s.Type)
+		}
+		n, err := val.Float64()
+		if err != nil {
+			return fmt.Errorf("%s: некорректное число", path)
+		}
+		if err := s.validateNumber(n, val.String()); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		return nil
+	case bool:
+		if s.Type != "" && s.Type != "boolean" {
+			return fmt.Errorf("%s: ожидался тип %s, получен boolean", path, s.Type)
+		}
+		return nil
+	case string:
+		if s.Type != "" && s.Type != "string" {
+			return fmt.Errorf("%s: ожидался тип %s, получена строка", path, s.Type)
+		}
+		if err := s.validateString(val); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("%s: неподдерживаемое значение", path)
+}
+
+// validateObject проверяет обязательные, известные и дополнительные свойства объекта
+func (s *jsonSchema) validateObject(obj map[string]interface{}, path string, schemas map[string]*jsonSchema) error {
+	for _, name := range s.Required {
+		if _, ok := obj[name]; !ok {
+			return fmt.Errorf("%s: отсутствует обязательное поле %s", path, name)
+		}
+	}
+
+	var additional *jsonSchema
+	allowAdditional := true
+	if raw := strings.TrimSpace(string(s.AdditionalProperties)); raw == "false" {
+		allowAdditional = false
+	} else if raw != "" && raw != "true" {
+		additional = &jsonSchema{}
+		if err := json.Unmarshal(s.AdditionalProperties, additional); err != nil {
+			additional = nil
+		}
+	}
+
+	for name, value := range obj {
+		prop, known := s.Properties[name]
+		if !known {
+			if !allowAdditional {
+				return fmt.Errorf("%s: недопустимое поле %s", path, name)
+			}
+			prop = additional
+		}
+		if err := prop.validateValue(value, path+"."+name, schemas); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateJSONBody декодирует JSON и проверяет его по схеме
+func (s *jsonSchema) validateJSONBody(body []byte, schemas map[string]*jsonSchema) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return fmt.Errorf("некорректный JSON: %v", err)
+	}
+	if dec.More() {
+		return fmt.Errorf("лишние данные после JSON")
+	}
+	return s.validateValue(v, "$", schemas)
+}
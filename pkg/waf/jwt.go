@@ -0,0 +1,547 @@
+package waf
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256" // crypto.SHA256 для jwtAlgorithms
+	_ "crypto/sha512" // crypto.SHA384, crypto.SHA512
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwtClaimsKey ключ контекста запроса с проверенными claims (JWT или ответ интроспекции)
+type jwtClaimsKey struct{}
+
+// JWTClaims возвращает claims проверенного токена из контекста запроса (nil, если токена нет)
+func JWTClaims(r *http.Request) map[string]interface{} {
+	claims, _ := r.Context().Value(jwtClaimsKey{}).(map[string]interface{})
+	return claims
+}
+
+// jwtHeader заголовок JWT
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// jwk ключ из JWKS
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// jwtAlgorithm семейство и хеш алгоритма подписи; для ES — кривая ключа
+type jwtAlgorithm struct {
+	family string // HS, RS, PS, ES
+	hash   crypto.Hash
+	curve  elliptic.Curve
+}
+
+// jwtAlgorithms поддерживаемые алгоритмы; другие значения alg отклоняются
+var jwtAlgorithms = map[string]jwtAlgorithm{
+	"HS256": {"HS", crypto.SHA256, nil},
+	"HS384": {"HS", crypto.SHA384, nil},
+	"HS512": {"HS", crypto.SHA512, nil},
+	"RS256": {"RS", crypto.SHA256, nil},
+	"RS384": {"RS", crypto.SHA384, nil},
+	"RS512": {"RS", crypto.SHA512, nil},
+	"PS256": {"PS", crypto.SHA256, nil},
+	"PS384": {"PS", crypto.SHA384, nil},
+	"PS512": {"PS", crypto.SHA512, nil},
+	"ES256": {"ES", crypto.SHA256, elliptic.P256()},
+	"ES384": {"ES", crypto.SHA384, elliptic.P384()},
+	"ES512": {"ES", crypto.SHA512, elliptic.P521()},
+}
+
+// jwksRetryInterval неизвестный kid или ошибка загрузки вызывают новую загрузку не чаще
+const jwksRetryInterval = time.Minute
+
+// jwksCache загруженные по JWKS URL ключи с периодическим обновлением. Одновременно
+// выполняется одна загрузка, остальные запросы ждут ее результата
+type jwksCache struct {
+	url       string
+	refresh   time.Duration
+	mu        sync.RWMutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time     // последняя успешная загрузка
+	attemptAt time.Time     // начало последней загрузки
+	inflight  chan struct{} // закрывается по окончании текущей загрузки; nil — загрузки нет
+}
+
+// JWTMiddleware проверяет подпись, срок действия, аудиторию и издателя JWT
+// и отвечает 401 до обращения к бэкенду. alg: none всегда отклоняется.
+// Claims проверенного токена доступны последующим middleware через JWTClaims.
+type JWTMiddleware struct {
+	waf           *WAF
+	routes        []routePattern
+	optional      bool
+	cookieName    string
+	algorithms    map[string]bool
+	hmacSecret    []byte
+	staticKeys    map[string]crypto.PublicKey // kid -> ключ; "" — ключ по умолчанию
+	jwks          *jwksCache
+	audience      string
+	issuer        string
+	leeway        time.Duration
+	forwardClaims map[string]string
+	logDetections bool
+}
+
+// NewJWTMiddlewareWithConfig создает JWT middleware из конфига
+func NewJWTMiddlewareWithConfig(w *WAF, cfg JWTConfig) (*JWTMiddleware, error) {
+	m := &JWTMiddleware{
+		waf:           w,
+		optional:      cfg.Optional,
+		cookieName:    cfg.CookieName,
+		algorithms:    make(map[string]bool),
+		staticKeys:    make(map[string]crypto.PublicKey),
+		audience:      cfg.Audience,
+		issuer:        cfg.Issuer,
+		leeway:        30 * time.Second,
+		forwardClaims: cfg.ForwardClaims,
+		logDetections: true,
+	}
+	for _, p := range cfg.Routes {
+		m.routes = append(m.routes, compileRoutePattern(p))
+	}
+	algs := cfg.Algorithms
+	if len(algs) == 0 {
+		algs = []string{"RS256", "ES256"}
+	}
+	for _, a := range algs {
+		if strings.EqualFold(a, "none") {
+			return nil, errors.New("jwt: alg none is not allowed")
+		}
+		a = strings.ToUpper(a)
+		if _, ok := jwtAlgorithms[a]; !ok {
+			return nil, fmt.Errorf("jwt: unsupported alg %q", a)
+		}
+		m.algorithms[a] = true
+	}
+	if cfg.LeewaySeconds > 0 {
+		m.leeway = time.Duration(cfg.LeewaySeconds) * time.Second
+	}
+	if cfg.HMACSecret != "" {
+		m.hmacSecret = []byte(cfg.HMACSecret)
+	}
+	for kid, path := range cfg.PublicKeyFiles {
+		key, err := loadPublicKeyPEM(path)
+		if err != nil {
+			return nil, fmt.Errorf("jwt key %s: %w", path, err)
+		}
+		m.staticKeys[kid] = key
+	}
+	if cfg.JWKSURL != "" {
+		refresh := time.Hour
+		if cfg.JWKSRefreshSeconds > 0 {
+			refresh = time.Duration(cfg.JWKSRefreshSeconds) * time.Second
+		}
+		// Ключи загружаются один раз и сохраняются при перезагрузке конфигурации
+		m.jwks = w.keep(fmt.Sprintf("jwt.jwks|%s|%s", cfg.JWKSURL, refresh), func() interface{} {
+			c := &jwksCache{url: cfg.JWKSURL, refresh: refresh}
+			c.load()
+			return c
+		}).(*jwksCache)
+	}
+	if m.hmacSecret == nil && len(m.staticKeys) == 0 && m.jwks == nil {
+		return nil, errors.New("jwt: no verification keys configured")
+	}
+	return m, nil
+}
+
+func (m *JWTMiddleware) push(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.waf == nil || !m.protects(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := ClientIP(r)
+		if m.waf.banned(r, ip) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		// Заголовки с claims выставляет только WAF, клиентские значения удаляются
+		for _, header := range m.forwardClaims {
+			r.Header.Del(header)
+		}
+
+		token := bearerToken(r, m.cookieName)
+		if token == "" {
+			if m.optional {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ev := requestEvent(r, ip, "jwt", SeverityInfo, "block", fmt.Sprintf("Запрос без JWT от %s: %s %s", ip, r.Method, r.URL.Path))
+			m.waf.refuse(w, r, ev, false, func() {
+				w.Header().Set("WWW-Authenticate", `Bearer`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			})
+			return
+		}
+
+		claims, err := m.verify(token)
+		if err != nil {
+			ev := requestEvent(r, ip, "jwt", SeverityWarning, "block", fmt.Sprintf("Недействительный JWT от %s: %s %s: %v", ip, r.Method, r.URL.Path, err))
+			m.waf.refuse(w, r, ev, m.logDetections, func() {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			})
+			return
+		}
+
+		for claim, header := range m.forwardClaims {
+			if v, ok := claims[claim]; ok {
+				r.Header.Set(header, fmt.Sprint(v))
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), jwtClaimsKey{}, claims)))
+	})
+}
+
+// protects проверяет, требуется ли JWT для пути (пустой список маршрутов — все пути)
+func (m *JWTMiddleware) protects(path string) bool {
+	if len(m.routes) == 0 {
+		return true
+	}
+	for _, p := range m.routes {
+		if _, ok := p.match(path); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// bearerToken берет токен из Authorization: Bearer или из cookie
+func bearerToken(r *http.Request, cookieName string) string {
+	if auth := r.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	if cookieName != "" {
+		if c, err := r.Cookie(cookieName); err == nil {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+// verify проверяет подпись и стандартные claims, возвращая claims токена
+func (m *JWTMiddleware) verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.New("malformed header")
+	}
+	var header jwtHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, errors.New("malformed header")
+	}
+	alg := strings.ToUpper(header.Alg)
+	if alg == "" || alg == "NONE" {
+		return nil, errors.New("alg none is not allowed")
+	}
+	if !m.algorithms[alg] {
+		return nil, errors.New("alg " + header.Alg + " is not allowed")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed signature")
+	}
+	if err := m.verifySignature(alg, header.Kid, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("malformed payload")
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.New("malformed payload")
+	}
+	return claims, m.validateClaims(claims)
+}
+
+// validateClaims проверяет exp, nbf, aud и iss
+func (m *JWTMiddleware) validateClaims(claims map[string]interface{}) error {
+	now := time.Now()
+	if exp, ok := claims["exp"].(float64); ok {
+		if now.After(time.Unix(int64(exp), 0).Add(m.leeway)) {
+			return errors.New("token expired")
+		}
+	} else {
+		return errors.New("missing exp claim")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(m.leeway).Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("token not yet valid")
+	}
+	if m.issuer != "" && claims["iss"] != m.issuer {
+		return errors.New("unexpected issuer")
+	}
+	if m.audience != "" {
+		switch aud := claims["aud"].(type) {
+		case string:
+			if aud != m.audience {
+				return errors.New("unexpected audience")
+			}
+		case []interface{}:
+			found := false
+			for _, a := range aud {
+				if a == m.audience {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return errors.New("unexpected audience")
+			}
+		default:
+			return errors.New("missing aud claim")
+		}
+	}
+	return nil
+}
+
+// verifySignature проверяет подпись signingInput алгоритмом alg
+func (m *JWTMiddleware) verifySignature(alg, kid, signingInput string, sig []byte) error {
+	a, ok := jwtAlgorithms[alg]
+	if !ok {
+		return errors.New("unsupported alg " + alg)
+	}
+	hf := a.hash
+
+	if a.family == "HS" {
+		if m.hmacSecret == nil {
+			return errors.New("no HMAC secret configured")
+		}
+		mac := hmac.New(hf.New, m.hmacSecret)
+		mac.Write([]byte(signingInput))
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+
+	key := m.publicKey(kid)
+	if key == nil {
+		return errors.New("unknown key id " + kid)
+	}
+	h := hf.New()
+	h.Write([]byte(signingInput))
+	digest := h.Sum(nil)
+
+	switch a.family {
+	case "RS", "PS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("key type mismatch")
+		}
+		var err error
+		if a.family == "PS" {
+			err = rsa.VerifyPSS(pub, hf, digest, sig, nil)
+		} else {
+			err = rsa.VerifyPKCS1v15(pub, hf, digest, sig)
+		}
+		if err != nil {
+			return errors.New("invalid signature")
+		}
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || pub.Curve != a.curve {
+			return errors.New("key type mismatch")
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return errors.New("invalid signature")
+		}
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return errors.New("invalid signature")
+		}
+	default:
+		return errors.New("unsupported alg " + alg)
+	}
+	return nil
+}
+
+// publicKey ищет ключ по kid в статических ключах и JWKS
+func (m *JWTMiddleware) publicKey(kid string) crypto.PublicKey {
+	if key, ok := m.staticKeys[kid]; ok {
+		return key
+	}
+	if m.jwks != nil {
+		if key := m.jwks.get(kid); key != nil {
+			return key
+		}
+	}
+	if key, ok := m.staticKeys[""]; ok {
+		return key
+	}
+	return nil
+}
+
+// get возвращает ключ JWKS, обновляя набор по истечении refresh или при неизвестном kid
+func (c *jwksCache) get(kid string) crypto.PublicKey {
+	c.mu.Lock()
+	key := c.keys[kid]
+	// Неизвестный kid: ключи могли смениться. Поток токенов с чужими kid не должен
+	// превращаться в поток запросов к IdP, поэтому загрузка не чаще jwksRetryInterval
+	need := (time.Since(c.fetchedAt) > c.refresh || key == nil) && time.Since(c.attemptAt) > jwksRetryInterval
+	wait := c.inflight
+	if need && wait == nil {
+		wait = c.startLocked()
+		go c.finish(wait)
+	}
+	c.mu.Unlock()
+	if key != nil || wait == nil {
+		// Известный ключ используется, пока ключи обновляются в фоне
+		return key
+	}
+	<-wait
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.keys[kid]
+}
+
+// load загружает ключи и ждет окончания загрузки
+func (c *jwksCache) load() {
+	c.mu.Lock()
+	done := c.startLocked()
+	c.mu.Unlock()
+	c.finish(done)
+}
+
+// startLocked отмечает начало загрузки; вызывается под c.mu
+func (c *jwksCache) startLocked() chan struct{} {
+	c.inflight, c.attemptAt = make(chan struct{}), time.Now()
+	return c.inflight
+}
+
+// finish выполняет загрузку и будит ожидающих
+func (c *jwksCache) finish(done chan struct{}) {
+	if err := c.fetch(); err != nil {
+		log.Printf("[WAF] Ошибка загрузки JWKS %s: %v", c.url, err)
+	}
+	c.mu.Lock()
+	c.inflight = nil
+	c.mu.Unlock()
+	close(done)
+}
+
+// fetch загружает набор ключей по URL
+func (c *jwksCache) fetch() error {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(c.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("bad response: " + resp.Status)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return err
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if pub, err := k.publicKey(); err == nil {
+			keys[k.Kid] = pub
+		}
+	}
+	c.mu.Lock()
+	c.keys, c.fetchedAt = keys, time.Now()
+	c.mu.Unlock()
+	return nil
+}
+
+// publicKey преобразует JWK в открытый ключ RSA или EC
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.New("unsupported curve " + k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, errors.New("unsupported key type " + k.Kty)
+}
+
+// loadPublicKeyPEM читает открытый ключ (PKIX или сертификат) из PEM файла
+func loadPublicKeyPEM(path string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data")
+	}
+	if block.Type == "CERTIFICATE" {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
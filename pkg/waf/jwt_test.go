@@ -0,0 +1,140 @@
+package waf
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func jwtPart(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func hs256Token(secret string, header, claims map[string]interface{}) string {
+	input := jwtPart(header) + "." + jwtPart(claims)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(input))
+	return input + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestJWTAlgorithmsConfig(t *testing.T) {
+	for _, algs := range [][]string{{"none"}, {"NONE"}, {"H"}, {""}, {"HS1"}, {"EdDSA"}} {
+		if _, err := NewJWTMiddlewareWithConfig(nil, JWTConfig{Algorithms: algs, HMACSecret: "secret"}); err == nil {
+			t.Errorf("algorithms %q accepted", algs)
+		}
+	}
+	if _, err := NewJWTMiddlewareWithConfig(nil, JWTConfig{Algorithms: []string{"hs256"}, HMACSecret: "secret"}); err != nil {
+		t.Errorf("hs256: %v", err)
+	}
+}
+
+func TestJWTVerifyRejects(t *testing.T) {
+	const secret = "test-secret"
+	m, err := NewJWTMiddlewareWithConfig(nil, JWTConfig{Algorithms: []string{"HS256"}, HMACSecret: secret, Audience: "api", Issuer: "idp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().Unix()
+	hs := map[string]interface{}{"alg": "HS256", "typ": "JWT"}
+	valid := map[string]interface{}{"exp": now + 60, "aud": "api", "iss": "idp"}
+	with := func(k string, v interface{}) map[string]interface{} {
+		c := map[string]interface{}{}
+		for kk, vv := range valid {
+			c[kk] = vv
+		}
+		if v == nil {
+			delete(c, k)
+		} else {
+			c[k] = v
+		}
+		return c
+	}
+	if _, err := m.verify(hs256Token(secret, hs, valid)); err != nil {
+		t.Fatalf("valid token: %v", err)
+	}
+	unsigned := jwtPart(map[string]interface{}{"alg": "none"}) + "." + jwtPart(valid) + "."
+	for _, tc := range []struct {
+		name, token string
+	}{
+		{"alg none", unsigned},
+		{"alg None with signature", hs256Token(secret, map[string]interface{}{"alg": "None"}, valid)},
+		{"alg not allowed", hs256Token(secret, map[string]interface{}{"alg": "HS512"}, valid)},
+		{"short alg", hs256Token(secret, map[string]interface{}{"alg": "H"}, valid)},
+		{"bad signature", hs256Token("other-secret", hs, valid)},
+		{"expired", hs256Token(secret, hs, with("exp", now-3600))},
+		{"missing exp", hs256Token(secret, hs, with("exp", nil))},
+		{"not yet valid", hs256Token(secret, hs, with("nbf", now+3600))},
+		{"wrong audience", hs256Token(secret, hs, with("aud", "other"))},
+		{"wrong issuer", hs256Token(secret, hs, with("iss", "evil"))},
+		{"malformed", "a.b"},
+	} {
+		if _, err := m.verify(tc.token); err == nil {
+			t.Errorf("%s: token accepted", tc.name)
+		}
+	}
+}
+
+func TestJWKSRefetchCoalesced(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coord := func(n *big.Int) string { return base64.RawURLEncoding.EncodeToString(n.FillBytes(make([]byte, 32))) }
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		fmt.Fprintf(rw, `{"keys":[{"kty":"EC","kid":"k1","crv":"P-256","x":%q,"y":%q}]}`, coord(key.X), coord(key.Y))
+	}))
+	defer srv.Close()
+
+	w, err := NewWAF(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewJWTMiddlewareWithConfig(w, JWTConfig{JWKSURL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.jwks.get("k1") == nil {
+		t.Fatal("known kid not loaded")
+	}
+	// Загрузка не сразу после старта: неизвестный kid может вызвать одну загрузку
+	m.jwks.mu.Lock()
+	m.jwks.attemptAt = time.Time{}
+	m.jwks.mu.Unlock()
+	before := hits.Load()
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			m.jwks.get(fmt.Sprintf("unknown-%d", i))
+		}(i)
+	}
+	wg.Wait()
+	if n := hits.Load() - before; n != 1 {
+		t.Errorf("%d JWKS fetches for a burst of unknown kids, want 1", n)
+	}
+	m.jwks.get("unknown-again")
+	if n := hits.Load() - before; n != 1 {
+		t.Errorf("unknown kid refetched within %v", jwksRetryInterval)
+	}
+	// Пересборка цепи не загружает ключи заново
+	if _, err := NewJWTMiddlewareWithConfig(w, JWTConfig{JWKSURL: srv.URL}); err != nil || hits.Load()-before != 1 {
+		t.Errorf("rebuild refetched JWKS: %v", err)
+	}
+}
@@ -0,0 +1,236 @@
+package waf
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Ресурс CRD WAFPolicy
+const (
+	policyAPIGroup    = "waf-lya.io"
+	policyAPIVersion  = "v1alpha1"
+	policyResource    = "wafpolicies"
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+)
+
+// wafPolicy объект WAFPolicy; spec имеет формат файла конфигурации и накладывается поверх него
+type wafPolicy struct {
+	Metadata struct {
+		Name            string `json:"name"`
+		ResourceVersion string `json:"resourceVersion"`
+		Generation      int64  `json:"generation"`
+	} `json:"metadata"`
+	Spec json.RawMessage `json:"spec"`
+}
+
+// policyWatcher следит за объектом WAFPolicy через Kubernetes API и перезагружает WAF
+// при его изменении, так что политика управляется kubectl/GitOps, а не файлом в образе.
+// Каждый экземпляр WAF следит за политикой сам, отдельный контроллер не нужен.
+type policyWatcher struct {
+	waf       *WAF
+	base      *Config // конфигурация из файла
+	apiServer string
+	namespace string
+	name      string
+	tokenFile string
+	client    *http.Client
+	applied   string // resourceVersion примененной политики; пусто — действует base
+}
+
+// newPolicyWatcher настраивает доступ к API из пода (service account) или по api_server из конфига
+func newPolicyWatcher(w *WAF, base *Config, cfg KubernetesConfig) (*policyWatcher, error) {
+	p := &policyWatcher{
+		waf:       w,
+		base:      base,
+		apiServer: strings.TrimRight(cfg.APIServer, "/"),
+		namespace: cfg.Namespace,
+		name:      cfg.Policy,
+		tokenFile: serviceAccountDir + "/token",
+	}
+	if p.apiServer == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, errors.New("kubernetes: api_server is not set and not running in a pod")
+		}
+		p.apiServer = "https://" + net.JoinHostPort(host, port)
+	}
+	if p.namespace == "" {
+		ns, err := os.ReadFile(serviceAccountDir + "/namespace")
+		if err != nil {
+			return nil, fmt.Errorf("kubernetes: namespace: %w", err)
+		}
+		p.namespace = strings.TrimSpace(string(ns))
+	}
+
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if ca, err := os.ReadFile(serviceAccountDir + "/ca.crt"); err == nil {
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(ca)
+		tlsCfg.RootCAs = pool
+	}
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.TLSClientConfig = tlsCfg
+	// Без общего таймаута: watch — долгий потоковый запрос, его длительность задает timeoutSeconds
+	p.client = &http.Client{Transport: tr}
+	return p, nil
+}
+
+// run синхронизирует политику и следит за изменениями; при ошибках повторяет с задержкой
+func (p *policyWatcher) run() {
+	for {
+		rv, err := p.sync()
+		if err == nil {
+			err = p.watch(rv)
+		}
+		if err != nil {
+			log.Printf("[WAF] kubernetes: %v", err)
+			time.Sleep(5 * time.Second)
+		}
+	}
+}
+
+// sync читает текущую политику и возвращает resourceVersion списка для watch
+func (p *policyWatcher) sync() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp, err := p.get(ctx, url.Values{})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var list struct {
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+		Items []wafPolicy `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return "", fmt.Errorf("list %s: %w", policyResource, err)
+	}
+	if len(list.Items) == 0 {
+		p.apply(nil)
+	} else {
+		p.apply(&list.Items[0])
+	}
+	return list.Metadata.ResourceVersion, nil
+}
+
+// watch применяет изменения политики до завершения потока (сервер закрывает его по timeoutSeconds)
+func (p *policyWatcher) watch(rv string) error {
+	q := url.Values{}
+	q.Set("watch", "true")
+	q.Set("resourceVersion", rv)
+	q.Set("timeoutSeconds", "300")
+	resp, err := p.get(context.Background(), q)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var ev struct {
+			Type   string          `json:"type"`
+			Object json.RawMessage `json:"object"`
+		}
+		if err := dec.Decode(&ev); err != nil {
+			return nil // поток закрыт: заново прочитать политику и продолжить
+		}
+		switch ev.Type {
+		case "ADDED", "MODIFIED":
+			var pol wafPolicy
+			if err := json.Unmarshal(ev.Object, &pol); err != nil {
+				return fmt.Errorf("watch %s: %w", policyResource, err)
+			}
+			p.apply(&pol)
+		case "DELETED":
+			p.apply(nil)
+		case "ERROR":
+			// Обычно 410 Gone: resourceVersion устарел, нужен повторный list
+			return nil
+		}
+	}
+}
+
+// get запрашивает объекты WAFPolicy с нужным именем
+func (p *policyWatcher) get(ctx context.Context, q url.Values) (*http.Response, error) {
+	q.Set("fieldSelector", "metadata.name="+p.name)
+	u := fmt.Sprintf("%s/apis/%s/%s/namespaces/%s/%s?%s",
+		p.apiServer, policyAPIGroup, policyAPIVersion, url.PathEscape(p.namespace), policyResource, q.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Токен service account периодически обновляется kubelet, поэтому читается заново
+	if token, err := os.ReadFile(p.tokenFile); err == nil {
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: %s", req.Method, policyResource, resp.Status)
+	}
+	return resp, nil
+}
+
+// apply накладывает политику на конфигурацию из файла и перезагружает цепь модулей.
+// nil — политика удалена, восстанавливается конфигурация из файла.
+func (p *policyWatcher) apply(pol *wafPolicy) {
+	if pol == nil {
+		if p.applied == "" {
+			return
+		}
+		before := p.waf.config()
+		if err := p.waf.reload(p.base, "kubernetes"); err != nil {
+			log.Printf("[WAF] kubernetes: не удалось восстановить конфигурацию из файла: %v", err)
+			return
+		}
+		p.audit(before, p.base)
+		p.applied = ""
+		log.Printf("[WAF] WAFPolicy %s/%s удалена, действует конфигурация из файла", p.namespace, p.name)
+		return
+	}
+	if pol.Metadata.ResourceVersion == p.applied {
+		return
+	}
+
+	before := p.waf.config()
+	cfg, err := mergeConfig(p.base, pol.Spec)
+	if err == nil {
+		err = p.waf.reload(cfg, "kubernetes")
+	}
+	if err != nil {
+		// Ошибочная политика не применяется, продолжает действовать предыдущая
+		log.Printf("[WAF] WAFPolicy %s/%s (generation %d) отклонена: %v", p.namespace, p.name, pol.Metadata.Generation, err)
+		return
+	}
+	p.audit(before, cfg)
+	p.applied = pol.Metadata.ResourceVersion
+	log.Printf("[WAF] Применена WAFPolicy %s/%s (generation %d)", p.namespace, p.name, pol.Metadata.Generation)
+}
+
+// audit записывает смену конфигурации по WAFPolicy в журнал административных действий
+func (p *policyWatcher) audit(before, after *Config) {
+	p.waf.audit.record(AuditRecord{
+		Actor:  "kubernetes",
+		Action: "config_change",
+		Target: "wafpolicy/" + p.namespace + "/" + p.name,
+		Before: redactConfig(before),
+		After:  redactConfig(after),
+	})
+}
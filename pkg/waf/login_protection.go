@@ -0,0 +1,289 @@
+package waf
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxCredentialBodySize сколько байт тела читается для поиска имени пользователя
+const maxCredentialBodySize = 64 << 10
+
+// LoginProtectionMiddleware обнаруживает перебор учетных данных (credential stuffing)
+// на эндпоинтах аутентификации. Считает неудачные входы по IP, по пользователю
+// и по паре IP-пользователь, а также число разных логинов с одного IP.
+// При настроенной блокировке аккаунтов вход в аккаунт временно запрещается
+// после серии неудач с любых IP.
+type LoginProtectionMiddleware struct {
+	waf                *WAF
+	paths              []routePattern
+	usernameFields     []string
+	failureStatuses    map[int]bool
+	window             time.Duration
+	maxFailuresPerIP   int
+	maxFailuresPerUser int
+	maxFailuresPerPair int
+	maxUsernamesPerIP  int
+	action             string // ban, throttle, delay, challenge
+	banDuration        time.Duration
+	delay              time.Duration
+	lockout            *accountLockout
+	logDetections      bool
+}
+
+// NewLoginProtectionMiddleware создает модуль защиты входа с дефолт настройками
+func NewLoginProtectionMiddleware(w *WAF, paths []string) *LoginProtectionMiddleware {
+	m := &LoginProtectionMiddleware{
+		waf:                w,
+		usernameFields:     []string{"username", "login", "email"},
+		failureStatuses:    map[int]bool{http.StatusUnauthorized: true, http.StatusForbidden: true},
+		window:             5 * time.Minute,
+		maxFailuresPerIP:   20,
+		maxFailuresPerUser: 10,
+		maxFailuresPerPair: 5,
+		maxUsernamesPerIP:  5,
+		action:             "ban",
+		banDuration:        10 * time.Minute,
+		delay:              2 * time.Second,
+		logDetections:      true,
+	}
+	for _, p := range paths {
+		m.paths = append(m.paths, compileRoutePattern(p))
+	}
+	return m
+}
+
+// NewLoginProtectionMiddlewareWithConfig создает модуль защиты входа из конфига
+func NewLoginProtectionMiddlewareWithConfig(w *WAF, cfg LoginProtectionConfig) *LoginProtectionMiddleware {
+	m := NewLoginProtectionMiddleware(w, cfg.Paths)
+	if len(cfg.UsernameFields) > 0 {
+		m.usernameFields = cfg.UsernameFields
+	}
+	if len(cfg.FailureStatuses) > 0 {
+		m.failureStatuses = make(map[int]bool, len(cfg.FailureStatuses))
+		for _, code := range cfg.FailureStatuses {
+			m.failureStatuses[code] = true
+		}
+	}
+	if cfg.WindowSeconds > 0 {
+		m.window = time.Duration(cfg.WindowSeconds) * time.Second
+	}
+	if cfg.MaxFailuresPerIP > 0 {
+		m.maxFailuresPerIP = cfg.MaxFailuresPerIP
+	}
+	if cfg.MaxFailuresPerUser > 0 {
+		m.maxFailuresPerUser = cfg.MaxFailuresPerUser
+	}
+	if cfg.MaxFailuresPerPair > 0 {
+		m.maxFailuresPerPair = cfg.MaxFailuresPerPair
+	}
+	if cfg.MaxUsernamesPerIP > 0 {
+		m.maxUsernamesPerIP = cfg.MaxUsernamesPerIP
+	}
+	if cfg.Action != "" {
+		m.action = cfg.Action
+	}
+	if cfg.BanSeconds > 0 {
+		m.banDuration = time.Duration(cfg.BanSeconds) * time.Second
+	}
+	if cfg.DelayMs > 0 {
+		m.delay = time.Duration(cfg.DelayMs) * time.Millisecond
+	}
+	if cfg.AccountLockout.MaxFailures > 0 {
+		m.lockout = newAccountLockout(w, cfg.AccountLockout)
+	}
+	return m
+}
+
+// OnAccountLocked регистрирует хук, вызываемый при блокировке аккаунта
+// (например, чтобы приложение отправило пользователю письмо)
+func (m *LoginProtectionMiddleware) OnAccountLocked(hook func(AccountLockoutEvent)) {
+	if m.lockout != nil {
+		m.lockout.hooks = append(m.lockout.hooks, hook)
+	}
+}
+
+func (m *LoginProtectionMiddleware) push(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.waf == nil || r.Method != http.MethodPost || !m.isLoginPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := ClientIP(r)
+		if m.waf.banned(r, ip) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		user := extractCredentialField(r, m.usernameFields)
+
+		if m.lockout != nil && user != "" {
+			if until := m.lockout.lockedUntil(user); !until.IsZero() {
+				ev := requestEvent(r, ip, "login_protection", SeverityWarning, "throttle", fmt.Sprintf("Попытка входа в заблокированный аккаунт %q от %s", user, ip))
+				m.waf.refuse(w, r, ev, m.logDetections, func() {
+					w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(until).Seconds())+1))
+					http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+				})
+				return
+			}
+		}
+
+		// Проверить накопленные неудачи до передачи запроса бэкенду
+		if reason := m.check(ip, user, false); reason != "" {
+			ev := requestEvent(r, ip, "login_protection", SeverityCritical, m.action, fmt.Sprintf("Подозрение на перебор учетных данных от %s (пользователь %q): %s, действие: %s", ip, user, reason, m.action))
+			if m.waf.decide(r, ev, m.logDetections, func() bool {
+				return enforceAction(w, r, m.waf, ip, m.action, m.banDuration, m.delay)
+			}) {
+				return
+			}
+		}
+
+		rec := newStatusRecorder(w)
+		next.ServeHTTP(rec, r)
+
+		// Учесть неудачную попытку входа
+		if m.failureStatuses[rec.status] {
+			m.waf.reputation.note(ip, reputationLoginFailure)
+			m.check(ip, user, true)
+			if m.lockout != nil && user != "" {
+				m.lockout.recordFailure(ip, user, requestID(r))
+			}
+		}
+	})
+}
+
+// isLoginPath проверяет, относится ли путь к эндпоинтам входа
+func (m *LoginProtectionMiddleware) isLoginPath(path string) bool {
+	for _, p := range m.paths {
+		if _, ok := p.match(path); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// check при record=true учитывает неудачу и возвращает причину срабатывания, если порог превышен
+func (m *LoginProtectionMiddleware) check(ip, user string, record bool) string {
+	ipState := m.waf.states.Get(ip)
+	if ipState == nil {
+		return ""
+	}
+	ipFailures := slidingCount(ipState, "login_failures", m.window, record)
+	if user == "" {
+		if ipFailures > m.waf.reputation.tighten(ip, m.maxFailuresPerIP) {
+			return "превышено число неудачных входов с IP"
+		}
+		return ""
+	}
+	usernames := distinctCount(ipState, "login_usernames", user, m.window, record)
+	userFailures := slidingCount(m.waf.states.Get("login_user:"+user), "login_failures", m.window, record)
+	pairFailures := slidingCount(m.waf.states.Get("login_pair:"+ip+"|"+user), "login_failures", m.window, record)
+
+	switch {
+	case ipFailures > m.waf.reputation.tighten(ip, m.maxFailuresPerIP):
+		return "превышено число неудачных входов с IP"
+	case usernames > m.waf.reputation.tighten(ip, m.maxUsernamesPerIP):
+		return "слишком много разных пользователей с одного IP"
+	case userFailures > m.maxFailuresPerUser:
+		return "превышено число неудачных входов для пользователя"
+	case pairFailures > m.maxFailuresPerPair:
+		return "превышено число неудачных входов для пары IP-пользователь"
+	}
+	return ""
+}
+
+// slidingCount при add=true добавляет событие в журнал key и возвращает число событий в окне
+func slidingCount(st *State, key string, window time.Duration, add bool) int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	now := time.Now()
+
+	var events []time.Time
+	if v, ok := st.Meta[key]; ok {
+		events = v.([]time.Time)
+	}
+	kept := events[:0]
+	for _, t := range events {
+		if now.Sub(t) <= window {
+			kept = append(kept, t)
+		}
+	}
+	if add {
+		kept = append(kept, now)
+	}
+	st.Meta[key] = kept
+	st.LastSeen = now
+	return len(kept)
+}
+
+// distinctCount при add=true отмечает значение и возвращает число разных значений в окне
+func distinctCount(st *State, key, value string, window time.Duration, add bool) int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	now := time.Now()
+
+	var seen map[string]time.Time
+	if v, ok := st.Meta[key]; ok {
+		seen = v.(map[string]time.Time)
+	} else {
+		seen = make(map[string]time.Time)
+	}
+	if add && value != "" {
+		seen[value] = now
+	}
+	for k, t := range seen {
+		if now.Sub(t) > window {
+			delete(seen, k)
+		}
+	}
+	st.Meta[key] = seen
+	st.LastSeen = now
+	return len(seen)
+}
+
+// extractCredentialField ищет значение одного из полей в query, form или JSON теле.
+// Тело читается не более maxCredentialBodySize байт и возвращается в запрос без изменений.
+func extractCredentialField(r *http.Request, fields []string) string {
+	query := r.URL.Query()
+	for _, f := range fields {
+		if v := query.Get(f); v != "" {
+			return normalizeUsername(v)
+		}
+	}
+	head, err := peekBody(r, maxCredentialBodySize)
+	if err != nil || len(head) == 0 {
+		return ""
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json":
+		var body map[string]interface{}
+		if json.Unmarshal(head, &body) != nil {
+			return ""
+		}
+		for _, f := range fields {
+			if v, ok := body[f].(string); ok && v != "" {
+				return normalizeUsername(v)
+			}
+		}
+	case "application/x-www-form-urlencoded":
+		form := formValues(r, head)
+		for _, f := range fields {
+			if v := form.Get(f); v != "" {
+				return normalizeUsername(v)
+			}
+		}
+	}
+	return ""
+}
+
+// normalizeUsername приводит имя пользователя к каноничному виду для подсчета
+func normalizeUsername(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
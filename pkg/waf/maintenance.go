@@ -0,0 +1,156 @@
+package waf
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const defaultMaintenancePage = `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Технические работы</title></head>
+<body><h1>Ведутся технические работы</h1><p>Сервис скоро снова будет доступен.</p></body></html>
+`
+
+// maintenanceMode режим обслуживания: запросы к выбранным маршрутам получают статический
+// ответ и не передаются ни цепи модулей, ни бэкенду. Клиенты из allow проходят как обычно.
+type maintenanceMode struct {
+	routes     []routePattern // пусто — все маршруты
+	allow      []netip.Prefix
+	status     int
+	retryAfter string
+	html       []byte
+	json       []byte
+}
+
+// newMaintenanceMode nil — режим выключен
+func newMaintenanceMode(cfg MaintenanceConfig) (*maintenanceMode, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	m := &maintenanceMode{status: http.StatusServiceUnavailable}
+	if cfg.Status != 0 {
+		if cfg.Status < 200 || cfg.Status > 599 {
+			return nil, fmt.Errorf("invalid status %d", cfg.Status)
+		}
+		m.status = cfg.Status
+	}
+	if cfg.RetryAfterSeconds > 0 {
+		m.retryAfter = strconv.Itoa(cfg.RetryAfterSeconds)
+	}
+	for _, p := range cfg.Routes {
+		m.routes = append(m.routes, compileRoutePattern(p))
+	}
+	for _, s := range cfg.Allow {
+		p, err := parsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("allow %q: %w", s, err)
+		}
+		m.allow = append(m.allow, p)
+	}
+	if len(cfg.JSON) > 0 {
+		if !json.Valid(cfg.JSON) {
+			return nil, fmt.Errorf("json: invalid JSON")
+		}
+		m.json = cfg.JSON
+	}
+	switch {
+	case cfg.HTMLFile != "":
+		data, err := os.ReadFile(cfg.HTMLFile)
+		if err != nil {
+			return nil, fmt.Errorf("html_file: %w", err)
+		}
+		m.html = data
+	case cfg.HTML != "":
+		m.html = []byte(cfg.HTML)
+	case m.json == nil:
+		m.html = []byte(defaultMaintenancePage)
+	}
+	return m, nil
+}
+
+// serve отвечает статической страницей, если запрос попадает под режим обслуживания
+func (m *maintenanceMode) serve(w http.ResponseWriter, r *http.Request) bool {
+	if len(m.routes) > 0 {
+		matched := false
+		for _, p := range m.routes {
+			if _, ok := p.match(r.URL.Path); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(m.allow) > 0 {
+		if a, err := netip.ParseAddr(ClientIP(r)); err == nil {
+			a = a.Unmap()
+			for _, p := range m.allow {
+				if p.Contains(a) {
+					return false
+				}
+			}
+		}
+	}
+
+	body, contentType := m.html, "text/html; charset=utf-8"
+	if m.json != nil && (m.html == nil || prefersJSON(r)) {
+		body, contentType = m.json, "application/json"
+	}
+	h := w.Header()
+	h.Set("Content-Type", contentType)
+	h.Set("Cache-Control", "no-store")
+	if m.retryAfter != "" {
+		h.Set("Retry-After", m.retryAfter)
+	}
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(m.status)
+	if r.Method != http.MethodHead {
+		w.Write(body)
+	}
+	return true
+}
+
+// prefersJSON клиент API: принимает JSON и не принимает HTML
+func prefersJSON(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "json") && !strings.Contains(accept, "text/html")
+}
+
+// Maintenance сообщает, включен ли режим обслуживания
+func (w *WAF) Maintenance() bool {
+	return w.maintenance.Load() != nil
+}
+
+// SetMaintenance включает или выключает режим обслуживания, сохраняя остальные настройки
+// секции maintenance, и перезагружает конфигурацию
+func (w *WAF) SetMaintenance(enabled bool) error {
+	patch, _ := json.Marshal(map[string]interface{}{"maintenance": map[string]bool{"enabled": enabled}})
+	cfg, err := mergeConfig(w.config(), patch)
+	if err != nil {
+		return err
+	}
+	return w.reload(cfg, "maintenance")
+}
+
+// adminMaintenance переключает режим обслуживания: {"enabled": true}
+func (w *WAF) adminMaintenance(rw http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(rw, r.Body, 4096)).Decode(&req); err != nil || req.Enabled == nil {
+		writeJSON(rw, http.StatusBadRequest, map[string]string{"error": "enabled is required"})
+		return
+	}
+	before := w.Maintenance()
+	if err := w.SetMaintenance(*req.Enabled); err != nil {
+		writeJSON(rw, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+		return
+	}
+	w.auditRequest(r, "maintenance", "", before, w.Maintenance())
+	writeJSON(rw, http.StatusOK, map[string]bool{"enabled": w.Maintenance()})
+}
@@ -0,0 +1,197 @@
+package waf
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// defaultPatternBackend движок сопоставления шаблонов по умолчанию
+const defaultPatternBackend = "regexp"
+
+// patternSet скомпилированный набор шаблонов, проверяемый одним вызовом
+type patternSet interface {
+	// match сообщает, совпал ли со строкой хотя бы один шаблон набора
+	match(s string) bool
+}
+
+// patternBackend компилирует набор: literal — подстроки, иначе регулярные выражения RE2
+type patternBackend func(patterns []string, literal bool) (patternSet, error)
+
+// patternBackends движки сопоставления по имени из конфига (signature.matcher_backend)
+var patternBackends = map[string]patternBackend{
+	"regexp":      compileRegexpSet,
+	"ahocorasick": compileHybridSet,
+	"hyperscan":   compileHyperscanSet,
+}
+
+// compilePatternSet компилирует набор выбранным движком
+func compilePatternSet(backend string, patterns []string, literal bool) (patternSet, error) {
+	if backend == "" {
+		backend = defaultPatternBackend
+	}
+	compile, ok := patternBackends[backend]
+	if !ok {
+		return nil, fmt.Errorf("unknown matcher backend %q", backend)
+	}
+	return compile(nonEmpty(patterns), literal)
+}
+
+func nonEmpty(patterns []string) []string {
+	out := make([]string, 0, len(patterns))
+	for _, p := range patterns {
+		if p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// regexpSet шаблоны из подстрок и простых альтернатив проверяются автоматом подстрок,
+// остальные выражения — заранее скомпилированными regexp по очереди
+type regexpSet struct {
+	*plannedPatterns
+}
+
+func compileRegexpSet(patterns []string, literal bool) (patternSet, error) {
+	return &regexpSet{planPatterns(patterns, literal)}, nil
+}
+
+func (s *regexpSet) match(v string) bool {
+	if s.matchLiterals(v) {
+		return true
+	}
+	for _, re := range s.regexps {
+		if re.MatchString(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// hybridSet как regexpSet, но сложные выражения объединяются в одну альтернативу RE2,
+// и строка проверяется одним проходом автомата и одним регулярным выражением.
+// Время проверки почти не зависит от числа шаблонов, что важно для наборов в тысячи правил.
+type hybridSet struct {
+	*plannedPatterns
+	combined *regexp.Regexp
+}
+
+func compileHybridSet(patterns []string, literal bool) (patternSet, error) {
+	s := &hybridSet{plannedPatterns: planPatterns(patterns, literal)}
+	if len(s.regexps) == 0 {
+		return s, nil
+	}
+	parts := make([]string, len(s.regexps))
+	for i, re := range s.regexps {
+		parts[i] = "(?:" + re.String() + ")"
+	}
+	combined, err := regexp.Compile(strings.Join(parts, "|"))
+	if err != nil {
+		return nil, err
+	}
+	s.combined = combined
+	return s, nil
+}
+
+func (s *hybridSet) match(v string) bool {
+	if s.matchLiterals(v) {
+		return true
+	}
+	return s.combined != nil && s.combined.MatchString(v)
+}
+
+// patternPlanner набор, который может сообщить план компиляции
+type patternPlanner interface {
+	compiledPlan() patternPlan
+}
+
+func (p *plannedPatterns) compiledPlan() patternPlan { return p.plan }
+
+// ahoCorasick автомат поиска множества подстрок
+type ahoCorasick struct {
+	nodes []acNode
+}
+
+type acNode struct {
+	next map[byte]int32
+	fail int32
+	out  bool // в этом узле (или по ссылкам fail) заканчивается шаблон
+}
+
+func newAhoCorasick(patterns []string) *ahoCorasick {
+	ac := &ahoCorasick{nodes: []acNode{{next: map[byte]int32{}}}}
+	for _, p := range patterns {
+		cur := int32(0)
+		for i := 0; i < len(p); i++ {
+			nxt, ok := ac.nodes[cur].next[p[i]]
+			if !ok {
+				nxt = int32(len(ac.nodes))
+				ac.nodes = append(ac.nodes, acNode{next: map[byte]int32{}})
+				ac.nodes[cur].next[p[i]] = nxt
+			}
+			cur = nxt
+		}
+		ac.nodes[cur].out = true
+	}
+
+	// Ссылки fail строятся обходом в ширину; ребра перебираются в порядке байтов,
+	// чтобы автомат не зависел от порядка обхода map
+	queue := []int32{}
+	for _, b := range sortedEdges(ac.nodes[0].next) {
+		queue = append(queue, ac.nodes[0].next[b])
+	}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for _, b := range sortedEdges(ac.nodes[cur].next) {
+			child := ac.nodes[cur].next[b]
+			f := ac.nodes[cur].fail
+			for f != 0 {
+				if _, ok := ac.nodes[f].next[b]; ok {
+					break
+				}
+				f = ac.nodes[f].fail
+			}
+			if nxt, ok := ac.nodes[f].next[b]; ok && nxt != child {
+				ac.nodes[child].fail = nxt
+			}
+			ac.nodes[child].out = ac.nodes[child].out || ac.nodes[ac.nodes[child].fail].out
+			queue = append(queue, child)
+		}
+	}
+	return ac
+}
+
+func sortedEdges(next map[byte]int32) []byte {
+	keys := make([]byte, 0, len(next))
+	for b := range next {
+		keys = append(keys, b)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+func (ac *ahoCorasick) match(s string) bool {
+	if len(ac.nodes) == 1 {
+		return false
+	}
+	cur := int32(0)
+	for i := 0; i < len(s); i++ {
+		for {
+			if nxt, ok := ac.nodes[cur].next[s[i]]; ok {
+				cur = nxt
+				break
+			}
+			if cur == 0 {
+				break
+			}
+			cur = ac.nodes[cur].fail
+		}
+		if ac.nodes[cur].out {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+//go:build hyperscan
+
+package waf
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/flier/gohs/hyperscan"
+)
+
+// hyperscanSupported WAF собран с поддержкой Hyperscan
+const hyperscanSupported = true
+
+// hyperscanSet набор шаблонов в одной базе Hyperscan: все шаблоны проверяются
+// за один векторизованный проход. Scratch-пространство не потокобезопасно,
+// поэтому каждому сканированию выдается копия из пула.
+type hyperscanSet struct {
+	db      hyperscan.BlockDatabase
+	scratch sync.Pool
+}
+
+func compileHyperscanSet(patterns []string, literal bool) (patternSet, error) {
+	s := &hyperscanSet{}
+	var hsPatterns []*hyperscan.Pattern
+	for _, p := range patterns {
+		expr := p
+		if literal {
+			expr = regexp.QuoteMeta(p)
+		} else if _, err := regexp.Compile(p); err != nil {
+			// Как и в остальных движках, невалидный для RE2 шаблон пропускается
+			continue
+		}
+		hp := hyperscan.NewPattern(expr, hyperscan.SingleMatch)
+		hp.Id = len(hsPatterns)
+		hsPatterns = append(hsPatterns, hp)
+	}
+	if len(hsPatterns) == 0 {
+		return s, nil
+	}
+	db, err := hyperscan.NewBlockDatabase(hsPatterns...)
+	if err != nil {
+		return nil, fmt.Errorf("hyperscan: %w", err)
+	}
+	proto, err := hyperscan.NewScratch(db)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("hyperscan scratch: %w", err)
+	}
+	s.db = db
+	s.scratch.New = func() any {
+		sc, err := proto.Clone()
+		if err != nil {
+			return nil
+		}
+		return sc
+	}
+	return s, nil
+}
+
+func (s *hyperscanSet) match(v string) bool {
+	if s.db == nil {
+		return false
+	}
+	sc, _ := s.scratch.Get().(*hyperscan.Scratch)
+	if sc == nil {
+		return false
+	}
+	defer s.scratch.Put(sc)
+	matched := false
+	s.db.Scan([]byte(v), sc, func(uint, uint64, uint64, uint, interface{}) error {
+		matched = true
+		// Ненулевая ошибка останавливает сканирование после первого совпадения
+		return hyperscan.ErrScanTerminated
+	}, nil)
+	return matched
+}
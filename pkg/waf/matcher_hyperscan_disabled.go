@@ -0,0 +1,12 @@
+//go:build !hyperscan
+
+package waf
+
+import "errors"
+
+// hyperscanSupported WAF собран без поддержки Hyperscan (нужен тег сборки hyperscan и cgo)
+const hyperscanSupported = false
+
+func compileHyperscanSet([]string, bool) (patternSet, error) {
+	return nil, errors.New("Hyperscan support is not compiled in (build with -tags hyperscan)")
+}
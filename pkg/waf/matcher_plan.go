@@ -0,0 +1,281 @@
+package waf
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"unicode"
+)
+
+const (
+	maxLiteralExpansion = 64 // строк из одного выражения; больше — выражение остается регулярным
+	maxClassExpansion   = 16 // символов в классе [...], который раскрывается в строки
+)
+
+// patternPlan как набор шаблонов разложен при компиляции: выражения из одних литералов,
+// классов символов и альтернатив объединяются в автомат подстрок, остальные проверяются
+// регулярными выражениями по отдельности
+type patternPlan struct {
+	patterns int // шаблонов в наборе, включая невалидные
+	merged   int // объединены в автомат
+	strings  int // строк в автоматах
+	regexps  int // проверяются регулярными выражениями
+	invalid  int // пропущены как невалидные
+}
+
+func (p patternPlan) String() string {
+	return fmt.Sprintf("%d шаблонов: %d объединены в автомат (%d строк), %d регулярных выражений, %d невалидных",
+		p.patterns, p.merged, p.strings, p.regexps, p.invalid)
+}
+
+// plannedPatterns результат разбора набора: автоматы с учетом и без учета регистра
+// и выражения, которые нельзя свести к подстрокам
+type plannedPatterns struct {
+	exact   *ahoCorasick
+	folded  *ahoCorasick // строки и вход приводятся foldASCII
+	regexps []*regexp.Regexp
+	plan    patternPlan
+}
+
+// planPatterns раскладывает набор; literal — все шаблоны являются подстроками
+func planPatterns(patterns []string, literal bool) *plannedPatterns {
+	p := &plannedPatterns{plan: patternPlan{patterns: len(patterns)}}
+	var exact, folded []string
+	if literal {
+		exact = patterns
+		p.plan.merged = len(patterns)
+	} else {
+		for _, pattern := range patterns {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				log.Printf("[WAF] Невалидный паттерн %q пропущен: %v", pattern, err)
+				p.plan.invalid++
+				continue
+			}
+			lits, mode, ok := regexpLiterals(pattern)
+			if !ok {
+				p.regexps = append(p.regexps, re)
+				continue
+			}
+			p.plan.merged++
+			if mode == caseFold {
+				for _, l := range lits {
+					folded = append(folded, foldASCII(l))
+				}
+			} else {
+				exact = append(exact, lits...)
+			}
+		}
+		p.plan.regexps = len(p.regexps)
+	}
+	exact, folded = dedupe(exact), dedupe(folded)
+	p.plan.strings = len(exact) + len(folded)
+	if len(exact) > 0 {
+		p.exact = newAhoCorasick(exact)
+	}
+	if len(folded) > 0 {
+		p.folded = newAhoCorasick(folded)
+	}
+	return p
+}
+
+// matchLiterals проверяет строку автоматами подстрок
+func (p *plannedPatterns) matchLiterals(s string) bool {
+	if p.exact != nil && p.exact.match(s) {
+		return true
+	}
+	return p.folded != nil && p.folded.match(foldASCII(s))
+}
+
+func dedupe(ss []string) []string {
+	seen := make(map[string]bool, len(ss))
+	out := ss[:0:0]
+	for _, s := range ss {
+		if s != "" && !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// literalCase требование выражения к регистру
+type literalCase int
+
+const (
+	caseAny   literalCase = iota // в выражении нет букв, зависящих от регистра
+	caseExact                    // регистр важен
+	caseFold                     // (?i): регистр не важен
+)
+
+func mergeCase(a, b literalCase) (literalCase, bool) {
+	switch {
+	case a == caseAny:
+		return b, true
+	case b == caseAny || a == b:
+		return a, true
+	}
+	return 0, false
+}
+
+// regexpLiterals раскрывает выражение в конечный набор подстрок, если оно состоит только
+// из литералов, небольших классов символов, групп и альтернатив без якорей и повторов
+func regexpLiterals(pattern string) ([]string, literalCase, bool) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil, 0, false
+	}
+	lits, mode, ok := expandLiterals(re.Simplify())
+	if !ok || len(lits) == 0 {
+		return nil, 0, false
+	}
+	for _, l := range lits {
+		if l == "" {
+			// Пустая альтернатива совпадает с любой строкой
+			return nil, 0, false
+		}
+	}
+	return lits, mode, true
+}
+
+func expandLiterals(re *syntax.Regexp) ([]string, literalCase, bool) {
+	switch re.Op {
+	case syntax.OpLiteral:
+		s := string(re.Rune)
+		if re.Flags&syntax.FoldCase == 0 {
+			if hasCase(re.Rune) {
+				return []string{s}, caseExact, true
+			}
+			return []string{s}, caseAny, true
+		}
+		for _, r := range re.Rune {
+			if !foldable(r) {
+				return nil, 0, false
+			}
+		}
+		if hasCase(re.Rune) {
+			return []string{s}, caseFold, true
+		}
+		return []string{s}, caseAny, true
+
+	case syntax.OpCharClass:
+		var runes []rune
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			for r := re.Rune[i]; r <= re.Rune[i+1]; r++ {
+				if len(runes) == maxClassExpansion {
+					return nil, 0, false
+				}
+				runes = append(runes, r)
+			}
+		}
+		out := make([]string, len(runes))
+		for i, r := range runes {
+			out[i] = string(r)
+		}
+		if !hasCase(runes) {
+			return out, caseAny, true
+		}
+		// Класс, замкнутый относительно регистра ([Aa]), подходит и для (?i), и для точного сравнения
+		if foldClosed(runes) {
+			return out, caseAny, true
+		}
+		return out, caseExact, true
+
+	case syntax.OpCapture:
+		return expandLiterals(re.Sub[0])
+
+	case syntax.OpConcat:
+		res, mode := []string{""}, caseAny
+		for _, sub := range re.Sub {
+			lits, m, ok := expandLiterals(sub)
+			if !ok {
+				return nil, 0, false
+			}
+			if mode, ok = mergeCase(mode, m); !ok || len(res)*len(lits) > maxLiteralExpansion {
+				return nil, 0, false
+			}
+			next := make([]string, 0, len(res)*len(lits))
+			for _, prefix := range res {
+				for _, l := range lits {
+					next = append(next, prefix+l)
+				}
+			}
+			res = next
+		}
+		return res, mode, true
+
+	case syntax.OpAlternate:
+		var res []string
+		mode := caseAny
+		for _, sub := range re.Sub {
+			lits, m, ok := expandLiterals(sub)
+			if !ok {
+				return nil, 0, false
+			}
+			if mode, ok = mergeCase(mode, m); !ok || len(res)+len(lits) > maxLiteralExpansion {
+				return nil, 0, false
+			}
+			res = append(res, lits...)
+		}
+		return res, mode, true
+	}
+	return nil, 0, false
+}
+
+// hasCase есть ли среди символов буквы, зависящие от регистра
+func hasCase(runes []rune) bool {
+	for _, r := range runes {
+		if unicode.SimpleFold(r) != r {
+			return true
+		}
+	}
+	return false
+}
+
+// foldable все варианты символа без учета регистра совпадают после foldASCII
+func foldable(r rune) bool {
+	want := foldASCII(string(r))
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		if foldASCII(string(f)) != want {
+			return false
+		}
+	}
+	return true
+}
+
+// foldClosed класс содержит все варианты регистра своих символов, и они совпадают после foldASCII
+func foldClosed(runes []rune) bool {
+	in := make(map[rune]bool, len(runes))
+	for _, r := range runes {
+		in[r] = true
+	}
+	for _, r := range runes {
+		if !foldable(r) {
+			return false
+		}
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			if !in[f] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// foldASCII приводит ASCII буквы к нижнему регистру; знак Кельвина и длинная s, которые
+// (?i) в RE2 считает вариантами k и s, заменяются на эти буквы
+func foldASCII(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		case r == '\u212a':
+			return 'k'
+		case r == '\u017f':
+			return 's'
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,256 @@
+package waf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+	"os"
+)
+
+// mmdbMetadataMarker начало метаданных в конце файла MaxMind DB
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// mmdbReader читает базы формата MaxMind DB (GeoLite2, GeoIP2, DB-IP и совместимые).
+// Файл целиком загружается в память; чтение безопасно из нескольких горутин.
+type mmdbReader struct {
+	data       []byte // дерево поиска
+	section    []byte // раздел данных
+	nodeCount  uint32
+	recordSize int
+	ipVersion  int
+	dbType     string
+	ipv4Start  uint32 // узел IPv4-адресов в дереве IPv6 (::/96)
+}
+
+func openMMDB(path string) (*mmdbReader, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseMMDB(buf)
+}
+
+func parseMMDB(buf []byte) (*mmdbReader, error) {
+	i := bytes.LastIndex(buf, mmdbMetadataMarker)
+	if i < 0 {
+		return nil, errors.New("mmdb: metadata not found")
+	}
+	meta, _, err := (&mmdbDecoder{buf: buf[i+len(mmdbMetadataMarker):]}).decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("mmdb: metadata: %w", err)
+	}
+	m, ok := meta.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("mmdb: metadata is not a map")
+	}
+	r := &mmdbReader{}
+	nodes, _ := m["node_count"].(uint64)
+	size, _ := m["record_size"].(uint64)
+	version, _ := m["ip_version"].(uint64)
+	r.dbType, _ = m["database_type"].(string)
+	r.nodeCount, r.recordSize, r.ipVersion = uint32(nodes), int(size), int(version)
+	if r.recordSize != 24 && r.recordSize != 28 && r.recordSize != 32 {
+		return nil, fmt.Errorf("mmdb: unsupported record size %d", r.recordSize)
+	}
+	if r.ipVersion != 4 && r.ipVersion != 6 {
+		return nil, fmt.Errorf("mmdb: unsupported ip version %d", r.ipVersion)
+	}
+	treeSize := int(r.nodeCount) * r.recordSize / 4
+	if treeSize+16 > i {
+		return nil, errors.New("mmdb: search tree exceeds file size")
+	}
+	r.data, r.section = buf[:treeSize], buf[treeSize+16:i]
+
+	if r.ipVersion == 6 {
+		node := uint32(0)
+		for b := 0; b < 96 && node < r.nodeCount; b++ {
+			node = r.record(node, 0)
+		}
+		r.ipv4Start = node
+	}
+	return r, nil
+}
+
+// record левая (bit=0) или правая запись узла
+func (r *mmdbReader) record(node uint32, bit int) uint32 {
+	switch r.recordSize {
+	case 24:
+		b := r.data[node*6+uint32(bit)*3:]
+		return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+	case 28:
+		b := r.data[node*7:]
+		if bit == 0 {
+			return uint32(b[3]&0xf0)<<20 | uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+		}
+		return uint32(b[3]&0x0f)<<24 | uint32(b[4])<<16 | uint32(b[5])<<8 | uint32(b[6])
+	default:
+		return binary.BigEndian.Uint32(r.data[node*8+uint32(bit)*4:])
+	}
+}
+
+// lookup возвращает запись для адреса; nil — адреса нет в базе
+func (r *mmdbReader) lookup(addr netip.Addr) (interface{}, error) {
+	addr = addr.Unmap()
+	var ip []byte
+	node := uint32(0)
+	switch {
+	case addr.Is4() && r.ipVersion == 6:
+		a := addr.As4()
+		ip, node = a[:], r.ipv4Start
+	case addr.Is4():
+		a := addr.As4()
+		ip = a[:]
+	case r.ipVersion == 4:
+		return nil, nil
+	default:
+		a := addr.As16()
+		ip = a[:]
+	}
+	for i := 0; i < len(ip)*8 && node < r.nodeCount; i++ {
+		node = r.record(node, int(ip[i/8]>>(7-i%8)&1))
+	}
+	if node <= r.nodeCount {
+		return nil, nil
+	}
+	offset := int(node-r.nodeCount) - 16
+	if offset < 0 || offset >= len(r.section) {
+		return nil, errors.New("mmdb: invalid data pointer")
+	}
+	v, _, err := (&mmdbDecoder{buf: r.section}).decode(offset, 0)
+	return v, err
+}
+
+// mmdbDecoder разбирает раздел данных MaxMind DB
+type mmdbDecoder struct {
+	buf []byte
+}
+
+const mmdbMaxDepth = 32
+
+var errMMDBTruncated = errors.New("mmdb: truncated data")
+
+func (d *mmdbDecoder) bytes(off, n int) ([]byte, error) {
+	if n < 0 || off+n > len(d.buf) {
+		return nil, errMMDBTruncated
+	}
+	return d.buf[off : off+n], nil
+}
+
+func (d *mmdbDecoder) uint(off, n int) (uint64, error) {
+	b, err := d.bytes(off, n)
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
+
+// decode разбирает значение по смещению off и возвращает его и смещение следующего значения
+func (d *mmdbDecoder) decode(off, depth int) (interface{}, int, error) {
+	if depth > mmdbMaxDepth {
+		return nil, 0, errors.New("mmdb: data nested too deep")
+	}
+	if off >= len(d.buf) {
+		return nil, 0, errMMDBTruncated
+	}
+	ctrl := d.buf[off]
+	off++
+	typ := int(ctrl >> 5)
+	if typ == 1 {
+		// Указатель: значение лежит по другому смещению раздела данных
+		ss, vvv := int(ctrl>>3&3), uint64(ctrl&7)
+		p, err := d.uint(off, ss+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		switch ss {
+		case 0:
+			p |= vvv << 8
+		case 1:
+			p = (p | vvv<<16) + 2048
+		case 2:
+			p = (p | vvv<<24) + 526336
+		}
+		v, _, err := d.decode(int(p), depth+1)
+		return v, off + ss + 1, err
+	}
+	if typ == 0 {
+		if off >= len(d.buf) {
+			return nil, 0, errMMDBTruncated
+		}
+		typ = 7 + int(d.buf[off])
+		off++
+	}
+	size := int(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		v, err := d.uint(off, n)
+		if err != nil {
+			return nil, 0, err
+		}
+		off += n
+		size = []int{0, 29, 285, 65821}[n] + int(v)
+	}
+
+	switch typ {
+	case 2: // строка UTF-8
+		b, err := d.bytes(off, size)
+		return string(b), off + size, err
+	case 3: // double
+		v, err := d.uint(off, 8)
+		return math.Float64frombits(v), off + 8, err
+	case 4: // байты
+		b, err := d.bytes(off, size)
+		return append([]byte(nil), b...), off + size, err
+	case 5, 6, 9, 10: // uint16, uint32, uint64, uint128 (старшие байты отбрасываются)
+		if size > 8 {
+			off += size - 8
+			size = 8
+		}
+		v, err := d.uint(off, size)
+		return v, off + size, err
+	case 8: // int32
+		v, err := d.uint(off, size)
+		return int64(int32(uint32(v))), off + size, err
+	case 7: // map
+		m := make(map[string]interface{}, size)
+		for i := 0; i < size; i++ {
+			k, next, err := d.decode(off, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, errors.New("mmdb: map key is not a string")
+			}
+			v, next, err := d.decode(next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[key], off = v, next
+		}
+		return m, off, nil
+	case 11: // array
+		a := make([]interface{}, 0, min(size, 1024))
+		for i := 0; i < size; i++ {
+			v, next, err := d.decode(off, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a, off = append(a, v), next
+		}
+		return a, off, nil
+	case 14: // boolean: значение в поле размера
+		return size != 0, off, nil
+	case 15: // float
+		v, err := d.uint(off, 4)
+		return float64(math.Float32frombits(uint32(v))), off + 4, err
+	}
+	return nil, 0, fmt.Errorf("mmdb: unsupported data type %d", typ)
+}
@@ -0,0 +1,1074 @@
+package waf
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Основные типы WAF и инициализация
+
+type Middleware interface {
+	push(h http.Handler) http.Handler
+}
+
+// State хранит состояние для IP/сессии
+type State struct {
+	ID                  string
+	LastSeen            time.Time
+	Limiter             *rate.Limiter
+	Meta                map[string]interface{}
+	RateLimitViolations int        // количесво последовательных блокировок
+	LastViolationTime   time.Time  // последний таймаут блокировку
+	currentLimit        rate.Limit // текущее ограничение
+	currentBurst        int        // ограничение пиковой нагрузки
+	mu                  sync.Mutex
+}
+
+// StateStore хранит состояние клиентов (IP, сессия, аккаунт) и управляет доступом к нему
+type StateStore struct {
+	store sync.Map // map[string]State
+}
+
+func newStateStore() *StateStore { return &StateStore{} }
+
+// Get возвращает состояние клиента, создавая его при первом обращении
+func (s *StateStore) Get(id string) *State {
+	if id == "" {
+		return nil
+	}
+	if v, ok := s.store.Load(id); ok {
+		return v.(*State)
+	}
+	// задать состояние по умолчанию
+	st := &State{
+		ID:       id,
+		LastSeen: time.Now(),
+		Meta:     make(map[string]interface{}),
+	}
+	s.store.Store(id, st)
+	return st
+}
+
+// Len возвращает число отслеживаемых клиентов
+func (s *StateStore) Len() int {
+	n := 0
+	s.store.Range(func(_, _ interface{}) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// BanList хранит временные блокировки.
+type banEntry struct {
+	until time.Time
+}
+
+type BanList struct {
+	m          sync.Map      // map[string]banEntry
+	events     *EventBus     // nil — события банов не публикуются
+	forensics  *forensicLog  // nil — история запросов не добавляется в события банов
+	generation atomic.Uint64 // увеличивается при каждом бане
+}
+
+func newBanList() *BanList { return &BanList{} }
+
+// IsBanned сообщает, заблокирован ли идентификатор
+func (b *BanList) IsBanned(id string) bool {
+	if v, ok := b.m.Load(id); ok {
+		e := v.(banEntry)
+		if time.Now().Before(e.until) {
+			return true
+		}
+		b.m.Delete(id)
+	}
+	return false
+}
+
+// Unban снимает блокировку; false — идентификатор не был заблокирован
+func (b *BanList) Unban(id string) bool {
+	_, ok := b.m.LoadAndDelete(id)
+	return ok
+}
+
+// Active возвращает действующие блокировки и время их окончания
+func (b *BanList) Active() map[string]time.Time {
+	now := time.Now()
+	bans := make(map[string]time.Time)
+	b.m.Range(func(k, v interface{}) bool {
+		if until := v.(banEntry).until; now.Before(until) {
+			bans[k.(string)] = until
+		}
+		return true
+	})
+	return bans
+}
+
+// Ban блокирует идентификатор на время d
+func (b *BanList) Ban(id string, d time.Duration) {
+	b.m.Store(id, banEntry{until: time.Now().Add(d)})
+	b.generation.Add(1)
+	if b.events != nil {
+		fields := map[string]interface{}{"duration_seconds": d.Seconds()}
+		if history := b.forensics.history(id); len(history) > 0 {
+			fields["history"] = history
+		}
+		b.events.Publish(SecurityEvent{
+			Type:     EventBan,
+			Module:   "bans",
+			Severity: SeverityWarning,
+			IP:       id,
+			Action:   "ban",
+			Message:  fmt.Sprintf("Клиент %s заблокирован на %s", id, d),
+			Fields:   fields,
+		})
+	}
+}
+
+// banUntil применяет бан, полученный от другого экземпляра кластера: событие не публикуется,
+// более длинный действующий бан не сокращается
+func (b *BanList) banUntil(id string, until time.Time) {
+	if v, ok := b.m.Load(id); ok && !v.(banEntry).until.Before(until) {
+		return
+	}
+	b.m.Store(id, banEntry{until: until})
+	b.generation.Add(1)
+}
+
+// Главный контейнер WAF: конфиг, состояние, цепь middleware
+type WAF struct {
+	target *url.URL
+	proxy  *httputil.ReverseProxy
+
+	middlewares []Middleware
+	states      *StateStore
+	bans        *BanList
+	challenges  *challenger
+	canonical   *pathCanonicalizer // nil — канонизация путей выключена
+	decoder     *bodyDecoder       // nil — сжатые тела запросов не распаковываются
+	upstreams   *upstreamPool      // nil — единственный бэкенд target
+	sni         []sniBackend       // бэкенды по имени TLS SNI, проверяются до основного
+	timeouts    timeouts
+	cfg         *Config // конфигурация New или последнего Reload; nil — настройки по умолчанию
+	schedules   string  // расписания, наложенные на cfg в текущей цепи
+	authz       authzChain
+	events      *EventBus
+	requests    atomic.Uint64 // запросы, прошедшие через Handler
+	requestIDs  requestIDs
+	stats       *adminStats // nil — панель администратора не запущена
+	audit       *auditLog
+	bundles     *bundleHistory                  // применявшиеся версии конфигурации для отката
+	adminAuth   *adminAuth                      // nil — API администратора без аутентификации
+	profiles    []*profile                      // привязки профилей по порядку; запросы без профиля идут в основную цепь
+	tenants     []*tenant                       // проверяются по порядку; запросы без арендатора идут в основную цепь
+	tenant      string                          // имя арендатора; пусто — основной WAF
+	shadow      atomic.Pointer[shadowRun]       // nil — теневой режим выключен
+	maintenance atomic.Pointer[maintenanceMode] // nil — режим обслуживания выключен
+	standby     atomic.Pointer[standbyReplica]  // не nil — резервный экземпляр, правила не применяются
+	reputation  *reputationBook                 // nil — репутация клиентов не ведется
+	forensics   *forensicLog                    // nil — история запросов клиентов не ведется
+	cluster     *clusterNode                    // nil — баны не передаются другим экземплярам
+	fleet       *fleetRegistry                  // отчеты экземпляров, синхронизирующих конфигурацию с этим
+	canary      *canaryStats                    // счетчики canary-правил по группам клиентов
+	keptMu      sync.Mutex
+	kept        map[string]interface{} // состояние модулей, общее для всех сборок цепи (см. keep)
+	exclusions  []exclusion            // исключения ложных срабатываний текущей конфигурации
+
+	mu         sync.RWMutex  // защищает middlewares и cfg при перезагрузке
+	generation atomic.Uint64 // меняется при изменении цепи; Handler пересобирает цепь
+}
+
+// NewWAF создает инстанс WAF для целевого сервера
+func NewWAF(targetAddr string) (*WAF, error) {
+	target, err := url.Parse(targetAddr)
+	if err != nil {
+		return nil, err
+	}
+	w := &WAF{
+		target:     target,
+		proxy:      httputil.NewSingleHostReverseProxy(target),
+		states:     newStateStore(),
+		bans:       newBanList(),
+		challenges: newChallenger(),
+		canonical:  &pathCanonicalizer{backslashAsSlash: true, logDetections: true},
+		decoder:    &bodyDecoder{maxBytes: defaultMaxDecodedBody, logDetections: true},
+		timeouts:   defaultTimeouts(),
+		events:     newEventBus(),
+		audit:      &auditLog{},
+		bundles:    newBundleHistory(),
+		fleet:      newFleetRegistry(),
+		canary:     newCanaryStats(),
+	}
+	w.bans.events = w.events
+	w.canonical.events = w.events
+	w.decoder.events = w.events
+	w.timeouts.configureProxy(w.proxy)
+	return w, nil
+}
+
+// SetTimeouts настраивает таймауты listener и транспорта к бэкендам.
+// Вызывается до SetUpstreams и запуска сервера.
+func (w *WAF) SetTimeouts(cfg TimeoutsConfig) {
+	w.timeouts.apply(cfg)
+	w.timeouts.configureProxy(w.proxy)
+}
+
+// SetRequestDecompression настраивает распаковку сжатых тел запросов перед цепью middleware
+func (w *WAF) SetRequestDecompression(cfg RequestDecompressionConfig) {
+	if cfg.Disable {
+		w.decoder = nil
+		return
+	}
+	w.decoder = &bodyDecoder{
+		maxBytes:         defaultMaxDecodedBody,
+		allowUnsupported: cfg.AllowUnsupported,
+		logDetections:    true,
+		events:           w.events,
+	}
+	if cfg.MaxBytes > 0 {
+		w.decoder.maxBytes = cfg.MaxBytes
+	}
+}
+
+// SetTransport настраивает пул соединений к бэкендам. Вызывается до SetUpstreams и запуска сервера.
+func (w *WAF) SetTransport(cfg TransportConfig) {
+	w.timeouts.applyTransport(cfg)
+	w.timeouts.configureProxy(w.proxy)
+}
+
+// SetPathCanonicalization настраивает канонизацию путей перед цепью middleware
+func (w *WAF) SetPathCanonicalization(cfg PathCanonicalizationConfig) {
+	if cfg.Disable {
+		w.canonical = nil
+		return
+	}
+	w.canonical = &pathCanonicalizer{
+		backslashAsSlash: !cfg.KeepBackslashes,
+		logDetections:    true,
+		events:           w.events,
+	}
+}
+
+// SetUpstreams распределяет запросы по пулу бэкендов с активными проверками доступности
+func (w *WAF) SetUpstreams(cfg UpstreamsConfig) error {
+	pool, err := newUpstreamPool(cfg, w.timeouts)
+	if err != nil {
+		return err
+	}
+	pool.events = w.events
+	w.upstreams = pool
+	pool.start()
+	return nil
+}
+
+// OnUpstreamChange регистрирует хук, вызываемый при исключении бэкенда из пула и его возврате
+func (w *WAF) OnUpstreamChange(hook func(UpstreamEvent)) {
+	if w.upstreams == nil {
+		return
+	}
+	w.upstreams.mu.Lock()
+	w.upstreams.hooks = append(w.upstreams.hooks, hook)
+	w.upstreams.mu.Unlock()
+}
+
+// RegisterMiddleware добавляет middleware в цепь
+func (w *WAF) RegisterMiddleware(m Middleware) {
+	w.mu.Lock()
+	w.middlewares = append(w.middlewares, m)
+	w.mu.Unlock()
+	w.generation.Add(1)
+}
+
+// States возвращает хранилище состояний клиентов
+func (w *WAF) States() *StateStore { return w.states }
+
+// Bans возвращает список блокировок, общий для всех middleware
+func (w *WAF) Bans() *BanList { return w.bans }
+
+// Handler строит цепь обработчиков перед next (последний зарегистрированный выполняется первым).
+// Канонизация путей выполняется до всех middleware.
+// Если next равен nil, запросы передаются бэкенду (server_address или пул upstreams).
+// Цепь пересобирается при RegisterMiddleware и Reload, поэтому обработчик можно получить один раз.
+func (w *WAF) Handler(next http.Handler) http.Handler {
+	if next == nil {
+		next = w.backend()
+	}
+	return &liveChain{waf: w, next: markUpstream(next)}
+}
+
+// chain собирает текущую цепь обработчиков перед next и цепи профилей
+func (w *WAF) chain(next http.Handler) *builtChain {
+	w.mu.RLock()
+	middlewares, profiles := w.middlewares, w.profiles
+	w.mu.RUnlock()
+
+	b := &builtChain{handler: w.wrap(middlewares, next), profiles: profiles}
+	for _, p := range profiles {
+		b.byProfile = append(b.byProfile, w.wrap(p.middlewares, next))
+	}
+	return b
+}
+
+// wrap оборачивает next модулями (первый в списке выполняется первым), распаковкой тел
+// и канонизацией путей
+func (w *WAF) wrap(middlewares []Middleware, next http.Handler) http.Handler {
+	handler := next
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i].push(handler)
+	}
+	if w.decoder != nil {
+		handler = w.decoder.push(handler)
+	}
+	if w.canonical != nil {
+		handler = w.canonical.push(handler)
+	}
+	return handler
+}
+
+// backend обработчик, проксирующий запросы к бэкенду
+func (w *WAF) backend() http.Handler {
+	var handler http.Handler = w.proxy
+	if w.upstreams != nil {
+		handler = w.upstreams
+	}
+	return w.timeouts.withUpstreamDeadline(w.sniHandler(handler))
+}
+
+// Run создает WAF с дефолт модулями и запускает сервер.
+func Run(port, targetAddress string) error {
+	return RunWithConfig(port, targetAddress, "")
+}
+
+// New создает WAF из конфигурации: бэкенд server_address (или пул upstreams), таймауты
+// и модули middleware_chain. cfg может быть nil — тогда используется цепь по умолчанию.
+// Для встраивания в собственный сервис без обратного прокси используйте Handler(next).
+func New(cfg *Config) (*WAF, error) {
+	targetAddress := ""
+	if cfg != nil {
+		targetAddress = cfg.ServerAddress
+	}
+	waf, err := newFromConfig(targetAddress, cfg)
+	if err != nil {
+		return nil, err
+	}
+	go waf.runSchedules()
+	return waf, nil
+}
+
+// newFromConfig создает WAF для бэкенда targetAddress и настраивает его из конфига
+func newFromConfig(targetAddress string, cfg *Config) (*WAF, error) {
+	waf, err := NewWAF(targetAddress)
+	if err != nil {
+		return nil, fmt.Errorf("target url: %w", err)
+	}
+
+	if cfg != nil {
+		if err := waf.SetEvents(cfg.Events); err != nil {
+			return nil, fmt.Errorf("events: %w", err)
+		}
+		if err := waf.SetAudit(cfg.Audit); err != nil {
+			return nil, fmt.Errorf("audit: %w", err)
+		}
+		if err := waf.SetRuleBundles(cfg.RuleBundles); err != nil {
+			return nil, fmt.Errorf("rule_bundles: %w", err)
+		}
+		if err := waf.SetAdminAuth(cfg.Admin.Auth); err != nil {
+			return nil, fmt.Errorf("admin auth: %w", err)
+		}
+		waf.SetTimeouts(cfg.Timeouts)
+		waf.SetTransport(cfg.Transport)
+		waf.SetRequestID(cfg.RequestID)
+		waf.SetReputation(cfg.Reputation)
+		waf.SetForensics(cfg.Forensics)
+		if err := waf.SetGeoIP(cfg.GeoIP); err != nil {
+			return nil, fmt.Errorf("geoip: %w", err)
+		}
+		waf.SetPathCanonicalization(cfg.PathCanonicalization)
+		waf.SetRequestDecompression(cfg.RequestDecompression)
+		if len(cfg.Upstreams.Targets) > 0 {
+			if err := waf.SetUpstreams(cfg.Upstreams); err != nil {
+				return nil, fmt.Errorf("upstreams: %w", err)
+			}
+		}
+		if err := waf.SetSNIRoutes(cfg.SNIRoutes); err != nil {
+			return nil, fmt.Errorf("sni_routes: %w", err)
+		}
+	}
+
+	if err := waf.reload(cfg, "startup"); err != nil {
+		return nil, err
+	}
+	return waf, nil
+}
+
+// buildChain создает модули middleware_chain (или цепь по умолчанию)
+func buildChain(waf *WAF, cfg *Config) ([]Middleware, error) {
+	// Определить цепь middleware
+	chain := []string{"context", "rate_limit", "signature"}
+	if cfg != nil && len(cfg.MiddlewareChain) > 0 {
+		chain = cfg.MiddlewareChain
+	}
+
+	if cfg != nil {
+		if err := loadPluginFiles(cfg.PluginFiles); err != nil {
+			return nil, err
+		}
+	}
+
+	var middlewares []Middleware
+	for _, name := range chain {
+		m, err := newChainMiddleware(waf, name, cfg)
+		if errors.Is(err, errUnknownMiddleware) {
+			// Пропустить неизвестные модули
+			log.Printf("Неизвестный middleware в цепочке: %s (пропущен)", name)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if m != nil {
+			middlewares = append(middlewares, m)
+		}
+	}
+
+	if cfg != nil && cfg.Decision.Enabled {
+		e, err := newDecisionEngine(waf, cfg.Decision)
+		if err != nil {
+			return nil, fmt.Errorf("decision: %w", err)
+		}
+		middlewares = append(append([]Middleware{&decisionEntry{e}}, middlewares...), &decisionExit{e})
+	}
+	return middlewares, nil
+}
+
+// errUnknownMiddleware имя модуля не поддерживается
+var errUnknownMiddleware = errors.New("unknown middleware")
+
+// newChainMiddleware создает модуль по имени из middleware_chain с настройками из cfg.
+// nil без ошибки — модуль пропущен из-за отсутствия обязательных настроек.
+func newChainMiddleware(waf *WAF, name string, cfg *Config) (Middleware, error) {
+	switch name {
+	case "rate_limit":
+		// дефолт параметры
+		rl := NewRateLimitMiddleware(waf, 5.0, 20, 30*time.Second)
+		if cfg != nil {
+			rlc := cfg.RateLimit
+			if rlc.Limit > 0 {
+				rl.limit = rate.Limit(rlc.Limit)
+			}
+			if rlc.Burst > 0 {
+				rl.burst = rlc.Burst
+			}
+			if rlc.BanSeconds > 0 {
+				rl.banDuration = time.Duration(rlc.BanSeconds) * time.Second
+			}
+			if rlc.Multiplier > 0 {
+				rl.multiplier = rlc.Multiplier
+			}
+			if rlc.ViolationResetHrs > 0 {
+				rl.violationResetTTL = time.Duration(rlc.ViolationResetHrs) * time.Hour
+			}
+			keys, err := newRateLimitKeys(rlc.Keys)
+			if err != nil {
+				return nil, fmt.Errorf("rate_limit: %w", err)
+			}
+			rl.keys, rl.identity = keys, rlc.Identity
+		}
+		return rl, nil
+
+	case "signature":
+		var ptPatterns []string
+		var err error
+		if cfg != nil {
+			// Приоритет: path_traversal_patterns_source -> path_traversal_patterns_source_file
+			if cfg.PathTraversalPatternsSource.Enable && cfg.PathTraversalPatternsSource.Source != "" {
+				ptPatterns, err = LoadPatternsDynamic(
+					cfg.PathTraversalPatternsSource.SourceType,
+					cfg.PathTraversalPatternsSource.Source,
+					cfg.PathTraversalPatternsSource.Format,
+				)
+				if err != nil {
+					log.Printf("[WAF] Ошибка динамической загрузки паттернов обхода путей: %v", err)
+				}
+			} else if cfg.PathTraversalPatternsSourceFile.Source != "" {
+				ptPatterns, err = LoadPatternsDynamic(
+					cfg.PathTraversalPatternsSourceFile.SourceType,
+					cfg.PathTraversalPatternsSourceFile.Source,
+					cfg.PathTraversalPatternsSourceFile.Format,
+				)
+				if err != nil {
+					log.Printf("[WAF] Ошибка загрузки файла паттернов обхода путей: %v", err)
+				}
+			}
+		}
+		sm := NewSignatureMiddlewareWithPathTraversal(waf, ptPatterns)
+		if cfg != nil {
+			sm.logMatches = cfg.Signature.LogMatches
+			switch n := cfg.Signature.VerdictCacheSize; {
+			case n < 0:
+				sm.cache = nil
+			case n > 0:
+				sm.cache = newSignatureCache(n)
+			}
+			backend := cfg.Signature.MatcherBackend
+			if backend == "hyperscan" && !hyperscanSupported {
+				log.Printf("[WAF] signature: WAF собран без тега hyperscan, используется движок %s", defaultPatternBackend)
+				backend = defaultPatternBackend
+			}
+			if err := sm.compileWithFallback(backend); err != nil {
+				return nil, fmt.Errorf("signature: %w", err)
+			}
+			if cfg.Signature.InspectBody {
+				sm.maxBodyInspect = defaultMaxBodyInspectSize
+				if cfg.Signature.MaxBodyInspectKB > 0 {
+					sm.maxBodyInspect = int64(cfg.Signature.MaxBodyInspectKB) << 10
+				}
+			}
+		}
+		return sm, nil
+
+	case "context":
+		if cfg != nil && cfg.Context.WindowSeconds > 0 {
+			cm := NewContextMiddlewareWithConfig(
+				waf,
+				time.Duration(cfg.Context.WindowSeconds)*time.Second,
+				cfg.Context.Threshold,
+				time.Duration(cfg.Context.BanSeconds)*time.Second,
+				cfg.Context.ResourceExtractor,
+			)
+			// Применить динамическое удлинение бана из конфига
+			if cfg.Context.Multiplier > 0 {
+				cm.multiplier = cfg.Context.Multiplier
+			}
+			if cfg.Context.ViolationResetHours > 0 {
+				cm.violationResetTTL = time.Duration(cfg.Context.ViolationResetHours) * time.Hour
+			}
+			cm.SetRoutes(cfg.Context.Routes)
+			cm.SetIdentity(cfg.Context.Identity)
+			return cm, nil
+		} else {
+			cm := NewContextMiddleware(waf)
+			if cfg != nil {
+				cm.SetRoutes(cfg.Context.Routes)
+				cm.SetIdentity(cfg.Context.Identity)
+			}
+			return cm, nil
+		}
+
+	case "login_protection":
+		if cfg == nil || len(cfg.LoginProtection.Paths) == 0 {
+			log.Printf("[WAF] login_protection: не заданы пути входа (пропущен)")
+			return nil, nil
+		}
+		return NewLoginProtectionMiddlewareWithConfig(waf, cfg.LoginProtection), nil
+
+	case "enumeration":
+		if cfg == nil || len(cfg.Enumeration.Paths) == 0 {
+			log.Printf("[WAF] enumeration: не заданы пути (пропущен)")
+			return nil, nil
+		}
+		return NewEnumerationMiddlewareWithConfig(waf, cfg.Enumeration), nil
+
+	case "scanner":
+		if cfg != nil {
+			return NewScannerMiddlewareWithConfig(waf, cfg.Scanner), nil
+		} else {
+			return NewScannerMiddleware(waf), nil
+		}
+
+	case "good_bots":
+		if cfg != nil {
+			return NewGoodBotMiddlewareWithConfig(waf, cfg.GoodBots), nil
+		}
+		return NewGoodBotMiddlewareWithConfig(waf, GoodBotsConfig{}), nil
+
+	case "device":
+		if cfg != nil {
+			return NewDeviceMiddlewareWithConfig(waf, cfg.Device), nil
+		}
+		return NewDeviceMiddlewareWithConfig(waf, DeviceConfig{}), nil
+
+	case "header_anomaly":
+		if cfg != nil {
+			return NewHeaderAnomalyMiddlewareWithConfig(waf, cfg.HeaderAnomaly), nil
+		}
+		return NewHeaderAnomalyMiddlewareWithConfig(waf, HeaderAnomalyConfig{}), nil
+
+	case "control_chars":
+		if cfg != nil {
+			return NewControlCharMiddlewareWithConfig(waf, cfg.ControlChars), nil
+		}
+		return NewControlCharMiddlewareWithConfig(waf, ControlCharsConfig{}), nil
+
+	case "verdict":
+		if cfg == nil || cfg.Verdict.URL == "" {
+			log.Printf("[WAF] verdict: не задан url (пропущен)")
+			return nil, nil
+		}
+		vm, err := NewVerdictMiddlewareWithConfig(waf, cfg.Verdict)
+		if err != nil {
+			return nil, fmt.Errorf("verdict: %w", err)
+		}
+		return vm, nil
+
+	case "upload":
+		var upCfg UploadConfig
+		if cfg != nil {
+			upCfg = cfg.Upload
+		}
+		up, err := NewUploadMiddlewareWithConfig(waf, upCfg)
+		if err != nil {
+			return nil, fmt.Errorf("upload: %w", err)
+		}
+		return up, nil
+
+	case "hotlink":
+		var hlCfg HotlinkConfig
+		if cfg != nil {
+			hlCfg = cfg.Hotlink
+		}
+		hl, err := NewHotlinkMiddlewareWithConfig(waf, hlCfg)
+		if err != nil {
+			return nil, fmt.Errorf("hotlink: %w", err)
+		}
+		return hl, nil
+
+	case "host_header":
+		if cfg != nil {
+			return NewHostHeaderMiddlewareWithConfig(waf, cfg.HostHeader), nil
+		}
+		return NewHostHeaderMiddlewareWithConfig(waf, HostHeaderConfig{}), nil
+
+	case "crlf":
+		if cfg != nil {
+			return NewCRLFMiddlewareWithConfig(waf, cfg.CRLF), nil
+		}
+		return NewCRLFMiddlewareWithConfig(waf, CRLFConfig{}), nil
+
+	case "content_type":
+		if cfg != nil {
+			return NewContentTypeMiddlewareWithConfig(waf, cfg.ContentType), nil
+		}
+		return NewContentTypeMiddlewareWithConfig(waf, ContentTypeConfig{}), nil
+
+	case "tls_client":
+		var tcCfg TLSClientConfig
+		if cfg != nil {
+			tcCfg = cfg.TLSClient
+		}
+		tc, err := NewTLSClientMiddlewareWithConfig(waf, tcCfg)
+		if err != nil {
+			return nil, err
+		}
+		return tc, nil
+
+	case "sequence":
+		if cfg != nil {
+			return NewSequenceMiddlewareWithConfig(waf, cfg.Sequence), nil
+		} else {
+			return NewSequenceMiddleware(waf), nil
+		}
+
+	case "param_anomaly":
+		if cfg != nil {
+			return NewParamAnomalyMiddlewareWithConfig(waf, cfg.ParamAnomaly), nil
+		} else {
+			return NewParamAnomalyMiddleware(waf), nil
+		}
+
+	case "anomaly_model":
+		if cfg == nil {
+			log.Printf("[WAF] anomaly_model: нет конфигурации (пропущен)")
+			return nil, nil
+		}
+		am, err := NewAnomalyScoringMiddlewareWithConfig(waf, cfg.AnomalyModel)
+		if err != nil {
+			log.Printf("[WAF] anomaly_model: %v (пропущен)", err)
+			return nil, nil
+		}
+		return am, nil
+
+	case "positive_model":
+		if cfg == nil || cfg.PositiveModel.PolicyPath == "" {
+			log.Printf("[WAF] positive_model: не задан policy_path (пропущен)")
+			return nil, nil
+		}
+		pm, err := NewPositiveModelMiddlewareWithConfig(waf, cfg.PositiveModel)
+		if err != nil {
+			log.Printf("[WAF] positive_model: %v (пропущен)", err)
+			return nil, nil
+		}
+		return pm, nil
+
+	case "openapi":
+		if cfg == nil || cfg.OpenAPI.SpecPath == "" {
+			log.Printf("[WAF] openapi: не задан spec_path (пропущен)")
+			return nil, nil
+		}
+		om, err := NewOpenAPIMiddlewareWithConfig(waf, cfg.OpenAPI)
+		if err != nil {
+			return nil, fmt.Errorf("openapi: %w", err)
+		}
+		return om, nil
+
+	case "json_schema":
+		if cfg == nil || len(cfg.RequestSchema.Routes) == 0 {
+			log.Printf("[WAF] json_schema: не заданы маршруты (пропущен)")
+			return nil, nil
+		}
+		rs, err := NewRequestSchemaMiddlewareWithConfig(waf, cfg.RequestSchema)
+		if err != nil {
+			return nil, fmt.Errorf("json_schema: %w", err)
+		}
+		return rs, nil
+
+	case "csrf":
+		var csrfCfg CSRFConfig
+		if cfg != nil {
+			csrfCfg = cfg.CSRF
+		}
+		return NewCSRFMiddlewareWithConfig(waf, csrfCfg), nil
+
+	case "cors":
+		var corsCfg CORSConfig
+		if cfg != nil {
+			corsCfg = cfg.CORS
+		}
+		return NewCORSMiddlewareWithConfig(waf, corsCfg), nil
+
+	case "cookie_security":
+		var csCfg CookieSecurityConfig
+		if cfg != nil {
+			csCfg = cfg.CookieSecurity
+		}
+		cs, err := NewCookieSecurityMiddlewareWithConfig(waf, csCfg)
+		if err != nil {
+			return nil, fmt.Errorf("cookie_security: %w", err)
+		}
+		return cs, nil
+
+	case "jwt":
+		if cfg == nil {
+			log.Printf("[WAF] jwt: нет конфигурации (пропущен)")
+			return nil, nil
+		}
+		jm, err := NewJWTMiddlewareWithConfig(waf, cfg.JWT)
+		if err != nil {
+			return nil, fmt.Errorf("jwt: %w", err)
+		}
+		return jm, nil
+
+	case "introspection":
+		if cfg == nil || cfg.Introspection.Endpoint == "" {
+			log.Printf("[WAF] introspection: не задан endpoint (пропущен)")
+			return nil, nil
+		}
+		im, err := NewIntrospectionMiddlewareWithConfig(waf, cfg.Introspection)
+		if err != nil {
+			return nil, fmt.Errorf("introspection: %w", err)
+		}
+		return im, nil
+
+	case "cache":
+		if cfg != nil {
+			return NewCacheMiddlewareWithConfig(waf, cfg.Cache), nil
+		} else {
+			return NewCacheMiddleware(waf), nil
+		}
+
+	case "rewrite":
+		if cfg == nil {
+			log.Printf("[WAF] rewrite: нет конфигурации (пропущен)")
+			return nil, nil
+		}
+		rw, err := NewRewriteMiddlewareWithConfig(waf, cfg.Rewrite)
+		if err != nil {
+			return nil, fmt.Errorf("rewrite: %w", err)
+		}
+		return rw, nil
+
+	case "circuit_breaker":
+		if cfg != nil {
+			return NewCircuitBreakerMiddlewareWithConfig(waf, cfg.CircuitBreaker), nil
+		} else {
+			return NewCircuitBreakerMiddleware(waf), nil
+		}
+
+	case "retry":
+		var rcfg RetryConfig
+		if cfg != nil {
+			rcfg = cfg.Retry
+		}
+		return NewRetryMiddlewareWithConfig(waf, rcfg), nil
+
+	case "wasm":
+		if cfg == nil || len(cfg.WASM.Modules) == 0 {
+			log.Printf("[WAF] wasm: не заданы модули (пропущен)")
+			return nil, nil
+		}
+		if !wasmSupported {
+			log.Printf("[WAF] wasm: WAF собран без тега wazero (пропущен)")
+			return nil, nil
+		}
+		wm, err := NewWASMMiddlewareWithConfig(waf, cfg.WASM)
+		if err != nil {
+			return nil, fmt.Errorf("wasm: %w", err)
+		}
+		return wm, nil
+
+	case "rules":
+		if cfg == nil || len(cfg.Rules.Rules) == 0 {
+			log.Printf("[WAF] rules: не заданы правила (пропущен)")
+			return nil, nil
+		}
+		rm, err := NewRulesMiddlewareWithConfig(waf, cfg.Rules)
+		if err != nil {
+			return nil, fmt.Errorf("rules: %w", err)
+		}
+		return rm, nil
+
+	case "somecheck":
+		return &SomeCheck{waf: waf}, nil
+
+	}
+	if m, ok, err := newPluginMiddleware(waf, name, cfg); ok {
+		return m, err
+	}
+	return nil, errUnknownMiddleware
+
+}
+
+// RunWithConfig создает WAF с middleware из конфига и запускает сервер.
+// Все слушающие сокеты открываются до начала обслуживания: ошибка любого из них возвращается,
+// а предыдущий процесс при обновлении бинарника продолжает работать.
+func RunWithConfig(port, targetAddress, configPath string) error {
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+
+	if cfg != nil && len(cfg.RuleTests.Files) > 0 {
+		runStartupRuleTests(cfg)
+	}
+
+	waf, err := newFromConfig(targetAddress, cfg)
+	if err != nil {
+		return fmt.Errorf("configure WAF: %w", err)
+	}
+	go waf.runSchedules()
+	if cfg != nil && len(cfg.Upstreams.Targets) > 0 {
+		targetAddress = strings.Join(cfg.Upstreams.Targets, ", ")
+	}
+	if cfg != nil && cfg.Kubernetes.Policy != "" {
+		pw, err := newPolicyWatcher(waf, cfg, cfg.Kubernetes)
+		if err != nil {
+			return fmt.Errorf("kubernetes: %w", err)
+		}
+		go pw.run()
+	}
+	if cfg != nil && cfg.RuleUpdates.URL != "" {
+		ru, err := newRuleUpdater(waf, cfg, cfg.RuleUpdates)
+		if err != nil {
+			return fmt.Errorf("rule_updates: %w", err)
+		}
+		go ru.run()
+	}
+	if cfg != nil && cfg.Cluster.Bind != "" {
+		c, err := newClusterNode(waf, cfg.Cluster)
+		if err == nil {
+			err = c.start()
+		}
+		if err != nil {
+			return fmt.Errorf("cluster: %w", err)
+		}
+	}
+	if cfg != nil && cfg.Fleet.Leader != "" {
+		f, err := newFleetFollower(waf, cfg, cfg.Fleet)
+		if err != nil {
+			return fmt.Errorf("fleet: %w", err)
+		}
+		go f.run()
+	}
+	if cfg != nil && cfg.Standby.Primary != "" {
+		s, err := newStandbyReplica(waf, cfg, cfg.Standby)
+		if err != nil {
+			return fmt.Errorf("standby: %w", err)
+		}
+		waf.standby.Store(s)
+		go s.run()
+	}
+
+	// Режим внешней авторизации Envoy: WAF не проксирует трафик, а только выносит решения
+	if cfg != nil && cfg.ExtAuthz.Addr != "" {
+		if !extAuthzSupported {
+			log.Printf("[WAF] ext_authz: WAF собран без тега extauthz (пропущен)")
+		} else {
+			log.Printf("Запуск Envoy ext_authz сервера на %s", cfg.ExtAuthz.Addr)
+			if err := serveExtAuthz(waf, cfg.ExtAuthz); err != nil {
+				return fmt.Errorf("ext_authz: %w", err)
+			}
+			return nil
+		}
+	}
+
+	if cfg != nil && cfg.ForwardAuth.Addr != "" {
+		path := cfg.ForwardAuth.Path
+		if path == "" {
+			path = "/verify"
+		}
+		mux := http.NewServeMux()
+		mux.Handle(path, waf.ForwardAuthHandler(cfg.ForwardAuth))
+		ln, err := sockets.listenTCP("forward_auth", cfg.ForwardAuth.Addr)
+		if err != nil {
+			return fmt.Errorf("forward_auth: %w", err)
+		}
+		srv := waf.timeouts.server(mux)
+		log.Printf("Запуск forward-auth endpoint %s на %s", path, cfg.ForwardAuth.Addr)
+		go func() {
+			if err := srv.Serve(ln); err != nil && !errors.Is(err, net.ErrClosed) {
+				log.Printf("[WAF] Ошибка forward-auth endpoint: %v", err)
+			}
+		}()
+	}
+
+	if cfg != nil && cfg.Admin.Addr != "" {
+		ln, err := sockets.listenTCP("admin", cfg.Admin.Addr)
+		if err != nil {
+			return fmt.Errorf("admin: %w", err)
+		}
+		srv := waf.timeouts.server(waf.AdminHandler())
+		log.Printf("Запуск панели администратора http://%s/admin/", cfg.Admin.Addr)
+		go func() {
+			if err := srv.Serve(ln); err != nil && !errors.Is(err, net.ErrClosed) {
+				log.Printf("[WAF] Ошибка панели администратора: %v", err)
+			}
+		}()
+	}
+
+	handler := waf.Handler(nil)
+
+	// Сокет, переданный systemd (socket activation) или предыдущим процессом при обновлении,
+	// имеет приоритет над waf_port
+	fdName := ""
+	if cfg != nil {
+		fdName = cfg.ListenFDName
+	}
+	ln, err := sockets.activatedListener(fdName)
+	if err != nil {
+		return err
+	}
+	if ln != nil {
+		port = ln.Addr().String()
+		log.Printf("[WAF] Используется унаследованный сокет: %s", port)
+	} else if ln, err = sockets.listenTCP(proxyName(fdName), port); err != nil {
+		return fmt.Errorf("reverse proxy: %w", err)
+	}
+	if cfg != nil && (cfg.ConnectionLimit.MaxPerIP > 0 || cfg.ConnectionLimit.MaxTotal > 0) {
+		cl := newConnLimitListener(ln, cfg.ConnectionLimit.MaxPerIP, cfg.ConnectionLimit.MaxTotal)
+		cl.events = waf.events
+		ln = cl
+	}
+
+	if cfg != nil && cfg.HTTP3.Addr != "" {
+		if !http3Supported {
+			log.Printf("[WAF] http3: WAF собран без тега http3 (пропущен)")
+		} else {
+			conn, err := sockets.listenUDP("http3", cfg.HTTP3.Addr)
+			if err != nil {
+				return fmt.Errorf("http3: %w", err)
+			}
+			h3 := handler
+			log.Printf("Запуск HTTP/3 (QUIC) listener на %s", cfg.HTTP3.Addr)
+			go func() {
+				if err := serveHTTP3(cfg.HTTP3, conn, h3); err != nil {
+					log.Printf("[WAF] Ошибка HTTP/3 listener: %v", err)
+				}
+			}()
+			handler = withAltSvc(handler, cfg.HTTP3)
+		}
+	}
+
+	srv := waf.timeouts.server(handler)
+	if cfg != nil && cfg.TLS.CertFile != "" {
+		tlsCfg, err := waf.tlsConfig(cfg.TLS)
+		if err != nil {
+			return fmt.Errorf("tls: %w", err)
+		}
+		// net/http распознает TLS и HTTP/2 только у *tls.Conn, поэтому исходные заголовки
+		// поверх TLS не записываются
+		if cfg.HeaderAnomaly.RawHeaders {
+			log.Printf("[WAF] header_anomaly: raw_headers не поддерживается вместе с tls (пропущено)")
+		}
+		ln = tls.NewListener(ln, tlsCfg)
+	} else if cfg != nil && cfg.HeaderAnomaly.RawHeaders {
+		ln = rawHeaderListener{ln}
+		srv.ConnContext = rawHeadersConnContext
+	}
+
+	log.Printf("Запуск обратного прокси на порту %s -> %s", port, targetAddress)
+	if err := serve(srv, ln, waf.timeouts.shutdown); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("reverse proxy: %w", err)
+	}
+	return nil
+}
+
+// extractIP нормализует RemoteAddr в адрес хоста
+func extractIP(remote string) string {
+	host, _, err := net.SplitHostPort(remote)
+	if err != nil {
+		// может быть уже без порта
+		return remote
+	}
+	return host
+}
+
+// SomeCheck пример middleware (использовать для расширения)
+type SomeCheck struct{ waf *WAF }
+
+func (m *SomeCheck) push(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := ClientIP(r)
+
+		// Проверка бана
+		if m.waf != nil && m.waf.banned(r, ip) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		// Обновить время последнего доступа и rate limit
+		if m.waf != nil {
+			st := m.waf.states.Get(ip)
+			st.mu.Lock()
+			st.LastSeen = time.Now()
+			allowed := st.Limiter.Allow()
+			st.mu.Unlock()
+			if !allowed {
+				// Пример блокировки при превышении; с движком решений банит только движок
+				if !decisionEnabled(r) {
+					m.waf.bans.Ban(ip, 30*time.Second)
+				}
+				ev := requestEvent(r, ip, "somecheck", SeverityWarning, "throttle", fmt.Sprintf("Превышен лимит запросов для %s", ip))
+				m.waf.refuse(w, r, ev, false, func() {
+					w.Header().Set("Retry-After", "30")
+					http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+				})
+				return
+			}
+		}
+
+		log.Printf("Запрос от %s %s %s", ip, r.Method, r.URL.Path)
+		next.ServeHTTP(w, r)
+	})
+}
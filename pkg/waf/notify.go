@@ -0,0 +1,193 @@
+package waf
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func init() {
+	eventSinks["slack"] = newSlackSink
+	eventSinks["telegram"] = newTelegramSink
+}
+
+// notifySettings общие параметры оповещений в мессенджеры
+type notifySettings struct {
+	MaxPerMinute   int `json:"max_per_minute"`  // лимит сообщений в канал, по умолчанию 10
+	StormThreshold int `json:"storm_threshold"` // срабатываний модуля за окно для оповещения о шторме, по умолчанию 50
+	StormWindowS   int `json:"storm_window_s"`  // окно подсчета шторма, по умолчанию 60 с
+	TimeoutMs      int `json:"timeout_ms"`      // таймаут запроса к API, по умолчанию 5 с
+}
+
+// notifier превращает поток событий в короткие оповещения: новый бан, блокировка аккаунта,
+// изменение бэкенда и шторм срабатываний модуля. Отдельные обнаружения не отправляются,
+// иначе канал будет завален при атаке. Сообщения сверх лимита канала подсчитываются
+// и упоминаются в следующем отправленном сообщении.
+type notifier struct {
+	send    func(text string) error
+	limiter *rate.Limiter
+
+	stormThreshold int
+	stormWindow    time.Duration
+
+	mu         sync.Mutex
+	storms     map[string]*stormCounter
+	suppressed int
+}
+
+type stormCounter struct {
+	start    time.Time
+	count    int
+	notified bool
+}
+
+func newNotifier(s notifySettings, send func(string) error) *notifier {
+	n := &notifier{
+		send:           send,
+		limiter:        rate.NewLimiter(rate.Limit(10.0/60), 10),
+		stormThreshold: 50,
+		stormWindow:    time.Minute,
+		storms:         make(map[string]*stormCounter),
+	}
+	if s.MaxPerMinute > 0 {
+		n.limiter = rate.NewLimiter(rate.Limit(float64(s.MaxPerMinute)/60), s.MaxPerMinute)
+	}
+	if s.StormThreshold > 0 {
+		n.stormThreshold = s.StormThreshold
+	}
+	if s.StormWindowS > 0 {
+		n.stormWindow = time.Duration(s.StormWindowS) * time.Second
+	}
+	return n
+}
+
+func (n *notifier) HandleEvent(ev SecurityEvent) {
+	text := n.summarize(ev)
+	if text == "" {
+		return
+	}
+	n.mu.Lock()
+	if !n.limiter.Allow() {
+		n.suppressed++
+		n.mu.Unlock()
+		return
+	}
+	if n.suppressed > 0 {
+		text += fmt.Sprintf("\n(пропущено оповещений из-за лимита: %d)", n.suppressed)
+		n.suppressed = 0
+	}
+	n.mu.Unlock()
+	if err := n.send(text); err != nil {
+		log.Printf("[WAF] Ошибка отправки оповещения: %v", err)
+	}
+}
+
+// summarize возвращает текст оповещения или пустую строку, если событие не оповещается
+func (n *notifier) summarize(ev SecurityEvent) string {
+	label := "[" + strings.ToUpper(ev.Severity) + "]"
+	switch ev.Type {
+	case EventBan, EventAccountLock, EventUpstream:
+		return fmt.Sprintf("%s WAF [%s] %s", label, ev.Module, ev.Message)
+	case EventDetection:
+		if count, ok := n.storm(ev.Module, ev.Time); ok {
+			return fmt.Sprintf("%s WAF: шторм срабатываний модуля %s — %d за %s (последнее: %s)", label, ev.Module, count, n.stormWindow, ev.Message)
+		}
+	}
+	return ""
+}
+
+// storm учитывает срабатывание модуля; ok=true один раз за окно при достижении порога
+func (n *notifier) storm(module string, now time.Time) (int, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	c := n.storms[module]
+	if c == nil || now.Sub(c.start) > n.stormWindow {
+		c = &stormCounter{start: now}
+		n.storms[module] = c
+	}
+	c.count++
+	if c.count >= n.stormThreshold && !c.notified {
+		c.notified = true
+		return c.count, true
+	}
+	return 0, false
+}
+
+// postJSON отправляет JSON в API мессенджера
+func postJSON(client *http.Client, url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
+
+func notifyClient(s notifySettings) *http.Client {
+	timeout := 5 * time.Second
+	if s.TimeoutMs > 0 {
+		timeout = time.Duration(s.TimeoutMs) * time.Millisecond
+	}
+	return &http.Client{Timeout: timeout}
+}
+
+// newSlackSink оповещения через Slack Incoming Webhook
+func newSlackSink(raw json.RawMessage) (EventSink, error) {
+	var s struct {
+		notifySettings
+		WebhookURL string `json:"webhook_url"`
+	}
+	if err := decodeSettings(raw, &s); err != nil {
+		return nil, err
+	}
+	if s.WebhookURL == "" {
+		return nil, errors.New("webhook_url is required")
+	}
+	client := notifyClient(s.notifySettings)
+	return newNotifier(s.notifySettings, func(text string) error {
+		return postJSON(client, s.WebhookURL, map[string]string{"text": text})
+	}), nil
+}
+
+// newTelegramSink оповещения через Telegram Bot API
+func newTelegramSink(raw json.RawMessage) (EventSink, error) {
+	var s struct {
+		notifySettings
+		BotToken string `json:"bot_token"`
+		ChatID   string `json:"chat_id"`
+		APIURL   string `json:"api_url"` // по умолчанию https://api.telegram.org
+	}
+	if err := decodeSettings(raw, &s); err != nil {
+		return nil, err
+	}
+	if s.BotToken == "" || s.ChatID == "" {
+		return nil, errors.New("bot_token and chat_id are required")
+	}
+	if s.APIURL == "" {
+		s.APIURL = "https://api.telegram.org"
+	}
+	url := strings.TrimRight(s.APIURL, "/") + "/bot" + s.BotToken + "/sendMessage"
+	client := notifyClient(s.notifySettings)
+	return newNotifier(s.notifySettings, func(text string) error {
+		return postJSON(client, url, map[string]interface{}{
+			"chat_id":                  s.ChatID,
+			"text":                     text,
+			"disable_web_page_preview": true,
+		})
+	}), nil
+}
@@ -0,0 +1,172 @@
+package waf
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	_ "crypto/sha1" // хеш CertID в запросах OCSP
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+// Минимальный клиент OCSP (RFC 6960) для stapling: запрос статуса сертификата
+// у ответчика из сертификата и разбор ответа. Подпись ответа проверяют клиенты,
+// получающие его в рукопожатии; здесь проверяются статус, серийный номер и срок.
+
+var (
+	oidSHA1          = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidOCSPBasicResp = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+)
+
+type ocspCertID struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	NameHash      []byte
+	IssuerKeyHash []byte
+	SerialNumber  *big.Int
+}
+
+type ocspRequest struct {
+	TBSRequest struct {
+		RequestList []struct {
+			Cert ocspCertID
+		}
+	}
+}
+
+type ocspResponse struct {
+	Status        asn1.Enumerated
+	ResponseBytes struct {
+		ResponseType asn1.ObjectIdentifier
+		Response     []byte
+	} `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspBasicResponse struct {
+	TBSResponseData struct {
+		Version     int `asn1:"optional,explicit,default:0,tag:0"`
+		ResponderID asn1.RawValue
+		ProducedAt  time.Time `asn1:"generalized"`
+		Responses   []ocspSingleResponse
+		Extensions  []pkix.Extension `asn1:"explicit,tag:1,optional"`
+	}
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspSingleResponse struct {
+	CertID  ocspCertID
+	Good    asn1.Flag `asn1:"tag:0,optional"`
+	Revoked struct {
+		RevocationTime time.Time       `asn1:"generalized"`
+		Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+	} `asn1:"tag:1,optional"`
+	Unknown    asn1.Flag        `asn1:"tag:2,optional"`
+	ThisUpdate time.Time        `asn1:"generalized"`
+	NextUpdate time.Time        `asn1:"generalized,explicit,tag:0,optional"`
+	Extensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+// ocspStaple ответ OCSP для сертификата и время следующего обновления
+type ocspStaple struct {
+	raw        []byte
+	thisUpdate time.Time
+	nextUpdate time.Time // нулевое — ответчик не указал
+}
+
+// newOCSPRequest кодирует запрос статуса сертификата leaf, выданного issuer
+func newOCSPRequest(leaf, issuer *x509.Certificate) ([]byte, error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return nil, err
+	}
+	h := crypto.SHA1.New()
+	h.Write(issuer.RawSubject)
+	nameHash := h.Sum(nil)
+	h.Reset()
+	h.Write(spki.PublicKey.RightAlign())
+	keyHash := h.Sum(nil)
+
+	var req ocspRequest
+	req.TBSRequest.RequestList = make([]struct{ Cert ocspCertID }, 1)
+	req.TBSRequest.RequestList[0].Cert = ocspCertID{
+		HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue},
+		NameHash:      nameHash,
+		IssuerKeyHash: keyHash,
+		SerialNumber:  leaf.SerialNumber,
+	}
+	return asn1.Marshal(req)
+}
+
+// parseOCSPResponse проверяет, что ответ подтверждает действительность сертификата leaf
+func parseOCSPResponse(der []byte, leaf *x509.Certificate) (*ocspStaple, error) {
+	var resp ocspResponse
+	if rest, err := asn1.Unmarshal(der, &resp); err != nil {
+		return nil, err
+	} else if len(rest) > 0 {
+		return nil, errors.New("trailing data in OCSP response")
+	}
+	if resp.Status != 0 {
+		return nil, fmt.Errorf("OCSP responder status %d", resp.Status)
+	}
+	if !resp.ResponseBytes.ResponseType.Equal(oidOCSPBasicResp) {
+		return nil, errors.New("unsupported OCSP response type")
+	}
+	var basic ocspBasicResponse
+	if _, err := asn1.Unmarshal(resp.ResponseBytes.Response, &basic); err != nil {
+		return nil, err
+	}
+	for _, single := range basic.TBSResponseData.Responses {
+		if single.CertID.SerialNumber == nil || single.CertID.SerialNumber.Cmp(leaf.SerialNumber) != 0 {
+			continue
+		}
+		if !single.Good {
+			return nil, errors.New("certificate is not reported as good")
+		}
+		if !single.NextUpdate.IsZero() && time.Now().After(single.NextUpdate) {
+			return nil, errors.New("OCSP response is expired")
+		}
+		return &ocspStaple{raw: der, thisUpdate: single.ThisUpdate, nextUpdate: single.NextUpdate}, nil
+	}
+	return nil, errors.New("no OCSP response for the certificate")
+}
+
+// fetchOCSP запрашивает статус сертификата у первого ответчика из его расширения AIA
+func fetchOCSP(ctx context.Context, leaf, issuer *x509.Certificate) (*ocspStaple, error) {
+	if len(leaf.OCSPServer) == 0 {
+		return nil, errors.New("certificate has no OCSP responder")
+	}
+	body, err := newOCSPRequest(leaf, issuer)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, leaf.OCSPServer[0], bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/ocsp-request")
+	req.Header.Set("Accept", "application/ocsp-response")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OCSP responder %s: %s", leaf.OCSPServer[0], resp.Status)
+	}
+	der, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	return parseOCSPResponse(der, leaf)
+}
@@ -0,0 +1,334 @@
+package waf
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+// openAPIMethods методы, которые могут быть описаны в path item
+var openAPIMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// openAPIDocument подмножество OpenAPI 3, необходимое для проверки запросов
+type openAPIDocument struct {
+	OpenAPI string `json:"openapi"`
+	Servers []struct {
+		URL string `json:"url"`
+	} `json:"servers"`
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas       map[string]*jsonSchema         `json:"schemas"`
+		Parameters    map[string]*openAPIParameter   `json:"parameters"`
+		RequestBodies map[string]*openAPIRequestBody `json:"requestBodies"`
+	} `json:"components"`
+}
+
+type openAPIParameter struct {
+	Ref      string      `json:"$ref"`
+	Name     string      `json:"name"`
+	In       string      `json:"in"` // path, query, header, cookie
+	Required bool        `json:"required"`
+	Schema   *jsonSchema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Ref      string `json:"$ref"`
+	Required bool   `json:"required"`
+	Content  map[string]struct {
+		Schema *jsonSchema `json:"schema"`
+	} `json:"content"`
+}
+
+type openAPIOperation struct {
+	Parameters  []*openAPIParameter `json:"parameters"`
+	RequestBody *openAPIRequestBody `json:"requestBody"`
+}
+
+// openAPIRoute скомпилированный путь спецификации с операциями по методам
+type openAPIRoute struct {
+	pattern    routePattern
+	literals   int
+	operations map[string]*compiledOperation
+}
+
+// compiledOperation операция с разрешенными ссылками и объединенными параметрами
+type compiledOperation struct {
+	parameters  []*openAPIParameter
+	requestBody *openAPIRequestBody
+}
+
+// OpenAPIMiddleware проверяет запросы на соответствие спецификации OpenAPI 3:
+// пути, методы, типы и обязательность параметров, типы содержимого и схемы JSON тел.
+// Все, что не описано в спецификации, отклоняется.
+type OpenAPIMiddleware struct {
+	waf               *WAF
+	basePath          string
+	routes            []*openAPIRoute
+	schemas           map[string]*jsonSchema
+	allowUnknownQuery bool
+	action            string // block, log
+	logDetections     bool
+}
+
+// NewOpenAPIMiddlewareWithConfig загружает спецификацию (JSON) и создает middleware
+func NewOpenAPIMiddlewareWithConfig(w *WAF, cfg OpenAPIConfig) (*OpenAPIMiddleware, error) {
+	data, err := os.ReadFile(cfg.SpecPath)
+	if err != nil {
+		return nil, err
+	}
+	var doc openAPIDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("openapi spec %s: %w", cfg.SpecPath, err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		return nil, errors.New("only OpenAPI 3.x specs are supported")
+	}
+
+	m := &OpenAPIMiddleware{
+		waf:               w,
+		schemas:           doc.Components.Schemas,
+		allowUnknownQuery: cfg.AllowUnknownQueryParams,
+		action:            "block",
+		logDetections:     true,
+	}
+	if cfg.Action != "" {
+		m.action = cfg.Action
+	}
+	if len(doc.Servers) > 0 {
+		if u, err := url.Parse(doc.Servers[0].URL); err == nil {
+			m.basePath = strings.TrimRight(u.Path, "/")
+		}
+	}
+	if cfg.BasePath != "" {
+		m.basePath = strings.TrimRight(cfg.BasePath, "/")
+	}
+
+	for path, item := range doc.Paths {
+		route, err := compileOpenAPIPath(path, item, &doc)
+		if err != nil {
+			return nil, fmt.Errorf("openapi path %s: %w", path, err)
+		}
+		m.routes = append(m.routes, route)
+	}
+	// Конкретные пути проверяются раньше шаблонных (/users/me раньше /users/{id})
+	sort.SliceStable(m.routes, func(i, j int) bool {
+		return m.routes[i].literals > m.routes[j].literals
+	})
+	return m, nil
+}
+
+// compileOpenAPIPath разбирает path item и разрешает ссылки на компоненты
+func compileOpenAPIPath(path string, item map[string]json.RawMessage, doc *openAPIDocument) (*openAPIRoute, error) {
+	route := &openAPIRoute{
+		pattern:    compileRoutePattern(path),
+		operations: make(map[string]*compiledOperation),
+	}
+	for _, seg := range route.pattern.segments {
+		if _, ok := routeParamName(seg); !ok {
+			route.literals++
+		}
+	}
+
+	var common []*openAPIParameter
+	if raw, ok := item["parameters"]; ok {
+		if err := json.Unmarshal(raw, &common); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, method := range openAPIMethods {
+		raw, ok := item[method]
+		if !ok {
+			continue
+		}
+		var op openAPIOperation
+		if err := json.Unmarshal(raw, &op); err != nil {
+			return nil, fmt.Errorf("%s: %w", method, err)
+		}
+
+		// Параметры операции переопределяют общие параметры пути с тем же name+in
+		merged := make(map[string]*openAPIParameter)
+		var order []string
+		for _, p := range append(append([]*openAPIParameter{}, common...), op.Parameters...) {
+			p = resolveParameterRef(p, doc)
+			if p == nil {
+				continue
+			}
+			p.Schema = resolveSchemaRef(p.Schema, doc.Components.Schemas)
+			key := p.In + ":" + p.Name
+			if _, seen := merged[key]; !seen {
+				order = append(order, key)
+			}
+			merged[key] = p
+		}
+		compiled := &compiledOperation{requestBody: op.RequestBody}
+		for _, key := range order {
+			compiled.parameters = append(compiled.parameters, merged[key])
+		}
+		if rb := compiled.requestBody; rb != nil && rb.Ref != "" {
+			compiled.requestBody = doc.Components.RequestBodies[strings.TrimPrefix(rb.Ref, "#/components/requestBodies/")]
+		}
+		route.operations[strings.ToUpper(method)] = compiled
+	}
+	return route, nil
+}
+
+// resolveParameterRef заменяет ссылку #/components/parameters/Name на параметр
+func resolveParameterRef(p *openAPIParameter, doc *openAPIDocument) *openAPIParameter {
+	if p != nil && p.Ref != "" {
+		return doc.Components.Parameters[strings.TrimPrefix(p.Ref, "#/components/parameters/")]
+	}
+	return p
+}
+
+func (m *OpenAPIMiddleware) push(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.waf == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := ClientIP(r)
+		if m.waf.banned(r, ip) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		if err := m.validate(r); err != nil {
+			ev := requestEvent(r, ip, "openapi", SeverityWarning, m.action, fmt.Sprintf("Запрос не соответствует OpenAPI от %s: %s %s: %v", ip, r.Method, r.URL.Path, err))
+			if m.waf.decide(r, ev, m.logDetections, func() bool {
+				return m.action == "block" && forbid(w)()
+			}) {
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validate проверяет запрос на соответствие спецификации
+func (m *OpenAPIMiddleware) validate(r *http.Request) error {
+	path := r.URL.Path
+	if m.basePath != "" {
+		if path != m.basePath && !strings.HasPrefix(path, m.basePath+"/") {
+			return errors.New("путь вне basePath")
+		}
+		path = strings.TrimPrefix(path, m.basePath)
+	}
+
+	op, pathParams, err := m.findOperation(r.Method, path)
+	if err != nil {
+		return err
+	}
+
+	query := r.URL.Query()
+	declaredQuery := make(map[string]bool)
+	for _, p := range op.parameters {
+		var values []string
+		switch p.In {
+		case "path":
+			if v, ok := pathParams[p.Name]; ok {
+				values = []string{v}
+			}
+		case "query":
+			declaredQuery[p.Name] = true
+			values = query[p.Name]
+		case "header":
+			values = r.Header.Values(p.Name)
+		case "cookie":
+			if c, err := r.Cookie(p.Name); err == nil {
+				values = []string{c.Value}
+			}
+		}
+		if len(values) == 0 {
+			if p.Required || p.In == "path" {
+				return fmt.Errorf("отсутствует обязательный параметр %s (%s)", p.Name, p.In)
+			}
+			continue
+		}
+		for _, v := range values {
+			if err := p.Schema.validateParam(v); err != nil {
+				return fmt.Errorf("параметр %s (%s): %v", p.Name, p.In, err)
+			}
+		}
+	}
+	if !m.allowUnknownQuery {
+		for name := range query {
+			if !declaredQuery[name] {
+				return fmt.Errorf("неописанный query-параметр %s", name)
+			}
+		}
+	}
+
+	return m.validateBody(r, op.requestBody)
+}
+
+// findOperation находит операцию по методу и пути
+func (m *OpenAPIMiddleware) findOperation(method, path string) (*compiledOperation, map[string]string, error) {
+	pathFound := false
+	for _, route := range m.routes {
+		params, ok := route.pattern.match(path)
+		if !ok {
+			continue
+		}
+		pathFound = true
+		if op, ok := route.operations[method]; ok {
+			return op, params, nil
+		}
+	}
+	if pathFound {
+		return nil, nil, fmt.Errorf("метод %s не описан", method)
+	}
+	return nil, nil, errors.New("путь не описан")
+}
+
+// validateBody проверяет наличие тела и его Content-Type
+func (m *OpenAPIMiddleware) validateBody(r *http.Request, rb *openAPIRequestBody) error {
+	hasBody := r.ContentLength > 0 || len(r.TransferEncoding) > 0
+	if rb == nil {
+		if hasBody {
+			return errors.New("тело запроса не описано")
+		}
+		return nil
+	}
+	if !hasBody {
+		if rb.Required {
+			return errors.New("отсутствует обязательное тело запроса")
+		}
+		return nil
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return errors.New("некорректный Content-Type")
+	}
+	for declared, content := range rb.Content {
+		if !mediaTypeMatches(declared, mediaType) {
+			continue
+		}
+		// JSON тела дополнительно проверяются по схеме содержимого
+		if content.Schema != nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")) {
+			return validateJSONRequestBody(r, content.Schema, m.schemas, defaultMaxSchemaBodySize)
+		}
+		return nil
+	}
+	return fmt.Errorf("Content-Type %s не описан", mediaType)
+}
+
+// mediaTypeMatches сравнивает тип содержимого с объявленным (поддерживаются */* и type/*)
+func mediaTypeMatches(declared, actual string) bool {
+	declared = strings.ToLower(declared)
+	if declared == "*/*" || declared == actual {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(declared, "/*"); ok {
+		return strings.HasPrefix(actual, prefix+"/")
+	}
+	return false
+}
@@ -0,0 +1,242 @@
+package waf
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Классы символов значения параметра (битовая маска)
+const (
+	charDigit uint8 = 1 << iota
+	charLower
+	charUpper
+	charSpace
+	charPunct
+	charNonASCII
+	charControl
+)
+
+// paramStats базовая статистика параметра (онлайн-среднее и дисперсия по Уэлфорду)
+type paramStats struct {
+	count   int
+	lenMean float64
+	lenM2   float64
+	entMean float64
+	entM2   float64
+	classes uint8
+}
+
+func (s *paramStats) observe(length, entropy float64, classes uint8) {
+	s.count++
+	d := length - s.lenMean
+	s.lenMean += d / float64(s.count)
+	s.lenM2 += d * (length - s.lenMean)
+	d = entropy - s.entMean
+	s.entMean += d / float64(s.count)
+	s.entM2 += d * (entropy - s.entMean)
+	s.classes |= classes
+}
+
+func (s *paramStats) lenStd() float64 {
+	if s.count < 2 {
+		return 0
+	}
+	return math.Sqrt(s.lenM2 / float64(s.count-1))
+}
+
+func (s *paramStats) entStd() float64 {
+	if s.count < 2 {
+		return 0
+	}
+	return math.Sqrt(s.entM2 / float64(s.count-1))
+}
+
+// paramBaselines изученная статистика параметров; сохраняется при перезагрузке конфигурации
+type paramBaselines struct {
+	mu            sync.RWMutex
+	baselines     map[string]*paramStats // ключ: шаблон пути + имя параметра
+	trainingUntil time.Time
+}
+
+// ParamAnomalyMiddleware изучает длину, энтропию и классы символов каждого параметра
+// в период обучения и затем отмечает выбросы (например, 4 КБ случайных данных в поле name).
+type ParamAnomalyMiddleware struct {
+	waf *WAF
+	*paramBaselines
+	minSamples    int
+	zThreshold    float64
+	minLength     int
+	minEntropy    float64
+	riskScore     float64
+	action        string // log, block
+	logDetections bool
+}
+
+// NewParamAnomalyMiddleware создает анализатор параметров с дефолт настройками
+func NewParamAnomalyMiddleware(w *WAF) *ParamAnomalyMiddleware {
+	return NewParamAnomalyMiddlewareWithConfig(w, ParamAnomalyConfig{})
+}
+
+// NewParamAnomalyMiddlewareWithConfig создает анализатор параметров из конфига
+func NewParamAnomalyMiddlewareWithConfig(w *WAF, cfg ParamAnomalyConfig) *ParamAnomalyMiddleware {
+	training := time.Hour
+	if cfg.TrainingSeconds > 0 {
+		training = time.Duration(cfg.TrainingSeconds) * time.Second
+	}
+	m := &ParamAnomalyMiddleware{
+		waf: w,
+		paramBaselines: w.keep("param_anomaly|"+training.String(), func() interface{} {
+			return &paramBaselines{baselines: make(map[string]*paramStats), trainingUntil: time.Now().Add(training)}
+		}).(*paramBaselines),
+		minSamples:    30,
+		zThreshold:    4,
+		minLength:     64,
+		minEntropy:    4.0,
+		riskScore:     5,
+		action:        "log",
+		logDetections: true,
+	}
+	if cfg.MinSamples > 0 {
+		m.minSamples = cfg.MinSamples
+	}
+	if cfg.ZThreshold > 0 {
+		m.zThreshold = cfg.ZThreshold
+	}
+	if cfg.MinLength > 0 {
+		m.minLength = cfg.MinLength
+	}
+	if cfg.MinEntropy > 0 {
+		m.minEntropy = cfg.MinEntropy
+	}
+	if cfg.RiskScore > 0 {
+		m.riskScore = cfg.RiskScore
+	}
+	if cfg.Action != "" {
+		m.action = cfg.Action
+	}
+	return m
+}
+
+func (m *ParamAnomalyMiddleware) push(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.waf == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := ClientIP(r)
+		if m.waf.banned(r, ip) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		params := requestParams(r)
+		training := time.Now().Before(m.trainingUntil)
+		route := pathTemplate(r.URL.Path)
+		for name, values := range params {
+			key := route + "?" + name
+			for _, v := range values {
+				length, entropy, classes := float64(len(v)), shannonEntropy(v), charClasses(v)
+				if training {
+					m.learn(key, length, entropy, classes)
+					continue
+				}
+				reason := m.evaluate(key, length, entropy, classes)
+				if reason == "" {
+					continue
+				}
+				risk := addRiskScore(m.waf.states.Get(ip), m.riskScore)
+				ev := requestEvent(r, ip, "param_anomaly", SeverityWarning, m.action, fmt.Sprintf("Аномальное значение параметра %q от %s на %s: %s (длина %d, энтропия %.2f), риск %.1f", name, ip, route, reason, len(v), entropy, risk))
+				ev.Fields = map[string]interface{}{"param": name}
+				if m.waf.decide(r, ev, m.logDetections, func() bool {
+					return m.action == "block" && forbid(w)()
+				}) {
+					return
+				}
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// learn добавляет значение в базовую статистику параметра
+func (m *ParamAnomalyMiddleware) learn(key string, length, entropy float64, classes uint8) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st, ok := m.baselines[key]
+	if !ok {
+		st = &paramStats{}
+		m.baselines[key] = st
+	}
+	st.observe(length, entropy, classes)
+}
+
+// evaluate сравнивает значение с базовой статистикой и возвращает причину выброса
+func (m *ParamAnomalyMiddleware) evaluate(key string, length, entropy float64, classes uint8) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	st, ok := m.baselines[key]
+	if !ok || st.count < m.minSamples {
+		return ""
+	}
+	if length >= float64(m.minLength) && length > st.lenMean+m.zThreshold*math.Max(st.lenStd(), 1) {
+		return "аномальная длина"
+	}
+	if length >= 16 && entropy >= m.minEntropy && entropy > st.entMean+m.zThreshold*math.Max(st.entStd(), 0.1) {
+		return "аномальная энтропия"
+	}
+	if unseen := classes &^ st.classes; unseen&(charControl|charNonASCII|charPunct) != 0 {
+		return "новый класс символов"
+	}
+	return ""
+}
+
+// shannonEntropy энтропия Шеннона строки в битах на символ
+func shannonEntropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	var freq [256]int
+	for i := 0; i < len(s); i++ {
+		freq[s[i]]++
+	}
+	n := float64(len(s))
+	var e float64
+	for _, c := range freq {
+		if c == 0 {
+			continue
+		}
+		p := float64(c) / n
+		e -= p * math.Log2(p)
+	}
+	return e
+}
+
+// charClasses возвращает маску классов символов строки
+func charClasses(s string) uint8 {
+	var mask uint8
+	for _, c := range s {
+		switch {
+		case c >= '0' && c <= '9':
+			mask |= charDigit
+		case c >= 'a' && c <= 'z':
+			mask |= charLower
+		case c >= 'A' && c <= 'Z':
+			mask |= charUpper
+		case c == ' ':
+			mask |= charSpace
+		case c > unicode.MaxASCII:
+			mask |= charNonASCII
+		case unicode.IsControl(c):
+			mask |= charControl
+		default:
+			mask |= charPunct
+		}
+	}
+	return mask
+}
@@ -0,0 +1,301 @@
+package waf
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+)
+
+// Форматы захвата: классический pcap (микро- и наносекундный, оба порядка байт) и pcapng
+const (
+	pcapMagic      = 0xa1b2c3d4
+	pcapMagicNano  = 0xa1b23c4d
+	pcapngBlockSHB = 0x0a0d0d0a
+	pcapngBlockIDB = 0x00000001
+	pcapngBlockEPB = 0x00000006
+	pcapngBlockSPB = 0x00000003
+)
+
+// Типы канального уровня (LINKTYPE_*)
+const (
+	linkNull     = 0
+	linkEthernet = 1
+	linkRaw      = 101
+	linkLinuxSLL = 113
+	linkIPv4     = 228
+	linkIPv6     = 229
+)
+
+// isPCAP сообщает, что данные — захват pcap или pcapng
+func isPCAP(data []byte) bool {
+	if len(data) < 4 {
+		return false
+	}
+	le, be := binary.LittleEndian.Uint32(data), binary.BigEndian.Uint32(data)
+	return le == pcapMagic || be == pcapMagic || le == pcapMagicNano || be == pcapMagicNano || be == pcapngBlockSHB
+}
+
+// tcpFlow половина TCP-соединения (от src к dst)
+type tcpFlow struct {
+	src, dst string // ip:port
+	order    int    // порядок первого пакета, чтобы запросы шли в порядке захвата
+	segments map[uint32][]byte
+}
+
+// readPCAP собирает TCP-потоки захвата и передает HTTP/1.x запросы из них.
+// Зашифрованный трафик (TLS) пропускается: в нем нет читаемых запросов.
+func readPCAP(data []byte, fn func(*replayRequest)) error {
+	flows := make(map[string]*tcpFlow)
+	packet := func(link int, pkt []byte) {
+		src, dst, seq, payload, ok := decodeTCP(link, pkt)
+		if !ok || len(payload) == 0 {
+			return
+		}
+		key := src + ">" + dst
+		f := flows[key]
+		if f == nil {
+			f = &tcpFlow{src: src, dst: dst, order: len(flows), segments: make(map[uint32][]byte)}
+			flows[key] = f
+		}
+		// Повторная передача того же сегмента заменяет предыдущую
+		if old, dup := f.segments[seq]; !dup || len(payload) > len(old) {
+			f.segments[seq] = append([]byte(nil), payload...)
+		}
+	}
+
+	var err error
+	if binary.BigEndian.Uint32(data) == pcapngBlockSHB {
+		err = readPCAPNG(data, packet)
+	} else {
+		err = readPCAPClassic(data, packet)
+	}
+	if err != nil {
+		return err
+	}
+
+	ordered := make([]*tcpFlow, 0, len(flows))
+	for _, f := range flows {
+		ordered = append(ordered, f)
+	}
+	sort.Slice(ordered, func(i, j int) bool { return ordered[i].order < ordered[j].order })
+	for _, f := range ordered {
+		stream := f.assemble()
+		if !looksLikeHTTPRequest(stream) {
+			continue
+		}
+		ip, _, _ := net.SplitHostPort(f.src)
+		br := bufio.NewReader(bytes.NewReader(stream))
+		for {
+			req, err := http.ReadRequest(br)
+			if err != nil {
+				if err != io.EOF {
+					fn(nil)
+				}
+				break
+			}
+			body, _ := io.ReadAll(req.Body)
+			req.Body.Close()
+			fn(&replayRequest{method: req.Method, uri: req.RequestURI, host: req.Host, ip: ip, header: req.Header, body: body})
+		}
+	}
+	return nil
+}
+
+// assemble склеивает сегменты по номерам последовательности; перекрытия отбрасываются
+func (f *tcpFlow) assemble() []byte {
+	seqs := make([]uint32, 0, len(f.segments))
+	for s := range f.segments {
+		seqs = append(seqs, s)
+	}
+	// Номер последовательности считается от первого сегмента, чтобы пережить переход через 2^32
+	base := seqs[0]
+	for _, s := range seqs {
+		if int32(s-base) < 0 {
+			base = s
+		}
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i]-base < seqs[j]-base })
+	var out []byte
+	next := base
+	for _, s := range seqs {
+		seg := f.segments[s]
+		off := int64(next-base) - int64(s-base)
+		if off < 0 {
+			// Потерянный сегмент: дальше поток не читается
+			break
+		}
+		if off < int64(len(seg)) {
+			out = append(out, seg[off:]...)
+			next = s + uint32(len(seg))
+		}
+	}
+	return out
+}
+
+func looksLikeHTTPRequest(b []byte) bool {
+	sp := bytes.IndexByte(b, ' ')
+	if sp <= 0 || sp > 10 {
+		return false
+	}
+	for _, c := range b[:sp] {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	line := b
+	if i := bytes.IndexByte(b, '\n'); i >= 0 {
+		line = b[:i]
+	}
+	return bytes.Contains(line, []byte(" HTTP/1."))
+}
+
+func readPCAPClassic(data []byte, packet func(link int, pkt []byte)) error {
+	if len(data) < 24 {
+		return errors.New("pcap: short file header")
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if m := binary.BigEndian.Uint32(data); m == pcapMagic || m == pcapMagicNano {
+		order = binary.BigEndian
+	}
+	link := int(order.Uint32(data[20:]) & 0x0fffffff)
+	for off := 24; off+16 <= len(data); {
+		incl := int(order.Uint32(data[off+8:]))
+		off += 16
+		if incl < 0 || off+incl > len(data) {
+			return errors.New("pcap: truncated packet")
+		}
+		packet(link, data[off:off+incl])
+		off += incl
+	}
+	return nil
+}
+
+func readPCAPNG(data []byte, packet func(link int, pkt []byte)) error {
+	var order binary.ByteOrder = binary.LittleEndian
+	var links []int
+	for off := 0; off+12 <= len(data); {
+		typ := binary.BigEndian.Uint32(data[off:])
+		if typ == pcapngBlockSHB {
+			// Порядок байт секции задается magic 0x1a2b3c4d
+			if binary.BigEndian.Uint32(data[off+8:]) == 0x1a2b3c4d {
+				order = binary.BigEndian
+			} else {
+				order = binary.LittleEndian
+			}
+			links = links[:0]
+		} else {
+			typ = order.Uint32(data[off:])
+		}
+		size := int(order.Uint32(data[off+4:]))
+		if size < 12 || off+size > len(data) {
+			return errors.New("pcapng: truncated block")
+		}
+		body := data[off+8 : off+size-4]
+		switch typ {
+		case pcapngBlockIDB:
+			if len(body) >= 2 {
+				links = append(links, int(order.Uint16(body)))
+			}
+		case pcapngBlockEPB:
+			if len(body) < 20 {
+				break
+			}
+			iface, capLen := int(order.Uint32(body)), int(order.Uint32(body[12:]))
+			if iface < len(links) && 20+capLen <= len(body) {
+				packet(links[iface], body[20:20+capLen])
+			}
+		case pcapngBlockSPB:
+			if len(body) >= 4 && len(links) > 0 {
+				packet(links[0], body[4:])
+			}
+		}
+		off += size
+	}
+	return nil
+}
+
+// decodeTCP разбирает канальный, сетевой и транспортный уровни пакета
+func decodeTCP(link int, pkt []byte) (src, dst string, seq uint32, payload []byte, ok bool) {
+	var ethType uint16
+	switch link {
+	case linkEthernet:
+		if len(pkt) < 14 {
+			return
+		}
+		ethType, pkt = binary.BigEndian.Uint16(pkt[12:]), pkt[14:]
+		for ethType == 0x8100 && len(pkt) >= 4 { // VLAN
+			ethType, pkt = binary.BigEndian.Uint16(pkt[2:]), pkt[4:]
+		}
+	case linkLinuxSLL:
+		if len(pkt) < 16 {
+			return
+		}
+		ethType, pkt = binary.BigEndian.Uint16(pkt[14:]), pkt[16:]
+	case linkNull:
+		if len(pkt) < 4 {
+			return
+		}
+		pkt = pkt[4:]
+	case linkRaw, linkIPv4, linkIPv6:
+	default:
+		return
+	}
+	if ethType == 0 && len(pkt) > 0 {
+		// Тип не задан канальным уровнем: версия IP из первого полубайта
+		switch pkt[0] >> 4 {
+		case 4:
+			ethType = 0x0800
+		case 6:
+			ethType = 0x86dd
+		}
+	}
+
+	var srcIP, dstIP net.IP
+	var proto byte
+	switch ethType {
+	case 0x0800:
+		if len(pkt) < 20 {
+			return
+		}
+		ihl := int(pkt[0]&0x0f) * 4
+		total := int(binary.BigEndian.Uint16(pkt[2:]))
+		if ihl < 20 || total < ihl || total > len(pkt) {
+			return
+		}
+		// Фрагменты IP не собираются: HTTP-запросы в них встречаются редко
+		if binary.BigEndian.Uint16(pkt[6:])&0x3fff != 0 {
+			return
+		}
+		proto, srcIP, dstIP = pkt[9], net.IP(pkt[12:16]), net.IP(pkt[16:20])
+		pkt = pkt[ihl:total]
+	case 0x86dd:
+		if len(pkt) < 40 {
+			return
+		}
+		plen := int(binary.BigEndian.Uint16(pkt[4:]))
+		if 40+plen > len(pkt) {
+			return
+		}
+		proto, srcIP, dstIP = pkt[6], net.IP(pkt[8:24]), net.IP(pkt[24:40])
+		pkt = pkt[40 : 40+plen]
+	default:
+		return
+	}
+	if proto != 6 || len(pkt) < 20 {
+		return
+	}
+	dataOff := int(pkt[12]>>4) * 4
+	if dataOff < 20 || dataOff > len(pkt) {
+		return
+	}
+	sport, dport := binary.BigEndian.Uint16(pkt[0:]), binary.BigEndian.Uint16(pkt[2:])
+	src = net.JoinHostPort(srcIP.String(), fmt.Sprint(sport))
+	dst = net.JoinHostPort(dstIP.String(), fmt.Sprint(dport))
+	return src, dst, binary.BigEndian.Uint32(pkt[4:]), pkt[dataOff:], true
+}
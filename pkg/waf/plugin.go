@@ -0,0 +1,99 @@
+package waf
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"plugin"
+	"sort"
+	"sync"
+)
+
+// PluginFactory создает модуль плагина. cfg — секция plugins.<имя> конфигурации (может быть пустой).
+// Модуль получает доступ к общим состояниям клиентов (w.States()) и блокировкам (w.Bans()).
+type PluginFactory func(w *WAF, cfg json.RawMessage) (func(http.Handler) http.Handler, error)
+
+var (
+	pluginsMu sync.RWMutex
+	plugins   = make(map[string]PluginFactory)
+)
+
+// RegisterPlugin регистрирует модуль под именем, которое можно указать в middleware_chain.
+// Обычно вызывается из init() пакета плагина; встроенные имена модулей переопределить нельзя.
+func RegisterPlugin(name string, factory PluginFactory) {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	if factory == nil {
+		panic("waf: RegisterPlugin factory is nil")
+	}
+	if _, dup := plugins[name]; dup {
+		panic("waf: RegisterPlugin called twice for " + name)
+	}
+	plugins[name] = factory
+}
+
+// Plugins возвращает имена зарегистрированных плагинов
+func Plugins() []string {
+	pluginsMu.RLock()
+	defer pluginsMu.RUnlock()
+	names := make([]string, 0, len(plugins))
+	for name := range plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// loadPluginFiles загружает плагины, собранные с -buildmode=plugin.
+// Плагин регистрирует свои модули через RegisterPlugin в init(); повторная загрузка файла не выполняется.
+func loadPluginFiles(paths []string) error {
+	for _, path := range paths {
+		if _, err := plugin.Open(path); err != nil {
+			return fmt.Errorf("plugin %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// newPluginMiddleware создает модуль зарегистрированного плагина; ok=false — плагина с таким именем нет
+func newPluginMiddleware(w *WAF, name string, cfg *Config) (Middleware, bool, error) {
+	pluginsMu.RLock()
+	factory, ok := plugins[name]
+	pluginsMu.RUnlock()
+	if !ok {
+		return nil, false, nil
+	}
+	var raw json.RawMessage
+	if cfg != nil {
+		raw = cfg.Plugins[name]
+	}
+	wrap, err := factory(w, raw)
+	if err != nil {
+		return nil, true, fmt.Errorf("%s: %w", name, err)
+	}
+	return &pluginMiddleware{waf: w, wrap: wrap}, true, nil
+}
+
+// pluginMiddleware встраивает модуль плагина в цепь с общей для всех модулей проверкой бана
+type pluginMiddleware struct {
+	waf  *WAF
+	wrap func(http.Handler) http.Handler
+}
+
+func (m *pluginMiddleware) push(next http.Handler) http.Handler {
+	h := m.wrap(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.waf != nil && m.waf.banned(r, ClientIP(r)) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Update изменяет состояние клиента под его блокировкой
+func (s *State) Update(fn func(st *State)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(s)
+}
@@ -0,0 +1,280 @@
+package waf
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	uuidRe  = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	hexRe   = regexp.MustCompile(`^[0-9a-fA-F]+$`)
+	alnumRe = regexp.MustCompile(`^[0-9a-zA-Z_\-.]+$`)
+)
+
+// PositivePolicy сгенерированная allowlist-политика: маршрут "METHOD /path/{id}" -> параметры
+type PositivePolicy struct {
+	Generated time.Time                     `json:"generated"`
+	Routes    map[string]*PositiveRouteRule `json:"routes"`
+}
+
+// PositiveRouteRule допустимые параметры маршрута
+type PositiveRouteRule struct {
+	Hits   int                           `json:"hits"`
+	Params map[string]*PositiveParamRule `json:"params"`
+}
+
+// PositiveParamRule допустимый тип и максимальная длина значения параметра
+type PositiveParamRule struct {
+	Type      string `json:"type"`
+	MaxLength int    `json:"max_length"`
+}
+
+// PositiveModelMiddleware в режиме observe изучает пути, методы, параметры и типы значений
+// и периодически сохраняет allowlist-политику; в режиме enforce отклоняет или отмечает
+// все, что выходит за пределы изученной модели.
+type PositiveModelMiddleware struct {
+	waf         *WAF
+	mode        string // observe, enforce
+	policyPath  string
+	action      string // block, log
+	lengthSlack float64
+	*positiveState
+	logDetections bool
+}
+
+// positiveState политика модуля. В режиме observe она общая для всех сборок цепи с тем же
+// policy_path, чтобы перезагрузка конфигурации не теряла изученное и не запускала
+// второе сохранение в тот же файл
+type positiveState struct {
+	mu     sync.RWMutex
+	policy *PositivePolicy
+}
+
+// NewPositiveModelMiddlewareWithConfig создает модуль позитивной модели из конфига
+func NewPositiveModelMiddlewareWithConfig(w *WAF, cfg PositiveModelConfig) (*PositiveModelMiddleware, error) {
+	m := &PositiveModelMiddleware{
+		waf:           w,
+		mode:          cfg.Mode,
+		policyPath:    cfg.PolicyPath,
+		action:        "block",
+		lengthSlack:   1.5,
+		logDetections: true,
+	}
+	if cfg.Action != "" {
+		m.action = cfg.Action
+	}
+	if cfg.LengthSlack > 0 {
+		m.lengthSlack = cfg.LengthSlack
+	}
+
+	observeKey := "positive_model.observe|" + m.policyPath
+	switch m.mode {
+	case "observe":
+		interval := time.Minute
+		if cfg.FlushSeconds > 0 {
+			interval = time.Duration(cfg.FlushSeconds) * time.Second
+		}
+		started := false
+		m.positiveState = w.keep(observeKey, func() interface{} {
+			started = true
+			return &positiveState{policy: &PositivePolicy{Routes: make(map[string]*PositiveRouteRule)}}
+		}).(*positiveState)
+		if started && w != nil {
+			go m.flushLoop(interval, observeKey)
+		}
+	case "enforce":
+		// Изучение по этому пути закончено: сохранение прекращается, чтобы не перезаписать политику
+		w.forget(observeKey)
+		m.positiveState = &positiveState{policy: &PositivePolicy{Routes: make(map[string]*PositiveRouteRule)}}
+		data, err := os.ReadFile(m.policyPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, m.policy); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("unknown positive_model mode: " + m.mode)
+	}
+	return m, nil
+}
+
+func (m *PositiveModelMiddleware) push(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.waf == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := ClientIP(r)
+		if m.waf.banned(r, ip) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		route := r.Method + " " + pathTemplate(r.URL.Path)
+		params := requestParams(r)
+
+		if m.mode == "observe" {
+			m.observe(route, params)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if reason := m.violation(route, params); reason != "" {
+			ev := requestEvent(r, ip, "positive_model", SeverityWarning, m.action, fmt.Sprintf("Запрос вне позитивной модели от %s: %s: %s", ip, route, reason))
+			if m.waf.decide(r, ev, m.logDetections, func() bool {
+				return m.action == "block" && forbid(w)()
+			}) {
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// observe добавляет маршрут и параметры в политику, расширяя типы при необходимости
+func (m *PositiveModelMiddleware) observe(route string, params map[string][]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rule, ok := m.policy.Routes[route]
+	if !ok {
+		rule = &PositiveRouteRule{Params: make(map[string]*PositiveParamRule)}
+		m.policy.Routes[route] = rule
+	}
+	rule.Hits++
+	for name, values := range params {
+		for _, v := range values {
+			t := inferValueType(v)
+			p, ok := rule.Params[name]
+			if !ok {
+				rule.Params[name] = &PositiveParamRule{Type: t, MaxLength: len(v)}
+				continue
+			}
+			p.Type = widenValueType(p.Type, t)
+			if len(v) > p.MaxLength {
+				p.MaxLength = len(v)
+			}
+		}
+	}
+}
+
+// violation возвращает причину, по которой запрос не соответствует политике
+func (m *PositiveModelMiddleware) violation(route string, params map[string][]string) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	rule, ok := m.policy.Routes[route]
+	if !ok {
+		return "неизвестный маршрут"
+	}
+	for name, values := range params {
+		p, ok := rule.Params[name]
+		if !ok {
+			return "неизвестный параметр " + name
+		}
+		for _, v := range values {
+			if widenValueType(p.Type, inferValueType(v)) != p.Type {
+				return "недопустимый тип параметра " + name
+			}
+			if float64(len(v)) > float64(p.MaxLength)*m.lengthSlack {
+				return "превышена длина параметра " + name
+			}
+		}
+	}
+	return ""
+}
+
+// flushLoop периодически сохраняет изученную политику, пока она остается политикой
+// режима observe по ключу key
+func (m *PositiveModelMiddleware) flushLoop(interval time.Duration, key string) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if m.waf.keptValue(key) != m.positiveState {
+			return
+		}
+		if err := m.SavePolicy(); err != nil {
+			log.Printf("[WAF] Ошибка сохранения позитивной модели: %v", err)
+		}
+	}
+}
+
+// SavePolicy записывает текущую политику в policy_path
+func (m *PositiveModelMiddleware) SavePolicy() error {
+	m.mu.Lock()
+	m.policy.Generated = time.Now()
+	data, err := json.MarshalIndent(m.policy, "", "  ")
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := m.policyPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.policyPath)
+}
+
+// inferValueType определяет наиболее узкий тип значения
+func inferValueType(v string) string {
+	switch {
+	case v == "true" || v == "false":
+		return "bool"
+	case isInt(v):
+		return "int"
+	case isFloat(v):
+		return "float"
+	case uuidRe.MatchString(v):
+		return "uuid"
+	case len(v) >= 8 && hexRe.MatchString(v):
+		return "hex"
+	case isEmail(v):
+		return "email"
+	case alnumRe.MatchString(v):
+		return "alnum"
+	default:
+		return "string"
+	}
+}
+
+// widenValueType возвращает наиболее узкий тип, включающий оба типа
+func widenValueType(a, b string) string {
+	if a == b {
+		return a
+	}
+	numeric := map[string]bool{"int": true, "float": true}
+	if numeric[a] && numeric[b] {
+		return "float"
+	}
+	// Все типы, кроме email и string, состоят из символов alnum
+	alnum := map[string]bool{"bool": true, "int": true, "float": true, "uuid": true, "hex": true, "alnum": true}
+	if alnum[a] && alnum[b] {
+		return "alnum"
+	}
+	return "string"
+}
+
+func isInt(v string) bool {
+	_, err := strconv.ParseInt(v, 10, 64)
+	return err == nil
+}
+
+func isFloat(v string) bool {
+	_, err := strconv.ParseFloat(v, 64)
+	return err == nil
+}
+
+func isEmail(v string) bool {
+	addr, err := mail.ParseAddress(v)
+	return err == nil && addr.Address == v
+}
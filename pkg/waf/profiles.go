@@ -0,0 +1,101 @@
+package waf
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// profile профиль безопасности, привязанный к хостам и маршрутам. В отличие от арендатора
+// профиль меняет только модули и пороги: баны и состояния клиентов общие с основной цепью.
+type profile struct {
+	name        string
+	hosts       []string // пусто — любой Host
+	sni         []string // имена TLS SNI; пусто — любое соединение
+	routes      []routePattern
+	middlewares []Middleware
+}
+
+// match сообщает, подходит ли профиль запросу
+func (p *profile) match(host, sni, urlPath string) bool {
+	if len(p.hosts) > 0 && !matchHost(p.hosts, host) {
+		return false
+	}
+	if len(p.sni) > 0 && !matchHost(p.sni, sni) {
+		return false
+	}
+	if len(p.routes) == 0 {
+		return true
+	}
+	for _, rp := range p.routes {
+		if _, ok := rp.match(urlPath); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// profileFor возвращает номер первой подходящей привязки; -1 — основная цепь
+func profileFor(profiles []*profile, r *http.Request) int {
+	if len(profiles) == 0 {
+		return -1
+	}
+	host, sni, p := requestHost(r), serverName(r), path.Clean("/"+r.URL.Path)
+	for i, pr := range profiles {
+		if pr.match(host, sni, p) {
+			return i
+		}
+	}
+	return -1
+}
+
+// buildProfiles собирает цепи профилей для привязок profile_bindings. Профиль — набор
+// настроек из profiles, наложенный на основную конфигурацию; один профиль может
+// использоваться в нескольких привязках и собирается один раз.
+func buildProfiles(w *WAF, cfg *Config) ([]*profile, error) {
+	if cfg == nil || len(cfg.ProfileBindings) == 0 {
+		return nil, nil
+	}
+	base := *cfg
+	base.Profiles, base.ProfileBindings, base.Tenants = nil, nil, nil
+
+	built := make(map[string][]Middleware)
+	var profiles []*profile
+	for i, b := range cfg.ProfileBindings {
+		if len(b.Hosts) == 0 && len(b.Routes) == 0 && len(b.SNI) == 0 {
+			return nil, fmt.Errorf("profile binding #%d: hosts, sni or routes are required", i+1)
+		}
+		overlay, ok := cfg.Profiles[b.Profile]
+		if !ok {
+			return nil, fmt.Errorf("profile binding #%d: unknown profile %q", i+1, b.Profile)
+		}
+		mws, ok := built[b.Profile]
+		if !ok {
+			pcfg, err := mergeConfig(&base, overlay)
+			if err != nil {
+				return nil, fmt.Errorf("profile %s: %w", b.Profile, err)
+			}
+			if len(pcfg.Profiles) > 0 || len(pcfg.ProfileBindings) > 0 || len(pcfg.Tenants) > 0 {
+				return nil, errors.New("profile " + b.Profile + ": profiles, profile_bindings and tenants are not allowed inside a profile")
+			}
+			if mws, err = buildChain(w, pcfg); err != nil {
+				return nil, fmt.Errorf("profile %s: %w", b.Profile, err)
+			}
+			built[b.Profile] = mws
+		}
+		p := &profile{name: b.Profile, middlewares: mws}
+		for _, h := range b.Hosts {
+			p.hosts = append(p.hosts, strings.ToLower(h))
+		}
+		for _, n := range b.SNI {
+			p.sni = append(p.sni, strings.ToLower(n))
+		}
+		for _, r := range b.Routes {
+			p.routes = append(p.routes, compileRoutePattern(r))
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles, nil
+}
@@ -0,0 +1,209 @@
+package waf
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimitMiddleware реализует token-bucket лимитер. При превышении блокирует IP.
+// Повторные нарушения удлиняют бан экспоненциально.
+type RateLimitMiddleware struct {
+	waf               *WAF
+	limit             rate.Limit
+	burst             int
+	banDuration       time.Duration
+	multiplier        float64        // умножитель времени блокировки
+	violationResetTTL time.Duration  // сброс времени блокировки после таймаута
+	keys              []rateLimitKey // nil — один лимит по IP
+	identity          IdentityConfig
+}
+
+// NewRateLimitMiddleware создает rate-limiter middleware.
+func NewRateLimitMiddleware(w *WAF, limit float64, burst int, ban time.Duration) *RateLimitMiddleware {
+	return &RateLimitMiddleware{
+		waf:               w,
+		limit:             rate.Limit(limit),
+		burst:             burst,
+		banDuration:       ban,
+		multiplier:        2.0,
+		violationResetTTL: 24 * time.Hour,
+	}
+}
+
+func (m *RateLimitMiddleware) push(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.waf == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := ClientIP(r)
+
+		if m.waf.banned(r, ip) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		if verifiedBot(r) != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		keys := m.keys
+		if keys == nil {
+			keys = []rateLimitKey{{name: "ip"}}
+		}
+		for i := range keys {
+			id, ok := keys[i].id(r, m.identity, ip)
+			if !ok {
+				continue
+			}
+			if id != ip && m.waf.banned(r, id) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			if !m.allow(w, r, &keys[i], id, ip) {
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allow расходует токены клиента по ключу; при превышении банит идентификатор ключа и отвечает 429
+func (m *RateLimitMiddleware) allow(w http.ResponseWriter, r *http.Request, k *rateLimitKey, id, ip string) bool {
+	st := m.waf.states.Get(id)
+	if st == nil {
+		return true
+	}
+	limit, burst, ban := m.limit, m.burst, m.banDuration
+	if k.limit > 0 {
+		limit = k.limit
+	}
+	if k.burst > 0 {
+		burst = k.burst
+	}
+	if k.banDuration > 0 {
+		ban = k.banDuration
+	}
+
+	// Клиент с плохой репутацией расходует несколько токенов на запрос
+	cost := 1
+	if f := m.waf.reputation.factor(ip); f < 1 && burst > 1 {
+		cost = min(burst, int(math.Ceil(1/f)))
+	}
+
+	// Проверить лимитер и его параметры
+	st.mu.Lock()
+	if st.Limiter == nil || st.currentLimit != limit || st.currentBurst != burst {
+		st.Limiter = rate.NewLimiter(limit, burst)
+		st.currentLimit = limit
+		st.currentBurst = burst
+	}
+	allowed := st.Limiter.AllowN(time.Now(), cost)
+	low := st.Limiter.Tokens() < float64(burst)/4
+	st.LastSeen = time.Now()
+	st.mu.Unlock()
+	if allowed && low {
+		m.waf.reputation.note(ip, reputationRateWarning)
+	}
+
+	// Установить заголовки
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(burst))
+
+	if allowed {
+		return true
+	}
+
+	m.waf.reputation.note(ip, reputationRateExceeded)
+	m.waf.forensics.link(id, ip)
+
+	// С движком решений лимит только отвечает 429, а бан назначает движок по сумме срабатываний
+	if decisionEnabled(r) {
+		ev := requestEvent(r, ip, "rate_limit", SeverityWarning, "throttle", fmt.Sprintf("Превышен лимит запросов для %s", id))
+		ev.Fields = map[string]interface{}{"key": k.name}
+		retry := 1
+		if limit > 0 {
+			retry = max(1, int(math.Ceil(float64(cost)/float64(limit))))
+		}
+		m.waf.refuse(w, r, ev, false, func() {
+			w.Header().Set("Retry-After", strconv.Itoa(retry))
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+		})
+		return false
+	}
+
+	st.mu.Lock()
+	now := time.Now()
+
+	// Сброс счетчика если прошло установленное время после послежней блокировки
+	if !st.LastViolationTime.IsZero() && now.Sub(st.LastViolationTime) > m.violationResetTTL {
+		st.RateLimitViolations = 0
+	}
+
+	st.RateLimitViolations++
+	st.LastViolationTime = now
+
+	// Вычисление нового времени блокировки
+	banDuration := time.Duration(float64(ban) * math.Pow(m.multiplier, float64(st.RateLimitViolations-1)))
+	violationCount := st.RateLimitViolations
+	st.mu.Unlock()
+
+	// Заблокировать и вернуть 429
+	m.waf.bans.Ban(id, banDuration)
+	w.Header().Set("Retry-After", strconv.FormatInt(int64(banDuration.Seconds()), 10))
+	http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+	log.Printf("[%s] Превышен лимит запросов для %s: заблокирован на %s (нарушение #%d) [request_id=%s]", now.Format(time.RFC3339), id, banDuration, violationCount, requestID(r))
+	return false
+}
+
+// rateLimitKey отдельный лимит по ключу клиента. Составной ключ (ip+ja3, session) отслеживает
+// одного клиента, который меняет IP или cookie сессии, сохраняя остальные признаки.
+type rateLimitKey struct {
+	name        string // ключ из конфига, например ja3+ua
+	parts       []string
+	limit       rate.Limit // 0 — лимит модуля
+	burst       int
+	banDuration time.Duration
+}
+
+func newRateLimitKeys(cfg []RateLimitKeyConfig) ([]rateLimitKey, error) {
+	var keys []rateLimitKey
+	for _, kc := range cfg {
+		parts, err := identityParts(kc.Key)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, rateLimitKey{
+			name:        strings.Join(parts, "+"),
+			parts:       parts,
+			limit:       rate.Limit(kc.Limit),
+			burst:       kc.Burst,
+			banDuration: time.Duration(kc.BanSeconds) * time.Second,
+		})
+	}
+	return keys, nil
+}
+
+// id идентификатор клиента по ключу: для ip — сам адрес (его проверяют и другие модули),
+// иначе ключ и значения частей. false — какой-то части в запросе нет.
+func (k *rateLimitKey) id(r *http.Request, identity IdentityConfig, ip string) (string, bool) {
+	if k.name == "ip" {
+		return ip, true
+	}
+	values := make([]string, len(k.parts))
+	for i, p := range k.parts {
+		if values[i] = identityPart(r, identity, p, ip); values[i] == "" {
+			return "", false
+		}
+	}
+	return k.name + ":" + strings.Join(values, "|"), true
+}
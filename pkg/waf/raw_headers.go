@@ -0,0 +1,229 @@
+package waf
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Запись исходного порядка и регистра заголовков HTTP/1.x. net/http приводит имена
+// к каноническому виду и хранит их в map, поэтому listener сам разбирает поток байтов
+// соединения: выделяет блоки заголовков, пропускает тела (Content-Length, chunked)
+// и передает имена обработчику запроса по порядку. При рассинхронизации (upgrade,
+// HTTP/2, слишком длинный блок, несовпадение строки запроса) запись на соединении
+// прекращается, и модули работают без исходных заголовков.
+
+const (
+	rawHead = iota
+	rawBody
+	rawChunkSize
+	rawChunkData
+	rawTrailer
+	rawDead
+)
+
+const (
+	rawHeaderMaxBlock = 64 << 10
+	rawHeaderMaxQueue = 32
+)
+
+// rawHeaderBlock заголовки одного запроса в исходном виде
+type rawHeaderBlock struct {
+	method, target string
+	names          []string
+}
+
+type rawHeaderParser struct {
+	state  int
+	buf    []byte
+	remain int64
+	queue  []rawHeaderBlock
+}
+
+func (p *rawHeaderParser) feed(b []byte) {
+	for len(b) > 0 && p.state != rawDead {
+		switch p.state {
+		case rawHead:
+			p.buf = append(p.buf, b...)
+			b = nil
+			p.buf = bytes.TrimLeft(p.buf, "\r\n")
+			end, sep := bytes.Index(p.buf, []byte("\r\n\r\n")), 4
+			if end < 0 {
+				end, sep = bytes.Index(p.buf, []byte("\n\n")), 2
+			}
+			if end < 0 {
+				if len(p.buf) > rawHeaderMaxBlock {
+					p.kill()
+				}
+				return
+			}
+			block, rest := p.buf[:end], p.buf[end+sep:]
+			p.buf = nil
+			p.head(block)
+			b = rest
+		case rawBody, rawChunkData:
+			n := int64(len(b))
+			if n > p.remain {
+				n = p.remain
+			}
+			b = b[n:]
+			if p.remain -= n; p.remain == 0 {
+				if p.state == rawBody {
+					p.state = rawHead
+				} else {
+					p.state = rawChunkSize
+				}
+			}
+		case rawChunkSize, rawTrailer:
+			i := bytes.IndexByte(b, '\n')
+			if i < 0 {
+				p.buf = append(p.buf, b...)
+				if len(p.buf) > 4096 {
+					p.kill()
+				}
+				return
+			}
+			line := string(bytes.TrimRight(append(p.buf, b[:i]...), "\r"))
+			p.buf, b = nil, b[i+1:]
+			if p.state == rawTrailer {
+				if line == "" {
+					p.state = rawHead
+				}
+				continue
+			}
+			size, _, _ := strings.Cut(line, ";")
+			n, err := strconv.ParseInt(strings.TrimSpace(size), 16, 64)
+			switch {
+			case err != nil || n < 0:
+				p.kill()
+			case n == 0:
+				p.state = rawTrailer
+			default:
+				p.state, p.remain = rawChunkData, n+2 // данные и CRLF после них
+			}
+		}
+	}
+}
+
+// head разбирает блок заголовков и определяет, как выглядит тело запроса
+func (p *rawHeaderParser) head(block []byte) {
+	lines := strings.Split(string(block), "\n")
+	fields := strings.Fields(lines[0])
+	if len(fields) != 3 || !strings.HasPrefix(fields[2], "HTTP/1.") {
+		p.kill() // HTTP/2 preface или мусор
+		return
+	}
+	blk := rawHeaderBlock{method: fields[0], target: fields[1]}
+	var length int64
+	chunked, upgrade := false, fields[0] == http.MethodConnect
+	for _, line := range lines[1:] {
+		name, value, ok := strings.Cut(strings.TrimRight(line, "\r"), ":")
+		if !ok {
+			continue
+		}
+		blk.names = append(blk.names, name)
+		value = strings.TrimSpace(value)
+		switch {
+		case strings.EqualFold(name, "Content-Length"):
+			length, _ = strconv.ParseInt(value, 10, 64)
+		case strings.EqualFold(name, "Transfer-Encoding"):
+			chunked = strings.Contains(strings.ToLower(value), "chunked")
+		case strings.EqualFold(name, "Upgrade"):
+			upgrade = true
+		}
+	}
+	if len(p.queue) >= rawHeaderMaxQueue {
+		p.kill()
+		return
+	}
+	p.queue = append(p.queue, blk)
+	switch {
+	case upgrade:
+		// После переключения протокола поток больше не HTTP/1.x
+		p.state = rawDead
+	case chunked:
+		p.state = rawChunkSize
+	case length > 0:
+		p.state, p.remain = rawBody, length
+	}
+}
+
+func (p *rawHeaderParser) kill() {
+	p.state, p.buf, p.queue = rawDead, nil, nil
+}
+
+// take выдает заголовки очередного запроса; при несовпадении строки запроса запись прекращается
+func (p *rawHeaderParser) take(method, target string) []string {
+	if len(p.queue) == 0 {
+		return nil
+	}
+	blk := p.queue[0]
+	p.queue = p.queue[1:]
+	if blk.method != method || blk.target != target {
+		p.kill()
+		return nil
+	}
+	return blk.names
+}
+
+// rawHeaderConn соединение, записывающее исходные заголовки запросов
+type rawHeaderConn struct {
+	net.Conn
+	mu     sync.Mutex
+	parser rawHeaderParser
+}
+
+func (c *rawHeaderConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.mu.Lock()
+		c.parser.feed(b[:n])
+		c.mu.Unlock()
+	}
+	return n, err
+}
+
+// rawHeaderListener оборачивает соединения listener в rawHeaderConn
+type rawHeaderListener struct{ net.Listener }
+
+func (l rawHeaderListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &rawHeaderConn{Conn: c}, nil
+}
+
+type rawHeaderConnKey struct{}
+
+// rawHeadersConnContext передает соединение в контекст запросов (http.Server.ConnContext)
+func rawHeadersConnContext(ctx context.Context, c net.Conn) context.Context {
+	if rc, ok := c.(*rawHeaderConn); ok {
+		return context.WithValue(ctx, rawHeaderConnKey{}, rc)
+	}
+	return ctx
+}
+
+// takeRawHeaders имена заголовков запроса в исходном порядке и регистре или nil,
+// если listener их не записывал. Вызывается один раз на запрос, при создании его контекста.
+func takeRawHeaders(r *http.Request) []string {
+	c, _ := r.Context().Value(rawHeaderConnKey{}).(*rawHeaderConn)
+	if c == nil || r.ProtoMajor != 1 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.parser.take(r.Method, r.RequestURI)
+}
+
+// rawHeaders имена заголовков запроса в исходном виде (header_anomaly.raw_headers) или nil
+func rawHeaders(r *http.Request) []string {
+	if info := requestInfoOf(r); info != nil {
+		return info.rawHeaders
+	}
+	return nil
+}
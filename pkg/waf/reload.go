@@ -0,0 +1,184 @@
+package waf
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// liveChain обработчик, возвращаемый Handler: пересобирает цепь после изменения модулей WAF
+type liveChain struct {
+	waf     *WAF
+	next    http.Handler
+	built   atomic.Pointer[builtChain]
+	tenants sync.Map // *WAF арендатора -> *liveChain
+}
+
+type builtChain struct {
+	generation uint64
+	handler    http.Handler
+	profiles   []*profile
+	byProfile  []http.Handler // цепи привязок profiles по тем же индексам
+}
+
+func (c *liveChain) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if requestInfoOf(r) == nil {
+		var finish func()
+		w, r, finish = c.waf.requestIDs.assign(w, r)
+		defer finish()
+		requestInfoOf(r).rawHeaders = takeRawHeaders(r)
+	}
+	c.waf.requests.Add(1)
+	// Резервный экземпляр пропускает запросы без проверок до повышения
+	if c.waf.standby.Load() != nil {
+		c.next.ServeHTTP(w, r)
+		return
+	}
+	if m := c.waf.maintenance.Load(); m != nil && m.serve(w, r) {
+		return
+	}
+	if s := c.waf.shadow.Load(); s != nil {
+		if p := s.mirror(r); p != nil {
+			defer p.primary(r)
+		}
+	}
+	if tw := c.waf.tenantFor(r); tw != nil {
+		tc, ok := c.tenants.Load(tw)
+		if !ok {
+			tc, _ = c.tenants.LoadOrStore(tw, &liveChain{waf: tw, next: c.next})
+		}
+		tc.(*liveChain).ServeHTTP(w, r)
+		return
+	}
+	if f := c.waf.forensics; f != nil {
+		rec := newStatusRecorder(w)
+		defer func(done func(int)) { done(rec.status) }(f.begin(r, ClientIP(r)))
+		w = rec
+	}
+	b := c.built.Load()
+	if gen := c.waf.generation.Load(); b == nil || b.generation != gen {
+		b = c.waf.chain(c.next)
+		b.generation = gen
+		c.built.Store(b)
+	}
+	if i := profileFor(b.profiles, r); i >= 0 {
+		b.byProfile[i].ServeHTTP(w, r)
+		return
+	}
+	b.handler.ServeHTTP(w, r)
+}
+
+// Reload заменяет цепь модулей на собранную из cfg без перезапуска сервера.
+// Состояния клиентов и блокировки сохраняются; запросы в обработке завершаются на старой цепи.
+// Listener, таймауты, бэкенды и канонизация путей при перезагрузке не меняются.
+// Профили и арендаторы пересобираются вместе с основной цепью; баны арендатора сохраняются,
+// пока он есть в cfg. При ошибке в конфигурации остается прежняя цепь.
+// Цепь собирается с настройками расписаний, активных в момент перезагрузки.
+// Примененная конфигурация сохраняется в истории версий для отката.
+func (w *WAF) Reload(cfg *Config) error {
+	return w.reload(cfg, "reload")
+}
+
+// reload перезагружает конфигурацию; source — кто ее применил, для истории версий
+func (w *WAF) reload(cfg *Config, source string) error {
+	eff, active, err := scheduledConfig(cfg, time.Now())
+	if err != nil {
+		return err
+	}
+	middlewares, err := buildChain(w, eff)
+	if err != nil {
+		return err
+	}
+	profiles, err := buildProfiles(w, eff)
+	if err != nil {
+		return err
+	}
+	tenants, err := w.buildTenants(eff)
+	if err != nil {
+		return err
+	}
+	shadow, err := newShadowRun(w, eff)
+	if err != nil {
+		return fmt.Errorf("shadow: %w", err)
+	}
+	var maintenance *maintenanceMode
+	if eff != nil {
+		if maintenance, err = newMaintenanceMode(eff.Maintenance); err != nil {
+			return fmt.Errorf("maintenance: %w", err)
+		}
+	}
+	w.setTenants(tenants)
+	w.setShadow(shadow)
+	w.maintenance.Store(maintenance)
+	w.mu.Lock()
+	w.schedules = strings.Join(active, ",")
+	w.mu.Unlock()
+	w.setChain(middlewares, profiles, cfg)
+	w.bundles.record(cfg, source)
+	return nil
+}
+
+// setChain заменяет основную цепь и профили
+func (w *WAF) setChain(middlewares []Middleware, profiles []*profile, cfg *Config) {
+	w.mu.Lock()
+	w.middlewares = middlewares
+	w.profiles = profiles
+	w.cfg = cfg
+	w.exclusions = nil
+	if cfg != nil {
+		w.exclusions = compileExclusions(cfg.Exclusions)
+	}
+	w.mu.Unlock()
+	w.generation.Add(1)
+}
+
+// keep возвращает объект модуля по ключу, создавая его при первом обращении. Модули
+// пересобираются при каждой перезагрузке и смене расписания; через keep они сохраняют
+// между сборками секреты по умолчанию, изученные модели и счетчики. Ключ включает настройки,
+// от которых объект зависит. Без WAF (проверка конфигурации) объект создается заново
+func (w *WAF) keep(key string, create func() interface{}) interface{} {
+	if w == nil {
+		return create()
+	}
+	w.keptMu.Lock()
+	defer w.keptMu.Unlock()
+	if v, ok := w.kept[key]; ok {
+		return v
+	}
+	if w.kept == nil {
+		w.kept = make(map[string]interface{})
+	}
+	v := create()
+	w.kept[key] = v
+	return v
+}
+
+// keptValue сохраненный объект модуля по ключу или nil
+func (w *WAF) keptValue(key string) interface{} {
+	w.keptMu.Lock()
+	defer w.keptMu.Unlock()
+	return w.kept[key]
+}
+
+// forget удаляет сохраненный объект; следующая сборка создаст его заново
+func (w *WAF) forget(key string) {
+	if w == nil {
+		return
+	}
+	w.keptMu.Lock()
+	delete(w.kept, key)
+	w.keptMu.Unlock()
+}
+
+// randomSecret случайный ключ для модулей, у которых секрет не задан в конфиге
+func randomSecret() interface{} {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return secret
+}
@@ -0,0 +1,38 @@
+package waf
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Пересборка цепи при перезагрузке не должна менять ключи по умолчанию и терять обучение
+func TestKeepAcrossRebuilds(t *testing.T) {
+	w, err := NewWAF("http://127.0.0.1:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, b := NewCSRFMiddlewareWithConfig(w, CSRFConfig{}), NewCSRFMiddlewareWithConfig(w, CSRFConfig{}); !bytes.Equal(a.secret, b.secret) {
+		t.Error("csrf secret changed between builds")
+	}
+	if a, b := NewDeviceMiddlewareWithConfig(w, DeviceConfig{}), NewDeviceMiddlewareWithConfig(w, DeviceConfig{}); !bytes.Equal(a.secret, b.secret) {
+		t.Error("device secret changed between builds")
+	}
+	if a, b := NewCSRFMiddlewareWithConfig(nil, CSRFConfig{}), NewCSRFMiddlewareWithConfig(nil, CSRFConfig{}); bytes.Equal(a.secret, b.secret) {
+		t.Error("secret shared without WAF")
+	}
+
+	p := NewParamAnomalyMiddleware(w)
+	p.learn("/a?q", 10, 2, 1)
+	if again := NewParamAnomalyMiddleware(w); again.baselines["/a?q"] == nil || !again.trainingUntil.Equal(p.trainingUntil) {
+		t.Error("param_anomaly baselines lost")
+	}
+	if other := NewParamAnomalyMiddlewareWithConfig(w, ParamAnomalyConfig{TrainingSeconds: 60}); other.baselines["/a?q"] != nil {
+		t.Error("param_anomaly baselines kept after training_seconds change")
+	}
+	if NewSequenceMiddleware(w).model != NewSequenceMiddleware(w).model {
+		t.Error("sequence model lost")
+	}
+	if NewCircuitBreakerMiddleware(w).breakerState != NewCircuitBreakerMiddleware(w).breakerState {
+		t.Error("circuit breaker state lost")
+	}
+}
@@ -0,0 +1,342 @@
+package waf
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ReplayOptions параметры офлайн-прогона записанных запросов
+type ReplayOptions struct {
+	Host        string // Host для строк журнала доступа (в них его нет); по умолчанию localhost
+	OnlyBlocked bool   // выводить только заблокированные запросы
+	Detected    bool   // выводить только запросы со срабатываниями модулей
+	Baseline    string // результат прошлого прогона: выводятся и считаются изменившиеся решения
+}
+
+// ReplayResult решение WAF по одному записанному запросу (строка JSON Lines вывода replay)
+type ReplayResult struct {
+	N              int    `json:"n"` // номер запроса во входных файлах
+	Method         string `json:"method"`
+	URI            string `json:"uri"`
+	IP             string `json:"ip"`
+	Verdict        string `json:"verdict"` // allow или block
+	Status         int    `json:"status"`
+	RecordedStatus int    `json:"recorded_status,omitempty"` // ответ из записи
+	Was            string `json:"was,omitempty"`             // решение в baseline, если изменилось
+
+	Detections []ReplayDetection `json:"detections,omitempty"`
+}
+
+// ReplayDetection срабатывание модуля на запросе
+type ReplayDetection struct {
+	Module   string                 `json:"module"`
+	Severity string                 `json:"severity"`
+	Action   string                 `json:"action,omitempty"`
+	Message  string                 `json:"message"`
+	Fields   map[string]interface{} `json:"fields,omitempty"`
+}
+
+// ReplayReport итоги прогона
+type ReplayReport struct {
+	Total    int         `json:"total"`
+	Blocked  int         `json:"blocked"`
+	Skipped  int         `json:"skipped"` // нераспознанные строки
+	Changed  int         `json:"changed"` // решения, отличающиеся от baseline
+	ByStatus map[int]int `json:"by_status"`
+
+	Detected int            `json:"detected"` // запросы хотя бы с одним срабатыванием
+	ByModule map[string]int `json:"by_module"`
+	ByAttack map[string]int `json:"by_attack,omitempty"` // поле attack сигнатурных срабатываний
+	ByIP     map[string]int `json:"by_ip"`               // клиенты со срабатываниями
+}
+
+// replayRequest записанный запрос
+type replayRequest struct {
+	method, uri, host, ip string
+	header                http.Header
+	body                  []byte
+	status                int
+}
+
+// Replay прогоняет записанные запросы (журнал доступа в формате combined, HAR, pcap или pcapng)
+// через цепь модулей cfg без обращения к бэкенду и пишет решения со срабатываниями модулей
+// в out в формате JSON Lines. Получатели событий, панель администратора и теневой режим
+// при прогоне не запускаются; журналирование сигнатурных срабатываний включается.
+func Replay(cfg *Config, paths []string, opts ReplayOptions, out io.Writer) (*ReplayReport, error) {
+	w, err := newOfflineWAF(cfg)
+	if err != nil {
+		return nil, err
+	}
+	// Запросы обрабатываются по одному, поэтому события синхронной шины относятся к текущему
+	var current []ReplayDetection
+	w.events.tap = func(ev SecurityEvent) {
+		if ev.Type == EventDetection {
+			current = append(current, detectionOf(ev))
+		}
+	}
+
+	var baseline map[int]string
+	if opts.Baseline != "" {
+		if baseline, err = loadReplayBaseline(opts.Baseline); err != nil {
+			return nil, fmt.Errorf("baseline: %w", err)
+		}
+	}
+	if opts.Host == "" {
+		opts.Host = "localhost"
+	}
+
+	rep := &ReplayReport{
+		ByStatus: make(map[int]int),
+		ByModule: make(map[string]int),
+		ByAttack: make(map[string]int),
+		ByIP:     make(map[string]int),
+	}
+	enc := json.NewEncoder(out)
+	n := 0
+	for _, path := range paths {
+		err := readReplayFile(path, opts.Host, func(rr *replayRequest) {
+			if rr == nil {
+				rep.Skipped++
+				return
+			}
+			n++
+			current = nil
+			res := w.replayOne(n, rr)
+			res.Detections = current
+			rep.Total++
+			if len(res.Detections) > 0 {
+				rep.Detected++
+				rep.ByIP[res.IP]++
+			}
+			for _, d := range res.Detections {
+				rep.ByModule[d.Module]++
+				if attack, ok := d.Fields["attack"].(string); ok {
+					rep.ByAttack[attack]++
+				}
+			}
+			rep.ByStatus[res.Status]++
+			if res.Verdict == "block" {
+				rep.Blocked++
+			}
+			if was, ok := baseline[n]; ok && was != res.Verdict {
+				res.Was = was
+				rep.Changed++
+			}
+			if opts.OnlyBlocked && res.Verdict != "block" && res.Was == "" {
+				return
+			}
+			if opts.Detected && len(res.Detections) == 0 && res.Was == "" {
+				return
+			}
+			enc.Encode(res)
+		})
+		if err != nil {
+			return rep, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return rep, nil
+}
+
+// newOfflineWAF создает WAF для офлайн-прогона: без получателей событий (включая журнал),
+// панели, журнала действий и теневого режима, с журналированием сигнатурных срабатываний
+func newOfflineWAF(cfg *Config) (*WAF, error) {
+	var offline Config
+	if cfg != nil {
+		offline = *cfg
+	}
+	offline.Events.Sinks = nil
+	offline.Admin, offline.Audit, offline.Shadow = AdminConfig{}, AuditConfig{}, ShadowConfig{}
+	offline.RuleBundles = RuleBundlesConfig{}
+	offline.Signature.LogMatches = true
+	w, err := newFromConfig(offline.ServerAddress, &offline)
+	if err != nil {
+		return nil, err
+	}
+	w.events.replace(nil)
+	return w, nil
+}
+
+func detectionOf(ev SecurityEvent) ReplayDetection {
+	return ReplayDetection{Module: ev.Module, Severity: ev.Severity, Action: ev.Action, Message: ev.Message, Fields: ev.Fields}
+}
+
+func (w *WAF) replayOne(n int, rr *replayRequest) ReplayResult {
+	req := httptest.NewRequest(rr.method, "/", bytes.NewReader(rr.body))
+	if u, err := req.URL.Parse(rr.uri); err == nil {
+		req.URL = u
+		req.RequestURI = rr.uri
+	}
+	req.Host = rr.host
+	req.Header = rr.header
+	req.RemoteAddr = net.JoinHostPort(rr.ip, "0")
+	v := w.authorize(req)
+
+	res := ReplayResult{N: n, Method: rr.method, URI: rr.uri, IP: rr.ip, Verdict: "allow", Status: http.StatusOK, RecordedStatus: rr.status}
+	if !v.allowed {
+		res.Verdict, res.Status = "block", v.status
+	}
+	return res
+}
+
+func loadReplayBaseline(path string) (map[int]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	verdicts := make(map[int]string)
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		var res ReplayResult
+		if json.Unmarshal(sc.Bytes(), &res) == nil && res.N > 0 {
+			verdicts[res.N] = res.Verdict
+		}
+	}
+	return verdicts, sc.Err()
+}
+
+// readReplayFile читает захват pcap/pcapng, HAR (по расширению .har или JSON-объекту
+// в начале файла) либо журнал доступа; fn получает nil для нераспознанной записи
+func readReplayFile(path, host string, fn func(*replayRequest)) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if isPCAP(data) {
+		return readPCAP(data, fn)
+	}
+	if strings.HasSuffix(path, ".har") || bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return readHAR(data, fn)
+	}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		if line := sc.Text(); strings.TrimSpace(line) != "" {
+			fn(parseAccessLogLine(line, host))
+		}
+	}
+	return sc.Err()
+}
+
+// accessLogLine формат combined (NCSA): ip - user [time] "METHOD URI PROTO" status size "referer" "user-agent"
+var accessLogLine = regexp.MustCompile(`^(\S+) \S+ \S+ \[[^\]]*\] "(\S+) (\S+)(?: \S+)?" (\d{3}) \S+(?: "((?:[^"\\]|\\.)*)" "((?:[^"\\]|\\.)*)")?`)
+
+func parseAccessLogLine(line, host string) *replayRequest {
+	m := accessLogLine.FindStringSubmatch(line)
+	if m == nil || net.ParseIP(m[1]) == nil {
+		return nil
+	}
+	rr := &replayRequest{method: m[2], uri: m[3], host: host, ip: m[1], header: make(http.Header)}
+	rr.status, _ = strconv.Atoi(m[4])
+	if ref := unescapeLogField(m[5]); ref != "" && ref != "-" {
+		rr.header.Set("Referer", ref)
+	}
+	if ua := unescapeLogField(m[6]); ua != "" && ua != "-" {
+		rr.header.Set("User-Agent", ua)
+	}
+	return rr
+}
+
+func unescapeLogField(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	if u, err := strconv.Unquote(`"` + s + `"`); err == nil {
+		return u
+	}
+	return s
+}
+
+// harFile подмножество формата HTTP Archive 1.2, нужное для воспроизведения
+type harFile struct {
+	Log struct {
+		Entries []struct {
+			ServerIPAddress string `json:"serverIPAddress"`
+			Request         struct {
+				Method  string `json:"method"`
+				URL     string `json:"url"`
+				Headers []struct {
+					Name  string `json:"name"`
+					Value string `json:"value"`
+				} `json:"headers"`
+				PostData *struct {
+					Text string `json:"text"`
+				} `json:"postData"`
+			} `json:"request"`
+			Response struct {
+				Status int `json:"status"`
+			} `json:"response"`
+			ClientIPAddress string `json:"_clientIPAddress"` // расширение; в HAR браузера адреса клиента нет
+		} `json:"entries"`
+	} `json:"log"`
+}
+
+func readHAR(data []byte, fn func(*replayRequest)) error {
+	var har harFile
+	if err := json.Unmarshal(data, &har); err != nil {
+		return err
+	}
+	if har.Log.Entries == nil {
+		return errors.New("not a HAR file: log.entries is missing")
+	}
+	for _, e := range har.Log.Entries {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		u, err := req.URL.Parse(e.Request.URL)
+		if err != nil || e.Request.Method == "" {
+			fn(nil)
+			continue
+		}
+		rr := &replayRequest{
+			method: e.Request.Method,
+			uri:    u.RequestURI(),
+			host:   u.Host,
+			ip:     e.ClientIPAddress,
+			header: make(http.Header),
+			status: e.Response.Status,
+		}
+		if net.ParseIP(rr.ip) == nil {
+			rr.ip = "127.0.0.1"
+		}
+		for _, h := range e.Request.Headers {
+			// Псевдозаголовки HTTP/2 (:authority, :path) в HAR браузеров не передаются модулям
+			if strings.HasPrefix(h.Name, ":") {
+				continue
+			}
+			if strings.EqualFold(h.Name, "Host") {
+				rr.host = h.Value
+				continue
+			}
+			rr.header.Add(h.Name, h.Value)
+		}
+		if e.Request.PostData != nil {
+			rr.body = []byte(e.Request.PostData.Text)
+		}
+		fn(rr)
+	}
+	return nil
+}
+
+// WriteText выводит итоги прогона в виде отчета для человека
+func (r *ReplayReport) WriteText(out io.Writer) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Запросов: %d, со срабатываниями: %d, заблокировано: %d, нераспознано: %d\n", r.Total, r.Detected, r.Blocked, r.Skipped)
+	if r.Changed > 0 {
+		fmt.Fprintf(&b, "Изменилось решений относительно baseline: %d\n", r.Changed)
+	}
+	writeTop(&b, "Модули", r.ByModule, 0)
+	writeTop(&b, "Атаки", r.ByAttack, 0)
+	writeTop(&b, "Клиенты", r.ByIP, 20)
+	io.WriteString(out, b.String())
+}
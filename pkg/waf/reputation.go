@@ -0,0 +1,180 @@
+package waf
+
+import (
+	"math"
+	"strings"
+	"time"
+)
+
+// Источники мелких событий, снижающих репутацию клиента
+const (
+	reputationNotFound     = "not_found"           // ответ 404
+	reputationRateWarning  = "rate_warning"        // в корзине лимитера осталось меньше четверти токенов
+	reputationRateExceeded = "rate_exceeded"       // лимит запросов превышен
+	reputationNearMiss     = "signature_near_miss" // спецсимволы атак в параметрах без срабатывания сигнатур
+	reputationLoginFailure = "login_failure"       // неудачный вход
+)
+
+// Штрафы по умолчанию: мелкие события и срабатывания модулей по важности
+var defaultReputationWeights = map[string]float64{
+	reputationNotFound:     0.5,
+	reputationRateWarning:  0.5,
+	reputationRateExceeded: 3,
+	reputationNearMiss:     1,
+	reputationLoginFailure: 1,
+	SeverityInfo:           1,
+	SeverityWarning:        3,
+	SeverityCritical:       5,
+}
+
+// reputationScore штраф клиента на момент at; со временем уменьшается вдвое за halfLife
+type reputationScore struct {
+	value float64
+	at    time.Time
+}
+
+// reputationBook локальная репутация клиентов по IP. Каждое мелкое событие и срабатывание
+// добавляет штраф, штраф затухает экспоненциально. Модули снижают пороги для клиентов
+// с плохой репутацией, умножая их на factor.
+type reputationBook struct {
+	states       *StateStore
+	halfLife     time.Duration
+	weights      map[string]float64
+	tightenScore float64 // штраф, при котором пороги снижаются вдвое
+	minFactor    float64
+}
+
+func newReputationBook(states *StateStore, cfg ReputationConfig) *reputationBook {
+	b := &reputationBook{
+		states:       states,
+		halfLife:     time.Hour,
+		weights:      make(map[string]float64),
+		tightenScore: 10,
+		minFactor:    0.25,
+	}
+	for k, v := range defaultReputationWeights {
+		b.weights[k] = v
+	}
+	for k, v := range cfg.Weights {
+		b.weights[k] = v
+	}
+	if cfg.HalfLifeSeconds > 0 {
+		b.halfLife = time.Duration(cfg.HalfLifeSeconds) * time.Second
+	}
+	if cfg.TightenScore > 0 {
+		b.tightenScore = cfg.TightenScore
+	}
+	if cfg.MinFactor > 0 && cfg.MinFactor <= 1 {
+		b.minFactor = cfg.MinFactor
+	}
+	return b
+}
+
+// SetReputation включает локальную репутацию клиентов
+func (w *WAF) SetReputation(cfg ReputationConfig) {
+	if !cfg.Enabled {
+		w.reputation = nil
+		return
+	}
+	w.reputation = newReputationBook(w.states, cfg)
+}
+
+// decayed штраф на момент now; вызывается под st.mu
+func (b *reputationBook) decayed(st *State, now time.Time) *reputationScore {
+	s, _ := st.Meta["reputation"].(*reputationScore)
+	if s == nil {
+		s = &reputationScore{at: now}
+		st.Meta["reputation"] = s
+	}
+	if elapsed := now.Sub(s.at); elapsed > 0 {
+		s.value *= math.Exp2(-float64(elapsed) / float64(b.halfLife))
+		s.at = now
+	}
+	return s
+}
+
+// note добавляет штраф за событие kind
+func (b *reputationBook) note(ip, kind string) {
+	if b == nil {
+		return
+	}
+	b.add(ip, b.weights[kind])
+}
+
+// observe учитывает срабатывание модуля: вес по модулю, иначе по важности
+func (b *reputationBook) observe(ev SecurityEvent) {
+	if b == nil || ev.Type != EventDetection || ev.IP == "" {
+		return
+	}
+	w, ok := b.weights[ev.Module]
+	if !ok {
+		w = b.weights[ev.Severity]
+	}
+	b.add(ev.IP, w)
+}
+
+func (b *reputationBook) add(ip string, penalty float64) {
+	st := b.states.Get(ip)
+	if st == nil || penalty <= 0 {
+		return
+	}
+	st.mu.Lock()
+	b.decayed(st, time.Now()).value += penalty
+	st.mu.Unlock()
+}
+
+// raise поднимает штраф клиента до score, если он меньше (риск от другого экземпляра кластера)
+func (b *reputationBook) raise(ip string, score float64) {
+	if b == nil {
+		return
+	}
+	st := b.states.Get(ip)
+	if st == nil {
+		return
+	}
+	st.mu.Lock()
+	s := b.decayed(st, time.Now())
+	s.value = math.Max(s.value, score)
+	st.mu.Unlock()
+}
+
+// score текущий штраф клиента
+func (b *reputationBook) score(ip string) float64 {
+	if b == nil {
+		return 0
+	}
+	st := b.states.Get(ip)
+	if st == nil {
+		return 0
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return b.decayed(st, time.Now()).value
+}
+
+// factor множитель порогов модулей: 1 для клиента без штрафа, 0.5 при штрафе tighten_score,
+// не меньше min_factor
+func (b *reputationBook) factor(ip string) float64 {
+	if b == nil {
+		return 1
+	}
+	return math.Max(b.minFactor, 1/(1+b.score(ip)/b.tightenScore))
+}
+
+// tighten снижает порог по репутации клиента; порог остается не меньше 1
+func (b *reputationBook) tighten(ip string, threshold int) int {
+	if b == nil {
+		return threshold
+	}
+	return max(1, int(math.Round(float64(threshold)*b.factor(ip))))
+}
+
+// nearMiss значение похоже на попытку атаки, но сигнатуры не сработали
+func nearMiss(s string) bool {
+	return strings.ContainsAny(s, "'\"<>`;") || strings.Contains(s, "../") || strings.Contains(s, "--")
+}
+
+// ClientReputation текущий штраф репутации клиента (0 — репутация не ведется или клиент чист)
+func (w *WAF) ClientReputation(ip string) float64 {
+	return w.reputation.score(ip)
+}
@@ -0,0 +1,165 @@
+package waf
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// requestInfo контекст анализа запроса, общий для всех модулей цепи: идентификатор,
+// адрес клиента, проверенные баны, разобранные параметры, результаты нормализации
+// и накопленные срабатывания. Создается на входе в цепь (Handler); вне цепи
+// помощники вычисляют значения заново.
+type requestInfo struct {
+	id       string
+	upstream bool            // запрос передан бэкенду: ответ с ошибкой пришел не от WAF
+	echo     bool            // ответ WAF — текстовый отказ, в конец которого дописывается идентификатор
+	engine   *decisionEngine // движок решений цепи; nil — детекторы применяют действия сами
+	bot      string          // подтвержденный поисковый робот (good_bots)
+	device   string          // идентификатор устройства из проверенной cookie (device)
+
+	rawHeaders []string // имена заголовков в исходном порядке и регистре; nil — не записывались
+
+	mu         sync.Mutex
+	remote     string // RemoteAddr, для которого вычислен ip
+	ip         string
+	notBanned  map[banCheck]uint64 // проверка -> поколение списка банов, при котором бана не было
+	params     url.Values
+	paramsDone bool
+	normalized map[string]string
+	findings   []SecurityEvent
+}
+
+type banCheck struct {
+	bans *BanList
+	id   string
+}
+
+type requestInfoKey struct{}
+
+func requestInfoOf(r *http.Request) *requestInfo {
+	info, _ := r.Context().Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// requestID идентификатор запроса или "" вне цепи WAF
+func requestID(r *http.Request) string {
+	if info := requestInfoOf(r); info != nil {
+		return info.id
+	}
+	return ""
+}
+
+// verifiedBot подтвержденный DNS поисковый робот, отправивший запрос, или ""
+func verifiedBot(r *http.Request) string {
+	if info := requestInfoOf(r); info != nil {
+		return info.bot
+	}
+	return ""
+}
+
+// ClientIP возвращает IP клиента запроса (как его видят встроенные модули)
+func ClientIP(r *http.Request) string {
+	info := requestInfoOf(r)
+	if info == nil {
+		return extractIP(r.RemoteAddr)
+	}
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	if info.remote != r.RemoteAddr || info.ip == "" {
+		info.remote, info.ip = r.RemoteAddr, extractIP(r.RemoteAddr)
+	}
+	return info.ip
+}
+
+// banned сообщает, заблокирован ли идентификатор. Отсутствие бана запоминается
+// на время запроса, пока в списке не появится новый бан.
+func (w *WAF) banned(r *http.Request, id string) bool {
+	info := requestInfoOf(r)
+	if info == nil {
+		return w.bans.IsBanned(id)
+	}
+	key := banCheck{w.bans, id}
+	gen := w.bans.generation.Load()
+	info.mu.Lock()
+	checked, ok := info.notBanned[key]
+	info.mu.Unlock()
+	if ok && checked == gen {
+		return false
+	}
+	if w.bans.IsBanned(id) {
+		return true
+	}
+	info.mu.Lock()
+	if info.notBanned == nil {
+		info.notBanned = make(map[banCheck]uint64)
+	}
+	info.notBanned[key] = gen
+	info.mu.Unlock()
+	return false
+}
+
+// requestParams параметры query и url-encoded тела (paramsOf), разобранные один раз за запрос
+func requestParams(r *http.Request) url.Values {
+	info := requestInfoOf(r)
+	if info == nil {
+		return paramsOf(r)
+	}
+	info.mu.Lock()
+	done := info.paramsDone
+	info.mu.Unlock()
+	if !done {
+		params := paramsOf(r)
+		info.mu.Lock()
+		info.params, info.paramsDone = params, true
+		info.mu.Unlock()
+	}
+	// Копия: модули могут дополнять параметры
+	out := make(url.Values, len(info.params))
+	for k, vs := range info.params {
+		out[k] = append([]string(nil), vs...)
+	}
+	return out
+}
+
+// normalized результат normalizeForSignature для строки запроса; одинаковые строки
+// (путь, query, значения параметров) нормализуются один раз за запрос
+func normalized(r *http.Request, s string) string {
+	info := requestInfoOf(r)
+	if info == nil {
+		return normalizeForSignature(s)
+	}
+	info.mu.Lock()
+	n, ok := info.normalized[s]
+	info.mu.Unlock()
+	if ok {
+		return n
+	}
+	n = normalizeForSignature(s)
+	info.mu.Lock()
+	if info.normalized == nil {
+		info.normalized = make(map[string]string)
+	}
+	info.normalized[s] = n
+	info.mu.Unlock()
+	return n
+}
+
+// addFinding запоминает срабатывание модуля по запросу
+func (info *requestInfo) addFinding(ev SecurityEvent) {
+	info.mu.Lock()
+	info.findings = append(info.findings, ev)
+	info.mu.Unlock()
+}
+
+// Findings возвращает срабатывания модулей, накопленные по запросу к текущему моменту.
+// Модуль может учитывать выводы предыдущих модулей цепи, не повторяя их проверки.
+func Findings(r *http.Request) []SecurityEvent {
+	info := requestInfoOf(r)
+	if info == nil {
+		return nil
+	}
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	return append([]SecurityEvent(nil), info.findings...)
+}
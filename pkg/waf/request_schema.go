@@ -0,0 +1,146 @@
+package waf
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// defaultMaxSchemaBodySize максимальный размер JSON тела для проверки по схеме
+const defaultMaxSchemaBodySize = 1 << 20
+
+// schemaRoute JSON Schema, привязанная к маршруту и методам
+type schemaRoute struct {
+	pattern routePattern
+	methods map[string]bool
+	schema  *jsonSchema
+}
+
+// RequestSchemaMiddleware проверяет JSON тела запросов по схемам, привязанным к маршрутам.
+// Блокирует mass-assignment (лишние поля при additionalProperties: false)
+// и подмену типов до того, как запрос попадет на бэкенд.
+type RequestSchemaMiddleware struct {
+	waf           *WAF
+	routes        []schemaRoute
+	maxBodySize   int64
+	action        string // block, log
+	logDetections bool
+}
+
+// NewRequestSchemaMiddlewareWithConfig загружает схемы маршрутов и создает middleware
+func NewRequestSchemaMiddlewareWithConfig(w *WAF, cfg RequestSchemaConfig) (*RequestSchemaMiddleware, error) {
+	m := &RequestSchemaMiddleware{
+		waf:           w,
+		maxBodySize:   defaultMaxSchemaBodySize,
+		action:        "block",
+		logDetections: true,
+	}
+	if cfg.MaxBodyBytes > 0 {
+		m.maxBodySize = cfg.MaxBodyBytes
+	}
+	if cfg.Action != "" {
+		m.action = cfg.Action
+	}
+
+	for _, rc := range cfg.Routes {
+		raw := []byte(rc.Schema)
+		if rc.SchemaPath != "" {
+			data, err := os.ReadFile(rc.SchemaPath)
+			if err != nil {
+				return nil, err
+			}
+			raw = data
+		}
+		if len(raw) == 0 {
+			return nil, fmt.Errorf("json_schema route %s: schema is empty", rc.Path)
+		}
+		schema := &jsonSchema{}
+		if err := json.Unmarshal(raw, schema); err != nil {
+			return nil, fmt.Errorf("json_schema route %s: %w", rc.Path, err)
+		}
+		route := schemaRoute{pattern: compileRoutePattern(rc.Path), schema: schema}
+		if len(rc.Methods) > 0 {
+			route.methods = make(map[string]bool, len(rc.Methods))
+			for _, method := range rc.Methods {
+				route.methods[strings.ToUpper(method)] = true
+			}
+		}
+		m.routes = append(m.routes, route)
+	}
+	return m, nil
+}
+
+func (m *RequestSchemaMiddleware) push(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.waf == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := ClientIP(r)
+		if m.waf.banned(r, ip) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		schema := m.findSchema(r)
+		if schema == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if err := m.validate(r, schema); err != nil {
+			ev := requestEvent(r, ip, "json_schema", SeverityWarning, m.action, fmt.Sprintf("Тело запроса не соответствует схеме от %s: %s %s: %v", ip, r.Method, r.URL.Path, err))
+			if m.waf.decide(r, ev, m.logDetections, func() bool {
+				if m.action != "block" {
+					return false
+				}
+				http.Error(w, "Bad Request", http.StatusBadRequest)
+				return true
+			}) {
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// findSchema возвращает схему первого подходящего маршрута
+func (m *RequestSchemaMiddleware) findSchema(r *http.Request) *jsonSchema {
+	for _, route := range m.routes {
+		if route.methods != nil && !route.methods[r.Method] {
+			continue
+		}
+		if _, ok := route.pattern.match(r.URL.Path); ok {
+			return route.schema
+		}
+	}
+	return nil
+}
+
+// validate читает тело (не более maxBodySize) и проверяет его по схеме
+func (m *RequestSchemaMiddleware) validate(r *http.Request, schema *jsonSchema) error {
+	return validateJSONRequestBody(r, schema, nil, m.maxBodySize)
+}
+
+// validateJSONRequestBody проверяет JSON тело запроса по схеме.
+// Тело, превышающее max, отклоняется: проверить его целиком невозможно.
+func validateJSONRequestBody(r *http.Request, schema *jsonSchema, schemas map[string]*jsonSchema, max int64) error {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+		return fmt.Errorf("ожидался JSON, получен Content-Type %q", mediaType)
+	}
+	body, err := peekBody(r, max+1)
+	if err != nil {
+		return err
+	}
+	if int64(len(body)) > max {
+		return errors.New("тело превышает лимит проверки")
+	}
+	return schema.validateJSONBody(body, schemas)
+}
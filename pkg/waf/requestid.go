@@ -0,0 +1,92 @@
+package waf
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const defaultRequestIDHeader = "X-Request-ID"
+
+// requestIDs настройки идентификаторов запросов; задаются при запуске и не перезагружаются
+type requestIDs struct {
+	header string
+	trust  bool // принимать идентификатор, выставленный балансировщиком перед WAF
+}
+
+func newRequestIDs(cfg RequestIDConfig) requestIDs {
+	ids := requestIDs{header: defaultRequestIDHeader, trust: cfg.TrustIncoming}
+	if cfg.Header != "" {
+		ids.header = http.CanonicalHeaderKey(cfg.Header)
+	}
+	return ids
+}
+
+// SetRequestID задает заголовок идентификатора запроса. Идентификатор создается для каждого
+// запроса, передается бэкенду, возвращается клиенту и попадает в события.
+func (w *WAF) SetRequestID(cfg RequestIDConfig) {
+	w.requestIDs = newRequestIDs(cfg)
+}
+
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// validRequestID ограничивает принимаемые от клиента идентификаторы, чтобы через них
+// нельзя было внедрить строки в журналы
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_.:", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// assign присваивает запросу идентификатор: передает его бэкенду в заголовке, возвращает
+// клиенту в заголовке ответа и дописывает в текстовые отказы WAF. finish вызывается
+// после обработки запроса цепью.
+func (ids requestIDs) assign(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *http.Request, func()) {
+	header := ids.header
+	if header == "" {
+		header = defaultRequestIDHeader
+	}
+	id := r.Header.Get(header)
+	if !ids.trust || !validRequestID(id) {
+		id = newRequestID()
+	}
+	r.Header.Set(header, id)
+	w.Header().Set(header, id)
+	info := &requestInfo{id: id}
+	hw := newHeaderHookWriter(w, func(status int, h http.Header) {
+		// Set заменяет идентификатор, который мог вернуть бэкенд
+		h.Set(header, id)
+		if status >= 400 && !info.upstream && h.Get("Content-Length") == "" && strings.HasPrefix(h.Get("Content-Type"), "text/plain") {
+			info.echo = true
+		}
+	})
+	finish := func() {
+		if info.echo {
+			io.WriteString(hw, "Request ID: "+id+"\n")
+		}
+	}
+	return hw, r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)), finish
+}
+
+// markUpstream отмечает передачу запроса обработчику за цепью модулей
+func markUpstream(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if info := requestInfoOf(r); info != nil {
+			info.upstream = true
+		}
+		next.ServeHTTP(w, withForwardPath(r))
+	})
+}
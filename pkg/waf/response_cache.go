@@ -0,0 +1,358 @@
+package waf
+
+import (
+	"container/list"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheableStatuses коды ответов, которые можно кешировать (RFC 9111, эвристически кешируемые)
+var cacheableStatuses = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusMovedPermanently:     true,
+	http.StatusNotFound:             true,
+	http.StatusGone:                 true,
+}
+
+// cacheEntry закешированный ответ бэкенда
+type cacheEntry struct {
+	key        string
+	status     int
+	header     http.Header
+	body       []byte
+	storedAt   time.Time
+	freshUntil time.Time
+	staleUntil time.Time // до этого момента ответ можно отдавать при ошибке бэкенда
+	elem       *list.Element
+}
+
+// CacheMiddleware кеширует ответы на GET запросы в памяти с ограничением размера (LRU).
+// Срок жизни берется из Cache-Control (s-maxage, max-age) или Expires ответа;
+// no-store, private, no-cache и ответы с Set-Cookie не кешируются.
+// Если бэкенд недоступен или отвечает 5xx, отдается устаревшая копия (stale-if-error).
+// Директивы Cache-Control запроса игнорируются, чтобы флуд с no-cache не проходил к бэкенду.
+type CacheMiddleware struct {
+	waf          *WAF
+	maxBytes     int64
+	maxEntrySize int
+	defaultTTL   time.Duration
+	staleIfError time.Duration
+	mu           sync.Mutex
+	entries      map[string]*cacheEntry
+	vary         map[string][]string // базовый ключ -> имена заголовков из Vary
+	lru          *list.List
+	size         int64
+}
+
+// NewCacheMiddleware создает кеш ответов с дефолт настройками
+func NewCacheMiddleware(w *WAF) *CacheMiddleware {
+	return &CacheMiddleware{
+		waf:          w,
+		maxBytes:     64 << 20,
+		maxEntrySize: 1 << 20,
+		staleIfError: 5 * time.Minute,
+		entries:      make(map[string]*cacheEntry),
+		vary:         make(map[string][]string),
+		lru:          list.New(),
+	}
+}
+
+// NewCacheMiddlewareWithConfig создает кеш ответов из конфига
+func NewCacheMiddlewareWithConfig(w *WAF, cfg CacheConfig) *CacheMiddleware {
+	m := NewCacheMiddleware(w)
+	if cfg.MaxSizeMB > 0 {
+		m.maxBytes = int64(cfg.MaxSizeMB) << 20
+	}
+	if cfg.MaxEntryKB > 0 {
+		m.maxEntrySize = cfg.MaxEntryKB << 10
+	}
+	if cfg.DefaultTTLSeconds > 0 {
+		m.defaultTTL = time.Duration(cfg.DefaultTTLSeconds) * time.Second
+	}
+	if cfg.StaleIfErrorSeconds > 0 {
+		m.staleIfError = time.Duration(cfg.StaleIfErrorSeconds) * time.Second
+	}
+	return m
+}
+
+func (m *CacheMiddleware) push(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.waf == nil || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := ClientIP(r)
+		if m.waf.banned(r, ip) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		now := time.Now()
+		entry := m.lookup(r)
+		if entry != nil && now.Before(entry.freshUntil) {
+			m.serve(w, r, entry, "HIT")
+			return
+		}
+
+		// HEAD не заполняет кеш: у ответа нет тела
+		if r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var stale *cacheEntry
+		if entry != nil && now.Before(entry.staleUntil) {
+			stale = entry
+		}
+		rec := &cacheRecorder{ResponseWriter: w, status: http.StatusOK, limit: m.maxEntrySize, holdErrors: stale != nil}
+		rec.Header().Set("X-Cache", "MISS")
+		next.ServeHTTP(rec, r)
+
+		if rec.failed {
+			m.serve(w, r, stale, "STALE")
+			return
+		}
+		if !rec.overflow {
+			m.store(r, rec)
+		}
+	})
+}
+
+// lookup ищет ответ для запроса с учетом заголовков из Vary
+func (m *CacheMiddleware) lookup(r *http.Request) *cacheEntry {
+	base := cacheBaseKey(r)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.entries[cacheVaryKey(base, m.vary[base], r)]
+	if !ok {
+		return nil
+	}
+	m.lru.MoveToFront(entry.elem)
+	return entry
+}
+
+// serve отдает закешированный ответ
+func (m *CacheMiddleware) serve(w http.ResponseWriter, r *http.Request, entry *cacheEntry, result string) {
+	h := w.Header()
+	for k := range h {
+		delete(h, k)
+	}
+	for k, v := range entry.header {
+		h[k] = append([]string(nil), v...)
+	}
+	h.Set("Age", strconv.Itoa(int(time.Since(entry.storedAt).Seconds())))
+	h.Set("X-Cache", result)
+	w.WriteHeader(entry.status)
+	if r.Method != http.MethodHead {
+		w.Write(entry.body)
+	}
+}
+
+// store сохраняет ответ, если он кешируемый
+func (m *CacheMiddleware) store(r *http.Request, rec *cacheRecorder) {
+	header := rec.Header().Clone()
+	header.Del("X-Cache")
+	ttl, ok := m.freshness(r, rec.status, header)
+	if !ok {
+		return
+	}
+
+	var varyNames []string
+	for _, v := range header.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "*" {
+				return
+			}
+			if name != "" {
+				varyNames = append(varyNames, name)
+			}
+		}
+	}
+	sort.Strings(varyNames)
+
+	now := time.Now()
+	base := cacheBaseKey(r)
+	entry := &cacheEntry{
+		key:        cacheVaryKey(base, varyNames, r),
+		status:     rec.status,
+		header:     header,
+		body:       rec.body,
+		storedAt:   now,
+		freshUntil: now.Add(ttl),
+		staleUntil: now.Add(ttl + m.staleIfError),
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(varyNames) > 0 {
+		m.vary[base] = varyNames
+	} else {
+		delete(m.vary, base)
+	}
+	if old, ok := m.entries[entry.key]; ok {
+		m.remove(old)
+	}
+	entry.elem = m.lru.PushFront(entry)
+	m.entries[entry.key] = entry
+	m.size += int64(len(entry.body))
+	for m.size > m.maxBytes && m.lru.Len() > 0 {
+		m.remove(m.lru.Back().Value.(*cacheEntry))
+	}
+}
+
+// remove удаляет запись; вызывается под m.mu
+func (m *CacheMiddleware) remove(entry *cacheEntry) {
+	m.lru.Remove(entry.elem)
+	delete(m.entries, entry.key)
+	m.size -= int64(len(entry.body))
+}
+
+// freshness возвращает срок свежести ответа и признак того, что его можно кешировать
+func (m *CacheMiddleware) freshness(r *http.Request, status int, h http.Header) (time.Duration, bool) {
+	if !cacheableStatuses[status] || h.Get("Set-Cookie") != "" {
+		return 0, false
+	}
+	directives := parseCacheControl(h.Get("Cache-Control"))
+	if _, ok := directives["no-store"]; ok {
+		return 0, false
+	}
+	if _, ok := directives["private"]; ok {
+		return 0, false
+	}
+	if _, ok := directives["no-cache"]; ok {
+		return 0, false
+	}
+	_, public := directives["public"]
+	sMaxAge, hasSMaxAge := directives["s-maxage"]
+	// Ответы на запросы с авторизацией кешируются только при явном разрешении
+	if r.Header.Get("Authorization") != "" && !public && !hasSMaxAge {
+		return 0, false
+	}
+
+	var ttl time.Duration
+	switch {
+	case hasSMaxAge:
+		ttl = parseCacheSeconds(sMaxAge)
+	case directives["max-age"] != "":
+		ttl = parseCacheSeconds(directives["max-age"])
+	case h.Get("Expires") != "":
+		if exp, err := http.ParseTime(h.Get("Expires")); err == nil {
+			ttl = time.Until(exp)
+		}
+	default:
+		ttl = m.defaultTTL
+	}
+	return ttl, ttl > 0
+}
+
+// parseCacheControl разбирает директивы Cache-Control в map (значение без кавычек)
+func parseCacheControl(v string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(v, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(part, "=")
+		directives[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+	return directives
+}
+
+func parseCacheSeconds(v string) time.Duration {
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return time.Duration(n) * time.Second
+}
+
+// cacheBaseKey ключ кеша без учета Vary
+func cacheBaseKey(r *http.Request) string {
+	return strings.ToLower(r.Host) + r.URL.RequestURI()
+}
+
+// cacheVaryKey дополняет базовый ключ значениями заголовков из Vary
+func cacheVaryKey(base string, names []string, r *http.Request) string {
+	if len(names) == 0 {
+		return base
+	}
+	var b strings.Builder
+	b.WriteString(base)
+	for _, name := range names {
+		b.WriteString("\n")
+		b.WriteString(name)
+		b.WriteString(": ")
+		b.WriteString(strings.Join(r.Header.Values(name), ","))
+	}
+	return b.String()
+}
+
+// cacheRecorder пропускает ответ клиенту, сохраняя копию тела не больше limit байт.
+// При holdErrors ответы 5xx не отправляются клиенту: вместо них отдается устаревшая копия.
+type cacheRecorder struct {
+	http.ResponseWriter
+	status      int
+	body        []byte
+	limit       int
+	overflow    bool
+	holdErrors  bool
+	failed      bool
+	wroteHeader bool
+}
+
+func (c *cacheRecorder) WriteHeader(code int) {
+	if c.wroteHeader {
+		return
+	}
+	c.wroteHeader = true
+	c.status = code
+	if c.holdErrors && code >= 500 {
+		c.failed = true
+		return
+	}
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *cacheRecorder) Write(b []byte) (int, error) {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	if c.failed {
+		return len(b), nil
+	}
+	if !c.overflow {
+		if len(c.body)+len(b) > c.limit {
+			c.overflow = true
+			c.body = nil
+		} else {
+			c.body = append(c.body, b...)
+		}
+	}
+	return c.ResponseWriter.Write(b)
+}
+
+// Flush пробрасывает сброс буфера для потоковых ответов
+func (c *cacheRecorder) Flush() {
+	if c.failed {
+		return
+	}
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap позволяет http.ResponseController добраться до исходного writer
+func (c *cacheRecorder) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
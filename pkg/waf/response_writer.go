@@ -0,0 +1,72 @@
+package waf
+
+import "net/http"
+
+// statusRecorder запоминает код ответа бэкенда для анализа после проксирования
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func newStatusRecorder(w http.ResponseWriter) *statusRecorder {
+	return &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+// Flush пробрасывает сброс буфера для потоковых ответов
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap позволяет http.ResponseController добраться до исходного writer
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// headerHookWriter вызывает hook над заголовками ответа непосредственно перед их отправкой.
+// Используется для переписывания заголовков, выставленных бэкендом.
+type headerHookWriter struct {
+	http.ResponseWriter
+	hook  func(status int, h http.Header)
+	wrote bool
+}
+
+func newHeaderHookWriter(w http.ResponseWriter, hook func(status int, h http.Header)) *headerHookWriter {
+	return &headerHookWriter{ResponseWriter: w, hook: hook}
+}
+
+func (h *headerHookWriter) WriteHeader(code int) {
+	if !h.wrote {
+		h.wrote = true
+		h.hook(code, h.ResponseWriter.Header())
+	}
+	h.ResponseWriter.WriteHeader(code)
+}
+
+func (h *headerHookWriter) Write(b []byte) (int, error) {
+	if !h.wrote {
+		h.WriteHeader(http.StatusOK)
+	}
+	return h.ResponseWriter.Write(b)
+}
+
+// Flush пробрасывает сброс буфера для потоковых ответов
+func (h *headerHookWriter) Flush() {
+	if !h.wrote {
+		h.WriteHeader(http.StatusOK)
+	}
+	if f, ok := h.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap позволяет http.ResponseController добраться до исходного writer
+func (h *headerHookWriter) Unwrap() http.ResponseWriter {
+	return h.ResponseWriter
+}
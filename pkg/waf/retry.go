@@ -0,0 +1,275 @@
+package waf
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// idempotentMethods методы, которые безопасно повторять (RFC 9110, 9.2.2)
+var idempotentMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+	http.MethodPut:     true,
+	http.MethodDelete:  true,
+}
+
+// maxRetryBodySize запросы с телом больше этого размера не повторяются
+const maxRetryBodySize = 64 << 10
+
+// retryRoute число повторов для маршрута
+type retryRoute struct {
+	pattern    routePattern
+	maxRetries int
+}
+
+// retryBudget ограничивает долю повторов от числа запросов за окно,
+// чтобы повторы не умножали нагрузку на падающий бэкенд
+type retryBudget struct {
+	mu          sync.Mutex
+	ratio       float64
+	minRetries  int
+	window      time.Duration
+	windowStart time.Time
+	requests    int
+	retries     int
+}
+
+// request учитывает запрос
+func (b *retryBudget) request() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rotate()
+	b.requests++
+}
+
+// withdraw разрешает повтор, если бюджет не исчерпан
+func (b *retryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rotate()
+	if b.retries >= b.minRetries && float64(b.retries+1) > b.ratio*float64(b.requests) {
+		return false
+	}
+	b.retries++
+	return true
+}
+
+func (b *retryBudget) rotate() {
+	if now := time.Now(); now.Sub(b.windowStart) > b.window {
+		b.windowStart = now
+		b.requests = 0
+		b.retries = 0
+	}
+}
+
+// RetryMiddleware повторяет идемпотентные запросы при ошибках соединения и 5xx бэкенда
+// с экспоненциальной задержкой. Общий бюджет повторов не дает им усилить отказ бэкенда.
+// С пулом бэкендов повтор уходит на следующий бэкенд.
+type RetryMiddleware struct {
+	waf           *WAF
+	maxRetries    int
+	routes        []retryRoute
+	retryStatuses map[int]bool
+	backoff       time.Duration
+	maxBackoff    time.Duration
+	budget        *retryBudget
+	logDetections bool
+}
+
+// NewRetryMiddlewareWithConfig создает политику повторов из конфига
+func NewRetryMiddlewareWithConfig(w *WAF, cfg RetryConfig) *RetryMiddleware {
+	m := &RetryMiddleware{
+		waf:        w,
+		maxRetries: 2,
+		retryStatuses: map[int]bool{
+			http.StatusBadGateway:         true,
+			http.StatusServiceUnavailable: true,
+			http.StatusGatewayTimeout:     true,
+		},
+		backoff:    50 * time.Millisecond,
+		maxBackoff: time.Second,
+		budget: &retryBudget{
+			ratio:      0.2,
+			minRetries: 10,
+			window:     10 * time.Second,
+		},
+		logDetections: true,
+	}
+	if cfg.MaxRetries > 0 {
+		m.maxRetries = cfg.MaxRetries
+	}
+	for _, rt := range cfg.Routes {
+		m.routes = append(m.routes, retryRoute{pattern: compileRoutePattern(rt.Path), maxRetries: rt.MaxRetries})
+	}
+	if len(cfg.RetryStatuses) > 0 {
+		m.retryStatuses = make(map[int]bool, len(cfg.RetryStatuses))
+		for _, code := range cfg.RetryStatuses {
+			m.retryStatuses[code] = true
+		}
+	}
+	if cfg.BackoffMs > 0 {
+		m.backoff = time.Duration(cfg.BackoffMs) * time.Millisecond
+	}
+	if cfg.MaxBackoffMs > 0 {
+		m.maxBackoff = time.Duration(cfg.MaxBackoffMs) * time.Millisecond
+	}
+	if cfg.Budget.Ratio > 0 {
+		m.budget.ratio = cfg.Budget.Ratio
+	}
+	if cfg.Budget.MinRetries > 0 {
+		m.budget.minRetries = cfg.Budget.MinRetries
+	}
+	if cfg.Budget.WindowSeconds > 0 {
+		m.budget.window = time.Duration(cfg.Budget.WindowSeconds) * time.Second
+	}
+	return m
+}
+
+func (m *RetryMiddleware) push(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.waf == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := ClientIP(r)
+		if m.waf.banned(r, ip) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		m.budget.request()
+		retries := m.retriesFor(r.URL.Path)
+		if retries == 0 || !idempotentMethods[r.Method] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Тело нужно отправить повторно: буферизовать небольшие тела, большие не повторять
+		var body []byte
+		if r.Body != nil && r.Body != http.NoBody {
+			head, err := peekBody(r, maxRetryBodySize+1)
+			if err != nil || len(head) > maxRetryBodySize {
+				next.ServeHTTP(w, r)
+				return
+			}
+			body = head
+		}
+
+		header := w.Header().Clone()
+		for attempt := 0; ; attempt++ {
+			if body != nil {
+				r.Body = io.NopCloser(bytes.NewReader(body))
+			}
+			last := attempt >= retries
+			rw := &retryWriter{ResponseWriter: w, statuses: m.retryStatuses, holdErrors: !last}
+			next.ServeHTTP(rw, r)
+			if !rw.failed {
+				return
+			}
+			if !m.budget.withdraw() {
+				if m.logDetections {
+					log.Printf("[WAF] Бюджет повторов исчерпан, %s %s не повторяется", r.Method, r.URL.Path)
+				}
+				resetHeader(w.Header(), header)
+				http.Error(w, http.StatusText(rw.status), rw.status)
+				return
+			}
+			if r.Context().Err() != nil {
+				return
+			}
+
+			// Заголовки неудачной попытки не должны попасть в ответ клиенту
+			resetHeader(w.Header(), header)
+			time.Sleep(m.delay(attempt))
+		}
+	})
+}
+
+// retriesFor возвращает число повторов для пути (первый совпавший маршрут, иначе общее)
+func (m *RetryMiddleware) retriesFor(path string) int {
+	for _, rt := range m.routes {
+		if _, ok := rt.pattern.match(path); ok {
+			return rt.maxRetries
+		}
+	}
+	return m.maxRetries
+}
+
+// delay экспоненциальная задержка с разбросом (full jitter)
+func (m *RetryMiddleware) delay(attempt int) time.Duration {
+	d := m.backoff << uint(attempt)
+	if d <= 0 || d > m.maxBackoff {
+		d = m.maxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// resetHeader заменяет содержимое h копией snapshot
+func resetHeader(h, snapshot http.Header) {
+	for k := range h {
+		delete(h, k)
+	}
+	for k, v := range snapshot {
+		h[k] = append([]string(nil), v...)
+	}
+}
+
+// retryWriter при holdErrors не отправляет клиенту ответы с кодом из statuses,
+// чтобы запрос можно было повторить
+type retryWriter struct {
+	http.ResponseWriter
+	statuses    map[int]bool
+	holdErrors  bool
+	status      int
+	failed      bool
+	wroteHeader bool
+}
+
+func (rw *retryWriter) WriteHeader(code int) {
+	if rw.wroteHeader {
+		return
+	}
+	rw.wroteHeader = true
+	rw.status = code
+	if rw.holdErrors && rw.statuses[code] {
+		rw.failed = true
+		return
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *retryWriter) Write(b []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if rw.failed {
+		return len(b), nil
+	}
+	return rw.ResponseWriter.Write(b)
+}
+
+// Flush пробрасывает сброс буфера для потоковых ответов
+func (rw *retryWriter) Flush() {
+	if rw.failed {
+		return
+	}
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap позволяет http.ResponseController добраться до исходного writer
+func (rw *retryWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -0,0 +1,244 @@
+package waf
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// defaultMaxRewriteBodySize ответы больше этого размера передаются без переписывания тела
+const defaultMaxRewriteBodySize = 2 << 20
+
+// pathPrefixRule заменяет префикс пути
+type pathPrefixRule struct {
+	from, to string
+}
+
+// regexRule замена по регулярному выражению ($1 и ${name} в replacement)
+type regexRule struct {
+	re          *regexp.Regexp
+	replacement []byte
+}
+
+// RewriteMiddleware переписывает запросы и ответы: удаляет и выставляет заголовки,
+// заменяет префиксы и части пути, переписывает тела ответов регулярными выражениями.
+type RewriteMiddleware struct {
+	waf                   *WAF
+	requestRemoveHeaders  []string
+	requestSetHeaders     map[string]string
+	pathPrefixes          []pathPrefixRule
+	pathRegexes           []regexRule
+	responseRemoveHeaders []string
+	responseSetHeaders    map[string]string
+	bodyRules             []regexRule
+	bodyContentTypes      []string
+	maxBodySize           int
+}
+
+// NewRewriteMiddlewareWithConfig создает модуль переписывания из конфига
+func NewRewriteMiddlewareWithConfig(w *WAF, cfg RewriteConfig) (*RewriteMiddleware, error) {
+	m := &RewriteMiddleware{
+		waf:                   w,
+		requestRemoveHeaders:  cfg.Request.RemoveHeaders,
+		requestSetHeaders:     cfg.Request.SetHeaders,
+		responseRemoveHeaders: cfg.Response.RemoveHeaders,
+		responseSetHeaders:    cfg.Response.SetHeaders,
+		bodyContentTypes:      []string{"text/html"},
+		maxBodySize:           defaultMaxRewriteBodySize,
+	}
+	for _, p := range cfg.Request.PathPrefixes {
+		m.pathPrefixes = append(m.pathPrefixes, pathPrefixRule{from: p.From, to: p.To})
+	}
+	for _, p := range cfg.Request.PathRegex {
+		re, err := regexp.Compile(p.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("path regex %q: %w", p.Pattern, err)
+		}
+		m.pathRegexes = append(m.pathRegexes, regexRule{re: re, replacement: []byte(p.Replacement)})
+	}
+	for _, b := range cfg.Response.BodyRegex {
+		re, err := regexp.Compile(b.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("body regex %q: %w", b.Pattern, err)
+		}
+		m.bodyRules = append(m.bodyRules, regexRule{re: re, replacement: []byte(b.Replacement)})
+	}
+	if len(cfg.Response.BodyContentTypes) > 0 {
+		m.bodyContentTypes = cfg.Response.BodyContentTypes
+	}
+	if cfg.Response.MaxBodyKB > 0 {
+		m.maxBodySize = cfg.Response.MaxBodyKB << 10
+	}
+	return m, nil
+}
+
+func (m *RewriteMiddleware) push(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.waf == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := ClientIP(r)
+		if m.waf.banned(r, ip) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		m.rewriteRequest(r)
+
+		if len(m.bodyRules) == 0 {
+			next.ServeHTTP(newHeaderHookWriter(w, func(_ int, h http.Header) {
+				m.rewriteResponseHeaders(h)
+			}), r)
+			return
+		}
+
+		// Сжатые ответы переписать нельзя: просить бэкенд отвечать без сжатия
+		r.Header.Del("Accept-Encoding")
+		bw := &bodyRewriteWriter{ResponseWriter: w, m: m, status: http.StatusOK}
+		next.ServeHTTP(bw, r)
+		bw.finish()
+	})
+}
+
+// rewriteRequest применяет правила к заголовкам и пути запроса
+func (m *RewriteMiddleware) rewriteRequest(r *http.Request) {
+	for _, h := range m.requestRemoveHeaders {
+		r.Header.Del(h)
+	}
+	for k, v := range m.requestSetHeaders {
+		r.Header.Set(k, v)
+	}
+
+	path := r.URL.Path
+	for _, p := range m.pathPrefixes {
+		if rest, ok := strings.CutPrefix(path, p.from); ok {
+			path = p.to + rest
+			break
+		}
+	}
+	for _, p := range m.pathRegexes {
+		path = string(p.re.ReplaceAll([]byte(path), p.replacement))
+	}
+	if path != r.URL.Path {
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		r.URL.Path = path
+		r.URL.RawPath = ""
+	}
+}
+
+// rewriteResponseHeaders применяет правила к заголовкам ответа
+func (m *RewriteMiddleware) rewriteResponseHeaders(h http.Header) {
+	for _, name := range m.responseRemoveHeaders {
+		h.Del(name)
+	}
+	for k, v := range m.responseSetHeaders {
+		h.Set(k, v)
+	}
+}
+
+// rewritesBody проверяет, нужно ли переписывать тело ответа с такими заголовками
+func (m *RewriteMiddleware) rewritesBody(h http.Header) bool {
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	if n, err := strconv.Atoi(h.Get("Content-Length")); err == nil && n > m.maxBodySize {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	for _, ct := range m.bodyContentTypes {
+		if mediaTypeMatches(ct, mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// bodyRewriteWriter буферизует тело подходящих ответов для замены по регулярным выражениям.
+// Если тело превышает maxBodySize, накопленное отправляется как есть и буферизация прекращается.
+type bodyRewriteWriter struct {
+	http.ResponseWriter
+	m           *RewriteMiddleware
+	status      int
+	wroteHeader bool
+	buffering   bool
+	buf         bytes.Buffer
+}
+
+func (b *bodyRewriteWriter) WriteHeader(code int) {
+	if b.wroteHeader {
+		return
+	}
+	b.wroteHeader = true
+	b.status = code
+	h := b.ResponseWriter.Header()
+	b.m.rewriteResponseHeaders(h)
+	b.buffering = code != http.StatusNoContent && code != http.StatusNotModified && b.m.rewritesBody(h)
+	if !b.buffering {
+		b.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (b *bodyRewriteWriter) Write(p []byte) (int, error) {
+	if !b.wroteHeader {
+		b.WriteHeader(http.StatusOK)
+	}
+	if !b.buffering {
+		return b.ResponseWriter.Write(p)
+	}
+	if b.buf.Len()+len(p) > b.m.maxBodySize {
+		// Слишком большое тело: отправить как есть
+		b.buffering = false
+		b.ResponseWriter.WriteHeader(b.status)
+		if _, err := b.ResponseWriter.Write(b.buf.Bytes()); err != nil {
+			return 0, err
+		}
+		b.buf.Reset()
+		return b.ResponseWriter.Write(p)
+	}
+	return b.buf.Write(p)
+}
+
+// Flush при буферизации откладывается до finish
+func (b *bodyRewriteWriter) Flush() {
+	if !b.wroteHeader {
+		b.WriteHeader(http.StatusOK)
+	}
+	if b.buffering {
+		return
+	}
+	if f, ok := b.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap позволяет http.ResponseController добраться до исходного writer
+func (b *bodyRewriteWriter) Unwrap() http.ResponseWriter {
+	return b.ResponseWriter
+}
+
+// finish применяет замены к буферизованному телу и отправляет ответ
+func (b *bodyRewriteWriter) finish() {
+	if !b.wroteHeader {
+		b.WriteHeader(http.StatusOK)
+	}
+	if !b.buffering {
+		return
+	}
+	body := b.buf.Bytes()
+	for _, rule := range b.m.bodyRules {
+		body = rule.re.ReplaceAll(body, rule.replacement)
+	}
+	h := b.ResponseWriter.Header()
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	h.Del("ETag")
+	b.ResponseWriter.WriteHeader(b.status)
+	b.ResponseWriter.Write(body)
+}
@@ -0,0 +1,20 @@
+package waf
+
+// addRiskScore увеличивает накопленный риск клиента и возвращает новое значение.
+// Риск хранится в Meta состояния и используется модулями поведенческого анализа.
+func addRiskScore(st *State, delta float64) float64 {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	score, _ := st.Meta["risk_score"].(float64)
+	score += delta
+	st.Meta["risk_score"] = score
+	return score
+}
+
+// riskScore возвращает накопленный риск клиента
+func riskScore(st *State) float64 {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	score, _ := st.Meta["risk_score"].(float64)
+	return score
+}
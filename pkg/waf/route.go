@@ -0,0 +1,61 @@
+package waf
+
+import "strings"
+
+// routePattern шаблон маршрута вида /invoices/{id} или /static/*.
+// {name} совпадает с одним любым сегментом пути, * в конце — с любым остатком.
+type routePattern struct {
+	raw      string
+	segments []string
+	wildcard bool
+}
+
+// compileRoutePattern разбирает строковый шаблон маршрута.
+func compileRoutePattern(raw string) routePattern {
+	p := routePattern{raw: raw, segments: splitPathSegments(raw)}
+	if n := len(p.segments); n > 0 && p.segments[n-1] == "*" {
+		p.wildcard = true
+		p.segments = p.segments[:n-1]
+	}
+	return p
+}
+
+// match проверяет путь на соответствие шаблону и возвращает значения {параметров}.
+func (p routePattern) match(path string) (map[string]string, bool) {
+	parts := splitPathSegments(path)
+	if len(parts) < len(p.segments) || (!p.wildcard && len(parts) != len(p.segments)) {
+		return nil, false
+	}
+	var params map[string]string
+	for i, seg := range p.segments {
+		if name, ok := routeParamName(seg); ok {
+			if params == nil {
+				params = make(map[string]string)
+			}
+			params[name] = parts[i]
+			continue
+		}
+		if seg != parts[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// lastParam возвращает значение последнего {параметра} шаблона.
+func (p routePattern) lastParam(params map[string]string) string {
+	for i := len(p.segments) - 1; i >= 0; i-- {
+		if name, ok := routeParamName(p.segments[i]); ok {
+			return params[name]
+		}
+	}
+	return ""
+}
+
+// routeParamName возвращает имя параметра, если сегмент имеет вид {name}.
+func routeParamName(seg string) (string, bool) {
+	if len(seg) > 2 && strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+		return seg[1 : len(seg)-1], true
+	}
+	return "", false
+}
@@ -0,0 +1,301 @@
+package waf
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRuleUpdatesInterval = 5 * time.Minute
+	defaultRuleUpdatesState    = "rule_updates.json"
+	maxRuleBundleBytes         = 10 << 20
+)
+
+// ruleBundleKeys секции конфигурации, которые может менять набор правил. Остальные
+// (цепь модулей, плагины, API администратора, бэкенды) набор не трогает, даже подписанный
+var ruleBundleKeys = map[string]bool{"rules": true, "signature": true, "exclusions": true, "rule_tests": true}
+
+// signedRuleBundle файл набора правил. Подписывается файл целиком, так что версия
+// защищена той же подписью, что и правила
+type signedRuleBundle struct {
+	Version int64           `json:"version"` // растет с каждым выпуском; более старый набор не применяется
+	Config  json.RawMessage `json:"config"`  // секции ruleBundleKeys, накладываются поверх действующей конфигурации
+}
+
+// ruleUpdatesState последний примененный набор; хранится на диске, чтобы после
+// перезапуска старый подписанный набор не применился снова
+type ruleUpdatesState struct {
+	Version   int64     `json:"version"`
+	Digest    string    `json:"digest"` // sha256 набора в hex
+	AppliedAt time.Time `json:"applied_at"`
+}
+
+// checkRuleBundleConfig проверяет, что набор меняет только секции правил
+func checkRuleBundleConfig(raw json.RawMessage) error {
+	var sections map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &sections); err != nil {
+		return err
+	}
+	for k := range sections {
+		if !ruleBundleKeys[k] {
+			return fmt.Errorf("section %q is not allowed in a rule bundle", k)
+		}
+	}
+	return nil
+}
+
+// ruleUpdater периодически загружает набор правил, проверяет подпись Ed25519 и применяет его
+// без перезапуска, так что правила для группы экземпляров WAF поставляются из одного места
+type ruleUpdater struct {
+	waf       *WAF
+	url       string
+	sigURL    string
+	statePath string
+	keys      []ed25519.PublicKey
+	interval  time.Duration
+	client    *http.Client
+	applied   ruleUpdatesState // последний примененный набор, в том числе до перезапуска
+	seen      [32]byte         // sha256 последнего загруженного набора; тот же набор повторно не проверяется
+}
+
+// newRuleUpdater проверяет ключи и адреса из конфига и загружает сохраненную версию
+func newRuleUpdater(w *WAF, base *Config, cfg RuleUpdatesConfig) (*ruleUpdater, error) {
+	u := &ruleUpdater{
+		waf:       w,
+		url:       cfg.URL,
+		sigURL:    cfg.SignatureURL,
+		statePath: cfg.StatePath,
+		interval:  defaultRuleUpdatesInterval,
+		client:    &http.Client{Timeout: 30 * time.Second},
+	}
+	if u.sigURL == "" {
+		u.sigURL = u.url + ".sig"
+	}
+	if u.statePath == "" {
+		u.statePath = defaultRuleUpdatesState
+		if base != nil && base.RuleBundles.Dir != "" {
+			u.statePath = filepath.Join(base.RuleBundles.Dir, defaultRuleUpdatesState)
+		}
+	}
+	if cfg.IntervalSeconds > 0 {
+		u.interval = time.Duration(cfg.IntervalSeconds) * time.Second
+	}
+	if len(cfg.PublicKeys) == 0 {
+		return nil, errors.New("rule_updates: public_keys is empty")
+	}
+	for _, k := range cfg.PublicKeys {
+		raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(k))
+		if err != nil || len(raw) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("rule_updates: invalid Ed25519 public key %q", k)
+		}
+		u.keys = append(u.keys, ed25519.PublicKey(raw))
+	}
+	if w != nil {
+		data, err := os.ReadFile(u.statePath)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+		case err != nil:
+			return nil, fmt.Errorf("rule_updates: %w", err)
+		default:
+			if err := json.Unmarshal(data, &u.applied); err != nil {
+				return nil, fmt.Errorf("rule_updates: %s: %w", u.statePath, err)
+			}
+		}
+	}
+	return u, nil
+}
+
+// saveState записывает примененную версию; файл заменяется целиком
+func (u *ruleUpdater) saveState() error {
+	data, err := json.Marshal(u.applied)
+	if err != nil {
+		return err
+	}
+	tmp := u.statePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, u.statePath)
+}
+
+// run проверяет обновления сразу и затем каждые interval
+func (u *ruleUpdater) run() {
+	for {
+		if err := u.update(); err != nil {
+			log.Printf("[WAF] rule_updates: %v", err)
+		}
+		time.Sleep(u.interval)
+	}
+}
+
+// update загружает набор и подпись и применяет набор, если он новее примененного.
+// Тот же набор, что был применен до перезапуска, применяется снова. Набор с неверной
+// подписью, старой версией, чужими секциями или ошибкой в конфигурации отклоняется,
+// продолжает действовать предыдущий
+func (u *ruleUpdater) update() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	data, err := u.fetch(ctx, u.url)
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256(data)
+	if digest == u.seen {
+		return nil
+	}
+	sig, err := u.fetch(ctx, u.sigURL)
+	if err != nil {
+		return err
+	}
+	bundle, err := u.check(data, sig)
+	if err != nil {
+		u.seen = digest
+		return err
+	}
+
+	// Набор накладывается на действующую конфигурацию, а не на файл: правки через API
+	// администратора в других секциях сохраняются
+	before := u.waf.config()
+	base := before
+	if base == nil {
+		base = &Config{}
+	}
+	cfg, err := mergeConfig(base, bundle.Config)
+	if err == nil {
+		err = u.waf.reload(cfg, "rule_updates")
+	}
+	if err != nil {
+		return fmt.Errorf("bundle version %d rejected: %w", bundle.Version, err)
+	}
+	u.seen = digest
+	u.applied = ruleUpdatesState{Version: bundle.Version, Digest: hex.EncodeToString(digest[:]), AppliedAt: time.Now().UTC()}
+	if err := u.saveState(); err != nil {
+		log.Printf("[WAF] rule_updates: не удалось сохранить версию в %s: %v", u.statePath, err)
+	}
+	u.waf.audit.record(AuditRecord{
+		Actor:  "rule_updates",
+		Action: "config_change",
+		Target: u.url,
+		Before: redactConfig(before),
+		After:  redactConfig(cfg),
+	})
+	log.Printf("[WAF] rule_updates: применен набор правил версии %d из %s", bundle.Version, u.url)
+	return nil
+}
+
+// check проверяет подпись, версию и секции набора. Набор той же версии допускается,
+// только если это тот же примененный набор (повторное применение после перезапуска)
+func (u *ruleUpdater) check(data, sig []byte) (*signedRuleBundle, error) {
+	if err := u.verify(data, sig); err != nil {
+		return nil, err
+	}
+	var bundle signedRuleBundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return nil, fmt.Errorf("%s: %w", u.url, err)
+	}
+	digest := sha256.Sum256(data)
+	if bundle.Version < u.applied.Version || (bundle.Version == u.applied.Version && hex.EncodeToString(digest[:]) != u.applied.Digest) {
+		return nil, fmt.Errorf("%s: bundle version %d is not newer than applied %d", u.url, bundle.Version, u.applied.Version)
+	}
+	if err := checkRuleBundleConfig(bundle.Config); err != nil {
+		return nil, fmt.Errorf("%s: %w", u.url, err)
+	}
+	return &bundle, nil
+}
+
+// verify проверяет подпись (base64) любым из доверенных ключей
+func (u *ruleUpdater) verify(data, sig []byte) error {
+	raw, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sig)))
+	if err != nil || len(raw) != ed25519.SignatureSize {
+		return fmt.Errorf("%s: malformed signature", u.sigURL)
+	}
+	for _, k := range u.keys {
+		if ed25519.Verify(k, data, raw) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: signature verification failed", u.url)
+}
+
+func (u *ruleUpdater) fetch(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxRuleBundleBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxRuleBundleBytes {
+		return nil, fmt.Errorf("GET %s: bundle exceeds %d bytes", url, maxRuleBundleBytes)
+	}
+	return data, nil
+}
+
+// GenerateBundleKey создает ключ подписи наборов правил: закрытый ключ (seed Ed25519
+// в base64) записывается в keyPath, открытый возвращается для rule_updates.public_keys
+func GenerateBundleKey(keyPath string) (string, error) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return "", err
+	}
+	seed := base64.StdEncoding.EncodeToString(priv.Seed()) + "\n"
+	if err := os.WriteFile(keyPath, []byte(seed), 0o600); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(pub), nil
+}
+
+// SignRuleBundle проверяет формат набора правил и записывает подпись в bundlePath.sig
+func SignRuleBundle(keyPath, bundlePath string) (string, error) {
+	key, err := os.ReadFile(keyPath)
+	if err != nil {
+		return "", err
+	}
+	seed, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(key)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return "", fmt.Errorf("%s: invalid Ed25519 key", keyPath)
+	}
+	data, err := os.ReadFile(bundlePath)
+	if err != nil {
+		return "", err
+	}
+	var bundle signedRuleBundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return "", fmt.Errorf("%s: %w", bundlePath, err)
+	}
+	if bundle.Version <= 0 {
+		return "", fmt.Errorf("%s: version must be positive", bundlePath)
+	}
+	if err := checkRuleBundleConfig(bundle.Config); err != nil {
+		return "", fmt.Errorf("%s: config: %w", bundlePath, err)
+	}
+	if _, err := mergeConfig(&Config{}, bundle.Config); err != nil {
+		return "", fmt.Errorf("%s: config: %w", bundlePath, err)
+	}
+	sig := ed25519.Sign(ed25519.NewKeyFromSeed(seed), data)
+	sigPath := bundlePath + ".sig"
+	return sigPath, os.WriteFile(sigPath, []byte(base64.StdEncoding.EncodeToString(sig)+"\n"), 0o644)
+}
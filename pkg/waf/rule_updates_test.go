@@ -0,0 +1,88 @@
+package waf
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRuleUpdaterRejects(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, other, _ := ed25519.GenerateKey(nil)
+	sign := func(k ed25519.PrivateKey, data string) string {
+		return base64.StdEncoding.EncodeToString(ed25519.Sign(k, []byte(data)))
+	}
+	const (
+		v2      = `{"version": 2, "config": {"rules": {"rules": [{"name": "a", "when": "request.path == \"/a\""}]}}}`
+		v2Other = `{"version": 2, "config": {"rules": {"rules": [{"name": "b", "when": "request.path == \"/b\""}]}}}`
+		v1      = `{"version": 1, "config": {"rules": {"rules": []}}}`
+		v3Chain = `{"version": 3, "config": {"middleware_chain": ["rules"]}}`
+	)
+	var bundle, sig string
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".sig") {
+			rw.Write([]byte(sig))
+			return
+		}
+		rw.Write([]byte(bundle))
+	}))
+	defer srv.Close()
+
+	w, err := NewWAF(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := RuleUpdatesConfig{
+		URL:        srv.URL + "/rules.json",
+		PublicKeys: []string{base64.StdEncoding.EncodeToString(pub)},
+		StatePath:  filepath.Join(t.TempDir(), "state.json"),
+	}
+	u, err := newRuleUpdater(w, nil, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle, sig = v2, sign(priv, v2)
+	if err := u.update(); err != nil {
+		t.Fatalf("valid bundle: %v", err)
+	}
+
+	// После перезапуска версия берется из файла состояния
+	restarted, err := newRuleUpdater(w, nil, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name, bundle, sig string
+	}{
+		{"foreign key", v2Other, sign(other, v2Other)},
+		{"malformed signature", v2Other, "not-base64"},
+		{"signature of other data", v2Other, sign(priv, v2)},
+		{"older version", v1, sign(priv, v1)},
+		{"same version, other content", v2Other, sign(priv, v2Other)},
+		{"non-rule section", v3Chain, sign(priv, v3Chain)},
+	} {
+		for _, upd := range []*ruleUpdater{u, restarted} {
+			bundle, sig = tc.bundle, tc.sig
+			upd.seen = [32]byte{}
+			if err := upd.update(); err == nil {
+				t.Errorf("%s: bundle applied", tc.name)
+			}
+		}
+	}
+	if got := w.config().Rules.Rules; len(got) != 1 || got[0].Name != "a" {
+		t.Errorf("rules changed by rejected bundles: %+v", got)
+	}
+
+	// Тот же набор после перезапуска применяется снова
+	bundle, sig = v2, sign(priv, v2)
+	if err := restarted.update(); err != nil {
+		t.Errorf("reapply after restart: %v", err)
+	}
+}
@@ -0,0 +1,207 @@
+package waf
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// customRule правило с условием на языке выражений (см. expr.go)
+type customRule struct {
+	name        string
+	cond        exprNode
+	action      string
+	banDuration time.Duration
+	delay       time.Duration
+	risk        float64
+	canary      float64          // доля клиентов в процентах, к которым правило применяется; 0 — ко всем
+	stats       *canaryRuleStats // счетчики по группам; nil — правило не canary
+}
+
+// RulesMiddleware проверяет пользовательские правила из конфига. Правила проверяются по порядку:
+// allow пропускает запрос без проверки остальных правил, log и delay не прерывают проверку,
+// block/ban/throttle/challenge завершают ее.
+type RulesMiddleware struct {
+	waf           *WAF
+	rules         []customRule
+	lists         map[string]interface{}
+	logDetections bool
+}
+
+// NewRulesMiddlewareWithConfig компилирует выражения правил; ошибка разбора возвращается с именем правила
+func NewRulesMiddlewareWithConfig(w *WAF, cfg RulesConfig) (*RulesMiddleware, error) {
+	m := &RulesMiddleware{
+		waf:           w,
+		lists:         make(map[string]interface{}, len(cfg.Lists)),
+		logDetections: true,
+	}
+	vars := map[string]bool{"request": true, "client": true}
+	for name, values := range cfg.Lists {
+		if vars[name] {
+			return nil, fmt.Errorf("list name %q is reserved", name)
+		}
+		vars[name] = true
+		m.lists[name] = newExprList(values)
+	}
+	for i, rc := range cfg.Rules {
+		name := rc.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+		}
+		cond, err := compileExpr(rc.When, vars)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", name, err)
+		}
+		rule := customRule{
+			name:        name,
+			cond:        cond,
+			action:      rc.Action,
+			banDuration: 10 * time.Minute,
+			delay:       time.Second,
+			risk:        rc.RiskScore,
+		}
+		switch rule.action {
+		case "":
+			rule.action = "block"
+		case "block", "ban", "throttle", "delay", "challenge", "log", "allow":
+		default:
+			return nil, fmt.Errorf("rule %s: unknown action %q", name, rc.Action)
+		}
+		if rc.BanSeconds > 0 {
+			rule.banDuration = time.Duration(rc.BanSeconds) * time.Second
+		}
+		if rc.DelayMs > 0 {
+			rule.delay = time.Duration(rc.DelayMs) * time.Millisecond
+		}
+		canary := rc.CanaryPercent
+		if canary == 0 {
+			canary = cfg.CanaryPercent
+		}
+		if canary < 0 || canary > 100 {
+			return nil, fmt.Errorf("rule %s: canary_percent must be between 0 and 100", name)
+		}
+		if canary > 0 && canary < 100 && rule.action != "log" {
+			rule.canary = canary
+			if w != nil {
+				rule.stats = w.canary.rule(name, canary, rule.action)
+			}
+		}
+		m.rules = append(m.rules, rule)
+	}
+	return m, nil
+}
+
+func (m *RulesMiddleware) push(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.waf == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := ClientIP(r)
+		if m.waf.banned(r, ip) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		st := m.waf.states.Get(ip)
+		env := m.env(r, ip, st)
+		slice := -1.0
+		for _, rule := range m.rules {
+			canary := true
+			if rule.canary > 0 {
+				if slice < 0 {
+					slice = canarySlice(ip)
+				}
+				canary = slice < rule.canary
+				if rule.stats != nil {
+					rule.stats.cohort(canary).requests.Add(1)
+				}
+			}
+			v, err := rule.cond.eval(env)
+			if err != nil {
+				// Ошибка вычисления (например, несовпадение типов) не срабатывает как совпадение
+				log.Printf("[WAF] rules %s: %v", rule.name, err)
+				continue
+			}
+			if matched, _ := v.(bool); !matched {
+				continue
+			}
+			if rule.stats != nil {
+				rule.stats.cohort(canary).hits.Add(1)
+			}
+			if !canary {
+				// Контрольная группа: правило только записывается в журнал
+				ev := requestEvent(r, ip, "rules", SeverityInfo, "log", fmt.Sprintf("Правило %s (%s, canary %g%%) сработало для %s в контрольной группе: %s %s", rule.name, rule.action, rule.canary, ip, r.Method, r.URL.Path))
+				ev.Fields = map[string]interface{}{"rule": rule.name, "cohort": cohortControl, "canary_percent": rule.canary, "canary_action": rule.action}
+				m.waf.decide(r, ev, m.logDetections, func() bool { return false })
+				continue
+			}
+			if rule.risk > 0 {
+				addRiskScore(st, rule.risk)
+			}
+			if rule.action == "allow" {
+				if rule.stats != nil {
+					rule.stats.canary.enforced.Add(1)
+				}
+				break
+			}
+			ev := requestEvent(r, ip, "rules", SeverityWarning, rule.action, fmt.Sprintf("Правило %s (%s) сработало для %s: %s %s", rule.name, rule.action, ip, r.Method, r.URL.Path))
+			ev.Fields = map[string]interface{}{"rule": rule.name}
+			if rule.stats != nil {
+				ev.Fields["cohort"], ev.Fields["canary_percent"] = cohortCanary, rule.canary
+			}
+			if m.waf.decide(r, ev, m.logDetections, func() bool {
+				if rule.stats != nil {
+					rule.stats.canary.enforced.Add(1)
+				}
+				switch rule.action {
+				case "log":
+					return false
+				case "block":
+					return forbid(w)()
+				}
+				return enforceAction(w, r, m.waf, ip, rule.action, rule.banDuration, rule.delay)
+			}) {
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// env переменные выражений: request, client и именованные списки
+func (m *RulesMiddleware) env(r *http.Request, ip string, st *State) map[string]interface{} {
+	headers := make(map[string]string, len(r.Header))
+	for k, v := range r.Header {
+		headers[strings.ToLower(k)] = strings.Join(v, ", ")
+	}
+	query := make(map[string]string)
+	for k, v := range r.URL.Query() {
+		if len(v) > 0 {
+			query[k] = v[0]
+		}
+	}
+	env := make(map[string]interface{}, len(m.lists)+2)
+	for name, l := range m.lists {
+		env[name] = l
+	}
+	env["request"] = map[string]interface{}{
+		"method":     r.Method,
+		"path":       r.URL.Path,
+		"raw_query":  r.URL.RawQuery,
+		"host":       r.Host,
+		"proto":      r.Proto,
+		"user_agent": r.UserAgent(),
+		"headers":    headers,
+		"query":      query,
+	}
+	env["client"] = map[string]interface{}{
+		"ip":         ip,
+		"risk":       riskScore(st),
+		"reputation": m.waf.reputation.score(ip),
+	}
+	return env
+}
@@ -0,0 +1,262 @@
+package waf
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// RuleTestCase проверка правил на одном запросе. Запрос задается полностью (request)
+// или полезной нагрузкой payload, которая подставляется в место target.
+type RuleTestCase struct {
+	Name    string `json:"name"`
+	Payload string `json:"payload"`
+	// query (по умолчанию, параметр q), path, body (форма, поле q), header:<Имя>, cookie:<имя>
+	Target  string           `json:"target"`
+	Request *RuleTestRequest `json:"request"`
+	Expect  []RuleExpect     `json:"expect"`
+	Verdict string           `json:"verdict"` // allow или block; пусто — не проверяется
+}
+
+// RuleTestRequest запрос теста
+type RuleTestRequest struct {
+	Method  string            `json:"method"` // по умолчанию GET, с body — POST
+	URI     string            `json:"uri"`
+	Host    string            `json:"host"`
+	IP      string            `json:"ip"` // по умолчанию свой адрес для каждого теста
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+}
+
+// RuleExpect ожидание по правилу. Идентификатор правила — имя модуля (signature),
+// модуль с именем правила (rules:no-old-api) или модуль с типом атаки (signature:SQLi).
+type RuleExpect struct {
+	Rule  string `json:"rule"`
+	Match bool   `json:"match"`
+}
+
+// RuleTestFailure непройденная проверка
+type RuleTestFailure struct {
+	File     string   `json:"file"`
+	Test     string   `json:"test"`
+	Problem  string   `json:"problem"`
+	Detected []string `json:"detected"` // идентификаторы сработавших правил
+}
+
+// RuleTestReport итоги прогона корпуса тестов
+type RuleTestReport struct {
+	Tests    int               `json:"tests"`
+	Checks   int               `json:"checks"`
+	Failures []RuleTestFailure `json:"failures"`
+}
+
+// RunRuleTests прогоняет корпуса тестов правил (JSON-массивы RuleTestCase) через цепь
+// модулей cfg без обращения к бэкенду. Ошибка возвращается только при невозможности
+// прочитать корпус; непройденные проверки перечисляются в отчете.
+func RunRuleTests(cfg *Config, paths []string) (*RuleTestReport, error) {
+	w, err := newOfflineWAF(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var current []SecurityEvent
+	w.events.tap = func(ev SecurityEvent) {
+		if ev.Type == EventDetection {
+			current = append(current, ev)
+		}
+	}
+
+	rep := &RuleTestReport{}
+	for _, path := range paths {
+		cases, err := loadRuleTests(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		for i, tc := range cases {
+			name := tc.Name
+			if name == "" {
+				name = fmt.Sprintf("#%d", i+1)
+			}
+			req, err := tc.build(rep.Tests)
+			rep.Tests++
+			if err != nil {
+				rep.Failures = append(rep.Failures, RuleTestFailure{File: path, Test: name, Problem: err.Error()})
+				continue
+			}
+			current = nil
+			v := w.authorize(req)
+			detected := ruleIDs(current)
+
+			fail := func(problem string) {
+				rep.Failures = append(rep.Failures, RuleTestFailure{File: path, Test: name, Problem: problem, Detected: detected})
+			}
+			for _, e := range tc.Expect {
+				rep.Checks++
+				if matched := containsRule(detected, e.Rule); matched != e.Match {
+					if e.Match {
+						fail("expected " + e.Rule + " to match")
+					} else {
+						fail("expected " + e.Rule + " not to match")
+					}
+				}
+			}
+			if tc.Verdict != "" {
+				rep.Checks++
+				verdict := "allow"
+				if !v.allowed {
+					verdict = "block"
+				}
+				if verdict != tc.Verdict {
+					fail(fmt.Sprintf("expected verdict %s, got %s (status %d)", tc.Verdict, verdict, v.status))
+				}
+			}
+		}
+	}
+	return rep, nil
+}
+
+func loadRuleTests(path string) ([]RuleTestCase, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var cases []RuleTestCase
+	if err := dec.Decode(&cases); err != nil {
+		return nil, err
+	}
+	return cases, nil
+}
+
+// build собирает запрос теста; адрес клиента у каждого теста свой, чтобы лимиты
+// и баны одного теста не влияли на другие
+func (tc RuleTestCase) build(n int) (*http.Request, error) {
+	rt := RuleTestRequest{URI: "/"}
+	if tc.Request != nil {
+		rt = *tc.Request
+	}
+	if rt.URI == "" {
+		rt.URI = "/"
+	}
+	header := make(http.Header)
+	for k, v := range rt.Headers {
+		header.Set(k, v)
+	}
+	body := rt.Body
+
+	if tc.Payload != "" {
+		target := tc.Target
+		if target == "" {
+			target = "query"
+		}
+		switch {
+		case target == "query":
+			sep := "?"
+			if strings.Contains(rt.URI, "?") {
+				sep = "&"
+			}
+			rt.URI += sep + "q=" + url.QueryEscape(tc.Payload)
+		case target == "path":
+			rt.URI = "/" + url.PathEscape(tc.Payload)
+		case target == "body":
+			body = "q=" + url.QueryEscape(tc.Payload)
+			header.Set("Content-Type", "application/x-www-form-urlencoded")
+		case strings.HasPrefix(target, "header:"):
+			header.Set(strings.TrimPrefix(target, "header:"), tc.Payload)
+		case strings.HasPrefix(target, "cookie:"):
+			header.Add("Cookie", strings.TrimPrefix(target, "cookie:")+"="+url.QueryEscape(tc.Payload))
+		default:
+			return nil, errors.New("unknown target " + target)
+		}
+	}
+	if rt.Method == "" {
+		rt.Method = http.MethodGet
+		if body != "" {
+			rt.Method = http.MethodPost
+		}
+	}
+	if rt.IP == "" {
+		// 198.18.0.0/15 — сеть для тестирования (RFC 2544)
+		rt.IP = net.IPv4(198, 18+byte(n>>16&1), byte(n>>8), byte(n)).String()
+	}
+
+	req, err := http.NewRequest(rt.Method, "http://localhost"+rt.URI, io.NopCloser(strings.NewReader(body)))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	req.RequestURI = rt.URI
+	req.Header = header
+	req.Host = "localhost"
+	if rt.Host != "" {
+		req.Host = rt.Host
+	}
+	req.RemoteAddr = net.JoinHostPort(rt.IP, "0")
+	return req, nil
+}
+
+// ruleIDs идентификаторы сработавших правил в порядке срабатывания
+func ruleIDs(events []SecurityEvent) []string {
+	var ids []string
+	for _, ev := range events {
+		ids = append(ids, ev.Module)
+		for _, key := range []string{"rule", "attack"} {
+			if v, ok := ev.Fields[key].(string); ok && v != "" {
+				ids = append(ids, ev.Module+":"+v)
+			}
+		}
+	}
+	return ids
+}
+
+func containsRule(ids []string, rule string) bool {
+	for _, id := range ids {
+		if strings.EqualFold(id, rule) {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteText выводит итоги в виде отчета для человека
+func (r *RuleTestReport) WriteText(out io.Writer) {
+	for _, f := range r.Failures {
+		fmt.Fprintf(out, "FAIL %s: %s: %s", f.File, f.Test, f.Problem)
+		if len(f.Detected) > 0 {
+			fmt.Fprintf(out, " (сработали: %s)", strings.Join(f.Detected, ", "))
+		}
+		fmt.Fprintln(out)
+	}
+	status := "ok"
+	if len(r.Failures) > 0 {
+		status = "FAIL"
+	}
+	fmt.Fprintf(out, "%s: тестов %d, проверок %d, ошибок %d\n", status, r.Tests, r.Checks, len(r.Failures))
+}
+
+// runStartupRuleTests прогоняет rule_tests перед запуском; при fail_on_error
+// непройденный тест останавливает запуск
+func runStartupRuleTests(cfg *Config) {
+	report, err := RunRuleTests(cfg, cfg.RuleTests.Files)
+	if err != nil {
+		log.Fatalln("Ошибка тестов правил:", err)
+	}
+	if len(report.Failures) == 0 {
+		log.Printf("[WAF] Тесты правил пройдены: тестов %d, проверок %d", report.Tests, report.Checks)
+		return
+	}
+	var b strings.Builder
+	report.WriteText(&b)
+	log.Printf("[WAF] Тесты правил не пройдены:\n%s", b.String())
+	if cfg.RuleTests.FailOnError {
+		log.Fatalln("Запуск остановлен: rule_tests.fail_on_error")
+	}
+}
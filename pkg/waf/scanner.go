@@ -0,0 +1,231 @@
+package waf
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// scannerHeaders заголовки, которые добавляют известные сканеры
+var scannerHeaders = []string{
+	"Acunetix-Product",
+	"Acunetix-Scanning-Agreement",
+	"Acunetix-User-Agreement",
+	"X-Scanner",
+	"X-Wipp",
+	"X-Request-Memo",
+	"X-Nuclei",
+	"Sqlmap",
+}
+
+// ScannerMiddleware распознает сканеры уязвимостей (sqlmap, nikto, nuclei, dirbuster)
+// по User-Agent, путям-зондам, характерным заголовкам и всплескам 404.
+// По умолчанию срабатывание только записывается; с action ban или tarpit IP блокируется
+// сразу или после удержания в tarpit.
+type ScannerMiddleware struct {
+	waf               *WAF
+	uaPatterns        []string
+	pathPatterns      []string
+	notFoundThreshold int
+	notFoundWindow    time.Duration
+	action            string // log, ban, tarpit
+	banDuration       time.Duration
+	tarpitDuration    time.Duration
+	logDetections     bool
+}
+
+// NewScannerMiddleware создает детектор сканеров с паттернами из patterns/
+func NewScannerMiddleware(w *WAF) *ScannerMiddleware {
+	return NewScannerMiddlewareWithConfig(w, ScannerConfig{})
+}
+
+// NewScannerMiddlewareWithConfig создает детектор сканеров из конфига
+func NewScannerMiddlewareWithConfig(w *WAF, cfg ScannerConfig) *ScannerMiddleware {
+	m := &ScannerMiddleware{
+		waf:               w,
+		notFoundThreshold: 30,
+		notFoundWindow:    time.Minute,
+		action:            "log",
+		banDuration:       time.Hour,
+		tarpitDuration:    30 * time.Second,
+		logDetections:     true,
+	}
+
+	uaFile := "patterns/scanner_user_agents.txt"
+	if cfg.UserAgentPatternsFile != "" {
+		uaFile = cfg.UserAgentPatternsFile
+	}
+	pathFile := "patterns/scanner_paths.txt"
+	if cfg.PathPatternsFile != "" {
+		pathFile = cfg.PathPatternsFile
+	}
+	var err error
+	if m.uaPatterns, err = LoadPatternsDynamic("file", uaFile, "txt"); err != nil {
+		log.Printf("[WAF] Ошибка загрузки паттернов User-Agent сканеров: %v", err)
+	}
+	if m.pathPatterns, err = LoadPatternsDynamic("file", pathFile, "txt"); err != nil {
+		log.Printf("[WAF] Ошибка загрузки путей-зондов сканеров: %v", err)
+	}
+	for i := range m.uaPatterns {
+		m.uaPatterns[i] = strings.ToLower(m.uaPatterns[i])
+	}
+	for i := range m.pathPatterns {
+		m.pathPatterns[i] = strings.ToLower(m.pathPatterns[i])
+	}
+
+	if cfg.NotFoundThreshold > 0 {
+		m.notFoundThreshold = cfg.NotFoundThreshold
+	}
+	if cfg.NotFoundWindowSeconds > 0 {
+		m.notFoundWindow = time.Duration(cfg.NotFoundWindowSeconds) * time.Second
+	}
+	if cfg.Action != "" {
+		m.action = cfg.Action
+	}
+	if cfg.BanSeconds > 0 {
+		m.banDuration = time.Duration(cfg.BanSeconds) * time.Second
+	}
+	if cfg.TarpitSeconds > 0 {
+		m.tarpitDuration = time.Duration(cfg.TarpitSeconds) * time.Second
+	}
+	return m
+}
+
+func (m *ScannerMiddleware) push(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.waf == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := ClientIP(r)
+		if m.waf.banned(r, ip) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		if reason := m.fingerprint(r); reason != "" {
+			ev := requestEvent(r, ip, "scanner", SeverityCritical, m.action, fmt.Sprintf("Обнаружен сканер от %s: %s, действие: %s", ip, reason, m.action))
+			if m.waf.decide(r, ev, m.logDetections, func() bool {
+				if m.action == "log" {
+					return false
+				}
+				m.block(w, r, ip)
+				return true
+			}) {
+				return
+			}
+		}
+
+		rec := newStatusRecorder(w)
+		next.ServeHTTP(rec, r)
+
+		// Всплеск 404 — признак перебора путей (dirbuster, ffuf)
+		if rec.status == http.StatusNotFound {
+			m.waf.reputation.note(ip, reputationNotFound)
+			st := m.waf.states.Get(ip)
+			if n := slidingCount(st, "scanner_not_found", m.notFoundWindow, true); n > m.waf.reputation.tighten(ip, m.notFoundThreshold) {
+				action, verdict := "ban", fmt.Sprintf("заблокирован на %s", m.banDuration)
+				if m.action == "log" {
+					action, verdict = "log", "действие: log"
+				}
+				ev := requestEvent(r, ip, "scanner", SeverityCritical, action, fmt.Sprintf("Обнаружен перебор путей от %s: %d ответов 404 за %s, %s", ip, n, m.notFoundWindow, verdict))
+				m.waf.decide(r, ev, m.logDetections, func() bool {
+					if action == "log" {
+						return false
+					}
+					m.waf.bans.Ban(ip, m.banDuration)
+					return true
+				})
+			}
+		}
+	})
+}
+
+// fingerprint возвращает описание найденного признака сканера или пустую строку
+func (m *ScannerMiddleware) fingerprint(r *http.Request) string {
+	ua := strings.ToLower(r.UserAgent())
+	for _, p := range m.uaPatterns {
+		if containsToken(ua, p) {
+			return "User-Agent " + p
+		}
+	}
+	path := strings.ToLower(r.URL.Path)
+	for _, p := range m.pathPatterns {
+		if matchProbePath(path, p) {
+			return "путь-зонд " + strings.TrimPrefix(p, "^")
+		}
+	}
+	for _, h := range scannerHeaders {
+		if r.Header.Get(h) != "" {
+			return "заголовок " + h
+		}
+	}
+	return ""
+}
+
+// containsToken ищет token в s как отдельное слово: до и после него не буква и не цифра,
+// чтобы nikto не находился в случайной строке вроде "ffufnikto1"
+func containsToken(s, token string) bool {
+	if token == "" {
+		return false
+	}
+	for off := 0; ; {
+		i := strings.Index(s[off:], token)
+		if i < 0 {
+			return false
+		}
+		start, end := off+i, off+i+len(token)
+		if (start == 0 || !isTokenChar(s[start-1])) && (end == len(s) || !isTokenChar(s[end])) {
+			return true
+		}
+		off = start + 1
+	}
+}
+
+func isTokenChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// matchProbePath сопоставляет путь с путем-зондом по границам сегментов: /.env находит
+// /.env и /app/.env, но не /.environment. Шаблон с ^ привязан к началу пути,
+// шаблон со слешем на конце совпадает с любым путем внутри каталога.
+func matchProbePath(path, pattern string) bool {
+	anchored := strings.HasPrefix(pattern, "^")
+	pattern = strings.TrimPrefix(pattern, "^")
+	if pattern == "" {
+		return false
+	}
+	dir := strings.HasSuffix(pattern, "/")
+	for off := 0; ; {
+		i := strings.Index(path[off:], pattern)
+		if i < 0 {
+			return false
+		}
+		start, end := off+i, off+i+len(pattern)
+		if anchored && start > 0 {
+			return false
+		}
+		if (start == 0 || pattern[0] == '/' || path[start-1] == '/') && (dir || end == len(path) || path[end] == '/') {
+			return true
+		}
+		off = start + 1
+	}
+}
+
+// block блокирует сканер: сразу (ban) или после удержания соединения (tarpit)
+func (m *ScannerMiddleware) block(w http.ResponseWriter, r *http.Request, ip string) {
+	m.waf.bans.Ban(ip, m.banDuration)
+	if m.action == "tarpit" {
+		timer := time.NewTimer(m.tarpitDuration)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-r.Context().Done():
+			return
+		}
+	}
+	http.Error(w, "Forbidden", http.StatusForbidden)
+}
@@ -0,0 +1,66 @@
+package waf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestScannerFingerprint(t *testing.T) {
+	m := NewScannerMiddlewareWithConfig(nil, ScannerConfig{
+		UserAgentPatternsFile: "../../patterns/scanner_user_agents.txt",
+		PathPatternsFile:      "../../patterns/scanner_paths.txt",
+	})
+	if len(m.uaPatterns) == 0 || len(m.pathPatterns) == 0 {
+		t.Fatal("scanner patterns not loaded")
+	}
+	for _, tc := range []struct {
+		path, ua string
+		scanner  bool
+	}{
+		{"/.git/config", "curl/8.0", true},
+		{"/app/.env", "curl/8.0", true},
+		{"/.hg/store/data", "curl/8.0", true},
+		{"/server-status", "curl/8.0", true},
+		{"/static/etc/passwd", "curl/8.0", true},
+		{"/", "sqlmap/1.7.2#stable (https://sqlmap.org)", true},
+		{"/", "Mozilla/5.0 (compatible; Nmap Scripting Engine; https://nmap.org/book/nse.html)", true},
+		{"/", "Fuzz Faster U Fool v2.1.0 ffuf", true},
+		{"/docs/.environment", "curl/8.0", false},
+		{"/docs/.git/configuration", "curl/8.0", false},
+		{"/docs/server-status", "curl/8.0", false},
+		{"/etc/passwd-guide", "curl/8.0", false},
+		{"/", "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 Chrome/125.0 Mobile Safari/537.36 Dirba/1.0", false},
+		{"/", "Mozilla/5.0 NucleiBrowser/2.0", false},
+		{"/", "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:126.0) Gecko/20100101 Firefox/126.0", false},
+	} {
+		r := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		r.Header.Set("User-Agent", tc.ua)
+		if got := m.fingerprint(r) != ""; got != tc.scanner {
+			t.Errorf("%s %q: scanner %v, want %v (%s)", tc.path, tc.ua, got, tc.scanner, m.fingerprint(r))
+		}
+	}
+}
+
+// По умолчанию сканер только записывается, запрос доходит до бэкенда
+func TestScannerDefaultActionLogs(t *testing.T) {
+	w, err := NewWAF("http://127.0.0.1:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewScannerMiddlewareWithConfig(w, ScannerConfig{UserAgentPatternsFile: "../../patterns/scanner_user_agents.txt"})
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("User-Agent", "sqlmap/1.7.2")
+	reached := false
+	m.push(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { reached = true })).ServeHTTP(httptest.NewRecorder(), r)
+	if !reached || w.bans.IsBanned(ClientIP(r)) {
+		t.Error("scanner blocked with default action")
+	}
+
+	m = NewScannerMiddlewareWithConfig(w, ScannerConfig{UserAgentPatternsFile: "../../patterns/scanner_user_agents.txt", Action: "ban"})
+	rec := httptest.NewRecorder()
+	m.push(http.NotFoundHandler()).ServeHTTP(rec, r)
+	if rec.Code != http.StatusForbidden || !w.bans.IsBanned(ClientIP(r)) {
+		t.Errorf("action ban: status %d", rec.Code)
+	}
+}
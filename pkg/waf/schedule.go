@@ -0,0 +1,175 @@
+package waf
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSpec выражение расписания в формате cron: минута, час, день месяца, месяц, день недели.
+// Поля: *, число, диапазон a-b, шаг */n или a-b/n, списки через запятую. Воскресенье — 0 или 7.
+// Расписание активно в каждую минуту, подходящую под выражение, поэтому "* 0-8 * * *"
+// означает интервал с 00:00 до 08:59, а не запуск в начале часа.
+type cronSpec struct {
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny                bool
+}
+
+var cronFields = []struct {
+	name     string
+	min, max int
+}{{"minute", 0, 59}, {"hour", 0, 23}, {"day of month", 1, 31}, {"month", 1, 12}, {"day of week", 0, 7}}
+
+func parseCron(expr string) (cronSpec, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return cronSpec{}, fmt.Errorf("cron %q: expected 5 fields, got %d", expr, len(fields))
+	}
+	var sets [5]uint64
+	for i, f := range fields {
+		set, err := parseCronField(f, cronFields[i].min, cronFields[i].max)
+		if err != nil {
+			return cronSpec{}, fmt.Errorf("cron %q: %s: %w", expr, cronFields[i].name, err)
+		}
+		sets[i] = set
+	}
+	spec := cronSpec{minute: sets[0], hour: sets[1], dom: sets[2], month: sets[3], dow: sets[4],
+		domAny: fields[2] == "*", dowAny: fields[4] == "*"}
+	if spec.dow&(1<<7) != 0 {
+		spec.dow |= 1
+	}
+	return spec, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, item := range strings.Split(field, ",") {
+		rng, step := item, 1
+		if i := strings.IndexByte(item, '/'); i >= 0 {
+			n, err := strconv.Atoi(item[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", item)
+			}
+			rng, step = item[:i], n
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(a); err != nil {
+				return 0, fmt.Errorf("invalid value %q", item)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(b); err != nil {
+					return 0, fmt.Errorf("invalid value %q", item)
+				}
+			}
+			if lo < min || hi > max || lo > hi {
+				return 0, fmt.Errorf("%q out of range %d-%d", item, min, max)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+// match проверяет минуту t. Если заданы и день месяца, и день недели, достаточно
+// совпадения одного из них, как в cron.
+func (s cronSpec) match(t time.Time) bool {
+	if s.minute&(1<<t.Minute()) == 0 || s.hour&(1<<t.Hour()) == 0 || s.month&(1<<int(t.Month())) == 0 {
+		return false
+	}
+	dom, dow := s.dom&(1<<t.Day()) != 0, s.dow&(1<<int(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// schedule вариант политики, действующий по расписанию
+type schedule struct {
+	name string
+	spec cronSpec
+	loc  *time.Location
+}
+
+func (s *schedule) active(now time.Time) bool {
+	return s.spec.match(now.In(s.loc))
+}
+
+func compileSchedule(sc ScheduleConfig) (*schedule, error) {
+	if sc.Name == "" {
+		return nil, errors.New("name is required")
+	}
+	spec, err := parseCron(sc.Cron)
+	if err != nil {
+		return nil, err
+	}
+	loc := time.Local
+	if sc.Timezone != "" {
+		if loc, err = time.LoadLocation(sc.Timezone); err != nil {
+			return nil, err
+		}
+	}
+	return &schedule{name: sc.Name, spec: spec, loc: loc}, nil
+}
+
+// scheduledConfig накладывает на cfg настройки расписаний, активных в момент now, по порядку
+// объявления. Возвращает итоговую конфигурацию и имена активных расписаний.
+func scheduledConfig(cfg *Config, now time.Time) (*Config, []string, error) {
+	if cfg == nil || len(cfg.Schedules) == 0 {
+		return cfg, nil, nil
+	}
+	eff := cfg
+	var active []string
+	for i, sc := range cfg.Schedules {
+		s, err := compileSchedule(sc)
+		if err != nil {
+			return nil, nil, fmt.Errorf("schedule #%d: %w", i+1, err)
+		}
+		if !s.active(now) {
+			continue
+		}
+		if eff, err = mergeConfig(eff, sc.Config); err != nil {
+			return nil, nil, fmt.Errorf("schedule %s: %w", sc.Name, err)
+		}
+		active = append(active, sc.Name)
+	}
+	return eff, active, nil
+}
+
+// runSchedules раз в минуту пересобирает цепь, если изменился набор активных расписаний
+func (w *WAF) runSchedules() {
+	for {
+		now := time.Now()
+		time.Sleep(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+
+		cfg := w.config()
+		_, active, err := scheduledConfig(cfg, time.Now())
+		if err != nil || strings.Join(active, ",") == w.activeSchedules() {
+			continue
+		}
+		if err := w.reload(cfg, "schedule"); err != nil {
+			log.Printf("[WAF] schedules: не удалось применить расписания %v: %v", active, err)
+			continue
+		}
+		if len(active) == 0 {
+			log.Printf("[WAF] schedules: действует основная конфигурация")
+		} else {
+			log.Printf("[WAF] schedules: действуют расписания %s", strings.Join(active, ", "))
+		}
+	}
+}
+
+// activeSchedules имена расписаний, действующих в текущей цепи, через запятую
+func (w *WAF) activeSchedules() string {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.schedules
+}
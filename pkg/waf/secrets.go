@@ -0,0 +1,87 @@
+package waf
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// secretRef ссылка на секрет в значении поля: ${env:ИМЯ} или ${file:/путь}
+var secretRef = regexp.MustCompile(`\$\{(env|file):([^}]+)\}`)
+
+// resolveSecrets подставляет ссылки на секреты в полях-секретах (имя содержит secret, password,
+// token, key, pass) и в значениях заголовков, в том числе в settings получателей событий,
+// плагинах, арендаторах и профилях. Остальные поля не меняются
+func resolveSecrets(data []byte) ([]byte, error) {
+	if !secretRef.Match(data) {
+		return data, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	v, err := resolveSecretValue("", v, false, false)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// resolveSecretValue обходит значение по пути path: secret — строки в нем являются секретами,
+// headers — объект заголовков, все его значения являются секретами
+func resolveSecretValue(path string, v interface{}, secret, headers bool) (interface{}, error) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, val := range v {
+			p := k
+			if path != "" {
+				p = path + "." + k
+			}
+			res, err := resolveSecretValue(p, val, headers || isSecretKey(k), isHeadersKey(k))
+			if err != nil {
+				return nil, err
+			}
+			v[k] = res
+		}
+	case []interface{}:
+		for i, val := range v {
+			res, err := resolveSecretValue(fmt.Sprintf("%s[%d]", path, i), val, secret, false)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = res
+		}
+	case string:
+		if secret {
+			return expandSecretRefs(path, v)
+		}
+	}
+	return v, nil
+}
+
+// expandSecretRefs заменяет ссылки в строке значениями; файл читается целиком без
+// завершающего перевода строки
+func expandSecretRefs(path, s string) (string, error) {
+	var err error
+	out := secretRef.ReplaceAllStringFunc(s, func(ref string) string {
+		m := secretRef.FindStringSubmatch(ref)
+		if m[1] == "env" {
+			val, ok := os.LookupEnv(m[2])
+			if !ok && err == nil {
+				err = fmt.Errorf("%s: environment variable %s is not set", path, m[2])
+			}
+			return val
+		}
+		data, ferr := os.ReadFile(m[2])
+		if ferr != nil && err == nil {
+			err = fmt.Errorf("%s: %w", path, ferr)
+		}
+		return strings.TrimRight(string(data), "\r\n")
+	})
+	return out, err
+}
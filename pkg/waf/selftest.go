@@ -0,0 +1,312 @@
+package waf
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// SelfTestOptions параметры проверки развернутого WAF атаками-зондами
+type SelfTestOptions struct {
+	Target   string        // адрес WAF, например http://127.0.0.1:8000
+	Host     string        // заголовок Host; пусто — из Target
+	Timeout  time.Duration // на один запрос; дольше — считается удержанием (tarpit)
+	Insecure bool          // не проверять сертификат HTTPS
+
+	// Зонды банят адрес проверяющего. С панелью администратора бан снимается после
+	// каждого сработавшего зонда, иначе проверка останавливается на первом бане.
+	AdminURL string
+	APIKey   string
+	ClientIP string // адрес проверяющего, как его видит WAF; пусто — локальный адрес соединения
+
+	EnumerationPath string // эндпоинт регистрации для зонда перебора аккаунтов; пусто — зонд пропускается
+}
+
+// SelfTestResult итог одного зонда
+type SelfTestResult struct {
+	Category  string `json:"category"`
+	Name      string `json:"name"`
+	Status    int    `json:"status,omitempty"`
+	Triggered bool   `json:"triggered"`
+	Note      string `json:"note,omitempty"`
+}
+
+// SelfTestReport итоги проверки
+type SelfTestReport struct {
+	Baseline int              `json:"baseline"` // ответ на безобидный запрос
+	Results  []SelfTestResult `json:"results"`
+	Missed   int              `json:"missed"`  // зонды, пропущенные WAF
+	Skipped  int              `json:"skipped"` // не отправлены из-за бана проверяющего
+}
+
+// selfTestProbe атака-зонд; несколько запросов (requests > 1) нужны поведенческим детекторам
+type selfTestProbe struct {
+	category, name string
+	method, path   string
+	query          url.Values
+	header         map[string]string
+	form           func(i int) url.Values
+	requests       int
+}
+
+func selfTestProbes(enumPath string) []selfTestProbe {
+	q := func(k, v string) url.Values { return url.Values{k: {v}} }
+	probes := []selfTestProbe{
+		{category: "sqli", name: "tautology", path: "/", query: q("id", "1' OR '1'='1")},
+		{category: "sqli", name: "union select", path: "/", query: q("id", "1 UNION SELECT username,password FROM users--")},
+		{category: "sqli", name: "form body", method: http.MethodPost, path: "/login", form: func(int) url.Values {
+			return url.Values{"username": {"admin'--"}, "password": {"x"}}
+		}},
+		{category: "xss", name: "script tag", path: "/", query: q("q", "<script>alert(document.cookie)</script>")},
+		{category: "xss", name: "event handler", path: "/", query: q("q", `<img src=x onerror=alert(1)>`)},
+		{category: "traversal", name: "parameter", path: "/", query: q("file", "../../../../etc/passwd")},
+		{category: "traversal", name: "encoded path", path: "/static/%2e%2e/%2e%2e/%2e%2e/etc/passwd"},
+		{category: "cmdi", name: "shell chain", path: "/", query: q("host", "127.0.0.1; cat /etc/passwd")},
+		{category: "scanner", name: "sqlmap user agent", path: "/", header: map[string]string{"User-Agent": "sqlmap/1.7.2#stable (https://sqlmap.org)"}},
+		{category: "scanner", name: "probe path", path: "/.git/config"},
+		{category: "scanner", name: "404 burst", requests: 40},
+	}
+	if enumPath != "" {
+		probes = append(probes, selfTestProbe{category: "enumeration", name: "account enumeration", method: http.MethodPost, path: enumPath, requests: 12,
+			form: func(i int) url.Values {
+				return url.Values{"username": {fmt.Sprintf("selftest-user-%d", i)}, "email": {fmt.Sprintf("selftest-%d@example.com", i)}}
+			}})
+	}
+	return probes
+}
+
+// selfTestBlocked коды ответов WAF при блокировке, ограничении и проверке клиента
+func selfTestBlocked(status int) bool {
+	return status == http.StatusForbidden || status == http.StatusTooManyRequests || status == http.StatusNotAcceptable
+}
+
+type selfTester struct {
+	opts   SelfTestOptions
+	client *http.Client
+	base   *url.URL
+	local  string // локальный адрес последнего соединения
+}
+
+// SelfTest отправляет на работающий WAF набор известных атак (SQLi, XSS, обход путей,
+// внедрение команд, сканеры, перебор аккаунтов) и сообщает, какие защиты сработали.
+// Результаты пишутся в out по мере выполнения.
+func SelfTest(opts SelfTestOptions, out io.Writer) (*SelfTestReport, error) {
+	base, err := url.Parse(opts.Target)
+	if err != nil || base.Host == "" {
+		return nil, fmt.Errorf("invalid target %q", opts.Target)
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	t := &selfTester{
+		opts: opts,
+		base: base,
+		client: &http.Client{
+			Timeout: opts.Timeout,
+			// Перенаправления не выполняются: код ответа WAF важнее страницы назначения
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+			Transport: &http.Transport{
+				TLSClientConfig:   &tls.Config{InsecureSkipVerify: opts.Insecure},
+				DisableKeepAlives: true,
+			},
+		},
+	}
+
+	rep := &SelfTestReport{}
+	rep.Baseline, err = t.do(selfTestProbe{path: "/", query: url.Values{"q": {"hello"}}}, 0)
+	if err != nil {
+		return nil, fmt.Errorf("baseline request: %w", err)
+	}
+	if selfTestBlocked(rep.Baseline) {
+		return nil, fmt.Errorf("baseline request is blocked with status %d: the client is already banned or the WAF blocks everything", rep.Baseline)
+	}
+
+	banned := false
+	for _, p := range selfTestProbes(opts.EnumerationPath) {
+		res := SelfTestResult{Category: p.category, Name: p.name}
+		if banned {
+			res.Note = "skipped: client is banned"
+			rep.Skipped++
+			rep.Results = append(rep.Results, res)
+			fmt.Fprintf(out, "SKIP %-11s %s\n", p.category, p.name)
+			continue
+		}
+		res.Status, res.Triggered, res.Note = t.run(p, rep.Baseline)
+		if !res.Triggered {
+			rep.Missed++
+		} else {
+			var note string
+			note, banned = t.recover()
+			if note != "" && res.Note != "" {
+				res.Note += "; " + note
+			} else if note != "" {
+				res.Note = note
+			}
+		}
+		rep.Results = append(rep.Results, res)
+
+		mark := "MISS"
+		if res.Triggered {
+			mark = "OK  "
+		}
+		fmt.Fprintf(out, "%s %-11s %-22s status %d", mark, p.category, p.name, res.Status)
+		if res.Note != "" {
+			fmt.Fprintf(out, " (%s)", res.Note)
+		}
+		fmt.Fprintln(out)
+	}
+	return rep, nil
+}
+
+// run отправляет запросы зонда до первой блокировки
+func (t *selfTester) run(p selfTestProbe, baseline int) (status int, triggered bool, note string) {
+	n := max(p.requests, 1)
+	for i := 0; i < n; i++ {
+		probe := p
+		if p.name == "404 burst" {
+			probe.path = fmt.Sprintf("/selftest-missing-%d-%d", time.Now().UnixNano(), i)
+		}
+		var err error
+		status, err = t.do(probe, i)
+		if errors.Is(err, context.DeadlineExceeded) || isTimeout(err) {
+			// Сканеры могут удерживаться в tarpit: ответ не приходит до истечения таймаута
+			return 0, true, "timeout, probably tarpit"
+		}
+		if err != nil {
+			return 0, false, err.Error()
+		}
+		if selfTestBlocked(status) && status != baseline {
+			return status, true, ""
+		}
+	}
+	return status, false, ""
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// recover снимает бан проверяющего после сработавшего зонда; banned — дальнейшие
+// зонды отправлять бесполезно
+func (t *selfTester) recover() (note string, banned bool) {
+	if t.opts.AdminURL != "" {
+		ip := t.opts.ClientIP
+		if ip == "" {
+			ip = t.local
+		}
+		if err := t.unban(ip); err != nil {
+			note = "unban failed: " + err.Error()
+		}
+	}
+	status, err := t.do(selfTestProbe{path: "/", query: url.Values{"q": {"hello"}}}, 0)
+	if err == nil && selfTestBlocked(status) {
+		if note == "" {
+			note = "client is banned"
+		}
+		return note, true
+	}
+	return note, false
+}
+
+func (t *selfTester) unban(ip string) error {
+	if ip == "" {
+		return errors.New("client address is unknown")
+	}
+	req, err := http.NewRequest(http.MethodDelete, strings.TrimSuffix(t.opts.AdminURL, "/")+"/admin/api/bans/"+url.PathEscape(ip), nil)
+	if err != nil {
+		return err
+	}
+	if t.opts.APIKey != "" {
+		req.Header.Set("X-API-Key", t.opts.APIKey)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	// 404 — бана не было: зонд ограничил частоту, но не забанил
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("admin API responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (t *selfTester) do(p selfTestProbe, i int) (int, error) {
+	u := *t.base
+	u.RawPath = ""
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	if p.path == "" {
+		p.path = "/"
+	}
+	// Путь передается как есть, чтобы закодированные зонды дошли до WAF без нормализации
+	u.Opaque = "//" + u.Host + u.Path + p.path
+	u.RawQuery = p.query.Encode()
+
+	method := p.method
+	if method == "" {
+		method = http.MethodGet
+	}
+	var body io.Reader
+	if p.form != nil {
+		body = strings.NewReader(p.form(i).Encode())
+	}
+	ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.local, _, _ = net.SplitHostPort(info.Conn.LocalAddr().String())
+		},
+	})
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return 0, err
+	}
+	if p.form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	req.Header.Set("User-Agent", "waf-selftest/1.0")
+	for k, v := range p.header {
+		req.Header.Set(k, v)
+	}
+	if t.opts.Host != "" {
+		req.Host = t.opts.Host
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// WriteText выводит итоги по категориям
+func (r *SelfTestReport) WriteText(out io.Writer) {
+	type counts struct{ triggered, total int }
+	var order []string
+	byCategory := make(map[string]*counts)
+	for _, res := range r.Results {
+		c := byCategory[res.Category]
+		if c == nil {
+			c = &counts{}
+			byCategory[res.Category] = c
+			order = append(order, res.Category)
+		}
+		c.total++
+		if res.Triggered {
+			c.triggered++
+		}
+	}
+	for _, cat := range order {
+		c := byCategory[cat]
+		fmt.Fprintf(out, "%-11s %d/%d\n", cat, c.triggered, c.total)
+	}
+	fmt.Fprintf(out, "Пропущено WAF: %d, не отправлено: %d\n", r.Missed, r.Skipped)
+}
@@ -0,0 +1,245 @@
+package waf
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// markovModel вероятности переходов между страницами (шаблонами путей) приложения
+type markovModel struct {
+	mu          sync.RWMutex
+	transitions map[string]map[string]int
+	totals      map[string]int
+	pages       map[string]struct{}
+}
+
+func newMarkovModel() *markovModel {
+	return &markovModel{
+		transitions: make(map[string]map[string]int),
+		totals:      make(map[string]int),
+		pages:       make(map[string]struct{}),
+	}
+}
+
+// observe учитывает переход from -> to
+func (mm *markovModel) observe(from, to string) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	next, ok := mm.transitions[from]
+	if !ok {
+		next = make(map[string]int)
+		mm.transitions[from] = next
+	}
+	next[to]++
+	mm.totals[from]++
+	mm.pages[from] = struct{}{}
+	mm.pages[to] = struct{}{}
+}
+
+// surprise возвращает -log P(to|from) со сглаживанием Лапласа.
+// ok=false, если для страницы from накоплено меньше minObservations переходов.
+func (mm *markovModel) surprise(from, to string, minObservations int) (float64, bool) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+	total := mm.totals[from]
+	if total < minObservations {
+		return 0, false
+	}
+	count := mm.transitions[from][to]
+	p := (float64(count) + 1) / (float64(total) + float64(len(mm.pages)+1))
+	return -math.Log(p), true
+}
+
+// sequenceLearning изученная модель переходов; сохраняется при перезагрузке конфигурации
+type sequenceLearning struct {
+	model         *markovModel
+	trainingUntil time.Time
+}
+
+// SequenceMiddleware обучается типичным последовательностям переходов между страницами
+// и отмечает клиентов с маловероятной навигацией (скрейпинг API, forced browsing).
+// Средняя «неожиданность» последних переходов клиента увеличивает его риск.
+type SequenceMiddleware struct {
+	waf *WAF
+	*sequenceLearning
+	minObservations int
+	historySize     int
+	threshold       float64 // средняя -log P перехода, выше которой навигация аномальна
+	riskScore       float64
+	banRisk         float64 // 0 — только повышать риск, без бана
+	banDuration     time.Duration
+	logDetections   bool
+}
+
+// NewSequenceMiddleware создает анализатор последовательностей с дефолт настройками
+func NewSequenceMiddleware(w *WAF) *SequenceMiddleware {
+	return NewSequenceMiddlewareWithConfig(w, SequenceConfig{})
+}
+
+// NewSequenceMiddlewareWithConfig создает анализатор последовательностей из конфига
+func NewSequenceMiddlewareWithConfig(w *WAF, cfg SequenceConfig) *SequenceMiddleware {
+	training := time.Hour
+	if cfg.TrainingSeconds > 0 {
+		training = time.Duration(cfg.TrainingSeconds) * time.Second
+	}
+	m := &SequenceMiddleware{
+		waf: w,
+		sequenceLearning: w.keep("sequence|"+training.String(), func() interface{} {
+			return &sequenceLearning{model: newMarkovModel(), trainingUntil: time.Now().Add(training)}
+		}).(*sequenceLearning),
+		minObservations: 50,
+		historySize:     20,
+		threshold:       4.6, // примерно P < 1%
+		riskScore:       10,
+		banDuration:     10 * time.Minute,
+		logDetections:   true,
+	}
+	if cfg.MinObservations > 0 {
+		m.minObservations = cfg.MinObservations
+	}
+	if cfg.HistorySize > 0 {
+		m.historySize = cfg.HistorySize
+	}
+	if cfg.Threshold > 0 {
+		m.threshold = cfg.Threshold
+	}
+	if cfg.RiskScore > 0 {
+		m.riskScore = cfg.RiskScore
+	}
+	if cfg.BanRisk > 0 {
+		m.banRisk = cfg.BanRisk
+	}
+	if cfg.BanSeconds > 0 {
+		m.banDuration = time.Duration(cfg.BanSeconds) * time.Second
+	}
+	return m
+}
+
+func (m *SequenceMiddleware) push(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.waf == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := ClientIP(r)
+		if m.waf.banned(r, ip) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		st := m.waf.states.Get(ip)
+		if st == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		page := r.Method + " " + pathTemplate(r.URL.Path)
+
+		st.mu.Lock()
+		prev, _ := st.Meta["sequence_prev"].(string)
+		st.Meta["sequence_prev"] = page
+		st.mu.Unlock()
+
+		if prev == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// В период обучения только накапливать модель
+		if time.Now().Before(m.trainingUntil) {
+			m.model.observe(prev, page)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		surprise, ok := m.model.surprise(prev, page, m.minObservations)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		avg, full := m.recordSurprise(st, surprise)
+		if full && avg > m.threshold {
+			risk := addRiskScore(st, m.riskScore)
+			m.resetHistory(st)
+			action := "log"
+			if m.banRisk > 0 && risk >= m.banRisk {
+				action = "ban"
+			}
+			ev := requestEvent(r, ip, "sequence", SeverityWarning, action, fmt.Sprintf("Аномальная навигация от %s: средняя неожиданность %.2f за %d переходов, риск %.1f", ip, avg, m.historySize, risk))
+			if m.waf.decide(r, ev, m.logDetections, func() bool {
+				if action != "ban" {
+					return false
+				}
+				m.waf.bans.Ban(ip, m.banDuration)
+				w.Header().Set("Retry-After", strconv.FormatInt(int64(m.banDuration.Seconds()), 10))
+				return forbid(w)()
+			}) {
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// recordSurprise добавляет значение в историю клиента и возвращает среднее.
+// full=true, если история заполнена до historySize.
+func (m *SequenceMiddleware) recordSurprise(st *State, surprise float64) (float64, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	history, _ := st.Meta["sequence_history"].([]float64)
+	history = append(history, surprise)
+	if len(history) > m.historySize {
+		history = history[len(history)-m.historySize:]
+	}
+	st.Meta["sequence_history"] = history
+	var sum float64
+	for _, v := range history {
+		sum += v
+	}
+	return sum / float64(len(history)), len(history) >= m.historySize
+}
+
+// resetHistory очищает историю переходов после срабатывания
+func (m *SequenceMiddleware) resetHistory(st *State) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	delete(st.Meta, "sequence_history")
+}
+
+// pathTemplate обобщает путь: числовые, hex и UUID сегменты заменяются на {id}
+func pathTemplate(path string) string {
+	parts := splitPathSegments(path)
+	for i, p := range parts {
+		if isIdentifierSegment(p) {
+			parts[i] = "{id}"
+		}
+	}
+	return "/" + strings.Join(parts, "/")
+}
+
+// isIdentifierSegment определяет сегменты, похожие на идентификаторы
+func isIdentifierSegment(s string) bool {
+	if _, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return true
+	}
+	if len(s) == 36 && strings.Count(s, "-") == 4 {
+		return true
+	}
+	if len(s) >= 16 {
+		for _, c := range s {
+			if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,322 @@
+package waf
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	shadowMaxBody     = 1 << 20 // тело больше этого передается в теневой набор пустым
+	shadowConcurrency = 32      // одновременных теневых проверок; лишние запросы не зеркалируются
+	shadowRecent      = 20      // последних запросов, заблокированных только кандидатом, в отчете
+)
+
+// shadowRun теневой режим: доля запросов асинхронно проходит через набор правил-кандидат,
+// ответ которого не применяется. Кандидат работает на отдельном WAF со своими банами
+// и состояниями, поэтому не влияет на клиентов. Решение кандидата сравнивается с решением
+// основной цепи по тому же запросу.
+type shadowRun struct {
+	percent float64
+	waf     *WAF
+	handler http.Handler
+	slots   chan struct{}
+	parent  *WAF
+	stop    func() // отключает подсчет срабатываний от шины кандидата
+
+	mu         sync.Mutex
+	since      time.Time
+	sampled    uint64
+	skipped    uint64
+	wouldBlock uint64
+	byStatus   map[int]uint64
+	byModule   map[string]uint64 // срабатывания модулей кандидата с блокирующим действием
+
+	primaryBlocked uint64         // заблокированы основной цепью
+	agreed         uint64         // решения основной цепи и кандидата совпали
+	candidateOnly  uint64         // заблокированы только кандидатом
+	primaryOnly    uint64         // заблокированы только основной цепью
+	recent         []shadowSample // последние запросы, заблокированные только кандидатом
+}
+
+// shadowSample запрос, по которому решения основной цепи и кандидата разошлись
+type shadowSample struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id,omitempty"`
+	IP        string    `json:"ip"`
+	Method    string    `json:"method"`
+	Host      string    `json:"host"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"` // ответ кандидата
+}
+
+// shadowPair решения по зеркалированному запросу; сравнение записывается, когда известны оба
+type shadowPair struct {
+	run   *shadowRun
+	clone *http.Request
+
+	mu              sync.Mutex
+	pending         int
+	primaryPassed   bool
+	candidatePassed bool
+	candidateStatus int
+}
+
+// primary решение основной цепи: запрос прошел, если дошел до бэкенда
+func (p *shadowPair) primary(r *http.Request) {
+	info := requestInfoOf(r)
+	p.mu.Lock()
+	p.primaryPassed = info != nil && info.upstream
+	p.mu.Unlock()
+	p.done()
+}
+
+func (p *shadowPair) candidate(status int, passed bool) {
+	p.mu.Lock()
+	p.candidatePassed, p.candidateStatus = passed, status
+	p.mu.Unlock()
+	p.done()
+}
+
+func (p *shadowPair) done() {
+	p.mu.Lock()
+	p.pending--
+	last := p.pending == 0
+	p.mu.Unlock()
+	if last {
+		p.run.record(p)
+	}
+}
+
+type shadowPassKey struct{}
+
+// shadowWriter принимает ответ кандидата, сохраняя только код статуса
+type shadowWriter struct {
+	header http.Header
+	status int
+}
+
+func (s *shadowWriter) Header() http.Header { return s.header }
+
+func (s *shadowWriter) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return len(b), nil
+}
+
+func (s *shadowWriter) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+}
+
+// newShadowRun собирает набор-кандидат: настройки shadow.config поверх основной конфигурации
+func newShadowRun(parent *WAF, cfg *Config) (*shadowRun, error) {
+	if cfg == nil || cfg.Shadow.Percent <= 0 {
+		return nil, nil
+	}
+	base := *cfg
+	base.Shadow, base.Tenants = ShadowConfig{}, nil
+	scfg, err := mergeConfig(&base, cfg.Shadow.Config)
+	if err != nil {
+		return nil, err
+	}
+	// Срабатывания сигнатур нужны отчету by_module; шина кандидата их никуда больше не передает
+	scfg.Signature.LogMatches = true
+
+	// Собственная шина без получателей из конфига: события кандидата не должны поднимать тревоги
+	sw := &WAF{
+		target:     parent.target,
+		proxy:      parent.proxy,
+		states:     newStateStore(),
+		bans:       newBanList(),
+		challenges: newChallenger(),
+		canonical:  parent.canonical,
+		timeouts:   parent.timeouts,
+		events:     &EventBus{buffer: 1024},
+		audit:      parent.audit,
+		canary:     newCanaryStats(),
+	}
+	sw.bans.events = sw.events
+	mws, err := buildChain(sw, scfg)
+	if err != nil {
+		return nil, err
+	}
+	profiles, err := buildProfiles(sw, scfg)
+	if err != nil {
+		return nil, err
+	}
+	sw.setChain(mws, profiles, scfg)
+
+	s := &shadowRun{
+		percent:  cfg.Shadow.Percent,
+		waf:      sw,
+		slots:    make(chan struct{}, shadowConcurrency),
+		parent:   parent,
+		since:    time.Now(),
+		byStatus: make(map[int]uint64),
+		byModule: make(map[string]uint64),
+	}
+	s.handler = sw.Handler(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		if passed, ok := r.Context().Value(shadowPassKey{}).(*atomic.Bool); ok {
+			passed.Store(true)
+		}
+	}))
+	s.stop = sw.events.Subscribe("shadow", EventSinkFunc(s.countDetection))
+	return s, nil
+}
+
+// setShadow заменяет набор-кандидат; nil выключает теневой режим
+func (w *WAF) setShadow(s *shadowRun) {
+	if old := w.shadow.Swap(s); old != nil {
+		old.stop()
+	}
+}
+
+func (s *shadowRun) countDetection(ev SecurityEvent) {
+	if ev.Type != EventDetection || !blockedAction(ev.Action) {
+		return
+	}
+	s.mu.Lock()
+	s.byModule[ev.Module]++
+	s.mu.Unlock()
+}
+
+// mirror отправляет копию запроса в набор-кандидат, если запрос попал в выборку.
+// Тело читается до shadowMaxBody и возвращается в r для основной цепи. Для запроса
+// из выборки возвращается пара, которой после основной цепи передается ее решение
+func (s *shadowRun) mirror(r *http.Request) *shadowPair {
+	if rand.Float64()*100 >= s.percent {
+		return nil
+	}
+	select {
+	case s.slots <- struct{}{}:
+	default:
+		s.mu.Lock()
+		s.skipped++
+		s.mu.Unlock()
+		return nil
+	}
+
+	var body []byte
+	if r.Body != nil && r.Body != http.NoBody && r.ContentLength <= shadowMaxBody {
+		buf, err := io.ReadAll(io.LimitReader(r.Body, shadowMaxBody+1))
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buf), r.Body), r.Body}
+		if err == nil && len(buf) <= shadowMaxBody {
+			body = buf
+		}
+	}
+	passed := new(atomic.Bool)
+	ctx := context.WithValue(context.Background(), shadowPassKey{}, passed)
+	if info := requestInfoOf(r); info != nil {
+		// Кандидат видит тот же идентификатор, что и основная цепь
+		ctx = context.WithValue(ctx, requestInfoKey{}, &requestInfo{id: info.id})
+	}
+	clone := r.Clone(ctx)
+	clone.Body = io.NopCloser(bytes.NewReader(body))
+	clone.ContentLength = int64(len(body))
+
+	p := &shadowPair{run: s, clone: clone, pending: 2}
+	go func() {
+		defer func() { <-s.slots }()
+		rw := &shadowWriter{header: make(http.Header)}
+		s.handler.ServeHTTP(rw, clone)
+		p.candidate(rw.status, passed.Load())
+	}()
+	return p
+}
+
+func (s *shadowRun) record(p *shadowPair) {
+	r, status := p.clone, p.candidateStatus
+	s.mu.Lock()
+	s.sampled++
+	if !p.candidatePassed {
+		s.wouldBlock++
+		s.byStatus[status]++
+	}
+	if !p.primaryPassed {
+		s.primaryBlocked++
+	}
+	switch {
+	case p.primaryPassed == p.candidatePassed:
+		s.agreed++
+	case p.primaryPassed:
+		s.candidateOnly++
+		sample := shadowSample{Time: time.Now().UTC(), IP: ClientIP(r), Method: r.Method, Host: r.Host, Path: r.URL.Path, Status: status}
+		if info := requestInfoOf(r); info != nil {
+			sample.RequestID = info.id
+		}
+		if len(s.recent) == shadowRecent {
+			s.recent = s.recent[1:]
+		}
+		s.recent = append(s.recent, sample)
+	default:
+		s.primaryOnly++
+	}
+	s.mu.Unlock()
+	if p.candidatePassed {
+		return
+	}
+	ev := requestEvent(r, ClientIP(r), "shadow", SeverityInfo, "would_block",
+		fmt.Sprintf("Набор правил-кандидат заблокировал бы %s %s (статус %d)", r.Method, r.URL.Path, status))
+	ev.Type = EventShadow
+	ev.Fields = map[string]interface{}{"status": status, "primary_blocked": !p.primaryPassed}
+	s.parent.emit(ev)
+}
+
+// shadowReport отчет теневого режима для панели администратора
+type shadowReport struct {
+	Percent    float64           `json:"percent"`
+	Since      time.Time         `json:"since"`
+	Sampled    uint64            `json:"sampled"`
+	Skipped    uint64            `json:"skipped"` // не зеркалированы из-за предела одновременных проверок
+	WouldBlock uint64            `json:"would_block"`
+	ByStatus   map[int]uint64    `json:"by_status"`
+	ByModule   map[string]uint64 `json:"by_module"`
+
+	PrimaryBlocked uint64 `json:"primary_blocked"`
+	// Agreement доля проверенных запросов, по которым основная цепь и кандидат решили одинаково
+	Agreement           float64        `json:"agreement"`
+	CandidateOnly       uint64         `json:"candidate_only_blocks"`
+	PrimaryOnly         uint64         `json:"primary_only_blocks"`
+	CandidateOnlyRecent []shadowSample `json:"candidate_only_recent"`
+}
+
+func (s *shadowRun) report() shadowReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rep := shadowReport{
+		Percent:    s.percent,
+		Since:      s.since,
+		Sampled:    s.sampled,
+		Skipped:    s.skipped,
+		WouldBlock: s.wouldBlock,
+		ByStatus:   make(map[int]uint64, len(s.byStatus)),
+		ByModule:   make(map[string]uint64, len(s.byModule)),
+
+		PrimaryBlocked:      s.primaryBlocked,
+		CandidateOnly:       s.candidateOnly,
+		PrimaryOnly:         s.primaryOnly,
+		CandidateOnlyRecent: append([]shadowSample{}, s.recent...),
+	}
+	if s.sampled > 0 {
+		rep.Agreement = float64(s.agreed) / float64(s.sampled)
+	}
+	for k, v := range s.byStatus {
+		rep.ByStatus[k] = v
+	}
+	for k, v := range s.byModule {
+		rep.ByModule[k] = v
+	}
+	return rep
+}
@@ -0,0 +1,431 @@
+package waf
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	patternparser "github.com/SomebodyForSomeone/WAF-lya/internal/pattern_parser"
+	libinjection "github.com/corazawaf/libinjection-go"
+)
+
+// LoadPatternsFromFile загружает паттерны из текстового файла (по одному на строку)
+func LoadPatternsFromFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(string(data), "\n")
+	var patterns []string
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			patterns = append(patterns, line)
+		}
+	}
+	return patterns, nil
+}
+
+// LoadPatternsDynamic загружает паттерны через PatternParser
+// sourceType: "file" или "url"
+func LoadPatternsDynamic(sourceType, source, format string) ([]string, error) {
+	switch format {
+	case "txt":
+		if sourceType == "file" {
+			return patternparser.ParseTxtPatternsFromFile(source)
+		} else if sourceType == "url" {
+			return patternparser.ParseTxtPatternsFromURL(source)
+		}
+		return nil, errors.New("unsupported source type: " + sourceType)
+	default:
+		return nil, errors.New("unsupported pattern format: " + format)
+	}
+}
+
+// SignatureMiddleware обнаруживает атаки (SQLi, XSS, path traversal)
+// Блокирует запрос, но не блокирует IP
+type SignatureMiddleware struct {
+	waf          *WAF
+	logMatches   bool
+	ptPatterns   []string
+	xssPatterns  []string
+	sqliPatterns []string
+	// maxBodyInspect сколько байт тела проверять; 0 — тело не проверяется
+	maxBodyInspect int64
+	cache          *signatureCache // nil — вердикты не кешируются
+
+	// Наборы шаблонов компилируются один раз выбранным движком (matcher.go)
+	patternsOnce sync.Once
+	patterns     *signaturePatterns
+}
+
+// signaturePatterns скомпилированные наборы шаблонов из файлов
+type signaturePatterns struct {
+	sqli, xss, pt patternSet
+}
+
+// compilePatterns компилирует наборы шаблонов движком backend
+func (m *SignatureMiddleware) compilePatterns(backend string) error {
+	var p signaturePatterns
+	var err error
+	if p.sqli, err = compilePatternSet(backend, lowerAll(m.sqliPatterns), true); err != nil {
+		return err
+	}
+	if p.xss, err = compilePatternSet(backend, lowerAll(m.xssPatterns), true); err != nil {
+		return err
+	}
+	if p.pt, err = compilePatternSet(backend, m.ptPatterns, false); err != nil {
+		return err
+	}
+	for _, set := range []struct {
+		name string
+		set  patternSet
+	}{{"sqli", p.sqli}, {"xss", p.xss}, {"path_traversal", p.pt}} {
+		if planner, ok := set.set.(patternPlanner); ok {
+			log.Printf("[WAF] signature: план шаблонов %s: %v", set.name, planner.compiledPlan())
+		}
+	}
+	m.patterns = &p
+	return nil
+}
+
+// compileWithFallback компилирует шаблоны движком backend; если движок не справился
+// (например, Hyperscan отверг шаблон), шаблоны компилируются движком по умолчанию
+func (m *SignatureMiddleware) compileWithFallback(backend string) error {
+	err := m.compilePatterns(backend)
+	if _, known := patternBackends[backend]; err == nil || !known || backend == defaultPatternBackend {
+		return err
+	}
+	log.Printf("[WAF] signature: движок %s: %v, используется %s", backend, err, defaultPatternBackend)
+	return m.compilePatterns(defaultPatternBackend)
+}
+
+// compiled возвращает наборы шаблонов, компилируя их движком по умолчанию при первом обращении.
+// Если компиляция не удалась, наборы пусты: проверка сигнатур пропускается, но не падает
+func (m *SignatureMiddleware) compiled() *signaturePatterns {
+	m.patternsOnce.Do(func() {
+		if m.patterns != nil {
+			return
+		}
+		if err := m.compilePatterns(defaultPatternBackend); err != nil {
+			log.Printf("[WAF] signature: %v, проверка сигнатур отключена", err)
+			empty, _ := compileRegexpSet(nil, false)
+			m.patterns = &signaturePatterns{sqli: empty, xss: empty, pt: empty}
+		}
+	})
+	return m.patterns
+}
+
+func lowerAll(patterns []string) []string {
+	out := make([]string, len(patterns))
+	for i, p := range patterns {
+		out[i] = strings.ToLower(p)
+	}
+	return out
+}
+
+func (m *SignatureMiddleware) push(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.waf == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := ClientIP(r)
+
+		// Проверка бана
+		if m.waf.banned(r, ip) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		// Кандидаты на анализ: path, параметры, raw query
+		candidates := []string{r.URL.Path}
+		// Исходный путь сохраняет признаки обхода (../), убранные канонизацией
+		if orig := originalPath(r); orig != r.URL.Path {
+			candidates = append(candidates, orig)
+		}
+		// params[i] — query-параметр, к которому относится кандидат i (для исключений)
+		params := make([]string, len(candidates), len(candidates)+8)
+
+		// Добавить значения всех query-параметров
+		for param, values := range r.URL.Query() {
+			for _, v := range values {
+				// Добавить имя и значение параметра для анализа
+				candidates = append(candidates, param)
+				candidates = append(candidates, v)
+				params = append(params, param, param)
+			}
+		}
+		// Raw query проверяется последним: он повторяет значения параметров
+		rawQuery := len(candidates)
+		candidates = append(candidates, r.URL.RawQuery)
+		params = append(params, "")
+
+		// Нормализация и сигнатуры для каждого кандидата (из кеша, если строка уже встречалась)
+		verdicts := make([]signatureVerdict, len(candidates))
+		for i, s := range candidates {
+			verdicts[i] = m.analyze(r, s)
+		}
+
+		// Обход фильтра через кодировку (overlong UTF-8, UTF-7, UTF-16) блокируется сам по себе;
+		// некорректный UTF-8 встречается у клиентов в однобайтовых кодировках и только отмечается
+		illFormed := false
+		for i, v := range verdicts {
+			if !v.evasion {
+				illFormed = illFormed || v.encoding != ""
+				continue
+			}
+			attack := "обхода через " + v.encoding
+			ev := requestEvent(r, ip, "signature", SeverityCritical, "block", fmt.Sprintf("Обнаружена атака %s от %s: payload -> %q", attack, ip, candidates[i]))
+			ev.Fields = map[string]interface{}{"attack": attack, "encoding": v.encoding, "payload": candidates[i]}
+			if params[i] != "" {
+				ev.Fields["param"] = params[i]
+			}
+			if m.waf.excluded(ev) {
+				continue
+			}
+			if m.waf.decide(r, ev, m.logMatches, forbid(w)) {
+				return
+			}
+			break
+		}
+		if illFormed {
+			ev := requestEvent(r, ip, "signature", SeverityInfo, "log", fmt.Sprintf("Некорректный UTF-8 в запросе от %s", ip))
+			ev.Fields = map[string]interface{}{"encoding": "ill-formed UTF-8"}
+			m.waf.decide(r, ev, m.logMatches, func() bool { return false })
+		}
+
+		// Проверка через libinjection-go, XSS и path traversal паттерны
+		detected, excluded := false, false
+		for i, v := range verdicts {
+			if i == rawQuery && excluded {
+				// Срабатывание в параметре исключено; raw query содержит то же значение
+				break
+			}
+			if v.attack != "" {
+				ev := requestEvent(r, ip, "signature", SeverityCritical, "block", fmt.Sprintf("Обнаружена атака %s от %s: payload -> %s", v.attack, ip, v.normalized))
+				ev.Fields = map[string]interface{}{"attack": v.attack, "payload": v.normalized}
+				if params[i] != "" {
+					ev.Fields["param"] = params[i]
+				}
+				if m.waf.excluded(ev) {
+					excluded = true
+					continue
+				}
+				if m.waf.decide(r, ev, m.logMatches, forbid(w)) {
+					return
+				}
+				detected = true
+				break
+			}
+		}
+		if !detected && m.waf.reputation != nil {
+			for _, v := range verdicts {
+				if nearMiss(v.normalized) {
+					m.waf.reputation.note(ip, reputationNearMiss)
+					break
+				}
+			}
+		}
+
+		// Потоковая проверка начала тела (не более maxBodyInspect байт)
+		if m.maxBodyInspect > 0 && !detected {
+			var attack, payload string
+			matched, _ := scanBody(r, m.maxBodyInspect, func(s string) bool {
+				v := m.analyze(r, s)
+				if v.evasion {
+					payload, attack = s, "обхода через "+v.encoding
+					return true
+				}
+				payload, attack = v.normalized, v.attack
+				return attack != ""
+			})
+			if matched {
+				ev := requestEvent(r, ip, "signature", SeverityCritical, "block", fmt.Sprintf("Обнаружена атака %s в теле запроса от %s: payload -> %s", attack, ip, payload))
+				ev.Fields = map[string]interface{}{"attack": attack, "payload": payload, "location": "body"}
+				if m.waf.decide(r, ev, m.logMatches, forbid(w)) {
+					return
+				}
+			}
+		}
+
+		// Запрос прошел проверку сигнатур
+		next.ServeHTTP(w, r)
+	})
+}
+
+// NewSignatureMiddlewareWithPathTraversal создает SignatureMiddleware с паттернами path traversal
+func NewSignatureMiddlewareWithPathTraversal(w *WAF, ptPatterns []string) *SignatureMiddleware {
+	xssPatterns, err := LoadPatternsDynamic("file", "patterns/xss.txt", "txt")
+	if err != nil {
+		log.Printf("[WAF] Ошибка загрузки XSS паттернов: %v", err)
+	}
+	sqliPatterns, err := LoadPatternsDynamic("file", "patterns/sqli.txt", "txt")
+	if err != nil {
+		log.Printf("[WAF] Ошибка загрузки SQLi паттернов: %v", err)
+	}
+	return &SignatureMiddleware{
+		waf:          w,
+		ptPatterns:   ptPatterns,
+		xssPatterns:  xssPatterns,
+		sqliPatterns: sqliPatterns,
+		logMatches:   true,
+		cache:        newSignatureCache(defaultSignatureCacheSize),
+	}
+
+}
+
+// detect возвращает тип атаки, обнаруженной в нормализованной строке, или ""
+func (m *SignatureMiddleware) detect(normalized string) string {
+	p := m.compiled()
+	switch {
+	case m.isSQLi(p, normalized):
+		return "SQLi"
+	case m.isXSS(p, normalized):
+		return "XSS"
+	case p.pt.match(normalized):
+		return "обхода путей"
+	}
+	return ""
+}
+
+// Метод для проверки SQLi с учётом паттернов из файла
+func (m *SignatureMiddleware) isSQLi(p *signaturePatterns, s string) bool {
+	found, _ := libinjection.IsSQLi(s)
+	if found {
+		return true
+	}
+	return p.sqli.match(strings.ToLower(s))
+}
+
+// Метод для проверки XSS с учётом паттернов из файла
+func (m *SignatureMiddleware) isXSS(p *signaturePatterns, s string) bool {
+	if libinjection.IsXSS(s) {
+		return true
+	}
+	return p.xss.match(strings.ToLower(s))
+}
+
+// // isSQLi использует libinjection-go для проверки SQL-инъекций
+// func isSQLi(s string) bool {
+// 	found, _ := libinjection.IsSQLi(s)
+// 	return found
+// }
+
+// // isXSS использует libinjection-go для проверки XSS
+// func isXSS(s string) bool {
+// 	// Сначала libinjection-go
+// 	return libinjection.IsXSS(s)
+// }
+
+// decodeBypassSequences декодирует обходные последовательности (overlong UTF-8, hex, смешанные)
+func decodeBypassSequences(s string) string {
+	// Overlong UTF-8 для / и .
+	overlongReplacements := map[string]string{
+		"%c0%af": "/", "%c0%ae": ".",
+		"%e0%80%af": "/", "%e0%80%ae": ".",
+		"%f0%80%80%af": "/", "%f0%80%80%ae": ".",
+		"%c0%2f": "/", "%c0%5c": "\\", "%c0%2e": ".",
+		"%c0.%c0./": "../", "%c0.%c0.%5c": "..\\",
+	}
+	for k, v := range overlongReplacements {
+		s = strings.ReplaceAll(s, k, v)
+		s = strings.ReplaceAll(s, strings.ToUpper(k), v)
+	}
+	// Декодированные байты
+	s = strings.ReplaceAll(s, "\xc0\xaf", "/")
+	s = strings.ReplaceAll(s, "\xc0\xae", ".")
+	s = strings.ReplaceAll(s, "\xe0\x80\xaf", "/")
+	s = strings.ReplaceAll(s, "\xe0\x80\xae", ".")
+	s = strings.ReplaceAll(s, "\xf0\x80\x80\xaf", "/")
+	s = strings.ReplaceAll(s, "\xf0\x80\x80\xae", ".")
+	s = strings.ReplaceAll(s, "\xc0\x2f", "/")
+	s = strings.ReplaceAll(s, "\xc0\x5c", "\\")
+	s = strings.ReplaceAll(s, "\xc0\x2e", ".")
+
+	// Hex-последовательности: 0x2e (.), 0x2f (/), 0x5c (\)
+	hexRe := regexp.MustCompile(`0x([0-9a-fA-F]{2})`)
+	s = hexRe.ReplaceAllStringFunc(s, func(match string) string {
+		hex := match[2:]
+		if b, err := strconv.ParseUint(hex, 16, 8); err == nil {
+			switch b {
+			case 0x2e:
+				return "."
+			case 0x2f:
+				return "/"
+			case 0x5c:
+				return "\\"
+			default:
+				return string(rune(b))
+			}
+		}
+		return match
+	})
+
+	// Смешанные варианты: %c0.%c0./, %c0.%c0.%5c и т.д.
+	s = strings.ReplaceAll(s, "%c0.%c0./", "../")
+	s = strings.ReplaceAll(s, "%c0.%c0.%5c", "..\\")
+	s = strings.ReplaceAll(s, "%c0.%c0%2f", "../")
+	s = strings.ReplaceAll(s, "%c0.%c0%5c", "..\\")
+
+	return s
+}
+
+// normalizeForSignature нормализует запрос для проверки сигнатур.
+// Декодирует (URL, %uXXXX, HTML, UTF-7, UTF-16, overlong UTF-8), удаляет комментарии,
+// приводит к нижнему регистру.
+func normalizeForSignature(s string) string {
+	// Декодирование обходных последовательностей (overlong, hex, смешанные)
+	s = decodeBypassSequences(s)
+	if s == "" {
+		return ""
+	}
+
+	// %uXXXX не является URL-кодированием и иначе прервал бы декодирование;
+	// UTF-7 раскодируется до того, как "+" станет пробелом
+	s = decodeCharsets(decodePercentU(s))
+
+	// Рекурсивное URL-декодирование (до 5 раз)
+	for i := 0; i < 5; i++ {
+		decoded, err := url.QueryUnescape(s)
+		if err != nil || decoded == s {
+			break
+		}
+		s = decoded
+	}
+
+	// Альтернативные кодировки, скрытые URL-кодированием: UTF-16, UTF-7, overlong UTF-8
+	s = decodeCharsets(s)
+
+	// Раскодирование HTML сущностей
+	s = html.UnescapeString(s)
+
+	// Привести к нижнему регистру
+	s = strings.ToLower(s)
+
+	// Удалить пробелы в начале и конце
+	s = strings.TrimSpace(s)
+
+	// Свернуть множество пробелов в один
+	s = regexp.MustCompile(`\s+`).ReplaceAllString(s, " ")
+
+	// Удалить SQL комментарии (/* ... */)
+	s = regexp.MustCompile(`(?s)/\*.*?\*/`).ReplaceAllString(s, "")
+
+	// Удалить SQL комментарии строк (-- ...)
+	s = regexp.MustCompile(`(?m)--.*$`).ReplaceAllString(s, "")
+
+	// Удалить HTML комментарии (<!-- ... -->)
+	s = regexp.MustCompile(`(?s)<!--.*?-->`).ReplaceAllString(s, "")
+
+	return s
+}
@@ -0,0 +1,82 @@
+package waf
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"net/http"
+	"sync"
+)
+
+// defaultSignatureCacheSize число вердиктов в кеше сигнатур по умолчанию
+const defaultSignatureCacheSize = 4096
+
+// signatureVerdict результат анализа одной строки запроса
+type signatureVerdict struct {
+	normalized string
+	attack     string // "" — атаки нет
+	encoding   string // прием обхода через кодировку или некорректный UTF-8; "" — нет
+	evasion    bool   // encoding — обход фильтра, а не просто некорректный UTF-8
+}
+
+// signatureCache LRU вердиктов по хешу исходной строки. Боты повторяют один и тот же
+// payload тысячи раз; кеш избавляет от повторной нормализации и прогона всех сигнатур.
+type signatureCache struct {
+	mu      sync.Mutex
+	max     int
+	lru     *list.List // элементы *signatureCacheEntry, недавние в начале
+	entries map[[sha256.Size]byte]*list.Element
+}
+
+type signatureCacheEntry struct {
+	key     [sha256.Size]byte
+	verdict signatureVerdict
+}
+
+func newSignatureCache(max int) *signatureCache {
+	return &signatureCache{max: max, lru: list.New(), entries: make(map[[sha256.Size]byte]*list.Element)}
+}
+
+func (c *signatureCache) get(key [sha256.Size]byte) (signatureVerdict, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return signatureVerdict{}, false
+	}
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*signatureCacheEntry).verdict, true
+}
+
+func (c *signatureCache) put(key [sha256.Size]byte, v signatureVerdict) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&signatureCacheEntry{key: key, verdict: v})
+	for c.lru.Len() > c.max {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*signatureCacheEntry).key)
+	}
+}
+
+// analyze нормализует строку и проверяет ее сигнатурами, используя кеш вердиктов
+func (m *SignatureMiddleware) analyze(r *http.Request, s string) signatureVerdict {
+	var key [sha256.Size]byte
+	if m.cache != nil {
+		key = sha256.Sum256([]byte(s))
+		if v, ok := m.cache.get(key); ok {
+			return v
+		}
+	}
+	var v signatureVerdict
+	v.encoding, v.evasion = encodingEvasion(s)
+	v.normalized = normalized(r, s)
+	v.attack = m.detect(v.normalized)
+	if m.cache != nil {
+		m.cache.put(key, v)
+	}
+	return v
+}
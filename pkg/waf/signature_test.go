@@ -0,0 +1,27 @@
+package waf
+
+import (
+	"errors"
+	"testing"
+)
+
+// Ошибка движка не должна оставлять наборы шаблонов пустыми (nil)
+func TestSignatureBackendFallback(t *testing.T) {
+	patternBackends["failing"] = func([]string, bool) (patternSet, error) {
+		return nil, errors.New("backend failed")
+	}
+	defer delete(patternBackends, "failing")
+
+	m := NewSignatureMiddlewareWithPathTraversal(nil, []string{`\.\./`})
+	m.sqliPatterns = []string{"union select"}
+	if err := m.compileWithFallback("failing"); err != nil {
+		t.Fatalf("fallback: %v", err)
+	}
+	p := m.compiled()
+	if p == nil || !p.sqli.match("1 union select 2") || !p.pt.match("/a/../b") {
+		t.Fatal("patterns not compiled by the default backend")
+	}
+	if err := m.compileWithFallback("no-such-backend"); err == nil {
+		t.Error("unknown backend accepted")
+	}
+}
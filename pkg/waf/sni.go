@@ -0,0 +1,81 @@
+package waf
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+)
+
+// sniBackend бэкенд соединений с указанными именами SNI
+type sniBackend struct {
+	names   []string
+	handler http.Handler
+}
+
+// serverName имя TLS SNI соединения запроса в нижнем регистре; "" — без TLS или SNI
+func serverName(r *http.Request) string {
+	if r.TLS == nil {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSuffix(r.TLS.ServerName, "."))
+}
+
+// SetSNIRoutes направляет запросы к бэкендам по имени TLS SNI: несколько доменов
+// обслуживаются одним listener. Запросы без совпадения идут к основному бэкенду.
+// Вызывается до запуска сервера.
+func (w *WAF) SetSNIRoutes(routes []SNIRouteConfig) error {
+	var backends []sniBackend
+	for i, rc := range routes {
+		if len(rc.SNI) == 0 {
+			return fmt.Errorf("route #%d: sni is required", i+1)
+		}
+		b := sniBackend{}
+		for _, n := range rc.SNI {
+			b.names = append(b.names, strings.ToLower(n))
+		}
+		switch {
+		case len(rc.Upstreams.Targets) > 0:
+			pool, err := newUpstreamPool(rc.Upstreams, w.timeouts)
+			if err != nil {
+				return fmt.Errorf("route #%d: %w", i+1, err)
+			}
+			pool.events = w.events
+			pool.start()
+			b.handler = pool
+		case rc.ServerAddress != "":
+			target, err := url.Parse(rc.ServerAddress)
+			if err != nil {
+				return fmt.Errorf("route #%d: %w", i+1, err)
+			}
+			proxy := httputil.NewSingleHostReverseProxy(target)
+			w.timeouts.configureProxy(proxy)
+			b.handler = proxy
+		default:
+			return fmt.Errorf("route #%d: server_address or upstreams are required", i+1)
+		}
+		backends = append(backends, b)
+	}
+	w.sni = backends
+	return nil
+}
+
+// sniHandler бэкенд для SNI запроса или fallback
+func (w *WAF) sniHandler(fallback http.Handler) http.Handler {
+	if len(w.sni) == 0 {
+		return fallback
+	}
+	backends := w.sni
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if name := serverName(r); name != "" {
+			for _, b := range backends {
+				if matchHost(b.names, name) {
+					b.handler.ServeHTTP(rw, r)
+					return
+				}
+			}
+		}
+		fallback.ServeHTTP(rw, r)
+	})
+}
@@ -0,0 +1,295 @@
+package waf
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// listenFDsStart первый унаследованный дескриптор по протоколу sd_listen_fds(3)
+const listenFDsStart = 3
+
+// upgradeParentEnv PID процесса, передавшего сокеты при обновлении бинарника;
+// LISTEN_PID в этом случае не задается (PID дочернего процесса заранее неизвестен)
+const upgradeParentEnv = "WAF_UPGRADE_PARENT"
+
+// proxySocketName имя сокета прокси при обновлении бинарника, если listen_fd_name не задан
+const proxySocketName = "waf"
+
+// sockets слушающие сокеты процесса по именам: прокси (listen_fd_name или waf), admin,
+// forward_auth, http3, cluster, metrics. Сокет, унаследованный под тем же именем, забирается
+// вместо открытия нового, а все открытые сокеты передаются новому процессу при обновлении,
+// так что новый процесс не пытается занять порты, которые еще держит старый.
+var sockets = &socketRegistry{}
+
+type socketRegistry struct {
+	mu        sync.Mutex
+	loaded    bool
+	inherited map[string]*os.File // унаследованные и еще не забранные
+	order     []string            // имена унаследованных сокетов в порядке дескрипторов
+	open      []namedSocket       // сокеты процесса в порядке открытия
+}
+
+type namedSocket struct {
+	name string
+	conn interface{ File() (*os.File, error) }
+}
+
+// inheritedFiles возвращает слушающие сокеты, переданные процессу systemd
+// (socket activation: LISTEN_PID, LISTEN_FDS, LISTEN_FDNAMES), другим супервизором
+// по тому же протоколу или предыдущим процессом при обновлении. Так WAF может работать
+// без привилегий на порту 443: порт открывает systemd, а процесс получает готовый сокет.
+// Переменные окружения очищаются, чтобы сокеты не унаследовали дочерние процессы.
+func inheritedFiles() (names []string, files []*os.File) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if (err != nil || pid != os.Getpid()) && os.Getenv(upgradeParentEnv) == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+	fdNames := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	for i := 0; i < n; i++ {
+		name := "LISTEN_FD_" + strconv.Itoa(listenFDsStart+i)
+		if i < len(fdNames) && fdNames[i] != "" {
+			name = fdNames[i]
+		}
+		names = append(names, name)
+		files = append(files, os.NewFile(uintptr(listenFDsStart+i), name))
+	}
+	return names, files
+}
+
+// adopt принимает унаследованные сокеты; повторное имя заменяет предыдущее
+func (s *socketRegistry) adopt(names []string, files []*os.File) {
+	s.loaded = true
+	s.inherited = make(map[string]*os.File, len(files))
+	for i, f := range files {
+		if old := s.inherited[names[i]]; old != nil {
+			old.Close()
+		} else {
+			s.order = append(s.order, names[i])
+		}
+		s.inherited[names[i]] = f
+	}
+}
+
+// claim забирает унаследованный сокет name; вызывается под s.mu
+func (s *socketRegistry) claim(name string) *os.File {
+	if !s.loaded {
+		s.adopt(inheritedFiles())
+	}
+	f := s.inherited[name]
+	delete(s.inherited, name)
+	return f
+}
+
+func (s *socketRegistry) add(name string, conn interface{ File() (*os.File, error) }) {
+	s.open = append(s.open, namedSocket{name: name, conn: conn})
+}
+
+// uniqueName добавляет номер к имени, если сокет с таким именем уже открыт (несколько
+// приемников metrics). Сокеты открываются в порядке конфигурации, поэтому номера совпадают
+// в старом и новом процессе. Вызывается под s.mu.
+func (s *socketRegistry) uniqueName(name string) string {
+	n := 1
+	for _, sock := range s.open {
+		if sock.name == name || strings.HasPrefix(sock.name, name+".") {
+			n++
+		}
+	}
+	if n == 1 {
+		return name
+	}
+	return name + "." + strconv.Itoa(n)
+}
+
+// listenTCP открывает TCP сокет name на addr или забирает унаследованный с тем же именем и адресом
+func (s *socketRegistry) listenTCP(name, addr string) (net.Listener, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name = s.uniqueName(name)
+	if f := s.claim(name); f != nil {
+		ln, err := net.FileListener(f)
+		f.Close() // FileListener дублирует дескриптор
+		if err != nil {
+			return nil, fmt.Errorf("inherited socket %s: %w", name, err)
+		}
+		if tl, ok := ln.(*net.TCPListener); ok && sameListenAddr(ln.Addr(), addr) {
+			s.add(name, tl)
+			return ln, nil
+		}
+		// Адрес изменился в конфигурации: сокет старого адреса не нужен
+		ln.Close()
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s.add(name, ln.(*net.TCPListener))
+	return ln, nil
+}
+
+// listenUDP открывает UDP сокет name на addr или забирает унаследованный с тем же именем и адресом
+func (s *socketRegistry) listenUDP(name, addr string) (*net.UDPConn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name = s.uniqueName(name)
+	if f := s.claim(name); f != nil {
+		pc, err := net.FilePacketConn(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("inherited socket %s: %w", name, err)
+		}
+		if uc, ok := pc.(*net.UDPConn); ok && sameListenAddr(uc.LocalAddr(), addr) {
+			s.add(name, uc)
+			return uc, nil
+		}
+		pc.Close()
+	}
+	ua, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", ua)
+	if err != nil {
+		return nil, err
+	}
+	s.add(name, conn)
+	return conn, nil
+}
+
+// activatedListener забирает унаследованный сокет прокси: с именем fdName, если оно задано,
+// иначе переданный при обновлении (waf) или первый. Возвращает nil, если сокеты не переданы.
+func (s *socketRegistry) activatedListener(fdName string) (net.Listener, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := fdName
+	f := s.claim(name)
+	if f == nil && fdName != "" {
+		if len(s.order) > 0 {
+			return nil, fmt.Errorf("no inherited socket named %q", fdName)
+		}
+		return nil, nil
+	}
+	if f == nil {
+		name = proxySocketName
+		if f = s.claim(name); f == nil {
+			for _, n := range s.order {
+				if f = s.claim(n); f != nil {
+					break
+				}
+			}
+		}
+	}
+	if f == nil {
+		return nil, nil
+	}
+	ln, err := net.FileListener(f)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("inherited socket %s: %w", f.Name(), err)
+	}
+	if tl, ok := ln.(*net.TCPListener); ok {
+		s.add(proxyName(fdName), tl)
+	}
+	return ln, nil
+}
+
+func proxyName(fdName string) string {
+	if fdName != "" {
+		return fdName
+	}
+	return proxySocketName
+}
+
+// release закрывает унаследованные сокеты, которые не понадобились по текущей конфигурации
+func (s *socketRegistry) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, f := range s.inherited {
+		f.Close()
+		delete(s.inherited, name)
+	}
+	s.loaded = true
+}
+
+// closeListeners перестает принимать TCP соединения на всех адресах процесса при остановке:
+// после обновления их принимает только новый процесс. UDP сокеты (http3, cluster) остаются
+// открытыми до выхода, по ним досылаются пакеты активных QUIC соединений.
+func (s *socketRegistry) closeListeners() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sock := range s.open {
+		if ln, ok := sock.conn.(net.Listener); ok {
+			ln.Close()
+		}
+	}
+}
+
+// files дубликаты дескрипторов открытых сокетов и их имена для передачи новому процессу
+func (s *socketRegistry) files() (names []string, files []*os.File, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sock := range s.open {
+		f, err := sock.conn.File()
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, nil, fmt.Errorf("socket %s: %w", sock.name, err)
+		}
+		names = append(names, sock.name)
+		files = append(files, f)
+	}
+	return names, files, nil
+}
+
+// sameListenAddr проверяет, что сокет слушает адрес addr из конфигурации
+func sameListenAddr(have net.Addr, addr string) bool {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	haveHost, havePort, err := net.SplitHostPort(have.String())
+	if err != nil || havePort != port {
+		return false
+	}
+	haveIP := net.ParseIP(haveHost)
+	if host == "" {
+		return haveIP != nil && haveIP.IsUnspecified()
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return false
+	}
+	for _, ip := range ips {
+		if ip.Equal(haveIP) || ip.IsUnspecified() && haveIP.IsUnspecified() {
+			return true
+		}
+	}
+	return false
+}
+
+// sdNotify отправляет состояние в systemd (sd_notify(3)), если сервис запущен с Type=notify
+func sdNotify(state string) {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return
+	}
+	// Абстрактный адрес (@...) net преобразует сам
+	conn, err := net.Dial("unixgram", addr)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.Write([]byte(state))
+}
@@ -0,0 +1,132 @@
+package waf
+
+import (
+	"net"
+	"testing"
+)
+
+// Новый процесс при обновлении забирает все сокеты старого по именам, пока старый их держит
+func TestSocketRegistryHandoff(t *testing.T) {
+	parent := &socketRegistry{loaded: true}
+	proxy, err := parent.listenTCP(proxySocketName, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+	admin, err := parent.listenTCP("admin", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+	cluster, err := parent.listenUDP("cluster", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.Close()
+	metrics, err := parent.listenTCP("metrics", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer metrics.Close()
+	metrics2, err := parent.listenTCP("metrics", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer metrics2.Close()
+
+	names, files, err := parent.files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{proxySocketName, "admin", "cluster", "metrics", "metrics.2"}
+	if len(names) != len(want) {
+		t.Fatalf("names = %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("names = %v, want %v", names, want)
+		}
+	}
+
+	child := &socketRegistry{}
+	child.adopt(names, files)
+
+	ln, err := child.activatedListener("")
+	if err != nil || ln == nil {
+		t.Fatalf("activatedListener: %v, %v", ln, err)
+	}
+	defer ln.Close()
+	if ln.Addr().String() != proxy.Addr().String() {
+		t.Errorf("proxy = %s, want %s", ln.Addr(), proxy.Addr())
+	}
+
+	// Тот же адрес занят старым процессом: без передачи сокета был бы address already in use
+	childAdmin, err := child.listenTCP("admin", admin.Addr().String())
+	if err != nil {
+		t.Fatalf("admin: %v", err)
+	}
+	defer childAdmin.Close()
+	if childAdmin.Addr().String() != admin.Addr().String() {
+		t.Errorf("admin = %s, want %s", childAdmin.Addr(), admin.Addr())
+	}
+	admin.Close()
+	go func() {
+		if c, err := net.Dial("tcp", childAdmin.Addr().String()); err == nil {
+			c.Close()
+		}
+	}()
+	if c, err := childAdmin.Accept(); err != nil {
+		t.Errorf("admin accept: %v", err)
+	} else {
+		c.Close()
+	}
+
+	childCluster, err := child.listenUDP("cluster", cluster.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("cluster: %v", err)
+	}
+	defer childCluster.Close()
+	if childCluster.LocalAddr().String() != cluster.LocalAddr().String() {
+		t.Errorf("cluster = %s, want %s", childCluster.LocalAddr(), cluster.LocalAddr())
+	}
+
+	// Адрес первого приемника metrics изменился в конфигурации: открывается новый сокет
+	childMetrics, err := child.listenTCP("metrics", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("metrics: %v", err)
+	}
+	defer childMetrics.Close()
+	if childMetrics.Addr().String() == metrics.Addr().String() {
+		t.Errorf("metrics reused inherited socket %s for a different address", metrics.Addr())
+	}
+
+	// Второй приемник metrics не настроен в новой конфигурации: сокет закрывается
+	child.release()
+	if len(child.inherited) != 0 {
+		t.Errorf("inherited after release: %v", child.inherited)
+	}
+}
+
+func TestSameListenAddr(t *testing.T) {
+	tests := []struct {
+		have, addr string
+		want       bool
+	}{
+		{"127.0.0.1:8090", "127.0.0.1:8090", true},
+		{"127.0.0.1:8090", "localhost:8090", true},
+		{"[::]:8090", ":8090", true},
+		{"0.0.0.0:8090", ":8090", true},
+		{"127.0.0.1:8090", ":8090", false},
+		{"127.0.0.1:8090", "127.0.0.1:8091", false},
+		{"127.0.0.1:8090", "127.0.0.1:0", false},
+	}
+	for _, tt := range tests {
+		have, err := net.ResolveTCPAddr("tcp", tt.have)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := sameListenAddr(have, tt.addr); got != tt.want {
+			t.Errorf("sameListenAddr(%s, %q) = %v, want %v", tt.have, tt.addr, got, tt.want)
+		}
+	}
+}
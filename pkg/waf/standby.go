@@ -0,0 +1,236 @@
+package waf
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const defaultStandbyInterval = 2 * time.Second
+
+// replicationState баны и состояния клиентов основного экземпляра для резервного
+type replicationState struct {
+	Bans    []clusterBanEntry   `json:"bans"`
+	Clients []replicationClient `json:"clients"`
+}
+
+// replicationClient накопленный риск и репутация клиента; клиенты без них не передаются
+type replicationClient struct {
+	ID         string  `json:"id"`
+	RiskScore  float64 `json:"risk_score,omitempty"`
+	Reputation float64 `json:"reputation,omitempty"`
+}
+
+// standbyReplica резервный экземпляр: повторяет конфигурацию, баны и состояния клиентов
+// основного, но запросы пропускает к бэкенду без проверок, пока его не повысят вызовом API
+// или после promote_after_failures неудачных обращений к основному подряд
+type standbyReplica struct {
+	waf          *WAF
+	primary      string
+	fleet        *fleetFollower // конфигурация основного и отчеты ему
+	interval     time.Duration
+	promoteAfter int
+
+	mu       sync.Mutex
+	lastSync time.Time
+	failures int
+	lastErr  string
+}
+
+// newStandbyReplica проверяет конфигурацию; репликацию запускает run
+func newStandbyReplica(w *WAF, base *Config, cfg StandbyConfig) (*standbyReplica, error) {
+	if base != nil && base.Fleet.Leader != "" {
+		return nil, errors.New("standby: fleet and standby cannot be used together")
+	}
+	interval := defaultStandbyInterval
+	if cfg.IntervalSeconds > 0 {
+		interval = time.Duration(cfg.IntervalSeconds) * time.Second
+	}
+	f, err := newFleetFollower(w, base, FleetConfig{Leader: cfg.Primary, APIKey: cfg.APIKey, Node: cfg.Node, IntervalSeconds: int(interval / time.Second)})
+	if err != nil {
+		return nil, fmt.Errorf("standby: invalid primary URL %q", cfg.Primary)
+	}
+	return &standbyReplica{waf: w, primary: f.leader, fleet: f, interval: interval, promoteAfter: cfg.PromoteAfterFailures}, nil
+}
+
+// run копирует состояние основного, пока экземпляр остается резервным
+func (s *standbyReplica) run() {
+	for s.waf.standby.Load() == s {
+		s.fleet.round()
+		err := s.replicate()
+		s.mu.Lock()
+		if err == nil {
+			s.failures, s.lastErr, s.lastSync = 0, "", time.Now()
+		} else {
+			s.failures++
+			s.lastErr = err.Error()
+		}
+		failures := s.failures
+		s.mu.Unlock()
+		if err != nil {
+			log.Printf("[WAF] standby: %v", err)
+			if s.promoteAfter > 0 && failures >= s.promoteAfter {
+				s.waf.promote("standby", fmt.Sprintf("основной %s недоступен: %d неудачных обращений подряд", s.primary, failures))
+				return
+			}
+		}
+		time.Sleep(s.interval)
+	}
+}
+
+// replicate загружает состояние основного и заменяет им баны и состояния клиентов
+func (s *standbyReplica) replicate() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.primary+"/admin/api/replication/state", nil)
+	if err != nil {
+		return err
+	}
+	s.fleet.authorize(req)
+	resp, err := s.fleet.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", req.URL, resp.Status)
+	}
+	var state replicationState
+	if err := json.NewDecoder(resp.Body).Decode(&state); err != nil {
+		return fmt.Errorf("GET %s: %w", req.URL, err)
+	}
+	s.apply(state)
+	return nil
+}
+
+// apply делает баны такими же, как у основного (лишние снимаются), и переносит риск и репутацию
+func (s *standbyReplica) apply(state replicationState) {
+	if s.waf.standby.Load() != s {
+		// Экземпляр повысили, пока шел запрос: его баны больше не копия основного
+		return
+	}
+	now := time.Now()
+	keep := make(map[*BanList]map[string]bool)
+	for _, b := range state.Bans {
+		bans := s.waf.tenantBans(b.Tenant)
+		if bans == nil || b.TTL <= 0 {
+			continue
+		}
+		if keep[bans] == nil {
+			keep[bans] = make(map[string]bool)
+		}
+		keep[bans][b.ID] = true
+		bans.banUntil(b.ID, now.Add(time.Duration(b.TTL)*time.Millisecond))
+	}
+	lists := []*BanList{s.waf.bans}
+	s.waf.mu.RLock()
+	for _, t := range s.waf.tenants {
+		lists = append(lists, t.waf.bans)
+	}
+	s.waf.mu.RUnlock()
+	for _, bans := range lists {
+		for id := range bans.Active() {
+			if !keep[bans][id] {
+				bans.Unban(id)
+			}
+		}
+	}
+
+	rep := s.waf.reputation
+	for _, c := range state.Clients {
+		st := s.waf.states.Get(c.ID)
+		if st == nil {
+			continue
+		}
+		st.mu.Lock()
+		st.Meta["risk_score"] = c.RiskScore
+		if rep != nil {
+			rep.decayed(st, now).value = c.Reputation
+		}
+		st.mu.Unlock()
+	}
+}
+
+// promote делает резервный экземпляр основным: правила и баны начинают применяться,
+// копирование состояния прекращается. false — экземпляр не резервный
+func (w *WAF) promote(actor, reason string) bool {
+	s := w.standby.Swap(nil)
+	if s == nil {
+		return false
+	}
+	w.audit.record(AuditRecord{Actor: actor, Action: "standby_promote", Target: s.primary, Before: "standby", After: reason})
+	log.Printf("[WAF] standby: экземпляр повышен до основного (%s)", reason)
+	return true
+}
+
+// adminReplicationState баны и состояния клиентов для резервного экземпляра
+func (w *WAF) adminReplicationState(rw http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	state := replicationState{Bans: []clusterBanEntry{}, Clients: []replicationClient{}}
+	collect := func(tenant string, bans *BanList) {
+		for id, until := range bans.Active() {
+			state.Bans = append(state.Bans, clusterBanEntry{Tenant: tenant, ID: id, TTL: until.Sub(now).Milliseconds()})
+		}
+	}
+	collect("", w.bans)
+	w.mu.RLock()
+	tenants := w.tenants
+	w.mu.RUnlock()
+	for _, t := range tenants {
+		collect(t.name, t.waf.bans)
+	}
+	w.states.store.Range(func(k, v interface{}) bool {
+		st := v.(*State)
+		st.mu.Lock()
+		c := replicationClient{ID: k.(string)}
+		c.RiskScore, _ = st.Meta["risk_score"].(float64)
+		if w.reputation != nil {
+			if _, ok := st.Meta["reputation"]; ok {
+				c.Reputation = w.reputation.decayed(st, now).value
+			}
+		}
+		st.mu.Unlock()
+		if c.RiskScore > 0 || c.Reputation >= 0.01 {
+			state.Clients = append(state.Clients, c)
+		}
+		return true
+	})
+	writeJSON(rw, http.StatusOK, state)
+}
+
+// adminStandby роль экземпляра; 503, пока экземпляр резервный, чтобы балансировщик
+// мог использовать адрес как проверку готовности
+func (w *WAF) adminStandby(rw http.ResponseWriter, r *http.Request) {
+	s := w.standby.Load()
+	if s == nil {
+		writeJSON(rw, http.StatusOK, map[string]string{"role": "primary"})
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	writeJSON(rw, http.StatusServiceUnavailable, map[string]interface{}{
+		"role":                   "standby",
+		"primary":                s.primary,
+		"last_sync":              s.lastSync,
+		"failures":               s.failures,
+		"error":                  s.lastErr,
+		"promote_after_failures": s.promoteAfter,
+	})
+}
+
+// adminStandbyPromote повышает резервный экземпляр до основного
+func (w *WAF) adminStandbyPromote(rw http.ResponseWriter, r *http.Request) {
+	s := w.standby.Swap(nil)
+	if s == nil {
+		writeJSON(rw, http.StatusConflict, map[string]string{"error": "instance is not a standby"})
+		return
+	}
+	w.auditRequest(r, "standby_promote", s.primary, "standby", "primary")
+	log.Printf("[WAF] standby: экземпляр повышен до основного через API администратора")
+	writeJSON(rw, http.StatusOK, map[string]string{"role": "primary"})
+}
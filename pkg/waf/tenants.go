@@ -0,0 +1,180 @@
+package waf
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// tenant арендатор WAF: свой набор модулей, лимиты и баны поверх общего бэкенда.
+// Атака на одного арендатора не блокирует клиентов другого.
+type tenant struct {
+	name       string
+	hosts      []string // точные имена и шаблоны *.example.com; пусто — любой Host
+	pathPrefix string
+	waf        *WAF
+
+	// Собранные buildTenants модули; применяются setTenants
+	middlewares []Middleware
+	profiles    []*profile
+	cfg         *Config
+}
+
+// match сообщает, относится ли запрос к арендатору
+func (t *tenant) match(host, p string) bool {
+	if len(t.hosts) > 0 && !matchHost(t.hosts, host) {
+		return false
+	}
+	if t.pathPrefix == "" {
+		return true
+	}
+	return p == t.pathPrefix || strings.HasPrefix(p, strings.TrimSuffix(t.pathPrefix, "/")+"/")
+}
+
+// tenantFor возвращает WAF арендатора запроса; nil — запрос обрабатывает основная цепь
+func (w *WAF) tenantFor(r *http.Request) *WAF {
+	w.mu.RLock()
+	tenants := w.tenants
+	w.mu.RUnlock()
+	if len(tenants) == 0 {
+		return nil
+	}
+	// Префикс сравнивается с нормализованным путем, чтобы //b/../a не уводил в чужого арендатора
+	host, p := requestHost(r), path.Clean("/"+r.URL.Path)
+	for _, t := range tenants {
+		if t.match(host, p) {
+			return t.waf
+		}
+	}
+	return nil
+}
+
+// requestHost имя хоста запроса без порта в нижнем регистре
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// matchHost сравнивает хост с точными именами и шаблонами *.example.com
+func matchHost(patterns []string, host string) bool {
+	for _, h := range patterns {
+		if h == host || strings.HasPrefix(h, "*.") && strings.HasSuffix(host, h[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Tenant возвращает WAF арендатора по имени (баны, состояния, шина событий); nil — нет такого
+func (w *WAF) Tenant(name string) *WAF {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	for _, t := range w.tenants {
+		if t.name == name {
+			return t.waf
+		}
+	}
+	return nil
+}
+
+// newTenantWAF создает WAF арендатора: бэкенд, таймауты и журнал общие с parent,
+// состояния клиентов, баны и challenge — собственные
+func newTenantWAF(parent *WAF, name string) *WAF {
+	t := &WAF{
+		target:     parent.target,
+		proxy:      parent.proxy,
+		states:     newStateStore(),
+		bans:       newBanList(),
+		challenges: newChallenger(),
+		canonical:  parent.canonical,
+		upstreams:  parent.upstreams,
+		timeouts:   parent.timeouts,
+		events:     parent.events.forTenant(name),
+		audit:      parent.audit,
+		canary:     newCanaryStats(),
+		tenant:     name,
+	}
+	t.bans.events = t.events
+	if parent.forensics != nil {
+		t.forensics = &forensicLog{states: t.states, size: parent.forensics.size}
+		t.bans.forensics = t.forensics
+	}
+	return t
+}
+
+// buildTenants собирает арендаторов из cfg. Арендаторы с прежними именами сохраняют
+// баны и состояния клиентов; модули пересобираются. Ничего не меняет до успешной сборки всех.
+func (w *WAF) buildTenants(cfg *Config) ([]*tenant, error) {
+	if cfg == nil || len(cfg.Tenants) == 0 {
+		return nil, nil
+	}
+	w.mu.RLock()
+	old := make(map[string]*WAF, len(w.tenants))
+	for _, t := range w.tenants {
+		old[t.name] = t.waf
+	}
+	w.mu.RUnlock()
+
+	// Общая конфигурация арендатора — основная без секции tenants
+	base := *cfg
+	base.Tenants = nil
+
+	var tenants []*tenant
+	seen := make(map[string]bool)
+	for _, tc := range cfg.Tenants {
+		if tc.Name == "" {
+			return nil, errors.New("tenant name is required")
+		}
+		if seen[tc.Name] {
+			return nil, fmt.Errorf("tenant %s: duplicate name", tc.Name)
+		}
+		seen[tc.Name] = true
+		if len(tc.Hosts) == 0 && tc.PathPrefix == "" {
+			return nil, fmt.Errorf("tenant %s: hosts or path_prefix is required", tc.Name)
+		}
+		tcfg, err := mergeConfig(&base, tc.Config)
+		if err != nil {
+			return nil, fmt.Errorf("tenant %s: %w", tc.Name, err)
+		}
+		if len(tcfg.Tenants) > 0 {
+			return nil, fmt.Errorf("tenant %s: nested tenants are not supported", tc.Name)
+		}
+		tw := old[tc.Name]
+		if tw == nil {
+			tw = newTenantWAF(w, tc.Name)
+		}
+		mws, err := buildChain(tw, tcfg)
+		if err != nil {
+			return nil, fmt.Errorf("tenant %s: %w", tc.Name, err)
+		}
+		profiles, err := buildProfiles(tw, tcfg)
+		if err != nil {
+			return nil, fmt.Errorf("tenant %s: %w", tc.Name, err)
+		}
+		t := &tenant{name: tc.Name, pathPrefix: tc.PathPrefix, waf: tw, middlewares: mws, profiles: profiles, cfg: tcfg}
+		if t.pathPrefix != "" {
+			t.pathPrefix = path.Clean("/" + t.pathPrefix)
+		}
+		for _, h := range tc.Hosts {
+			t.hosts = append(t.hosts, strings.ToLower(h))
+		}
+		tenants = append(tenants, t)
+	}
+	return tenants, nil
+}
+
+// setTenants применяет собранных buildTenants арендаторов
+func (w *WAF) setTenants(tenants []*tenant) {
+	for _, t := range tenants {
+		t.waf.setChain(t.middlewares, t.profiles, t.cfg)
+	}
+	w.mu.Lock()
+	w.tenants = tenants
+	w.mu.Unlock()
+}
@@ -0,0 +1,158 @@
+package waf
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"time"
+)
+
+// timeouts таймауты listener и транспорта к бэкенду, параметры пула соединений к бэкенду
+type timeouts struct {
+	readHeader time.Duration
+	read       time.Duration
+	write      time.Duration
+	idle       time.Duration
+
+	dial           time.Duration
+	tlsHandshake   time.Duration
+	responseHeader time.Duration
+	upstreamTotal  time.Duration // 0 — общий таймаут запроса к бэкенду не ограничен
+	upstreamIdle   time.Duration
+
+	shutdown time.Duration // ожидание активных запросов при остановке
+
+	maxIdleConns        int
+	maxIdleConnsPerHost int
+	maxConnsPerHost     int           // 0 — без ограничения
+	keepAlive           time.Duration // период TCP keep-alive; отрицательный — выключен
+	disableKeepAlives   bool
+	disableCompression  bool
+}
+
+// defaultTimeouts не дают удерживать соединения бесконечно (slowloris, зависший бэкенд).
+// WriteTimeout по умолчанию выключен: он обрывал бы длинные загрузки и потоковые ответы.
+// Пул простаивающих соединений больше, чем в net/http (2 на бэкенд): иначе под нагрузкой
+// соединения к бэкенду постоянно закрываются и открываются заново.
+func defaultTimeouts() timeouts {
+	return timeouts{
+		readHeader:     10 * time.Second,
+		read:           60 * time.Second,
+		idle:           120 * time.Second,
+		dial:           5 * time.Second,
+		tlsHandshake:   10 * time.Second,
+		responseHeader: 30 * time.Second,
+		upstreamIdle:   90 * time.Second,
+		shutdown:       30 * time.Second,
+
+		maxIdleConns:        512,
+		maxIdleConnsPerHost: 128,
+		keepAlive:           30 * time.Second,
+	}
+}
+
+// apply переопределяет таймауты значениями из конфига
+func (t *timeouts) apply(cfg TimeoutsConfig) {
+	set := func(dst *time.Duration, ms int) {
+		if ms > 0 {
+			*dst = time.Duration(ms) * time.Millisecond
+		}
+	}
+	set(&t.readHeader, cfg.ReadHeaderMs)
+	set(&t.read, cfg.ReadMs)
+	set(&t.write, cfg.WriteMs)
+	set(&t.idle, cfg.IdleMs)
+	set(&t.dial, cfg.UpstreamDialMs)
+	set(&t.tlsHandshake, cfg.UpstreamTLSHandshakeMs)
+	set(&t.responseHeader, cfg.UpstreamResponseHeaderMs)
+	set(&t.upstreamTotal, cfg.UpstreamTotalMs)
+	set(&t.upstreamIdle, cfg.UpstreamIdleMs)
+	set(&t.shutdown, cfg.ShutdownMs)
+}
+
+// server создает http.Server с таймаутами listener
+func (t timeouts) server(handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: t.readHeader,
+		ReadTimeout:       t.read,
+		WriteTimeout:      t.write,
+		IdleTimeout:       t.idle,
+	}
+}
+
+// transport создает транспорт к бэкенду с таймаутами соединения и ожидания ответа
+func (t timeouts) transport() *http.Transport {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.DialContext = (&net.Dialer{Timeout: t.dial, KeepAlive: t.keepAlive}).DialContext
+	tr.TLSHandshakeTimeout = t.tlsHandshake
+	tr.ResponseHeaderTimeout = t.responseHeader
+	tr.IdleConnTimeout = t.upstreamIdle
+	tr.MaxIdleConns = t.maxIdleConns
+	tr.MaxIdleConnsPerHost = t.maxIdleConnsPerHost
+	tr.MaxConnsPerHost = t.maxConnsPerHost
+	tr.DisableKeepAlives = t.disableKeepAlives
+	tr.DisableCompression = t.disableCompression
+	return tr
+}
+
+// applyTransport переопределяет параметры пула соединений значениями из конфига
+func (t *timeouts) applyTransport(cfg TransportConfig) {
+	if cfg.MaxIdleConns > 0 {
+		t.maxIdleConns = cfg.MaxIdleConns
+	}
+	if cfg.MaxIdleConnsPerHost > 0 {
+		t.maxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+	}
+	t.maxConnsPerHost = cfg.MaxConnsPerHost
+	switch {
+	case cfg.KeepAliveSeconds < 0:
+		t.keepAlive = -1
+	case cfg.KeepAliveSeconds > 0:
+		t.keepAlive = time.Duration(cfg.KeepAliveSeconds) * time.Second
+	}
+	t.disableKeepAlives = cfg.DisableKeepAlives
+	t.disableCompression = cfg.DisableCompression
+}
+
+// configureProxy применяет транспорт и обработчик ошибок к обратному прокси
+func (t timeouts) configureProxy(p *httputil.ReverseProxy) {
+	p.Transport = t.transport()
+	p.ErrorHandler = proxyErrorHandler
+}
+
+// withUpstreamDeadline ограничивает общее время запроса к бэкенду
+func (t timeouts) withUpstreamDeadline(next http.Handler) http.Handler {
+	if t.upstreamTotal <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), t.upstreamTotal)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// proxyErrorHandler отвечает 504 при таймауте бэкенда, 413 и 400 при ошибке распаковки
+// тела запроса, 403 при запрещенной загрузке и 502 при прочих ошибках
+func proxyErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusBadGateway
+	var netErr net.Error
+	switch {
+	case errors.Is(err, errDecodedBodyTooLarge):
+		status = http.StatusRequestEntityTooLarge
+	case errors.Is(err, errBodyEncoding):
+		status = http.StatusBadRequest
+	case errors.Is(err, errUploadRejected):
+		status = http.StatusForbidden
+	case errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()):
+		status = http.StatusGatewayTimeout
+	}
+	if !errors.Is(err, context.Canceled) {
+		log.Printf("[WAF] Ошибка запроса к бэкенду %s %s: %v", r.Method, r.URL.Path, err)
+	}
+	w.WriteHeader(status)
+}
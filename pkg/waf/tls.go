@@ -0,0 +1,227 @@
+package waf
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// tlsVersions версии TLS по именам из конфига
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func parseTLSVersion(s string, def uint16) (uint16, error) {
+	if s == "" {
+		return def, nil
+	}
+	v, ok := tlsVersions[strings.TrimPrefix(strings.ToLower(s), "tls")]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version %q", s)
+	}
+	return v, nil
+}
+
+// tlsConfig настройки TLS основного listener: сертификат, минимальная версия и шифры.
+// Клиенты, не поддерживающие минимальную версию, получают отказ в рукопожатии;
+// о каждом отказе публикуется событие модуля tls. Сертификат обновляется при изменении
+// файлов без перезапуска.
+func (w *WAF) tlsConfig(cfg TLSConfig) (*tls.Config, error) {
+	if cfg.KeyFile == "" {
+		return nil, errors.New("key_file is required")
+	}
+	var certs certSet
+	for i, cc := range append([]TLSCertConfig{{CertFile: cfg.CertFile, KeyFile: cfg.KeyFile}}, cfg.Certificates...) {
+		sc := cfg
+		sc.CertFile, sc.KeyFile = cc.CertFile, cc.KeyFile
+		s, err := newCertStore(sc)
+		if err != nil {
+			if i > 0 {
+				return nil, fmt.Errorf("certificates #%d: %w", i, err)
+			}
+			return nil, err
+		}
+		go s.run()
+		certs = append(certs, s)
+	}
+	minVersion, err := parseTLSVersion(cfg.MinVersion, tls.VersionTLS12)
+	if err != nil {
+		return nil, fmt.Errorf("min_version: %w", err)
+	}
+	tc := &tls.Config{
+		GetCertificate: certs.getCertificate,
+		MinVersion:     minVersion,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+	if len(cfg.CipherSuites) > 0 {
+		byName := make(map[string]uint16)
+		for _, cs := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+			byName[cs.Name] = cs.ID
+		}
+		for _, name := range cfg.CipherSuites {
+			id, ok := byName[name]
+			if !ok {
+				return nil, fmt.Errorf("cipher_suites: unknown cipher suite %q", name)
+			}
+			tc.CipherSuites = append(tc.CipherSuites, id)
+		}
+	}
+	tc.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		if max := slices.Max(append(hello.SupportedVersions, 0)); max < minVersion {
+			ip := extractIP(hello.Conn.RemoteAddr().String())
+			w.emit(SecurityEvent{
+				Type:     EventDetection,
+				Module:   "tls",
+				Severity: SeverityWarning,
+				IP:       ip,
+				Action:   "block",
+				Message:  fmt.Sprintf("Рукопожатие TLS с %s отклонено: клиент поддерживает только %s", ip, tls.VersionName(max)),
+				Fields:   map[string]interface{}{"rule": "min_version", "version": tls.VersionName(max)},
+			})
+		}
+		return nil, nil
+	}
+	return tc, nil
+}
+
+// TLSClientMiddleware отмечает клиентов с устаревшим стеком TLS: согласованная версия
+// ниже weak_version или шифр без AEAD (CBC, RC4, 3DES). Такие стеки редко встречаются
+// у современных браузеров и часто — у инструментов атак. Работает, когда WAF сам
+// терминирует TLS (секция tls); запросы без TLS пропускаются.
+type TLSClientMiddleware struct {
+	waf           *WAF
+	weakVersion   uint16
+	action        string // log, rate_limit, block, challenge
+	riskScore     float64
+	limit         rate.Limit
+	burst         int
+	logDetections bool
+}
+
+// NewTLSClientMiddlewareWithConfig создает проверку стека TLS клиентов из конфига
+func NewTLSClientMiddlewareWithConfig(w *WAF, cfg TLSClientConfig) (*TLSClientMiddleware, error) {
+	weak, err := parseTLSVersion(cfg.WeakVersion, tls.VersionTLS12)
+	if err != nil {
+		return nil, fmt.Errorf("tls_client: weak_version: %w", err)
+	}
+	m := &TLSClientMiddleware{
+		waf:           w,
+		weakVersion:   weak,
+		action:        "log",
+		riskScore:     3,
+		limit:         rate.Limit(0.5),
+		burst:         5,
+		logDetections: true,
+	}
+	switch cfg.Action {
+	case "":
+	case "log", "rate_limit", "block", "challenge":
+		m.action = cfg.Action
+	default:
+		return nil, fmt.Errorf("tls_client: unknown action %q", cfg.Action)
+	}
+	if cfg.RiskScore > 0 {
+		m.riskScore = cfg.RiskScore
+	}
+	if cfg.Limit > 0 {
+		m.limit = rate.Limit(cfg.Limit)
+	}
+	if cfg.Burst > 0 {
+		m.burst = cfg.Burst
+	}
+	return m, nil
+}
+
+func (m *TLSClientMiddleware) push(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.waf == nil || r.TLS == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := ClientIP(r)
+		if m.waf.banned(r, ip) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		reason := m.weak(r.TLS)
+		if reason == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		st := m.waf.states.Get(ip)
+		if m.action == "rate_limit" {
+			// Отдельный, более строгий лимит для устаревших стеков; как и rate_limit,
+			// отвечает 429 сам, событие — только при превышении
+			if m.allow(st) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			m.waf.refuse(w, r, m.event(r, ip, reason, addRiskScore(st, m.riskScore)), m.logDetections, func() {
+				w.Header().Set("Retry-After", "2")
+				http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			})
+			return
+		}
+		ev := m.event(r, ip, reason, addRiskScore(st, m.riskScore))
+		if m.waf.decide(r, ev, m.logDetections, func() bool {
+			switch m.action {
+			case "block":
+				return forbid(w)()
+			case "challenge":
+				return enforceAction(w, r, m.waf, ip, m.action, 0, 0)
+			}
+			return false
+		}) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (m *TLSClientMiddleware) event(r *http.Request, ip, reason string, risk float64) SecurityEvent {
+	ev := requestEvent(r, ip, "tls_client", SeverityWarning, m.action, fmt.Sprintf("Устаревший стек TLS у %s: %s, риск %.1f", ip, reason, risk))
+	ev.Fields = map[string]interface{}{"version": tls.VersionName(r.TLS.Version), "cipher": tls.CipherSuiteName(r.TLS.CipherSuite)}
+	return ev
+}
+
+// weak описание устаревшего параметра соединения или ""
+func (m *TLSClientMiddleware) weak(cs *tls.ConnectionState) string {
+	if cs.Version < m.weakVersion {
+		return tls.VersionName(cs.Version)
+	}
+	if cs.Version < tls.VersionTLS13 && !aeadCipherSuite(cs.CipherSuite) {
+		return "шифр " + tls.CipherSuiteName(cs.CipherSuite)
+	}
+	return ""
+}
+
+// allow расходует токен строгого лимита клиента с устаревшим стеком
+func (m *TLSClientMiddleware) allow(st *State) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	l, _ := st.Meta["weak_tls_limiter"].(*rate.Limiter)
+	if l == nil || l.Limit() != m.limit || l.Burst() != m.burst {
+		l = rate.NewLimiter(m.limit, m.burst)
+		st.Meta["weak_tls_limiter"] = l
+	}
+	st.LastSeen = time.Now()
+	return l.Allow()
+}
+
+// aeadCipherSuite шифр TLS 1.2 с AEAD (GCM, ChaCha20-Poly1305)
+func aeadCipherSuite(id uint16) bool {
+	name := tls.CipherSuiteName(id)
+	return strings.Contains(name, "_GCM_") || strings.Contains(name, "CHACHA20_POLY1305")
+}
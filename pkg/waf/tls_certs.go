@@ -0,0 +1,148 @@
+package waf
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// certStore сертификат listener с обновлением без перезапуска: файлы сертификата
+// и ключа проверяются раз в interval и перечитываются при изменении. При включенном
+// OCSP stapling ответ ответчика CA прикладывается к сертификату и обновляется
+// до истечения его срока.
+type certStore struct {
+	certFile, keyFile string
+	interval          time.Duration
+	ocsp              bool
+
+	cert     atomic.Pointer[tls.Certificate]
+	modified time.Time // позднее из времен изменения файлов загруженной пары
+
+	staple     *ocspStaple
+	ocspFailed time.Time // время неудачного запроса OCSP; повтор через ocspRetry
+}
+
+const ocspRetry = 10 * time.Minute
+
+func newCertStore(cfg TLSConfig) (*certStore, error) {
+	s := &certStore{certFile: cfg.CertFile, keyFile: cfg.KeyFile, interval: time.Minute, ocsp: cfg.OCSPStapling}
+	if cfg.ReloadSeconds > 0 {
+		s.interval = time.Duration(cfg.ReloadSeconds) * time.Second
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load читает пару сертификат/ключ и, если нужно, получает ответ OCSP
+func (s *certStore) load() error {
+	modified := s.modTime()
+	cert, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+	if err != nil {
+		return err
+	}
+	s.modified = modified
+	s.staple, s.ocspFailed = nil, time.Time{}
+	s.publish(&cert)
+	return nil
+}
+
+// publish устанавливает сертификат, прикладывая действующий ответ OCSP
+func (s *certStore) publish(cert *tls.Certificate) {
+	if s.ocsp && s.staple == nil && s.ocspFailed.IsZero() {
+		s.fetchStaple(cert)
+	}
+	c := *cert
+	c.OCSPStaple = nil
+	if s.staple != nil && (s.staple.nextUpdate.IsZero() || time.Now().Before(s.staple.nextUpdate)) {
+		c.OCSPStaple = s.staple.raw
+	}
+	s.cert.Store(&c)
+}
+
+func (s *certStore) fetchStaple(cert *tls.Certificate) {
+	if cert.Leaf == nil || len(cert.Certificate) < 2 {
+		log.Printf("[WAF] tls: OCSP stapling пропущен: в %s нет сертификата издателя", s.certFile)
+		s.ocspFailed = time.Now()
+		return
+	}
+	issuer, err := x509.ParseCertificate(cert.Certificate[1])
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		var staple *ocspStaple
+		staple, err = fetchOCSP(ctx, cert.Leaf, issuer)
+		cancel()
+		if err == nil {
+			s.staple = staple
+			return
+		}
+	}
+	log.Printf("[WAF] tls: ошибка получения ответа OCSP: %v", err)
+	s.ocspFailed = time.Now()
+}
+
+// modTime позднее из времен изменения файлов сертификата и ключа
+func (s *certStore) modTime() time.Time {
+	var t time.Time
+	for _, name := range []string{s.certFile, s.keyFile} {
+		if fi, err := os.Stat(name); err == nil && fi.ModTime().After(t) {
+			t = fi.ModTime()
+		}
+	}
+	return t
+}
+
+// certSet сертификаты listener; для рукопожатия выбирается первый, подходящий
+// к SNI и возможностям клиента, иначе основной
+type certSet []*certStore
+
+func (cs certSet) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if len(cs) > 1 {
+		for _, s := range cs {
+			if c := s.cert.Load(); hello.SupportsCertificate(c) == nil {
+				return c, nil
+			}
+		}
+	}
+	return cs[0].cert.Load(), nil
+}
+
+// run следит за файлами и сроком ответа OCSP
+func (s *certStore) run() {
+	t := time.NewTicker(s.interval)
+	defer t.Stop()
+	for range t.C {
+		if m := s.modTime(); m.After(s.modified) {
+			if err := s.load(); err != nil {
+				// Пара может быть записана наполовину; прежний сертификат остается до следующей проверки
+				log.Printf("[WAF] tls: ошибка загрузки сертификата %s: %v", s.certFile, err)
+				continue
+			}
+			log.Printf("[WAF] tls: сертификат %s обновлен, действует до %s", s.certFile, s.cert.Load().Leaf.NotAfter.Format(time.RFC3339))
+			continue
+		}
+		if s.ocsp && s.staleStaple() {
+			cert := s.cert.Load()
+			s.staple, s.ocspFailed = nil, time.Time{}
+			s.publish(cert)
+		}
+	}
+}
+
+// staleStaple пора обновить ответ OCSP: прошла половина срока ответа
+// (или 12 часов без срока), либо после неудачи прошло ocspRetry
+func (s *certStore) staleStaple() bool {
+	now := time.Now()
+	if s.staple == nil {
+		return now.Sub(s.ocspFailed) >= ocspRetry
+	}
+	if s.staple.nextUpdate.IsZero() {
+		return now.Sub(s.staple.thisUpdate) >= 12*time.Hour
+	}
+	return now.After(s.staple.thisUpdate.Add(s.staple.nextUpdate.Sub(s.staple.thisUpdate) / 2))
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package waf
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// serve без сигналов обновления: передача сокета новому процессу поддерживается только на unix
+func serve(srv *http.Server, ln net.Listener, _ time.Duration) error {
+	return srv.Serve(ln)
+}
@@ -0,0 +1,171 @@
+//go:build unix
+
+package waf
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// serve обслуживает соединения с плавной остановкой и обновлением бинарника без простоя.
+// SIGUSR2 запускает новый бинарник (тот же путь и аргументы) и передает ему все слушающие сокеты:
+// оба процесса принимают соединения из одних очередей, пока новый не пришлет старому SIGTERM.
+// SIGTERM/SIGINT закрывают listener и дожидаются активных запросов (не дольше shutdown).
+// К вызову serve все сокеты процесса должны быть открыты через sockets.
+func serve(srv *http.Server, ln net.Listener, shutdown time.Duration) error {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR2, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(sigs)
+
+	fresh := trackFreshConns(srv)
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(ln) }()
+	sockets.release()
+	notifyUpgradeParent()
+
+	for {
+		select {
+		case err := <-errc:
+			return err
+		case sig := <-sigs:
+			if sig == syscall.SIGUSR2 {
+				if err := spawnUpgrade(); err != nil {
+					log.Printf("[WAF] Ошибка обновления бинарника: %v", err)
+				}
+				continue
+			}
+			log.Printf("[WAF] Получен %v, ожидание активных запросов (до %v)", sig, shutdown)
+			sdNotify("STOPPING=1")
+			ctx, cancel := context.WithTimeout(context.Background(), shutdown)
+			defer cancel()
+
+			// Shutdown закрывает без ответа соединения, первый запрос которых дочитан после его вызова.
+			// Поэтому сначала перестать принимать соединения и дождаться запросов по уже принятым.
+			ln.Close()
+			sockets.closeListeners()
+			select {
+			case <-errc:
+			case <-ctx.Done():
+			}
+			fresh.wait(ctx)
+			return srv.Shutdown(ctx)
+		}
+	}
+}
+
+// freshConns принятые соединения, по которым еще не прочитан первый запрос
+type freshConns struct {
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+// trackFreshConns подключает учет новых соединений к серверу
+func trackFreshConns(srv *http.Server) *freshConns {
+	f := &freshConns{conns: make(map[net.Conn]struct{})}
+	prev := srv.ConnState
+	srv.ConnState = func(c net.Conn, st http.ConnState) {
+		f.mu.Lock()
+		if st == http.StateNew {
+			f.conns[c] = struct{}{}
+		} else {
+			delete(f.conns, c)
+		}
+		f.mu.Unlock()
+		if prev != nil {
+			prev(c, st)
+		}
+	}
+	return f
+}
+
+// wait ждет, пока все новые соединения пришлют запрос или закроются
+func (f *freshConns) wait(ctx context.Context) {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		f.mu.Lock()
+		n := len(f.conns)
+		f.mu.Unlock()
+		if n == 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// spawnUpgrade запускает новый процесс и передает ему все открытые сокеты (начиная с fd 3)
+// с именами в LISTEN_FDNAMES
+func spawnUpgrade() error {
+	names, files, err := sockets.files()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	// Путь ищется заново: при обновлении бинарник заменен на диске
+	exe, err := exec.LookPath(os.Args[0])
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	env := []string{
+		"LISTEN_FDS=" + strconv.Itoa(len(files)),
+		"LISTEN_FDNAMES=" + strings.Join(names, ":"),
+		upgradeParentEnv + "=" + strconv.Itoa(os.Getpid()),
+	}
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "LISTEN_") && !strings.HasPrefix(kv, upgradeParentEnv+"=") {
+			env = append(env, kv)
+		}
+	}
+	cmd.Env = env
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	log.Printf("[WAF] Запущен новый процесс %d, переданы сокеты: %s", cmd.Process.Pid, strings.Join(names, ", "))
+
+	// Если новый процесс не поднялся, старый продолжает работать
+	go func() {
+		err := cmd.Wait()
+		log.Printf("[WAF] Новый процесс %d завершился: %v", cmd.Process.Pid, err)
+	}()
+	return nil
+}
+
+// notifyUpgradeParent сообщает процессу, передавшему сокеты, что новый процесс принимает соединения
+// на всех адресах, и (при Type=notify) передает systemd роль основного процесса сервиса
+func notifyUpgradeParent() {
+	parent, err := strconv.Atoi(os.Getenv(upgradeParentEnv))
+	os.Unsetenv(upgradeParentEnv)
+	if err != nil || parent != os.Getppid() {
+		sdNotify("READY=1")
+		return
+	}
+	sdNotify("MAINPID=" + strconv.Itoa(os.Getpid()) + "\nREADY=1")
+	if err := syscall.Kill(parent, syscall.SIGTERM); err != nil {
+		log.Printf("[WAF] Не удалось остановить предыдущий процесс %d: %v", parent, err)
+		return
+	}
+	log.Printf("[WAF] Обновление завершено, предыдущий процесс %d останавливается", parent)
+}
@@ -0,0 +1,346 @@
+package waf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+)
+
+// errUploadRejected тело multipart содержит запрещенный файл; прокси отвечает 403
+var errUploadRejected = errors.New("upload rejected by file type policy")
+
+// defaultBlockedExtensions исполняемые и серверные сценарии, которые не принимаются
+// ни как последнее, ни как внутреннее расширение (shell.php.jpg)
+var defaultBlockedExtensions = []string{
+	".php", ".php3", ".php4", ".php5", ".php7", ".phtml", ".phar", ".pht",
+	".jsp", ".jspx", ".asp", ".aspx", ".ashx", ".asmx", ".cer", ".shtml",
+	".exe", ".dll", ".com", ".scr", ".msi", ".bat", ".cmd", ".ps1", ".vbs",
+	".sh", ".cgi", ".pl", ".py", ".jar", ".war", ".htaccess",
+}
+
+// executableMagic сигнатуры исполняемых файлов и сценариев
+var executableMagic = []struct {
+	magic []byte
+	kind  string
+}{
+	{[]byte("MZ"), "windows executable"},
+	{[]byte("\x7fELF"), "elf executable"},
+	{[]byte("\xcf\xfa\xed\xfe"), "mach-o executable"},
+	{[]byte("\xca\xfe\xba\xbe"), "mach-o/java executable"},
+	{[]byte("#!"), "script"},
+	{[]byte("<?php"), "php script"},
+	{[]byte("<%"), "server script"},
+}
+
+// UploadMiddleware проверяет файлы в multipart-загрузках: расширение по списку, сигнатуру
+// (magic bytes) по заявленному типу и расширению, исполняемые файлы и двойные расширения.
+// Тело не буферизуется: части разбираются по мере того, как прокси передает тело бэкенду.
+// Запрещенный файл обрывает передачу, бэкенд не получает тело целиком, а клиент — 403.
+type UploadMiddleware struct {
+	waf           *WAF
+	routes        []routePattern
+	allowedExt    map[string]bool // nil — любое расширение, кроме запрещенных
+	blockedExt    map[string]bool
+	allowedTypes  map[string]bool // nil — любой тип
+	antivirus     virusScanner    // nil — файлы не проверяются антивирусом
+	failOpen      bool            // пропускать файл, если антивирус недоступен
+	logOnly       bool
+	logDetections bool
+}
+
+// NewUploadMiddlewareWithConfig создает политику загрузок из конфига
+func NewUploadMiddlewareWithConfig(w *WAF, cfg UploadConfig) (*UploadMiddleware, error) {
+	av, err := newVirusScanner(cfg.Antivirus)
+	if err != nil {
+		return nil, err
+	}
+	m := &UploadMiddleware{
+		waf:           w,
+		blockedExt:    extensionSet(defaultBlockedExtensions),
+		antivirus:     av,
+		failOpen:      cfg.Antivirus.FailOpen,
+		logOnly:       cfg.Action == "log",
+		logDetections: true,
+	}
+	for _, rt := range cfg.Routes {
+		m.routes = append(m.routes, compileRoutePattern(rt))
+	}
+	if len(cfg.AllowedExtensions) > 0 {
+		m.allowedExt = extensionSet(cfg.AllowedExtensions)
+	}
+	if cfg.BlockedExtensions != nil {
+		m.blockedExt = extensionSet(cfg.BlockedExtensions)
+	}
+	if len(cfg.AllowedTypes) > 0 {
+		m.allowedTypes = make(map[string]bool, len(cfg.AllowedTypes))
+		for _, t := range cfg.AllowedTypes {
+			m.allowedTypes[strings.ToLower(t)] = true
+		}
+	}
+	return m, nil
+}
+
+func extensionSet(list []string) map[string]bool {
+	set := make(map[string]bool, len(list))
+	for _, e := range list {
+		set["."+strings.TrimPrefix(strings.ToLower(e), ".")] = true
+	}
+	return set
+}
+
+func (m *UploadMiddleware) push(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.waf == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := ClientIP(r)
+		if m.waf.banned(r, ip) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" || r.Body == nil || r.Body == http.NoBody || !m.routed(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		report := func(part, reason string) bool {
+			action := "block"
+			if m.logOnly {
+				action = "log"
+			}
+			ev := requestEvent(r, ip, "upload", SeverityCritical, action, fmt.Sprintf("Запрещенная загрузка от %s: %s (%s)", ip, part, reason))
+			ev.Fields = map[string]interface{}{"filename": part, "reason": reason}
+			return m.waf.decide(r, ev, m.logDetections, func() bool { return !m.logOnly })
+		}
+		r.Body = newUploadBody(r.Body, params["boundary"], report, func(filename, declared string, head []byte, rest io.Reader) string {
+			if reason := m.checkPart(filename, declared, head); reason != "" {
+				return reason
+			}
+			return m.scanPart(r, ip, filename, io.MultiReader(bytes.NewReader(head), rest))
+		})
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (m *UploadMiddleware) routed(urlPath string) bool {
+	if len(m.routes) == 0 {
+		return true
+	}
+	for _, rp := range m.routes {
+		if _, ok := rp.match(urlPath); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// scanPart проверяет файл антивирусом; "" — угроз нет или антивирус не настроен.
+// Недоступный антивирус при fail_open только отмечается событием.
+func (m *UploadMiddleware) scanPart(r *http.Request, ip, filename string, content io.Reader) string {
+	if m.antivirus == nil {
+		return ""
+	}
+	virus, err := m.antivirus.scan(r.Context(), content)
+	if err == nil {
+		if virus != "" {
+			return "virus " + virus
+		}
+		return ""
+	}
+	if !m.failOpen {
+		return "antivirus unavailable: " + err.Error()
+	}
+	if m.logDetections {
+		m.waf.emit(requestEvent(r, ip, "upload", SeverityWarning, "log", fmt.Sprintf("Антивирус недоступен, файл %s от %s пропущен без проверки: %v", filename, ip, err)))
+	}
+	return ""
+}
+
+// checkPart проверяет файл по имени, заявленному типу и первым байтам; "" — файл допустим
+func (m *UploadMiddleware) checkPart(filename, declared string, head []byte) string {
+	name := strings.ToLower(filename)
+	if strings.ContainsAny(name, "\x00/\\") || strings.Contains(name, "..") || strings.TrimRight(name, ". ") != name {
+		return "malformed filename"
+	}
+	base := path.Base(name)
+	ext := path.Ext(base)
+	// Все расширения имени: shell.php.jpg отдается как PHP при неверной настройке сервера
+	for _, e := range strings.Split(base, ".")[1:] {
+		if m.blockedExt["."+e] {
+			return "blocked extension ." + e
+		}
+	}
+	if m.allowedExt != nil && !m.allowedExt[ext] {
+		return "extension " + ext + " not allowed"
+	}
+	for _, em := range executableMagic {
+		if bytes.HasPrefix(head, em.magic) {
+			return em.kind
+		}
+	}
+
+	detected := sniffedType(head)
+	declaredType, _, _ := mime.ParseMediaType(declared)
+	if declaredType == "image/jpg" {
+		declaredType = "image/jpeg"
+	}
+	if detected != "" {
+		if declaredType != "" && declaredType != "application/octet-stream" && declaredType != detected {
+			return fmt.Sprintf("content is %s, declared %s", detected, declaredType)
+		}
+		if extType, _, _ := mime.ParseMediaType(mime.TypeByExtension(ext)); extType != "" && sniffable(extType) && extType != detected {
+			return fmt.Sprintf("content is %s, extension %s", detected, ext)
+		}
+	} else if sniffable(declaredType) {
+		return "content does not match " + declaredType
+	}
+	if m.allowedTypes != nil {
+		t := detected
+		if t == "" {
+			t = declaredType
+		}
+		if !m.allowedTypes[t] {
+			return "type " + t + " not allowed"
+		}
+	}
+	return ""
+}
+
+// sniffableTypes типы, которые надежно определяются по сигнатуре
+var sniffableTypes = map[string]bool{
+	"image/jpeg": true, "image/png": true, "image/gif": true, "image/webp": true, "image/bmp": true,
+	"application/pdf": true, "application/zip": true, "application/x-gzip": true,
+	"video/mp4": true, "video/webm": true, "audio/mpeg": true, "audio/wave": true, "application/ogg": true,
+}
+
+func sniffable(t string) bool {
+	return sniffableTypes[t]
+}
+
+// sniffedType тип по сигнатуре из числа sniffableTypes; "" — не определен
+func sniffedType(head []byte) string {
+	t, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	if t == "audio/wav" {
+		t = "audio/wave"
+	}
+	if sniffableTypes[t] {
+		return t
+	}
+	return ""
+}
+
+// uploadSniffBytes сколько байт начала файла нужно для определения типа
+const uploadSniffBytes = 512
+
+// uploadBody передает тело бэкенду и параллельно разбирает его как multipart в отдельной
+// горутине. Решение по последней части принимается до того, как бэкенд получит конец тела.
+type uploadBody struct {
+	src  io.ReadCloser
+	pw   *io.PipeWriter
+	done chan struct{}
+
+	mu     sync.Mutex
+	reject error
+}
+
+func newUploadBody(src io.ReadCloser, boundary string, report func(part, reason string) bool, check func(filename, declared string, head []byte, rest io.Reader) string) *uploadBody {
+	pr, pw := io.Pipe()
+	u := &uploadBody{src: src, pw: pw, done: make(chan struct{})}
+	go func() {
+		defer close(u.done)
+		err := inspectMultipart(pr, boundary, func(filename, declared string, head []byte, rest io.Reader) bool {
+			reason := check(filename, declared, head, rest)
+			if reason == "" || !report(filename, reason) {
+				return false
+			}
+			u.mu.Lock()
+			u.reject = fmt.Errorf("%w: %s: %s", errUploadRejected, filename, reason)
+			u.mu.Unlock()
+			return true
+		})
+		if err != nil && !errors.Is(err, io.ErrClosedPipe) {
+			// Тело, которое WAF не может разобрать, бэкенд может разобрать по-своему
+			if report("", "malformed multipart: "+err.Error()) {
+				u.mu.Lock()
+				u.reject = fmt.Errorf("%w: malformed multipart", errUploadRejected)
+				u.mu.Unlock()
+			}
+		}
+		pr.CloseWithError(io.ErrClosedPipe)
+	}()
+	return u
+}
+
+func (u *uploadBody) rejected() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.reject
+}
+
+func (u *uploadBody) Read(p []byte) (int, error) {
+	if err := u.rejected(); err != nil {
+		return 0, err
+	}
+	n, err := u.src.Read(p)
+	if n > 0 {
+		// Ошибка записи означает, что разбор завершен; тело передается дальше как есть
+		u.pw.Write(p[:n])
+	}
+	if err == io.EOF {
+		u.pw.Close()
+		<-u.done
+	}
+	if rerr := u.rejected(); rerr != nil {
+		return 0, rerr
+	}
+	return n, err
+}
+
+func (u *uploadBody) Close() error {
+	u.pw.CloseWithError(io.ErrClosedPipe)
+	return u.src.Close()
+}
+
+// inspectMultipart передает check имя, заявленный тип, начало и остаток каждого файла тела;
+// true из check прекращает разбор. Непрочитанный остаток части пропускается.
+func inspectMultipart(r io.Reader, boundary string, check func(filename, declared string, head []byte, rest io.Reader) bool) error {
+	mr := multipart.NewReader(r, boundary)
+	head := make([]byte, uploadSniffBytes)
+	for {
+		part, err := mr.NextRawPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// Имя берется из Content-Disposition как есть: FileName() отбрасывает путь (../)
+		_, disposition, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+		if err != nil {
+			return fmt.Errorf("part header: %w", err)
+		}
+		if filename := disposition["filename"]; filename != "" {
+			n, err := io.ReadFull(part, head)
+			if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+				return err
+			}
+			if check(filename, part.Header.Get("Content-Type"), head[:n], part) {
+				return nil
+			}
+		}
+		if _, err := io.Copy(io.Discard, part); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package waf
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// UpstreamEvent событие изменения состава пула бэкендов
+type UpstreamEvent struct {
+	Event     string    `json:"event"` // upstream_down, upstream_up
+	Upstream  string    `json:"upstream"`
+	Healthy   int       `json:"healthy"`
+	Total     int       `json:"total"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// upstream бэкенд пула со своим обратным прокси и состоянием проверок
+type upstream struct {
+	target    *url.URL
+	proxy     *httputil.ReverseProxy
+	healthy   atomic.Bool
+	successes int // подряд успешных проверок (только в горутине проверки)
+	failures  int // подряд неудачных проверок
+}
+
+// upstreamPool распределяет запросы по здоровым бэкендам (round-robin)
+// и активно проверяет их доступность, исключая недоступные из ротации.
+type upstreamPool struct {
+	upstreams          []*upstream
+	next               atomic.Uint64
+	checkPath          string
+	interval           time.Duration
+	timeout            time.Duration
+	healthyThreshold   int
+	unhealthyThreshold int
+	expectedStatus     map[int]bool
+	client             *http.Client
+	mu                 sync.Mutex
+	hooks              []func(UpstreamEvent)
+	events             *EventBus
+}
+
+// newUpstreamPool создает пул из конфига; все бэкенды изначально считаются здоровыми
+func newUpstreamPool(cfg UpstreamsConfig, t timeouts) (*upstreamPool, error) {
+	if len(cfg.Targets) == 0 {
+		return nil, errors.New("upstreams: no targets")
+	}
+	p := &upstreamPool{
+		checkPath:          "/",
+		interval:           10 * time.Second,
+		timeout:            2 * time.Second,
+		healthyThreshold:   2,
+		unhealthyThreshold: 3,
+	}
+	hc := cfg.HealthCheck
+	if hc.Path != "" {
+		p.checkPath = hc.Path
+	}
+	if hc.IntervalSeconds > 0 {
+		p.interval = time.Duration(hc.IntervalSeconds) * time.Second
+	}
+	if hc.TimeoutSeconds > 0 {
+		p.timeout = time.Duration(hc.TimeoutSeconds) * time.Second
+	}
+	if hc.HealthyThreshold > 0 {
+		p.healthyThreshold = hc.HealthyThreshold
+	}
+	if hc.UnhealthyThreshold > 0 {
+		p.unhealthyThreshold = hc.UnhealthyThreshold
+	}
+	if len(hc.ExpectedStatuses) > 0 {
+		p.expectedStatus = make(map[int]bool, len(hc.ExpectedStatuses))
+		for _, code := range hc.ExpectedStatuses {
+			p.expectedStatus[code] = true
+		}
+	}
+	p.client = &http.Client{
+		Timeout: p.timeout,
+		// Редирект считается ответом бэкенда, а не переходом
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+
+	for _, raw := range cfg.Targets {
+		target, err := url.Parse(raw)
+		if err != nil {
+			return nil, err
+		}
+		u := &upstream{target: target, proxy: httputil.NewSingleHostReverseProxy(target)}
+		t.configureProxy(u.proxy)
+		u.healthy.Store(true)
+		p.upstreams = append(p.upstreams, u)
+	}
+	return p, nil
+}
+
+// ServeHTTP передает запрос следующему здоровому бэкенду
+func (p *upstreamPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u := p.pick()
+	if u == nil {
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	u.proxy.ServeHTTP(w, r)
+}
+
+// pick выбирает здоровый бэкенд по кругу; nil, если здоровых нет
+func (p *upstreamPool) pick() *upstream {
+	n := uint64(len(p.upstreams))
+	start := p.next.Add(1)
+	for i := uint64(0); i < n; i++ {
+		if u := p.upstreams[(start+i)%n]; u.healthy.Load() {
+			return u
+		}
+	}
+	return nil
+}
+
+// start запускает периодические проверки всех бэкендов
+func (p *upstreamPool) start() {
+	for _, u := range p.upstreams {
+		go func(u *upstream) {
+			ticker := time.NewTicker(p.interval)
+			defer ticker.Stop()
+			for range ticker.C {
+				p.check(u)
+			}
+		}(u)
+	}
+}
+
+// check выполняет одну проверку и меняет состояние бэкенда по порогам
+func (p *upstreamPool) check(u *upstream) {
+	ok := p.probe(u)
+	if ok {
+		u.successes++
+		u.failures = 0
+	} else {
+		u.failures++
+		u.successes = 0
+	}
+
+	switch {
+	case !ok && u.healthy.Load() && u.failures >= p.unhealthyThreshold:
+		u.healthy.Store(false)
+		p.emit("upstream_down", u)
+	case ok && !u.healthy.Load() && u.successes >= p.healthyThreshold:
+		u.healthy.Store(true)
+		p.emit("upstream_up", u)
+	}
+}
+
+// probe запрашивает путь проверки бэкенда
+func (p *upstreamPool) probe(u *upstream) bool {
+	checkURL := *u.target
+	checkURL.Path = strings.TrimRight(u.target.Path, "/") + p.checkPath
+	resp, err := p.client.Get(checkURL.String())
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	if p.expectedStatus != nil {
+		return p.expectedStatus[resp.StatusCode]
+	}
+	return resp.StatusCode >= 200 && resp.StatusCode < 400
+}
+
+// emit логирует изменение пула и вызывает хуки
+func (p *upstreamPool) emit(event string, u *upstream) {
+	healthy := 0
+	for _, x := range p.upstreams {
+		if x.healthy.Load() {
+			healthy++
+		}
+	}
+	ev := UpstreamEvent{
+		Event:     event,
+		Upstream:  u.target.String(),
+		Healthy:   healthy,
+		Total:     len(p.upstreams),
+		Timestamp: time.Now(),
+	}
+	if p.events != nil {
+		se := SecurityEvent{
+			Type:     EventUpstream,
+			Module:   "upstreams",
+			Severity: SeverityInfo,
+			Action:   event,
+			Message:  fmt.Sprintf("Бэкенд %s возвращен в пул (здоровых: %d/%d)", ev.Upstream, healthy, ev.Total),
+			Fields:   map[string]interface{}{"upstream": ev.Upstream, "healthy": healthy, "total": ev.Total},
+		}
+		if event == "upstream_down" {
+			se.Severity = SeverityCritical
+			se.Message = fmt.Sprintf("Бэкенд %s исключен из пула (здоровых: %d/%d)", ev.Upstream, healthy, ev.Total)
+		}
+		p.events.Publish(se)
+	}
+
+	p.mu.Lock()
+	hooks := append([]func(UpstreamEvent){}, p.hooks...)
+	p.mu.Unlock()
+	for _, hook := range hooks {
+		go hook(ev)
+	}
+}
@@ -0,0 +1,309 @@
+package waf
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// ValidationIssue проблема, найденная проверкой конфигурации
+type ValidationIssue struct {
+	Where   string `json:"where"` // ключ конфигурации или файл:строка
+	Message string `json:"message"`
+	// Warning WAF запустится, но настройка не будет работать так, как задумано
+	Warning bool `json:"warning,omitempty"`
+}
+
+// ValidationReport итоги проверки конфигурации
+type ValidationReport struct {
+	Config string            `json:"config"`
+	Issues []ValidationIssue `json:"issues"`
+}
+
+// Errors число ошибок (без предупреждений)
+func (r *ValidationReport) Errors() int {
+	n := 0
+	for _, is := range r.Issues {
+		if !is.Warning {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *ValidationReport) add(where string, warning bool, format string, args ...interface{}) {
+	r.Issues = append(r.Issues, ValidationIssue{Where: where, Message: fmt.Sprintf(format, args...), Warning: warning})
+}
+
+// WriteText выводит проблемы и итоги в виде отчета для человека
+func (r *ValidationReport) WriteText(out io.Writer) {
+	for _, is := range r.Issues {
+		level := "ERROR"
+		if is.Warning {
+			level = "WARN"
+		}
+		fmt.Fprintf(out, "%s %s: %s\n", level, is.Where, is.Message)
+	}
+	status := "ok"
+	if r.Errors() > 0 {
+		status = "FAIL"
+	}
+	fmt.Fprintf(out, "%s: %s: ошибок %d, предупреждений %d\n", status, r.Config, r.Errors(), len(r.Issues)-r.Errors())
+}
+
+// ValidateConfig загружает конфигурацию и все файлы, на которые она ссылается, компилирует
+// шаблоны и правила, собирает цепь модулей и проверяет адреса бэкендов. В отличие от запуска,
+// где невалидный шаблон или модуль без настроек только записывается в лог, здесь каждая
+// такая проблема попадает в отчет.
+func ValidateConfig(path string) *ValidationReport {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		rep := &ValidationReport{Config: path, Issues: []ValidationIssue{}}
+		rep.add(path, false, "%v", err)
+		return rep
+	}
+	_, rep := validateConfigData(path, data)
+	return rep
+}
+
+// validateConfigData проверяет содержимое файла конфигурации; name — имя для отчета.
+// Возвращает разобранную конфигурацию (nil, если JSON не разобран) и отчет
+func validateConfigData(name string, data []byte) (*Config, *ValidationReport) {
+	rep := &ValidationReport{Config: name, Issues: []ValidationIssue{}}
+	cfg, err := parseConfig(data)
+	if err != nil {
+		rep.add(name, false, "%v", err)
+		return nil, rep
+	}
+	// Неизвестные ключи при запуске молча игнорируются; обычно это опечатка
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(new(Config)); err != nil {
+		rep.add(name, false, "%v", jsonPositionError(data, err))
+	}
+
+	chain := []string{"context", "rate_limit", "signature"}
+	if len(cfg.MiddlewareChain) > 0 {
+		chain = cfg.MiddlewareChain
+	}
+	rep.validatePatterns(cfg, chain)
+	rep.validateTargets(cfg)
+	if cfg.RuleUpdates.URL != "" {
+		if _, err := newRuleUpdater(nil, cfg, cfg.RuleUpdates); err != nil {
+			rep.add("rule_updates", false, "%v", err)
+		}
+	}
+	if cfg.Cluster.Bind != "" {
+		if _, err := newClusterNode(nil, cfg.Cluster); err != nil {
+			rep.add("cluster", false, "%v", err)
+		}
+	}
+	if cfg.Fleet.Leader != "" {
+		if _, err := newFleetFollower(nil, cfg, cfg.Fleet); err != nil {
+			rep.add("fleet", false, "%v", err)
+		}
+	}
+	if cfg.Standby.Primary != "" {
+		if _, err := newStandbyReplica(nil, cfg, cfg.Standby); err != nil {
+			rep.add("standby", false, "%v", err)
+		}
+	}
+	chainOK := rep.validateChain(cfg, chain)
+
+	// Полная сборка проверяет остальное: события, маршруты SNI, тенанты, профили, теневой режим.
+	// Ошибка модуля уже указана выше, повторно она не выводится
+	if chainOK {
+		if _, err := newOfflineWAF(withoutHealthChecks(cfg)); err != nil {
+			rep.add(name, false, "%v", err)
+		}
+	}
+	return cfg, rep
+}
+
+// withoutHealthChecks копия конфигурации, в которой пулы бэкендов заменены одним адресом:
+// проверка не должна запускать фоновые проверки доступности. Адреса пулов проверяет validateTargets
+func withoutHealthChecks(cfg *Config) *Config {
+	c := *cfg
+	c.Upstreams = UpstreamsConfig{}
+	c.SNIRoutes = make([]SNIRouteConfig, len(cfg.SNIRoutes))
+	for i, route := range cfg.SNIRoutes {
+		if len(route.Upstreams.Targets) > 0 {
+			route.ServerAddress, route.Upstreams = route.Upstreams.Targets[0], UpstreamsConfig{}
+		}
+		c.SNIRoutes[i] = route
+	}
+	return &c
+}
+
+// validateChain создает каждый модуль middleware_chain по отдельности
+func (r *ValidationReport) validateChain(cfg *Config, chain []string) bool {
+	if err := loadPluginFiles(cfg.PluginFiles); err != nil {
+		r.add("plugin_files", false, "%v", err)
+		return false
+	}
+	w, err := NewWAF(cfg.ServerAddress)
+	if err != nil {
+		// Адрес проверяется в validateTargets
+		return false
+	}
+	defer w.events.replace(nil)
+	ok := true
+	for i, name := range chain {
+		where := fmt.Sprintf("middleware_chain[%d] %s", i, name)
+		m, err := newChainMiddleware(w, name, cfg)
+		switch {
+		case errors.Is(err, errUnknownMiddleware):
+			r.add(where, false, "неизвестный модуль")
+			ok = false
+		case err != nil:
+			r.add(where, false, "%v", err)
+			ok = false
+		case m == nil:
+			reason, broken := skippedReason(name, cfg)
+			r.add(where, !broken, "модуль будет пропущен: %s", reason)
+			ok = ok && !broken
+		}
+	}
+	return ok
+}
+
+// skippedReason объясняет, почему модуль пропускается при сборке цепи;
+// broken — настройки заданы, но модуль не удалось создать
+func skippedReason(name string, cfg *Config) (reason string, broken bool) {
+	switch name {
+	case "anomaly_model":
+		if _, err := NewAnomalyScoringMiddlewareWithConfig(nil, cfg.AnomalyModel); err != nil {
+			return err.Error(), true
+		}
+	case "positive_model":
+		if cfg.PositiveModel.PolicyPath != "" {
+			if _, err := NewPositiveModelMiddlewareWithConfig(nil, cfg.PositiveModel); err != nil {
+				return err.Error(), true
+			}
+		}
+	case "wasm":
+		if !wasmSupported && len(cfg.WASM.Modules) > 0 {
+			return "WAF собран без тега wazero", false
+		}
+	}
+	return "не заданы обязательные настройки", false
+}
+
+// validatePatterns проверяет файлы шаблонов модулей signature и scanner построчно
+func (r *ValidationReport) validatePatterns(cfg *Config, chain []string) {
+	for _, name := range chain {
+		switch name {
+		case "signature":
+			r.checkPatternFile("patterns/xss.txt", false)
+			r.checkPatternFile("patterns/sqli.txt", false)
+			src := cfg.PathTraversalPatternsSourceFile
+			if cfg.PathTraversalPatternsSource.Enable && cfg.PathTraversalPatternsSource.Source != "" {
+				src = cfg.PathTraversalPatternsSource
+			}
+			switch {
+			case src.Source == "":
+			case src.Format != "txt":
+				r.add("path_traversal_patterns_source", false, "неподдерживаемый формат %q", src.Format)
+			case src.SourceType == "file":
+				r.checkPatternFile(src.Source, true)
+			case src.SourceType == "url":
+				patterns, err := LoadPatternsDynamic(src.SourceType, src.Source, src.Format)
+				if err != nil {
+					r.add(src.Source, false, "%v", err)
+				}
+				for _, p := range patterns {
+					if _, err := regexp.Compile(p); err != nil {
+						r.add(src.Source, false, "невалидный шаблон %q: %v", p, err)
+					}
+				}
+			default:
+				r.add("path_traversal_patterns_source", false, "неподдерживаемый source_type %q", src.SourceType)
+			}
+			if b := cfg.Signature.MatcherBackend; b != "" {
+				if _, ok := patternBackends[b]; !ok {
+					r.add("signature.matcher_backend", false, "неизвестный движок %q", b)
+				} else if b == "hyperscan" && !hyperscanSupported {
+					r.add("signature.matcher_backend", true, "WAF собран без тега hyperscan, будет использован %s", defaultPatternBackend)
+				}
+			}
+		case "scanner":
+			ua, paths := cfg.Scanner.UserAgentPatternsFile, cfg.Scanner.PathPatternsFile
+			if ua == "" {
+				ua = "patterns/scanner_user_agents.txt"
+			}
+			if paths == "" {
+				paths = "patterns/scanner_paths.txt"
+			}
+			r.checkPatternFile(ua, false)
+			r.checkPatternFile(paths, false)
+		}
+	}
+}
+
+// checkPatternFile читает файл шаблонов (по одному на строку, # — комментарий);
+// regexps — строки являются регулярными выражениями
+func (r *ValidationReport) checkPatternFile(path string, regexps bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		r.add(path, false, "%v", err)
+		return
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if !regexps || line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err := regexp.Compile(line); err != nil {
+			r.add(fmt.Sprintf("%s:%d", path, n), false, "невалидный шаблон %q будет пропущен: %v", line, err)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		r.add(path, false, "%v", err)
+	}
+}
+
+// validateTargets проверяет адреса бэкендов и разрешает их имена
+func (r *ValidationReport) validateTargets(cfg *Config) {
+	check := func(where, target string) {
+		u, err := url.Parse(target)
+		if err != nil {
+			r.add(where, false, "%v", err)
+			return
+		}
+		if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			r.add(where, false, "ожидается адрес вида http://host:port, получено %q", target)
+			return
+		}
+		host := u.Hostname()
+		if net.ParseIP(host) != nil {
+			return
+		}
+		if _, err := net.LookupHost(host); err != nil {
+			r.add(where, false, "не удалось разрешить %s: %v", host, err)
+		}
+	}
+	if cfg.ServerAddress != "" {
+		check("server_address", cfg.ServerAddress)
+	}
+	for i, t := range cfg.Upstreams.Targets {
+		check(fmt.Sprintf("upstreams.targets[%d]", i), t)
+	}
+	for i, route := range cfg.SNIRoutes {
+		if route.ServerAddress != "" {
+			check(fmt.Sprintf("sni_routes[%d].server_address", i), route.ServerAddress)
+		}
+		for j, t := range route.Upstreams.Targets {
+			check(fmt.Sprintf("sni_routes[%d].upstreams.targets[%d]", i, j), t)
+		}
+	}
+}
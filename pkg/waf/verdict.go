@@ -0,0 +1,300 @@
+package waf
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// verdictRequest сводка запроса, которую получает внешний сервис
+type verdictRequest struct {
+	RequestID string            `json:"request_id,omitempty"`
+	IP        string            `json:"ip"`
+	Method    string            `json:"method"`
+	Host      string            `json:"host"`
+	Path      string            `json:"path"`
+	Query     string            `json:"query,omitempty"`
+	Headers   map[string]string `json:"headers"`
+	Risk      float64           `json:"risk"`
+	Findings  []string          `json:"findings,omitempty"` // модули, сработавшие раньше в цепи
+	Body      string            `json:"body,omitempty"`
+}
+
+// verdictResponse ответ внешнего сервиса
+type verdictResponse struct {
+	Verdict      string  `json:"verdict"` // allow, deny, score
+	Score        float64 `json:"score"`
+	Reason       string  `json:"reason"`
+	CacheSeconds int     `json:"cache_seconds"` // 0 — cache_seconds из конфига
+}
+
+type verdictEntry struct {
+	resp    verdictResponse
+	expires time.Time
+}
+
+// VerdictMiddleware отправляет сводку выбранных запросов (по маршруту или риску клиента)
+// внешнему HTTP-сервису и применяет его вердикт: allow, deny или score. Так к WAF
+// подключается собственная логика обнаружения без сборки плагинов. Время ожидания
+// ограничено, вердикты кешируются, поведение при недоступности сервиса задает fail_open.
+type VerdictMiddleware struct {
+	waf            *WAF
+	url            string
+	headers        map[string]string
+	routes         []routePattern
+	minRisk        float64
+	perIP          bool // кешировать вердикт для клиента, а не для запроса
+	includeBody    int64
+	redact         map[string]bool
+	scoreThreshold float64
+	action         string
+	banDuration    time.Duration
+	delay          time.Duration
+	cacheTTL       time.Duration
+	maxCacheSize   int
+	failOpen       bool
+	client         *http.Client
+	mu             sync.Mutex
+	cache          map[string]*verdictEntry
+	logDetections  bool
+}
+
+// NewVerdictMiddlewareWithConfig создает клиента внешнего сервиса вердиктов из конфига
+func NewVerdictMiddlewareWithConfig(w *WAF, cfg VerdictConfig) (*VerdictMiddleware, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("url is required")
+	}
+	m := &VerdictMiddleware{
+		waf:            w,
+		url:            cfg.URL,
+		headers:        cfg.Headers,
+		minRisk:        cfg.MinRisk,
+		perIP:          cfg.CacheKey == "ip",
+		includeBody:    cfg.BodyBytes,
+		redact:         map[string]bool{"Authorization": true, "Cookie": true, "Proxy-Authorization": true},
+		scoreThreshold: cfg.ScoreThreshold,
+		action:         "block",
+		banDuration:    10 * time.Minute,
+		delay:          2 * time.Second,
+		cacheTTL:       time.Minute,
+		maxCacheSize:   10000,
+		failOpen:       cfg.FailOpen,
+		client:         &http.Client{Timeout: 200 * time.Millisecond},
+		cache:          make(map[string]*verdictEntry),
+		logDetections:  true,
+	}
+	switch cfg.CacheKey {
+	case "", "request", "ip":
+	default:
+		return nil, fmt.Errorf("unknown cache_key %q", cfg.CacheKey)
+	}
+	for _, p := range cfg.Routes {
+		m.routes = append(m.routes, compileRoutePattern(p))
+	}
+	for _, h := range cfg.RedactHeaders {
+		m.redact[http.CanonicalHeaderKey(h)] = true
+	}
+	if cfg.Action != "" {
+		m.action = cfg.Action
+	}
+	if cfg.BanSeconds > 0 {
+		m.banDuration = time.Duration(cfg.BanSeconds) * time.Second
+	}
+	if cfg.TimeoutMs > 0 {
+		m.client.Timeout = time.Duration(cfg.TimeoutMs) * time.Millisecond
+	}
+	if cfg.CacheSeconds > 0 {
+		m.cacheTTL = time.Duration(cfg.CacheSeconds) * time.Second
+	}
+	if cfg.MaxCacheSize > 0 {
+		m.maxCacheSize = cfg.MaxCacheSize
+	}
+	return m, nil
+}
+
+func (m *VerdictMiddleware) push(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.waf == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := ClientIP(r)
+		if m.waf.banned(r, ip) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		st := m.waf.states.Get(ip)
+		risk := 0.0
+		if st != nil {
+			risk = riskScore(st)
+		}
+		if !m.selected(r.URL.Path, risk) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		resp, err := m.verdict(r, ip, risk)
+		if err != nil {
+			log.Printf("[WAF] verdict: ошибка внешнего сервиса: %v", err)
+			if m.failOpen {
+				next.ServeHTTP(w, r)
+				return
+			}
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		deny, reason := resp.Verdict == "deny", resp.Reason
+		if resp.Verdict == "score" && resp.Score != 0 && st != nil {
+			total := addRiskScore(st, resp.Score)
+			deny = m.scoreThreshold > 0 && total >= m.scoreThreshold
+			if reason == "" {
+				reason = fmt.Sprintf("оценка %.1f, риск %.1f", resp.Score, total)
+			}
+		}
+		if !deny {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ev := requestEvent(r, ip, "verdict", SeverityWarning, m.action, fmt.Sprintf("Внешний сервис отклонил запрос от %s: %s", ip, reason))
+		ev.Fields = map[string]interface{}{"verdict": resp.Verdict, "score": resp.Score, "reason": resp.Reason}
+		if m.waf.decide(r, ev, m.logDetections, func() bool {
+			switch m.action {
+			case "log":
+				return false
+			case "block":
+				return forbid(w)()
+			}
+			return enforceAction(w, r, m.waf, ip, m.action, m.banDuration, m.delay)
+		}) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// selected сообщает, отправляется ли запрос сервису: путь совпал с routes и риск
+// клиента не меньше min_risk. Без routes и min_risk отправляются все запросы.
+func (m *VerdictMiddleware) selected(path string, risk float64) bool {
+	if m.minRisk > 0 && risk < m.minRisk {
+		return false
+	}
+	if len(m.routes) == 0 {
+		return true
+	}
+	for _, p := range m.routes {
+		if _, ok := p.match(path); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// verdict возвращает вердикт из кеша или запрашивает сервис
+func (m *VerdictMiddleware) verdict(r *http.Request, ip string, risk float64) (verdictResponse, error) {
+	key := ip
+	if !m.perIP {
+		sum := sha256.Sum256([]byte(ip + "\x00" + r.Method + "\x00" + r.Host + "\x00" + r.URL.RequestURI()))
+		key = hex.EncodeToString(sum[:])
+	}
+	now := time.Now()
+	m.mu.Lock()
+	if e, ok := m.cache[key]; ok && now.Before(e.expires) {
+		m.mu.Unlock()
+		return e.resp, nil
+	}
+	m.mu.Unlock()
+
+	resp, err := m.request(r, ip, risk)
+	if err != nil {
+		return resp, err
+	}
+	ttl := m.cacheTTL
+	if resp.CacheSeconds > 0 {
+		ttl = time.Duration(resp.CacheSeconds) * time.Second
+	}
+
+	m.mu.Lock()
+	if len(m.cache) >= m.maxCacheSize {
+		for k, e := range m.cache {
+			if now.After(e.expires) {
+				delete(m.cache, k)
+			}
+		}
+		// Кеш заполнен действующими записями: сбросить его целиком
+		if len(m.cache) >= m.maxCacheSize {
+			m.cache = make(map[string]*verdictEntry)
+		}
+	}
+	m.cache[key] = &verdictEntry{resp: resp, expires: now.Add(ttl)}
+	m.mu.Unlock()
+	return resp, nil
+}
+
+// request отправляет сводку запроса сервису
+func (m *VerdictMiddleware) request(r *http.Request, ip string, risk float64) (verdictResponse, error) {
+	summary := verdictRequest{
+		RequestID: requestID(r),
+		IP:        ip,
+		Method:    r.Method,
+		Host:      r.Host,
+		Path:      r.URL.Path,
+		Query:     r.URL.RawQuery,
+		Headers:   make(map[string]string, len(r.Header)),
+		Risk:      risk,
+	}
+	for k, vs := range r.Header {
+		if !m.redact[k] && len(vs) > 0 {
+			summary.Headers[k] = strings.Join(vs, ", ")
+		}
+	}
+	for _, ev := range Findings(r) {
+		summary.Findings = append(summary.Findings, ev.Module)
+	}
+	if m.includeBody > 0 {
+		if head, err := peekBody(r, m.includeBody); err == nil {
+			summary.Body = string(head)
+		}
+	}
+
+	payload, err := json.Marshal(summary)
+	if err != nil {
+		return verdictResponse{}, err
+	}
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, m.url, bytes.NewReader(payload))
+	if err != nil {
+		return verdictResponse{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range m.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return verdictResponse{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return verdictResponse{}, errors.New("bad response: " + resp.Status)
+	}
+	var v verdictResponse
+	if err := json.NewDecoder(http.MaxBytesReader(nil, resp.Body, 64<<10)).Decode(&v); err != nil {
+		return verdictResponse{}, err
+	}
+	switch v.Verdict {
+	case "allow", "deny", "score":
+		return v, nil
+	}
+	return verdictResponse{}, fmt.Errorf("unknown verdict %q", v.Verdict)
+}
@@ -0,0 +1,166 @@
+package waf
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// wasmModule загруженный модуль обнаружения WebAssembly
+type wasmModule interface {
+	inspect(ctx context.Context, request []byte) (wasmVerdict, error)
+}
+
+// wasmLimits ограничения песочницы модуля
+type wasmLimits struct {
+	timeout     time.Duration
+	memoryPages uint32 // страницы по 64 КБ
+}
+
+// wasmVerdict результат проверки запроса модулем (заполняется вызовами host API)
+type wasmVerdict struct {
+	score      float64
+	block      bool
+	banSeconds int
+}
+
+// wasmCall данные одного вызова модуля, доступные функциям host API через контекст
+type wasmCall struct {
+	request []byte
+	verdict wasmVerdict
+}
+
+type wasmCallKey struct{}
+
+// wasmRequest описание запроса, которое модуль читает через read_request (JSON)
+type wasmRequest struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Query   string            `json:"query"`
+	Headers map[string]string `json:"headers"`
+	IP      string            `json:"ip"`
+	Body    string            `json:"body,omitempty"`
+}
+
+type wasmPlugin struct {
+	name   string
+	module wasmModule
+}
+
+// WASMMiddleware выполняет модули обнаружения WebAssembly в песочнице с ограниченным host API:
+// модуль читает описание запроса, добавляет риск, блокирует запрос или запрашивает бан.
+// Модулю недоступны файлы, сеть и время, вызов ограничен по времени и памяти,
+// поэтому сторонние правила можно подключать без доверия к их коду.
+type WASMMiddleware struct {
+	waf           *WAF
+	plugins       []wasmPlugin
+	maxBody       int64
+	banRisk       float64
+	banDuration   time.Duration
+	logDetections bool
+}
+
+// NewWASMMiddlewareWithConfig загружает модули из конфига
+func NewWASMMiddlewareWithConfig(w *WAF, cfg WASMConfig) (*WASMMiddleware, error) {
+	limits := wasmLimits{timeout: 10 * time.Millisecond, memoryPages: 256}
+	if cfg.TimeoutMs > 0 {
+		limits.timeout = time.Duration(cfg.TimeoutMs) * time.Millisecond
+	}
+	if cfg.MaxMemoryMB > 0 {
+		limits.memoryPages = uint32(cfg.MaxMemoryMB) * 16
+	}
+	m := &WASMMiddleware{
+		waf:           w,
+		maxBody:       int64(cfg.MaxBodyKB) << 10,
+		banRisk:       cfg.BanRisk,
+		banDuration:   10 * time.Minute,
+		logDetections: true,
+	}
+	if cfg.BanSeconds > 0 {
+		m.banDuration = time.Duration(cfg.BanSeconds) * time.Second
+	}
+	for _, mc := range cfg.Modules {
+		mod, err := loadWASMModule(mc.Path, limits)
+		if err != nil {
+			return nil, fmt.Errorf("module %s: %w", mc.Name, err)
+		}
+		m.plugins = append(m.plugins, wasmPlugin{name: mc.Name, module: mod})
+	}
+	return m, nil
+}
+
+func (m *WASMMiddleware) push(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.waf == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := ClientIP(r)
+		if m.waf.banned(r, ip) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		req, err := m.describe(r, ip)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		st := m.waf.states.Get(ip)
+		for _, p := range m.plugins {
+			v, err := p.module.inspect(r.Context(), req)
+			if err != nil {
+				// Ошибка или превышение лимитов модуля не блокирует трафик
+				log.Printf("[WAF] wasm %s: %v", p.name, err)
+				continue
+			}
+			if v.score > 0 {
+				score := addRiskScore(st, v.score)
+				if m.banRisk > 0 && score >= m.banRisk && v.banSeconds == 0 {
+					v.banSeconds = int(m.banDuration.Seconds())
+				}
+			}
+			if v.block || v.banSeconds > 0 {
+				ev := requestEvent(r, ip, "wasm", SeverityWarning, "block", fmt.Sprintf("WASM модуль %s заблокировал запрос от %s: %s %s", p.name, ip, r.Method, r.URL.Path))
+				ev.Fields = map[string]interface{}{"rule": p.name}
+				if m.waf.decide(r, ev, m.logDetections, func() bool {
+					if v.banSeconds > 0 {
+						m.waf.bans.Ban(ip, time.Duration(v.banSeconds)*time.Second)
+					}
+					return forbid(w)()
+				}) {
+					return
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// describe сериализует запрос для модулей
+func (m *WASMMiddleware) describe(r *http.Request, ip string) ([]byte, error) {
+	req := wasmRequest{
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Query:   r.URL.RawQuery,
+		Headers: make(map[string]string, len(r.Header)),
+		IP:      ip,
+	}
+	for k, v := range r.Header {
+		req.Headers[strings.ToLower(k)] = strings.Join(v, ", ")
+	}
+	if m.maxBody > 0 && r.Body != nil && r.Body != http.NoBody {
+		body, err := peekBody(r, m.maxBody)
+		if err != nil {
+			return nil, err
+		}
+		req.Body = string(body)
+	}
+	return json.Marshal(req)
+}
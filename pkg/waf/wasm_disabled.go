@@ -0,0 +1,12 @@
+//go:build !wazero
+
+package waf
+
+import "errors"
+
+// wasmSupported WAF собран без поддержки модулей WebAssembly (нужен тег сборки wazero)
+const wasmSupported = false
+
+func loadWASMModule(string, wasmLimits) (wasmModule, error) {
+	return nil, errors.New("WebAssembly support is not compiled in (build with -tags wazero)")
+}
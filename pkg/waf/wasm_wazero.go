@@ -0,0 +1,98 @@
+//go:build wazero
+
+package waf
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// wasmSupported WAF собран с поддержкой модулей WebAssembly
+const wasmSupported = true
+
+// wazeroModule модуль, скомпилированный один раз; на каждый запрос создается отдельный
+// экземпляр, поэтому модули не хранят состояние между запросами и не влияют друг на друга
+type wazeroModule struct {
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	limits   wasmLimits
+}
+
+// loadWASMModule компилирует модуль и подключает к нему host API "waf".
+// WASI не предоставляется: у модуля нет доступа к файлам, сети и часам.
+func loadWASMModule(path string, limits wasmLimits) (wasmModule, error) {
+	code, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	rt := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(limits.memoryPages).
+		WithCloseOnContextDone(true))
+
+	_, err = rt.NewHostModuleBuilder("waf").
+		NewFunctionBuilder().WithFunc(func(ctx context.Context) uint32 {
+		return uint32(len(callFrom(ctx).request))
+	}).Export("request_len").
+		NewFunctionBuilder().WithFunc(func(ctx context.Context, m api.Module, ptr uint32) uint32 {
+		if !m.Memory().Write(ptr, callFrom(ctx).request) {
+			return 1
+		}
+		return 0
+	}).Export("read_request").
+		NewFunctionBuilder().WithFunc(func(ctx context.Context, score float64) {
+		if score > 0 {
+			callFrom(ctx).verdict.score += score
+		}
+	}).Export("add_score").
+		NewFunctionBuilder().WithFunc(func(ctx context.Context) {
+		callFrom(ctx).verdict.block = true
+	}).Export("block").
+		NewFunctionBuilder().WithFunc(func(ctx context.Context, seconds uint32) {
+		if v := &callFrom(ctx).verdict; int(seconds) > v.banSeconds {
+			v.banSeconds = int(seconds)
+		}
+	}).Export("request_ban").
+		Instantiate(ctx)
+	if err != nil {
+		rt.Close(ctx)
+		return nil, err
+	}
+
+	compiled, err := rt.CompileModule(ctx, code)
+	if err != nil {
+		rt.Close(ctx)
+		return nil, err
+	}
+	if _, ok := compiled.ExportedFunctions()["inspect"]; !ok {
+		rt.Close(ctx)
+		return nil, errors.New("module does not export inspect()")
+	}
+	return &wazeroModule{runtime: rt, compiled: compiled, limits: limits}, nil
+}
+
+func callFrom(ctx context.Context) *wasmCall {
+	return ctx.Value(wasmCallKey{}).(*wasmCall)
+}
+
+// inspect создает экземпляр модуля и вызывает inspect() с ограничением времени
+func (m *wazeroModule) inspect(ctx context.Context, request []byte) (wasmVerdict, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.limits.timeout)
+	defer cancel()
+	call := &wasmCall{request: request}
+	ctx = context.WithValue(ctx, wasmCallKey{}, call)
+
+	mod, err := m.runtime.InstantiateModule(ctx, m.compiled, wazero.NewModuleConfig().WithName(""))
+	if err != nil {
+		return wasmVerdict{}, err
+	}
+	defer mod.Close(ctx)
+	if _, err := mod.ExportedFunction("inspect").Call(ctx); err != nil {
+		return wasmVerdict{}, err
+	}
+	return call.verdict, nil
+}